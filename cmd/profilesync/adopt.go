@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// adoptVariables lists the path variables tried when inferring a mapping,
+// in order of preference when several point at the same directory
+func adoptVariables(platform string) []string {
+	if platform == "windows" {
+		return []string{"APPDATA", "LOCALAPPDATA", "XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_CACHE_HOME"}
+	}
+	return []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_CACHE_HOME", "APPDATA", "LOCALAPPDATA"}
+}
+
+// inferMapping turns an absolute path under home into a mapping that finds
+// the same config on other platforms, e.g. ~/.config/starship.toml becomes
+// ${XDG_CONFIG_HOME}/starship.toml, which is under AppData\Roaming on Windows.
+// Paths not under a known directory stay relative to the home directory
+func inferMapping(path, home, platform string) (string, error) {
+	best, bestRoot := "", ""
+	for _, name := range adoptVariables(platform) {
+		root, err := resolveMappingPath(home, "${"+name+"}", platform)
+		if err != nil {
+			return "", err
+		}
+		// The more specific directory wins, so LOCALAPPDATA beats HOME
+		if withinRoot(root, path) && path != root && len(root) > len(bestRoot) {
+			best, bestRoot = name, root
+		}
+	}
+
+	if best == "" {
+		if !withinRoot(home, path) || path == home {
+			return "", fmt.Errorf("%s is not in your home directory (%s)", path, home)
+		}
+		rel, err := filepath.Rel(home, path)
+		return filepath.ToSlash(rel), err
+	}
+	rel, err := filepath.Rel(bestRoot, path)
+	if err != nil {
+		return "", err
+	}
+	return "${" + best + "}/" + filepath.ToSlash(rel), nil
+}
+
+// managedBy returns the mapping that already covers path, if any
+func managedBy(mappings map[string]string, path, home, platform string) string {
+	for mapping := range mappings {
+		resolved, err := resolveMappingPath(home, catalogPath(mapping, platform), platform)
+		if err != nil {
+			continue
+		}
+		if resolved == path || (strings.HasSuffix(mapping, "/") && withinRoot(resolved, path)) {
+			return mapping
+		}
+	}
+	return ""
+}
+
+// copyAdopted copies a file or directory into the profile store. Unlike
+// importTree it keeps everything, symlinks included, because the original
+// may be replaced by a link afterwards
+func copyAdopted(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyPlainFile(path, target, info.Mode().Perm())
+		}
+		return fmt.Errorf("%s: cannot adopt special files", path)
+	})
+}
+
+// sameContent reports whether two files hold the same bytes
+func sameContent(a, b string) bool {
+	x, err := os.ReadFile(a)
+	if err != nil {
+		return false
+	}
+	y, err := os.ReadFile(b)
+	return err == nil && bytes.Equal(x, y)
+}
+
+// linkToStore replaces path with a symlink to its copy in the store. The
+// original is moved aside first and put back if the link cannot be made
+func linkToStore(path, stored string) error {
+	aside := path + ".profilesync-adopt"
+	if err := os.Rename(path, aside); err != nil {
+		return err
+	}
+	if err := os.Symlink(stored, path); err != nil {
+		if restore := os.Rename(aside, path); restore != nil {
+			return fmt.Errorf("%v (and restoring the original failed: %v; it is at %s)", err, restore, aside)
+		}
+		return err
+	}
+	return os.RemoveAll(aside)
+}
+
+// addConfigMapping records a mapping in a config file, under the named
+// profile if one is given, creating the file if needed. The file is edited
+// as a YAML tree so its comments and layout survive
+func addConfigMapping(path, profile, mapping string) error {
+	doc, err := readConfigTree(path)
+	if err != nil {
+		return err
+	}
+
+	target := doc.Content[0]
+	if profile != "" {
+		if target, err = yamlChild(target, "profiles"); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if target, err = yamlChild(target, profile); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if target, err = yamlChild(target, "mappings"); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: mapping}
+	replaced := false
+	for i := 0; i+1 < len(target.Content); i += 2 {
+		if target.Content[i].Value == mapping {
+			target.Content[i+1] = value
+			replaced = true
+		}
+	}
+	if !replaced {
+		target.Content = append(target.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: mapping}, value)
+	}
+	return writeConfigTree(path, doc)
+}
+
+// readConfigTree reads a config file as a YAML tree whose top level is a
+// mapping, empty when the file does not exist yet
+func readConfigTree(path string) (*yaml.Node, error) {
+	doc := &yaml.Node{Kind: yaml.DocumentNode}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := yaml.Unmarshal(data, doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: expected a mapping at the top level", path)
+	}
+	return doc, nil
+}
+
+// writeConfigTree writes a tree read by readConfigTree back to its file
+func writeConfigTree(path string, doc *yaml.Node) error {
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, out.Bytes(), 0644)
+}
+
+// yamlChild returns the mapping stored under key in a YAML mapping node,
+// adding an empty one when the key is missing or null
+func yamlChild(n *yaml.Node, key string) (*yaml.Node, error) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value != key {
+			continue
+		}
+		child := n.Content[i+1]
+		switch {
+		case child.Kind == yaml.MappingNode:
+			return child, nil
+		case child.Kind == yaml.ScalarNode && child.Tag == "!!null":
+			n.Content[i+1] = &yaml.Node{Kind: yaml.MappingNode}
+			return n.Content[i+1], nil
+		}
+		return nil, fmt.Errorf("%s is not a mapping", key)
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+	return child, nil
+}
+
+// configMappings returns the mappings a config file sets for a profile, or
+// the defaults when there is no config file yet
+func configMappings(configFile, profile string) (map[string]string, error) {
+	if _, err := os.Stat(configFile); err != nil {
+		return GetDefaultMappings(), nil
+	}
+	cfg, err := loadConfig(configFile, profile)
+	if err != nil && profile != "" {
+		// Adding to a profile the config does not define yet creates it
+		cfg, err = loadConfig(configFile, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Mappings, nil
+}
+
+// runAdopt handles `profilesync adopt PATH...`, bringing existing configs
+// under management
+func runAdopt(args []string) {
+	flags := newFlagSet("adopt", "adopt [flags] PATH...")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to copy the files into")
+	configFile := flags.String("config", configPath(), "Config file to record the mappings in")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Record the mappings in this named profile instead of for every profile")
+	link := flags.Bool("link", false, "Replace each original with a symlink to its copy in the profile store")
+	force := flags.Bool("force", false, "Replace copies already in the profile store that differ")
+	dryRun := flags.Bool("dry-run", false, "Show what would be adopted without changing anything")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	platform := DetectPlatform()
+	home := GetHomeDir(platform)
+	mappings, err := configMappings(*configFile, *profile)
+	if err != nil {
+		errorColor.Println("❌ Error loading config:", err)
+		os.Exit(1)
+	}
+	storeHome := filepath.Join(*dir, "home")
+
+	failed := 0
+	for _, arg := range flags.Args() {
+		if err := adopt(arg, home, platform, storeHome, *configFile, *profile, mappings, *link, *force, *dryRun); err != nil {
+			errorColor.Printf("❌ %s: %v\n", arg, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+	if !*dryRun {
+		noticeColor.Printf("Run `profilesync apply --source-dir %s` on another machine to migrate adopted files\n", storeHome)
+	}
+}
+
+// adopt brings one file or directory under management
+func adopt(arg, home, platform, storeHome, configFile, profile string, mappings map[string]string, link, force, dryRun bool) error {
+	path, err := filepath.Abs(arg)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if target, err := filepath.EvalSymlinks(path); err == nil && withinRoot(storeHome, target) {
+			noticeColor.Printf("⏭️  %s is already adopted (it links to %s)\n", arg, target)
+			return nil
+		}
+		return errors.New("is a symlink; adopt the file it points to instead")
+	}
+
+	mapping, err := inferMapping(path, home, platform)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		mapping += "/"
+	}
+	// The store is laid out like a home directory, as `import` does, so
+	// `apply --source-dir` finds adopted files through their mappings
+	stored, err := resolveMappingPath(storeHome, mapping, platform)
+	if err != nil {
+		return err
+	}
+	existing := managedBy(mappings, path, home, platform)
+
+	if dryRun {
+		fmt.Printf("Would copy %s to %s\n", path, stored)
+		if existing == "" {
+			fmt.Printf("Would add mapping %s to %s\n", mapping, configFile)
+		}
+		if link {
+			fmt.Printf("Would replace %s with a symlink to %s\n", path, stored)
+		}
+		return nil
+	}
+
+	if _, err := os.Lstat(stored); err == nil {
+		same := !info.IsDir() && sameContent(path, stored)
+		if !same && !force {
+			return fmt.Errorf("%s is already in the profile store and differs (use --force to replace it)", stored)
+		}
+		if !same {
+			if err := os.RemoveAll(stored); err != nil {
+				return err
+			}
+		}
+	}
+	if err := copyAdopted(path, stored); err != nil {
+		return fmt.Errorf("copying into the profile store: %w", err)
+	}
+	successColor.Printf("✅ Copied %s to %s\n", arg, stored)
+
+	if existing != "" {
+		noticeColor.Printf("ℹ️  Already covered by mapping %s; the config is unchanged\n", existing)
+	} else {
+		if err := addConfigMapping(configFile, profile, mapping); err != nil {
+			return fmt.Errorf("recording the mapping: %w", err)
+		}
+		where := configFile
+		if profile != "" {
+			where += " (profile " + profile + ")"
+		}
+		successColor.Printf("✅ Added mapping %s to %s\n", mapping, where)
+		mappings[mapping] = mapping
+	}
+
+	if link {
+		if err := linkToStore(path, stored); err != nil {
+			return fmt.Errorf("linking to the profile store: %w", err)
+		}
+		successColor.Printf("🔗 %s now links to %s\n", arg, stored)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ansiblePlay is the playbook's one play
+type ansiblePlay struct {
+	Name  string            `yaml:"name"`
+	Hosts string            `yaml:"hosts"`
+	Vars  map[string]string `yaml:"vars"`
+	Tasks []ansibleTask     `yaml:"tasks"`
+}
+
+// ansibleTask is one task, using the file, copy or template module over a
+// loop of entries
+type ansibleTask struct {
+	Name        string            `yaml:"name"`
+	File        map[string]string `yaml:"ansible.builtin.file,omitempty"`
+	Copy        map[string]string `yaml:"ansible.builtin.copy,omitempty"`
+	Template    map[string]string `yaml:"ansible.builtin.template,omitempty"`
+	Diff        *bool             `yaml:"diff,omitempty"`
+	Loop        []planEntry       `yaml:"loop"`
+	LoopControl map[string]string `yaml:"loop_control"`
+}
+
+// exportAnsible writes the plan as dir/playbook.yml, with the files its
+// copy and template tasks deploy next to it
+func (ps *ProfileSync) exportAnsible(ctx context.Context, sourceHome, dir string) error {
+	var tasks []ansibleTask
+	files := 0
+	out := exportTarget{dir: dir, home: "{{ profilesync_home }}", templated: true, redaction: newRedactionPolicy(destExport, ps.redaction)}
+	err := ps.exportedItems(ctx, sourceHome, out, nil, func(item MigrationItem, rel string, collected exportedItem) error {
+		files += len(collected.files) + len(collected.rewritten)
+		// copy does not create the directory a single file goes in
+		if parent := path.Dir(rel); len(collected.dirs) == 0 && parent != "." {
+			collected.dirs = []planEntry{{Path: parent}}
+		}
+
+		// Only files that may be replaced honour profilesync_force;
+		// the rest are only written where nothing is there yet
+		force := "{{ profilesync_force }}"
+		if catalogMerge(item.Mapping) != mergeReplace {
+			force = "false"
+		}
+		var diff *bool
+		if item.Sensitivity == sensitivitySecret {
+			diff = new(bool)
+		}
+		label := map[string]string{"label": "{{ item.path }}"}
+		if len(collected.dirs) > 0 {
+			tasks = append(tasks, ansibleTask{
+				Name:        item.Description + " (directories)",
+				File:        map[string]string{"path": "{{ profilesync_home }}/{{ item.path }}", "state": "directory", "mode": "{{ item.mode | default(omit) }}"},
+				Loop:        collected.dirs,
+				LoopControl: label,
+			})
+		}
+		if len(collected.files) > 0 {
+			tasks = append(tasks, ansibleTask{
+				Name:        item.Description,
+				Copy:        map[string]string{"src": "files/{{ item.path }}", "dest": "{{ profilesync_home }}/{{ item.path }}", "mode": "{{ item.mode }}", "force": force},
+				Diff:        diff,
+				Loop:        collected.files,
+				LoopControl: label,
+			})
+		}
+		if len(collected.rewritten) > 0 {
+			tasks = append(tasks, ansibleTask{
+				Name:        item.Description + " (with home directory paths)",
+				Template:    map[string]string{"src": "templates/{{ item.path }}.j2", "dest": "{{ profilesync_home }}/{{ item.path }}", "mode": "{{ item.mode }}", "force": force},
+				Diff:        diff,
+				Loop:        collected.rewritten,
+				LoopControl: label,
+			})
+		}
+		if len(collected.links) > 0 {
+			tasks = append(tasks, ansibleTask{
+				Name:        item.Description + " (symlinks)",
+				File:        map[string]string{"path": "{{ profilesync_home }}/{{ item.path }}", "src": "{{ item.target }}", "state": "link", "force": force},
+				Loop:        collected.links,
+				LoopControl: label,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	playbook := []ansiblePlay{{
+		Name:  "Deploy profilesync profile",
+		Hosts: "all",
+		Vars: map[string]string{
+			"profilesync_home":  "{{ ansible_env.HOME }}",
+			"profilesync_force": "false",
+		},
+		Tasks: tasks,
+	}}
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by `profilesync export --format ansible` from " + sourceHome + "\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(playbook); err != nil {
+		return err
+	}
+	playbookPath := filepath.Join(dir, "playbook.yml")
+	if err := writeExported(playbookPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	out.redaction.summary()
+	successColor.Printf("✅ Wrote %d tasks deploying %d files to %s\n", len(tasks), files, playbookPath)
+	noticeColor.Printf("Run `ansible-playbook -i INVENTORY %s` to apply it\n", playbookPath)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// appDataRule classifies part of a Windows profile's AppData tree. Pattern
+// is slash-separated and relative to AppData; "*" matches one segment
+type appDataRule struct {
+	Pattern  string
+	Portable bool
+	Reason   string
+}
+
+// appDataRules are checked in order and the first match wins. Roaming is
+// designed to follow the user between machines; Local and LocalLow are not,
+// and UWP packages split their data the same way
+var appDataRules = []appDataRule{
+	{"Roaming/Microsoft/Protect", false, "DPAPI master keys bound to the source account"},
+	{"Roaming/Microsoft/Crypto", false, "machine-bound key store"},
+	{"Roaming/Microsoft/Credentials", false, "DPAPI-encrypted credentials"},
+	{"Roaming/Microsoft/SystemCertificates", false, "certificate store (export with certmgr instead)"},
+	{"Roaming/Microsoft/Windows/Recent", false, "shortcuts to files on the source machine"},
+	{"Roaming", true, "roaming application data"},
+
+	{"LocalLow", false, "low-integrity sandbox data, mostly caches"},
+
+	{"Local/Packages/*/RoamingState", true, "packaged app roaming state"},
+	{"Local/Packages/*/Settings", true, "packaged app settings"},
+	{"Local/Packages/*/LocalState", true, "packaged app data"},
+	{"Local/Packages/*/*", false, "packaged app caches and system data"},
+
+	{"Local/Temp", false, "temporary files"},
+	{"Local/CrashDumps", false, "crash dumps"},
+	{"Local/D3DSCache", false, "GPU shader cache"},
+	{"Local/Microsoft/Windows", false, "Explorer caches and machine state"},
+	{"Local/Microsoft/Credentials", false, "DPAPI-encrypted credentials"},
+	{"Local/Microsoft/Vault", false, "DPAPI-encrypted credentials"},
+	{"Local", true, "local application data"},
+}
+
+// classifyAppData reports whether a path in a Windows profile is worth
+// migrating. Paths that do not pass through an AppData directory are always
+// portable
+func classifyAppData(path string) (portable bool, reason string) {
+	segments := strings.Split(filepath.ToSlash(path), "/")
+
+	for i, segment := range segments {
+		if !strings.EqualFold(segment, "AppData") {
+			continue
+		}
+		rest := segments[i+1:]
+		for _, rule := range appDataRules {
+			if matchSegments(strings.Split(rule.Pattern, "/"), rest) {
+				return rule.Portable, rule.Reason
+			}
+		}
+		return true, ""
+	}
+
+	return true, ""
+}
+
+// matchSegments reports whether path starts with pattern, ignoring case as
+// Windows does
+func matchSegments(pattern, path []string) bool {
+	if len(path) < len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p != "*" && !strings.EqualFold(p, path[i]) {
+			return false
+		}
+	}
+	return true
+}
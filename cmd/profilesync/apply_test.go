@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"profilesync/profilesynctest"
+)
+
+// applyTestMappings are the mappings apply tests migrate
+var applyTestMappings = map[string]string{
+	"${XDG_CONFIG_HOME}/nvim/": "${XDG_CONFIG_HOME}/nvim/",
+	".tmux.conf":               ".tmux.conf",
+	".config/missing.toml":     ".config/missing.toml",
+}
+
+// applyTestHome is the source home apply tests migrate from
+func applyTestHome() *profilesynctest.FS {
+	return profilesynctest.NewFS().
+		File(".config/nvim/init.lua", "vim.o.number = true\n").
+		File(".config/nvim/lua/plugins.lua", "return {}\n").
+		File(".config/nvim/.profilesyncignore", "*.log\n").
+		File(".config/nvim/debug.log", "left out\n").
+		FileMode(".tmux.conf", "set -g mouse on\n", 0600).
+		File(".bashrc", "not mapped\n")
+}
+
+// newApplyTest returns a ProfileSync for a live or dry run between linux
+// homes, with its state kept in a temporary directory
+func newApplyTest(t *testing.T, dryRun bool) *ProfileSync {
+	t.Helper()
+	t.Setenv("PROFILESYNC_STATE_DIR", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	for _, name := range []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_CACHE_HOME"} {
+		t.Setenv(name, "")
+	}
+	ps := NewProfileSync("linux", "linux", dryRun, false, false, false, false, 2)
+	ps.mappings = applyTestMappings
+	ps.noInput = true
+	return ps
+}
+
+// planListing renders a plan with paths relative to the homes, for golden files
+func planListing(ps *ProfileSync, source, dest string) string {
+	var b strings.Builder
+	for _, item := range ps.migrationPlan.Items {
+		from, _ := filepath.Rel(source, item.SourcePath)
+		to, _ := filepath.Rel(dest, item.DestinationPath)
+		fmt.Fprintf(&b, "%s: %s -> %s", item.Mapping, filepath.ToSlash(from), filepath.ToSlash(to))
+		if item.SkipReason != "" {
+			fmt.Fprintf(&b, " (%s)", item.SkipReason)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestApplyPlan(t *testing.T) {
+	ps := newApplyTest(t, true)
+	source, dest := applyTestHome().Materialize(t), t.TempDir()
+	if err := ps.CreateMigrationPlan(context.Background(), source, dest); err != nil {
+		t.Fatal(err)
+	}
+	profilesynctest.GoldenString(t, "apply-plan", planListing(ps, source, dest))
+
+	// A dry run writes nothing
+	if err := ps.ExecuteMigration(context.Background(), source, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got := profilesynctest.Snapshot(t, dest); got != "\n" {
+		t.Errorf("dry run wrote\n%s", got)
+	}
+}
+
+func TestApplyHome(t *testing.T) {
+	ps := newApplyTest(t, false)
+	source := applyTestHome().Materialize(t)
+	// Files apply has no mapping for are left alone
+	dest := profilesynctest.NewFS().File(".bashrc", "the destination's own\n").Materialize(t)
+	if err := ps.CreateMigrationPlan(context.Background(), source, dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.ExecuteMigration(context.Background(), source, dest); err != nil {
+		t.Fatal(err)
+	}
+	profilesynctest.GoldenString(t, "apply-home", profilesynctest.Snapshot(t, dest))
+}
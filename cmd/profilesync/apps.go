@@ -0,0 +1,321 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// appSpec describes how to tell whether an application is installed and how to install it
+type appSpec struct {
+	Name     string
+	Binaries []string
+	MacApps  []string
+	Dirs     map[string][]string
+	Registry []string
+	Install  map[string]string
+}
+
+// knownApps is keyed by the first segment of a mapping path
+var knownApps = map[string]appSpec{
+	"vscode": {
+		Name:     "Visual Studio Code",
+		Binaries: []string{"code", "code-insiders", "codium"},
+		MacApps:  []string{"Visual Studio Code.app", "VSCodium.app"},
+		Dirs:     map[string][]string{"windows": {"${LOCALAPPDATA}/Programs/Microsoft VS Code"}},
+		Registry: []string{"Microsoft Visual Studio Code", "VSCodium"},
+		Install: map[string]string{
+			"linux":   "snap install code --classic",
+			"macos":   "brew install --cask visual-studio-code",
+			"windows": "winget install Microsoft.VisualStudioCode",
+		},
+	},
+	"intellij": {
+		Name:     "IntelliJ IDEA",
+		Binaries: []string{"idea", "idea.sh", "intellij-idea-community", "intellij-idea-ultimate"},
+		MacApps:  []string{"IntelliJ IDEA.app", "IntelliJ IDEA CE.app"},
+		Dirs:     map[string][]string{"linux": {"/opt/idea", "${XDG_DATA_HOME}/JetBrains/Toolbox"}},
+		Registry: []string{"IntelliJ IDEA"},
+		Install: map[string]string{
+			"linux":   "snap install intellij-idea-community --classic",
+			"macos":   "brew install --cask intellij-idea-ce",
+			"windows": "winget install JetBrains.IntelliJIDEA.Community",
+		},
+	},
+	"vim": {
+		Name:     "Vim",
+		Binaries: []string{"vim", "gvim"},
+		MacApps:  []string{"MacVim.app"},
+		Registry: []string{"Vim "},
+		Install: map[string]string{
+			"linux":   "apt install vim",
+			"macos":   "brew install vim",
+			"windows": "winget install vim.vim",
+		},
+	},
+	"nvim": {
+		Name:     "Neovim",
+		Binaries: []string{"nvim"},
+		Registry: []string{"Neovim"},
+		Install: map[string]string{
+			"linux":   "apt install neovim",
+			"macos":   "brew install neovim",
+			"windows": "winget install Neovim.Neovim",
+		},
+	},
+	"emacs": {
+		Name:     "Emacs",
+		Binaries: []string{"emacs"},
+		MacApps:  []string{"Emacs.app"},
+		Registry: []string{"GNU Emacs"},
+		Install: map[string]string{
+			"linux":   "apt install emacs",
+			"macos":   "brew install --cask emacs",
+			"windows": "winget install GNU.Emacs",
+		},
+	},
+	"zsh": {
+		Name:     "Zsh",
+		Binaries: []string{"zsh"},
+		Install:  map[string]string{"linux": "apt install zsh"},
+	},
+	"fish": {
+		Name:     "Fish",
+		Binaries: []string{"fish"},
+		Install:  map[string]string{"linux": "apt install fish", "macos": "brew install fish"},
+	},
+	"tmux": {
+		Name:     "Tmux",
+		Binaries: []string{"tmux"},
+		Install:  map[string]string{"linux": "apt install tmux", "macos": "brew install tmux"},
+	},
+	"git": {
+		Name:     "Git",
+		Binaries: []string{"git"},
+		Registry: []string{"Git"},
+		Install: map[string]string{
+			"linux":   "apt install git",
+			"macos":   "xcode-select --install",
+			"windows": "winget install Git.Git",
+		},
+	},
+	"chrome": {
+		Name:     "Google Chrome",
+		Binaries: []string{"google-chrome", "google-chrome-stable"},
+		MacApps:  []string{"Google Chrome.app"},
+		Dirs:     map[string][]string{"windows": {"${LOCALAPPDATA}/Google/Chrome/Application"}},
+		Registry: []string{"Google Chrome"},
+		Install: map[string]string{
+			"macos":   "brew install --cask google-chrome",
+			"windows": "winget install Google.Chrome",
+		},
+	},
+	"chromium": {
+		Name:     "Chromium",
+		Binaries: []string{"chromium", "chromium-browser"},
+		MacApps:  []string{"Chromium.app"},
+		Dirs:     map[string][]string{"windows": {"${LOCALAPPDATA}/Chromium/Application"}},
+		Install: map[string]string{
+			"linux": "apt install chromium",
+			"macos": "brew install --cask chromium",
+		},
+	},
+	"edge": {
+		Name:     "Microsoft Edge",
+		Binaries: []string{"microsoft-edge", "microsoft-edge-stable"},
+		MacApps:  []string{"Microsoft Edge.app"},
+		Dirs:     map[string][]string{"windows": {"C:/Program Files (x86)/Microsoft/Edge/Application"}},
+		Registry: []string{"Microsoft Edge"},
+		Install: map[string]string{
+			"macos":   "brew install --cask microsoft-edge",
+			"windows": "winget install Microsoft.Edge",
+		},
+	},
+	"brave": {
+		Name:     "Brave",
+		Binaries: []string{"brave-browser", "brave"},
+		MacApps:  []string{"Brave Browser.app"},
+		Dirs:     map[string][]string{"windows": {"${LOCALAPPDATA}/BraveSoftware/Brave-Browser/Application"}},
+		Registry: []string{"Brave"},
+		Install: map[string]string{
+			"macos":   "brew install --cask brave-browser",
+			"windows": "winget install Brave.Brave",
+		},
+	},
+	"firefox": {
+		Name:     "Firefox",
+		Binaries: []string{"firefox"},
+		MacApps:  []string{"Firefox.app"},
+		Registry: []string{"Mozilla Firefox"},
+		Install: map[string]string{
+			"linux":   "apt install firefox",
+			"macos":   "brew install --cask firefox",
+			"windows": "winget install Mozilla.Firefox",
+		},
+	},
+	"npm": {
+		Name:     "Node.js",
+		Binaries: []string{"npm"},
+		Registry: []string{"Node.js"},
+		Install: map[string]string{
+			"linux":   "apt install nodejs npm",
+			"macos":   "brew install node",
+			"windows": "winget install OpenJS.NodeJS",
+		},
+	},
+	"yarn": {
+		Name:     "Yarn",
+		Binaries: []string{"yarn"},
+		Install:  map[string]string{"linux": "npm install -g yarn", "macos": "npm install -g yarn", "windows": "npm install -g yarn"},
+	},
+	"pip": {
+		Name:     "Python pip",
+		Binaries: []string{"pip", "pip3"},
+		Registry: []string{"Python "},
+		Install: map[string]string{
+			"linux":   "apt install python3-pip",
+			"macos":   "brew install python",
+			"windows": "winget install Python.Python.3.12",
+		},
+	},
+	"docker": {
+		Name:     "Docker",
+		Binaries: []string{"docker", "podman"},
+		MacApps:  []string{"Docker.app"},
+		Registry: []string{"Docker Desktop"},
+		Install: map[string]string{
+			"linux":   "apt install docker.io",
+			"macos":   "brew install --cask docker",
+			"windows": "winget install Docker.DockerDesktop",
+		},
+	},
+	"kubectl": {
+		Name:     "kubectl",
+		Binaries: []string{"kubectl"},
+		Install: map[string]string{
+			"linux":   "snap install kubectl --classic",
+			"macos":   "brew install kubectl",
+			"windows": "winget install Kubernetes.kubectl",
+		},
+	},
+	"helm": {
+		Name:     "Helm",
+		Binaries: []string{"helm"},
+		Install: map[string]string{
+			"linux":   "snap install helm --classic",
+			"macos":   "brew install helm",
+			"windows": "winget install Helm.Helm",
+		},
+	},
+	"terraform": {
+		Name:     "Terraform",
+		Binaries: []string{"terraform", "tofu"},
+		Install: map[string]string{
+			"linux":   "snap install terraform --classic",
+			"macos":   "brew install terraform",
+			"windows": "winget install Hashicorp.Terraform",
+		},
+	},
+	"aws": {
+		Name:     "AWS CLI",
+		Binaries: []string{"aws"},
+		Registry: []string{"AWS Command Line Interface"},
+		Install: map[string]string{
+			"linux":   "snap install aws-cli --classic",
+			"macos":   "brew install awscli",
+			"windows": "winget install Amazon.AWSCLI",
+		},
+	},
+}
+
+// appForMapping returns the knownApps key a mapping belongs to, if any
+func appForMapping(mapping string) string {
+	first := mappingTool(mapping)
+	if _, ok := knownApps[first]; ok {
+		return first
+	}
+	return ""
+}
+
+// appInstalled reports whether an application is present on this machine,
+// checking PATH, /Applications on macOS, known install directories and the
+// Windows uninstall registry
+func appInstalled(spec appSpec, platform, home string) bool {
+	for _, bin := range spec.Binaries {
+		if _, err := exec.LookPath(bin); err == nil {
+			return true
+		}
+	}
+
+	if platform == "macos" {
+		for _, app := range spec.MacApps {
+			for _, dir := range []string{"/Applications", filepath.Join(home, "Applications")} {
+				if _, err := os.Stat(filepath.Join(dir, app)); err == nil {
+					return true
+				}
+			}
+		}
+	}
+
+	for _, dir := range spec.Dirs[platform] {
+		path := dir
+		if strings.HasPrefix(dir, "$") {
+			resolved, err := resolveMappingPath(home, dir, platform)
+			if err != nil {
+				continue
+			}
+			path = resolved
+		}
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+
+	if platform == "windows" && len(spec.Registry) > 0 {
+		return registryHasApp(spec.Registry)
+	}
+
+	return false
+}
+
+// MarkMissingApps flags plan items whose application is not installed on the
+// destination. Detection only inspects this machine, so it is skipped when the
+// destination platform is a different OS. With skip set, flagged items are
+// left out of the migration
+func (ps *ProfileSync) MarkMissingApps(destBase string, skip bool) {
+	if ps.destPlatform != DetectPlatform() {
+		if ps.verbose {
+			warnColor.Printf("⚠️  Cannot detect applications for %s from %s; not filtering the plan\n", ps.destPlatform, DetectPlatform())
+		}
+		return
+	}
+
+	installed := map[string]bool{}
+	for i := range ps.migrationPlan.Items {
+		item := &ps.migrationPlan.Items[i]
+		if item.App == "" {
+			continue
+		}
+
+		found, checked := installed[item.App]
+		if !checked {
+			found = appInstalled(knownApps[item.App], ps.destPlatform, destBase)
+			installed[item.App] = found
+		}
+		if found {
+			continue
+		}
+
+		item.AppMissing = true
+		if skip {
+			item.AutoMigrate = false
+			item.SkipReason = knownApps[item.App].Name + " not installed"
+		}
+	}
+}
+
+// installHint returns the command that installs an item's application on the destination
+func (ps *ProfileSync) installHint(item MigrationItem) string {
+	return knownApps[item.App].Install[ps.destPlatform]
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// registryHasApp only has a registry to consult on Windows
+func registryHasApp(prefixes []string) bool {
+	return false
+}
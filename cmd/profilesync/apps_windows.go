@@ -0,0 +1,52 @@
+//go:build windows
+
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// uninstallKeys are where installers register themselves
+var uninstallKeys = []struct {
+	root registry.Key
+	path string
+}{
+	{registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall`},
+	{registry.LOCAL_MACHINE, `SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall`},
+	{registry.CURRENT_USER, `SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall`},
+}
+
+// registryHasApp reports whether any installed program's DisplayName starts with one of prefixes
+func registryHasApp(prefixes []string) bool {
+	for _, uk := range uninstallKeys {
+		key, err := registry.OpenKey(uk.root, uk.path, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+		names, err := key.ReadSubKeyNames(-1)
+		key.Close()
+		if err != nil {
+			continue
+		}
+
+		for _, name := range names {
+			sub, err := registry.OpenKey(uk.root, uk.path+`\`+name, registry.QUERY_VALUE)
+			if err != nil {
+				continue
+			}
+			display, _, err := sub.GetStringValue("DisplayName")
+			sub.Close()
+			if err != nil {
+				continue
+			}
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(display, prefix) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
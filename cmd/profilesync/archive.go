@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// archiveManifestName is the first entry of an exported archive, which
+// marks it as one
+const archiveManifestName = "profilesync-archive.json"
+
+// signatureSuffix names the signature written next to an archive
+const signatureSuffix = ".sig"
+
+// archiveManifest describes where an exported archive came from
+type archiveManifest struct {
+	Machine  string    `json:"machine"`
+	Platform string    `json:"platform"`
+	User     string    `json:"user"`
+	Created  time.Time `json:"created"`
+	Files    int       `json:"files"`
+}
+
+// archiveSignature is the detached signature of an archive: the archive's
+// SHA-256, signed with the key of the machine that exported it
+type archiveSignature struct {
+	Key       string    `yaml:"key"`
+	Signer    string    `yaml:"signer"`
+	Signed    time.Time `yaml:"signed"`
+	SHA256    string    `yaml:"sha256"`
+	Signature string    `yaml:"signature"`
+}
+
+// signedMessage is what an archive's signature covers
+func signedMessage(sum string) []byte {
+	return []byte("profilesync-archive-v1\n" + sum + "\n")
+}
+
+// writeProfileArchive writes the files of the profile store's home as a
+// gzipped tar: a manifest, then the files under home/. It returns how many
+// files it wrote
+func writeProfileArchive(w io.Writer, storeHome string, filter storeFilter) (int, error) {
+	// data, when set, replaces the file's content
+	type entry struct {
+		name, path string
+		data       []byte
+	}
+	var entries []entry
+	err := filepath.WalkDir(storeHome, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || isTempFile(path) || d.Name() == ignoreFileName {
+			return nil
+		}
+		// Follow links, such as those adopt leaves in place of files
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, keep := filter(path, data)
+		if !keep {
+			return nil
+		}
+		rel, err := filepath.Rel(storeHome, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{name: "home/" + filepath.ToSlash(rel), path: path, data: out})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest, err := json.MarshalIndent(archiveManifest{
+		Machine:  machineName(),
+		Platform: DetectPlatform(),
+		User:     currentUserName(),
+		Created:  time.Now().UTC(),
+		Files:    len(entries),
+	}, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: archiveManifestName, Mode: 0600, Size: int64(len(manifest)), ModTime: time.Now()}); err != nil {
+		return 0, err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		if err := addTarFile(tw, e.name, e.path, e.data); err != nil {
+			return 0, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return len(entries), gz.Close()
+}
+
+// currentUserName is the name of the user running profilesync, which an
+// imported archive's home is filed under
+func currentUserName() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	// Windows names users DOMAIN\user
+	if i := strings.LastIndex(name, `\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if name == "" {
+		return "user"
+	}
+	return name
+}
+
+// signArchive writes the signature of the archive at path next to it,
+// returning the public key it was signed with
+func signArchive(path string, sum []byte) (ed25519.PublicKey, error) {
+	key, err := signingKey(true)
+	if err != nil {
+		return nil, err
+	}
+	digest := hex.EncodeToString(sum)
+	sig := archiveSignature{
+		Key:       formatPublicKey(key.Public().(ed25519.PublicKey)),
+		Signer:    currentUserName() + "@" + machineName(),
+		Signed:    time.Now().UTC(),
+		SHA256:    digest,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, signedMessage(digest))),
+	}
+	data, err := yaml.Marshal(sig)
+	if err != nil {
+		return nil, err
+	}
+	return key.Public().(ed25519.PublicKey), writeFileAtomic(path+signatureSuffix, data, 0644)
+}
+
+// checkArchiveSignature verifies the signature next to an archive. It
+// returns who signed it, or why the archive is not trusted: it is an
+// exported archive without a signature, or its key is not trusted. exported
+// tells whether the unpacked archive holds a profilesync manifest; other
+// backups need no signature. err is set when the signature does not match
+// the archive, which no flag overrides
+func checkArchiveSignature(path string, exported bool) (signer, untrusted string, err error) {
+	data, err := os.ReadFile(path + signatureSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		if exported {
+			return "", "it is not signed", nil
+		}
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	var sig archiveSignature
+	if err := yaml.Unmarshal(data, &sig); err != nil {
+		return "", "", fmt.Errorf("%s%s: %w", path, signatureSuffix, err)
+	}
+	pub, err := parsePublicKey(sig.Key)
+	if err != nil {
+		return "", "", fmt.Errorf("%s%s: %w", path, signatureSuffix, err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(pub, signedMessage(sig.SHA256), signature) {
+		return "", "", errors.New("its signature is invalid")
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	if sum != sig.SHA256 {
+		return "", "", errors.New("it was changed after it was signed")
+	}
+
+	name, trusted, err := trustedName(pub)
+	if err != nil {
+		return "", "", err
+	}
+	if !trusted {
+		return "", fmt.Sprintf("it is signed by %s with a key that is not trusted (%s)", sig.Signer, keyFingerprint(pub)), nil
+	}
+	return fmt.Sprintf("%s (%s)", name, sig.Signer), "", nil
+}
+
+// exportPolicy returns the mappings of the config, or the defaults when
+// there is no config, and a redaction policy for exports with its rules
+func exportPolicy(configFile, profile string) (map[string]string, *redactionPolicy, error) {
+	if _, err := os.Stat(configFile); err != nil {
+		return GetDefaultMappings(), newRedactionPolicy(destExport, nil), nil
+	}
+	cfg, err := loadConfig(configFile, profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg.Mappings, newRedactionPolicy(destExport, cfg.Redaction), nil
+}
+
+// runExportArchive handles `profilesync export archive FILE`, writing the
+// profile store as a signed archive to share or keep on a shared drive
+func runExportArchive(args []string) {
+	flags := newFlagSet("export archive", "export archive [flags] FILE")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to export")
+	configFile := flags.String("config", configPath(), "Config file with the mappings and redaction rules")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config")
+	force := flags.Bool("force", false, "Replace FILE if it exists")
+	unsigned := flags.Bool("unsigned", false, "Do not sign the archive")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	file := flags.Arg(0)
+	if lower := strings.ToLower(file); !strings.HasSuffix(lower, ".tar.gz") && !strings.HasSuffix(lower, ".tgz") {
+		errorColor.Println("❌ FILE must end in .tar.gz or .tgz")
+		os.Exit(2)
+	}
+	storeHome := filepath.Join(*dir, "home")
+	if !isDir(storeHome) {
+		errorColor.Printf("❌ The profile store has nothing to export (%s is missing)\n", storeHome)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(file); err == nil && !*force {
+		errorColor.Printf("❌ %s exists (use --force to replace it)\n", file)
+		os.Exit(1)
+	}
+	mappings, policy, err := exportPolicy(*configFile, *profile)
+	if err != nil {
+		errorColor.Println("❌ Error loading config:", err)
+		os.Exit(1)
+	}
+
+	tmp := tempPath(file)
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		errorColor.Println("❌ Error writing archive:", err)
+		os.Exit(1)
+	}
+	hash := sha256.New()
+	n, err := writeProfileArchive(io.MultiWriter(out, hash), storeHome, policy.forStore(mappings, storeHome))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		errorColor.Println("❌ Error writing archive:", err)
+		os.Exit(1)
+	}
+	policy.summary()
+	successColor.Printf("✅ Exported %d files from %s to %s\n", n, storeHome, file)
+
+	if *unsigned {
+		os.Remove(file + signatureSuffix)
+		warnColor.Println("⚠️  The archive is not signed; importing it needs --allow-untrusted")
+		return
+	}
+	pub, err := signArchive(file, hash.Sum(nil))
+	if err != nil {
+		errorColor.Println("❌ Error signing archive:", err)
+		os.Exit(1)
+	}
+	successColor.Printf("🔏 Signed it with %s in %s%s\n", keyFingerprint(pub), file, signatureSuffix)
+	noticeColor.Println("Share both files; others trust your key with the line `profilesync trust key` prints")
+}
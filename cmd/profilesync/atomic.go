@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempSuffix marks a file being written next to the one it replaces. Until
+// it is renamed into place, the original stays whole, so a crash never
+// leaves a truncated config behind
+const tempSuffix = ".profilesync-tmp"
+
+// tempPath is where dst is written before being renamed into place
+func tempPath(dst string) string {
+	return dst + tempSuffix
+}
+
+// isTempFile reports whether path is a temp file left by an aborted write
+func isTempFile(path string) bool {
+	return strings.HasSuffix(path, tempSuffix)
+}
+
+// writeTarget returns the file a write to path lands in: the target when
+// path is a symlink, as renaming over the link would replace it
+func writeTarget(path string) string {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if target, err := filepath.EvalSymlinks(path); err == nil {
+			return target
+		}
+	}
+	return path
+}
+
+// writeFileAtomic replaces path with data like os.WriteFile, keeping an
+// existing file's permissions, but writes a temp file in the same
+// directory, flushes it to disk and renames it over path
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	path = writeTarget(path)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp := tempPath(path)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// syncDir flushes a directory so a rename in it survives a crash. Not every
+// platform can open a directory for this, so failures are ignored
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// removeTempFiles deletes the temp files of the given destinations left by
+// an aborted run, returning how many there were
+func removeTempFiles(dsts []string) int {
+	removed := 0
+	for _, dst := range dsts {
+		if err := os.Remove(tempPath(dst)); err == nil {
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditLogName is the audit log in the state directory. It only holds
+// paths and hashes, and stays in plain text so it is only ever appended to
+const auditLogName = "audit.jsonl"
+
+// What happened to a file, as the audit log records it
+const (
+	auditCreated  = "created"
+	auditModified = "modified"
+	auditDeleted  = "deleted"
+)
+
+// auditLogPath is where the audit log is kept
+func auditLogPath() string {
+	return filepath.Join(stateDir(), auditLogName)
+}
+
+// runID identifies this run in the audit log and the structured log
+var runID = sync.OnceValue(func() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+})
+
+// AuditRecord is one line of the audit log: a file a run changed
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Run     string    `json:"run"`
+	Command string    `json:"command"`
+	User    string    `json:"user"`
+	Machine string    `json:"machine"`
+	// For is the user whose profile was migrated with --user or --all-users
+	For     string `json:"for,omitempty"`
+	Action  string `json:"action"`
+	Path    string `json:"path"`
+	Mapping string `json:"mapping,omitempty"`
+	// Before and After are the SHA-256 of the file; empty when it did not
+	// exist
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// Mode is the file's permissions before the run, and Dir the highest
+	// directory the run created for it, which undo removes again
+	Mode fs.FileMode `json:"mode,omitempty"`
+	Dir  string      `json:"dir,omitempty"`
+	// Undoes is the run an undo reverted
+	Undoes string `json:"undoes,omitempty"`
+}
+
+// appendAudit adds records to the audit log, flushed to disk before it
+// returns
+func appendAudit(records []AuditRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(stateDir(), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(auditLogPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	_, err = f.WriteString(b.String())
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// auditTrail notes the files a command is about to change with their
+// hashes, and logs those that did change once it is done. A nil trail,
+// as on a dry run, notes nothing
+type auditTrail struct {
+	command string
+	user    string
+	// journal keeps what the files held before in the object store, so
+	// undo can put it back
+	journal bool
+	// undoes is the run an undo reverts
+	undoes string
+
+	// mu guards the rest, as files are watched from the copy workers
+	mu     sync.Mutex
+	paths  []string
+	before map[string]auditBefore
+	// mapping is that of the item being migrated, and dirs the directories
+	// it was missing before it started, which the run creates
+	mapping string
+	dirs    []string
+}
+
+// auditBefore is a watched file as it was before the command
+type auditBefore struct {
+	hash, mapping, dir string
+	mode               fs.FileMode
+}
+
+// newAuditTrail starts the trail of a command run for user, which is empty
+// unless it migrates another user's profile
+func newAuditTrail(command, user string) *auditTrail {
+	return &auditTrail{command: command, user: user, before: map[string]auditBefore{}}
+}
+
+// watch notes a file before it may change
+func (a *auditTrail) watch(path, mapping string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, seen := a.before[path]; seen {
+		return
+	}
+	b := auditBefore{mapping: mapping}
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// The highest missing directory is what the command creates, and
+		// the item may have created it already
+		b.dir = missingDir(filepath.Dir(path))
+		for _, dir := range a.dirs {
+			if dir != path && withinRoot(dir, path) {
+				b.dir = dir
+				break
+			}
+		}
+	case err == nil && info.Mode().IsRegular():
+		b.mode = info.Mode().Perm()
+		if !a.journal {
+			b.hash, _ = hashFile(path)
+			break
+		}
+		data, err := os.ReadFile(path)
+		if err == nil {
+			b.hash, _, err = openObjectStore().put(data)
+		}
+		if err != nil {
+			b.hash, _ = hashFile(path)
+			warnColor.Printf("⚠️  Could not keep %s for undo: %v\n", path, err)
+		}
+	}
+	a.paths = append(a.paths, path)
+	a.before[path] = b
+}
+
+// missingDir returns the highest of dir and its parents that does not
+// exist, or "" when dir exists
+func missingDir(dir string) string {
+	missing := ""
+	for ; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			return missing
+		}
+		missing = dir
+	}
+}
+
+// watchItem starts a migration item, before anything of it is written:
+// it notes the directories the item's destination is missing, and the
+// destination itself for a single file. The files a directory item writes
+// are watched as they are copied, with watchFile
+func (a *auditTrail) watchItem(item MigrationItem) {
+	if a == nil {
+		return
+	}
+	info, err := os.Stat(item.SourcePath)
+	dir := err == nil && info.IsDir()
+	a.mu.Lock()
+	a.mapping, a.dirs = item.Mapping, nil
+	if dir {
+		if missing := missingDir(item.DestinationPath); missing != "" {
+			a.dirs = append(a.dirs, missing)
+		}
+	}
+	a.mu.Unlock()
+	if !dir {
+		a.watch(item.DestinationPath, item.Mapping)
+	}
+}
+
+// watchFile notes a file the current item is about to write
+func (a *auditTrail) watchFile(path string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	mapping := a.mapping
+	a.mu.Unlock()
+	a.watch(path, mapping)
+}
+
+// flush logs the watched files that changed and starts over. Failing to
+// write the log is reported but never fails the command
+func (a *auditTrail) flush() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now().UTC()
+	var records []AuditRecord
+	for _, path := range a.paths {
+		b := a.before[path]
+		after, _ := hashFile(path)
+		if after == b.hash {
+			continue
+		}
+		action := auditModified
+		switch {
+		case b.hash == "":
+			action = auditCreated
+		case after == "":
+			action = auditDeleted
+		}
+		records = append(records, AuditRecord{
+			Time:    now,
+			Run:     runID(),
+			Command: a.command,
+			User:    currentUserName(),
+			Machine: machineName(),
+			For:     a.user,
+			Action:  action,
+			Path:    path,
+			Mapping: b.mapping,
+			Before:  b.hash,
+			After:   after,
+			Mode:    b.mode,
+			Dir:     b.dir,
+			Undoes:  a.undoes,
+		})
+	}
+	a.paths, a.before, a.dirs = nil, map[string]auditBefore{}, nil
+	if err := appendAudit(records); err != nil {
+		warnColor.Println("⚠️  Could not write the audit log:", err)
+	}
+}
+
+// readAudit returns the records of the audit log that keep returns true
+// for, oldest first
+func readAudit(keep func(AuditRecord) bool) ([]AuditRecord, error) {
+	f, err := os.Open(auditLogPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", auditLogPath(), line, err)
+		}
+		if keep(r) {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// parseSince reads --since: a duration back from now, such as 72h, or a
+// date or time
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("must be a duration such as 72h, or a date such as 2006-01-02")
+}
+
+// shortHash shortens a hash for the table, or shows a missing file as -
+func shortHash(hash string) string {
+	if hash == "" {
+		return "-"
+	}
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// runAudit handles `profilesync audit`, showing the files runs changed
+func runAudit(args []string) {
+	flags := newFlagSet("audit", "audit [flags] [PATH...]")
+	since := flags.String("since", "", "Only changes since this long ago (such as 72h) or this date")
+	run := flags.String("run", "", "Only changes made by this run")
+	command := flags.String("command", "", "Only changes made by this command: apply, restore, prune or undo")
+	format := flags.String("format", "table", "Output format: table, or json for one record per line")
+	flags.Parse(args)
+
+	if *format != "table" && *format != "json" {
+		errorColor.Println("❌ --format must be table or json")
+		os.Exit(2)
+	}
+	var from time.Time
+	if *since != "" {
+		var err error
+		if from, err = parseSince(*since); err != nil {
+			errorColor.Println("❌ Error: --since", err)
+			os.Exit(2)
+		}
+	}
+	var paths []string
+	for _, p := range flags.Args() {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(2)
+		}
+		paths = append(paths, abs)
+	}
+
+	records, err := readAudit(func(r AuditRecord) bool {
+		if r.Time.Before(from) || (*run != "" && r.Run != *run) || (*command != "" && r.Command != *command) {
+			return false
+		}
+		matched := len(paths) == 0
+		for _, p := range paths {
+			matched = matched || withinRoot(p, r.Path)
+		}
+		return matched
+	})
+	if err != nil {
+		errorColor.Println("❌ Error reading the audit log:", err)
+		os.Exit(1)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			enc.Encode(r)
+		}
+		return
+	}
+	if len(records) == 0 {
+		fmt.Println("No changes recorded")
+		return
+	}
+	for _, r := range records {
+		infoColor.Printf("%s  %-8s", r.Time.Local().Format("2006-01-02 15:04:05"), r.Command)
+		fmt.Printf("  %-8s  %s  %s → %s  %s@%s  %s\n", r.Action, displayPath(r.Path), shortHash(r.Before), shortHash(r.After), r.User, r.Machine, r.Run)
+	}
+}
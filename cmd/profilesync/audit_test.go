@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestWatchItemJournalsCopiedFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROFILESYNC_STATE_DIR", filepath.Join(dir, "state"))
+	src, dest := filepath.Join(dir, "src", "nvim"), filepath.Join(dir, "home", ".config", "nvim")
+	for name, data := range map[string]string{
+		"init.lua":          "vim.o.number = true",
+		"lua/plugins.lua":   "return {}",
+		"swap.log":          "left out",
+		"lua/.keep/tmp.txt": "left out",
+	} {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, ignoreFileName), []byte("swap.log\nlua/.keep/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ps := NewProfileSync("linux", "linux", false, false, false, false, false, 2)
+	ps.audit = newAuditTrail("apply", "")
+	item := MigrationItem{Mapping: "${XDG_CONFIG_HOME}/nvim/", SourcePath: src, DestinationPath: dest}
+	ps.audit.watchItem(item)
+	if err := ps.copyItem(context.Background(), item); err != nil {
+		t.Fatal(err)
+	}
+
+	got := append([]string(nil), ps.audit.paths...)
+	sort.Strings(got)
+	want := []string{filepath.Join(dest, ignoreFileName), filepath.Join(dest, "init.lua"), filepath.Join(dest, "lua", "plugins.lua")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("watched %q, want only the copied files %q", got, want)
+	}
+	// Every file is below the directory the item created, which undo removes
+	for _, path := range got {
+		if b := ps.audit.before[path]; b.dir != filepath.Join(dir, "home") || b.mapping != item.Mapping {
+			t.Errorf("%s watched with dir %q and mapping %q", path, b.dir, b.mapping)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	"gopkg.in/yaml.v3"
+)
+
+// Secrets profilesync keeps in the OS keystore, besides token:HOST entries
+const (
+	authGPGPassphrase = "gpg-passphrase"
+	authTokenPrefix   = "token:"
+)
+
+// authSecret describes a kind of secret `auth` manages: what it is for and
+// the environment variable that overrides the keystore
+type authSecret struct {
+	what string
+	env  string
+}
+
+// authSecretFor describes the secret name, or errors for names profilesync
+// does not use
+func authSecretFor(name string) (authSecret, error) {
+	switch {
+	case name == stateKeyName:
+		return authSecret{"key that encrypts the state directory (base64, 32 bytes)", "PROFILESYNC_STATE_KEY"}, nil
+	case name == signingKeyName:
+		return authSecret{"key that signs exported archives (base64, 32 byte ed25519 seed)", "PROFILESYNC_SIGNING_KEY"}, nil
+	case name == authGPGPassphrase:
+		return authSecret{"passphrase of the GPG keys in the profile store", gpgPassphraseEnv}, nil
+	case strings.HasPrefix(name, authTokenPrefix) && len(name) > len(authTokenPrefix) && !strings.ContainsAny(name[len(authTokenPrefix):], "/ "):
+		return authSecret{"bearer token for remote configs, registries and Vault on " + name[len(authTokenPrefix):], ""}, nil
+	}
+	return authSecret{}, fmt.Errorf("unknown secret %q (must be %s, %s, %s or %sHOST)", name, stateKeyName, signingKeyName, authGPGPassphrase, authTokenPrefix)
+}
+
+// authIndexPath lists the names stored with `auth set`, as keystores cannot
+// be listed the same way everywhere. It never holds the secrets themselves
+func authIndexPath() string {
+	return filepath.Join(stateDir(), "auth.yaml")
+}
+
+// loadAuthIndex reads when each stored secret was set, by name
+func loadAuthIndex() (map[string]time.Time, error) {
+	index := map[string]time.Time{}
+	data, err := readStateFile(authIndexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("%s: %w", authIndexPath(), err)
+	}
+	return index, nil
+}
+
+// saveAuthIndex writes the index of stored secrets
+func saveAuthIndex(index map[string]time.Time) error {
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(authIndexPath(), data, 0600)
+}
+
+// lookupSecret returns a secret from its environment variable, if it has
+// one and it is set, else from the OS keystore. ok is false when neither
+// has it
+func lookupSecret(name string) (secret string, ok bool, err error) {
+	if s, err := authSecretFor(name); err == nil && s.env != "" {
+		if value, ok := os.LookupEnv(s.env); ok {
+			return value, true, nil
+		}
+	}
+	metrics.request("keystore")
+	secret, err = keystoreGet(name)
+	switch {
+	case errors.Is(err, errSecretNotFound), errors.Is(err, errNoKeystore):
+		return "", false, nil
+	case err != nil:
+		return "", false, fmt.Errorf("reading %s from the OS keystore: %w", name, err)
+	}
+	return secret, true, nil
+}
+
+// readSecretInput reads a secret from the terminal without echo, or from
+// stdin when it is piped
+func readSecretInput(name string) (string, error) {
+	if isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		return readSecret(fmt.Sprintf("🔑 %s: ", name))
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// runAuth handles `profilesync auth set|list|delete`, managing the secrets
+// kept in the OS keystore
+func runAuth(args []string) {
+	if len(args) == 0 {
+		errorColor.Println("❌ Usage: profilesync auth set|list|delete [flags]")
+		os.Exit(2)
+	}
+	sub, args := args[0], args[1:]
+
+	switch sub {
+	case "set":
+		flags := newFlagSet("auth set", "auth set [flags] NAME")
+		flags.Parse(args)
+		if flags.NArg() != 1 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		name := flags.Arg(0)
+		if _, err := authSecretFor(name); err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(2)
+		}
+		secret, err := readSecretInput(name)
+		if err == nil && secret == "" {
+			err = errors.New("empty secret")
+		}
+		if err == nil && name == stateKeyName {
+			if key, decodeErr := base64.StdEncoding.DecodeString(secret); decodeErr != nil || len(key) != 32 {
+				err = errors.New("state key must be 32 bytes, base64 encoded")
+			} else if current, getErr := keystoreGet(stateKeyName); getErr == nil && current != secret && stateEncryptionEnabled() {
+				err = errors.New("the state directory is encrypted with another key; run `profilesync state decrypt` first")
+			}
+		}
+		if err == nil && name == signingKeyName {
+			if seed, decodeErr := base64.StdEncoding.DecodeString(secret); decodeErr != nil || len(seed) != 32 {
+				err = errors.New("signing key must be a 32 byte seed, base64 encoded")
+			}
+		}
+		if err != nil {
+			errorColor.Println("❌ Error reading secret:", err)
+			os.Exit(1)
+		}
+		metrics.request("keystore")
+		if err := keystoreSet(name, secret); err != nil {
+			errorColor.Println("❌ Error storing secret:", err)
+			os.Exit(1)
+		}
+		index, err := loadAuthIndex()
+		if err == nil {
+			index[name] = time.Now().UTC()
+			err = saveAuthIndex(index)
+		}
+		if err != nil {
+			warnColor.Printf("⚠️  Stored %s, but could not record it for `auth list`: %v\n", name, err)
+			return
+		}
+		successColor.Printf("🔑 Stored %s in the OS keystore\n", name)
+
+	case "list":
+		flags := newFlagSet("auth list", "auth list [flags]")
+		flags.Parse(args)
+
+		index, err := loadAuthIndex()
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(1)
+		}
+		// The state and signing keys are created by `state encrypt` and
+		// `trust key`, not `auth set`
+		created := map[string]string{stateKeyName: "state encrypt", signingKeyName: "trust key or export archive"}
+		for name := range created {
+			if _, ok := index[name]; !ok {
+				if _, err := keystoreGet(name); err == nil {
+					index[name] = time.Time{}
+				}
+			}
+		}
+		if len(index) == 0 {
+			fmt.Println("No secrets stored; add one with `profilesync auth set NAME`")
+			return
+		}
+		for _, name := range sortedKeys(index) {
+			s, _ := authSecretFor(name)
+			when := "set " + index[name].Local().Format("2006-01-02")
+			if index[name].IsZero() {
+				when = "created by " + created[name]
+			}
+			note := ""
+			if s.env != "" && os.Getenv(s.env) != "" {
+				note = " (overridden by $" + s.env + ")"
+			}
+			fmt.Printf("   %-32s %s%s\n", name, when, note)
+			fmt.Printf("   %-32s %s\n", "", s.what)
+		}
+
+	case "delete":
+		flags := newFlagSet("auth delete", "auth delete [flags] NAME...")
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		index, err := loadAuthIndex()
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(1)
+		}
+		failed := 0
+		for _, name := range flags.Args() {
+			if name == stateKeyName && stateEncryptionEnabled() {
+				errorColor.Printf("❌ %s: the state directory is encrypted with it; run `profilesync state decrypt` first\n", name)
+				failed++
+				continue
+			}
+			metrics.request("keystore")
+			err := keystoreDelete(name)
+			delete(index, name)
+			switch {
+			case errors.Is(err, errSecretNotFound):
+				warnColor.Printf("⚠️  %s is not stored\n", name)
+			case err != nil:
+				errorColor.Printf("❌ %s: %v\n", name, err)
+				failed++
+			default:
+				successColor.Printf("🗑️  Deleted %s\n", name)
+			}
+		}
+		if err := saveAuthIndex(index); err != nil {
+			errorColor.Println("❌ Error:", err)
+			failed++
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+
+	default:
+		errorColor.Printf("❌ Unknown auth command: %s\n", sub)
+		errorColor.Println("Must be one of: set, list, delete")
+		os.Exit(2)
+	}
+}
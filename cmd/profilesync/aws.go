@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// awsProfileName returns the profile a section configures, or "" when the
+// section is not a profile. The config file writes "[profile x]" where
+// credentials has "[x]"
+func awsProfileName(section string, configFile bool) string {
+	if !configFile || section == "default" {
+		return section
+	}
+	if name, ok := strings.CutPrefix(section, "profile "); ok {
+		return strings.TrimSpace(name)
+	}
+	return ""
+}
+
+// loadAWSFile parses an AWS config or credentials file; a missing file has no sections
+func loadAWSFile(path string) ([]*iniSection, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parseINI(data), nil
+}
+
+// chooseAWSProfiles decides once per run which source profiles to migrate:
+// those named with --aws-profiles, else the user's picks when we can ask,
+// else all. Profiles the chosen ones depend on through source_profile are
+// added
+func (ps *ProfileSync) chooseAWSProfiles(sourceDir string) (map[string]bool, error) {
+	config, err := loadAWSFile(filepath.Join(sourceDir, "config"))
+	if err != nil {
+		return nil, err
+	}
+
+	if ps.awsProfiles == nil {
+		credentials, err := loadAWSFile(filepath.Join(sourceDir, "credentials"))
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		var all []string
+		for _, s := range credentials {
+			if name := awsProfileName(s.Name, false); name != "" && !seen[name] {
+				seen[name] = true
+				all = append(all, name)
+			}
+		}
+		for _, s := range config {
+			if name := awsProfileName(s.Name, true); name != "" && !seen[name] {
+				seen[name] = true
+				all = append(all, name)
+			}
+		}
+		sort.Strings(all)
+
+		ps.awsProfiles = []string{}
+		for _, name := range all {
+			if ps.decide(decisionAWSProfile, name, fmt.Sprintf("   Bring over AWS profile %s?", name), true) {
+				ps.awsProfiles = append(ps.awsProfiles, name)
+			}
+		}
+	}
+
+	chosen := map[string]bool{}
+	queue := append([]string(nil), ps.awsProfiles...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if chosen[name] {
+			continue
+		}
+		chosen[name] = true
+		for _, s := range config {
+			if awsProfileName(s.Name, true) == name && s.Get("source_profile") != "" {
+				queue = append(queue, s.Get("source_profile"))
+			}
+		}
+	}
+	return chosen, nil
+}
+
+// mergeAWSFile copies the chosen profiles' sections from src into dst,
+// along with the sso-session sections they use. Sections dst already has
+// are replaced only when force is set; the names of those kept are returned
+func mergeAWSFile(dst, src []*iniSection, chosen map[string]bool, configFile, force bool) ([]*iniSection, []string) {
+	sessions := map[string]bool{}
+	for _, s := range src {
+		if chosen[awsProfileName(s.Name, configFile)] && s.Get("sso_session") != "" {
+			sessions["sso-session "+s.Get("sso_session")] = true
+		}
+	}
+
+	var kept []string
+	for _, s := range src {
+		if s.Name == "" || (!sessions[s.Name] && !chosen[awsProfileName(s.Name, configFile)]) {
+			continue
+		}
+
+		replaced := false
+		for i, existing := range dst {
+			if existing.Name != s.Name {
+				continue
+			}
+			replaced = true
+			if reflect.DeepEqual(existing.Vals, s.Vals) {
+				break
+			}
+			if force {
+				dst[i] = s
+			} else {
+				kept = append(kept, s.Name)
+			}
+			break
+		}
+		if !replaced {
+			dst = append(dst, s)
+		}
+	}
+	return dst, kept
+}
+
+// copyAWSFile merges the chosen profiles of an AWS config or credentials
+// file into the destination's, leaving its other profiles alone
+func (ps *ProfileSync) copyAWSFile(ctx context.Context, item MigrationItem) error {
+	chosen, err := ps.chooseAWSProfiles(filepath.Dir(item.SourcePath))
+	if err != nil {
+		return err
+	}
+	if len(chosen) == 0 {
+		warnColor.Printf("⏭️  No AWS profiles chosen for %s\n", item.Description)
+		return nil
+	}
+
+	src, err := loadAWSFile(item.SourcePath)
+	if err != nil {
+		return err
+	}
+	dst, err := loadAWSFile(item.DestinationPath)
+	if err != nil {
+		return err
+	}
+
+	configFile := filepath.Base(item.SourcePath) == "config"
+	merged, kept := mergeAWSFile(dst, src, chosen, configFile, ps.force)
+	for _, name := range kept {
+		warnColor.Printf("⚠️  Kept the destination's [%s] in %s (use --force to replace it)\n", name, filepath.Base(item.DestinationPath))
+	}
+
+	data := formatINI(merged, " = ", lineEnding(ps.destPlatform))
+	if err := checkTransformed(configINI, item.DestinationPath, data, "the AWS profile merge"); err != nil {
+		return err
+	}
+	if _, err := os.Stat(item.DestinationPath); err == nil {
+		backup, err := backupFile(item.DestinationPath)
+		if err != nil {
+			return fmt.Errorf("backing up %s: %w", item.DestinationPath, err)
+		}
+		if ps.verbose {
+			noticeColor.Printf("💾 Previous %s saved to %s\n", filepath.Base(item.DestinationPath), backup)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(item.DestinationPath), 0700); err != nil {
+		return err
+	}
+	// Credentials hold secret keys; keep both files private
+	if err := writeFileAtomic(item.DestinationPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(item.DestinationPath, 0600)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bootstrapMarker starts the lines the remote script reports each file on
+const bootstrapMarker = "profilesync-bootstrap:"
+
+// bootstrapMinimalTag picks what --minimal pushes
+const bootstrapMinimalTag = "minimal"
+
+// bootstrapUnpack unpacks the archive on stdin into a temporary directory
+// on the server
+const bootstrapUnpack = `m=` + bootstrapMarker + `
+set -e
+t=$(mktemp -d)
+trap 'rm -rf "$t"' EXIT
+tar -xmf - -C "$t"
+`
+
+// bootstrapPut defines put, which moves a file unpacked into $t to the
+// home directory. Existing files are kept unless FORCE is set, when they
+// are first moved aside to NAME.profilesync-backup. Each file is reported
+// on a line starting with $m
+const bootstrapPut = `put() {
+  if [ -e "$HOME/$1" ] || [ -L "$HOME/$1" ]; then
+    if [ ! -L "$t/$1" ] && command -v cmp >/dev/null 2>&1 && cmp -s "$t/$1" "$HOME/$1"; then
+      echo "${m}same:$1"; return
+    fi
+    if [ -z "$FORCE" ]; then
+      echo "${m}kept:$1"; return
+    fi
+    rm -rf "$HOME/$1.profilesync-backup"
+    mv "$HOME/$1" "$HOME/$1.profilesync-backup"
+  fi
+  cp -Pp "$t/$1" "$HOME/$1"
+  echo "${m}wrote:$1"
+}
+`
+
+// bootstrapRemoteScript is the script for the files collected into dir.
+// After unpack has put them in $t, it creates the missing directories
+// with their modes, then puts every file and link in place
+func bootstrapRemoteScript(dir, unpack string) (string, error) {
+	var b strings.Builder
+	b.WriteString(unpack)
+	b.WriteString(bootstrapPut)
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !d.IsDir() {
+			files = append(files, rel)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Parents come first in the walk, so each one exists before its children
+		target := `"$HOME"/` + shellQuote(rel)
+		fmt.Fprintf(&b, "[ -d %s ] || { mkdir %s && chmod %04o %s; }\n", target, target, info.Mode().Perm()|0700, target)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	for _, rel := range files {
+		fmt.Fprintf(&b, "put %s\n", shellQuote(rel))
+	}
+	return b.String(), nil
+}
+
+// bootstrapHost asks the server for its platform and home directory
+func bootstrapHost(ctx context.Context, argv []string) (platform, home string, err error) {
+	cmd := exec.CommandContext(ctx, argv[0], append(argv[1:], `uname -s; echo "$HOME"`)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", "", fmt.Errorf("%w\n%s", err, msg)
+		}
+		return "", "", err
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(strings.TrimSpace(lines[len(lines)-1]), "/") {
+		return "", "", fmt.Errorf("unexpected answer from the server: %q", strings.TrimSpace(string(out)))
+	}
+	home = strings.TrimSpace(lines[len(lines)-1])
+	switch system := strings.TrimSpace(lines[len(lines)-2]); system {
+	case "Linux":
+		platform = "linux"
+	case "Darwin":
+		platform = "macos"
+	default:
+		return "", "", fmt.Errorf("%s servers are not supported; only Linux and macOS are", system)
+	}
+	return platform, home, nil
+}
+
+// runBootstrap handles `profilesync bootstrap [user@]host`, pushing the
+// configs that make a fresh server feel like home over ssh. The server
+// needs only sh and tar, not profilesync
+func runBootstrap(args []string) {
+	flags := newFlagSet("bootstrap", "bootstrap [flags] [user@]host | ssh://[user@]host[:port]")
+	defaultSSH := "ssh"
+	if command := os.Getenv("PROFILESYNC_SSH"); command != "" {
+		defaultSSH = command
+	}
+	minimal := flags.Bool("minimal", true, "Push only the configs tagged minimal: shell, git, tmux, vim and the ssh config with public keys")
+	tags := flags.String("tags", "", "Comma-separated tags; push configs with one of them instead of the minimal ones")
+	skipTags := flags.String("skip-tags", "", "Comma-separated tags; leave out configs with any of them")
+	secrets := flags.Bool("secrets", false, "Also push secrets, such as private ssh keys and cloud credentials")
+	force := flags.Bool("force", false, "Replace files the server already has, keeping each as NAME.profilesync-backup")
+	dryRun := flags.Bool("dry-run", false, "List what would be pushed without connecting to the server")
+	sourceDir := flags.String("source-dir", "", "Push from this directory instead of the home directory, e.g. the profile store's home")
+	configFile := flags.String("config", "", "Config file with mappings and policies (default "+configPath()+" if it exists)")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config whose mappings to push")
+	sshCommand := flags.String("ssh", defaultSSH, "Command used to reach the server")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	host, target := flags.Arg(0), flags.Arg(0)
+	if !strings.Contains(target, "://") {
+		target = "ssh://" + target
+	}
+	sshArgs, _, err := sshTarget(target)
+	if err != nil {
+		errorColor.Printf("❌ %v\n", err)
+		os.Exit(2)
+	}
+	argv := append(strings.Fields(*sshCommand), sshArgs...)
+	if len(argv) == len(sshArgs) {
+		errorColor.Println("❌ Empty --ssh command")
+		os.Exit(2)
+	}
+
+	include, exclude := splitList(*tags), splitList(*skipTags)
+	if len(include) == 0 && *minimal {
+		include = []string{bootstrapMinimalTag}
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	platform, home := "linux", "$HOME"
+	if !*dryRun {
+		if platform, home, err = bootstrapHost(ctx, argv); err != nil {
+			errorColor.Printf("❌ Could not reach %s: %v\n", host, err)
+			os.Exit(1)
+		}
+	}
+
+	ps, sourceHome := planExport(ctx, DetectPlatform(), platform, *sourceDir, *configFile, *profile, symlinkFollow)
+	dir, err := os.MkdirTemp("", "profilesync-bootstrap-")
+	if err != nil {
+		errorColor.Println("❌ Error creating a temporary directory:", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	var secretItems []string
+	keep := func(item MigrationItem) bool {
+		if !tagsSelected(catalogTags(item.Mapping), include, exclude) {
+			return false
+		}
+		// ssh goes without its private keys, which the redaction leaves out
+		if item.Sensitivity == sensitivitySecret && !*secrets && item.Mapping != "ssh/" {
+			secretItems = append(secretItems, item.Description)
+			return false
+		}
+		return true
+	}
+	out := exportTarget{dir: dir, home: home, keepModes: true}
+	if !*secrets {
+		out.redaction = newRedactionPolicy(destExport, ps.redaction)
+	}
+	var pushed []string
+	err = ps.exportedItems(ctx, sourceHome, out, keep, func(item MigrationItem, rel string, collected exportedItem) error {
+		pushed = append(pushed, item.Description)
+		return placeExported(dir, collected)
+	})
+	if err != nil {
+		errorColor.Println("❌ Error collecting the profile:", err)
+		os.Exit(1)
+	}
+	if len(secretItems) > 0 {
+		warnColor.Printf("🔒 Left out secrets (use --secrets to push them): %s\n", strings.Join(secretItems, ", "))
+	}
+	if len(pushed) == 0 {
+		warnColor.Println("⚠️  Nothing to push: this machine has none of the selected configs")
+		return
+	}
+	files := filepath.Join(dir, "files")
+
+	if *dryRun {
+		noticeColor.Printf("🚀 Would push %d configs to %s:\n", len(pushed), host)
+		for _, description := range pushed {
+			fmt.Printf("   %s\n", description)
+		}
+		return
+	}
+
+	unpack := bootstrapUnpack
+	if *force {
+		unpack = "FORCE=1\n" + unpack
+	}
+	script, err := bootstrapRemoteScript(files, unpack)
+	if err != nil {
+		errorColor.Println("❌ Error collecting the profile:", err)
+		os.Exit(1)
+	}
+	noticeColor.Printf("🚀 Pushing %d configs to %s (%s, %s)\n", len(pushed), host, platform, home)
+	cmd := exec.CommandContext(ctx, argv[0], append(argv[1:], "sh -c "+shellQuote(script))...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		errorColor.Println("❌ Error:", err)
+		os.Exit(1)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		errorColor.Println("❌ Error:", err)
+		os.Exit(1)
+	}
+	if err := cmd.Start(); err != nil {
+		errorColor.Printf("❌ Could not reach %s: %v\n", host, err)
+		os.Exit(1)
+	}
+	written := make(chan error, 1)
+	go func() {
+		written <- writeSandboxTar(stdin, files)
+		stdin.Close()
+	}()
+
+	wrote, same, kept := 0, 0, 0
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		status, rel, ok := strings.Cut(strings.TrimPrefix(scanner.Text(), bootstrapMarker), ":")
+		if !ok {
+			continue
+		}
+		switch status {
+		case "wrote":
+			successColor.Printf("✅ ~/%s\n", rel)
+			wrote++
+		case "same":
+			same++
+		case "kept":
+			warnColor.Printf("⚠️  Kept the server's ~/%s\n", rel)
+			kept++
+		}
+	}
+	err = cmd.Wait()
+	if werr := <-written; werr != nil && err == nil {
+		err = werr
+	}
+	if err != nil {
+		errorColor.Printf("❌ Bootstrapping %s failed: %v\n", host, err)
+		os.Exit(1)
+	}
+	noticeColor.Printf("%d files pushed, %d already up to date, %d kept\n", wrote, same, kept)
+	if kept > 0 {
+		noticeColor.Println("Use --force to replace the files kept; the server's copies are moved to NAME.profilesync-backup")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+)
+
+// orderCanary moves a random sample of the items that will be migrated to
+// the front of the plan, so they make up the canary
+func (ps *ProfileSync) orderCanary() {
+	var candidates []int
+	for i, item := range ps.migrationPlan.Items {
+		if _, err := os.Stat(item.SourcePath); err == nil && item.AutoMigrate && !ps.checkpoint.completed(item.ID) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) <= ps.canary {
+		return
+	}
+
+	picked := map[int]bool{}
+	for _, n := range rand.Perm(len(candidates))[:ps.canary] {
+		picked[candidates[n]] = true
+	}
+	var front, back []MigrationItem
+	for i, item := range ps.migrationPlan.Items {
+		if picked[i] {
+			front = append(front, item)
+		} else {
+			back = append(back, item)
+		}
+	}
+	ps.migrationPlan.Items = append(front, back...)
+}
+
+// continueAfterCanary shows what the canary migrated and asks whether to go
+// on with the rest. Without a terminal to ask on, the run stops so it can be
+// checked and resumed
+func (ps *ProfileSync) continueAfterCanary(migrated []MigrationItem) bool {
+	noticeColor.Printf("\n🐤 Canary: migrated %d item(s). Check them before continuing:\n", len(migrated))
+	for _, item := range migrated {
+		noticeColor.Printf("  • %s → %s\n", item.Description, item.DestinationPath)
+	}
+	if ps.canPrompt() && confirm("Continue with the remaining items?", false) {
+		return true
+	}
+	warnColor.Println("⏸️  Paused after the canary. Run `profilesync apply --resume` to migrate the rest.")
+	return false
+}
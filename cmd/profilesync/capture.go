@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// captureManifestName records what capture saved, in the profile store
+const captureManifestName = "capture.yaml"
+
+// captureWithDefault are the other capture targets a capture runs. They
+// skip themselves where they do not apply and never ask for anything
+var captureWithDefault = []string{"packages", "extensions", "defaults", "dconf", "registry", "tasks"}
+
+// captureManifest lists the configs captured into the profile store, by
+// mapping, with where and when each was captured
+type captureManifest struct {
+	Items []capturedItem `yaml:"items"`
+}
+
+// capturedItem is one config as capture last saved it. Hash is set for
+// single files
+type capturedItem struct {
+	Mapping     string    `yaml:"mapping"`
+	Description string    `yaml:"description"`
+	Path        string    `yaml:"path"`
+	Source      string    `yaml:"source"`
+	Machine     string    `yaml:"machine"`
+	Platform    string    `yaml:"platform"`
+	Captured    time.Time `yaml:"captured"`
+	Size        int64     `yaml:"size"`
+	Files       int       `yaml:"files"`
+	Hash        string    `yaml:"sha256,omitempty"`
+	Mode        string    `yaml:"mode,omitempty"`
+	Sensitivity string    `yaml:"sensitivity,omitempty"`
+	Stale       string    `yaml:"stale,omitempty"`
+}
+
+// captureManifestPath is where the manifest of a profile store is kept
+func captureManifestPath(dir string) string {
+	return filepath.Join(dir, captureManifestName)
+}
+
+// loadCaptureManifest reads the manifest, empty when nothing was captured
+func loadCaptureManifest(dir string) (*captureManifest, error) {
+	m := &captureManifest{}
+	data, err := os.ReadFile(captureManifestPath(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%s: %w", captureManifestPath(dir), err)
+	}
+	return m, nil
+}
+
+// record adds what was captured, replacing earlier captures of the same
+// mappings. Mappings captured on other machines stay
+func (m *captureManifest) record(items []capturedItem) {
+	byMapping := map[string]capturedItem{}
+	for _, item := range m.Items {
+		byMapping[item.Mapping] = item
+	}
+	for _, item := range items {
+		byMapping[item.Mapping] = item
+	}
+	m.Items = m.Items[:0]
+	for _, mapping := range sortedKeys(byMapping) {
+		m.Items = append(m.Items, byMapping[mapping])
+	}
+}
+
+// forget drops mappings from the manifest
+func (m *captureManifest) forget(mappings map[string]bool) {
+	kept := m.Items[:0]
+	for _, item := range m.Items {
+		if !mappings[item.Mapping] {
+			kept = append(kept, item)
+		}
+	}
+	m.Items = kept
+}
+
+// staleReason says why a config looks dead, or is empty when it does not:
+// nothing in it changed for staleAfter, or its application is not
+// installed here. A zero staleAfter never flags configs by age
+func staleReason(item MigrationItem, staleAfter time.Duration, now time.Time) string {
+	if item.AppMissing {
+		return knownApps[item.App].Name + " not installed"
+	}
+	if staleAfter <= 0 {
+		return ""
+	}
+	_, _, modified := measureTree(item.SourcePath)
+	if !modified.IsZero() && now.Sub(modified) > staleAfter {
+		return "unchanged since " + modified.Format("2006-01-02")
+	}
+	return ""
+}
+
+// save writes the manifest
+func (m *captureManifest) save(dir string) error {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(captureManifestPath(dir), data, 0644)
+}
+
+// captureItem copies one item of the plan into the profile store as it
+// is, without merging, and describes what it saved
+func (ps *ProfileSync) captureItem(ctx context.Context, item MigrationItem, dir string) (capturedItem, error) {
+	if err := os.MkdirAll(filepath.Dir(item.DestinationPath), 0755); err != nil {
+		return capturedItem{}, err
+	}
+	var err error
+	if isSymlink(item.SourcePath) && item.Symlinks == symlinkPreserve {
+		err = ps.copySymlink(item.SourcePath, item.DestinationPath)
+	} else {
+		err = ps.copyTree(ctx, item)
+	}
+	if err != nil {
+		return capturedItem{}, err
+	}
+	rel, err := filepath.Rel(dir, item.DestinationPath)
+	if err != nil {
+		return capturedItem{}, err
+	}
+	c := capturedItem{
+		Mapping:     item.Mapping,
+		Description: item.Description,
+		Path:        filepath.ToSlash(rel),
+		Source:      item.SourcePath,
+		Machine:     machineName(),
+		Platform:    ps.sourcePlatform,
+		Captured:    time.Now().UTC(),
+	}
+	c.Size, c.Files, c.Hash = measureItem(item.DestinationPath)
+	if info, err := os.Stat(item.SourcePath); err == nil {
+		c.Mode = fmt.Sprintf("%04o", info.Mode().Perm())
+	}
+	if item.Sensitivity != sensitivityNormal {
+		c.Sensitivity = item.Sensitivity
+	}
+	return c, nil
+}
+
+// runCaptureProfile handles `profilesync capture`, saving the configs of
+// this machine that the mappings know about into the profile store, then
+// the packages, extensions and settings the other capture targets keep
+func runCaptureProfile(args []string) {
+	flags := newFlagSet("capture", "capture [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to write to")
+	configFile := flags.String("config", "", "Config file with mappings and policies (default "+configPath()+" if it exists)")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config whose mappings to capture")
+	with := flags.String("with", strings.Join(captureWithDefault, ","), "Comma-separated capture targets to run as well, all for every one, or none")
+	tags := flags.String("tags", "", "Comma-separated tags; only capture configs with one of them")
+	skipTags := flags.String("skip-tags", "", "Comma-separated tags; leave out configs with any of them")
+	skipSecrets := flags.Bool("skip-secrets", false, "Leave out configs holding secrets, such as SSH keys and cloud credentials")
+	staleDays := flags.Int("stale-after", 365, "Flag configs unchanged for this many days as stale, 0 to never")
+	skipStale := flags.Bool("skip-stale", false, "Leave out stale configs and those of applications not installed, and drop earlier captures of them from the store")
+	dryRun := flags.Bool("dry-run", false, "Show what would be captured without copying anything")
+	verbose := flags.Bool("verbose", false, "Also list the known configs this machine does not have")
+	jobs := flags.Int("jobs", runtime.NumCPU(), "Number of parallel workers for copying")
+	flags.Parse(args)
+
+	var targets []string
+	switch *with {
+	case "none", "":
+	case "all":
+		for _, kind := range capturedKinds {
+			targets = append(targets, kind.name)
+		}
+	default:
+		targets = splitList(*with)
+		for _, target := range targets {
+			known := false
+			for _, kind := range capturedKinds {
+				known = known || kind.name == target
+			}
+			if !known {
+				errorColor.Println("❌ Invalid --with value:", target)
+				errorColor.Println("Must be all, none, or some of: packages, extensions, defaults, dconf, registry, tasks, gpg, plugins, secrets")
+				os.Exit(2)
+			}
+		}
+	}
+
+	if *configFile == "" {
+		if _, err := os.Stat(configPath()); err == nil {
+			*configFile = configPath()
+		}
+	}
+	platform := DetectPlatform()
+	home := GetHomeDir(platform)
+	storeHome := filepath.Join(*dir, "home")
+	ps := NewProfileSync(platform, platform, *dryRun, true, *verbose, false, false, *jobs)
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile, *profile)
+		if err != nil {
+			errorColor.Println("❌ Error loading config:", err)
+			os.Exit(1)
+		}
+		ps.mappings = cfg.Mappings
+		ps.secretStore = cfg.SecretStore
+	}
+	ignore, err := loadIgnoreFile(globalIgnorePath(*configFile))
+	if err != nil {
+		errorColor.Println("❌ Error reading ignore file:", err)
+		os.Exit(1)
+	}
+	ps.ignore = ignore
+	ps.tags, ps.skipTags = splitList(*tags), splitList(*skipTags)
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	if err := ps.CreateMigrationPlan(ctx, home, storeHome); err != nil {
+		errorColor.Println("❌ Error planning the capture:", err)
+		os.Exit(1)
+	}
+	ps.MarkMissingApps(home, false)
+
+	noticeColor.Printf("📸 Capturing %s into %s\n", home, storeHome)
+	var captured []capturedItem
+	failed, skipped, stale := 0, 0, 0
+	dropped := map[string]bool{}
+	now := time.Now()
+	for _, item := range ps.migrationPlan.Items {
+		if ctx.Err() != nil {
+			break
+		}
+		if _, err := os.Lstat(item.SourcePath); err != nil {
+			if *verbose {
+				fmt.Printf("   Not found: %s\n", item.Description)
+			}
+			continue
+		}
+		reason := item.SkipReason
+		switch {
+		case reason != "":
+		case *skipSecrets && item.Sensitivity == sensitivitySecret:
+			reason = "holds secrets"
+		case withinRoot(item.SourcePath, *dir):
+			reason = "holds the profile store"
+		}
+		if !item.AutoMigrate || reason != "" {
+			warnColor.Printf("⏭️  Skipped (%s): %s\n", reason, item.Description)
+			skipped++
+			continue
+		}
+		staleBecause := staleReason(item, time.Duration(*staleDays)*24*time.Hour, now)
+		if staleBecause != "" && *skipStale {
+			warnColor.Printf("🕸️  Skipped stale config (%s): %s\n", staleBecause, item.Description)
+			skipped++
+			if _, err := os.Lstat(item.DestinationPath); err != nil || !withinRoot(storeHome, item.DestinationPath) {
+				continue
+			}
+			if *dryRun {
+				fmt.Printf("Would drop the earlier capture at %s\n", item.DestinationPath)
+				continue
+			}
+			if err := os.RemoveAll(item.DestinationPath); err != nil {
+				errorColor.Printf("❌ Error dropping the earlier capture of %s: %v\n", item.Description, err)
+				failed++
+				continue
+			}
+			dropped[item.Mapping] = true
+			continue
+		}
+		if staleBecause != "" {
+			stale++
+		}
+		if *dryRun {
+			fmt.Printf("Would capture %s to %s\n", displayPath(item.SourcePath), item.DestinationPath)
+			if staleBecause != "" {
+				warnColor.Printf("   🕸️  Stale: %s\n", staleBecause)
+			}
+			continue
+		}
+		c, err := ps.captureItem(ctx, item, *dir)
+		if err != nil {
+			errorColor.Printf("❌ Error capturing %s: %v\n", item.Description, err)
+			failed++
+			continue
+		}
+		c.Stale = staleBecause
+		successColor.Printf("✅ Captured: %s (%s)\n", item.Description, formatSize(c.Size))
+		if staleBecause != "" {
+			warnColor.Printf("   🕸️  Stale: %s\n", staleBecause)
+		}
+		captured = append(captured, c)
+	}
+
+	if !*dryRun && len(captured)+len(dropped) > 0 {
+		m, err := loadCaptureManifest(*dir)
+		if err == nil {
+			m.forget(dropped)
+			m.record(captured)
+			err = m.save(*dir)
+		}
+		if err != nil {
+			warnColor.Println("⚠️  Could not update the capture manifest:", err)
+		}
+	}
+	if ctx.Err() != nil {
+		warnColor.Println("⛔ Capture interrupted")
+		os.Exit(130)
+	}
+	sort.Strings(targets)
+	noticeColor.Printf("%d configs captured, %d skipped, %d failed\n", len(captured), skipped, failed)
+	if stale > 0 {
+		warnColor.Printf("🕸️  %d configs look stale; use --skip-stale to leave them out\n", stale)
+	}
+
+	for _, target := range targets {
+		fmt.Println()
+		infoColor.Printf("📸 capture %s\n", target)
+		if *dryRun {
+			fmt.Printf("Would run `profilesync capture %s`\n", target)
+			continue
+		}
+		runCapture([]string{target, "--profile-dir", *dir})
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+	if !*dryRun {
+		noticeColor.Printf("Run `profilesync apply --source-dir %s` on another machine to migrate them\n", storeHome)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sensitivity levels of a known config
+const (
+	sensitivityNormal   = "normal"
+	sensitivityPersonal = "personal"
+	sensitivitySecret   = "secret"
+)
+
+// Merge strategies for a destination that already exists
+const (
+	mergeReplace = "replace"
+	mergeMerge   = "merge"
+	mergeKeep    = "keep"
+)
+
+//go:embed catalog.yaml
+var builtinCatalog []byte
+
+// CatalogEntry describes one known config. Empty fields in an override
+// keep the value they override
+type CatalogEntry struct {
+	Mapping     string            `yaml:"mapping" json:"mapping"`
+	Destination string            `yaml:"destination,omitempty" json:"destination,omitempty"`
+	Description string            `yaml:"description,omitempty" json:"description,omitempty"`
+	Type        string            `yaml:"type,omitempty" json:"type,omitempty"`
+	Sensitivity string            `yaml:"sensitivity,omitempty" json:"sensitivity,omitempty"`
+	Merge       string            `yaml:"merge,omitempty" json:"merge,omitempty"`
+	Symlinks    string            `yaml:"symlinks,omitempty" json:"symlinks,omitempty"`
+	Timeout     string            `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Path        string            `yaml:"path,omitempty" json:"path,omitempty"`
+	Paths       map[string]string `yaml:"paths,omitempty" json:"paths,omitempty"`
+	// Tags group configs for --tags, e.g. minimal or work. An override's
+	// tags replace those of the entry it overrides
+	Tags []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+var (
+	catalogOnce    sync.Once
+	catalogList    []CatalogEntry
+	catalogMu      sync.RWMutex
+	catalogIndexed map[string]int
+)
+
+// catalogEntries returns the catalog, reading the built-in one on first use
+func catalogEntries() []CatalogEntry {
+	catalogOnce.Do(func() {
+		var entries []CatalogEntry
+		if err := yaml.Unmarshal(builtinCatalog, &entries); err != nil {
+			panic("built-in catalog: " + err.Error())
+		}
+		catalogIndexed = map[string]int{}
+		if err := mergeCatalogEntries(entries); err != nil {
+			panic("built-in catalog: " + err.Error())
+		}
+	})
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	return catalogList
+}
+
+// addCatalogEntries adds new entries and merges overrides into existing ones
+func addCatalogEntries(entries []CatalogEntry) error {
+	catalogEntries()
+	return mergeCatalogEntries(entries)
+}
+
+// mergeCatalogEntries does the work of addCatalogEntries
+func mergeCatalogEntries(entries []CatalogEntry) error {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	for _, e := range entries {
+		if e.Mapping == "" {
+			return fmt.Errorf("catalog entry without a mapping")
+		}
+		i, ok := catalogIndexed[e.Mapping]
+		if !ok {
+			catalogIndexed[e.Mapping] = len(catalogList)
+			catalogList = append(catalogList, CatalogEntry{Mapping: e.Mapping})
+			i = len(catalogList) - 1
+		}
+		merged := catalogList[i]
+		for _, field := range []struct {
+			dst *string
+			src string
+		}{
+			{&merged.Destination, e.Destination},
+			{&merged.Description, e.Description},
+			{&merged.Type, e.Type},
+			{&merged.Sensitivity, e.Sensitivity},
+			{&merged.Merge, e.Merge},
+			{&merged.Symlinks, e.Symlinks},
+			{&merged.Timeout, e.Timeout},
+			{&merged.Path, e.Path},
+		} {
+			if field.src != "" {
+				*field.dst = field.src
+			}
+		}
+		if len(e.Tags) > 0 {
+			merged.Tags = e.Tags
+		}
+		for platform, path := range e.Paths {
+			if merged.Paths == nil {
+				merged.Paths = map[string]string{}
+			}
+			merged.Paths[platform] = path
+		}
+		if err := merged.validate(); err != nil {
+			return fmt.Errorf("catalog entry %s: %w", e.Mapping, err)
+		}
+		catalogList[i] = merged
+	}
+	return nil
+}
+
+// validate checks an entry's enumerated fields
+func (e CatalogEntry) validate() error {
+	switch e.Sensitivity {
+	case "", sensitivityNormal, sensitivityPersonal, sensitivitySecret:
+	default:
+		return fmt.Errorf("sensitivity must be normal, personal or secret, not %q", e.Sensitivity)
+	}
+	switch e.Merge {
+	case "", mergeReplace, mergeKeep:
+	case mergeMerge:
+		// Only directories and handled configs can take in existing content
+		if !strings.HasSuffix(e.Mapping, "/") && itemHandlers[e.Mapping].Copy == nil {
+			return fmt.Errorf("%s is a single file and cannot be merged", e.Mapping)
+		}
+	default:
+		return fmt.Errorf("merge must be replace, merge or keep, not %q", e.Merge)
+	}
+	if e.Symlinks != "" {
+		if err := validSymlinkPolicy(e.Symlinks); err != nil {
+			return err
+		}
+	}
+	if e.Timeout != "" {
+		if d, err := time.ParseDuration(e.Timeout); err != nil || d < 0 {
+			return fmt.Errorf("timeout must be a duration such as 2m, not %q", e.Timeout)
+		}
+	}
+	for platform := range e.Paths {
+		if platform != "linux" && platform != "macos" && platform != "windows" {
+			return fmt.Errorf("unknown platform %q in paths", platform)
+		}
+	}
+	for _, tag := range e.Tags {
+		if tag == "" || strings.ContainsAny(tag, ", \t") {
+			return fmt.Errorf("tags must be single words, not %q", tag)
+		}
+	}
+	return nil
+}
+
+// lookupCatalog returns the entry for a mapping
+func lookupCatalog(mapping string) (CatalogEntry, bool) {
+	catalogEntries()
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	if i, ok := catalogIndexed[mapping]; ok {
+		return catalogList[i], true
+	}
+	return CatalogEntry{}, false
+}
+
+// catalogType is the type of a mapping. Unknown mappings take the type of
+// a known one for the same tool, e.g. vim/colors/ from vim/.vimrc
+func catalogType(mapping string) string {
+	if e, ok := lookupCatalog(mapping); ok && e.Type != "" {
+		return e.Type
+	}
+	tool := mappingTool(mapping)
+	for _, e := range catalogEntries() {
+		if e.Type != "" && mappingTool(e.Mapping) == tool {
+			return e.Type
+		}
+	}
+	return "General"
+}
+
+// mappingTool is the first directory of a mapping after any variable
+func mappingTool(mapping string) string {
+	if _, rest, ok := splitPathVariable(mapping); ok {
+		mapping = strings.TrimLeft(rest, `/\`)
+	}
+	return strings.SplitN(filepath.ToSlash(mapping), "/", 2)[0]
+}
+
+// catalogDescription is the description of a mapping
+func catalogDescription(mapping string) string {
+	if e, ok := lookupCatalog(mapping); ok && e.Description != "" {
+		return e.Description
+	}
+	return "Configuration file"
+}
+
+// catalogSensitivity is the sensitivity of a mapping
+func catalogSensitivity(mapping string) string {
+	if e, ok := lookupCatalog(mapping); ok && e.Sensitivity != "" {
+		return e.Sensitivity
+	}
+	return sensitivityNormal
+}
+
+// catalogMerge is the merge strategy of a mapping
+func catalogMerge(mapping string) string {
+	if e, ok := lookupCatalog(mapping); ok && e.Merge != "" {
+		return e.Merge
+	}
+	return mergeReplace
+}
+
+// catalogTags are the tags of a mapping
+func catalogTags(mapping string) []string {
+	e, _ := lookupCatalog(mapping)
+	return e.Tags
+}
+
+// tagsSelected reports whether a config with tags is picked by --tags and
+// --skip-tags: it must have one of include, when any are given, and none
+// of exclude
+func tagsSelected(tags, include, exclude []string) bool {
+	has := func(wanted []string) bool {
+		for _, w := range wanted {
+			for _, tag := range tags {
+				if tag == w {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return (len(include) == 0 || has(include)) && !has(exclude)
+}
+
+// catalogPath returns where a mapping lives on a platform, as a mapping
+// relative to the home directory
+func catalogPath(mapping, platform string) string {
+	e, ok := lookupCatalog(mapping)
+	switch {
+	case !ok:
+		return mapping
+	case e.Paths[platform] != "":
+		return e.Paths[platform]
+	case e.Path != "":
+		return e.Path
+	}
+	return mapping
+}
+
+// runCatalog handles `profilesync catalog`, listing the known configs
+func runCatalog(args []string) {
+	flags := newFlagSet("catalog", "catalog [flags]")
+	format := flags.String("format", "table", "Output format: table, markdown or yaml")
+	tags := flags.String("tags", "", "Comma-separated tags; only list configs with one of them")
+	configFile := flags.String("config", "", "Config file whose catalog overrides to include (default "+configPath()+" if it exists)")
+	flags.Parse(args)
+
+	path := *configFile
+	if path == "" {
+		if _, err := os.Stat(configPath()); err == nil {
+			path = configPath()
+		}
+	}
+	if path != "" {
+		if _, err := loadConfig(path, ""); err != nil {
+			errorColor.Println("❌ Error loading config:", err)
+			os.Exit(1)
+		}
+	}
+
+	var entries []CatalogEntry
+	for _, e := range catalogEntries() {
+		if tagsSelected(e.Tags, splitList(*tags), nil) {
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if a, b := catalogType(entries[i].Mapping), catalogType(entries[j].Mapping); a != b {
+			return a < b
+		}
+		return entries[i].Mapping < entries[j].Mapping
+	})
+
+	switch *format {
+	case "yaml":
+		out, err := yaml.Marshal(entries)
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+	case "markdown":
+		fmt.Println("| Mapping | Description | Type | Sensitivity | Merge | Tags |")
+		fmt.Println("|---------|-------------|------|-------------|-------|------|")
+		for _, e := range entries {
+			fmt.Printf("| `%s` | %s | %s | %s | %s | %s |\n", e.Mapping, catalogDescription(e.Mapping), catalogType(e.Mapping), catalogSensitivity(e.Mapping), catalogMerge(e.Mapping), strings.Join(e.Tags, ", "))
+		}
+	case "table":
+		current := ""
+		for _, e := range entries {
+			if t := catalogType(e.Mapping); t != current {
+				current = t
+				infoColor.Printf("📁 %s\n", t)
+			}
+			marks := ""
+			if s := catalogSensitivity(e.Mapping); s != sensitivityNormal {
+				marks += " [" + s + "]"
+			}
+			if m := catalogMerge(e.Mapping); m != mergeReplace {
+				marks += " [" + m + "]"
+			}
+			for _, tag := range e.Tags {
+				marks += " #" + tag
+			}
+			fmt.Printf("   %-34s %s%s\n", e.Mapping, catalogDescription(e.Mapping), marks)
+		}
+	default:
+		errorColor.Println("❌ Invalid --format value:", *format)
+		errorColor.Println("Must be one of: table, markdown, yaml")
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChecklistItem is a manual follow-up the migration can detect but not do
+type ChecklistItem struct {
+	Title   string `json:"title"`
+	Detail  string `json:"detail,omitempty"`
+	Command string `json:"command,omitempty"`
+	Done    bool   `json:"done"`
+}
+
+// Checklist is saved after each live run so follow-ups can be ticked off later
+type Checklist struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Items     []ChecklistItem `json:"items"`
+}
+
+// checklistDetectors are keyed by source mapping and look at what a
+// migrated item left on the destination
+var checklistDetectors = map[string]func(item MigrationItem) []ChecklistItem{
+	"chrome/Default/":           browserSyncChecklist("Chrome"),
+	"chromium/Default/":         browserSyncChecklist("Chromium"),
+	"edge/Default/":             browserSyncChecklist("Edge"),
+	"brave/Default/":            browserSyncChecklist("Brave"),
+	"firefox/.mozilla/firefox/": firefoxChecklist,
+	"ssh/":                      sshChecklist,
+	"aws/config":                awsSSOChecklist,
+	"kubectl/config":            kubeAuthChecklist,
+	"docker/config.json":        dockerLoginChecklist,
+	"git/.gitconfig":            gitCredentialChecklist,
+	shellTranslateMapping:       shellTranslationChecklist,
+}
+
+// checklistPath is where the latest checklist is kept
+func checklistPath() string {
+	return filepath.Join(stateDir(), "checklist.json")
+}
+
+// buildChecklist runs the detectors for every migrated item
+func (ps *ProfileSync) buildChecklist() []ChecklistItem {
+	var items []ChecklistItem
+	for _, item := range ps.migrationPlan.Items {
+		detect, ok := checklistDetectors[item.Mapping]
+		if !ok || !item.Migrated {
+			continue
+		}
+		items = append(items, detect(item)...)
+	}
+	return items
+}
+
+// browserSyncChecklist covers what a Chromium profile copy leaves behind
+func browserSyncChecklist(browser string) func(MigrationItem) []ChecklistItem {
+	return func(MigrationItem) []ChecklistItem {
+		return []ChecklistItem{{
+			Title:  fmt.Sprintf("Sign in to %s sync", browser),
+			Detail: "Cookies and saved passwords were not migrated; websites will ask you to log in again",
+		}}
+	}
+}
+
+// firefoxChecklist reminds that Sync is tied to the old device
+func firefoxChecklist(MigrationItem) []ChecklistItem {
+	return []ChecklistItem{{
+		Title:  "Sign in to Firefox Sync",
+		Detail: "Sync is registered per device and has to be re-enabled on this machine",
+	}}
+}
+
+// sshChecklist lists the hosts whose keys will be asked for again, since
+// known_hosts is not migrated, and loads the migrated keys into the agent
+func sshChecklist(item MigrationItem) []ChecklistItem {
+	files := collectSSHFiles(filepath.Join(item.DestinationPath, "config"), filepath.Dir(item.DestinationPath))
+
+	var hosts []string
+	for _, config := range files.Configs {
+		data, err := os.ReadFile(config)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			key, args := sshDirective(line)
+			if key != "host" {
+				continue
+			}
+			for _, host := range args {
+				if !strings.ContainsAny(host, "*?!") {
+					hosts = append(hosts, host)
+				}
+			}
+		}
+	}
+
+	var items []ChecklistItem
+	if len(hosts) > 0 {
+		items = append(items, ChecklistItem{
+			Title:  fmt.Sprintf("Accept host keys for %d SSH host(s)", len(hosts)),
+			Detail: "Compare fingerprints on first connection: " + strings.Join(hosts, ", "),
+		})
+	}
+
+	var keys []string
+	seen := map[string]bool{}
+	for _, key := range files.Identities {
+		if _, err := os.Stat(key); err == nil && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > 0 {
+		items = append(items, ChecklistItem{
+			Title:   "Add your SSH keys to the agent",
+			Command: "ssh-add " + strings.Join(keys, " "),
+		})
+	}
+	return items
+}
+
+// shellTranslationChecklist asks for the translated rc file to be sourced,
+// unless fish loads it from conf.d, and for a look at what was left for
+// translating by hand
+func shellTranslationChecklist(item MigrationItem) []ChecklistItem {
+	data, err := os.ReadFile(item.DestinationPath)
+	if err != nil {
+		return nil
+	}
+	var items []ChecklistItem
+	if rc := map[string]string{".zsh": "~/.zshrc", ".sh": "~/.bashrc"}[filepath.Ext(item.DestinationPath)]; rc != "" {
+		items = append(items, ChecklistItem{
+			Title:   "Source the translated shell configuration",
+			Command: fmt.Sprintf("echo 'source %s' >> %s", item.DestinationPath, rc),
+		})
+	}
+	if n := strings.Count(string(data), shellManualMarker); n > 0 {
+		items = append(items, ChecklistItem{
+			Title:  fmt.Sprintf("Translate %d commented-out shell line(s) by hand", n),
+			Detail: item.DestinationPath,
+		})
+	}
+	return items
+}
+
+// awsSSOChecklist asks for a login to every SSO profile, whose cached
+// tokens stay on the old machine
+func awsSSOChecklist(item MigrationItem) []ChecklistItem {
+	sections, err := loadAWSFile(item.DestinationPath)
+	if err != nil {
+		return nil
+	}
+	var items []ChecklistItem
+	for _, s := range sections {
+		name := awsProfileName(s.Name, true)
+		if name == "" || (s.Get("sso_session") == "" && s.Get("sso_start_url") == "") {
+			continue
+		}
+		items = append(items, ChecklistItem{
+			Title:   fmt.Sprintf("Log in to AWS SSO for profile %s", name),
+			Command: "aws sso login --profile " + name,
+		})
+	}
+	return items
+}
+
+// kubeAuthChecklist flags kube users whose credentials come from a plugin
+func kubeAuthChecklist(item MigrationItem) []ChecklistItem {
+	cfg, err := loadKubeconfig(item.DestinationPath)
+	if err != nil {
+		return nil
+	}
+	var items []ChecklistItem
+	for _, user := range cfg.Users {
+		spec, _ := user.Rest["user"].(map[string]interface{})
+		if execSpec, ok := spec["exec"].(map[string]interface{}); ok {
+			command, _ := execSpec["command"].(string)
+			if _, err := exec.LookPath(command); command != "" && err != nil {
+				items = append(items, ChecklistItem{
+					Title:  fmt.Sprintf("Install %s for kube user %s", command, user.Name),
+					Detail: "kubectl runs it to get credentials",
+				})
+			}
+		}
+		if _, ok := spec["auth-provider"]; ok {
+			items = append(items, ChecklistItem{
+				Title:  fmt.Sprintf("Re-authenticate kube user %s", user.Name),
+				Detail: "auth-provider tokens expire and are refreshed by the provider's CLI",
+			})
+		}
+	}
+	return items
+}
+
+// dockerLoginChecklist asks for a login to every registry whose credentials
+// live in a credential helper on the old machine
+func dockerLoginChecklist(item MigrationItem) []ChecklistItem {
+	data, err := os.ReadFile(item.DestinationPath)
+	if err != nil {
+		return nil
+	}
+	var cfg struct {
+		Auths       map[string]json.RawMessage `json:"auths"`
+		CredsStore  string                     `json:"credsStore"`
+		CredHelpers map[string]string          `json:"credHelpers"`
+	}
+	if json.Unmarshal(data, &cfg) != nil {
+		return nil
+	}
+
+	registries := map[string]bool{}
+	if cfg.CredsStore != "" {
+		for registry := range cfg.Auths {
+			registries[registry] = true
+		}
+	}
+	for registry := range cfg.CredHelpers {
+		registries[registry] = true
+	}
+
+	var items []ChecklistItem
+	for registry := range registries {
+		items = append(items, ChecklistItem{
+			Title:   "Log in to Docker registry " + registry,
+			Command: "docker login " + registry,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Title < items[j].Title })
+	return items
+}
+
+// gitCredentialChecklist notes that a keychain-backed git credential
+// helper starts out empty
+func gitCredentialChecklist(item MigrationItem) []ChecklistItem {
+	data, err := os.ReadFile(item.DestinationPath)
+	if err != nil {
+		return nil
+	}
+	for _, s := range parseINI(data) {
+		if s.Name != "credential" && !strings.HasPrefix(s.Name, "credential ") {
+			continue
+		}
+		if helper := s.Get("helper"); helper != "" {
+			return []ChecklistItem{{
+				Title:  "Re-authenticate to your git hosts",
+				Detail: fmt.Sprintf("The %q credential helper keeps passwords and tokens on the old machine", helper),
+			}}
+		}
+	}
+	return nil
+}
+
+// saveChecklist stores items as the current checklist
+func saveChecklist(items []ChecklistItem) error {
+	data, err := json.MarshalIndent(Checklist{CreatedAt: time.Now().UTC(), Items: items}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeStateFile(checklistPath(), data, 0600)
+}
+
+// loadChecklist reads the current checklist
+func loadChecklist() (*Checklist, error) {
+	data, err := readStateFile(checklistPath())
+	if err != nil {
+		return nil, err
+	}
+	var c Checklist
+	return &c, json.Unmarshal(data, &c)
+}
+
+// printChecklist lists the items, numbered from 1
+func printChecklist(items []ChecklistItem) {
+	for i, item := range items {
+		box := "[ ]"
+		color := noticeColor
+		if item.Done {
+			box = "[x]"
+			color = successColor
+		}
+		color.Printf("%2d. %s %s\n", i+1, box, item.Title)
+		if item.Detail != "" {
+			fmt.Printf("         %s\n", item.Detail)
+		}
+		if item.Command != "" {
+			fmt.Printf("         $ %s\n", item.Command)
+		}
+	}
+}
+
+// writeChecklist builds, saves and prints the follow-ups after a live run
+func (ps *ProfileSync) writeChecklist() {
+	items := ps.buildChecklist()
+	if len(items) == 0 {
+		return
+	}
+	if err := saveChecklist(items); err != nil {
+		warnColor.Println("⚠️  Could not save checklist:", err)
+	}
+
+	infoColor.Println("📋 Still to do by hand:")
+	printChecklist(items)
+	fmt.Println("Tick items off with `profilesync checklist done N` or `profilesync checklist review`.")
+}
+
+// runChecklist handles `profilesync checklist [done N...|review]`
+func runChecklist(args []string) {
+	checklist, err := loadChecklist()
+	if os.IsNotExist(err) {
+		successColor.Println("✅ Nothing to follow up")
+		return
+	} else if err != nil {
+		errorColor.Println("❌ Error reading checklist:", err)
+		os.Exit(1)
+	}
+
+	if len(args) == 0 {
+		printChecklist(checklist.Items)
+		return
+	}
+
+	switch args[0] {
+	case "done":
+		for _, arg := range args[1:] {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > len(checklist.Items) {
+				errorColor.Println("❌ No checklist item", arg)
+				os.Exit(2)
+			}
+			checklist.Items[n-1].Done = true
+		}
+	case "review":
+		for i := range checklist.Items {
+			item := &checklist.Items[i]
+			if item.Done {
+				continue
+			}
+			noticeColor.Printf("%d. %s\n", i+1, item.Title)
+			if item.Command != "" {
+				fmt.Printf("   $ %s\n", item.Command)
+			}
+			item.Done = confirm("   Done?", false)
+		}
+	default:
+		errorColor.Println("❌ Unknown checklist command:", args[0])
+		errorColor.Println("Must be one of: done, review")
+		os.Exit(2)
+	}
+
+	if err := saveChecklist(checklist.Items); err != nil {
+		errorColor.Println("❌ Error saving checklist:", err)
+		os.Exit(1)
+	}
+	printChecklist(checklist.Items)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// checkpointVersion is bumped whenever the checkpoint format changes
+	checkpointVersion = 1
+
+	// checkpointInterval throttles how often progress is flushed to disk
+	checkpointInterval = time.Second
+
+	// resumeChunkSize is how much of a file is copied between checkpoints
+	resumeChunkSize = 64 << 20
+)
+
+// Checkpoint records the progress of a live migration so an interrupted run
+// can be resumed with `profilesync apply --resume`
+type Checkpoint struct {
+	Version             int              `json:"version"`
+	SourcePlatform      string           `json:"source_platform"`
+	DestinationPlatform string           `json:"destination_platform"`
+	SourceBase          string           `json:"source_base"`
+	DestinationBase     string           `json:"destination_base"`
+	Started             map[string]bool  `json:"started"`
+	Completed           map[string]bool  `json:"completed"`
+	Files               map[string]int64 `json:"files"`
+	UpdatedAt           time.Time        `json:"updated_at"`
+
+	path      string
+	mu        sync.Mutex
+	lastWrite time.Time
+}
+
+// checkpointPath returns the location of the checkpoint file
+func checkpointPath() string {
+	return filepath.Join(stateDir(), "checkpoint.json")
+}
+
+// newCheckpoint starts an empty checkpoint for a migration
+func newCheckpoint(path, source, dest, sourceBase, destBase string) *Checkpoint {
+	return &Checkpoint{
+		Version:             checkpointVersion,
+		SourcePlatform:      source,
+		DestinationPlatform: dest,
+		SourceBase:          sourceBase,
+		DestinationBase:     destBase,
+		Started:             map[string]bool{},
+		Completed:           map[string]bool{},
+		Files:               map[string]int64{},
+		path:                path,
+	}
+}
+
+// loadCheckpoint reads a checkpoint, returning os.ErrNotExist when there is none
+func loadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := readStateFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("corrupt checkpoint %s: %w", path, err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint %s has unsupported version %d", path, cp.Version)
+	}
+	if cp.Started == nil {
+		cp.Started = map[string]bool{}
+	}
+	if cp.Completed == nil {
+		cp.Completed = map[string]bool{}
+	}
+	if cp.Files == nil {
+		cp.Files = map[string]int64{}
+	}
+	cp.path = path
+
+	return &cp, nil
+}
+
+// matches reports whether the checkpoint belongs to the given migration
+func (cp *Checkpoint) matches(source, dest, sourceBase, destBase string) bool {
+	return cp.SourcePlatform == source && cp.DestinationPlatform == dest &&
+		cp.SourceBase == sourceBase && cp.DestinationBase == destBase
+}
+
+// started reports whether an item began copying in an earlier run
+func (cp *Checkpoint) started(id string) bool {
+	if cp == nil {
+		return false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.Started[id]
+}
+
+// completed reports whether an item finished in an earlier run
+func (cp *Checkpoint) completed(id string) bool {
+	if cp == nil {
+		return false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.Completed[id]
+}
+
+// start marks an item as in flight
+func (cp *Checkpoint) start(id string) error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Started[id] = true
+	return cp.saveLocked()
+}
+
+// complete marks an item as finished and forgets its per-file progress
+func (cp *Checkpoint) complete(id, destPath string) error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Completed[id] = true
+	delete(cp.Started, id)
+	for dst := range cp.Files {
+		if dst == destPath || strings.HasPrefix(dst, destPath+string(filepath.Separator)) {
+			delete(cp.Files, dst)
+		}
+	}
+	return cp.saveLocked()
+}
+
+// fileProgress records that the first n bytes of dst are durably written
+func (cp *Checkpoint) fileProgress(dst string, n int64) error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Files[dst] = n
+	if time.Since(cp.lastWrite) < checkpointInterval {
+		return nil
+	}
+	return cp.saveLocked()
+}
+
+// resumeOffset returns how much of dst can be kept from an earlier run.
+// done is true when dst was already fully copied
+func (cp *Checkpoint) resumeOffset(dst string, srcSize int64) (offset int64, done bool) {
+	if cp == nil {
+		return 0, false
+	}
+	cp.mu.Lock()
+	recorded, ok := cp.Files[dst]
+	cp.mu.Unlock()
+	if !ok || recorded <= 0 || recorded > srcSize {
+		return 0, false
+	}
+
+	// Only trust the record if the bytes it describes are still on disk: in
+	// the temp file until the copy is renamed into place, then in dst
+	if info, err := os.Stat(tempPath(dst)); err == nil {
+		if info.Size() < recorded {
+			return 0, false
+		}
+		return recorded, false
+	}
+	info, err := os.Stat(dst)
+	if err != nil || info.Size() != srcSize {
+		return 0, false
+	}
+	return recorded, recorded == srcSize
+}
+
+// save flushes the checkpoint to disk
+func (cp *Checkpoint) save() error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.saveLocked()
+}
+
+// saveLocked writes the checkpoint atomically; cp.mu must be held
+func (cp *Checkpoint) saveLocked() error {
+	cp.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeStateFile(cp.path, data, 0600); err != nil {
+		return err
+	}
+
+	cp.lastWrite = time.Now()
+	return nil
+}
+
+// remove deletes the checkpoint once a migration has fully succeeded
+func (cp *Checkpoint) remove() error {
+	if cp == nil {
+		return nil
+	}
+	if err := os.Remove(cp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// openCheckpoint loads the checkpoint to resume from, or starts a fresh one
+func (ps *ProfileSync) openCheckpoint(sourceBase, destBase string) error {
+	path := checkpointPath()
+	existing, err := loadCheckpoint(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		if ps.resume {
+			return err
+		}
+		warnColor.Printf("⚠️  Ignoring unreadable checkpoint: %v\n", err)
+	}
+
+	if ps.resume {
+		if existing == nil {
+			return fmt.Errorf("no interrupted migration to resume (looked for %s)", path)
+		}
+		if !existing.matches(ps.sourcePlatform, ps.destPlatform, sourceBase, destBase) {
+			return fmt.Errorf("checkpoint is for a %s → %s migration from %s to %s; rerun with the same settings or without --resume",
+				existing.SourcePlatform, existing.DestinationPlatform, existing.SourceBase, existing.DestinationBase)
+		}
+		noticeColor.Printf("↪️  Resuming migration: %d items already done\n", len(existing.Completed))
+		ps.checkpoint = existing
+		return nil
+	}
+
+	if existing != nil {
+		warnColor.Println("⚠️  Discarding checkpoint from an interrupted migration (use --resume to continue it)")
+		// Partial copies only --resume could have finished
+		if n := removeTempFiles(sortedKeys(existing.Files)); n > 0 && ps.verbose {
+			noticeColor.Printf("🧹 Removed %d partial copies from the interrupted migration\n", n)
+		}
+	}
+	ps.checkpoint = newCheckpoint(path, ps.sourcePlatform, ps.destPlatform, sourceBase, destBase)
+	return ps.checkpoint.save()
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// chezmoiSourceDir is where chezmoi keeps its source state by default
+func chezmoiSourceDir(home string) string {
+	return filepath.Join(home, ".local", "share", "chezmoi")
+}
+
+// chezmoiEntry is a source state name decoded into its target name and
+// the attributes its prefixes and suffixes set
+type chezmoiEntry struct {
+	name       string
+	kind       string // file, dir, symlink, script, modify, remove, external or encrypted
+	private    bool
+	readonly   bool
+	executable bool
+	empty      bool
+	template   bool
+}
+
+// chezmoiPrefixes are the attribute prefixes of source state names, with
+// the kind of entry they make, or "" for plain attributes
+var chezmoiPrefixes = []struct{ prefix, kind string }{
+	{"create_", ""}, {"exact_", ""}, {"modify_", "modify"}, {"remove_", "remove"},
+	{"run_", "script"}, {"symlink_", "symlink"}, {"external_", "external"},
+	{"encrypted_", "encrypted"}, {"private_", ""}, {"readonly_", ""},
+	{"empty_", ""}, {"executable_", ""}, {"once_", ""}, {"onchange_", ""},
+	{"before_", ""}, {"after_", ""},
+}
+
+// parseChezmoiName decodes the name of a file or directory in chezmoi's
+// source state, e.g. private_dot_ssh or executable_dot_profile.tmpl
+func parseChezmoiName(name string, dir bool) chezmoiEntry {
+	e := chezmoiEntry{kind: "file"}
+	if dir {
+		e.kind = "dir"
+	} else if strings.HasSuffix(name, ".literal") {
+		name = strings.TrimSuffix(name, ".literal")
+	} else if strings.HasSuffix(name, ".tmpl") {
+		name = strings.TrimSuffix(name, ".tmpl")
+		e.template = true
+	}
+	for {
+		if rest, ok := strings.CutPrefix(name, "literal_"); ok {
+			e.name = rest
+			return e
+		}
+		matched := false
+		for _, p := range chezmoiPrefixes {
+			if rest, ok := strings.CutPrefix(name, p.prefix); ok {
+				name, matched = rest, true
+				if p.kind != "" {
+					e.kind = p.kind
+				}
+				switch p.prefix {
+				case "private_":
+					e.private = true
+				case "readonly_":
+					e.readonly = true
+				case "executable_":
+					e.executable = true
+				case "empty_":
+					e.empty = true
+				}
+				break
+			}
+		}
+		if !matched {
+			break
+		}
+	}
+	if rest, ok := strings.CutPrefix(name, "dot_"); ok {
+		name = "." + rest
+	}
+	e.name = name
+	return e
+}
+
+// mode is the permissions chezmoi gives the target of an entry
+func (e chezmoiEntry) mode() fs.FileMode {
+	perm := fs.FileMode(0644)
+	if e.kind == "dir" || e.executable {
+		perm = 0755
+	}
+	if e.private {
+		perm &^= 0077
+	}
+	if e.readonly {
+		perm &^= 0222
+	}
+	if e.kind == "dir" {
+		return fs.ModeDir | perm
+	}
+	return perm
+}
+
+// chezmoiImport is the state of one import of a chezmoi source directory
+type chezmoiImport struct {
+	src       string
+	data      map[string]any
+	templates *template.Template
+	ignore    []*regexp.Regexp
+	keep      []*regexp.Regexp
+	put       importPut
+	rendered  int
+	skipped   []string
+}
+
+// importChezmoi converts a chezmoi source directory. Names are decoded,
+// templates are rendered for this machine, and .chezmoiignore is honoured;
+// scripts, modify scripts and encrypted files are reported and left out
+func importChezmoi(dir, home string, put importPut) error {
+	src := dir
+	if root, err := os.ReadFile(filepath.Join(dir, ".chezmoiroot")); err == nil {
+		src = filepath.Join(dir, strings.TrimSpace(string(root)))
+	}
+	imp := &chezmoiImport{src: src, put: put, data: chezmoiData(src, home)}
+	imp.templates = template.New("chezmoi").Funcs(chezmoiFuncs(src)).Option("missingkey=error")
+	if err := imp.loadTemplates(); err != nil {
+		return err
+	}
+	if err := imp.loadIgnore(); err != nil {
+		return err
+	}
+	if err := imp.walk(src, ""); err != nil {
+		return err
+	}
+
+	if imp.rendered > 0 {
+		noticeColor.Printf("🧩 Rendered %d templates for this machine\n", imp.rendered)
+	}
+	if len(imp.skipped) > 0 {
+		warnColor.Printf("⚠️  Left out %d entries profilesync cannot import:\n", len(imp.skipped))
+		for _, s := range imp.skipped {
+			warnColor.Printf("   %s\n", s)
+		}
+	}
+	return nil
+}
+
+// loadTemplates adds the shared templates in .chezmoitemplates, which
+// others use with {{ template "name" . }}
+func (imp *chezmoiImport) loadTemplates() error {
+	dir := filepath.Join(imp.src, ".chezmoitemplates")
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if _, err := imp.templates.New(filepath.ToSlash(rel)).Parse(string(data)); err != nil {
+			return fmt.Errorf(".chezmoitemplates/%s: %w", filepath.ToSlash(rel), err)
+		}
+		return nil
+	})
+}
+
+// loadIgnore reads .chezmoiignore, itself a template, whose patterns match
+// target paths; ! patterns bring back what others ignore
+func (imp *chezmoiImport) loadIgnore() error {
+	path := filepath.Join(imp.src, ".chezmoiignore")
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	data, err := imp.render(path)
+	if err != nil {
+		return fmt.Errorf(".chezmoiignore: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list := &imp.ignore
+		if rest, ok := strings.CutPrefix(line, "!"); ok {
+			list, line = &imp.keep, rest
+		}
+		re, err := regexp.Compile("^" + globRegexp(strings.Trim(line, "/")) + "$")
+		if err != nil {
+			return fmt.Errorf(".chezmoiignore: invalid pattern %q", line)
+		}
+		*list = append(*list, re)
+	}
+	return scanner.Err()
+}
+
+// ignored reports whether .chezmoiignore leaves out a target path
+func (imp *chezmoiImport) ignored(rel string) bool {
+	for _, re := range imp.keep {
+		if re.MatchString(rel) {
+			return false
+		}
+	}
+	for _, re := range imp.ignore {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// walk imports the entries of the source directory dir, whose target is
+// rel below the home directory
+func (imp *chezmoiImport) walk(dir, rel string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		// chezmoi keeps its own files, and ignores everything else, whose
+		// name starts with a dot
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		e := parseChezmoiName(entry.Name(), entry.IsDir())
+		target := e.name
+		if rel != "" {
+			target = rel + "/" + e.name
+		}
+		if imp.ignored(target) {
+			continue
+		}
+		if err := imp.entry(path, target, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry imports one entry of the source state
+func (imp *chezmoiImport) entry(path, target string, e chezmoiEntry) error {
+	source, _ := filepath.Rel(imp.src, path)
+	switch e.kind {
+	case "dir":
+		if err := imp.put(target, nil, e.mode(), ""); err != nil {
+			return err
+		}
+		return imp.walk(path, target)
+	case "script":
+		imp.skipped = append(imp.skipped, source+": script, not run")
+		return nil
+	case "modify":
+		imp.skipped = append(imp.skipped, source+": modify script")
+		return nil
+	case "remove":
+		return nil
+	case "external":
+		imp.skipped = append(imp.skipped, source+": external, fetched by chezmoi")
+		return nil
+	case "encrypted":
+		imp.skipped = append(imp.skipped, source+": encrypted; run `chezmoi decrypt` on it first")
+		return nil
+	}
+
+	var data []byte
+	var err error
+	if e.template {
+		data, err = imp.render(path)
+		if err != nil {
+			imp.skipped = append(imp.skipped, fmt.Sprintf("%s: %v", source, err))
+			return nil
+		}
+		imp.rendered++
+	} else if data, err = os.ReadFile(path); err != nil {
+		return err
+	}
+
+	if e.kind == "symlink" {
+		return imp.put(target, nil, fs.ModeSymlink, strings.TrimSpace(string(data)))
+	}
+	// chezmoi removes files that end up empty unless they are empty_
+	if len(data) == 0 && !e.empty {
+		return nil
+	}
+	return imp.put(target, data, e.mode(), "")
+}
+
+// render executes a template of the source state with chezmoi's data
+func (imp *chezmoiImport) render(path string) ([]byte, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := imp.templates.Clone()
+	if err == nil {
+		t, err = t.New(filepath.Base(path)).Parse(string(text))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, imp.data); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// chezmoiData is what templates see: .chezmoi describing this machine, and
+// the user's data from .chezmoidata files and chezmoi's config file
+func chezmoiData(src, home string) map[string]any {
+	data := map[string]any{}
+	for _, name := range []string{".chezmoidata.json", ".chezmoidata.yaml", ".chezmoidata.yml", ".chezmoidata.toml"} {
+		mergeChezmoiData(data, filepath.Join(src, name))
+	}
+	if entries, err := os.ReadDir(filepath.Join(src, ".chezmoidata")); err == nil {
+		for _, e := range entries {
+			mergeChezmoiData(data, filepath.Join(src, ".chezmoidata", e.Name()))
+		}
+	}
+	configDir := filepath.Join(home, ".config", "chezmoi")
+	for _, name := range []string{"chezmoi.json", "chezmoi.yaml", "chezmoi.yml", "chezmoi.toml"} {
+		config := map[string]any{}
+		mergeChezmoiData(config, filepath.Join(configDir, name))
+		if d, ok := config["data"].(map[string]any); ok {
+			mergeMaps(data, d)
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	username := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+	short, _, _ := strings.Cut(hostname, ".")
+	data["chezmoi"] = map[string]any{
+		"os":           runtime.GOOS,
+		"arch":         runtime.GOARCH,
+		"hostname":     short,
+		"fqdnHostname": hostname,
+		"username":     username,
+		"homeDir":      home,
+		"sourceDir":    src,
+		"osRelease":    osRelease(),
+	}
+	return data
+}
+
+// mergeChezmoiData merges a JSON, YAML or TOML data file into data
+func mergeChezmoiData(data map[string]any, path string) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	parsed := map[string]any{}
+	switch filepath.Ext(path) {
+	case ".json":
+		err = json.Unmarshal(raw, &parsed)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &parsed)
+	case ".toml":
+		parsed = parseSimpleTOML(raw)
+	}
+	if err != nil {
+		warnColor.Printf("⚠️  Ignoring %s: %v\n", path, err)
+		return
+	}
+	mergeMaps(data, parsed)
+}
+
+// mergeMaps merges src into dst, recursing into tables both have
+func mergeMaps(dst, src map[string]any) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]any); ok {
+			if existing, ok := dst[k].(map[string]any); ok {
+				mergeMaps(existing, sub)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// parseSimpleTOML reads the tables and key = value pairs of a TOML file
+// whose values are strings, numbers and booleans, which is what chezmoi
+// data usually holds. Anything else is skipped
+func parseSimpleTOML(raw []byte) map[string]any {
+	root := map[string]any{}
+	table := root
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") && !strings.HasPrefix(line, "[[") {
+			table = root
+			for _, key := range strings.Split(strings.Trim(line, "[]"), ".") {
+				key = strings.Trim(strings.TrimSpace(key), `"`)
+				next, ok := table[key].(map[string]any)
+				if !ok {
+					next = map[string]any{}
+					table[key] = next
+				}
+				table = next
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, `"`):
+			if end := strings.LastIndex(value, `"`); end > 0 {
+				if s, err := strconv.Unquote(value[:end+1]); err == nil {
+					table[key] = s
+				}
+			}
+		case strings.HasPrefix(value, "'"):
+			if end := strings.LastIndex(value, "'"); end > 0 {
+				table[key] = value[1:end]
+			}
+		case value == "true" || value == "false":
+			table[key] = value == "true"
+		default:
+			value, _, _ = strings.Cut(value, "#")
+			value = strings.TrimSpace(value)
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				table[key] = n
+			} else if f, err := strconv.ParseFloat(value, 64); err == nil {
+				table[key] = f
+			}
+		}
+	}
+	return root
+}
+
+// osRelease reads /etc/os-release with keys in chezmoi's camel case, so
+// VERSION_ID becomes versionID
+func osRelease() map[string]any {
+	release := map[string]any{}
+	data, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return release
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if s, err := strconv.Unquote(value); err == nil {
+			value = s
+		}
+		words := strings.Split(strings.ToLower(key), "_")
+		for i := 1; i < len(words); i++ {
+			if words[i] == "id" {
+				words[i] = "ID"
+			} else if words[i] != "" {
+				words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+			}
+		}
+		release[strings.Join(words, "")] = value
+	}
+	return release
+}
+
+// chezmoiFuncs are the template functions chezmoi templates use most,
+// from chezmoi itself and the sprig library it includes
+func chezmoiFuncs(src string) template.FuncMap {
+	return template.FuncMap{
+		"env":        os.Getenv,
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"quote":      func(v any) string { return strconv.Quote(fmt.Sprint(v)) },
+		"squote":     func(v any) string { return "'" + fmt.Sprint(v) + "'" },
+		"list":       func(v ...any) []any { return v },
+		"joinPath":   func(elem ...string) string { return filepath.Join(elem...) },
+		"join": func(sep string, v any) string {
+			var parts []string
+			rv := reflect.ValueOf(v)
+			if rv.Kind() == reflect.Slice {
+				for i := 0; i < rv.Len(); i++ {
+					parts = append(parts, fmt.Sprint(rv.Index(i).Interface()))
+				}
+			}
+			return strings.Join(parts, sep)
+		},
+		"default": func(def any, v ...any) any {
+			if len(v) == 0 || v[0] == nil {
+				return def
+			}
+			rv := reflect.ValueOf(v[0])
+			switch rv.Kind() {
+			case reflect.Slice, reflect.Map, reflect.String:
+				if rv.Len() == 0 {
+					return def
+				}
+			default:
+				if rv.IsZero() {
+					return def
+				}
+			}
+			return v[0]
+		},
+		"lookPath": func(name string) string {
+			path, _ := exec.LookPath(name)
+			return path
+		},
+		"include": func(name string) (string, error) {
+			data, err := os.ReadFile(filepath.Join(src, filepath.FromSlash(name)))
+			return string(data), err
+		},
+	}
+}
+
+// chezmoiName encodes a file or directory name for chezmoi's source state
+func chezmoiName(name string, mode fs.FileMode, empty bool) string {
+	var prefix string
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		prefix = "symlink_"
+	default:
+		if mode.Perm()&0077 == 0 {
+			prefix += "private_"
+		}
+		if mode.Perm()&0222 == 0 {
+			prefix += "readonly_"
+		}
+		if mode.IsRegular() && empty {
+			prefix += "empty_"
+		}
+		if mode.IsRegular() && mode.Perm()&0111 != 0 {
+			prefix += "executable_"
+		}
+	}
+
+	base := name
+	if rest, ok := strings.CutPrefix(name, "."); ok {
+		base = "dot_" + rest
+	} else if parseChezmoiName(name, true).name != name || strings.HasPrefix(name, "literal_") {
+		// The name would be read as attributes
+		base = "literal_" + name
+	}
+	if !mode.IsDir() && (strings.HasSuffix(name, ".tmpl") || strings.HasSuffix(name, ".literal")) {
+		base += ".literal"
+	}
+	return prefix + base
+}
+
+// exportChezmoi writes the profile store's home as a chezmoi source
+// directory, with names carrying each file's permissions
+func exportChezmoi(storeHome, dir string, filter storeFilter) (int, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return 0, err
+	}
+	// Where each directory of the store ended up, as names change
+	encoded := map[string]string{".": dir}
+	written := 0
+	err := filepath.WalkDir(storeHome, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(storeHome, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if isTempFile(path) || d.Name() == ignoreFileName {
+			return nil
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		parent, ok := encoded[filepath.Dir(rel)]
+		if !ok {
+			return nil
+		}
+		target := filepath.Join(parent, chezmoiName(d.Name(), info.Mode(), info.Size() == 0))
+
+		switch {
+		case d.IsDir():
+			encoded[rel] = target
+			return os.MkdirAll(target, 0700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			written++
+			return writeFileAtomic(target, []byte(link), 0644)
+		case info.Mode().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			data, keep := filter(path, data)
+			if !keep {
+				return nil
+			}
+			written++
+			// Private files stay private in the source directory too
+			perm := fs.FileMode(0644)
+			if info.Mode().Perm()&0077 == 0 {
+				perm = 0600
+			}
+			return writeFileAtomic(target, data, perm)
+		}
+		return nil
+	})
+	return written, err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// chromiumUserData is where each Chromium-based browser keeps its profiles,
+// relative to the home directory
+var chromiumUserData = map[string]map[string]string{
+	"chrome": {
+		"linux":   ".config/google-chrome",
+		"macos":   "Library/Application Support/Google/Chrome",
+		"windows": "AppData/Local/Google/Chrome/User Data",
+	},
+	"chromium": {
+		"linux":   ".config/chromium",
+		"macos":   "Library/Application Support/Chromium",
+		"windows": "AppData/Local/Chromium/User Data",
+	},
+	"edge": {
+		"linux":   ".config/microsoft-edge",
+		"macos":   "Library/Application Support/Microsoft Edge",
+		"windows": "AppData/Local/Microsoft/Edge/User Data",
+	},
+	"brave": {
+		"linux":   ".config/BraveSoftware/Brave-Browser",
+		"macos":   "Library/Application Support/BraveSoftware/Brave-Browser",
+		"windows": "AppData/Local/BraveSoftware/Brave-Browser/User Data",
+	},
+}
+
+// chromiumCaches are regenerated by the browser and can run to gigabytes
+var chromiumCaches = map[string]bool{
+	"Cache":                       true,
+	"Code Cache":                  true,
+	"GPUCache":                    true,
+	"DawnCache":                   true,
+	"DawnGraphiteCache":           true,
+	"DawnWebGPUCache":             true,
+	"GrShaderCache":               true,
+	"ShaderCache":                 true,
+	"Media Cache":                 true,
+	"Application Cache":           true,
+	"Service Worker/CacheStorage": true,
+	"Service Worker/ScriptCache":  true,
+	"blob_storage":                true,
+	"VideoDecodeStats":            true,
+	"LOCK":                        true,
+	"lockfile":                    true,
+}
+
+// chromiumMachineBound are encrypted with a key held in the source machine's
+// keychain (DPAPI, Keychain or libsecret), or signed with a machine ID, and
+// are unreadable or reset elsewhere
+var chromiumMachineBound = map[string]bool{
+	"Cookies":                          true,
+	"Cookies-journal":                  true,
+	"Network/Cookies":                  true,
+	"Network/Cookies-journal":          true,
+	"Login Data":                       true,
+	"Login Data-journal":               true,
+	"Login Data For Account":           true,
+	"Login Data For Account-journal":   true,
+	"Trust Tokens":                     true,
+	"Network/Trust Tokens":             true,
+	"Secure Preferences":               true,
+	"Network/Device Bound Sessions":    true,
+	"Network/Reporting and NEL":        true,
+	"Safe Browsing Network":            true,
+	"Network/Network Persistent State": true,
+}
+
+// chromiumLocate returns a Locate function for a browser's Default profile
+func chromiumLocate(browser string) func(platform, home string) string {
+	return func(platform, home string) string {
+		dirs := chromiumUserData[browser]
+		rel, ok := dirs[platform]
+		if !ok {
+			rel = dirs["linux"]
+		}
+		return filepath.Join(home, filepath.FromSlash(rel), "Default")
+	}
+}
+
+// chromiumSource is what copyChromiumProfile copies: the profile, less
+// its caches and machine-bound data
+func (ps *ProfileSync) chromiumSource(item MigrationItem) (string, func(rel string) bool, error) {
+	return item.SourcePath, func(rel string) bool {
+		return chromiumMachineBound[rel] || chromiumCaches[rel]
+	}, nil
+}
+
+// copyChromiumProfile copies bookmarks, preferences, extensions and history
+// from a Chromium-based browser profile, leaving caches and machine-bound
+// encrypted data behind
+func (ps *ProfileSync) copyChromiumProfile(ctx context.Context, item MigrationItem) error {
+	var dropped []string
+	var mu sync.Mutex
+	jobs, err := ps.collectCopyJobs(ctx, item.SourcePath, item.DestinationPath, item.Symlinks, func(rel string) bool {
+		if chromiumMachineBound[rel] {
+			mu.Lock()
+			dropped = append(dropped, rel)
+			mu.Unlock()
+			return true
+		}
+		return chromiumCaches[rel]
+	})
+	if err != nil {
+		return err
+	}
+
+	err = ps.copyParallel(ctx, jobs, func(done, total int) {
+		ps.progress.setFiles(done, total)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(dropped) > 0 {
+		sort.Strings(dropped)
+		warnColor.Printf("⚠️  %s: cookies and saved passwords are encrypted with the source machine's keychain and were not copied\n", item.Description)
+		warnColor.Println("   Sign in to browser sync or export passwords from the password manager before retiring the old machine")
+		if ps.verbose {
+			for _, rel := range dropped {
+				noticeColor.Printf("   ⏭️  %s\n", filepath.Join(item.SourcePath, filepath.FromSlash(rel)))
+			}
+		}
+	}
+	return nil
+}
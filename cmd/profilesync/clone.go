@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// dotfilesLayouts are the repository layouts clone recognizes
+var dotfilesLayouts = []string{"bare", "chezmoi", "dotbot", "plain", "stow", "topical"}
+
+// dotfilesHosts are the forges clone takes OWNER/REPO shorthands for, by
+// prefix
+var dotfilesHosts = map[string]string{"github": "github.com", "gitlab": "gitlab.com"}
+
+// dotfilesShorthand matches OWNER/REPO, which clone takes to be on GitHub
+var dotfilesShorthand = regexp.MustCompile(`^[\w.-]+/[\w.-]+$`)
+
+// dotfilesRepoURL turns what clone was given into a URL git clones:
+// github:OWNER/REPO, gitlab:OWNER/REPO, OWNER/REPO for GitHub, or a git
+// URL as it is. OWNER alone means OWNER/dotfiles
+func dotfilesRepoURL(source string, overSSH bool) (string, error) {
+	if strings.Contains(source, "://") || strings.HasPrefix(source, "git@") {
+		return source, nil
+	}
+	host := dotfilesHosts["github"]
+	if prefix, rest, ok := strings.Cut(source, ":"); ok {
+		if host, ok = dotfilesHosts[prefix]; !ok {
+			return "", fmt.Errorf("%s: unknown host %q (use %s, or a git URL)", source, prefix, strings.Join(sortedKeys(dotfilesHosts), ":, ")+":")
+		}
+		source = rest
+		if !strings.Contains(source, "/") {
+			source += "/dotfiles"
+		}
+	}
+	source = strings.TrimSuffix(strings.Trim(source, "/"), ".git")
+	if !dotfilesShorthand.MatchString(source) {
+		return "", fmt.Errorf("%s is not OWNER/REPO, a git URL or a directory", source)
+	}
+	if overSSH {
+		return "git@" + host + ":" + source + ".git", nil
+	}
+	return "https://" + host + "/" + source + ".git", nil
+}
+
+// isRepoMeta reports whether a top-level entry of a dotfiles repository
+// belongs to the repository rather than the home directory: git's files,
+// docs, licenses and CI settings
+func isRepoMeta(name string) bool {
+	lower := strings.ToLower(name)
+	switch lower {
+	case ".git", ".github", ".gitlab", ".gitlab-ci.yml", ".gitignore", ".gitmodules", ".gitattributes", ".travis.yml", ".editorconfig", ".pre-commit-config.yaml", "copying", "makefile", "justfile":
+		return true
+	}
+	return strings.HasPrefix(lower, "readme") || strings.HasPrefix(lower, "license") || strings.HasSuffix(lower, ".md")
+}
+
+// isRepoScript reports whether a top-level file of a plain dotfiles
+// repository is its install script rather than a dotfile
+func isRepoScript(name string) bool {
+	lower := strings.ToLower(name)
+	for _, prefix := range []string{"install", "bootstrap", "setup", "link", "uninstall"} {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(lower, ".sh") || strings.HasSuffix(lower, ".ps1")
+}
+
+// detectDotfilesLayout works out how a dotfiles repository is laid out:
+//
+//	chezmoi  a chezmoi source directory, with names like dot_bashrc
+//	dotbot   an install.conf.yaml saying where each file is linked
+//	topical  topic directories with NAME.symlink files linked as ~/.NAME
+//	stow     a directory per package, each laid out like the home directory
+//	bare     the home directory itself, as the bare-repository trick keeps it
+//	plain    bashrc, vimrc and so on, linked as ~/.bashrc and ~/.vimrc
+func detectDotfilesLayout(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range dotbotConfigs {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return "dotbot", nil
+		}
+	}
+	hidden, plain, packages, dirs := 0, 0, 0, 0
+	topical := false
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".chezmoi") || strings.HasPrefix(name, "dot_") || strings.HasPrefix(name, "private_dot_") {
+			return "chezmoi", nil
+		}
+		if isRepoMeta(name) {
+			continue
+		}
+		if name == ".stowrc" {
+			return "stow", nil
+		}
+		if strings.HasPrefix(name, ".") {
+			hidden++
+			continue
+		}
+		if !e.IsDir() {
+			if !isRepoScript(name) {
+				plain++
+			}
+			continue
+		}
+		dirs++
+		children, _ := os.ReadDir(filepath.Join(dir, name))
+		stowed := false
+		for _, c := range children {
+			topical = topical || strings.HasSuffix(c.Name(), ".symlink")
+			stowed = stowed || (strings.HasPrefix(c.Name(), ".") && c.Name() != ".stow-local-ignore") || strings.HasPrefix(c.Name(), "dot-")
+		}
+		if stowed {
+			packages++
+		}
+	}
+	switch {
+	case topical:
+		return "topical", nil
+	case hidden > 0 && hidden >= plain:
+		return "bare", nil
+	case packages > 0 && packages*2 >= dirs && plain == 0:
+		return "stow", nil
+	case plain > 0 || dirs > 0:
+		return "plain", nil
+	}
+	return "", fmt.Errorf("%s holds no dotfiles", dir)
+}
+
+// dotfilesImporter returns what reads a repository of the given layout
+func dotfilesImporter(layout string) func(dir, home string, put importPut) error {
+	switch layout {
+	case "chezmoi":
+		return importChezmoi
+	case "dotbot":
+		return importDotbot
+	case "stow":
+		return importStow
+	case "topical":
+		return importTopical
+	case "bare":
+		return func(dir, home string, put importPut) error { return importRepoTree(dir, false, put) }
+	}
+	return func(dir, home string, put importPut) error { return importRepoTree(dir, true, put) }
+}
+
+// importRepoTree imports a repository laid out like the home directory,
+// leaving out its own files. With dot, top-level names get the leading dot
+// they have in the home directory, and install scripts are left out
+func importRepoTree(dir string, dot bool, put importPut) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if isRepoMeta(name) || (dot && !e.IsDir() && isRepoScript(name)) {
+			continue
+		}
+		target := name
+		if dot && !strings.HasPrefix(name, ".") {
+			target = "." + name
+		}
+		if err := importRepoEntry(filepath.Join(dir, name), target, put); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importTopical imports NAME.symlink files and directories from the topic
+// directories of a repository as ~/.NAME
+func importTopical(dir, home string, put importPut) error {
+	links, err := filepath.Glob(filepath.Join(dir, "*", "*.symlink"))
+	if err != nil {
+		return err
+	}
+	top, _ := filepath.Glob(filepath.Join(dir, "*.symlink"))
+	for _, p := range append(top, links...) {
+		if err := importRepoEntry(p, "."+strings.TrimSuffix(filepath.Base(p), ".symlink"), put); err != nil {
+			return err
+		}
+	}
+	noticeColor.Printf("📦 Read %d .symlink entries\n", len(top)+len(links))
+	return nil
+}
+
+// importRepoEntry hands a file, or everything in a directory other than
+// git's own files, to put at rel
+func importRepoEntry(source, rel string, put importPut) error {
+	return filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		sub, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" && sub != "." {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := path.Join(rel, filepath.ToSlash(sub))
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return put(target, nil, info.Mode(), "")
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return put(target, nil, info.Mode(), link)
+		case info.Mode().IsRegular():
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return put(target, data, info.Mode(), "")
+		}
+		return nil
+	})
+}
+
+// runClone handles `profilesync clone SOURCE [-- APPLY FLAGS]`, cloning a
+// dotfiles repository, importing it into the profile store with mappings
+// for its files and applying it
+func runClone(args []string) {
+	flags := newFlagSet("clone", "clone [flags] github:OWNER/REPO | gitlab:OWNER/REPO | OWNER/REPO | URL | DIR [-- APPLY FLAGS]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to import into")
+	configFile := flags.String("config", configPath(), "Config file to record the mappings in")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Record the mappings in this named profile instead of for every profile")
+	layout := flags.String("layout", "auto", "Layout of the repository: auto, or one of "+strings.Join(dotfilesLayouts, ", "))
+	ref := flags.String("ref", "", "Branch or tag to clone instead of the default branch")
+	overSSH := flags.Bool("ssh", false, "Clone github: and gitlab: repositories over ssh, e.g. private ones")
+	force := flags.Bool("force", false, "Replace files already in the profile store that differ")
+	noApply := flags.Bool("no-apply", false, "Only import into the profile store, without applying")
+	dryRun := flags.Bool("dry-run", false, "Show what would be imported without changing anything")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	source, applyArgs := flags.Arg(0), flags.Args()[1:]
+	if *layout != "auto" {
+		known := false
+		for _, l := range dotfilesLayouts {
+			known = known || *layout == l
+		}
+		if !known {
+			errorColor.Println("❌ Invalid --layout value:", *layout)
+			errorColor.Println("Must be one of:", strings.Join(append([]string{"auto"}, dotfilesLayouts...), ", "))
+			os.Exit(2)
+		}
+	}
+
+	// A clone is removed once imported, before apply may exit
+	repo, cleanup := source, func() {}
+	if !isDir(source) {
+		url, err := dotfilesRepoURL(source, *overSSH)
+		if err != nil {
+			errorColor.Printf("❌ %v\n", err)
+			os.Exit(2)
+		}
+		if _, err := exec.LookPath("git"); err != nil {
+			errorColor.Println("❌ git is needed to clone dotfiles repositories")
+			os.Exit(1)
+		}
+		tmp, err := os.MkdirTemp("", "profilesync-clone-")
+		if err != nil {
+			errorColor.Println("❌ Error creating a temporary directory:", err)
+			os.Exit(1)
+		}
+		cleanup = func() { os.RemoveAll(tmp) }
+		repo = filepath.Join(tmp, strings.TrimSuffix(path.Base(strings.TrimSuffix(url, "/")), ".git"))
+
+		ctx, cancel := interruptContext()
+		cloneArgs := []string{"clone", "-q", "--depth", "1", "--recurse-submodules", "--shallow-submodules"}
+		if *ref != "" {
+			cloneArgs = append(cloneArgs, "--branch", *ref)
+		}
+		noticeColor.Printf("📥 Cloning %s\n", url)
+		// git may ask for credentials for private repositories
+		cmd := exec.CommandContext(ctx, "git", append(cloneArgs, "--", url, repo)...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err = cmd.Run()
+		cancel()
+		if err != nil {
+			errorColor.Printf("❌ Could not clone %s: %v\n", url, err)
+			cleanup()
+			os.Exit(1)
+		}
+	} else if *ref != "" {
+		warnColor.Printf("⚠️  --ref is ignored for the directory %s\n", source)
+	}
+
+	if *layout == "auto" {
+		detected, err := detectDotfilesLayout(repo)
+		if err != nil {
+			errorColor.Printf("❌ %v\n", err)
+			cleanup()
+			os.Exit(1)
+		}
+		*layout = detected
+		noticeColor.Printf("🔍 %s looks like a %s layout (use --layout to pick another)\n", source, *layout)
+	}
+	conflicts := importDotfiles(source, dotfilesImporter(*layout), repo, *dir, *configFile, *profile, *force, false, *dryRun)
+	cleanup()
+	storeHome := filepath.Join(*dir, "home")
+	switch {
+	case *dryRun:
+		return
+	case conflicts > 0:
+		warnColor.Println("⚠️  Not applying until the differing files are resolved; use --force to take the repository's")
+		os.Exit(1)
+	case *noApply:
+		noticeColor.Printf("Run `profilesync apply --source-dir %s` to deploy them\n", storeHome)
+		return
+	}
+
+	apply := []string{"--source-dir", storeHome, "--dry-run=false"}
+	if _, err := os.Stat(*configFile); err == nil {
+		apply = append(apply, "--config", *configFile)
+	}
+	if *profile != "" {
+		apply = append(apply, "--profile", *profile)
+	}
+	runApply(append(apply, applyArgs...))
+}
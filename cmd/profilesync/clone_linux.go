@@ -0,0 +1,27 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst a copy-on-write clone of src using the FICLONE ioctl,
+// supported by Btrfs, XFS and other reflink-capable filesystems
+func cloneFile(src, dst string) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destinationFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer destinationFile.Close()
+
+	return unix.IoctlFileClone(int(destinationFile.Fd()), int(sourceFile.Fd()))
+}
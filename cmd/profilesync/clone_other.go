@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// cloneFile is unavailable on this platform; callers fall back to a byte copy
+func cloneFile(src, dst string) error {
+	return errors.ErrUnsupported
+}
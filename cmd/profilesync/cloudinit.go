@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cloudInitMaxSize is the most user-data EC2 and several other clouds
+// accept
+const cloudInitMaxSize = 16 << 10
+
+// cloudInitPackages are packages installed on first boot when their config
+// is exported, by mapping tool
+var cloudInitPackages = map[string]string{"fish": "fish", "git": "git", "tmux": "tmux", "vim": "vim", "zsh": "zsh"}
+
+// cloudInitConfig is the #cloud-config user-data a VM boots with
+type cloudInitConfig struct {
+	Users      []interface{}   `yaml:"users"`
+	Packages   []string        `yaml:"packages,omitempty"`
+	WriteFiles []cloudInitFile `yaml:"write_files,omitempty"`
+	RunCmd     [][]string      `yaml:"runcmd,omitempty"`
+}
+
+// cloudInitUser is the user cloud-init creates for the configs
+type cloudInitUser struct {
+	Name              string   `yaml:"name"`
+	Shell             string   `yaml:"shell,omitempty"`
+	Sudo              string   `yaml:"sudo"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+// cloudInitFile is one write_files entry. Deferred files are written once
+// the user exists, so they can belong to it
+type cloudInitFile struct {
+	Path        string `yaml:"path"`
+	Encoding    string `yaml:"encoding"`
+	Content     string `yaml:"content"`
+	Owner       string `yaml:"owner"`
+	Permissions string `yaml:"permissions"`
+	Defer       bool   `yaml:"defer"`
+}
+
+// minimalExport collects the plan's items with the selected tags into
+// dir/files for home, the way bootstrap does: secrets are left out, except
+// ssh, which goes without its private keys. It returns the exported items'
+// mapping tools
+func (ps *ProfileSync) minimalExport(ctx context.Context, sourceHome, dir, home string, include, exclude []string) ([]string, error) {
+	var secrets, tools []string
+	keep := func(item MigrationItem) bool {
+		if !tagsSelected(catalogTags(item.Mapping), include, exclude) {
+			return false
+		}
+		if item.Sensitivity == sensitivitySecret && item.Mapping != "ssh/" {
+			secrets = append(secrets, item.Description)
+			return false
+		}
+		return true
+	}
+	out := exportTarget{dir: dir, home: home, keepModes: true, redaction: newRedactionPolicy(destExport, ps.redaction)}
+	err := ps.exportedItems(ctx, sourceHome, out, keep, func(item MigrationItem, rel string, collected exportedItem) error {
+		tools = append(tools, mappingTool(item.Mapping))
+		return placeExported(dir, collected)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets) > 0 {
+		warnColor.Printf("🔒 Left out secrets anyone who can read the output could read: %s\n", strings.Join(secrets, ", "))
+	}
+	out.redaction.summary()
+	return tools, nil
+}
+
+// exportCloudInit writes DIR/user-data.yaml, cloud-init user-data that
+// creates user on a new VM with the plan's configs of the selected tags
+// in its home, and this machine's public ssh keys authorized
+func (ps *ProfileSync) exportCloudInit(ctx context.Context, sourceHome, dir, user string, include, exclude []string) error {
+	home := "/home/" + user
+	if user == "root" {
+		home = "/root"
+	}
+	work, err := os.MkdirTemp("", "profilesync-cloud-init-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	tools, err := ps.minimalExport(ctx, sourceHome, work, home, include, exclude)
+	if err != nil {
+		return err
+	}
+	if len(tools) == 0 {
+		warnColor.Println("⚠️  Nothing to export: the source has none of the selected configs")
+		return nil
+	}
+
+	owner := user + ":" + user
+	config := cloudInitConfig{Users: []interface{}{"default"}}
+	packages := map[string]bool{}
+	for _, tool := range tools {
+		if pkg, ok := cloudInitPackages[tool]; ok {
+			packages[pkg] = true
+		}
+	}
+	config.Packages = sortedKeys(packages)
+	if user != "root" {
+		account := cloudInitUser{Name: user, Sudo: "ALL=(ALL) NOPASSWD:ALL"}
+		// The login shell follows this machine's when its config went along
+		if shell := filepath.Base(os.Getenv("SHELL")); devcontainerShells[shell] != "" && packages[devcontainerShells[shell]] {
+			account.Shell = "/usr/bin/" + shell
+		}
+		keys, _ := filepath.Glob(filepath.Join(sourceHome, ".ssh", "*.pub"))
+		for _, key := range keys {
+			if data, err := os.ReadFile(key); err == nil && len(bytes.TrimSpace(data)) > 0 {
+				account.SSHAuthorizedKeys = append(account.SSHAuthorizedKeys, string(bytes.TrimSpace(data)))
+			}
+		}
+		config.Users = append(config.Users, account)
+	}
+
+	// write_files makes missing directories as root, so they are handed
+	// over to the user afterwards
+	files := filepath.Join(work, "files")
+	chown := []string{"chown", owner}
+	var chmods, links [][]string
+	err = filepath.WalkDir(files, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(files, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := path.Join(home, filepath.ToSlash(rel))
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			links = append(links, []string{"ln", "-sfn", link, target}, []string{"chown", "-h", owner, target})
+		case info.IsDir():
+			chown = append(chown, target)
+			chmods = append(chmods, []string{"chmod", fmt.Sprintf("%04o", info.Mode().Perm()), target})
+		default:
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			config.WriteFiles = append(config.WriteFiles, cloudInitFile{
+				Path:        target,
+				Encoding:    "b64",
+				Content:     base64.StdEncoding.EncodeToString(data),
+				Owner:       owner,
+				Permissions: fmt.Sprintf("%04o", info.Mode().Perm()),
+				Defer:       true,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(chown) > 2 {
+		config.RunCmd = append(config.RunCmd, chown)
+	}
+	config.RunCmd = append(append(config.RunCmd, chmods...), links...)
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	data = append([]byte("#cloud-config\n# Generated by `profilesync export --format cloud-init` from "+sourceHome+"\n"), data...)
+	target := filepath.Join(dir, "user-data.yaml")
+	if err := writeExported(target, data, 0644); err != nil {
+		return err
+	}
+	successColor.Printf("✅ Wrote cloud-init user-data with %d files for %s to %s\n", len(config.WriteFiles), user, target)
+	if len(data) > cloudInitMaxSize {
+		warnColor.Printf("⚠️  The user-data is %s; EC2 and other clouds accept at most %s. Use --tags to export fewer configs\n", formatSize(int64(len(data))), formatSize(cloudInitMaxSize))
+	}
+	noticeColor.Printf("Boot a VM with it, e.g. multipass launch --cloud-init %s\n", target)
+	return nil
+}
+
+// scriptUnpack is the start of the script --format script writes: it
+// decodes the archive embedded in the script into $t. macOS before 13
+// only knows base64 -D
+const scriptUnpack = `FORCE=${PROFILESYNC_FORCE:-}
+m=
+set -e
+t=$(mktemp -d)
+trap 'rm -rf "$t"' EXIT
+if base64 -d </dev/null >/dev/null 2>&1; then b='base64 -d'; else b='base64 -D'; fi
+$b <<'PROFILESYNC_PAYLOAD' | tar -xmzf - -C "$t"
+%sPROFILESYNC_PAYLOAD
+`
+
+// exportScript writes DIR/bootstrap.sh, a self-contained sh script that
+// puts the plan's configs of the selected tags in $HOME on any Linux or
+// macOS machine, needing only sh, base64 and tar
+func (ps *ProfileSync) exportScript(ctx context.Context, sourceHome, dir string, include, exclude []string) error {
+	work, err := os.MkdirTemp("", "profilesync-script-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	// The home is only known where the script runs, so exportHome marks it
+	tools, err := ps.minimalExport(ctx, sourceHome, work, exportHome, include, exclude)
+	if err != nil {
+		return err
+	}
+	if len(tools) == 0 {
+		warnColor.Println("⚠️  Nothing to export: the source has none of the selected configs")
+		return nil
+	}
+	files := filepath.Join(work, "files")
+
+	var payload bytes.Buffer
+	encoder := base64.NewEncoder(base64.StdEncoding, &lineWrapper{w: &payload, width: 76})
+	zw := gzip.NewWriter(encoder)
+	if err := writeSandboxTar(zw, files); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	if payload.Len() > 0 && payload.Bytes()[payload.Len()-1] != '\n' {
+		payload.WriteByte('\n')
+	}
+
+	var unpack strings.Builder
+	fmt.Fprintf(&unpack, scriptUnpack, payload.String())
+	fixes, count, err := scriptHomeFixes(files, false)
+	if err != nil {
+		return err
+	}
+	unpack.WriteString(fixes)
+	script, err := bootstrapRemoteScript(files, unpack.String())
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Generated by `profilesync export --format script` from " + sourceHome + "\n")
+	b.WriteString("# Puts the configs in $HOME. Files already there are kept unless\n")
+	b.WriteString("# PROFILESYNC_FORCE=1 is set, which moves them to NAME.profilesync-backup\n")
+	b.WriteString(script)
+	target := filepath.Join(dir, "bootstrap.sh")
+	if err := writeExported(target, []byte(b.String()), 0755); err != nil {
+		return err
+	}
+	successColor.Printf("✅ Wrote a bootstrap script with %d files to %s\n", count, target)
+	noticeColor.Println("Run it on a new machine with: sh bootstrap.sh, or curl -fsSL URL | sh once it is published")
+	return nil
+}
+
+// scriptHomeFixes returns the lines of a script that fix up the files
+// collected into files once they are copied to $t, and how many files
+// there are. Files and links that mention exportHome get the $HOME of
+// wherever the script runs. With modes, files get back modes git does not
+// keep, such as 0600
+func scriptHomeFixes(files string, modes bool) (string, int, error) {
+	var fixes []string
+	count := 0
+	err := filepath.WalkDir(files, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(files, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		count++
+		if d.Type()&fs.ModeSymlink != 0 {
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if inside, ok := strings.CutPrefix(link, exportHome+"/"); ok {
+				fixes = append(fixes, fmt.Sprintf(`ln -sfn "$HOME"/%s "$t"/%s`, shellQuote(inside), shellQuote(rel)))
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if perm := info.Mode().Perm(); modes && perm != 0644 && perm != 0755 {
+			fixes = append(fixes, fmt.Sprintf(`chmod %04o "$t"/%s`, perm, shellQuote(rel)))
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(data, []byte(exportHome)) {
+			fixes = append(fixes, "fix "+shellQuote(rel))
+		}
+		return nil
+	})
+	if err != nil || len(fixes) == 0 {
+		return "", count, err
+	}
+	var b strings.Builder
+	// Files that mention the home directory get this machine's
+	fmt.Fprintf(&b, "h=$(printf '%%s\\n' \"$HOME\" | sed 's/[|&\\\\]/\\\\&/g')\n")
+	fmt.Fprintf(&b, "fix() { sed \"s|%s|$h|g\" \"$t/$1\" > \"$t/.fix\" && cat \"$t/.fix\" > \"$t/$1\" && rm -f \"$t/.fix\"; }\n", exportHome)
+	b.WriteString(strings.Join(fixes, "\n") + "\n")
+	return b.String(), count, nil
+}
+
+// lineWrapper breaks what is written to w into lines of width bytes
+type lineWrapper struct {
+	w     *bytes.Buffer
+	width int
+	col   int
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	for _, c := range p {
+		l.w.WriteByte(c)
+		if l.col++; l.col == l.width {
+			l.w.WriteByte('\n')
+			l.col = 0
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// codespacesUnpack is the start of the install.sh --format codespaces
+// writes: it copies files/ from the checkout next to it into $t. Unlike
+// the other scripts it replaces existing files by default, as a new
+// environment's come from its image rather than from the user
+const codespacesUnpack = `case "${PROFILESYNC_FORCE:-1}" in 0|false|no) FORCE= ;; *) FORCE=1 ;; esac
+m='profilesync: '
+set -e
+here=$(cd "$(dirname "$0")" && pwd)
+t=$(mktemp -d)
+trap 'rm -rf "$t"' EXIT
+cp -pR "$here/files/." "$t"
+`
+
+// exportCodespaces writes the plan's configs of the selected tags to
+// dir/files with an install.sh next to them, the entrypoint GitHub
+// Codespaces, Gitpod and devpod run from a dotfiles repository in every
+// new environment. dir is usually a checkout of that repository
+func (ps *ProfileSync) exportCodespaces(ctx context.Context, sourceHome, dir string, include, exclude []string) error {
+	// Files of configs no longer exported must not linger in the repository
+	if err := os.RemoveAll(filepath.Join(dir, "files")); err != nil {
+		return err
+	}
+	// The home is only known inside the environment, so exportHome marks it
+	tools, err := ps.minimalExport(ctx, sourceHome, dir, exportHome, include, exclude)
+	if err != nil {
+		return err
+	}
+	if len(tools) == 0 {
+		warnColor.Println("⚠️  Nothing to export: the source has none of the selected configs")
+		return nil
+	}
+	files := filepath.Join(dir, "files")
+	fixes, count, err := scriptHomeFixes(files, true)
+	if err != nil {
+		return err
+	}
+	script, err := bootstrapRemoteScript(files, codespacesUnpack+fixes)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Generated by `profilesync export --format codespaces` from " + sourceHome + "\n")
+	b.WriteString("# GitHub Codespaces, Gitpod and devpod run this in each new environment.\n")
+	b.WriteString("# It puts the configs in files/ in $HOME, moving files already there to\n")
+	b.WriteString("# NAME.profilesync-backup; PROFILESYNC_FORCE=0 keeps them instead\n")
+	b.WriteString(script)
+	target := filepath.Join(dir, "install.sh")
+	if err := writeExported(target, []byte(b.String()), 0755); err != nil {
+		return err
+	}
+	successColor.Printf("✅ Wrote install.sh and %d files to %s\n", count, dir)
+	noticeColor.Println("Push it as your dotfiles repository and pick it in your Codespaces, Gitpod or devpod settings")
+	return nil
+}
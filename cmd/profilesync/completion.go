@@ -0,0 +1,467 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// completeCommand is the hidden command the completion scripts call to
+// complete the command line
+const completeCommand = "__complete"
+
+// commandInfo describes a command for completion and the man page
+type commandInfo struct {
+	Name    string
+	Summary string
+	// Flags is whether the command has a flag set of its own
+	Flags bool
+	// Args are words the command takes after its flags
+	Args        []string
+	Subcommands []commandInfo
+}
+
+// capturedKinds are the kinds of items capture stores and apply installs
+var capturedKinds = []struct{ name, what string }{
+	{"packages", "packages"},
+	{"extensions", "VS Code extensions"},
+	{"defaults", "macOS defaults"},
+	{"dconf", "GNOME settings"},
+	{"registry", "Windows registry settings"},
+	{"tasks", "scheduled tasks"},
+	{"gpg", "GPG keys and ownertrust"},
+	{"plugins", "settings of apps handled by plugins"},
+	{"secrets", "sensitive files (kept in Vault or AWS Secrets Manager)"},
+}
+
+// commands lists every command, in the order of the man page
+func commands() []commandInfo {
+	var capture, apply []commandInfo
+	for _, kind := range capturedKinds {
+		capture = append(capture, commandInfo{Name: kind.name, Summary: "Capture " + kind.what + " into the profile store", Flags: true})
+		apply = append(apply, commandInfo{Name: kind.name, Summary: "Install the captured " + kind.what, Flags: true})
+	}
+	var importTools []commandInfo
+	exportTools := []commandInfo{{Name: "archive", Summary: "Write the profile store as a signed archive", Flags: true}}
+	for _, name := range sortedKeys(dotfileTools) {
+		tool := dotfileTools[name]
+		if tool.importFrom != nil {
+			importTools = append(importTools, commandInfo{Name: name, Summary: "Import a " + tool.what + " into the profile store", Flags: true})
+		}
+		if tool.exportTo != nil {
+			exportTools = append(exportTools, commandInfo{Name: name, Summary: "Write the profile store as a " + tool.what, Flags: true})
+		}
+	}
+	return []commandInfo{
+		{Name: "apply", Summary: "Migrate configs to this machine (the default command)", Flags: true, Subcommands: apply},
+		{Name: "adopt", Summary: "Bring existing configs under management", Flags: true},
+		{Name: "audit", Summary: "Show the files apply, restore and prune changed", Flags: true},
+		{Name: "auth", Summary: "Manage passphrases and tokens in the OS keystore", Subcommands: []commandInfo{
+			{Name: "set", Summary: "Store a secret, read without echo or from stdin", Flags: true},
+			{Name: "list", Summary: "List the stored secrets", Flags: true},
+			{Name: "delete", Summary: "Delete stored secrets", Flags: true},
+		}},
+		{Name: "bootstrap", Summary: "Push shell, git, tmux and vim configs to a fresh server over ssh", Flags: true},
+		{Name: "capture", Summary: "Capture this machine's configs, packages and settings into the profile store", Flags: true, Subcommands: capture},
+		{Name: "catalog", Summary: "List the known configs", Flags: true},
+		{Name: "checklist", Summary: "Show or tick off the post-migration checklist", Args: []string{"done", "review"}},
+		{Name: "clone", Summary: "Clone a dotfiles repository, import its files with mappings and apply them", Flags: true},
+		{Name: "completion", Summary: "Print a shell completion script", Args: []string{"bash", "zsh", "fish", "powershell"}},
+		{Name: "convert", Summary: "Convert configs between applications", Subcommands: []commandInfo{
+			{Name: "terminal", Summary: "Convert a terminal's colors, font and profiles", Flags: true},
+		}},
+		{Name: "daemon", Summary: "Run schedules and serve the local API", Flags: true},
+		{Name: "decisions", Summary: "Show or clear remembered answers", Flags: true, Args: []string{"clear"}},
+		{Name: "discover", Summary: "Find dotfiles no mapping covers and offer to adopt them", Flags: true},
+		{Name: "export", Summary: "Export the profile store for another dotfile manager, or the plan as an Ansible playbook, a devcontainer, cloud-init user-data, a bootstrap script or a Codespaces dotfiles repository", Flags: true, Subcommands: exportTools},
+		{Name: "history", Summary: "List past runs", Flags: true, Subcommands: []commandInfo{
+			{Name: "show", Summary: "Show what happened in a run", Flags: true},
+		}},
+		{Name: "import", Summary: "Import configs from a backup or another dotfile manager", Flags: true, Subcommands: importTools},
+		{Name: "init", Summary: "Write the initial config with a setup wizard", Flags: true},
+		{Name: "machines", Summary: "List machines and edit per-machine overrides", Flags: true, Args: []string{"set", "unset", "forget"}},
+		{Name: "man", Summary: "Print the man page", Flags: true},
+		{Name: "mappings", Summary: "Search and add mapping packs from the registry", Subcommands: []commandInfo{
+			{Name: "search", Summary: "Search the registry's packs", Flags: true},
+			{Name: "add", Summary: "Add packs, optionally at a version, to the config", Flags: true},
+			{Name: "list", Summary: "List the packs the config extends", Flags: true},
+			{Name: "remove", Summary: "Remove packs from the config", Flags: true},
+		}},
+		{Name: "prune", Summary: "Remove deployed files of removed mappings", Flags: true},
+		{Name: "receive", Summary: "Receive a profile sent from another machine", Flags: true},
+		{Name: "send", Summary: "Send the profile to another machine", Flags: true},
+		{Name: "service", Summary: "Run profilesync as a service", Subcommands: []commandInfo{
+			{Name: "install", Summary: "Install and start the service", Flags: true},
+			{Name: "uninstall", Summary: "Stop and remove the service"},
+			{Name: "status", Summary: "Show whether the service is running"},
+		}},
+		{Name: "snapshot", Summary: "Take and restore snapshots of managed files", Flags: true, Subcommands: []commandInfo{
+			{Name: "create", Summary: "Take a snapshot (the default)", Flags: true},
+			{Name: "list", Summary: "List snapshots", Flags: true},
+			{Name: "diff", Summary: "Compare snapshots, or a snapshot with the files", Flags: true},
+			{Name: "restore", Summary: "Restore files from a snapshot", Flags: true},
+			{Name: "prune", Summary: "Delete old snapshots", Flags: true},
+		}},
+		{Name: "state", Summary: "Manage the state directory", Subcommands: []commandInfo{
+			{Name: "encrypt", Summary: "Encrypt the state directory"},
+			{Name: "decrypt", Summary: "Decrypt the state directory"},
+			{Name: "status", Summary: "Show the state directory's encryption and usage"},
+			{Name: "rotate", Summary: "Rotate and compress logs and reports", Flags: true},
+		}},
+		{Name: "status", Summary: "Show deployed files changed since they were deployed", Flags: true},
+		{Name: "sync", Summary: "Sync with another machine over ssh", Flags: true},
+		{Name: "trust", Summary: "Show this machine's signing key and manage trusted keys", Subcommands: []commandInfo{
+			{Name: "key", Summary: "Print this machine's public signing key", Flags: true},
+			{Name: "add", Summary: "Trust archives signed with a key", Flags: true},
+			{Name: "list", Summary: "List the trusted keys", Flags: true},
+			{Name: "remove", Summary: "Stop trusting keys", Flags: true},
+		}},
+		{Name: "test", Summary: "Apply the profile in a throwaway container and run smoke checks", Flags: true},
+		{Name: "undo", Summary: "Revert the files the last apply changed", Flags: true},
+		{Name: "vet", Summary: "Check a source for executables, setuid bits and hooks", Flags: true},
+	}
+}
+
+// describing is set while commandFlags runs a command for its flags
+var (
+	describing     bool
+	describedFlags *flag.FlagSet
+	describedUsage string
+)
+
+// commandFlags returns the flag set and usage line of the command at path,
+// by running it with -h: newFlagSet hands the flags over and parsing
+// panics before the command does anything
+func commandFlags(path []string) (flags *flag.FlagSet, usage string) {
+	describing, describedFlags = true, nil
+	defer func() {
+		recover()
+		flags, usage = describedFlags, describedUsage
+		describing, describedFlags = false, nil
+	}()
+	runCommand(path[0], append(append([]string(nil), path[1:]...), "-h"))
+	return nil, ""
+}
+
+// commonFlags are the output and log flags every command has
+func commonFlags() *flag.FlagSet {
+	flags := flag.NewFlagSet("profilesync", flag.ContinueOnError)
+	addOutputFlags(flags)
+	addLogFlags(flags)
+	return flags
+}
+
+// runComplete handles the hidden `profilesync __complete WORD...`, printing
+// the completions of the last word, one per line
+func runComplete(words []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	if current == `""` {
+		// An empty word, as PowerShell passes it
+		current = ""
+	}
+	for _, c := range completions(words[:len(words)-1], current) {
+		if strings.HasPrefix(c, current) {
+			fmt.Println(c)
+		}
+	}
+}
+
+// completions returns the candidates for the word after words
+func completions(words []string, current string) []string {
+	cmds := commands()
+	if len(words) == 0 && !strings.HasPrefix(current, "-") {
+		var names []string
+		for _, c := range cmds {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	// Find the command and subcommand; flags before a command belong to apply
+	path := []string{"apply"}
+	cmd := &cmds[0]
+	rest := words
+	if len(words) > 0 {
+		for i := range cmds {
+			if cmds[i].Name == words[0] {
+				path, cmd, rest = []string{words[0]}, &cmds[i], words[1:]
+			}
+		}
+	}
+	if len(rest) > 0 {
+		for i := range cmd.Subcommands {
+			if cmd.Subcommands[i].Name == rest[0] {
+				path, cmd, rest = append(path, rest[0]), &cmd.Subcommands[i], rest[1:]
+				break
+			}
+		}
+	}
+
+	var flags *flag.FlagSet
+	if cmd.Flags {
+		flags, _ = commandFlags(path)
+	}
+	if flags == nil {
+		flags = commonFlags()
+	}
+	// A flag's value, either in the same word or the next
+	if name, _, ok := strings.Cut(strings.TrimLeft(current, "-"), "="); ok && strings.HasPrefix(current, "-") {
+		var values []string
+		prefix := current[:strings.Index(current, "=")+1]
+		for _, v := range flagValues(name, words) {
+			values = append(values, prefix+v)
+		}
+		return values
+	}
+	if len(rest) > 0 {
+		last := rest[len(rest)-1]
+		if name := strings.TrimLeft(last, "-"); strings.HasPrefix(last, "-") && !strings.Contains(name, "=") {
+			if f := flags.Lookup(name); f != nil && !isBoolFlag(f) {
+				return flagValues(name, words)
+			}
+		}
+	}
+
+	if strings.HasPrefix(current, "-") {
+		var names []string
+		flags.VisitAll(func(f *flag.Flag) {
+			names = append(names, "--"+f.Name)
+		})
+		return names
+	}
+	var candidates []string
+	if len(rest) == 0 {
+		for _, sub := range cmd.Subcommands {
+			candidates = append(candidates, sub.Name)
+		}
+	}
+	if positional(rest, flags) == 0 {
+		candidates = append(candidates, cmd.Args...)
+	}
+	return candidates
+}
+
+// positional counts the words that are not flags or their values
+func positional(words []string, flags *flag.FlagSet) int {
+	n := 0
+	for i := 0; i < len(words); i++ {
+		name := strings.TrimLeft(words[i], "-")
+		if !strings.HasPrefix(words[i], "-") {
+			n++
+		} else if f := flags.Lookup(name); f != nil && !isBoolFlag(f) {
+			i++
+		}
+	}
+	return n
+}
+
+// isBoolFlag reports whether a flag takes no value
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// flagValues returns the values a flag can take: platforms, policies,
+// catalog tags, and the profiles and machines this machine knows about
+func flagValues(name string, words []string) []string {
+	switch name {
+	case "source", "dest":
+		return []string{"linux", "macos", "windows", platformWSL}
+	case "conflict":
+		return []string{conflictNewest, conflictSource, conflictInteractive}
+	case "on-error":
+		return []string{onErrorContinue, onErrorAbort, onErrorPrompt}
+	case "log-format":
+		return []string{"json", "text"}
+	case "log-level":
+		return []string{"debug", "info", "warn", "error"}
+	case "format":
+		if len(words) > 0 && words[0] == "export" {
+			return exportFormats
+		}
+		return []string{"table", "markdown", "yaml"}
+	case "layout":
+		return append([]string{"auto"}, dotfilesLayouts...)
+	case "tags", "skip-tags":
+		seen := map[string]bool{}
+		for _, e := range catalogEntries() {
+			for _, tag := range e.Tags {
+				seen[tag] = true
+			}
+		}
+		return sortedKeys(seen)
+	case "profile":
+		path := configPath()
+		for i, w := range words {
+			if v, ok := strings.CutPrefix(strings.TrimLeft(w, "-"), "config="); ok {
+				path = v
+			} else if strings.TrimLeft(w, "-") == "config" && i+1 < len(words) {
+				path = words[i+1]
+			}
+		}
+		if cfg, err := loadConfig(path, ""); err == nil {
+			return cfg.Defined
+		}
+	case "machine":
+		if r, err := loadMachines(profileDir()); err == nil {
+			return sortedKeys(r.Machines)
+		}
+	}
+	return nil
+}
+
+// completionScripts are the scripts `profilesync completion` prints. Each
+// passes the words so far to `profilesync __complete` and falls back to
+// file names when it has nothing to offer
+var completionScripts = map[string]string{
+	"bash": `# bash completion for profilesync
+_profilesync() {
+	local IFS=$'\n'
+	COMPREPLY=($(profilesync __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _profilesync profilesync
+`,
+	"zsh": `#compdef profilesync
+# zsh completion for profilesync
+_profilesync() {
+	local -a candidates
+	candidates=("${(@f)$(profilesync __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	candidates=(${candidates:#})
+	if (( ${#candidates} )); then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+compdef _profilesync profilesync
+`,
+	"fish": `# fish completion for profilesync
+complete -c profilesync -f -a '(profilesync __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`,
+	"powershell": `# PowerShell completion for profilesync
+Register-ArgumentCompleter -Native -CommandName profilesync -ScriptBlock {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+	if ($wordToComplete -eq '') { $words += '""' }
+	profilesync __complete @words 2>$null | ForEach-Object {
+		[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+	}
+}
+`,
+}
+
+// runCompletion handles `profilesync completion SHELL`
+func runCompletion(args []string) {
+	if len(args) != 1 || completionScripts[args[0]] == "" {
+		errorColor.Println("❌ Usage: profilesync completion bash|zsh|fish|powershell")
+		os.Exit(2)
+	}
+	fmt.Print(completionScripts[args[0]])
+}
+
+// roff escapes text for a man page
+func roff(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// writeManFlags writes a man page's list of flags, leaving out those in skip
+func writeManFlags(b *strings.Builder, flags *flag.FlagSet, skip *flag.FlagSet) {
+	flags.VisitAll(func(f *flag.Flag) {
+		if skip != nil && skip.Lookup(f.Name) != nil {
+			return
+		}
+		name, usage := flag.UnquoteUsage(f)
+		b.WriteString(".TP\n\\fB\\-\\-" + roff(f.Name) + "\\fR")
+		if name != "" {
+			b.WriteString(" \\fI" + roff(name) + "\\fR")
+		}
+		b.WriteString("\n" + roff(usage))
+		if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" && f.DefValue != "0s" {
+			b.WriteString(" (default " + roff(f.DefValue) + ")")
+		}
+		b.WriteString("\n")
+	})
+}
+
+// manPage renders the profilesync(1) man page from the commands and
+// their flags
+func manPage() string {
+	var b strings.Builder
+	b.WriteString(".TH PROFILESYNC 1 \"" + time.Now().Format("January 2006") + "\" profilesync \"User Commands\"\n")
+	b.WriteString(".SH NAME\nprofilesync \\- migrate and sync application configs between machines and platforms\n")
+	b.WriteString(".SH SYNOPSIS\n.B profilesync\n[\\fICOMMAND\\fR] [\\fIFLAGS\\fR] [\\fIARGS\\fR]\n")
+	b.WriteString(".SH DESCRIPTION\nprofilesync finds the configs of known applications, plans how they map between Linux, macOS and Windows, and copies, merges or syncs them. Without a command it runs \\fBapply\\fR, which previews a migration unless given \\fB\\-\\-dry\\-run=false\\fR.\n")
+
+	common := commonFlags()
+	b.WriteString(".SH COMMANDS\n")
+	var write func(path []string, c commandInfo)
+	write = func(path []string, c commandInfo) {
+		path = append(path, c.Name)
+		var flags *flag.FlagSet
+		usage := strings.Join(path, " ")
+		if c.Flags {
+			flags, usage = commandFlags(path)
+		}
+		b.WriteString(".SS " + roff("profilesync "+usage) + "\n" + roff(c.Summary) + ".\n")
+		if flags != nil {
+			writeManFlags(&b, flags, common)
+		}
+		for _, sub := range c.Subcommands {
+			write(path, sub)
+		}
+	}
+	for _, c := range commands() {
+		write(nil, c)
+	}
+
+	b.WriteString(".SH COMMON FLAGS\nEvery command with flags also takes these:\n")
+	writeManFlags(&b, common, nil)
+
+	b.WriteString(".SH ENVIRONMENT\n")
+	for _, env := range [][2]string{
+		{"PROFILESYNC_CONFIG", "Config file (default " + configPath() + ")"},
+		{"PROFILESYNC_PROFILE", "Named profile from the config to use"},
+		{"PROFILESYNC_PROFILE_DIR", "Profile store (default " + profileDir() + ")"},
+		{"PROFILESYNC_LOG_FILE, PROFILESYNC_LOG_FORMAT, PROFILESYNC_LOG_LEVEL", "Defaults of the log flags"},
+		{"PROFILESYNC_PLAIN, PROFILESYNC_NO_EMOJI, NO_COLOR", "Plain output, no emoji, no color"},
+	} {
+		b.WriteString(".TP\n.B " + roff(env[0]) + "\n" + roff(env[1]) + "\n")
+	}
+	b.WriteString(".SH EXIT STATUS\n0 on success, 1 when something failed, 2 for invalid usage.\n")
+	b.WriteString(".SH SEE ALSO\nRun \\fBprofilesync COMMAND \\-h\\fR for a command's flags, and \\fBprofilesync completion\\fR for shell completion.\n")
+	return b.String()
+}
+
+// runMan handles `profilesync man`, printing the man page or writing it
+// into a directory
+func runMan(args []string) {
+	flags := newFlagSet("man", "man [flags]")
+	output := flags.String("output", "", "Write profilesync.1 into this directory instead of printing it")
+	flags.Parse(args)
+
+	page := manPage()
+	if *output == "" {
+		fmt.Print(page)
+		return
+	}
+	path := filepath.Join(*output, "profilesync.1")
+	if err := os.MkdirAll(*output, 0755); err != nil {
+		errorColor.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(path, []byte(page), 0644); err != nil {
+		errorColor.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	successColor.Println("✅ Wrote", path)
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is one config file. A file may extend others; settings in the
+// extending file override those it inherits
+type Config struct {
+	// Extends names the files this one builds on, relative to this file, or
+	// remote configs (see remoteConfig)
+	Extends stringList `yaml:"extends"`
+
+	// Defaults controls whether the built-in mappings are included (default true)
+	Defaults *bool `yaml:"defaults"`
+
+	// Mappings adds or replaces mappings; a null destination removes one
+	Mappings map[string]*string `yaml:"mappings"`
+
+	// Policies set apply flags, e.g. force, jobs or skip-missing-apps,
+	// unless they are given on the command line
+	Policies map[string]interface{} `yaml:"policies"`
+
+	// Catalog adds known configs or overrides fields of built-in ones
+	Catalog []CatalogEntry `yaml:"catalog"`
+
+	// Mackup names Mackup application definitions, .cfg files or directories
+	// of them relative to this file, whose paths become catalog entries
+	Mackup stringList `yaml:"mackup"`
+
+	// Plugins name external handlers: paths relative to this file, or names
+	// of profilesync-plugin-NAME executables on PATH
+	Plugins stringList `yaml:"plugins"`
+
+	// Variables name paths that mappings can use as ${NAME}
+	Variables map[string]string `yaml:"variables"`
+
+	// Edits set or delete values in migrated JSON and YAML configs, by
+	// mapping; they add to the edits of the files this one extends
+	Edits map[string][]Edit `yaml:"edits"`
+
+	// Transforms name a Starlark script, relative to this file, that
+	// rewrites a mapping's file after it is migrated; null removes one
+	Transforms map[string]*string `yaml:"transforms"`
+
+	// Profiles are named sets of mappings, policies and variables chosen
+	// with --profile
+	Profiles map[string]*Profile `yaml:"profiles"`
+
+	// Schedules are commands the daemon runs periodically, by name; a null
+	// schedule removes an inherited one
+	Schedules map[string]*Schedule `yaml:"schedules"`
+
+	// Notifications report the results of scheduled jobs, by name; a null
+	// notification removes an inherited one
+	Notifications map[string]*Notification `yaml:"notifications"`
+
+	// Snapshots sets how many snapshots are kept
+	Snapshots *SnapshotRetention `yaml:"snapshots"`
+
+	// SecretStore keeps sensitive mappings in Vault or AWS Secrets Manager
+	// instead of the profile store
+	SecretStore *SecretStore `yaml:"secret-store"`
+
+	// Redaction leaves files out of, or redacts them in, exports and sends;
+	// they add to the rules of the files this one extends
+	Redaction []RedactionRule `yaml:"redaction"`
+}
+
+// Profile is a named set of settings applied on top of the rest of the
+// config. A profile may extend other profiles by name
+type Profile struct {
+	Extends   stringList             `yaml:"extends"`
+	Mappings  map[string]*string     `yaml:"mappings"`
+	Policies  map[string]interface{} `yaml:"policies"`
+	Variables map[string]string      `yaml:"variables"`
+	Edits     map[string][]Edit      `yaml:"edits"`
+
+	Transforms map[string]*string `yaml:"transforms"`
+}
+
+// stringList accepts either a single string or a list of strings
+type stringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = stringList{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// resolvedConfig is a config file with everything it extends merged in
+type resolvedConfig struct {
+	Files     []string
+	Profiles  []string
+	Defined   []string // every profile the config defines
+	Mappings  map[string]string
+	Policies  map[string]string
+	Variables map[string]string
+	Edits     map[string][]Edit
+	Schedules map[string]*Schedule
+	Snapshots SnapshotRetention
+
+	Transforms map[string]string // script by mapping
+	Plugins    []*plugin
+
+	Notifications map[string]*Notification
+	SecretStore   *SecretStore // nil unless the config sets one
+	Redaction     []RedactionRule
+}
+
+// configLayer accumulates settings while walking an extends chain
+type configLayer struct {
+	files     []string
+	defaults  *bool
+	mappings  map[string]*string
+	policies  map[string]string
+	catalog   []CatalogEntry
+	mackup    []string
+	variables map[string]string
+	edits     map[string][]Edit
+	profiles  map[string]*Profile
+
+	transforms map[string]*string
+	plugins    []string
+	schedules  map[string]*Schedule
+	snapshots  SnapshotRetention
+
+	notifications map[string]*Notification
+	secretStore   *SecretStore
+	redaction     []RedactionRule
+}
+
+// loadConfig reads the config at path, a file or remote URL, and everything
+// it extends, then applies the named profile if profile is not empty
+func loadConfig(path, profile string) (*resolvedConfig, error) {
+	layer := &configLayer{
+		mappings:  map[string]*string{},
+		policies:  map[string]string{},
+		variables: map[string]string{},
+		edits:     map[string][]Edit{},
+		profiles:  map[string]*Profile{},
+
+		transforms: map[string]*string{},
+		schedules:  map[string]*Schedule{},
+
+		notifications: map[string]*Notification{},
+	}
+	if err := layer.merge(path, nil); err != nil {
+		return nil, err
+	}
+	var chain []string
+	if profile != "" {
+		if err := layer.applyProfile(profile, nil, &chain); err != nil {
+			return nil, err
+		}
+	}
+	for name := range layer.variables {
+		for _, builtin := range pathVariables {
+			if name == builtin {
+				return nil, fmt.Errorf("variable %s is built in and cannot be redefined", name)
+			}
+		}
+	}
+
+	// Entries in the config override those of plugins, which override
+	// those read from Mackup
+	mackup, err := mackupCatalog(layer.mackup)
+	if err != nil {
+		return nil, err
+	}
+	plugins, err := loadPlugins(layer.plugins)
+	if err != nil {
+		return nil, err
+	}
+	entries := mackup
+	for _, p := range plugins {
+		entries = append(entries, p.Catalog...)
+	}
+	if err := addCatalogEntries(append(entries, layer.catalog...)); err != nil {
+		return nil, err
+	}
+
+	mappings := map[string]string{}
+	if layer.defaults == nil || *layer.defaults {
+		mappings = GetDefaultMappings()
+	}
+	for source, dest := range layer.mappings {
+		if dest == nil {
+			delete(mappings, source)
+			continue
+		}
+		mappings[source] = *dest
+	}
+	if len(layer.variables) > 0 {
+		expanded := make(map[string]string, len(mappings))
+		for source, dest := range mappings {
+			expanded[expandVariables(source, layer.variables)] = expandVariables(dest, layer.variables)
+		}
+		mappings = expanded
+	}
+	edits := map[string][]Edit{}
+	for mapping, list := range layer.edits {
+		for i := range list {
+			if err := list[i].validate(); err != nil {
+				return nil, fmt.Errorf("edit %d of %s: %w", i+1, mapping, err)
+			}
+		}
+		mapping = expandVariables(mapping, layer.variables)
+		edits[mapping] = append(edits[mapping], list...)
+	}
+	transforms := map[string]string{}
+	for mapping, script := range layer.transforms {
+		if script == nil {
+			continue
+		}
+		if err := checkTransformScript(*script); err != nil {
+			return nil, fmt.Errorf("transform for %s: %w", mapping, err)
+		}
+		transforms[expandVariables(mapping, layer.variables)] = *script
+	}
+
+	schedules := map[string]*Schedule{}
+	for name, sched := range layer.schedules {
+		if sched == nil {
+			continue
+		}
+		if err := sched.validate(); err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", name, err)
+		}
+		schedules[name] = sched
+	}
+	notifications := map[string]*Notification{}
+	for name, n := range layer.notifications {
+		if n == nil {
+			continue
+		}
+		if err := n.validate(); err != nil {
+			return nil, fmt.Errorf("notification %s: %w", name, err)
+		}
+		notifications[name] = n
+	}
+	for i, rule := range layer.redaction {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("redaction rule %d: %w", i+1, err)
+		}
+	}
+	if layer.secretStore != nil {
+		if err := layer.secretStore.validate(); err != nil {
+			return nil, fmt.Errorf("secret-store: %w", err)
+		}
+		for i, mapping := range layer.secretStore.Mappings {
+			layer.secretStore.Mappings[i] = expandVariables(mapping, layer.variables)
+		}
+	}
+
+	return &resolvedConfig{
+		Files:     layer.files,
+		Profiles:  chain,
+		Defined:   sortedKeys(layer.profiles),
+		Mappings:  mappings,
+		Policies:  layer.policies,
+		Variables: layer.variables,
+		Edits:     edits,
+		Schedules: schedules,
+		Snapshots: layer.snapshots,
+
+		Transforms: transforms,
+		Plugins:    plugins,
+
+		Notifications: notifications,
+		SecretStore:   layer.secretStore,
+		Redaction:     layer.redaction,
+	}, nil
+}
+
+// applyProfile applies the profiles name extends, then name itself. chain
+// collects the profiles applied, base first; stack detects cycles
+func (l *configLayer) applyProfile(name string, stack []string, chain *[]string) error {
+	for _, seen := range stack {
+		if seen == name {
+			return fmt.Errorf("profile extends itself: %s", strings.Join(append(stack, name), " → "))
+		}
+	}
+	p, ok := l.profiles[name]
+	if !ok {
+		var names []string
+		for n := range l.profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("unknown profile %q (the config defines none)", name)
+		}
+		return fmt.Errorf("unknown profile %q (must be one of: %s)", name, strings.Join(names, ", "))
+	}
+	for _, applied := range *chain {
+		if applied == name {
+			// Reached again through another parent
+			return nil
+		}
+	}
+
+	for _, parent := range p.Extends {
+		if err := l.applyProfile(parent, append(stack, name), chain); err != nil {
+			return err
+		}
+	}
+	*chain = append(*chain, name)
+	for source, dest := range p.Mappings {
+		l.mappings[source] = dest
+	}
+	for key, value := range p.Policies {
+		l.policies[key] = fmt.Sprint(value)
+	}
+	for key, value := range p.Variables {
+		l.variables[key] = value
+	}
+	for mapping, list := range p.Edits {
+		l.edits[mapping] = append(l.edits[mapping], list...)
+	}
+	for mapping, script := range p.Transforms {
+		l.transforms[mapping] = script
+	}
+	return nil
+}
+
+// expandVariables replaces ${NAME} and $NAME for the given variables,
+// leaving the built-in path variables to resolveMappingPath
+func expandVariables(mapping string, variables map[string]string) string {
+	return os.Expand(mapping, func(name string) string {
+		if value, ok := variables[name]; ok {
+			return value
+		}
+		return "${" + name + "}"
+	})
+}
+
+// merge applies the files location extends, then location itself. A
+// location is a file path or a remote config URL. stack holds the locations
+// currently being merged so cycles are reported instead of looping
+func (l *configLayer) merge(location string, stack []string) error {
+	remote := isRemoteConfig(location)
+	if !remote {
+		abs, err := filepath.Abs(location)
+		if err != nil {
+			return err
+		}
+		location = abs
+	}
+	for _, seen := range stack {
+		if seen == location {
+			return fmt.Errorf("config extends itself: %s", strings.Join(append(stack, location), " → "))
+		}
+	}
+	stack = append(stack, location)
+
+	var data []byte
+	var err error
+	if remote {
+		data, err = readRemoteConfig(location)
+	} else {
+		data, err = os.ReadFile(location)
+	}
+	if err != nil {
+		return err
+	}
+	var cfg Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", location, err)
+	}
+
+	for _, parent := range cfg.Extends {
+		switch {
+		case remote:
+			r, err := parseRemoteConfig(location)
+			if err != nil {
+				return err
+			}
+			if parent, err = r.resolve(parent); err != nil {
+				return err
+			}
+		case !isRemoteConfig(parent) && !filepath.IsAbs(parent):
+			parent = filepath.Join(filepath.Dir(location), parent)
+		}
+		if err := l.merge(parent, stack); err != nil {
+			return err
+		}
+	}
+
+	l.files = append(l.files, location)
+	if cfg.Defaults != nil {
+		l.defaults = cfg.Defaults
+	}
+	for source, dest := range cfg.Mappings {
+		l.mappings[source] = dest
+	}
+	for name, value := range cfg.Policies {
+		l.policies[name] = fmt.Sprint(value)
+	}
+	l.catalog = append(l.catalog, cfg.Catalog...)
+	for _, loc := range cfg.Mackup {
+		if remote {
+			return fmt.Errorf("%s: mackup definitions must be local files", location)
+		}
+		l.mackup = append(l.mackup, localConfigPath(location, loc))
+	}
+	for _, entry := range cfg.Plugins {
+		if remote {
+			return fmt.Errorf("%s: plugins must be local", location)
+		}
+		if strings.ContainsAny(entry, `/\`) {
+			entry = localConfigPath(location, entry)
+		}
+		found, err := findPlugin(entry)
+		if err != nil {
+			return fmt.Errorf("%s: %w", location, err)
+		}
+		l.plugins = append(l.plugins, found)
+	}
+	if remote && (len(cfg.Transforms) > 0 || profilesHaveTransforms(cfg.Profiles)) {
+		return fmt.Errorf("%s: transform scripts must be local files", location)
+	}
+	for mapping, script := range cfg.Transforms {
+		if script != nil {
+			resolved := localConfigPath(location, *script)
+			script = &resolved
+		}
+		l.transforms[mapping] = script
+	}
+	for name, value := range cfg.Variables {
+		l.variables[name] = value
+	}
+	for mapping, list := range cfg.Edits {
+		l.edits[mapping] = append(l.edits[mapping], list...)
+	}
+	for name, sched := range cfg.Schedules {
+		l.schedules[name] = sched
+	}
+	for name, n := range cfg.Notifications {
+		l.notifications[name] = n
+	}
+	if cfg.Snapshots != nil {
+		l.snapshots = l.snapshots.merge(*cfg.Snapshots)
+	}
+	l.redaction = append(l.redaction, cfg.Redaction...)
+	if cfg.SecretStore != nil {
+		merged := *cfg.SecretStore
+		if l.secretStore != nil {
+			merged = l.secretStore.merge(merged)
+		}
+		l.secretStore = &merged
+	}
+	for name, p := range cfg.Profiles {
+		if p == nil {
+			p = &Profile{}
+		}
+		existing, ok := l.profiles[name]
+		for mapping, script := range p.Transforms {
+			if script != nil {
+				resolved := localConfigPath(location, *script)
+				p.Transforms[mapping] = &resolved
+			}
+		}
+		if !ok {
+			l.profiles[name] = p
+			continue
+		}
+		// A file redefining an inherited profile overrides it field by field
+		if p.Extends != nil {
+			existing.Extends = p.Extends
+		}
+		for k, v := range p.Mappings {
+			if existing.Mappings == nil {
+				existing.Mappings = map[string]*string{}
+			}
+			existing.Mappings[k] = v
+		}
+		for k, v := range p.Policies {
+			if existing.Policies == nil {
+				existing.Policies = map[string]interface{}{}
+			}
+			existing.Policies[k] = v
+		}
+		for k, v := range p.Variables {
+			if existing.Variables == nil {
+				existing.Variables = map[string]string{}
+			}
+			existing.Variables[k] = v
+		}
+		for k, v := range p.Edits {
+			if existing.Edits == nil {
+				existing.Edits = map[string][]Edit{}
+			}
+			existing.Edits[k] = append(existing.Edits[k], v...)
+		}
+		for k, v := range p.Transforms {
+			if existing.Transforms == nil {
+				existing.Transforms = map[string]*string{}
+			}
+			existing.Transforms[k] = v
+		}
+	}
+	return nil
+}
+
+// localConfigPath resolves loc, a path in the config file at location,
+// against the home directory when it starts with ~/ or the file's
+// directory when it is relative
+func localConfigPath(location, loc string) string {
+	switch {
+	case strings.HasPrefix(loc, "~/"):
+		return filepath.Join(GetHomeDir(DetectPlatform()), loc[2:])
+	case !filepath.IsAbs(loc):
+		return filepath.Join(filepath.Dir(location), loc)
+	}
+	return loc
+}
+
+// profilesHaveTransforms reports whether any of profiles names a script
+func profilesHaveTransforms(profiles map[string]*Profile) bool {
+	for _, p := range profiles {
+		if p != nil && len(p.Transforms) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPolicies sets flags from the config's policies unless they were
+// given on the command line
+func (c *resolvedConfig) applyPolicies(flags *flag.FlagSet) error {
+	names := make([]string, 0, len(c.Policies))
+	for name := range c.Policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" || name == "help" || flags.Lookup(name) == nil {
+			return fmt.Errorf("unknown policy %q", name)
+		}
+		if flagWasSet(flags, name) {
+			continue
+		}
+		if err := flags.Set(name, c.Policies[name]); err != nil {
+			return fmt.Errorf("policy %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Ways to resolve a destination that already exists
+const (
+	resolveOverwrite = "overwrite"
+	resolveSkip      = "skip"
+	resolveBackup    = "backup"
+)
+
+// resolveConflict decides what to do with an item whose destination already
+// exists and is only replaced with --force. --yes overwrites it after a
+// backup; without a terminal to ask on, or with --no-input, it is skipped.
+// Otherwise the user is asked, and may answer for all remaining conflicts
+func (ps *ProfileSync) resolveConflict(item MigrationItem) string {
+	switch {
+	case ps.conflictAll != "":
+		return ps.conflictAll
+	case ps.assumeYes:
+		return resolveBackup
+	case !ps.canPrompt():
+		return resolveSkip
+	}
+
+	info, err := os.Stat(item.DestinationPath)
+	file := err == nil && info.Mode().IsRegular()
+	options := "[o]verwrite, [s]kip"
+	if file {
+		options += ", [b]ack up and overwrite, show [d]iff"
+	}
+	options += ", or a capital letter for all remaining conflicts"
+	warnColor.Printf("⚠️  %s already exists: %s\n", item.Description, displayPath(item.DestinationPath))
+	for {
+		answer := ask(options+":", "s")
+		all := answer != strings.ToLower(answer)
+		var resolution string
+		switch strings.ToLower(answer) {
+		case "o", "overwrite":
+			resolution = resolveOverwrite
+		case "s", "skip":
+			resolution = resolveSkip
+		case "b", "backup":
+			resolution = resolveBackup
+		case "d", "diff":
+			if file {
+				ps.showConflictDiff(item)
+				continue
+			}
+		}
+		if resolution == "" || (resolution == resolveBackup && !file && !all) {
+			fmt.Printf("Please answer one of: %s\n", options)
+			continue
+		}
+		if all {
+			ps.conflictAll = resolution
+		}
+		return resolution
+	}
+}
+
+// showConflictDiff shows how the source of an item differs from the file
+// it would replace
+func (ps *ProfileSync) showConflictDiff(item MigrationItem) {
+	old, err := os.ReadFile(item.DestinationPath)
+	if err != nil {
+		errorColor.Println("❌ Error:", err)
+		return
+	}
+	new, err := os.ReadFile(item.SourcePath)
+	if err != nil {
+		errorColor.Println("❌ Error:", err)
+		return
+	}
+	if item.Sensitivity == sensitivitySecret && !confirm("🔐 This file holds secrets. Show them?", false) {
+		return
+	}
+	defer pauseProgress()()
+	noticeColor.Printf("--- %s\n+++ %s\n", displayPath(item.DestinationPath), displayPath(item.SourcePath))
+	printLineDiff(old, new)
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// consoleUnicode reports whether the console can show emoji. Terminals
+// elsewhere than Windows are assumed to use UTF-8
+func consoleUnicode() bool {
+	return true
+}
+
+// consoleWidth is the width of the terminal on stdout, 80 if unknown
+func consoleWidth() int {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 {
+		return 80
+	}
+	return int(ws.Col)
+}
+
+// readSecret reads a line from the terminal without echoing it
+func readSecret(prompt string) (string, error) {
+	defer pauseProgress()()
+	fmt.Print(prompt)
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if err := stty("-echo"); err != nil {
+		return "", fmt.Errorf("cannot turn off echo: %w", err)
+	}
+	line, err := stdin.ReadString('\n')
+	stty("echo")
+	fmt.Println()
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
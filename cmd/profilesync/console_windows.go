@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// cpUTF8 is the UTF-8 console code page
+const cpUTF8 = 65001
+
+var procGetConsoleOutputCP = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetConsoleOutputCP")
+
+// consoleUnicode reports whether the console can show emoji: Windows
+// Terminal can, but a legacy console on a code page such as 437 or 1252
+// prints question marks or mojibake instead
+func consoleUnicode() bool {
+	if _, ok := windows.Getenv("WT_SESSION"); ok {
+		return true
+	}
+	cp, _, _ := procGetConsoleOutputCP.Call()
+	// No console at all, such as when redirected to a file, means UTF-8
+	return cp == 0 || cp == cpUTF8
+}
+
+// consoleWidth is the width of the console on stdout, 80 if unknown
+func consoleWidth() int {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(os.Stdout.Fd()), &info); err != nil {
+		return 80
+	}
+	return int(info.Window.Right-info.Window.Left) + 1
+}
+
+// readSecret reads a line from the console without echoing it
+func readSecret(prompt string) (string, error) {
+	defer pauseProgress()()
+	fmt.Print(prompt)
+	handle := windows.Handle(os.Stdin.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return "", fmt.Errorf("cannot turn off echo: %w", err)
+	}
+	if err := windows.SetConsoleMode(handle, mode&^windows.ENABLE_ECHO_INPUT); err != nil {
+		return "", fmt.Errorf("cannot turn off echo: %w", err)
+	}
+	line, err := stdin.ReadString('\n')
+	windows.SetConsoleMode(handle, mode)
+	fmt.Println()
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// copyJob is a single file copy scheduled on the worker pool
+type copyJob struct {
+	src string
+	dst string
+}
+
+// itemHandler customises how the item for one mapping is located and copied
+type itemHandler struct {
+	// Locate returns the item's path for a platform and home directory,
+	// replacing the mapping's own path
+	Locate func(platform, home string) string
+	// Copy replaces the default copy. Whether it merges into an existing
+	// destination is the catalog's merge strategy
+	Copy func(ps *ProfileSync, ctx context.Context, item MigrationItem) error
+	// Source returns the tree Copy copies from and the entries of it Copy
+	// leaves out, for estimating its size; the default is copyTree's
+	Source func(ps *ProfileSync, item MigrationItem) (root string, exclude func(rel string) bool, err error)
+}
+
+// itemHandlers are keyed by source mapping
+var itemHandlers = map[string]itemHandler{
+	"firefox/.mozilla/firefox/": {Locate: firefoxRoot, Copy: (*ProfileSync).copyFirefoxProfile, Source: (*ProfileSync).firefoxSource},
+	"chrome/Default/":           {Locate: chromiumLocate("chrome"), Copy: (*ProfileSync).copyChromiumProfile, Source: (*ProfileSync).chromiumSource},
+	"chromium/Default/":         {Locate: chromiumLocate("chromium"), Copy: (*ProfileSync).copyChromiumProfile, Source: (*ProfileSync).chromiumSource},
+	"edge/Default/":             {Locate: chromiumLocate("edge"), Copy: (*ProfileSync).copyChromiumProfile, Source: (*ProfileSync).chromiumSource},
+	"brave/Default/":            {Locate: chromiumLocate("brave"), Copy: (*ProfileSync).copyChromiumProfile, Source: (*ProfileSync).chromiumSource},
+	"kubectl/config":            {Copy: (*ProfileSync).copyKubeconfig},
+	"ssh/":                      {Copy: (*ProfileSync).copySSH},
+	"git/.gitconfig":            {Copy: (*ProfileSync).copyGitconfig},
+	"aws/credentials":           {Copy: (*ProfileSync).copyAWSFile},
+	"aws/config":                {Copy: (*ProfileSync).copyAWSFile},
+	shellTranslateMapping:       {Copy: (*ProfileSync).copyShellTranslation},
+}
+
+// copyItem copies a migration item, fanning directory trees out across the worker pool
+func (ps *ProfileSync) copyItem(ctx context.Context, item MigrationItem) error {
+	if isSymlink(item.SourcePath) && item.Symlinks == symlinkPreserve {
+		return ps.copySymlink(item.SourcePath, item.DestinationPath)
+	}
+	if h, ok := itemHandlers[item.Mapping]; ok && h.Copy != nil {
+		return h.Copy(ps, ctx, item)
+	}
+	return ps.copyTree(ctx, item)
+}
+
+// copyTree copies an item's file or directory as it is, without the
+// handler that merges it into what the destination holds
+func (ps *ProfileSync) copyTree(ctx context.Context, item MigrationItem) error {
+	info, err := os.Stat(item.SourcePath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return ps.copyFile(ctx, item.SourcePath, item.DestinationPath)
+	}
+
+	jobs, err := ps.collectCopyJobs(ctx, item.SourcePath, item.DestinationPath, item.Symlinks, appDataExclude(item.SourcePath))
+	if err != nil {
+		return err
+	}
+
+	err = ps.copyParallel(ctx, jobs, func(done, total int) {
+		ps.progress.setFiles(done, total)
+	})
+	return err
+}
+
+// appDataExclude leaves out the entries below root that classifyAppData
+// finds machine-specific, as only part of a Windows AppData tree belongs on
+// another machine
+func appDataExclude(root string) func(rel string) bool {
+	return func(rel string) bool {
+		portable, _ := classifyAppData(filepath.Join(root, rel))
+		return !portable
+	}
+}
+
+// itemSource is the tree copying item copies from, and the entries of it
+// left out
+func (ps *ProfileSync) itemSource(item MigrationItem) (string, func(rel string) bool, error) {
+	if h, ok := itemHandlers[item.Mapping]; ok && h.Source != nil {
+		return h.Source(ps, item)
+	}
+	return item.SourcePath, appDataExclude(item.SourcePath), nil
+}
+
+// followLink stats the target of a symlink that copying follows. For a
+// broken link or one that loops back on itself it returns why it is
+// skipped instead
+func followLink(path string) (fs.FileInfo, string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "⚠️  Skipped broken symlink"
+	}
+	if info.IsDir() && symlinkLoops(path) {
+		return nil, "🔁 Not following symlink loop"
+	}
+	return info, ""
+}
+
+// collectCopyJobs creates the destination directory tree and lists the files
+// to copy. Entries for which exclude returns true, given their slash-separated
+// path relative to srcRoot, are left out along with anything below them, as
+// are entries matched by the ignore files. Symlinks are followed, recreated
+// or skipped as links says
+func (ps *ProfileSync) collectCopyJobs(ctx context.Context, srcRoot, dstRoot, links string, exclude func(rel string) bool) ([]copyJob, error) {
+	// The walk never visits the root itself
+	info, err := os.Stat(srcRoot)
+	if err != nil {
+		return nil, err
+	}
+	ignore, err := ps.directoryIgnore(srcRoot)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dstRoot, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(dstRoot, ps.fileMode(srcRoot, info.Mode()).Perm()); err != nil {
+		return nil, err
+	}
+	ps.copyMetadata(srcRoot, dstRoot, dstRoot)
+
+	var jobs []copyJob
+	var skippedLinks, renamed []string
+	var mu sync.Mutex
+
+	err = parallelWalk(ctx, srcRoot, ps.jobs, func(path string, d fs.DirEntry) (bool, error) {
+		rel, err := filepath.Rel(srcRoot, path)
+		if err != nil {
+			return false, err
+		}
+		if exclude != nil && exclude(filepath.ToSlash(rel)) {
+			if ps.verbose {
+				noticeColor.Printf("⏭️  Excluded: %s\n", path)
+			}
+			return false, nil
+		}
+		if ignore.ignored(filepath.ToSlash(rel), d.IsDir()) {
+			if ps.verbose {
+				noticeColor.Printf("⏭️  Ignored: %s\n", path)
+			}
+			return false, nil
+		}
+		if ps.destPlatform == "windows" {
+			escaped, problem := windowsRel(rel)
+			if problem != "" && ps.windowsNames == windowsNamesSkip {
+				warnColor.Printf("⚠️  Skipped %s: %s on Windows\n", path, problem)
+				return false, nil
+			}
+			if problem != "" {
+				mu.Lock()
+				renamed = append(renamed, fmt.Sprintf("%s → %s (%s)", path, escaped, problem))
+				mu.Unlock()
+				rel = escaped
+			}
+		}
+		target, err := secureJoin(dstRoot, rel)
+		if err != nil {
+			return false, err
+		}
+
+		// mode is that of the entry, or of a followed link's target
+		mode := d.Type()
+		if mode&fs.ModeSymlink != 0 {
+			switch links {
+			case symlinkPreserve:
+				return false, ps.copySymlink(path, target)
+			case symlinkSkip:
+				mu.Lock()
+				skippedLinks = append(skippedLinks, path)
+				mu.Unlock()
+				return false, nil
+			}
+			info, skipped := followLink(path)
+			if info == nil {
+				warnColor.Printf("%s: %s\n", skipped, path)
+				return false, nil
+			}
+			mode = info.Mode().Type()
+		}
+
+		// Directories are created up front so workers only ever write files
+		if mode.IsDir() {
+			info, err := os.Stat(path)
+			if err != nil {
+				return false, err
+			}
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return false, err
+			}
+			if err := os.Chmod(target, ps.fileMode(path, info.Mode()).Perm()); err != nil {
+				return false, err
+			}
+			ps.copyMetadata(path, target, target)
+			return true, nil
+		}
+		if isTempFile(path) {
+			// Left by a write that never finished
+			return false, nil
+		}
+		if !mode.IsRegular() {
+			if ps.verbose {
+				warnColor.Printf("⏭️  Skipped (not a regular file): %s\n", path)
+			}
+			return false, nil
+		}
+
+		mu.Lock()
+		jobs = append(jobs, copyJob{src: path, dst: target})
+		mu.Unlock()
+		return false, nil
+	})
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].src < jobs[j].src })
+	if caseInsensitive(dstRoot, ps.destPlatform) {
+		jobs = dropCaseCollisions(jobs)
+	}
+	if len(renamed) > 0 {
+		warnColor.Printf("⚠️  Renamed %d names Windows cannot use in %s\n", len(renamed), srcRoot)
+		if ps.verbose {
+			sort.Strings(renamed)
+			for _, line := range renamed {
+				noticeColor.Printf("   🔤 %s\n", line)
+			}
+		}
+	}
+	if len(skippedLinks) > 0 {
+		warnColor.Printf("⚠️  Skipped %d symlinks in %s\n", len(skippedLinks), srcRoot)
+		if ps.verbose {
+			sort.Strings(skippedLinks)
+			for _, path := range skippedLinks {
+				noticeColor.Printf("   ⏭️  %s\n", path)
+			}
+		}
+	}
+
+	return jobs, err
+}
+
+// copyParallel runs jobs on a bounded pool of ps.jobs workers, reporting
+// progress from a single goroutine and collecting every failure. Once ctx is
+// cancelled no new files are started; files in flight stop at their next
+// checkpoint
+func (ps *ProfileSync) copyParallel(ctx context.Context, jobs []copyJob, progress func(done, total int)) error {
+	workers := ps.jobs
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	queue := make(chan copyJob)
+	results := make(chan error)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if err := ps.copyFile(ctx, job.src, job.dst); err != nil {
+					results <- fmt.Errorf("%s: %w", job.src, err)
+					continue
+				}
+				results <- nil
+			}
+		}()
+	}
+
+	go func() {
+	feed:
+		for _, job := range jobs {
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	var errs []error
+	done := 0
+	for err := range results {
+		if errors.Is(err, context.Canceled) {
+			continue
+		}
+		done++
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if progress != nil {
+			progress(done, len(jobs))
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// daemonAddress is where the daemon listens unless told otherwise
+const daemonAddress = "127.0.0.1:7438"
+
+// How much a long-running daemon keeps: the most recent jobs, and the last
+// lines of each job's output
+const (
+	maxDaemonJobs = 100
+	maxJobLines   = 10000
+)
+
+// Job states reported by the daemon API
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// daemonTokenPath holds the token API clients authenticate with
+func daemonTokenPath() string {
+	return filepath.Join(stateDir(), "daemon.token")
+}
+
+// daemonInfoPath tells clients where a running daemon listens
+func daemonInfoPath() string {
+	return filepath.Join(stateDir(), "daemon.json")
+}
+
+// daemonToken reads the API token, creating one on first use. It is kept
+// in plain text, even with state encryption, so clients can read it
+func daemonToken() (string, error) {
+	data, err := os.ReadFile(daemonTokenPath())
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	if err := os.MkdirAll(stateDir(), 0700); err != nil {
+		return "", err
+	}
+	return token, os.WriteFile(daemonTokenPath(), []byte(token+"\n"), 0600)
+}
+
+// applyRequest is the body of POST /v1/apply. Unset fields take the
+// defaults of `profilesync apply`
+type applyRequest struct {
+	DryRun    *bool  `json:"dry_run"`
+	Force     bool   `json:"force"`
+	Config    string `json:"config"`
+	Profile   string `json:"profile"`
+	Source    string `json:"source"`
+	SourceDir string `json:"source_dir"`
+}
+
+// args turns the request into `profilesync apply` arguments
+func (r applyRequest) args() []string {
+	args := []string{"apply"}
+	if r.DryRun != nil {
+		args = append(args, "--dry-run="+strconv.FormatBool(*r.DryRun))
+	}
+	if r.Force {
+		args = append(args, "--force")
+	}
+	for _, f := range []struct{ flag, value string }{
+		{"config", r.Config},
+		{"profile", r.Profile},
+		{"source", r.Source},
+		{"source-dir", r.SourceDir},
+	} {
+		if f.value != "" {
+			args = append(args, "--"+f.flag+"="+f.value)
+		}
+	}
+	return args
+}
+
+// errJobRunning is returned when a job is started while another runs
+var errJobRunning = errors.New("another job is still running")
+
+// jobInfo describes one command the daemon ran or is running
+type jobInfo struct {
+	ID       string     `json:"id"`
+	Args     []string   `json:"args"`
+	Trigger  string     `json:"trigger"`
+	State    string     `json:"state"`
+	ExitCode int        `json:"exit_code"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	// written counts the output lines so far, including those dropped
+	written int
+}
+
+// daemonJob is a job with its output so far
+type daemonJob struct {
+	jobInfo
+
+	mu      sync.Mutex
+	lines   []string
+	changed chan struct{}
+}
+
+// snapshot copies the job's state and the output lines from index from on,
+// of those written, with a channel closed on the next change. Lines dropped
+// since are left out
+func (j *daemonJob) snapshot(from int) (jobInfo, []string, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	copied := j.jobInfo
+	from -= j.written - len(j.lines)
+	if from < 0 {
+		from = 0
+	}
+	var lines []string
+	if from < len(j.lines) {
+		lines = append(lines, j.lines[from:]...)
+	}
+	return copied, lines, j.changed
+}
+
+// addLine appends a line of output, dropping the oldest beyond
+// maxJobLines. It is called within update
+func (j *daemonJob) addLine(line string) {
+	j.lines = append(j.lines, line)
+	j.written++
+	if len(j.lines) > maxJobLines {
+		j.lines = append(j.lines[:0], j.lines[len(j.lines)-maxJobLines:]...)
+	}
+}
+
+// update changes the job under its lock and wakes its followers
+func (j *daemonJob) update(change func()) {
+	j.mu.Lock()
+	change()
+	close(j.changed)
+	j.changed = make(chan struct{})
+	j.mu.Unlock()
+}
+
+// wait returns the job once it has finished, or as it is when ctx is done
+func (j *daemonJob) wait(ctx context.Context) jobInfo {
+	for {
+		info, _, changed := j.snapshot(0)
+		if info.State != jobRunning {
+			return info
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return info
+		}
+	}
+}
+
+// daemon serves the local API
+type daemon struct {
+	ctx       context.Context
+	token     string
+	exe       string
+	scheduler *scheduler
+	metrics   *daemonMetrics
+	traces    *traceExporter
+	notifier  *notifier
+
+	mu   sync.Mutex
+	jobs []*daemonJob
+	// started counts the jobs so far, numbering them
+	started int
+}
+
+// authorized checks the request's bearer token
+func (d *daemon) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) == 1
+}
+
+// handler routes the API, refusing requests without the token
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/plan", d.handlePlan)
+	mux.HandleFunc("/v1/status", d.handleStatus)
+	mux.HandleFunc("/v1/apply", d.handleApply)
+	mux.HandleFunc("/v1/jobs", d.handleJobs)
+	mux.HandleFunc("/v1/jobs/", d.handleJob)
+	mux.HandleFunc("/v1/schedules", d.handleSchedules)
+	mux.HandleFunc("/metrics", d.handleMetrics)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, "missing or wrong token (see "+daemonTokenPath()+")")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON sends a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeAPIError sends an error as JSON
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// allowMethod answers requests with another method with 405
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeAPIError(w, http.StatusMethodNotAllowed, "use "+method)
+	return false
+}
+
+// apiItem is a plan item as the API shows it
+type apiItem struct {
+	ID          string `json:"id"`
+	Mapping     string `json:"mapping"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Sensitivity string `json:"sensitivity,omitempty"`
+	SkipReason  string `json:"skip_reason,omitempty"`
+	Exists      bool   `json:"exists"`
+}
+
+// handlePlan answers GET /v1/plan with the items apply would migrate. The
+// config, profile, source and source_dir query parameters work as the flags
+// of apply do
+func (d *daemon) handlePlan(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	q := r.URL.Query()
+	source, dest := DetectPlatform(), DetectPlatform()
+	if p := q.Get("source"); p != "" {
+		source = p
+	}
+	switch source {
+	case "linux", "macos", "windows", platformWSL:
+	default:
+		writeAPIError(w, http.StatusBadRequest, "invalid source platform "+source)
+		return
+	}
+
+	ps := NewProfileSync(layoutPlatform(source), dest, true, false, false, false, false, 1)
+	configFile := q.Get("config")
+	if configFile == "" {
+		if _, err := os.Stat(configPath()); err == nil {
+			configFile = configPath()
+		}
+	}
+	if configFile != "" {
+		cfg, err := loadConfig(configFile, q.Get("profile"))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "loading config: "+err.Error())
+			return
+		}
+		ps.mappings = cfg.Mappings
+	}
+	sourceHome := GetHomeDir(source)
+	if dir := q.Get("source_dir"); dir != "" {
+		sourceHome = dir
+	}
+	if err := ps.CreateMigrationPlan(r.Context(), sourceHome, GetHomeDir(dest)); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "creating migration plan: "+err.Error())
+		return
+	}
+
+	items := []apiItem{}
+	for _, item := range ps.migrationPlan.Items {
+		_, err := os.Stat(item.SourcePath)
+		items = append(items, apiItem{
+			ID:          item.ID,
+			Mapping:     item.Mapping,
+			Source:      item.SourcePath,
+			Destination: item.DestinationPath,
+			Type:        item.Type,
+			Description: item.Description,
+			Sensitivity: item.Sensitivity,
+			SkipReason:  item.SkipReason,
+			Exists:      err == nil,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"source": sourceHome, "items": items})
+}
+
+// handleStatus answers GET /v1/status with the drift of every deployed file,
+// as `profilesync status --all` shows it
+func (d *daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	state, err := loadDeployState()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "reading deploy state: "+err.Error())
+		return
+	}
+	type apiFile struct {
+		Path       string    `json:"path"`
+		Mapping    string    `json:"mapping"`
+		Drift      string    `json:"drift"`
+		DeployedAt time.Time `json:"deployed_at"`
+	}
+	files := []apiFile{}
+	for dest, f := range state.Files {
+		files = append(files, apiFile{Path: dest, Mapping: f.Mapping, Drift: f.drift(dest), DeployedAt: f.DeployedAt})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	writeJSON(w, http.StatusOK, map[string]any{"files": files})
+}
+
+// handleApply answers POST /v1/apply by starting an apply in the
+// background. Only one runs at a time
+func (d *daemon) handleApply(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req applyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+			return
+		}
+	}
+
+	job, err := d.start(req.args(), "api")
+	if err != nil {
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	}
+	snapshot, _, _ := job.snapshot(0)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+// start runs a profilesync command as a new job, unless one is running
+func (d *daemon) start(args []string, trigger string) (*daemonJob, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, j := range d.jobs {
+		if running, _, _ := j.snapshot(0); running.State == jobRunning {
+			return nil, fmt.Errorf("%w (job %s)", errJobRunning, j.ID)
+		}
+	}
+	job := &daemonJob{
+		jobInfo: jobInfo{
+			ID:      strconv.Itoa(d.started + 1),
+			Args:    args,
+			Trigger: trigger,
+			State:   jobRunning,
+			Started: time.Now().UTC(),
+		},
+		changed: make(chan struct{}),
+	}
+	d.started++
+	d.jobs = append(d.jobs, job)
+	// Only one job runs at a time, so those let go of have all finished
+	if len(d.jobs) > maxDaemonJobs {
+		d.jobs = append(d.jobs[:0], d.jobs[len(d.jobs)-maxDaemonJobs:]...)
+	}
+	go d.run(job)
+	return job, nil
+}
+
+// run runs a job's command as a child process, collecting its output. A
+// child keeps prompts and exits of the CLI out of the daemon. Its
+// structured log feeds the daemon's metrics and traces
+func (d *daemon) run(job *daemonJob) {
+	cmd := exec.CommandContext(d.ctx, d.exe, job.Args...)
+	cmd.Env = append(os.Environ(), "NO_COLOR=1")
+	logFile, err := os.CreateTemp(stateDir(), "job-*.log")
+	if err == nil {
+		logFile.Close()
+		defer os.Remove(logFile.Name())
+		cmd.Env = append(cmd.Env, "PROFILESYNC_LOG_FILE="+logFile.Name(), "PROFILESYNC_LOG_FORMAT=json", "PROFILESYNC_LOG_LEVEL=info")
+	}
+	out, err := cmd.StdoutPipe()
+	if err == nil {
+		cmd.Stderr = cmd.Stdout
+		err = cmd.Start()
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			line := scanner.Text()
+			job.update(func() { job.addLine(line) })
+		}
+		err = cmd.Wait()
+	}
+
+	job.update(func() {
+		finished := time.Now().UTC()
+		job.Finished = &finished
+		job.State = jobSucceeded
+		var exit *exec.ExitError
+		switch {
+		case errors.As(err, &exit):
+			job.State, job.ExitCode = jobFailed, exit.ExitCode()
+		case err != nil:
+			job.State, job.ExitCode = jobFailed, -1
+			job.addLine(err.Error())
+		}
+	})
+
+	var records []jobRecord
+	if logFile != nil {
+		records = readJobLog(logFile.Name())
+	}
+	info, lines, _ := job.snapshot(0)
+	d.metrics.record(info, records)
+	if d.notifier != nil && strings.HasPrefix(info.Trigger, "schedule:") {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		d.notifier.notify(ctx, newJobEvent(info, records, lines))
+	}
+	if d.traces != nil {
+		// Exporting outlives a daemon asked to stop, briefly
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := d.traces.export(ctx, info, records); err != nil {
+			warnColor.Printf("⚠️  Could not export the trace of job %s: %v\n", job.ID, err)
+		}
+	}
+}
+
+// handleJobs answers GET /v1/jobs with every job since the daemon started,
+// whether started through the API or by a schedule
+func (d *daemon) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	d.mu.Lock()
+	jobs := []jobInfo{}
+	for _, j := range d.jobs {
+		snapshot, _, _ := j.snapshot(0)
+		jobs = append(jobs, snapshot)
+	}
+	d.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": jobs})
+}
+
+// handleJob answers GET /v1/jobs/ID with a job and its output, and
+// GET /v1/jobs/ID/events with its progress as server-sent events
+func (d *daemon) handleJob(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	id, events := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), "/events")
+	var job *daemonJob
+	d.mu.Lock()
+	for _, j := range d.jobs {
+		if j.ID == id {
+			job = j
+		}
+	}
+	d.mu.Unlock()
+	if job == nil {
+		writeAPIError(w, http.StatusNotFound, "no job "+id)
+		return
+	}
+
+	if !events {
+		snapshot, lines, _ := job.snapshot(0)
+		writeJSON(w, http.StatusOK, map[string]any{"job": snapshot, "output": lines})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	sent := 0
+	for {
+		snapshot, lines, changed := job.snapshot(sent)
+		for _, line := range lines {
+			data, _ := json.Marshal(map[string]string{"line": line})
+			fmt.Fprintf(w, "event: output\ndata: %s\n\n", data)
+		}
+		sent = snapshot.written
+		if snapshot.State != jobRunning {
+			data, _ := json.Marshal(snapshot)
+			fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// runDaemon handles `profilesync daemon`, serving the local API until
+// interrupted
+func runDaemon(args []string) {
+	flags := newFlagSet("daemon", "daemon [flags]")
+	listen := flags.String("listen", daemonAddress, "Address to serve the API on")
+	configFile := flags.String("config", "", "Config file with the schedules to run (default "+configPath()+" if it exists)")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config to load")
+	metricsListen := flags.String("metrics-listen", "", "Also serve Prometheus metrics on this address without the token, e.g. :9438 (default only on the API, with the token)")
+	otlpEndpoint := flags.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "Send a trace of each job to this OpenTelemetry collector over OTLP/HTTP, e.g. http://collector:4318 (default $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flags.Parse(args)
+
+	if *configFile == "" {
+		if _, err := os.Stat(configPath()); err == nil {
+			*configFile = configPath()
+		}
+	}
+	var schedules map[string]*Schedule
+	var notifications map[string]*Notification
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile, *profile)
+		if err != nil {
+			errorColor.Println("❌ Error loading config:", err)
+			os.Exit(1)
+		}
+		schedules, notifications = cfg.Schedules, cfg.Notifications
+	}
+
+	host, _, err := net.SplitHostPort(*listen)
+	if err != nil {
+		errorColor.Println("❌ Invalid --listen address:", err)
+		os.Exit(2)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		warnColor.Println("⚠️  Serving the API beyond this machine; anyone with the token can apply profiles")
+	}
+	token, err := daemonToken()
+	if err != nil {
+		errorColor.Println("❌ Error creating the API token:", err)
+		os.Exit(1)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		errorColor.Println("❌", err)
+		os.Exit(1)
+	}
+	traces, err := newTraceExporter(*otlpEndpoint)
+	if err != nil {
+		errorColor.Printf("❌ %v\n", err)
+		os.Exit(2)
+	}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		errorColor.Println("❌ Error listening:", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	d := &daemon{ctx: ctx, token: token, exe: exe, metrics: newDaemonMetrics(), traces: traces, notifier: newNotifier(notifications)}
+	if len(schedules) > 0 {
+		if d.scheduler, err = newScheduler(d, schedules); err != nil {
+			errorColor.Println("❌ Error reading schedule state:", err)
+			os.Exit(1)
+		}
+		d.scheduler.start(ctx)
+		noticeColor.Printf("⏰ Running %d schedules from the config\n", len(schedules))
+	}
+	server := &http.Server{Handler: d.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		server.Shutdown(shutdown)
+	}()
+
+	info, _ := json.Marshal(map[string]any{"address": ln.Addr().String(), "pid": os.Getpid()})
+	if err := os.WriteFile(daemonInfoPath(), info, 0600); err != nil {
+		warnColor.Println("⚠️  Could not record the daemon address:", err)
+	}
+	defer os.Remove(daemonInfoPath())
+
+	if *metricsListen != "" {
+		metricsLn, err := net.Listen("tcp", *metricsListen)
+		if err != nil {
+			errorColor.Println("❌ Error listening for metrics:", err)
+			os.Exit(1)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", d.handleMetrics)
+		metricsServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go metricsServer.Serve(metricsLn)
+		go func() {
+			<-ctx.Done()
+			metricsServer.Close()
+		}()
+		noticeColor.Printf("📈 Serving metrics on http://%s/metrics\n", metricsLn.Addr())
+	}
+	if traces != nil {
+		noticeColor.Printf("🔭 Sending job traces to %s\n", traces.url)
+	}
+
+	successColor.Printf("🛰️  Serving the profilesync API on http://%s\n", ln.Addr())
+	noticeColor.Printf("Clients authenticate with the token in %s\n", daemonTokenPath())
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errorColor.Println("❌", err)
+		os.Exit(1)
+	}
+}
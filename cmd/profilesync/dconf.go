@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dconfGroup is a set of desktop settings captured together
+type dconfGroup struct {
+	Name  string
+	Paths []string
+}
+
+// dconfGroups are the GNOME settings captured by default
+var dconfGroups = []dconfGroup{
+	{"keybindings", []string{
+		"/org/gnome/desktop/wm/keybindings/",
+		"/org/gnome/shell/keybindings/",
+		"/org/gnome/mutter/keybindings/",
+		"/org/gnome/settings-daemon/plugins/media-keys/",
+	}},
+	{"terminal", []string{
+		"/org/gnome/terminal/legacy/profiles:/",
+		"/org/gnome/terminal/legacy/keybindings/",
+	}},
+	{"interface", []string{
+		"/org/gnome/desktop/interface/",
+		"/org/gnome/desktop/wm/preferences/",
+	}},
+	{"peripherals", []string{
+		"/org/gnome/desktop/peripherals/",
+		"/org/gnome/desktop/input-sources/",
+	}},
+}
+
+// homeToken stands in for the home directory in captured desktop settings
+const homeToken = "${HOME}"
+
+// dconfPath and gsettingsPath are where desktop settings live in a profile store
+func dconfPath(dir string) string {
+	return filepath.Join(dir, "linux", "dconf.ini")
+}
+
+func gsettingsPath(dir string) string {
+	return filepath.Join(dir, "linux", "gsettings.txt")
+}
+
+// selectDconfPaths returns the paths of the named groups, or of all of them
+func selectDconfPaths(names string) ([]string, error) {
+	var paths []string
+	if names == "" {
+		for _, g := range dconfGroups {
+			paths = append(paths, g.Paths...)
+		}
+		return paths, nil
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, g := range dconfGroups {
+			if g.Name == name {
+				paths = append(paths, g.Paths...)
+				found = true
+			}
+		}
+		if !found {
+			var all []string
+			for _, g := range dconfGroups {
+				all = append(all, g.Name)
+			}
+			return nil, fmt.Errorf("unknown group %q (must be one of: %s)", name, strings.Join(all, ", "))
+		}
+	}
+	return paths, nil
+}
+
+// normalizeDconfPath makes a dconf directory path start and end with "/"
+func normalizeDconfPath(path string) string {
+	return "/" + strings.Trim(path, "/") + "/"
+}
+
+// dumpDconf reads a dconf directory, naming sections by their path from the
+// root so every captured directory fits in one file
+func dumpDconf(path string) ([]*iniSection, error) {
+	out, err := exec.Command("dconf", "dump", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("dconf dump %s: %w", path, err)
+	}
+	sections := parseINI(out)
+	base := strings.Trim(path, "/")
+	for _, s := range sections {
+		switch s.Name {
+		case "/":
+			s.Name = base
+		default:
+			s.Name = strings.Trim(base+"/"+s.Name, "/")
+		}
+	}
+	return sections, nil
+}
+
+// underDconfPath reports whether a section belongs to a dconf directory
+func underDconfPath(section, path string) bool {
+	base := strings.Trim(path, "/")
+	return section == base || strings.HasPrefix(section, base+"/")
+}
+
+// replaceHome swaps the home directory for homeToken, or back
+func replaceHome(value, home string, capture bool) string {
+	if capture {
+		return strings.ReplaceAll(value, home+"/", homeToken+"/")
+	}
+	return strings.ReplaceAll(value, homeToken+"/", home+"/")
+}
+
+// readSections reads an ini file, or nothing when it does not exist
+func readSections(path string) ([]*iniSection, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return parseINI(data), err
+}
+
+// CaptureDconf dumps the given dconf directories into dir/linux/dconf.ini,
+// replacing what was captured from them before and keeping other sections
+func CaptureDconf(dir string, paths []string) (int, error) {
+	home := GetHomeDir("linux")
+	existing, err := readSections(dconfPath(dir))
+	if err != nil {
+		return 0, err
+	}
+
+	var kept []*iniSection
+	for _, s := range existing {
+		captured := false
+		for _, path := range paths {
+			if underDconfPath(s.Name, path) {
+				captured = true
+			}
+		}
+		if !captured && s.Name != "" {
+			kept = append(kept, s)
+		}
+	}
+
+	count := 0
+	for _, path := range paths {
+		sections, err := dumpDconf(path)
+		if err != nil {
+			return 0, err
+		}
+		for _, s := range sections {
+			for _, key := range s.Keys {
+				s.Vals[key] = replaceHome(s.Vals[key], home, true)
+				count++
+			}
+			kept = append(kept, s)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Name < kept[j].Name })
+
+	header := newINISection("")
+	header.Set("# GNOME settings captured by profilesync. Apply with `profilesync apply dconf`,", "")
+	header.Set("# or by hand with `dconf load / < dconf.ini` after replacing "+homeToken+".", "")
+	data := formatINI(append([]*iniSection{header}, kept...), "=", "\n")
+	if err := os.MkdirAll(filepath.Dir(dconfPath(dir)), 0755); err != nil {
+		return 0, err
+	}
+	return count, os.WriteFile(dconfPath(dir), data, 0644)
+}
+
+// gsettingsLine is one "schema key value" line of `gsettings list-recursively`
+type gsettingsLine struct {
+	Schema, Key, Value string
+}
+
+// parseGsettings reads `gsettings list-recursively` output
+func parseGsettings(data []byte) []gsettingsLine {
+	var lines []gsettingsLine
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) == 3 {
+			lines = append(lines, gsettingsLine{fields[0], fields[1], fields[2]})
+		}
+	}
+	return lines
+}
+
+// CaptureGsettings saves every key of the given schemas into
+// dir/linux/gsettings.txt, replacing earlier captures of those schemas
+func CaptureGsettings(dir string, schemas []string) (int, error) {
+	home := GetHomeDir("linux")
+	data, err := os.ReadFile(gsettingsPath(dir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	replaced := map[string]bool{}
+	for _, schema := range schemas {
+		replaced[schema] = true
+	}
+
+	var lines []gsettingsLine
+	for _, l := range parseGsettings(data) {
+		if !replaced[l.Schema] {
+			lines = append(lines, l)
+		}
+	}
+	count := 0
+	for _, schema := range schemas {
+		out, err := exec.Command("gsettings", "list-recursively", schema).Output()
+		if err != nil {
+			return 0, fmt.Errorf("gsettings list-recursively %s: %w", schema, err)
+		}
+		for _, l := range parseGsettings(out) {
+			l.Value = replaceHome(l.Value, home, true)
+			lines = append(lines, l)
+			count++
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Schema < lines[j].Schema })
+
+	var b bytes.Buffer
+	b.WriteString("# gsettings keys captured by profilesync, as `schema key value`\n")
+	for _, l := range lines {
+		fmt.Fprintf(&b, "%s %s %s\n", l.Schema, l.Key, l.Value)
+	}
+	if err := os.MkdirAll(filepath.Dir(gsettingsPath(dir)), 0755); err != nil {
+		return 0, err
+	}
+	return count, os.WriteFile(gsettingsPath(dir), b.Bytes(), 0644)
+}
+
+// ApplyDconf loads captured dconf keys that differ from this machine's in
+// one `dconf load`, so related keys change together
+func ApplyDconf(dir string, dryRun bool) (changed, unchanged int, err error) {
+	captured, err := readSections(dconfPath(dir))
+	if err != nil || len(captured) == 0 {
+		return 0, 0, err
+	}
+	home := GetHomeDir("linux")
+
+	current := map[string]*iniSection{}
+	if sections, err := dumpDconf("/"); err == nil {
+		for _, s := range sections {
+			current[s.Name] = s
+		}
+	} else {
+		return 0, 0, err
+	}
+
+	var load []*iniSection
+	for _, s := range captured {
+		if s.Name == "" {
+			continue
+		}
+		diff := newINISection(s.Name)
+		for _, key := range s.Keys {
+			if isINIComment(key) {
+				continue
+			}
+			value := replaceHome(s.Vals[key], home, false)
+			if have, ok := current[s.Name]; ok && have.Get(key) == value {
+				unchanged++
+				continue
+			}
+			diff.Set(key, value)
+			if dryRun {
+				noticeColor.Printf("🖥️  Would set /%s/%s = %s\n", s.Name, key, value)
+			}
+		}
+		if len(diff.Keys) > 0 {
+			load = append(load, diff)
+			changed += len(diff.Keys)
+		}
+	}
+	if dryRun || len(load) == 0 {
+		return changed, unchanged, nil
+	}
+
+	noticeColor.Printf("🖥️  Running: dconf load / (%d key(s))\n", changed)
+	cmd := exec.Command("dconf", "load", "/")
+	cmd.Stdin = bytes.NewReader(formatINI(load, "=", "\n"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return 0, unchanged, fmt.Errorf("dconf load: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return changed, unchanged, nil
+}
+
+// ApplyGsettings sets captured gsettings keys that differ from this
+// machine's. Schemas that are not installed here are reported and skipped
+func ApplyGsettings(dir string, dryRun bool) (changed, unchanged int, err error) {
+	data, err := os.ReadFile(gsettingsPath(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	home := GetHomeDir("linux")
+
+	missing := map[string]bool{}
+	failed := 0
+	for _, l := range parseGsettings(data) {
+		if missing[l.Schema] {
+			continue
+		}
+		out, err := exec.Command("gsettings", "get", l.Schema, l.Key).Output()
+		if err != nil {
+			if _, err := exec.Command("gsettings", "list-keys", l.Schema).Output(); err != nil {
+				warnColor.Printf("⚠️  Schema %s is not installed here; skipped\n", l.Schema)
+				missing[l.Schema] = true
+			} else {
+				warnColor.Printf("⚠️  %s %s no longer exists; skipped\n", l.Schema, l.Key)
+			}
+			continue
+		}
+		value := replaceHome(l.Value, home, false)
+		if strings.TrimSpace(string(out)) == value {
+			unchanged++
+			continue
+		}
+		if dryRun {
+			noticeColor.Printf("🖥️  Would run: gsettings set %s %s %s\n", l.Schema, l.Key, value)
+			changed++
+			continue
+		}
+		noticeColor.Printf("🖥️  Running: gsettings set %s %s %s\n", l.Schema, l.Key, value)
+		if out, err := exec.Command("gsettings", "set", l.Schema, l.Key, value).CombinedOutput(); err != nil {
+			errorColor.Printf("❌ gsettings set %s %s failed: %v %s\n", l.Schema, l.Key, err, strings.TrimSpace(string(out)))
+			failed++
+			continue
+		}
+		changed++
+	}
+	if failed > 0 {
+		return changed, unchanged, fmt.Errorf("%d gsettings command(s) failed", failed)
+	}
+	return changed, unchanged, nil
+}
+
+// runCaptureDconf handles `profilesync capture dconf`
+func runCaptureDconf(args []string) {
+	flags := newFlagSet("capture dconf", "capture dconf [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to write to")
+	names := flags.String("groups", "", "Comma-separated groups to capture (default: all of keybindings, terminal, interface, peripherals)")
+	extra := flags.String("paths", "", "Comma-separated extra dconf directories to capture, e.g. /org/gnome/gedit/")
+	schemas := flags.String("schemas", "", "Comma-separated gsettings schemas to capture as well")
+	flags.Parse(args)
+
+	if DetectPlatform() != "linux" {
+		warnColor.Println("⏭️  dconf and gsettings settings can only be captured on Linux; nothing to do")
+		return
+	}
+	paths, err := selectDconfPaths(*names)
+	if err != nil {
+		errorColor.Println("❌ Invalid --groups value:", err)
+		os.Exit(2)
+	}
+	if flagWasSet(flags, "paths") && !flagWasSet(flags, "groups") {
+		paths = nil
+	}
+	for _, path := range splitList(*extra) {
+		paths = append(paths, normalizeDconfPath(path))
+	}
+
+	if _, err := exec.LookPath("dconf"); err != nil {
+		warnColor.Println("⚠️  dconf is not installed; skipping dconf directories")
+	} else if len(paths) > 0 {
+		count, err := CaptureDconf(*dir, paths)
+		if err != nil {
+			errorColor.Println("❌ Error capturing dconf settings:", err)
+			os.Exit(1)
+		}
+		successColor.Printf("✅ %d dconf key(s) from %d path(s) saved to %s\n", count, len(paths), dconfPath(*dir))
+	}
+
+	if list := splitList(*schemas); len(list) > 0 {
+		count, err := CaptureGsettings(*dir, list)
+		if err != nil {
+			errorColor.Println("❌ Error capturing gsettings:", err)
+			os.Exit(1)
+		}
+		successColor.Printf("✅ %d gsettings key(s) from %d schema(s) saved to %s\n", count, len(list), gsettingsPath(*dir))
+	}
+}
+
+// runApplyDconf handles `profilesync apply dconf`
+func runApplyDconf(args []string) {
+	flags := newFlagSet("apply dconf", "apply dconf [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to read from")
+	dryRun := flags.Bool("dry-run", true, "Show the settings that would change without changing them")
+	flags.Parse(args)
+
+	if DetectPlatform() != "linux" {
+		warnColor.Printf("⏭️  Skipping dconf and gsettings settings on %s\n", DetectPlatform())
+		return
+	}
+	_, dconfErr := os.Stat(dconfPath(*dir))
+	_, gsettingsErr := os.Stat(gsettingsPath(*dir))
+	if dconfErr != nil && gsettingsErr != nil {
+		errorColor.Println("❌ No desktop settings in", *dir, "(run `profilesync capture dconf` first)")
+		os.Exit(1)
+	}
+
+	verb := "changed"
+	if *dryRun {
+		verb = "to change"
+	}
+	failed := false
+	if dconfErr == nil {
+		if _, err := exec.LookPath("dconf"); err != nil {
+			warnColor.Println("⏭️  dconf is not installed; skipping dconf settings")
+		} else {
+			changed, unchanged, err := ApplyDconf(*dir, *dryRun)
+			if err != nil {
+				errorColor.Println("❌ Error applying dconf settings:", err)
+				failed = true
+			}
+			fmt.Printf("   dconf: %d key(s) %s, %d already match\n", changed, verb, unchanged)
+		}
+	}
+	if gsettingsErr == nil {
+		if _, err := exec.LookPath("gsettings"); err != nil {
+			warnColor.Println("⏭️  gsettings is not installed; skipping gsettings keys")
+		} else {
+			changed, unchanged, err := ApplyGsettings(*dir, *dryRun)
+			if err != nil {
+				errorColor.Println("❌ Error applying gsettings:", err)
+				failed = true
+			}
+			fmt.Printf("   gsettings: %d key(s) %s, %d already match\n", changed, verb, unchanged)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+	if *dryRun {
+		warnColor.Println("⚠️  This was a DRY RUN. Run with --dry-run=false to apply.")
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of questions whose answers are remembered
+const (
+	decisionKubeContext = "kube-context"
+	decisionAWSProfile  = "aws-profile"
+	decisionSSHKey      = "ssh-key"
+	decisionUntrusted   = "untrusted"
+	decisionDiscover    = "discover"
+)
+
+// decisionTitles describe each kind of decision in listings
+var decisionTitles = map[string]string{
+	decisionKubeContext: "kube context",
+	decisionAWSProfile:  "AWS profile",
+	decisionSSHKey:      "SSH key without a passphrase",
+	decisionUntrusted:   "untrusted item",
+	decisionDiscover:    "config discover suggests",
+}
+
+// Decision is a remembered answer to a question asked during a migration
+type Decision struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Answer bool   `json:"answer"`
+	// Fingerprint ties the answer to what was asked about, e.g. the vetting
+	// findings, so the question comes back when that changes
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	DecidedAt   time.Time `json:"decidedAt"`
+}
+
+// Decisions holds remembered answers by machine name
+type Decisions struct {
+	Machines map[string][]Decision `json:"machines"`
+}
+
+var (
+	decisionsMu     sync.Mutex
+	decisionsLoaded *Decisions
+)
+
+// decisionsPath is where remembered answers are kept
+func decisionsPath() string {
+	return filepath.Join(stateDir(), "decisions.json")
+}
+
+// machineName identifies this machine in the remembered answers
+func machineName() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return strings.ToLower(name)
+	}
+	return "unknown"
+}
+
+// loadDecisions reads the remembered answers, or none when there are none yet
+func loadDecisions() (*Decisions, error) {
+	d := &Decisions{Machines: map[string][]Decision{}}
+	data, err := readStateFile(decisionsPath())
+	if os.IsNotExist(err) {
+		return d, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", decisionsPath(), err)
+	}
+	if d.Machines == nil {
+		d.Machines = map[string][]Decision{}
+	}
+	return d, nil
+}
+
+// saveDecisions stores the remembered answers
+func saveDecisions(d *Decisions) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeStateFile(decisionsPath(), data, 0600)
+}
+
+// decisions returns the remembered answers, loading them on first use.
+// The caller must hold decisionsMu
+func decisions() *Decisions {
+	if decisionsLoaded == nil {
+		d, err := loadDecisions()
+		if err != nil {
+			warnColor.Println("⚠️  Could not read remembered answers:", err)
+			d = &Decisions{Machines: map[string][]Decision{}}
+		}
+		decisionsLoaded = d
+	}
+	return decisionsLoaded
+}
+
+// recall returns the answer remembered on this machine for a question,
+// unless --ask-again was given
+func (ps *ProfileSync) recall(kind, name, fingerprint string) (answer, ok bool) {
+	if ps.askAgain {
+		return false, false
+	}
+	decisionsMu.Lock()
+	defer decisionsMu.Unlock()
+
+	for _, d := range decisions().Machines[machineName()] {
+		if d.Kind == kind && d.Name == name && d.Fingerprint == fingerprint {
+			verb := "Leaving out"
+			if d.Answer {
+				verb = "Including"
+			}
+			noticeColor.Printf("↩️  %s %s %s, as answered on %s (see `profilesync decisions`)\n", verb, decisionTitles[kind], name, d.DecidedAt.Local().Format("2006-01-02"))
+			return d.Answer, true
+		}
+	}
+	return false, false
+}
+
+// remember stores an answer for this machine, replacing an earlier answer
+// to the same question
+func (ps *ProfileSync) remember(kind, name, fingerprint string, answer bool) {
+	decisionsMu.Lock()
+	defer decisionsMu.Unlock()
+
+	d := decisions()
+	machine := machineName()
+	kept := d.Machines[machine][:0]
+	for _, old := range d.Machines[machine] {
+		if old.Kind != kind || old.Name != name {
+			kept = append(kept, old)
+		}
+	}
+	d.Machines[machine] = append(kept, Decision{
+		Kind:        kind,
+		Name:        name,
+		Answer:      answer,
+		Fingerprint: fingerprint,
+		DecidedAt:   time.Now().UTC(),
+	})
+	if err := saveDecisions(d); err != nil {
+		warnColor.Println("⚠️  Could not remember answer:", err)
+	}
+}
+
+// decide asks a yes/no question about one item, using and keeping answers
+// remembered for this machine. Without a terminal def is used and nothing
+// is remembered
+func (ps *ProfileSync) decide(kind, name, question string, def bool) bool {
+	if answer, ok := ps.recall(kind, name, ""); ok {
+		return answer
+	}
+	if !ps.canPrompt() {
+		return def
+	}
+	answer := confirm(question, def)
+	ps.remember(kind, name, "", answer)
+	return answer
+}
+
+// findingsFingerprint identifies a set of vetting findings
+func findingsFingerprint(findings []VetFinding) string {
+	h := sha256.New()
+	for _, f := range findings {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\n", f.Kind, f.Path, f.Detail)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// printDecisions lists one machine's remembered answers, numbered from 1
+func printDecisions(list []Decision) {
+	for i, d := range list {
+		answer, color := "no ", warnColor
+		if d.Answer {
+			answer, color = "yes", successColor
+		}
+		color.Printf("%2d. [%s] %s %s", i+1, answer, decisionTitles[d.Kind], d.Name)
+		fmt.Printf("  (%s)\n", d.DecidedAt.Local().Format("2006-01-02 15:04"))
+	}
+}
+
+// runDecisions handles `profilesync decisions [clear [N...]]`
+func runDecisions(args []string) {
+	flags := newFlagSet("decisions", "decisions [flags] [clear [N...]]")
+	machine := flags.String("machine", machineName(), "Machine whose remembered answers to show or clear")
+	all := flags.Bool("all-machines", false, "Show the remembered answers of every machine")
+	flags.Parse(args)
+	args = flags.Args()
+
+	d, err := loadDecisions()
+	if err != nil {
+		errorColor.Println("❌ Error reading remembered answers:", err)
+		os.Exit(1)
+	}
+
+	if len(args) == 0 {
+		machines := []string{*machine}
+		if *all {
+			machines = machines[:0]
+			for name := range d.Machines {
+				machines = append(machines, name)
+			}
+			sort.Strings(machines)
+		}
+		shown := false
+		for _, name := range machines {
+			if len(d.Machines[name]) == 0 {
+				continue
+			}
+			infoColor.Printf("🖥️  %s:\n", name)
+			printDecisions(d.Machines[name])
+			shown = true
+		}
+		if !shown {
+			successColor.Println("✅ No remembered answers")
+			return
+		}
+		fmt.Println("Forget answers with `profilesync decisions clear N` (or `clear` for all), or ignore them once with --ask-again.")
+		return
+	}
+
+	if args[0] != "clear" {
+		errorColor.Println("❌ Unknown decisions command:", args[0])
+		errorColor.Println("Must be one of: clear")
+		os.Exit(2)
+	}
+	if *all {
+		errorColor.Println("❌ --all-machines only lists answers; use --machine to clear another machine's")
+		os.Exit(2)
+	}
+
+	list := d.Machines[*machine]
+	cleared := len(list)
+	if len(args) == 1 {
+		delete(d.Machines, *machine)
+	} else {
+		drop := map[int]bool{}
+		for _, arg := range args[1:] {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > len(list) {
+				errorColor.Printf("❌ No remembered answer %s\n", arg)
+				os.Exit(2)
+			}
+			drop[n-1] = true
+		}
+		var kept []Decision
+		for i, decision := range list {
+			if !drop[i] {
+				kept = append(kept, decision)
+			}
+		}
+		cleared = len(drop)
+		d.Machines[*machine] = kept
+		if len(kept) == 0 {
+			delete(d.Machines, *machine)
+		}
+	}
+
+	if err := saveDecisions(d); err != nil {
+		errorColor.Println("❌ Error saving remembered answers:", err)
+		os.Exit(1)
+	}
+	successColor.Printf("🧹 Forgot %d answer(s) for %s; you will be asked again\n", cleared, *machine)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// syncFeatureDelta is announced by ends of a sync that answer the
+// signature, delta and patch requests
+const syncFeatureDelta = "delta"
+
+// deltaMinSize is the smallest file sent as a delta; smaller files cost
+// less to send whole than their signature does
+const deltaMinSize = 64 << 10
+
+// Bytes per block in a delta signature: a block sum for each block
+const deltaSumSize = 4 + 8
+
+// deltaSignature describes a file by the sums of its blocks, so the other
+// end can find which parts of its own version the file already has, as
+// rsync does. Sums holds a rolling checksum and a truncated SHA-256 for
+// each full block
+type deltaSignature struct {
+	BlockSize int    `json:"block_size"`
+	Sums      []byte `json:"sums"`
+}
+
+// deltaOp is one step of rebuilding a file: Data to add as it is, or when
+// there is none, Length bytes to copy from Offset of the old version
+type deltaOp struct {
+	Offset int64  `json:"offset,omitempty"`
+	Length int64  `json:"length,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+}
+
+// deltaBlockSize picks the block size for a file, about the square root
+// of its size as rsync does
+func deltaBlockSize(size int) int {
+	bs := int(math.Sqrt(float64(size)))
+	return min(max(bs, 1024), 64<<10)
+}
+
+// rollingSum is rsync's weak checksum of a block, which can be moved along
+// the data a byte at a time
+type rollingSum struct {
+	a, b uint32
+	n    uint32
+}
+
+// newRollingSum sums block
+func newRollingSum(block []byte) rollingSum {
+	s := rollingSum{n: uint32(len(block))}
+	for i, c := range block {
+		s.a += uint32(c)
+		s.b += uint32(len(block)-i) * uint32(c)
+	}
+	return s
+}
+
+// roll moves the block a byte on, dropping out and taking in
+func (s *rollingSum) roll(out, in byte) {
+	s.a += uint32(in) - uint32(out)
+	s.b += s.a - s.n*uint32(out)
+}
+
+// value combines both halves into the checksum
+func (s rollingSum) value() uint32 {
+	return s.a&0xffff | s.b<<16
+}
+
+// strongSum is the part of a block's SHA-256 kept in a signature
+func strongSum(block []byte) uint64 {
+	sum := sha256.Sum256(block)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// signFile computes the signature of data
+func signFile(data []byte) *deltaSignature {
+	sig := &deltaSignature{BlockSize: deltaBlockSize(len(data))}
+	sig.Sums = make([]byte, 0, len(data)/sig.BlockSize*deltaSumSize)
+	for start := 0; start+sig.BlockSize <= len(data); start += sig.BlockSize {
+		block := data[start : start+sig.BlockSize]
+		sig.Sums = binary.BigEndian.AppendUint32(sig.Sums, newRollingSum(block).value())
+		sig.Sums = binary.BigEndian.AppendUint64(sig.Sums, strongSum(block))
+	}
+	return sig
+}
+
+// computeDelta returns the steps that turn the file sig describes into
+// data, copying every block of it that data contains
+func computeDelta(sig *deltaSignature, data []byte) ([]deltaOp, error) {
+	bs := sig.BlockSize
+	if bs <= 0 || len(sig.Sums)%deltaSumSize != 0 {
+		return nil, errors.New("invalid delta signature")
+	}
+	blocks := map[uint32][]int{}
+	for i := 0; i*deltaSumSize < len(sig.Sums); i++ {
+		weak := binary.BigEndian.Uint32(sig.Sums[i*deltaSumSize:])
+		blocks[weak] = append(blocks[weak], i)
+	}
+
+	var ops []deltaOp
+	literal := 0
+	emitCopy := func(block int) {
+		offset := int64(block) * int64(bs)
+		if n := len(ops); n > 0 && ops[n-1].Data == nil && ops[n-1].Offset+ops[n-1].Length == offset {
+			ops[n-1].Length += int64(bs)
+			return
+		}
+		ops = append(ops, deltaOp{Offset: offset, Length: int64(bs)})
+	}
+
+	i := 0
+	var sum rollingSum
+	if len(data) >= bs {
+		sum = newRollingSum(data[:bs])
+	}
+	for i+bs <= len(data) {
+		match := -1
+		if candidates, ok := blocks[sum.value()]; ok {
+			strong := strongSum(data[i : i+bs])
+			for _, block := range candidates {
+				if binary.BigEndian.Uint64(sig.Sums[block*deltaSumSize+4:]) == strong {
+					match = block
+					break
+				}
+			}
+		}
+		if match >= 0 {
+			if literal < i {
+				ops = append(ops, deltaOp{Data: data[literal:i]})
+			}
+			emitCopy(match)
+			i += bs
+			literal = i
+			if i+bs <= len(data) {
+				sum = newRollingSum(data[i : i+bs])
+			}
+			continue
+		}
+		if i+bs < len(data) {
+			sum.roll(data[i], data[i+bs])
+		}
+		i++
+	}
+	if literal < len(data) {
+		ops = append(ops, deltaOp{Data: data[literal:]})
+	}
+	return ops, nil
+}
+
+// applyDelta rebuilds a file from its old version and the steps
+// computeDelta returned, checking the result has the expected hash
+func applyDelta(old []byte, ops []deltaOp, hash string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, op := range ops {
+		if op.Data != nil {
+			b.Write(op.Data)
+			continue
+		}
+		if op.Offset < 0 || op.Length < 0 || op.Offset+op.Length > int64(len(old)) {
+			return nil, fmt.Errorf("delta copies beyond the end of the file")
+		}
+		b.Write(old[op.Offset : op.Offset+op.Length])
+	}
+	if hashBytes(b.Bytes()) != hash {
+		return nil, errors.New("file rebuilt from the delta does not match")
+	}
+	return b.Bytes(), nil
+}
+
+// deltaLiteralSize is how many bytes of data a delta carries
+func deltaLiteralSize(ops []deltaOp) int64 {
+	var n int64
+	for _, op := range ops {
+		n += int64(len(op.Data))
+	}
+	return n
+}
+
+// syncTransfer counts what a sync sent and received, against what whole
+// files would have taken
+type syncTransfer struct {
+	files, deltas   int
+	sent, whole     int64
+	received, fresh int64
+}
+
+// pull reads the other end's version of f, sending only the changes to
+// the version here, local, when the other end supports it
+func (r *syncRemote) pull(f syncFile, local []byte) ([]byte, error) {
+	r.transfer.files++
+	if r.delta && len(local) >= deltaMinSize {
+		resp, err := r.call(syncRequest{Op: "delta", File: f, Signature: signFile(local)})
+		if err == nil {
+			data, err := applyDelta(local, resp.Delta, f.Hash)
+			if err == nil {
+				r.transfer.deltas++
+				r.transfer.received += deltaLiteralSize(resp.Delta)
+				r.transfer.fresh += int64(len(data))
+				return data, nil
+			}
+		}
+		// The file may have changed since it was listed; read it whole
+	}
+	resp, err := r.call(syncRequest{Op: "read", File: f})
+	if err != nil {
+		return nil, err
+	}
+	r.transfer.received += int64(len(resp.Data))
+	r.transfer.fresh += int64(len(resp.Data))
+	return resp.Data, nil
+}
+
+// push writes data as the other end's version of f, which replaces old,
+// sending only the changes when the other end supports it
+func (r *syncRemote) push(f syncFile, old *syncFile, data []byte) error {
+	r.transfer.files++
+	r.transfer.whole += int64(len(data))
+	if r.delta && old != nil && len(data) >= deltaMinSize {
+		resp, err := r.call(syncRequest{Op: "signature", File: *old})
+		if err == nil && resp.Signature != nil {
+			ops, err := computeDelta(resp.Signature, data)
+			if err == nil {
+				if _, err := r.call(syncRequest{Op: "patch", File: f, Delta: ops}); err == nil {
+					r.transfer.deltas++
+					r.transfer.sent += deltaLiteralSize(ops)
+					return nil
+				}
+			}
+		}
+		// The other end's file may have changed; send the file whole
+	}
+	r.transfer.sent += int64(len(data))
+	_, err := r.call(syncRequest{Op: "write", File: f, Data: data})
+	return err
+}
+
+// serveSignature answers a signature request with the sums of a file
+func (e *syncEndpoint) serveSignature(f syncFile) (*deltaSignature, error) {
+	data, err := e.read(f)
+	if err != nil {
+		return nil, err
+	}
+	return signFile(data), nil
+}
+
+// serveDelta answers a delta request with the changes from the other
+// end's version of a file, which sig describes, to the one here
+func (e *syncEndpoint) serveDelta(f syncFile, sig *deltaSignature) ([]deltaOp, error) {
+	if sig == nil {
+		return nil, errors.New("delta request without a signature")
+	}
+	data, err := e.read(f)
+	if err != nil {
+		return nil, err
+	}
+	return computeDelta(sig, data)
+}
+
+// servePatch answers a patch request, rebuilding a file from the version
+// here and the changes sent
+func (e *syncEndpoint) servePatch(f syncFile, ops []deltaOp) error {
+	old, err := e.read(f)
+	if err != nil {
+		return err
+	}
+	data, err := applyDelta(old, ops, f.Hash)
+	if err != nil {
+		return err
+	}
+	return e.write(f, data)
+}
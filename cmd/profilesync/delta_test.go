@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	old := testContent(4, 1<<20)
+	splice := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	tests := []struct {
+		name     string
+		old, new []byte
+		// maxLiteral bounds the bytes the delta carries; 0 means the
+		// whole new file may be sent
+		maxLiteral int64
+	}{
+		{name: "unchanged", old: old, new: old, maxLiteral: 1},
+		{name: "insertion at the start", old: old, new: splice([]byte("inserted"), old), maxLiteral: 8 << 10},
+		{name: "bytes changed in the middle", old: old, new: splice(old[:500000], []byte("changed"), old[500007:]), maxLiteral: 8 << 10},
+		{name: "block removed", old: old, new: splice(old[:300000], old[310000:]), maxLiteral: 8 << 10},
+		{name: "appended", old: old, new: splice(old, []byte("appended")), maxLiteral: 8 << 10},
+		{name: "truncated", old: old, new: old[:700001], maxLiteral: 8 << 10},
+		{name: "emptied", old: old, new: []byte{}, maxLiteral: 1},
+		{name: "unrelated", old: old, new: testContent(5, 1<<20)},
+		{name: "old shorter than a block", old: []byte("short"), new: old},
+		{name: "from empty", old: []byte{}, new: []byte("new file")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := computeDelta(signFile(tt.old), tt.new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := applyDelta(tt.old, ops, hashBytes(tt.new))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.new) {
+				t.Errorf("applying the delta gave %d bytes, want the %d of the full copy", len(got), len(tt.new))
+			}
+			if n := deltaLiteralSize(ops); tt.maxLiteral > 0 && n >= tt.maxLiteral {
+				t.Errorf("delta carries %d bytes, want fewer than %d", n, tt.maxLiteral)
+			}
+		})
+	}
+}
+
+func TestApplyDeltaRejects(t *testing.T) {
+	old := []byte("old content of the file")
+	tests := []struct {
+		name string
+		ops  []deltaOp
+		hash string
+	}{
+		{name: "wrong result", ops: []deltaOp{{Offset: 0, Length: 3}}, hash: hashBytes([]byte("new"))},
+		{name: "copy beyond the end", ops: []deltaOp{{Offset: 10, Length: 100}}, hash: hashBytes(old)},
+		{name: "negative offset", ops: []deltaOp{{Offset: -1, Length: 2}}, hash: hashBytes(old)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := applyDelta(old, tt.ops, tt.hash); err == nil {
+				t.Error("applyDelta accepted the delta")
+			}
+		})
+	}
+
+	if _, err := computeDelta(&deltaSignature{BlockSize: 1024, Sums: make([]byte, 5)}, old); err == nil {
+		t.Error("computeDelta accepted a signature with a partial sum")
+	}
+}
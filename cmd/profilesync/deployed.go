@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// deployStateVersion is bumped whenever the deploy state format changes
+const deployStateVersion = 1
+
+// DeployedFile records one file a live run wrote
+type DeployedFile struct {
+	Mapping string `json:"mapping"`
+	Source  string `json:"source"`
+	// SourceHash is the source file when it was deployed; Hash is what was
+	// written, which differs for merged and translated configs
+	SourceHash string    `json:"source_hash"`
+	Hash       string    `json:"hash"`
+	DeployedAt time.Time `json:"deployed_at"`
+}
+
+// DeployState lists the files profilesync manages, by destination path
+type DeployState struct {
+	Version int                      `json:"version"`
+	Files   map[string]*DeployedFile `json:"files"`
+}
+
+// Drift of a deployed file, as shown by `profilesync status`
+const (
+	driftClean    = "clean"
+	driftModified = "modified"
+	driftDeleted  = "deleted"
+	driftUpdated  = "updated"
+	driftDiverged = "diverged"
+	driftOrphaned = "orphaned"
+)
+
+// deployStatePath is where the deploy state is kept
+func deployStatePath() string {
+	return filepath.Join(stateDir(), "deployed.json")
+}
+
+// loadDeployState reads the deploy state, or an empty one before the first
+// live run
+func loadDeployState() (*DeployState, error) {
+	s := &DeployState{Version: deployStateVersion, Files: map[string]*DeployedFile{}}
+	data, err := readStateFile(deployStatePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("corrupt deploy state %s: %w", deployStatePath(), err)
+	}
+	if s.Version != deployStateVersion {
+		return nil, fmt.Errorf("deploy state %s has unsupported version %d", deployStatePath(), s.Version)
+	}
+	if s.Files == nil {
+		s.Files = map[string]*DeployedFile{}
+	}
+	return s, nil
+}
+
+// save writes the deploy state
+func (s *DeployState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeStateFile(deployStatePath(), data, 0600); err != nil {
+		return err
+	}
+	pruneBases()
+	return nil
+}
+
+// hashFile returns the hex SHA-256 of a file
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// record notes the files a migrated item wrote. Directories are recorded
+// file by file, for the files that came from the source
+func (s *DeployState) record(item MigrationItem) error {
+	info, err := os.Stat(item.SourcePath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if err := s.recordFile(item.Mapping, item.SourcePath, item.DestinationPath); err != nil {
+			return err
+		}
+		// Single files can be merged later and need what was deployed
+		return saveBase(s.Files[item.DestinationPath].Hash, item.DestinationPath)
+	}
+	return filepath.WalkDir(item.SourcePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(item.SourcePath, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(item.DestinationPath, rel)
+		if _, err := os.Stat(dest); err != nil {
+			// Left out as machine-specific, or by the item's handler
+			return nil
+		}
+		return s.recordFile(item.Mapping, path, dest)
+	})
+}
+
+// recordFile notes one deployed file
+func (s *DeployState) recordFile(mapping, source, dest string) error {
+	sourceHash, err := hashFile(source)
+	if err != nil {
+		return err
+	}
+	hash, err := hashFile(dest)
+	if err != nil {
+		return err
+	}
+	s.Files[dest] = &DeployedFile{
+		Mapping:    mapping,
+		Source:     source,
+		SourceHash: sourceHash,
+		Hash:       hash,
+		DeployedAt: time.Now().UTC(),
+	}
+	return nil
+}
+
+// rehashDeployed records the current content of deployed files that
+// profilesync itself changed after deploying them, such as by machine
+// overrides, so status does not report them as modified locally
+func rehashDeployed(paths []string) {
+	state, err := loadDeployState()
+	if err != nil {
+		warnColor.Println("⚠️  Could not read deploy state:", err)
+		return
+	}
+	for _, path := range paths {
+		if f, ok := state.Files[path]; ok {
+			hash, err := hashFile(path)
+			if err != nil || hash == f.Hash {
+				continue
+			}
+			// The new content is the base of later merges, and saving the
+			// state prunes the base of the old one
+			if err := saveBase(hash, path); err != nil {
+				warnColor.Println("⚠️  Could not keep merge base of", displayPath(path)+":", err)
+			}
+			f.Hash = hash
+		}
+	}
+	if err := state.save(); err != nil {
+		warnColor.Println("⚠️  Could not save deploy state:", err)
+	}
+}
+
+// drift compares a deployed file with its destination and source now
+func (f *DeployedFile) drift(dest string) string {
+	local, err := hashFile(dest)
+	if errors.Is(err, os.ErrNotExist) {
+		return driftDeleted
+	}
+	source, serr := hashFile(f.Source)
+	localChanged := err != nil || local != f.Hash
+	switch {
+	case errors.Is(serr, os.ErrNotExist):
+		return driftOrphaned
+	case serr == nil && source != f.SourceHash && localChanged:
+		return driftDiverged
+	case serr == nil && source != f.SourceHash:
+		return driftUpdated
+	case localChanged:
+		return driftModified
+	}
+	return driftClean
+}
+
+// driftTitles head each group in `profilesync status`, in display order
+var driftTitles = []struct {
+	drift, title, code string
+}{
+	{driftModified, "Modified locally", "M"},
+	{driftDeleted, "Deleted locally", "D"},
+	{driftUpdated, "Changed in the profile", "U"},
+	{driftDiverged, "Diverged (changed on both sides)", "X"},
+	{driftOrphaned, "No longer in the profile", "O"},
+	{driftClean, "Unchanged", " "},
+}
+
+// displayPath shortens a path under the home directory to ~/...
+func displayPath(path string) string {
+	home := GetHomeDir(DetectPlatform())
+	if home != "" && withinRoot(home, path) && path != home {
+		rel, _ := filepath.Rel(home, path)
+		return "~" + string(filepath.Separator) + rel
+	}
+	return path
+}
+
+// runStatus handles `profilesync status [PATH...]`, showing how deployed
+// files have drifted since they were deployed
+func runStatus(args []string) {
+	flags := newFlagSet("status", "status [flags] [PATH...]")
+	short := flags.Bool("short", false, "One line per file with a status code (M, D, U, X, O)")
+	all := flags.Bool("all", false, "Also list files that are unchanged")
+	flags.Parse(args)
+
+	state, err := loadDeployState()
+	if err != nil {
+		errorColor.Println("❌ Error reading deploy state:", err)
+		os.Exit(1)
+	}
+	if len(state.Files) == 0 {
+		fmt.Println("No files deployed yet. Run `profilesync apply --dry-run=false` first.")
+		return
+	}
+
+	var filters []string
+	for _, arg := range flags.Args() {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			errorColor.Println("❌", err)
+			os.Exit(2)
+		}
+		filters = append(filters, abs)
+	}
+
+	groups := map[string][]string{}
+	total := 0
+	for dest, f := range state.Files {
+		matched := len(filters) == 0
+		for _, filter := range filters {
+			matched = matched || withinRoot(filter, dest)
+		}
+		if matched {
+			d := f.drift(dest)
+			groups[d] = append(groups[d], dest)
+			total++
+		}
+	}
+
+	changed := 0
+	for _, g := range driftTitles {
+		files := groups[g.drift]
+		if len(files) == 0 || (g.drift == driftClean && !*all) {
+			continue
+		}
+		if g.drift != driftClean {
+			changed += len(files)
+		}
+		sort.Strings(files)
+		if *short {
+			for _, dest := range files {
+				fmt.Printf("%s %s\n", g.code, displayPath(dest))
+			}
+			continue
+		}
+		c := warnColor
+		switch g.drift {
+		case driftDeleted, driftDiverged:
+			c = errorColor
+		case driftClean:
+			c = successColor
+		}
+		infoColor.Printf("%s:\n", g.title)
+		for _, dest := range files {
+			c.Printf("   %-44s", displayPath(dest))
+			fmt.Printf(" (%s)\n", state.Files[dest].Mapping)
+		}
+		fmt.Println()
+	}
+
+	if !*short {
+		if changed == 0 {
+			successColor.Printf("✅ All %d deployed files match what was deployed\n", total)
+		} else {
+			noticeColor.Printf("%d of %d deployed files changed. Re-apply with --force to overwrite local changes.\n", changed, total)
+		}
+	}
+}
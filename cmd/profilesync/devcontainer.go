@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// devcontainerImage is the base image devcontainers are built from unless
+// --image says otherwise
+const devcontainerImage = "mcr.microsoft.com/devcontainers/base:ubuntu"
+
+// devcontainerTypes are the catalog types a devcontainer gets by default:
+// the shell, git and editor setup rather than desktop apps and browsers
+var devcontainerTypes = []string{"Shell", "Version Control", "Editor", "IDE", "Terminal"}
+
+// devcontainerShells are shells the base image may lack, installed when
+// their config is exported, by mapping tool
+var devcontainerShells = map[string]string{"fish": "fish", "zsh": "zsh"}
+
+// devcontainerConfig is devcontainer.json
+type devcontainerConfig struct {
+	Name  string `json:"name"`
+	Build struct {
+		Dockerfile string `json:"dockerfile"`
+	} `json:"build"`
+	RemoteUser     string `json:"remoteUser"`
+	Customizations struct {
+		VSCode struct {
+			Settings   map[string]interface{} `json:"settings,omitempty"`
+			Extensions []string               `json:"extensions,omitempty"`
+		} `json:"vscode"`
+	} `json:"customizations"`
+}
+
+// exportDevcontainer writes devcontainer.json and a Dockerfile to dir that
+// build an image with the plan's items of the given types in the user's
+// home. VS Code settings and extensions go in devcontainer.json, where
+// VS Code applies them inside the container. Secrets are left out, since
+// anyone who can pull an image can read them
+func (ps *ProfileSync) exportDevcontainer(ctx context.Context, sourceHome, dir string, types []string, image, user string) error {
+	containerHome := "/home/" + user
+	if user == "root" {
+		containerHome = "/root"
+	}
+	all := len(types) == 1 && types[0] == "all"
+	wanted := map[string]bool{}
+	for _, t := range types {
+		wanted[strings.ToLower(t)] = true
+	}
+
+	config := devcontainerConfig{Name: "profilesync", RemoteUser: user}
+	config.Build.Dockerfile = "Dockerfile"
+	var secrets []string
+	keep := func(item MigrationItem) bool {
+		if !all && !wanted[strings.ToLower(item.Type)] {
+			return false
+		}
+		if item.Sensitivity == sensitivitySecret {
+			secrets = append(secrets, item.Description)
+			return false
+		}
+		// VS Code reads these on the client, not from the container's home
+		if mappingTool(item.Mapping) == "vscode" {
+			if item.Mapping == "vscode/settings.json" {
+				if err := readVSCodeSettings(item.SourcePath, &config); err != nil {
+					warnColor.Printf("⚠️  Could not read VS Code settings from %s: %v\n", item.SourcePath, err)
+				}
+			}
+			return false
+		}
+		return true
+	}
+
+	files := 0
+	shells := map[string]bool{}
+	out := exportTarget{dir: dir, home: containerHome, keepModes: true, redaction: newRedactionPolicy(destExport, ps.redaction)}
+	err := ps.exportedItems(ctx, sourceHome, out, keep, func(item MigrationItem, rel string, collected exportedItem) error {
+		files += len(collected.files) + len(collected.rewritten)
+		if pkg, ok := devcontainerShells[mappingTool(item.Mapping)]; ok {
+			shells[pkg] = true
+		}
+		// COPY keeps the modes directories have in the build context
+		return placeExported(dir, collected)
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0755); err != nil {
+		return err
+	}
+
+	if manifest, err := loadExtensionManifest(profileDir()); err == nil {
+		variants := sortedKeys(manifest.Variants)
+		if len(manifest.Variants["code"]) > 0 {
+			variants = []string{"code"}
+		}
+		seen := map[string]bool{}
+		for _, v := range variants {
+			for _, ext := range manifest.Variants[v] {
+				if !seen[ext] {
+					seen[ext] = true
+					config.Customizations.VSCode.Extensions = append(config.Customizations.VSCode.Extensions, ext)
+				}
+			}
+		}
+		sort.Strings(config.Customizations.VSCode.Extensions)
+	}
+
+	var dockerfile strings.Builder
+	dockerfile.WriteString("# Generated by `profilesync export --format devcontainer` from " + sourceHome + "\n")
+	dockerfile.WriteString("FROM " + image + "\n")
+	if len(shells) > 0 {
+		fmt.Fprintf(&dockerfile, "RUN apt-get update && apt-get install -y --no-install-recommends %s && rm -rf /var/lib/apt/lists/*\n", strings.Join(sortedKeys(shells), " "))
+	}
+	fmt.Fprintf(&dockerfile, "COPY --chown=%s:%s files/ %s/\n", user, user, containerHome)
+	if err := writeExported(filepath.Join(dir, "Dockerfile"), []byte(dockerfile.String()), 0644); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeExported(filepath.Join(dir, "devcontainer.json"), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	if len(secrets) > 0 {
+		warnColor.Printf("⚠️  Left out secrets anyone who can pull the image could read: %s\n", strings.Join(secrets, ", "))
+	}
+	out.redaction.summary()
+	successColor.Printf("✅ Wrote a devcontainer with %d files to %s\n", files, dir)
+	if n := len(config.Customizations.VSCode.Extensions); n > 0 {
+		noticeColor.Printf("🧩 VS Code in the container gets %d extensions\n", n)
+	}
+	return nil
+}
+
+// readVSCodeSettings puts the VS Code user settings at path into the
+// devcontainer's settings
+func readVSCodeSettings(path string, config *devcontainerConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	clean, _ := stripJSONC(data)
+	var settings map[string]interface{}
+	if err := json.Unmarshal(clean, &settings); err != nil {
+		return err
+	}
+	config.Customizations.VSCode.Settings = settings
+	return nil
+}
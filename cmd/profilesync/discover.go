@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// discoverMaxFiles bounds how many files of a candidate are looked at. A
+// tree larger than this holds data or caches rather than settings
+const discoverMaxFiles = 10000
+
+// configFileExtensions are the extensions and names ScanDirectory looks for
+// to tell how many settings files a discovered directory holds
+var configFileExtensions = []string{".conf", ".cfg", ".ini", ".toml", ".yaml", ".yml", ".json", ".lua", ".vim", ".el", ".plist", "config", "settings"}
+
+// discoverNoise are entries of the home directory that hold caches, history,
+// toolchains or desktop session state rather than settings
+var discoverNoise = map[string]bool{
+	".cache": true, ".local": true, ".config": true, ".Trash": true, ".DS_Store": true,
+	".CFUserTextEncoding": true, ".localized": true, ".npm": true, ".node-gyp": true,
+	".rustup": true, ".gradle": true, ".m2": true, ".dotnet": true, ".nuget": true,
+	".android": true, ".dbus": true, ".pki": true, ".ICEauthority": true, ".Xauthority": true,
+	".xsession-errors": true, ".sudo_as_admin_successful": true, ".lesshst": true,
+	".wget-hsts": true, ".viminfo": true, ".vscode-server": true, ".vscode-remote": true,
+	"Cache": true, "CachedData": true, "Crashpad": true, "pulse": true, "systemd": true,
+}
+
+// isDiscoverNoise reports whether a name is never worth suggesting
+func isDiscoverNoise(name string) bool {
+	lower := strings.ToLower(name)
+	switch {
+	case discoverNoise[name]:
+		return true
+	case strings.Contains(lower, "history"), strings.Contains(lower, "cache"):
+		return true
+	case strings.HasSuffix(lower, ".log"), strings.HasSuffix(lower, ".lock"), strings.HasSuffix(lower, ".pid"), strings.HasSuffix(lower, ".swp"):
+		return true
+	}
+	return false
+}
+
+// looksLikeConfig reports whether a file or directory name is typical of
+// settings, such as .foorc, .profile or tool.toml
+func looksLikeConfig(name string) bool {
+	lower := strings.ToLower(strings.TrimPrefix(name, "."))
+	for _, suffix := range []string{"rc", "profile", "config", "settings"} {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	ext := filepath.Ext(lower)
+	for _, e := range configFileExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// discoveredConfig is a file or directory in the home directory that looks
+// like settings but that no mapping covers
+type discoveredConfig struct {
+	Path     string
+	Mapping  string
+	Dir      bool
+	Size     int64
+	Files    int
+	Configs  int
+	Modified time.Time
+	Known    bool
+	Score    int
+	Reasons  []string
+}
+
+// score ranks a candidate from 0 to 100 by how likely it is to be settings
+// worth keeping: known or config-like names, recent changes and small size
+// count for it, age and bulk against it
+func (d *discoveredConfig) score(now time.Time) {
+	score := 20
+	add := func(points int, reason string) {
+		score += points
+		d.Reasons = append(d.Reasons, reason)
+	}
+	if d.Known {
+		add(40, "known config")
+	} else if looksLikeConfig(filepath.Base(d.Path)) {
+		add(20, "config-like name")
+	}
+	if d.Configs > 0 {
+		add(min(5*d.Configs, 20), fmt.Sprintf("%d settings files", d.Configs))
+	}
+	switch age := now.Sub(d.Modified); {
+	case age < 30*24*time.Hour:
+		add(20, "changed recently")
+	case age < 365*24*time.Hour:
+		add(10, "changed this year")
+	case age > 2*365*24*time.Hour:
+		add(-20, "untouched for years")
+	}
+	switch {
+	case d.Files >= discoverMaxFiles:
+		add(-50, "too many files")
+	case d.Size > 100<<20:
+		add(-40, "large")
+	case d.Size > 10<<20:
+		add(-20, "large")
+	case d.Size <= 1<<20:
+		add(10, "small")
+	}
+	d.Score = max(0, min(score, 100))
+}
+
+// measureTree adds up the size and files of a file or directory and finds
+// when anything in it last changed, giving up after discoverMaxFiles files
+func measureTree(path string) (size int64, files int, modified time.Time) {
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+		if d.Type().IsRegular() {
+			size += info.Size()
+			files++
+			if files >= discoverMaxFiles {
+				return filepath.SkipAll
+			}
+		}
+		return nil
+	})
+	return size, files, modified
+}
+
+// discoverConfigs lists the dotfiles of home and the entries of its config
+// directory that no mapping covers, best first. Paths within own, such as
+// the profile store and profilesync's own state, are left out
+func (ps *ProfileSync) discoverConfigs(ctx context.Context, home, platform string, mappings map[string]string, own []string) ([]discoveredConfig, error) {
+	known := map[string]bool{}
+	for _, e := range catalogEntries() {
+		if path, err := resolveMappingPath(home, catalogPath(e.Mapping, platform), platform); err == nil {
+			known[path] = true
+		}
+	}
+
+	var paths []string
+	entries, err := os.ReadDir(home)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			paths = append(paths, filepath.Join(home, entry.Name()))
+		}
+	}
+	configDir, err := resolveMappingPath(home, "${XDG_CONFIG_HOME}", platform)
+	if err != nil {
+		return nil, err
+	}
+	if configDir != home {
+		if entries, err := os.ReadDir(configDir); err == nil {
+			for _, entry := range entries {
+				paths = append(paths, filepath.Join(configDir, entry.Name()))
+			}
+		}
+	}
+
+	now := time.Now()
+	var found []discoveredConfig
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if isDiscoverNoise(filepath.Base(path)) || managedBy(mappings, path, home, platform) != "" {
+			continue
+		}
+		mine := false
+		for _, dir := range own {
+			mine = mine || withinRoot(dir, path) || withinRoot(path, dir)
+		}
+		info, err := os.Lstat(path)
+		if mine || err != nil || !(info.IsDir() || info.Mode().IsRegular()) {
+			continue
+		}
+		rel, err := filepath.Rel(home, path)
+		if err != nil || ps.ignore.ignored(filepath.ToSlash(rel), info.IsDir()) {
+			continue
+		}
+		mapping, err := inferMapping(path, home, platform)
+		if err != nil {
+			continue
+		}
+		d := discoveredConfig{Path: path, Mapping: mapping, Dir: info.IsDir(), Known: known[path]}
+		if d.Dir {
+			d.Mapping += "/"
+			d.Size, d.Files, d.Modified = measureTree(path)
+			if d.Files < discoverMaxFiles {
+				d.Configs = len(ps.ScanDirectory(ctx, path, configFileExtensions))
+			}
+		} else {
+			d.Size, d.Files, d.Modified = info.Size(), 1, info.ModTime()
+		}
+		d.score(now)
+		found = append(found, d)
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Score != found[j].Score {
+			return found[i].Score > found[j].Score
+		}
+		return found[i].Path < found[j].Path
+	})
+	return found, nil
+}
+
+// discoverDeclined lists the mappings the user asked discover not to
+// suggest again on this machine
+func discoverDeclined() map[string]bool {
+	decisionsMu.Lock()
+	defer decisionsMu.Unlock()
+
+	declined := map[string]bool{}
+	for _, d := range decisions().Machines[machineName()] {
+		if d.Kind == decisionDiscover && !d.Answer {
+			declined[d.Name] = true
+		}
+	}
+	return declined
+}
+
+// describeDiscovered is the one-line summary of a candidate in listings
+func describeDiscovered(d discoveredConfig) string {
+	what := formatSize(d.Size)
+	if d.Dir {
+		what = fmt.Sprintf("%d files, %s", d.Files, what)
+	}
+	return fmt.Sprintf("%3d  %s (%s, changed %s): %s", d.Score, displayPath(d.Path), what, formatAge(d.Modified), strings.Join(d.Reasons, ", "))
+}
+
+// runDiscover handles `profilesync discover`, finding dotfiles and config
+// directories no mapping covers and offering to adopt them
+func runDiscover(args []string) {
+	flags := newFlagSet("discover", "discover [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to copy adopted files into")
+	configFile := flags.String("config", configPath(), "Config file to read mappings from and record adopted ones in")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Record the mappings in this named profile instead of for every profile")
+	limit := flags.Int("limit", 20, "Suggest at most this many configs, 0 for all")
+	minScore := flags.Int("min-score", 30, "Leave out configs scoring lower than this (0 to 100)")
+	link := flags.Bool("link", false, "Replace each adopted original with a symlink to its copy in the profile store")
+	dryRun := flags.Bool("dry-run", false, "Only list what was found, without asking to adopt anything")
+	noInput := flags.Bool("no-input", false, "Never ask questions; only list what was found")
+	askAgain := flags.Bool("ask-again", false, "Also suggest configs you asked never to be suggested again")
+	jobs := flags.Int("jobs", 4, "Number of directories read at once when counting settings files")
+	flags.Parse(args)
+
+	platform := DetectPlatform()
+	home := GetHomeDir(platform)
+	mappings, err := configMappings(*configFile, *profile)
+	if err != nil {
+		errorColor.Println("❌ Error loading config:", err)
+		os.Exit(1)
+	}
+	ignore, err := loadIgnoreFile(globalIgnorePath(*configFile))
+	if err != nil {
+		errorColor.Println("❌ Error reading ignore file:", err)
+		os.Exit(1)
+	}
+	ps := NewProfileSync(platform, platform, *dryRun, false, false, false, false, *jobs)
+	ps.ignore, ps.noInput, ps.askAgain = ignore, *noInput, *askAgain
+	storeHome := filepath.Join(*dir, "home")
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	own := []string{*dir, stateDir(), filepath.Dir(*configFile)}
+	found, err := ps.discoverConfigs(ctx, home, platform, mappings, own)
+	if err != nil {
+		errorColor.Println("❌ Error scanning the home directory:", err)
+		os.Exit(1)
+	}
+
+	declined := map[string]bool{}
+	if !ps.askAgain {
+		declined = discoverDeclined()
+	}
+	var suggested []discoveredConfig
+	hidden := 0
+	for _, d := range found {
+		switch {
+		case d.Score < *minScore:
+		case declined[d.Mapping]:
+			hidden++
+		case *limit == 0 || len(suggested) < *limit:
+			suggested = append(suggested, d)
+		}
+	}
+	if len(suggested) == 0 {
+		successColor.Println("✅ No unmanaged configs found")
+		return
+	}
+
+	noticeColor.Printf("🔍 %d unmanaged configs found, best first:\n", len(suggested))
+	if !ps.canPrompt() {
+		for _, d := range suggested {
+			fmt.Println(describeDiscovered(d))
+		}
+		if hidden > 0 {
+			fmt.Printf("%d you asked never to see again are hidden (use --ask-again to show them)\n", hidden)
+		}
+		fmt.Println("Adopt one with `profilesync adopt PATH`, or run discover in a terminal to choose")
+		return
+	}
+
+	adopted, failed := 0, 0
+	options := "[a]dopt, [s]kip, [n]ever suggest again, or [q]uit:"
+	for _, d := range suggested {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Println(describeDiscovered(d))
+		answer := ""
+		for answer == "" {
+			switch strings.ToLower(ask(options, "s")) {
+			case "a", "adopt":
+				answer = "a"
+			case "s", "skip":
+				answer = "s"
+			case "n", "never":
+				answer = "n"
+			case "q", "quit":
+				answer = "q"
+			default:
+				fmt.Printf("Please answer one of: %s\n", options)
+			}
+		}
+		if answer == "q" {
+			break
+		}
+		switch answer {
+		case "a":
+			if err := adopt(d.Path, home, platform, storeHome, *configFile, *profile, mappings, *link, false, false); err != nil {
+				errorColor.Printf("❌ %s: %v\n", displayPath(d.Path), err)
+				failed++
+				continue
+			}
+			adopted++
+		case "n":
+			ps.remember(decisionDiscover, d.Mapping, "", false)
+		}
+	}
+
+	noticeColor.Printf("%d adopted, %d failed\n", adopted, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+	if adopted > 0 {
+		noticeColor.Printf("Run `profilesync apply --source-dir %s` on another machine to migrate adopted files\n", storeHome)
+	}
+}
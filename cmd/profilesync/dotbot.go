@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dotbotConfigs are the names dotbot's install script looks for
+var dotbotConfigs = []string{"install.conf.yaml", "install.conf.yml", "install.conf.json"}
+
+// dotbotDir is where dotbot repositories are usually cloned
+func dotbotDir(home string) string {
+	return filepath.Join(home, ".dotfiles")
+}
+
+// dotbotLink is one link directive's options, from its own settings or
+// the link defaults
+type dotbotLink struct {
+	Path    *string    `yaml:"path"`
+	Glob    bool       `yaml:"glob"`
+	If      string     `yaml:"if"`
+	Prefix  string     `yaml:"prefix"`
+	Exclude stringList `yaml:"exclude"`
+}
+
+// importDotbot imports what the link and create directives of a dotbot
+// config would put in the home directory. Other directives run commands
+// and are listed for the user to redo
+func importDotbot(dir, home string, put importPut) error {
+	var config string
+	for _, name := range dotbotConfigs {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			config = filepath.Join(dir, name)
+			break
+		}
+	}
+	if config == "" {
+		return fmt.Errorf("no %s in %s", strings.Join(dotbotConfigs, " or "), dir)
+	}
+	data, err := os.ReadFile(config)
+	if err != nil {
+		return err
+	}
+	// JSON is YAML too
+	var directives []map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &directives); err != nil {
+		return fmt.Errorf("%s: %w", config, err)
+	}
+
+	var defaults dotbotLink
+	var skipped []string
+	for _, directive := range directives {
+		for _, name := range sortedKeys(directive) {
+			node := directive[name]
+			var err error
+			switch name {
+			case "defaults":
+				var d struct {
+					Link dotbotLink `yaml:"link"`
+				}
+				err = node.Decode(&d)
+				defaults = d.Link
+			case "link":
+				var links map[string]yaml.Node
+				if err = node.Decode(&links); err == nil {
+					skipped, err = dotbotLinks(dir, home, links, defaults, skipped, put)
+				}
+			case "create":
+				err = dotbotCreate(home, &node, put)
+			case "clean":
+				// Removing dead links has nothing to import
+			default:
+				skipped = append(skipped, fmt.Sprintf("%s directive", name))
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %s: %w", filepath.Base(config), name, err)
+			}
+		}
+	}
+
+	if len(skipped) > 0 {
+		warnColor.Printf("⚠️  Left out %d entries profilesync cannot import:\n", len(skipped))
+		for _, s := range skipped {
+			warnColor.Printf("   %s\n", s)
+		}
+	}
+	return nil
+}
+
+// dotbotTarget turns a link or create target, such as ~/.vimrc or
+// $XDG_CONFIG_HOME/nvim, into a path relative to home
+func dotbotTarget(home, target string) (string, error) {
+	expanded := os.Expand(target, func(name string) string {
+		if name == "HOME" {
+			return home
+		}
+		return os.Getenv(name)
+	})
+	if expanded == "~" || strings.HasPrefix(expanded, "~/") {
+		expanded = filepath.Join(home, expanded[1:])
+	}
+	if !filepath.IsAbs(expanded) || !withinRoot(home, expanded) || filepath.Clean(expanded) == filepath.Clean(home) {
+		return "", fmt.Errorf("%s is not in the home directory", target)
+	}
+	rel, err := filepath.Rel(home, expanded)
+	return filepath.ToSlash(rel), err
+}
+
+// dotbotLinks imports the sources of one link directive. A link's value is
+// its source, nothing for the target's name without its leading dot, or
+// its options
+func dotbotLinks(dir, home string, links map[string]yaml.Node, defaults dotbotLink, skipped []string, put importPut) ([]string, error) {
+	for _, target := range sortedKeys(links) {
+		node := links[target]
+		opts := defaults
+		opts.Path = nil
+		switch node.Kind {
+		case yaml.MappingNode:
+			if err := node.Decode(&opts); err != nil {
+				return skipped, fmt.Errorf("%s: %w", target, err)
+			}
+		case yaml.ScalarNode:
+			if node.Tag != "!!null" {
+				opts.Path = &node.Value
+			}
+		}
+		source := strings.TrimPrefix(filepath.Base(strings.TrimRight(target, "/")), ".")
+		if opts.Path != nil {
+			source = *opts.Path
+		}
+
+		if opts.If != "" {
+			ok, err := dotbotCondition(dir, opts.If)
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("link %s: cannot check `%s`: %v", target, opts.If, err))
+				continue
+			}
+			if !ok {
+				continue
+			}
+		}
+		rel, err := dotbotTarget(home, target)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("link %s: %v", target, err))
+			continue
+		}
+
+		if !opts.Glob {
+			if err := importDotbotSource(filepath.Join(dir, source), rel, put); err != nil {
+				return skipped, fmt.Errorf("%s: %w", target, err)
+			}
+			continue
+		}
+		// A glob links each match into the target directory
+		matches, err := filepath.Glob(filepath.Join(dir, source))
+		if err != nil {
+			return skipped, fmt.Errorf("%s: %w", target, err)
+		}
+		sort.Strings(matches)
+		// Like a shell, * only matches dotfiles when asked to
+		hidden := strings.HasPrefix(filepath.Base(source), ".")
+	match:
+		for _, m := range matches {
+			if strings.HasPrefix(filepath.Base(m), ".") && !hidden {
+				continue
+			}
+			for _, pattern := range opts.Exclude {
+				if ok, _ := filepath.Match(filepath.Join(dir, pattern), m); ok {
+					continue match
+				}
+			}
+			dst := rel + "/" + opts.Prefix + filepath.Base(m)
+			if len(matches) == 1 && !strings.HasSuffix(target, "/") {
+				dst = rel
+			}
+			if err := importDotbotSource(m, dst, put); err != nil {
+				return skipped, fmt.Errorf("%s: %w", target, err)
+			}
+		}
+	}
+	return skipped, nil
+}
+
+// dotbotCondition runs a link's if command as dotbot does, from the
+// repository, reporting whether it succeeded
+func dotbotCondition(dir, command string) (bool, error) {
+	cmd := exec.Command("sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", command)
+	}
+	cmd.Dir = dir
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); ok {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// importDotbotSource hands a linked file, or everything in a linked
+// directory, to put at rel
+func importDotbotSource(source, rel string, put importPut) error {
+	return filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		sub, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := rel
+		if sub != "." {
+			target += "/" + filepath.ToSlash(sub)
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		// A linked source is read through its own links, as the link
+		// dotbot made would be
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return put(target, nil, info.Mode(), "")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return put(target, data, info.Mode(), "")
+	})
+}
+
+// dotbotCreate imports the directories a create directive makes, given as
+// a list or as a map to their options
+func dotbotCreate(home string, node *yaml.Node, put importPut) error {
+	var dirs []string
+	if node.Kind == yaml.MappingNode {
+		var m map[string]interface{}
+		if err := node.Decode(&m); err != nil {
+			return err
+		}
+		dirs = sortedKeys(m)
+	} else if err := node.Decode(&dirs); err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		rel, err := dotbotTarget(home, dir)
+		if err != nil {
+			return err
+		}
+		if err := put(rel, nil, fs.ModeDir|0755, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
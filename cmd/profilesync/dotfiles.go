@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dotfileTool converts between the profile store and the files of another
+// dotfile manager, so users can switch tools without redoing their setup
+type dotfileTool struct {
+	// what the tool's directory holds, for help and messages
+	what string
+
+	// dir is where the tool keeps its files by default
+	dir func(home string) string
+
+	// importFrom reads the tool's files in dir and hands each file,
+	// directory and symlink it would put in home to put; nil when there is
+	// no import
+	importFrom func(dir, home string, put importPut) error
+
+	// exportTo writes the profile store's home directory into dir in the
+	// tool's layout, passing each file through filter, and returns how many
+	// files it wrote; nil when there is no export
+	exportTo func(storeHome, dir string, filter storeFilter) (int, error)
+}
+
+// importPut stores one entry at rel, a slash-separated path relative to the
+// home directory: a directory when mode says so, a symlink to link when
+// link is set, and otherwise a file holding data
+type importPut func(rel string, data []byte, mode fs.FileMode, link string) error
+
+// dotfileTools are the dotfile managers `import` and `export` convert, by name
+var dotfileTools = map[string]dotfileTool{
+	"chezmoi": {
+		what:       "chezmoi source directory",
+		dir:        chezmoiSourceDir,
+		importFrom: importChezmoi,
+		exportTo:   exportChezmoi,
+	},
+	"dotbot": {
+		what:       "dotbot repository",
+		dir:        dotbotDir,
+		importFrom: importDotbot,
+	},
+	"home-manager": {
+		what:     "home-manager module",
+		dir:      homeManagerDir,
+		exportTo: exportHomeManager,
+	},
+	"stow": {
+		what:       "GNU Stow directory",
+		dir:        stowDir,
+		importFrom: importStow,
+	},
+}
+
+// exportableTools are the names of the tools `export` can write
+func exportableTools() []string {
+	var names []string
+	for _, name := range sortedKeys(dotfileTools) {
+		if dotfileTools[name].exportTo != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// coveredBy returns the mapping whose path is path or a directory holding
+// it, if any
+func coveredBy(mappings map[string]string, path, home, platform string) string {
+	for _, mapping := range sortedKeys(mappings) {
+		resolved, err := resolveMappingPath(home, catalogPath(mapping, platform), platform)
+		if err == nil && withinRoot(resolved, path) {
+			return mapping
+		}
+	}
+	return ""
+}
+
+// runImportTool handles `profilesync import TOOL [DIR]`, copying another
+// dotfile manager's files into the profile store and adding mappings for
+// those no mapping covers yet
+func runImportTool(name string, tool dotfileTool, args []string) {
+	flags := newFlagSet("import "+name, "import "+name+" [flags] [DIR]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to import into")
+	configFile := flags.String("config", configPath(), "Config file to record the mappings in")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Record the mappings in this named profile instead of for every profile")
+	force := flags.Bool("force", false, "Replace files already in the profile store that differ")
+	linkHome := flags.Bool("link", false, "Replace the tool's links in the home directory with links to the profile store")
+	dryRun := flags.Bool("dry-run", false, "Show what would be imported without changing anything")
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	platform := DetectPlatform()
+	home := GetHomeDir(platform)
+	src := tool.dir(home)
+	if flags.NArg() == 1 {
+		src = flags.Arg(0)
+	}
+	if !isDir(src) {
+		errorColor.Printf("❌ No %s at %s\n", tool.what, src)
+		os.Exit(1)
+	}
+	if importDotfiles(name, tool.importFrom, src, *dir, *configFile, *profile, *force, *linkHome, *dryRun) > 0 {
+		os.Exit(1)
+	}
+	if !*dryRun {
+		noticeColor.Printf("Run `profilesync apply --source-dir %s` to deploy them\n", filepath.Join(*dir, "home"))
+	}
+}
+
+// importDotfiles copies what importFrom reads in src into the profile store
+// in dir, recording mappings for files none covers yet, and returns how
+// many files differed from the store and were left alone. It exits on
+// errors
+func importDotfiles(name string, importFrom func(dir, home string, put importPut) error, src, dir, configFile, profile string, force, linkHome, dryRun bool) int {
+	platform := DetectPlatform()
+	home := GetHomeDir(platform)
+	mappings, err := configMappings(configFile, profile)
+	if err != nil {
+		errorColor.Println("❌ Error loading config:", err)
+		os.Exit(1)
+	}
+	storeHome := filepath.Join(dir, "home")
+
+	var added, linked []string
+	imported, unchanged, conflicts := 0, 0, 0
+	put := func(rel string, data []byte, mode fs.FileMode, link string) error {
+		path := filepath.Join(home, filepath.FromSlash(rel))
+		mapping, err := inferMapping(path, home, platform)
+		if err != nil {
+			return err
+		}
+		stored, err := resolveMappingPath(storeHome, mapping, platform)
+		if err != nil {
+			return err
+		}
+		if mode.IsDir() {
+			if dryRun {
+				return nil
+			}
+			if err := os.MkdirAll(stored, 0700); err != nil {
+				return err
+			}
+			// Keep it writable so its contents can follow
+			return os.Chmod(stored, mode.Perm()|0700)
+		}
+
+		same := false
+		if existing, err := os.Lstat(stored); err == nil {
+			if link != "" {
+				target, err := os.Readlink(stored)
+				same = err == nil && target == link
+			} else if existing.Mode().IsRegular() {
+				current, err := os.ReadFile(stored)
+				same = err == nil && bytes.Equal(current, data)
+			}
+			if !same && !force {
+				warnColor.Printf("⚠️  %s is already in the profile store and differs (use --force to replace it)\n", stored)
+				conflicts++
+				return nil
+			}
+		}
+		switch {
+		case same:
+			unchanged++
+		case dryRun:
+			fmt.Printf("Would import %s to %s\n", rel, stored)
+			imported++
+		default:
+			if err := writeImported(stored, data, mode, link); err != nil {
+				return err
+			}
+			imported++
+		}
+
+		if coveredBy(mappings, path, home, platform) == "" {
+			mappings[mapping] = mapping
+			added = append(added, mapping)
+		}
+		if linkHome && link == "" {
+			return linkImported(path, home, src, storeHome, platform, &linked, dryRun)
+		}
+		return nil
+	}
+	if err := importFrom(src, home, put); err != nil {
+		errorColor.Printf("❌ Error importing from %s: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	if dryRun {
+		for _, path := range linked {
+			fmt.Printf("Would link %s to the profile store\n", path)
+		}
+		for _, mapping := range added {
+			fmt.Printf("Would add mapping %s to %s\n", mapping, configFile)
+		}
+		return conflicts
+	}
+	for _, mapping := range added {
+		if err := addConfigMapping(configFile, profile, mapping); err != nil {
+			errorColor.Println("❌ Error recording mappings:", err)
+			os.Exit(1)
+		}
+	}
+	successColor.Printf("✅ Imported %d files from %s into %s (%d already there)\n", imported, src, storeHome, unchanged)
+	if len(added) > 0 {
+		successColor.Printf("✅ Added %d mappings to %s\n", len(added), configFile)
+	}
+	if len(linked) > 0 {
+		successColor.Printf("🔗 %d links in %s now point into the profile store\n", len(linked), home)
+	}
+	if conflicts > 0 {
+		warnColor.Printf("⚠️  %d files differ from the profile store and were left alone\n", conflicts)
+	}
+	return conflicts
+}
+
+// linkImported points path, an imported file, at its copy in the profile
+// store. Where the tool linked a directory holding path instead, as Stow
+// does when it folds a tree, that directory link is replaced. Files the
+// tool did not link are only replaced when they match the imported copy;
+// linked collects every link made
+func linkImported(path, home, src, storeHome, platform string, linked *[]string, dryRun bool) error {
+	for _, done := range *linked {
+		if withinRoot(done, path) {
+			return nil
+		}
+	}
+	rel, err := filepath.Rel(home, path)
+	if err != nil {
+		return err
+	}
+	// The outermost link into the tool's directory is the one it made
+	target := home
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		target = filepath.Join(target, part)
+		info, err := os.Lstat(target)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(target)
+		if err == nil && withinRoot(storeHome, resolved) {
+			return nil
+		}
+		// A dangling link is taken to be the tool's
+		if err != nil || withinRoot(src, resolved) {
+			break
+		}
+		if target == path {
+			warnColor.Printf("⚠️  %s links outside %s; left as it is\n", path, src)
+			return nil
+		}
+	}
+
+	mapping, err := inferMapping(target, home, platform)
+	if err != nil {
+		return err
+	}
+	stored, err := resolveMappingPath(storeHome, mapping, platform)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(target)
+	if err == nil && info.Mode()&fs.ModeSymlink == 0 && !dryRun && !sameContent(target, stored) {
+		warnColor.Printf("⚠️  %s differs from what was imported; left as it is\n", target)
+		return nil
+	}
+	*linked = append(*linked, target)
+	if dryRun {
+		return nil
+	}
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		return os.Symlink(stored, target)
+	}
+	return linkToStore(target, stored)
+}
+
+// writeImported writes one imported file or symlink into the profile store
+func writeImported(stored string, data []byte, mode fs.FileMode, link string) error {
+	if err := os.MkdirAll(filepath.Dir(stored), 0700); err != nil {
+		return err
+	}
+	if link != "" {
+		if err := os.RemoveAll(stored); err != nil {
+			return err
+		}
+		return os.Symlink(link, stored)
+	}
+	if err := writeFileAtomic(stored, data, mode.Perm()); err != nil {
+		return err
+	}
+	// A replaced file would keep its old mode
+	return os.Chmod(stored, mode.Perm())
+}
+
+// runExport handles `profilesync export TOOL [DIR]`, writing the profile
+// store in another dotfile manager's layout, and `export --format`
+func runExport(args []string) {
+	names := exportableTools()
+	if len(args) == 0 {
+		exportUsage()
+		os.Exit(2)
+	}
+	if strings.HasPrefix(args[0], "-") {
+		runExportPlan(args)
+		return
+	}
+	name := args[0]
+	if name == "archive" {
+		runExportArchive(args[1:])
+		return
+	}
+	tool, ok := dotfileTools[name]
+	if !ok || tool.exportTo == nil {
+		errorColor.Println("❌ Cannot export to:", name)
+		errorColor.Println("Must be one of:", strings.Join(names, ", "))
+		os.Exit(2)
+	}
+
+	flags := newFlagSet("export "+name, "export "+name+" [flags] [DIR]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to export")
+	configFile := flags.String("config", configPath(), "Config file with the mappings and redaction rules")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config")
+	force := flags.Bool("force", false, "Write into a directory that is not empty, replacing files there")
+	flags.Parse(args[1:])
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	dst := tool.dir(GetHomeDir(DetectPlatform()))
+	if flags.NArg() == 1 {
+		dst = flags.Arg(0)
+	}
+	storeHome := filepath.Join(*dir, "home")
+	if !isDir(storeHome) {
+		errorColor.Printf("❌ The profile store has nothing to export (%s is missing)\n", storeHome)
+		os.Exit(1)
+	}
+	if entries, _ := os.ReadDir(dst); len(entries) > 0 && !*force {
+		errorColor.Printf("❌ %s is not empty (use --force to write into it)\n", dst)
+		os.Exit(1)
+	}
+
+	mappings, policy, err := exportPolicy(*configFile, *profile)
+	if err != nil {
+		errorColor.Println("❌ Error loading config:", err)
+		os.Exit(1)
+	}
+
+	n, err := tool.exportTo(storeHome, dst, policy.forStore(mappings, storeHome))
+	if err != nil {
+		errorColor.Printf("❌ Error exporting to %s: %v\n", name, err)
+		os.Exit(1)
+	}
+	policy.summary()
+	successColor.Printf("✅ Exported %d files from %s to %s\n", n, storeHome, dst)
+}
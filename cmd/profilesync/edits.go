@@ -0,0 +1,688 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Edit is a structured change made to a migrated JSON or YAML config,
+// setting or deleting what a path selects
+type Edit struct {
+	Set    string    `yaml:"set"`
+	Delete string    `yaml:"delete"`
+	Value  yaml.Node `yaml:"value"`
+	When   EditWhen  `yaml:"when"`
+}
+
+// EditWhen limits an edit to some profiles, machines or destination
+// platforms; an empty field matches all
+type EditWhen struct {
+	Profile  stringList `yaml:"profile"`
+	Machine  stringList `yaml:"machine"`
+	Platform stringList `yaml:"platform"`
+}
+
+// validate checks that the edit has one operation on a path that parses
+func (e *Edit) validate() error {
+	switch {
+	case e.Set != "" && e.Delete != "":
+		return errors.New("an edit either sets or deletes, not both")
+	case e.Set != "":
+		if e.Value.Kind == 0 {
+			return fmt.Errorf("set %s has no value", e.Set)
+		}
+	case e.Delete != "":
+		if e.Value.Kind != 0 {
+			return fmt.Errorf("delete %s cannot have a value", e.Delete)
+		}
+	default:
+		return errors.New("an edit needs set or delete")
+	}
+	_, err := parseEditPath(e.path())
+	return err
+}
+
+// path is the path the edit sets or deletes
+func (e *Edit) path() string {
+	if e.Set != "" {
+		return e.Set
+	}
+	return e.Delete
+}
+
+// applies reports whether the edit is made for the applied profiles, on
+// machine, to a destination on platform
+func (e *Edit) applies(profiles []string, machine, platform string) bool {
+	if len(e.When.Profile) > 0 && !anyIn(e.When.Profile, profiles) {
+		return false
+	}
+	if len(e.When.Machine) > 0 && !anyIn(e.When.Machine, []string{machine}) {
+		return false
+	}
+	if len(e.When.Platform) > 0 && !anyIn(e.When.Platform, []string{platform}) {
+		return false
+	}
+	return true
+}
+
+// anyIn reports whether any of wanted is in have, ignoring case
+func anyIn(wanted, have []string) bool {
+	for _, w := range wanted {
+		for _, h := range have {
+			if strings.EqualFold(w, h) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// editsFor are the config's edits made for the applied profiles on this
+// machine, to a destination on platform, by mapping
+func (c *resolvedConfig) editsFor(platform string) map[string][]Edit {
+	edits := map[string][]Edit{}
+	for mapping, list := range c.Edits {
+		for _, e := range list {
+			if e.applies(c.Profiles, machineName(), platform) {
+				edits[mapping] = append(edits[mapping], e)
+			}
+		}
+	}
+	return edits
+}
+
+// editStep is one step of an edit path: a key, an index, or every member
+type editStep struct {
+	key   string
+	index int
+	isKey bool
+	all   bool
+}
+
+// String implements fmt.Stringer
+func (s editStep) String() string {
+	switch {
+	case s.all:
+		return "[*]"
+	case s.isKey:
+		return strconv.Quote(s.key)
+	}
+	return "[" + strconv.Itoa(s.index) + "]"
+}
+
+// parseEditPath parses a JSONPath such as $.editor["window.zoomLevel"] or
+// $.servers[0].name, or the same as a yq-style path starting with a dot
+func parseEditPath(p string) ([]editStep, error) {
+	rest := p
+	switch {
+	case strings.HasPrefix(rest, "$"):
+		rest = rest[1:]
+	case strings.HasPrefix(rest, "."):
+	default:
+		return nil, fmt.Errorf("path %q must start with $ or .", p)
+	}
+	var steps []editStep
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, "*") {
+				steps = append(steps, editStep{all: true})
+				rest = rest[1:]
+				continue
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				if rest == "" && len(steps) == 0 {
+					// A lone . is the whole file
+					break
+				}
+				return nil, fmt.Errorf("path %q has an empty key", p)
+			}
+			steps = append(steps, editStep{key: rest[:end], isKey: true})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed [", p)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			switch {
+			case inner == "*":
+				steps = append(steps, editStep{all: true})
+			case strings.HasPrefix(inner, `"`) || strings.HasPrefix(inner, "'"):
+				key, n, err := editPathKey(rest[1:])
+				if err != nil {
+					return nil, fmt.Errorf("path %q: %w", p, err)
+				}
+				closing := strings.IndexByte(rest[1+n:], ']')
+				if closing < 0 {
+					return nil, fmt.Errorf("path %q has an unclosed [", p)
+				}
+				end = 1 + n + closing
+				if strings.TrimSpace(rest[1+n:end]) != "" {
+					return nil, fmt.Errorf("path %q has text after a quoted key", p)
+				}
+				steps = append(steps, editStep{key: key, isKey: true})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("path %q: %q is not an index, a quoted key or *", p, inner)
+				}
+				steps = append(steps, editStep{index: index})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("path %q: expected . or [ before %q", p, rest)
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("path %q selects the whole file", p)
+	}
+	return steps, nil
+}
+
+// editPathKey reads the quoted key s starts with, after any spaces,
+// returning it and how much of s it took
+func editPathKey(s string) (string, int, error) {
+	start := len(s) - len(strings.TrimLeft(s, " "))
+	quote := s[start]
+	var key strings.Builder
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				key.WriteByte(s[i])
+			}
+		case quote:
+			return key.String(), i + 1, nil
+		default:
+			key.WriteByte(s[i])
+		}
+	}
+	return "", 0, errors.New("unterminated quoted key")
+}
+
+// formatEditPath writes steps back as a JSONPath, for messages
+func formatEditPath(steps []editStep) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, s := range steps {
+		if s.isKey && isPlainKey(s.key) {
+			b.WriteString("." + s.key)
+			continue
+		}
+		if s.isKey {
+			b.WriteString("[" + s.String() + "]")
+			continue
+		}
+		b.WriteString(s.String())
+	}
+	return b.String()
+}
+
+// isPlainKey reports whether key can be written after a dot in a path
+func isPlainKey(key string) bool {
+	return key != "" && key != "*" && !strings.ContainsAny(key, ".[]'\" ")
+}
+
+// setNode sets what steps select below node to value, creating missing
+// objects on the way
+func setNode(node *yaml.Node, steps []editStep, value *yaml.Node, at []editStep) error {
+	step, last := steps[0], len(steps) == 1
+	at = append(at, step)
+	next := func(child *yaml.Node) error {
+		if last {
+			*child = *copyNode(value)
+			return nil
+		}
+		if isNullNode(child) && steps[1].isKey {
+			*child = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		return setNode(child, steps[1:], value, at)
+	}
+
+	switch {
+	case step.all && node.Kind == yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := next(node.Content[i]); err != nil {
+				return err
+			}
+		}
+	case step.all && node.Kind == yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := next(child); err != nil {
+				return err
+			}
+		}
+	case step.isKey && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == step.key {
+				return next(node.Content[i+1])
+			}
+		}
+		if !last && !steps[1].isKey {
+			return fmt.Errorf("%s does not exist", formatEditPath(at))
+		}
+		child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: step.key}, child)
+		return next(child)
+	case !step.isKey && !step.all && node.Kind == yaml.SequenceNode:
+		index := step.index
+		if index < 0 {
+			index += len(node.Content)
+		}
+		if last && index == len(node.Content) {
+			// One past the end appends
+			node.Content = append(node.Content, copyNode(value))
+			return nil
+		}
+		if index < 0 || index >= len(node.Content) {
+			return fmt.Errorf("%s does not exist", formatEditPath(at))
+		}
+		return next(node.Content[index])
+	default:
+		return fmt.Errorf("%s is %s", formatEditPath(at[:len(at)-1]), describeNode(node))
+	}
+	return nil
+}
+
+// deleteNode deletes what steps select below node; paths that do not
+// exist are already deleted
+func deleteNode(node *yaml.Node, steps []editStep) {
+	step, last := steps[0], len(steps) == 1
+	switch {
+	case step.all && last && (node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode):
+		node.Content = nil
+	case step.all && node.Kind == yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			deleteNode(node.Content[i], steps[1:])
+		}
+	case step.all && node.Kind == yaml.SequenceNode:
+		for _, child := range node.Content {
+			deleteNode(child, steps[1:])
+		}
+	case step.isKey && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value != step.key {
+				continue
+			}
+			if last {
+				node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			} else {
+				deleteNode(node.Content[i+1], steps[1:])
+			}
+			return
+		}
+	case !step.isKey && !step.all && node.Kind == yaml.SequenceNode:
+		index := step.index
+		if index < 0 {
+			index += len(node.Content)
+		}
+		if index < 0 || index >= len(node.Content) {
+			return
+		}
+		if last {
+			node.Content = append(node.Content[:index], node.Content[index+1:]...)
+		} else {
+			deleteNode(node.Content[index], steps[1:])
+		}
+	}
+}
+
+// copyNode is a deep copy of n, so one value can be set in several places
+func copyNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = copyNode(child)
+	}
+	return &c
+}
+
+// isNullNode reports whether n is a YAML or JSON null
+func isNullNode(n *yaml.Node) bool {
+	return n.Kind == 0 || (n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null")
+}
+
+// describeNode names what kind of value n is, for messages
+func describeNode(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "an object"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	switch n.ShortTag() {
+	case "!!str":
+		return "a string"
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "a boolean"
+	}
+	return "a number"
+}
+
+// editConfig makes edits to the JSON or YAML config data, returning the
+// result. JSON keeps its key order and indentation; comments are dropped,
+// and hadComments reports whether there were any
+func editConfig(format string, data []byte, edits []Edit) (out []byte, hadComments bool, err error) {
+	var root *yaml.Node
+	switch format {
+	case configJSON:
+		var clean []byte
+		clean, hadComments = stripJSONC(data)
+		if root, err = decodeJSONNode(clean); err != nil {
+			return nil, false, err
+		}
+	case configYAML:
+		var doc yaml.Node
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		if err := decoder.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+			return nil, false, err
+		}
+		var extra yaml.Node
+		if err := decoder.Decode(&extra); !errors.Is(err, io.EOF) {
+			return nil, false, errors.New("edits only work on files with one YAML document")
+		}
+		if doc.Kind == 0 {
+			doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+		}
+		root = &doc
+	default:
+		return nil, false, errors.New("edits only work on JSON and YAML files")
+	}
+
+	top := root
+	if top.Kind == yaml.DocumentNode {
+		top = top.Content[0]
+	}
+	for _, e := range edits {
+		steps, err := parseEditPath(e.path())
+		if err != nil {
+			return nil, false, err
+		}
+		if e.Delete != "" {
+			deleteNode(top, steps)
+			continue
+		}
+		value := e.Value
+		if value.Kind == yaml.DocumentNode {
+			value = *value.Content[0]
+		}
+		if err := setNode(top, steps, &value, nil); err != nil {
+			return nil, false, fmt.Errorf("set %s: %w", e.Set, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if format == configJSON {
+		if err := writeJSONNode(&buf, top, jsonIndent(data), ""); err != nil {
+			return nil, false, err
+		}
+		if bytes.HasSuffix(bytes.TrimRight(data, " \t\r"), []byte("\n")) || len(bytes.TrimSpace(data)) == 0 {
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), hadComments, nil
+	}
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(yamlIndent(data))
+	if err := encoder.Encode(root); err != nil {
+		return nil, false, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), false, nil
+}
+
+// decodeJSONNode reads JSON into a node tree, which keeps the order of
+// object keys. An empty file is an empty object
+func decodeJSONNode(data []byte) (*yaml.Node, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	node, err := readJSONNode(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("unexpected data after the top-level value")
+	}
+	return node, nil
+}
+
+// readJSONNode reads the next JSON value from decoder
+func readJSONNode(decoder *json.Decoder) (*yaml.Node, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if t == '[' {
+			node = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		}
+		for decoder.More() {
+			if node.Kind == yaml.MappingNode {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.(string)})
+			}
+			child, err := readJSONNode(decoder)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		// The closing delimiter
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: t, Style: yaml.DoubleQuotedStyle}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(t.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: t.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(t)}, nil
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+}
+
+// writeJSONNode writes n as JSON indented by indent, at the depth prefix
+func writeJSONNode(buf *bytes.Buffer, n *yaml.Node, indent, prefix string) error {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	switch n.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		open, end := "{", "}"
+		step := 2
+		if n.Kind == yaml.SequenceNode {
+			open, end, step = "[", "]", 1
+		}
+		if len(n.Content) == 0 {
+			buf.WriteString(open + end)
+			return nil
+		}
+		buf.WriteString(open)
+		for i := 0; i < len(n.Content); i += step {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString("\n" + prefix + indent)
+			if step == 2 {
+				writeJSONString(buf, n.Content[i].Value)
+				buf.WriteString(": ")
+			}
+			if err := writeJSONNode(buf, n.Content[i+step-1], indent, prefix+indent); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("\n" + prefix + end)
+		return nil
+	}
+
+	switch n.ShortTag() {
+	case "!!str":
+		writeJSONString(buf, n.Value)
+		return nil
+	case "!!null":
+		buf.WriteString("null")
+		return nil
+	case "!!int", "!!float", "!!bool":
+		if json.Valid([]byte(n.Value)) {
+			buf.WriteString(n.Value)
+			return nil
+		}
+	}
+	// YAML spellings JSON does not have, such as 0x1F or 1_000
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return err
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("%s cannot be written as JSON", n.Value)
+	}
+	buf.Write(out)
+	return nil
+}
+
+// writeJSONString writes s as a JSON string, leaving <, > and & as they are
+func writeJSONString(buf *bytes.Buffer, s string) {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	buf.Write(bytes.TrimRight(out.Bytes(), "\n"))
+}
+
+// jsonIndent is the indentation of the first indented line of data, or
+// four spaces like VS Code
+func jsonIndent(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "    "
+}
+
+// yamlIndent is the number of spaces data indents nested mappings by, or 2
+func yamlIndent(data []byte) int {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed != "" && len(trimmed) < len(line) && !strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, "- ") {
+			return len(line) - len(trimmed)
+		}
+	}
+	return 2
+}
+
+// applyEdits makes the config's edits to the configs the migration wrote
+func (ps *ProfileSync) applyEdits() {
+	if len(ps.edits) == 0 {
+		return
+	}
+	var changedPaths []string
+	for _, item := range ps.migrationPlan.Items {
+		edits := ps.edits[item.Mapping]
+		if len(edits) == 0 {
+			continue
+		}
+		path := item.DestinationPath
+		if ps.dryRun {
+			// The destination may only exist after the migration
+			if _, err := os.Stat(path); err != nil {
+				path = item.SourcePath
+			}
+		}
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil && info.IsDir() {
+			err = errors.New("edits only work on single-file mappings")
+		}
+		var data, out []byte
+		var hadComments bool
+		if err == nil {
+			data, err = os.ReadFile(path)
+		}
+		if err == nil {
+			out, hadComments, err = editConfig(configFormat(item.DestinationPath), data, edits)
+		}
+		if err != nil {
+			warnColor.Printf("⚠️  Edits to %s: %v\n", item.Mapping, err)
+			continue
+		}
+		if bytes.Equal(out, data) {
+			continue
+		}
+
+		if !ps.dryRun {
+			if hadComments {
+				warnColor.Printf("⚠️  %s had comments; they are not kept when editing it\n", item.DestinationPath)
+			}
+			if err := writeFileAtomic(path, out, info.Mode().Perm()); err != nil {
+				warnColor.Printf("⚠️  Edits to %s: %v\n", item.Mapping, err)
+				continue
+			}
+			changedPaths = append(changedPaths, path)
+		}
+		for _, e := range edits {
+			what := "set " + e.Set + " = " + editValueString(&e.Value)
+			if e.Delete != "" {
+				what = "delete " + e.Delete
+			}
+			if ps.dryRun {
+				noticeColor.Printf("✏️  Would %s in %s\n", what, item.DestinationPath)
+			} else if e.Delete != "" {
+				noticeColor.Printf("✏️  Deleted %s in %s\n", e.Delete, item.DestinationPath)
+			} else {
+				noticeColor.Printf("✏️  Set %s = %s in %s\n", e.Set, editValueString(&e.Value), item.DestinationPath)
+			}
+		}
+	}
+	if len(changedPaths) > 0 {
+		rehashDeployed(changedPaths)
+	}
+}
+
+// editValueString is value as compact JSON, for messages
+func editValueString(value *yaml.Node) string {
+	n := value
+	if n.Kind == yaml.DocumentNode {
+		n = n.Content[0]
+	}
+	var buf bytes.Buffer
+	if err := writeJSONNode(&buf, n, "", ""); err != nil {
+		return n.Value
+	}
+	return strings.ReplaceAll(buf.String(), "\n", " ")
+}
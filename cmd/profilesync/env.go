@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pathVariables lists the variables mappings may start with, e.g. "${XDG_CONFIG_HOME}/nvim"
+var pathVariables = []string{"HOME", "XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_CACHE_HOME", "APPDATA", "LOCALAPPDATA"}
+
+// platformDirs returns where each path variable points for a platform whose
+// home directory is home. The XDG and Windows variables are mapped onto each
+// other so one mapping works on every platform
+func platformDirs(platform, home string) map[string]string {
+	dirs := map[string]string{"HOME": home}
+
+	switch platform {
+	case "windows":
+		dirs["APPDATA"] = filepath.Join(home, "AppData", "Roaming")
+		dirs["LOCALAPPDATA"] = filepath.Join(home, "AppData", "Local")
+		dirs["XDG_CONFIG_HOME"] = dirs["APPDATA"]
+		dirs["XDG_DATA_HOME"] = dirs["LOCALAPPDATA"]
+		dirs["XDG_STATE_HOME"] = dirs["LOCALAPPDATA"]
+		dirs["XDG_CACHE_HOME"] = filepath.Join(dirs["LOCALAPPDATA"], "Temp")
+	case "macos":
+		dirs["XDG_CONFIG_HOME"] = filepath.Join(home, ".config")
+		dirs["XDG_DATA_HOME"] = filepath.Join(home, ".local", "share")
+		dirs["XDG_STATE_HOME"] = filepath.Join(home, ".local", "state")
+		dirs["XDG_CACHE_HOME"] = filepath.Join(home, ".cache")
+		dirs["APPDATA"] = filepath.Join(home, "Library", "Application Support")
+		dirs["LOCALAPPDATA"] = dirs["APPDATA"]
+	default:
+		dirs["XDG_CONFIG_HOME"] = filepath.Join(home, ".config")
+		dirs["XDG_DATA_HOME"] = filepath.Join(home, ".local", "share")
+		dirs["XDG_STATE_HOME"] = filepath.Join(home, ".local", "state")
+		dirs["XDG_CACHE_HOME"] = filepath.Join(home, ".cache")
+		dirs["APPDATA"] = dirs["XDG_CONFIG_HOME"]
+		dirs["LOCALAPPDATA"] = dirs["XDG_DATA_HOME"]
+	}
+
+	return dirs
+}
+
+// resolveMappingPath turns a mapping path into an absolute path under base.
+// Paths may start with a variable such as ${XDG_CONFIG_HOME}; the user's
+// environment overrides the platform default only when base is the real home
+// directory of the platform we are running on
+func resolveMappingPath(base, mapping, platform string) (string, error) {
+	name, rest, ok := splitPathVariable(mapping)
+	if !ok {
+		if strings.Contains(mapping, "$") {
+			return "", fmt.Errorf("variables are only supported at the start of a mapping: %q", mapping)
+		}
+		return secureJoin(base, mapping)
+	}
+
+	dirs := platformDirs(platform, base)
+	root, known := dirs[name]
+	if !known {
+		return "", fmt.Errorf("unknown variable ${%s} in mapping %q", name, mapping)
+	}
+
+	isLocalHome := platform == DetectPlatform() && filepath.Clean(base) == filepath.Clean(GetHomeDir(platform))
+	if value := os.Getenv(name); isLocalHome && value != "" && filepath.IsAbs(value) {
+		root = value
+	}
+
+	// The variable is trusted; what follows it must stay inside
+	return secureJoin(root, strings.TrimLeft(rest, `/\`))
+}
+
+// splitPathVariable splits "${NAME}/rest" or "$NAME/rest" into NAME and rest
+func splitPathVariable(mapping string) (name, rest string, ok bool) {
+	switch {
+	case strings.HasPrefix(mapping, "${"):
+		end := strings.Index(mapping, "}")
+		if end < 0 {
+			return "", "", false
+		}
+		return mapping[2:end], mapping[end+1:], true
+	case strings.HasPrefix(mapping, "$"):
+		end := strings.IndexAny(mapping, `/\`)
+		if end < 0 {
+			end = len(mapping)
+		}
+		return mapping[1:end], mapping[end:], true
+	default:
+		return "", "", false
+	}
+}
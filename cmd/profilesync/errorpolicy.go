@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// What apply does after an item fails
+const (
+	onErrorContinue = "continue"
+	onErrorAbort    = "abort"
+	onErrorPrompt   = "prompt"
+)
+
+// Classes of the errors items fail with, as reports group them
+const (
+	errorPermission = "permission"
+	errorMissing    = "missing"
+	errorIO         = "io"
+	errorTimeout    = "timeout"
+	errorOther      = "other"
+)
+
+// errItemTimeout is the cause of an item's context when it runs out of time
+var errItemTimeout = errors.New("timed out")
+
+// errStoppedOnError is returned when --on-error stops a run after a failure
+var errStoppedOnError = errors.New("stopped after a failure")
+
+// classifyError sorts the error an item failed with into a class
+func classifyError(err error) string {
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	var errno syscall.Errno
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, errItemTimeout), errors.Is(err, context.DeadlineExceeded):
+		return errorTimeout
+	case errors.Is(err, fs.ErrPermission):
+		return errorPermission
+	case errors.Is(err, fs.ErrNotExist):
+		return errorMissing
+	case errors.As(err, &pathErr), errors.As(err, &linkErr), errors.As(err, &errno),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.ErrShortWrite):
+		return errorIO
+	}
+	return errorOther
+}
+
+// itemTimeout is how long copying an item may take, set for its mapping by
+// the catalog or for every item by --item-timeout; 0 means no limit
+func (ps *ProfileSync) itemTimeout(mapping string) time.Duration {
+	if e, ok := lookupCatalog(mapping); ok && e.Timeout != "" {
+		// validate has checked it parses
+		d, _ := time.ParseDuration(e.Timeout)
+		return d
+	}
+	return ps.timeout
+}
+
+// timeoutGrace is how long a copy that ran out of time gets to stop at its
+// next read or file before it is abandoned
+var timeoutGrace = 5 * time.Second
+
+// copyItemTimed copies an item within its timeout. Once it runs over, the
+// copy stops between files and between reads of a file. A read blocked on
+// a hung network filesystem cannot be interrupted, so a copy that has not
+// stopped after timeoutGrace is abandoned, and writes nothing more once
+// the read returns
+func (ps *ProfileSync) copyItemTimed(ctx context.Context, item MigrationItem) error {
+	timeout := ps.itemTimeout(item.Mapping)
+	if timeout <= 0 {
+		return ps.copyItem(ctx, item)
+	}
+	itemCtx, cancel := context.WithTimeoutCause(ctx, timeout, errItemTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- ps.copyItem(itemCtx, item) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-itemCtx.Done():
+		select {
+		case err = <-done:
+		case <-time.After(timeoutGrace):
+			err = context.Cause(itemCtx)
+			warnColor.Printf("⚠️  %s: abandoned a copy stuck in a read\n", item.Description)
+		}
+	}
+	if err != nil && ctx.Err() == nil && context.Cause(itemCtx) == errItemTimeout {
+		return fmt.Errorf("%w after %s", errItemTimeout, timeout)
+	}
+	return err
+}
+
+// failItem records a failed item with the class of its error
+func (ps *ProfileSync) failItem(i int, started time.Time, err error) {
+	ps.migrationPlan.Items[i].ErrorClass = classifyError(err)
+	ps.finishItem(i, started, itemFailed, err.Error())
+}
+
+// stopAfterFailure applies --on-error once an item has failed, returning
+// the error that stops the run, or nil to carry on. Without a terminal to
+// ask on, prompt stops the run
+func (ps *ProfileSync) stopAfterFailure(i int) error {
+	item := ps.migrationPlan.Items[i]
+	switch ps.onError {
+	case onErrorAbort:
+	case onErrorPrompt:
+		if ps.canPrompt() && confirm(fmt.Sprintf("%s failed. Continue with the remaining items?", item.Description), true) {
+			return nil
+		}
+	default:
+		return nil
+	}
+	if i+1 < len(ps.migrationPlan.Items) {
+		ps.markStopped(i+1, ps.migrationPlan.Items[i+1])
+	}
+	return fmt.Errorf("%w: %s (--on-error %s)", errStoppedOnError, item.Description, ps.onError)
+}
+
+// failureClasses counts the failed items of a plan by the class of their
+// error, most common first, e.g. "2 permission, 1 io"
+func failureClasses(items []MigrationItem) string {
+	counts := map[string]int{}
+	for _, item := range items {
+		if item.Status == itemFailed {
+			class := item.ErrorClass
+			if class == "" {
+				class = errorOther
+			}
+			counts[class]++
+		}
+	}
+	classes := sortedKeys(counts)
+	sort.SliceStable(classes, func(i, j int) bool { return counts[classes[i]] > counts[classes[j]] })
+	var parts []string
+	for _, class := range classes {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[class], class))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCopyItemTimed(t *testing.T) {
+	grace := timeoutGrace
+	timeoutGrace = 50 * time.Millisecond
+	t.Cleanup(func() { timeoutGrace = grace })
+
+	// The stuck copy is let go once the test is over
+	release, released := make(chan struct{}), make(chan struct{})
+	tests := []struct {
+		name string
+		copy func(ctx context.Context) error
+		want error
+	}{
+		{"in time", func(ctx context.Context) error { return nil }, nil},
+		{"fails in time", func(ctx context.Context) error { return errors.New("disk full") }, nil},
+		{"stops at its next read", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, errItemTimeout},
+		// Like a read on a hung network filesystem, it never looks at ctx
+		{"stuck in a read", func(ctx context.Context) error {
+			<-release
+			close(released)
+			return nil
+		}, errItemTimeout},
+	}
+	for _, tt := range tests {
+		run := tt.copy
+		itemHandlers["test/"+tt.name] = itemHandler{Copy: func(_ *ProfileSync, ctx context.Context, _ MigrationItem) error {
+			return run(ctx)
+		}}
+	}
+	t.Cleanup(func() {
+		close(release)
+		<-released
+		for _, tt := range tests {
+			delete(itemHandlers, "test/"+tt.name)
+		}
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping := "test/" + tt.name
+			ps := NewProfileSync("linux", "linux", false, false, false, false, false, 1)
+			ps.timeout = 20 * time.Millisecond
+			start := time.Now()
+			err := ps.copyItemTimed(context.Background(), MigrationItem{Mapping: mapping, Description: tt.name})
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("copyItemTimed took %s", elapsed)
+			}
+			switch {
+			case tt.want != nil && !errors.Is(err, tt.want):
+				t.Errorf("err = %v, want %v", err, tt.want)
+			case tt.want == nil && errors.Is(err, errItemTimeout):
+				t.Errorf("err = %v, want no timeout", err)
+			}
+		})
+	}
+}
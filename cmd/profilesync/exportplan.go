@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// exportHome stands in for the destination home while planning, so every
+// destination path comes out relative to it
+const exportHome = "/profilesync-home"
+
+// exportFormats are the formats `export --format` writes the plan in
+var exportFormats = []string{"ansible", "devcontainer", "cloud-init", "script", "codespaces"}
+
+// planEntry is one path an exported item deploys, relative to the home
+// directory. Target is set for symlinks
+type planEntry struct {
+	Path   string `yaml:"path"`
+	Mode   string `yaml:"mode,omitempty"`
+	Target string `yaml:"target,omitempty"`
+}
+
+// exportedItem collects what one plan item puts in the destination.
+// rewritten files mention the home directory, which was replaced
+type exportedItem struct {
+	dirs, files, rewritten, links []planEntry
+}
+
+// exportTarget says where collected files go and what replaces the
+// source home directory in them
+type exportTarget struct {
+	// dir receives files/ and, when templated, templates/
+	dir string
+	// home replaces the source home directory in text files and links
+	home string
+	// templated files go to templates/NAME.j2, unless they already hold
+	// template syntax, in which case they are copied unchanged
+	templated bool
+	// keepModes writes files with their own modes rather than 0644, or
+	// 0600 for private ones
+	keepModes bool
+	// redaction leaves out or redacts files; nil keeps them all
+	redaction *redactionPolicy
+}
+
+// runExportPlan handles `profilesync export --format FORMAT DIR`, writing
+// the resolved migration plan in a format other tools deploy
+func runExportPlan(args []string) {
+	flags := newFlagSet("export", "export --format FORMAT [flags] DIR")
+	format := flags.String("format", "", "Format to write the plan in: "+strings.Join(exportFormats, ", "))
+	sourcePlatform := flags.String("source", DetectPlatform(), "Source platform (linux, macos, windows)")
+	destPlatform := flags.String("dest", "linux", "Platform the plan is deployed on (linux, macos); devcontainers, cloud-init and codespaces are always linux")
+	sourceDir := flags.String("source-dir", "", "Export from this directory instead of the source platform's home, e.g. the profile store's home")
+	configFile := flags.String("config", "", "Config file with mappings and policies (default "+configPath()+" if it exists)")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config to export")
+	symlinks := flags.String("symlinks", symlinkFollow, "Symlinks in the source: follow and copy their targets, preserve them as links, or skip them")
+	types := flags.String("types", strings.Join(devcontainerTypes, ","), "Comma-separated catalog types a devcontainer gets, or all")
+	image := flags.String("image", devcontainerImage, "Base image of the devcontainer")
+	user := flags.String("user", "vscode", "User the devcontainer runs as; for cloud-init, the user to create (default: you)")
+	tags := flags.String("tags", bootstrapMinimalTag, "Comma-separated tags of the configs cloud-init, script and codespaces export, or all")
+	skipTags := flags.String("skip-tags", "", "Comma-separated tags; leave out configs with any of them from cloud-init, script and codespaces")
+	force := flags.Bool("force", false, "Write into a directory that is not empty, replacing files there")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	known := false
+	for _, f := range exportFormats {
+		known = known || *format == f
+	}
+	if !known {
+		errorColor.Println("❌ Invalid --format value:", *format)
+		errorColor.Println("Must be one of:", strings.Join(exportFormats, ", "))
+		os.Exit(2)
+	}
+	switch *sourcePlatform {
+	case "linux", "macos", "windows":
+	default:
+		errorColor.Println("❌ Invalid source platform:", *sourcePlatform)
+		errorColor.Println("Must be one of: linux, macos, windows")
+		os.Exit(1)
+	}
+	switch *format {
+	case "devcontainer", "cloud-init", "codespaces":
+		*destPlatform = "linux"
+	}
+	if *format == "cloud-init" && !flagWasSet(flags, "user") {
+		*user = currentUserName()
+	}
+	include := splitList(*tags)
+	if len(include) == 1 && include[0] == "all" {
+		include = nil
+	}
+	if *destPlatform != "linux" && *destPlatform != "macos" {
+		errorColor.Println("❌ Invalid destination platform:", *destPlatform)
+		errorColor.Println("Must be one of: linux, macos")
+		os.Exit(1)
+	}
+	if err := validSymlinkPolicy(*symlinks); err != nil {
+		errorColor.Println("❌ Invalid --symlinks value:", *symlinks)
+		errorColor.Println("Must be one of: follow, preserve, skip")
+		os.Exit(1)
+	}
+	dir := flags.Arg(0)
+	entries, _ := os.ReadDir(dir)
+	// A repository's checkout to export into holds only .git
+	if len(entries) == 1 && entries[0].Name() == ".git" {
+		entries = nil
+	}
+	if len(entries) > 0 && !*force {
+		errorColor.Printf("❌ %s is not empty (use --force to write into it)\n", dir)
+		os.Exit(1)
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	ps, sourceHome := planExport(ctx, *sourcePlatform, *destPlatform, *sourceDir, *configFile, *profile, *symlinks)
+
+	var err error
+	switch *format {
+	case "ansible":
+		err = ps.exportAnsible(ctx, sourceHome, dir)
+	case "devcontainer":
+		err = ps.exportDevcontainer(ctx, sourceHome, dir, splitList(*types), *image, *user)
+	case "cloud-init":
+		err = ps.exportCloudInit(ctx, sourceHome, dir, *user, include, splitList(*skipTags))
+	case "script":
+		err = ps.exportScript(ctx, sourceHome, dir, include, splitList(*skipTags))
+	case "codespaces":
+		err = ps.exportCodespaces(ctx, sourceHome, dir, include, splitList(*skipTags))
+	}
+	if err != nil {
+		errorColor.Println("❌ Error exporting the plan:", err)
+		os.Exit(1)
+	}
+}
+
+// planExport resolves the migration plan from sourceDir, or the source
+// platform's home, to exportHome, exiting on errors. It returns the plan
+// and the home it was made from
+func planExport(ctx context.Context, sourcePlatform, destPlatform, sourceDir, configFile, profile, symlinks string) (*ProfileSync, string) {
+	if configFile == "" {
+		if _, err := os.Stat(configPath()); err == nil {
+			configFile = configPath()
+		}
+	}
+	ps := NewProfileSync(sourcePlatform, destPlatform, true, false, false, false, false, 1)
+	ps.symlinks = symlinks
+	if configFile != "" {
+		cfg, err := loadConfig(configFile, profile)
+		if err != nil {
+			errorColor.Println("❌ Error loading config:", err)
+			os.Exit(1)
+		}
+		ps.mappings = cfg.Mappings
+		ps.secretStore = cfg.SecretStore
+		ps.redaction = cfg.Redaction
+	}
+	ignore, err := loadIgnoreFile(globalIgnorePath(configFile))
+	if err != nil {
+		errorColor.Println("❌ Error reading ignore file:", err)
+		os.Exit(1)
+	}
+	ps.ignore = ignore
+
+	sourceHome := GetHomeDir(sourcePlatform)
+	if sourceDir != "" {
+		sourceHome = sourceDir
+	}
+	if err := ps.CreateMigrationPlan(ctx, sourceHome, exportHome); err != nil {
+		errorColor.Println("❌ Error planning:", err)
+		os.Exit(1)
+	}
+	return ps, sourceHome
+}
+
+// exportedItems walks the plan's items that would be migrated, collecting
+// each one's files for out. keep, when set, picks the items to export
+func (ps *ProfileSync) exportedItems(ctx context.Context, sourceHome string, out exportTarget, keep func(MigrationItem) bool, each func(MigrationItem, string, exportedItem) error) error {
+	for _, item := range ps.migrationPlan.Items {
+		if !item.AutoMigrate {
+			continue
+		}
+		if _, err := os.Lstat(item.SourcePath); err != nil {
+			continue
+		}
+		if keep != nil && !keep(item) {
+			continue
+		}
+		rel, err := filepath.Rel(exportHome, item.DestinationPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		collected, err := ps.collectPlanItem(ctx, item, sourceHome, rel, out)
+		if err != nil {
+			return fmt.Errorf("%s: %w", item.Mapping, err)
+		}
+		if err := each(item, rel, collected); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectPlanItem walks one item's source, copying its files under
+// out.dir: to files/ as they are, or rewritten when they mention the
+// source home directory
+func (ps *ProfileSync) collectPlanItem(ctx context.Context, item MigrationItem, sourceHome, rel string, out exportTarget) (exportedItem, error) {
+	var collected exportedItem
+	root := item.SourcePath
+	var ignore ignoreRules
+	if isDir(root) {
+		var err error
+		if ignore, err = ps.directoryIgnore(root); err != nil {
+			return collected, err
+		}
+	}
+	// The profile store's files mention the home they were captured in,
+	// which is this machine's when the store is exported here
+	homes := map[string]bool{filepath.Clean(sourceHome): true, filepath.Clean(GetHomeDir(ps.sourcePlatform)): true}
+	var quoted []string
+	for h := range homes {
+		quoted = append(quoted, regexp.QuoteMeta(h))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(quoted)))
+	home := regexp.MustCompile(`(` + strings.Join(quoted, "|") + `)\b`)
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sub, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		sub = filepath.ToSlash(sub)
+		dest := rel
+		if sub != "." {
+			if ignore.ignored(sub, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			dest = path.Join(rel, sub)
+		}
+		if isTempFile(p) || d.Name() == ignoreFileName {
+			return nil
+		}
+
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			switch item.Symlinks {
+			case symlinkSkip:
+				return nil
+			case symlinkPreserve:
+				target, err := os.Readlink(p)
+				if err != nil {
+					return err
+				}
+				// Links into the source home point into the destination's
+				if withinRoot(sourceHome, target) && filepath.IsAbs(target) {
+					inside, _ := filepath.Rel(sourceHome, target)
+					target = out.home + "/" + filepath.ToSlash(inside)
+				}
+				collected.links = append(collected.links, planEntry{Path: dest, Target: target})
+				return nil
+			}
+			if info, err = os.Stat(p); err != nil {
+				warnColor.Printf("⚠️  Skipped broken symlink: %s\n", p)
+				return nil
+			}
+		}
+		mode := fmt.Sprintf("%04o", info.Mode().Perm())
+		if info.IsDir() {
+			collected.dirs = append(collected.dirs, planEntry{Path: dest, Mode: mode})
+			if d.Type()&fs.ModeSymlink != 0 {
+				if symlinkLoops(p) {
+					warnColor.Printf("🔁 Not following symlink loop: %s\n", p)
+					return nil
+				}
+				// WalkDir does not follow it, so walk what it points at
+				linked, err := ps.collectPlanItem(ctx, MigrationItem{SourcePath: p + string(filepath.Separator), Mapping: mappingPath(item.Mapping, sub) + "/", Type: item.Type, Symlinks: item.Symlinks}, sourceHome, dest, out)
+				if err != nil {
+					return err
+				}
+				collected.dirs = append(collected.dirs, linked.dirs[1:]...)
+				collected.files = append(collected.files, linked.files...)
+				collected.rewritten = append(collected.rewritten, linked.rewritten...)
+				collected.links = append(collected.links, linked.links...)
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		data, keep := out.redaction.filter(mappingPath(item.Mapping, sub), item.Type, data)
+		if !keep {
+			return nil
+		}
+		entry := planEntry{Path: dest, Mode: mode}
+		perm := info.Mode().Perm()
+		if !out.keepModes {
+			// Private files stay private in the export too
+			perm = 0644
+			if info.Mode().Perm()&0077 == 0 {
+				perm = 0600
+			}
+		}
+		text := bytes.IndexByte(data, 0) < 0
+		templ := bytes.Contains(data, []byte("{{")) || bytes.Contains(data, []byte("{%")) || bytes.Contains(data, []byte("{#"))
+		if text && home.Match(data) && !(out.templated && templ) {
+			data = home.ReplaceAll(data, []byte(out.home))
+			collected.rewritten = append(collected.rewritten, entry)
+			if out.templated {
+				return writeExported(filepath.Join(out.dir, "templates", filepath.FromSlash(dest)+".j2"), data, perm)
+			}
+		} else {
+			collected.files = append(collected.files, entry)
+		}
+		return writeExported(filepath.Join(out.dir, "files", filepath.FromSlash(dest)), data, perm)
+	})
+	sort.Slice(collected.dirs, func(i, j int) bool { return collected.dirs[i].Path < collected.dirs[j].Path })
+	return collected, err
+}
+
+// placeExported makes the links and directories of an item collected into
+// dir/files, giving the directories their modes
+func placeExported(dir string, collected exportedItem) error {
+	for _, link := range collected.links {
+		target := filepath.Join(dir, "files", filepath.FromSlash(link.Path))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		os.Remove(target)
+		if err := os.Symlink(link.Target, target); err != nil {
+			return err
+		}
+	}
+	for _, d := range collected.dirs {
+		var mode os.FileMode
+		fmt.Sscanf(d.Mode, "%o", &mode)
+		target := filepath.Join(dir, "files", filepath.FromSlash(d.Path))
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		if err := os.Chmod(target, mode|0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeExported writes a file an export deploys
+func writeExported(path string, data []byte, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data, perm); err != nil {
+		return err
+	}
+	// A replaced file would keep its old mode
+	return os.Chmod(path, perm)
+}
+
+// exportUsage is the usage line of `export`, for both of its forms
+func exportUsage() {
+	errorColor.Println("❌ Usage: profilesync export TOOL [flags] [DIR]")
+	errorColor.Println("       profilesync export archive [flags] FILE")
+	errorColor.Println("       profilesync export --format FORMAT [flags] DIR")
+	errorColor.Println("TOOL must be one of:", strings.Join(exportableTools(), ", "))
+	errorColor.Println("FORMAT must be one of:", strings.Join(exportFormats, ", "))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// firefoxExcluded are profile entries that are caches, locks or hold
+// absolute paths of the source machine. Firefox rebuilds all of them
+var firefoxExcluded = map[string]bool{
+	"cache2":                  true,
+	"startupCache":            true,
+	"thumbnails":              true,
+	"shader-cache":            true,
+	"OfflineCache":            true,
+	"safebrowsing":            true,
+	"crashes":                 true,
+	"minidumps":               true,
+	"lock":                    true,
+	".parentlock":             true,
+	"parent.lock":             true,
+	"compatibility.ini":       true,
+	"addonStartup.json.lz4":   true,
+	"storage/temporary":       true,
+	"datareporting/archived":  true,
+	"datareporting/glean/tmp": true,
+}
+
+// firefoxRoot returns the directory holding profiles.ini for a platform
+func firefoxRoot(platform, home string) string {
+	switch platform {
+	case "windows":
+		return filepath.Join(home, "AppData", "Roaming", "Mozilla", "Firefox")
+	case "macos":
+		return filepath.Join(home, "Library", "Application Support", "Firefox")
+	default:
+		return filepath.Join(home, ".mozilla", "firefox")
+	}
+}
+
+// firefoxProfilePrefix is where new profiles live relative to the root.
+// Linux keeps them next to profiles.ini, the others in Profiles/
+func firefoxProfilePrefix(platform string) string {
+	if platform == "linux" {
+		return ""
+	}
+	return "Profiles"
+}
+
+// defaultFirefoxProfile picks the profile Firefox would open: the one an
+// install section points at, then the one marked Default=1, then the only one
+func defaultFirefoxProfile(sections []*iniSection) (*iniSection, error) {
+	var profiles []*iniSection
+	for _, s := range sections {
+		if strings.HasPrefix(s.Name, "Profile") {
+			profiles = append(profiles, s)
+		}
+	}
+
+	for _, s := range sections {
+		if !strings.HasPrefix(s.Name, "Install") || s.Get("Default") == "" {
+			continue
+		}
+		for _, p := range profiles {
+			if p.Get("Path") == s.Get("Default") {
+				return p, nil
+			}
+		}
+	}
+	for _, p := range profiles {
+		if p.Get("Default") == "1" {
+			return p, nil
+		}
+	}
+	if len(profiles) == 1 {
+		return profiles[0], nil
+	}
+	return nil, fmt.Errorf("no default profile among %d in profiles.ini", len(profiles))
+}
+
+// firefoxSourceProfile finds the default profile in the source Firefox
+// root of item, returning its section of profiles.ini, its path there and
+// where it is
+func (ps *ProfileSync) firefoxSourceProfile(item MigrationItem) (*iniSection, string, string, error) {
+	data, err := os.ReadFile(filepath.Join(item.SourcePath, "profiles.ini"))
+	if err != nil {
+		return nil, "", "", err
+	}
+	profile, err := defaultFirefoxProfile(parseINI(data))
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	profilePath := profile.Get("Path")
+	if profile.Get("IsRelative") == "0" {
+		// Absolute paths belong to the source machine; look for the same
+		// directory name under the root we were given
+		profilePath = path.Join(firefoxProfilePrefix(ps.sourcePlatform), path.Base(filepath.ToSlash(profilePath)))
+	}
+	srcProfile, err := secureJoin(item.SourcePath, filepath.FromSlash(profilePath))
+	if err != nil {
+		return nil, "", "", err
+	}
+	return profile, profilePath, srcProfile, nil
+}
+
+// firefoxSource is what copyFirefoxProfile copies: the default profile,
+// less firefoxExcluded
+func (ps *ProfileSync) firefoxSource(item MigrationItem) (string, func(rel string) bool, error) {
+	_, _, srcProfile, err := ps.firefoxSourceProfile(item)
+	return srcProfile, firefoxExclude, err
+}
+
+// firefoxExclude leaves out the entries of firefoxExcluded
+func firefoxExclude(rel string) bool {
+	return firefoxExcluded[rel]
+}
+
+// copyFirefoxProfile migrates only the default profile from the source
+// Firefox root, leaving caches and locks behind, and registers it as the
+// default in the destination profiles.ini
+func (ps *ProfileSync) copyFirefoxProfile(ctx context.Context, item MigrationItem) error {
+	profile, profilePath, srcProfile, err := ps.firefoxSourceProfile(item)
+	if err != nil {
+		return err
+	}
+
+	destRel := path.Join(firefoxProfilePrefix(ps.destPlatform), path.Base(profilePath))
+	destProfile, err := secureJoin(item.DestinationPath, filepath.FromSlash(destRel))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(destProfile); err == nil && !ps.force && !ps.resume {
+		return fmt.Errorf("profile %s already exists on the destination (use --force to overwrite)", destRel)
+	}
+
+	jobs, err := ps.collectCopyJobs(ctx, srcProfile, destProfile, item.Symlinks, firefoxExclude)
+	if err != nil {
+		return err
+	}
+	err = ps.copyParallel(ctx, jobs, func(done, total int) {
+		ps.progress.setFiles(done, total)
+	})
+	if err != nil {
+		return err
+	}
+
+	name := profile.Get("Name")
+	if name == "" {
+		name = path.Base(profilePath)
+	}
+	ps.audit.watchFile(filepath.Join(item.DestinationPath, "profiles.ini"))
+	return registerFirefoxProfile(item.DestinationPath, ps.destPlatform, name, destRel)
+}
+
+// registerFirefoxProfile adds or updates the profile in root/profiles.ini and
+// makes it the default, creating the file when Firefox has never run here
+func registerFirefoxProfile(root, platform, name, rel string) error {
+	iniPath := filepath.Join(root, "profiles.ini")
+
+	var sections []*iniSection
+	if data, err := os.ReadFile(iniPath); err == nil {
+		sections = parseINI(data)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	var target *iniSection
+	var general *iniSection
+	profiles := 0
+	for _, s := range sections {
+		switch {
+		case s.Name == "General":
+			general = s
+		case strings.HasPrefix(s.Name, "Profile"):
+			profiles++
+			if s.Get("Path") == rel {
+				target = s
+			}
+			s.Delete("Default")
+		case strings.HasPrefix(s.Name, "Install"):
+			// Installs pin their own default; point them at the migrated profile
+			s.Set("Default", rel)
+		}
+	}
+
+	if target == nil {
+		target = newINISection(fmt.Sprintf("Profile%d", profiles))
+		sections = append(sections, target)
+	}
+	target.Set("Name", name)
+	target.Set("IsRelative", "1")
+	target.Set("Path", rel)
+	target.Set("Default", "1")
+
+	if general == nil {
+		general = newINISection("General")
+		sections = append(sections, general)
+	}
+	general.Set("StartWithLastProfile", "1")
+	if general.Get("Version") == "" {
+		general.Set("Version", "2")
+	}
+
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return err
+	}
+	data := formatINI(sections, "=", lineEnding(platform))
+	if err := checkTransformed(configINI, iniPath, data, "the Firefox profile registration"); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(iniPath, data, 0644); err != nil {
+		return err
+	}
+
+	// installs.ini mirrors the Install sections in newer releases
+	installsPath := filepath.Join(root, "installs.ini")
+	data, err := os.ReadFile(installsPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	installs := parseINI(data)
+	for _, s := range installs {
+		s.Set("Default", rel)
+	}
+	data = formatINI(installs, "=", lineEnding(platform))
+	if err := checkTransformed(configINI, installsPath, data, "the Firefox profile registration"); err != nil {
+		return err
+	}
+	return writeFileAtomic(installsPath, data, 0644)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// gitKeychainHelpers are credential helpers backed by an OS keychain; each
+// is swapped for the destination platform's equivalent
+var gitKeychainHelpers = map[string]bool{
+	"osxkeychain":   true,
+	"manager":       true,
+	"manager-core":  true,
+	"wincred":       true,
+	"libsecret":     true,
+	"gnome-keyring": true,
+}
+
+// gitPlatformHelper is the keychain credential helper for each platform
+var gitPlatformHelper = map[string]string{
+	"macos":   "osxkeychain",
+	"windows": "manager",
+	"linux":   "libsecret",
+}
+
+// gitToolPaths are where common diff and merge tools live on each platform
+var gitToolPaths = map[string]map[string]string{
+	"meld": {
+		"linux":   "meld",
+		"macos":   "/Applications/Meld.app/Contents/MacOS/Meld",
+		"windows": "C:/Program Files/Meld/Meld.exe",
+	},
+	"kdiff3": {
+		"linux":   "kdiff3",
+		"macos":   "/Applications/kdiff3.app/Contents/MacOS/kdiff3",
+		"windows": "C:/Program Files/KDiff3/kdiff3.exe",
+	},
+	"p4merge": {
+		"linux":   "p4merge",
+		"macos":   "/Applications/p4merge.app/Contents/MacOS/p4merge",
+		"windows": "C:/Program Files/Perforce/p4merge.exe",
+	},
+	"bc": {
+		"linux":   "bcompare",
+		"macos":   "/usr/local/bin/bcomp",
+		"windows": "C:/Program Files/Beyond Compare 4/BComp.exe",
+	},
+}
+
+// gitHomePrefix matches a home directory at the start of a path on any platform
+var gitHomePrefix = regexp.MustCompile(`^(?i:[a-z]:)?[/\\](?:Users|home)[/\\][^/\\]+`)
+
+// gitSectionHeader matches [section] and [section "subsection"]
+var gitSectionHeader = regexp.MustCompile(`^\s*\[\s*([^\s\]"]+)(?:\s+"((?:[^"\\]|\\.)*)")?\s*\]`)
+
+// gitconfigRewriter adapts a gitconfig to the destination platform and home
+type gitconfigRewriter struct {
+	srcHome     string
+	dstHome     string
+	srcPlatform string
+	dstPlatform string
+
+	// Includes are the files named by include paths and Files those named
+	// by excludesfile and attributesfile, as source paths
+	Includes []string
+	Files    []string
+	Notes    []string
+}
+
+// rewriteHome moves a path under any home directory to the destination
+// home, always with forward slashes, which git accepts everywhere
+func (r *gitconfigRewriter) rewriteHome(path string) string {
+	slashed := strings.ReplaceAll(path, `\`, "/")
+	src := filepath.ToSlash(r.srcHome)
+	dst := filepath.ToSlash(r.dstHome)
+
+	switch {
+	case src != "" && (slashed == src || strings.HasPrefix(slashed, src+"/")):
+		return dst + slashed[len(src):]
+	case gitHomePrefix.MatchString(path):
+		loc := gitHomePrefix.FindStringIndex(path)
+		return dst + strings.ReplaceAll(path[loc[1]:], `\`, "/")
+	default:
+		return path
+	}
+}
+
+// sourcePath resolves a path value as git would on the source machine
+func (r *gitconfigRewriter) sourcePath(value, relativeTo string) string {
+	switch {
+	case strings.HasPrefix(value, "~/"):
+		return filepath.Join(r.srcHome, filepath.FromSlash(value[2:]))
+	case gitHomePrefix.MatchString(value):
+		loc := gitHomePrefix.FindStringIndex(value)
+		return filepath.Join(r.srcHome, filepath.FromSlash(strings.ReplaceAll(value[loc[1]:], `\`, "/")))
+	case filepath.IsAbs(value):
+		return value
+	default:
+		return filepath.Join(relativeTo, filepath.FromSlash(value))
+	}
+}
+
+// foreignPath reports whether an absolute path only makes sense on another platform
+func (r *gitconfigRewriter) foreignPath(path string) bool {
+	if r.srcPlatform == r.dstPlatform {
+		return false
+	}
+	windowsPath := len(path) > 2 && path[1] == ':' || strings.HasPrefix(path, `\\`)
+	if r.dstPlatform == "windows" {
+		return strings.HasPrefix(path, "/")
+	}
+	return windowsPath || (r.dstPlatform == "linux" && strings.HasPrefix(path, "/Applications/"))
+}
+
+// Rewrite returns data adapted for the destination. file is the source
+// path of the config, used to resolve relative include paths
+func (r *gitconfigRewriter) Rewrite(data []byte, file string) []byte {
+	lines := strings.SplitAfter(string(data), "\n")
+	section, subsection := "", ""
+
+	for i, line := range lines {
+		body := strings.TrimRight(line, "\r\n")
+		eol := line[len(body):]
+		trimmed := strings.TrimSpace(body)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+
+		if m := gitSectionHeader.FindStringSubmatchIndex(body); m != nil {
+			section = strings.ToLower(body[m[2]:m[3]])
+			subsection = ""
+			if m[4] >= 0 {
+				subsection = body[m[4]:m[5]]
+			}
+			if section == "includeif" {
+				if cond, path, ok := strings.Cut(subsection, ":"); ok && strings.HasPrefix(cond, "gitdir") {
+					rewritten := r.rewriteHome(path)
+					if rewritten != path {
+						lines[i] = body[:m[4]] + cond + ":" + rewritten + body[m[5]:] + eol
+					}
+				}
+			}
+			continue
+		}
+
+		eq := strings.Index(body, "=")
+		if eq < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(body[:eq]))
+		raw := strings.TrimSpace(body[eq+1:])
+		value := strings.Trim(raw, `"`)
+		quoted := value != raw
+
+		newValue, drop := r.rewriteValue(section, subsection, key, value, file)
+		if drop {
+			lines[i] = ""
+			continue
+		}
+		if newValue == value {
+			continue
+		}
+		if quoted || strings.ContainsAny(newValue, " ;#") {
+			newValue = `"` + newValue + `"`
+		}
+		prefix := body[:eq+1]
+		if strings.HasPrefix(body[eq+1:], " ") {
+			prefix += " "
+		}
+		lines[i] = prefix + newValue + eol
+	}
+	return []byte(strings.Join(lines, ""))
+}
+
+// rewriteValue adapts one key's value. drop removes the line altogether
+func (r *gitconfigRewriter) rewriteValue(section, subsection, key, value, file string) (string, bool) {
+	switch {
+	case (section == "include" || section == "includeif") && key == "path":
+		r.Includes = append(r.Includes, r.sourcePath(value, filepath.Dir(file)))
+		return r.rewriteHome(value), false
+
+	case section == "core" && (key == "excludesfile" || key == "attributesfile"):
+		r.Files = append(r.Files, r.sourcePath(value, filepath.Dir(file)))
+		return r.rewriteHome(value), false
+
+	case section == "credential" && key == "helper":
+		name := strings.TrimPrefix(filepath.Base(strings.ReplaceAll(value, `\`, "/")), "git-credential-")
+		if gitKeychainHelpers[name] {
+			if helper := gitPlatformHelper[r.dstPlatform]; helper != name {
+				r.Notes = append(r.Notes, fmt.Sprintf("credential helper %s → %s", value, helper))
+				return helper, false
+			}
+		}
+
+	case (section == "difftool" || section == "mergetool") && key == "path":
+		if paths, ok := gitToolPaths[strings.ToLower(subsection)]; ok {
+			if path := paths[r.dstPlatform]; path != value {
+				r.Notes = append(r.Notes, fmt.Sprintf("%s.%s.path → %s", section, subsection, path))
+				return path, false
+			}
+			return value, false
+		}
+		if r.foreignPath(value) {
+			r.Notes = append(r.Notes, fmt.Sprintf("removed %s.%s.path %s; git will look for the tool on PATH", section, subsection, value))
+			return "", true
+		}
+
+	case (section == "difftool" || section == "mergetool") && key == "cmd",
+		section == "core" && (key == "editor" || key == "sshcommand" || key == "pager"):
+		value = r.rewriteHome(value)
+		if r.foreignPath(strings.TrimLeft(value, `'"`)) {
+			r.Notes = append(r.Notes, fmt.Sprintf("check %s.%s: %q does not run on %s", section, key, value, r.dstPlatform))
+		}
+		return value, false
+	}
+
+	return r.rewriteHome(value), false
+}
+
+// copyGitconfig migrates ~/.gitconfig and the files it includes, rewriting
+// paths, credential helpers and tool locations for the destination. Ignore
+// and attributes files it names are copied as they are
+func (ps *ProfileSync) copyGitconfig(ctx context.Context, item MigrationItem) error {
+	r := &gitconfigRewriter{
+		srcHome:     filepath.Dir(item.SourcePath),
+		dstHome:     filepath.Dir(item.DestinationPath),
+		srcPlatform: ps.sourcePlatform,
+		dstPlatform: ps.destPlatform,
+	}
+
+	type gitFile struct {
+		path   string
+		config bool
+	}
+	done := map[string]bool{}
+	pending := []gitFile{{item.SourcePath, true}}
+
+	for len(pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		file := pending[0]
+		pending = pending[1:]
+		if done[file.path] {
+			continue
+		}
+		done[file.path] = true
+
+		main := file.path == item.SourcePath
+		rel, err := filepath.Rel(r.srcHome, file.path)
+		if err != nil || !withinRoot(r.srcHome, file.path) {
+			warnColor.Printf("⚠️  Git: %s is outside the home directory; copy it by hand\n", file.path)
+			continue
+		}
+		dst, err := secureJoin(r.dstHome, rel)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(file.path)
+		if os.IsNotExist(err) && !main {
+			continue
+		} else if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(file.path)
+		if err != nil {
+			return err
+		}
+
+		if file.config {
+			includes, files := len(r.Includes), len(r.Files)
+			data = r.Rewrite(data, file.path)
+			for _, path := range r.Includes[includes:] {
+				pending = append(pending, gitFile{path, true})
+			}
+			for _, path := range r.Files[files:] {
+				pending = append(pending, gitFile{path, false})
+			}
+		}
+
+		if file.config {
+			if err := checkTransformed(configGitconfig, dst, data, "the gitconfig rewrite"); err != nil {
+				return err
+			}
+		}
+
+		// The main file's existence was already settled by the migration
+		if _, err := os.Stat(dst); err == nil && !ps.force && !main {
+			warnColor.Printf("⚠️  Git: kept the destination's %s (use --force to replace it)\n", rel)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(dst, data, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if ps.verbose && !main {
+			noticeColor.Printf("📄 %s\n", rel)
+		}
+	}
+
+	for _, note := range r.Notes {
+		warnColor.Printf("⚠️  Git: %s\n", note)
+	}
+	return nil
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GPGKey is one key captured from the keyring
+type GPGKey struct {
+	Fingerprint string   `yaml:"fingerprint"`
+	UserIDs     []string `yaml:"user_ids,omitempty"`
+	// Secret is whether the secret key was captured, not just the public one
+	Secret bool `yaml:"secret"`
+}
+
+// GPGManifest lists the keys captured into a profile store
+type GPGManifest struct {
+	CapturedAt time.Time `yaml:"captured_at"`
+	Platform   string    `yaml:"platform"`
+	Keys       []GPGKey  `yaml:"keys"`
+	// Keyring is whether every public key and all ownertrust was captured,
+	// not only those of Keys
+	Keyring bool `yaml:"keyring"`
+	// SigningKey is git's user.signingkey where the keys were captured
+	SigningKey string `yaml:"signing_key,omitempty"`
+}
+
+// gpgDir and the paths below are where GPG keys live in a profile store.
+// Only the secret keys are encrypted; public keys and trust are not secret
+func gpgDir(dir string) string {
+	return filepath.Join(dir, "gpg")
+}
+
+func gpgManifestPath(dir string) string {
+	return filepath.Join(gpgDir(dir), "gpg.yaml")
+}
+
+func gpgPublicPath(dir string) string {
+	return filepath.Join(gpgDir(dir), "public-keys.asc")
+}
+
+func gpgSecretPath(dir string) string {
+	return filepath.Join(gpgDir(dir), "secret-keys.asc.gpg")
+}
+
+func gpgTrustPath(dir string) string {
+	return filepath.Join(gpgDir(dir), "ownertrust.txt")
+}
+
+// gpgPassphraseEnv names the variable that holds the passphrase of the
+// encrypted secret keys for runs nobody can type it in
+const gpgPassphraseEnv = "PROFILESYNC_GPG_PASSPHRASE"
+
+// runGPG runs gpg with stdin, returning its stdout. gpg's own prompts go
+// through pinentry, so they work with stdin and stdout redirected
+func runGPG(stdin []byte, args ...string) ([]byte, error) {
+	metrics.request("gpg")
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("gpg", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			// gpg prefixes its own messages with "gpg:"
+			return nil, errors.New(lastLine(msg))
+		}
+		return nil, fmt.Errorf("gpg: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// runGPGWithPassphrase runs a gpg command that needs the passphrase of the
+// encrypted secret keys: from gpgPassphraseEnv or the OS keystore when set
+// (see `auth set gpg-passphrase`), else from pinentry
+func runGPGWithPassphrase(stdin []byte, args ...string) ([]byte, error) {
+	passphrase, ok, err := lookupSecret(authGPGPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return runGPG(stdin, args...)
+	}
+	// gpg reads the passphrase a byte at a time up to the newline, leaving
+	// the rest of stdin as the data
+	input := append([]byte(passphrase+"\n"), stdin...)
+	return runGPG(input, append([]string{"--batch", "--pinentry-mode", "loopback", "--passphrase-fd", "0"}, args...)...)
+}
+
+// listGPGKeys lists the keys in the keyring, the secret ones when secret
+// is set, from gpg's machine-readable listing
+func listGPGKeys(secret bool) ([]GPGKey, error) {
+	list := "--list-keys"
+	if secret {
+		list = "--list-secret-keys"
+	}
+	out, err := runGPG(nil, "--batch", "--with-colons", "--fixed-list-mode", list)
+	if err != nil {
+		return nil, err
+	}
+	var keys []GPGKey
+	primary := false
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "pub" || fields[0] == "sec":
+			keys = append(keys, GPGKey{Secret: secret})
+			primary = true
+		case fields[0] == "sub" || fields[0] == "ssb":
+			primary = false
+		case fields[0] == "fpr" && primary && len(fields) > 9 && len(keys) > 0:
+			keys[len(keys)-1].Fingerprint = fields[9]
+			primary = false
+		case fields[0] == "uid" && len(fields) > 9 && len(keys) > 0:
+			keys[len(keys)-1].UserIDs = append(keys[len(keys)-1].UserIDs, gpgUnescape(fields[9]))
+		}
+	}
+	return keys, nil
+}
+
+// gpgUnescape undoes the \xNN escapes of gpg's colon listings
+func gpgUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			var c byte
+			if _, err := fmt.Sscanf(s[i+2:i+4], "%02x", &c); err == nil {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// matches reports whether a --keys selector names the key: a fingerprint,
+// a long or short key ID, or part of a user ID such as an email address
+func (k GPGKey) matches(selector string) bool {
+	hex := strings.ToUpper(strings.TrimPrefix(selector, "0x"))
+	if len(hex) >= 8 && strings.HasSuffix(k.Fingerprint, hex) {
+		return true
+	}
+	for _, uid := range k.UserIDs {
+		if strings.Contains(strings.ToLower(uid), strings.ToLower(selector)) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectGPGKeys picks the secret keys named by selectors, or all of them
+func selectGPGKeys(keys []GPGKey, selectors []string) ([]GPGKey, error) {
+	if len(selectors) == 0 {
+		return keys, nil
+	}
+	var selected []GPGKey
+	for _, sel := range selectors {
+		found := false
+		for _, k := range keys {
+			if k.matches(sel) {
+				found = true
+				if !containsGPGKey(selected, k.Fingerprint) {
+					selected = append(selected, k)
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no secret key matches %q", sel)
+		}
+	}
+	return selected, nil
+}
+
+// containsGPGKey reports whether keys has the key with fingerprint
+func containsGPGKey(keys []GPGKey, fingerprint string) bool {
+	for _, k := range keys {
+		if k.Fingerprint == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// filterOwnertrust keeps the lines of an ownertrust export for keys,
+// dropping the comments
+func filterOwnertrust(trust []byte, keys []GPGKey) []byte {
+	var out bytes.Buffer
+	for _, line := range strings.Split(string(trust), "\n") {
+		fpr, _, ok := strings.Cut(line, ":")
+		if ok && !strings.HasPrefix(line, "#") && containsGPGKey(keys, fpr) {
+			out.WriteString(line + "\n")
+		}
+	}
+	return out.Bytes()
+}
+
+// gitGlobal reads a key from git's global config, empty when unset or when
+// git is not installed
+func gitGlobal(key string) string {
+	out, err := exec.Command("git", "config", "--global", "--get", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// CaptureGPG exports the selected secret keys, encrypted with a passphrase,
+// their public keys (or the whole keyring) and ownertrust into the profile store
+func CaptureGPG(dir string, selectors []string, keyring bool) (*GPGManifest, error) {
+	secret, err := listGPGKeys(true)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("the keyring has no secret keys")
+	}
+	keys, err := selectGPGKeys(secret, selectors)
+	if err != nil {
+		return nil, err
+	}
+	fingerprints := make([]string, len(keys))
+	for i, k := range keys {
+		fingerprints[i] = k.Fingerprint
+	}
+
+	exportArgs := []string{"--batch", "--armor", "--export"}
+	if !keyring {
+		exportArgs = append(exportArgs, fingerprints...)
+	}
+	public, err := runGPG(nil, exportArgs...)
+	if err != nil {
+		return nil, err
+	}
+	trust, err := runGPG(nil, "--batch", "--export-ownertrust")
+	if err != nil {
+		return nil, err
+	}
+	if !keyring {
+		trust = filterOwnertrust(trust, keys)
+	}
+
+	// Exporting may ask for each key's own passphrase; the export stays in
+	// memory until gpg has encrypted it
+	plain, err := runGPG(nil, append([]string{"--armor", "--export-secret-keys"}, fingerprints...)...)
+	if err != nil {
+		return nil, err
+	}
+	infoColor.Println("🔑 Choose a passphrase to encrypt the secret keys with; you will need it to apply them")
+	sealed, err := runGPGWithPassphrase(plain, "--armor", "--symmetric", "--cipher-algo", "AES256", "--output", "-")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &GPGManifest{
+		CapturedAt: time.Now().UTC(),
+		Platform:   DetectPlatform(),
+		Keyring:    keyring,
+		SigningKey: gitGlobal("user.signingkey"),
+	}
+	m.Keys = append(m.Keys, keys...)
+	if keyring {
+		public, err := listGPGKeys(false)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range public {
+			if !containsGPGKey(m.Keys, k.Fingerprint) {
+				m.Keys = append(m.Keys, k)
+			}
+		}
+	}
+	manifest, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(gpgDir(dir), 0700); err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		path string
+		data []byte
+	}{
+		{gpgSecretPath(dir), sealed},
+		{gpgPublicPath(dir), public},
+		{gpgTrustPath(dir), trust},
+		{gpgManifestPath(dir), manifest},
+	} {
+		if err := writeFileAtomic(f.path, f.data, 0600); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// loadGPGManifest reads the keys captured into a profile store
+func loadGPGManifest(dir string) (*GPGManifest, error) {
+	data, err := os.ReadFile(gpgManifestPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	var m GPGManifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", gpgManifestPath(dir), err)
+	}
+	return &m, nil
+}
+
+// ApplyGPG imports the captured keys and trust, then points git at this
+// machine's gpg and the captured signing key. It returns what it changed,
+// or would change on a dry run
+func ApplyGPG(dir string, m *GPGManifest, dryRun bool) ([]string, error) {
+	var changes []string
+	have := map[string]bool{}
+	for _, secret := range []bool{false, true} {
+		keys, err := listGPGKeys(secret)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			have[fmt.Sprint(secret, k.Fingerprint)] = true
+		}
+	}
+	for _, k := range m.Keys {
+		switch {
+		case k.Secret && !have[fmt.Sprint(true, k.Fingerprint)]:
+			changes = append(changes, "import secret key "+describeGPGKey(k))
+		case !k.Secret && !have[fmt.Sprint(false, k.Fingerprint)]:
+			changes = append(changes, "import public key "+describeGPGKey(k))
+		}
+	}
+
+	if !dryRun {
+		// Decrypt first, so a wrong passphrase leaves the keyring alone
+		sealed, err := os.ReadFile(gpgSecretPath(dir))
+		if err != nil {
+			return nil, err
+		}
+		infoColor.Println("🔑 Enter the passphrase the secret keys were captured with")
+		plain, err := runGPGWithPassphrase(sealed, "--decrypt")
+		if err != nil {
+			return nil, err
+		}
+		public, err := os.ReadFile(gpgPublicPath(dir))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := runGPG(public, "--batch", "--import"); err != nil {
+			return nil, err
+		}
+		if _, err := runGPG(plain, "--batch", "--import"); err != nil {
+			return nil, err
+		}
+		trust, err := os.ReadFile(gpgTrustPath(dir))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := runGPG(trust, "--batch", "--import-ownertrust"); err != nil {
+			return nil, err
+		}
+	}
+
+	gitChanges, err := applyGitSigning(m, dryRun)
+	return append(changes, gitChanges...), err
+}
+
+// describeGPGKey names a key by its first user ID and short fingerprint
+func describeGPGKey(k GPGKey) string {
+	id := k.Fingerprint
+	if len(id) > 16 {
+		id = id[len(id)-16:]
+	}
+	if len(k.UserIDs) > 0 {
+		return fmt.Sprintf("%s (%s)", k.UserIDs[0], id)
+	}
+	return id
+}
+
+// applyGitSigning points git's gpg.program at this machine's gpg when the
+// configured one is not here, and sets user.signingkey when it is unset
+func applyGitSigning(m *GPGManifest, dryRun bool) ([]string, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, nil
+	}
+	set := map[string]string{}
+	if program := gitGlobal("gpg.program"); program != "" {
+		if _, err := exec.LookPath(program); err != nil {
+			if gpg, err := exec.LookPath("gpg"); err == nil {
+				set["gpg.program"] = filepath.ToSlash(gpg)
+			}
+		}
+	}
+	if signing := gitGlobal("user.signingkey"); signing == "" && m.SigningKey != "" && gitGlobal("gpg.format") != "ssh" {
+		set["user.signingkey"] = m.SigningKey
+	}
+
+	var changes []string
+	for _, key := range sortedKeys(set) {
+		changes = append(changes, fmt.Sprintf("set git %s to %s", key, set[key]))
+		if dryRun {
+			continue
+		}
+		if out, err := exec.Command("git", "config", "--global", key, set[key]).CombinedOutput(); err != nil {
+			return changes, fmt.Errorf("git config %s: %v %s", key, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return changes, nil
+}
+
+// runCaptureGPG handles `profilesync capture gpg`
+func runCaptureGPG(args []string) {
+	flags := newFlagSet("capture gpg", "capture gpg [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to write to")
+	keys := flags.String("keys", "", "Comma-separated fingerprints, key IDs or email addresses of the secret keys to capture (default all)")
+	keyring := flags.Bool("keyring", true, "Also capture every public key in the keyring and all ownertrust, not only those of the captured keys")
+	flags.Parse(args)
+
+	if _, err := exec.LookPath("gpg"); err != nil {
+		warnColor.Println("⏭️  gpg is not installed; nothing to do")
+		return
+	}
+	m, err := CaptureGPG(*dir, splitList(*keys), *keyring)
+	if err != nil {
+		errorColor.Println("❌ Error capturing GPG keys:", err)
+		os.Exit(1)
+	}
+	secret := 0
+	for _, k := range m.Keys {
+		if k.Secret {
+			secret++
+			fmt.Printf("   🔑 %s\n", describeGPGKey(k))
+		}
+	}
+	successColor.Printf("✅ %d secret and %d public key(s) saved to %s\n", secret, len(m.Keys)-secret, gpgDir(*dir))
+}
+
+// runApplyGPG handles `profilesync apply gpg`
+func runApplyGPG(args []string) {
+	flags := newFlagSet("apply gpg", "apply gpg [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to read from")
+	dryRun := flags.Bool("dry-run", true, "Show the keys that would be imported without importing them")
+	flags.Parse(args)
+
+	if _, err := exec.LookPath("gpg"); err != nil {
+		warnColor.Println("⏭️  gpg is not installed; install it, then run this again")
+		return
+	}
+	m, err := loadGPGManifest(*dir)
+	if err != nil {
+		errorColor.Println("❌ Error reading GPG keys (run `profilesync capture gpg` first):", err)
+		os.Exit(1)
+	}
+	noticeColor.Printf("🔐 Applying %d GPG key(s) captured on %s (%s)\n", len(m.Keys), m.Platform, m.CapturedAt.Format(time.RFC1123))
+	changes, err := ApplyGPG(*dir, m, *dryRun)
+	for _, change := range changes {
+		if *dryRun {
+			fmt.Printf("   Would %s\n", change)
+			continue
+		}
+		verb, rest, _ := strings.Cut(change, " ")
+		fmt.Printf("   ✅ %s %s\n", map[string]string{"import": "Imported", "set": "Set"}[verb], rest)
+	}
+	if err != nil {
+		errorColor.Println("❌ Error applying GPG keys:", err)
+		os.Exit(1)
+	}
+	if len(changes) == 0 {
+		successColor.Println("✅ Keyring and git are up to date")
+		return
+	}
+	if *dryRun {
+		warnColor.Println("⚠️  This was a DRY RUN. Run with --dry-run=false to apply.")
+	}
+}
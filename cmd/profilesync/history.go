@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// savedRun is a report saved by recordRun, with the name of its file
+type savedRun struct {
+	runReport
+	name string
+}
+
+// id names the run for `history show`. Reports saved before runs had IDs
+// are named by their file
+func (r savedRun) id() string {
+	if r.Run != "" {
+		return r.Run
+	}
+	return strings.TrimSuffix(r.name, ".json")
+}
+
+// mode says whether the run was live and how it ended
+func (r savedRun) mode() string {
+	mode := "live"
+	if r.DryRun {
+		mode = "dry run"
+	}
+	switch {
+	case r.Error != "":
+		mode += ", failed"
+	case r.Plan != nil && r.Plan.Interrupted:
+		mode += ", stopped"
+	}
+	return mode
+}
+
+// counts says how many items ended each way, most common first
+func (r savedRun) counts() string {
+	if r.Plan == nil {
+		return ""
+	}
+	counts := map[string]int{}
+	for _, item := range r.Plan.Items {
+		if item.Detail == "not found" {
+			continue
+		}
+		status := item.Status
+		if status == "" {
+			status = itemNotRun
+		}
+		counts[status]++
+	}
+	statuses := sortedKeys(counts)
+	sort.SliceStable(statuses, func(i, j int) bool { return counts[statuses[i]] > counts[statuses[j]] })
+	var parts []string
+	for _, status := range statuses {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// loadHistory reads the saved reports, oldest first. Unreadable reports
+// are reported and left out
+func loadHistory() ([]savedRun, error) {
+	entries, err := os.ReadDir(reportsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []savedRun
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		data, err := readStateFile(filepath.Join(reportsDir(), e.Name()))
+		var r savedRun
+		if err == nil {
+			err = json.Unmarshal(data, &r.runReport)
+		}
+		if err != nil {
+			warnColor.Printf("⚠️  Skipping report %s: %v\n", e.Name(), err)
+			continue
+		}
+		r.name = e.Name()
+		runs = append(runs, r)
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].StartedAt.Before(runs[j].StartedAt) })
+	return runs, nil
+}
+
+// findRuns returns the reports of a run by its ID, or a unique prefix of
+// it; last is the most recent run. Migrating several users saves a report
+// for each under the same ID
+func findRuns(runs []savedRun, ref string) ([]savedRun, error) {
+	if len(runs) == 0 {
+		return nil, errors.New("no runs recorded yet")
+	}
+	if ref == "last" {
+		ref = runs[len(runs)-1].id()
+	}
+	var found []savedRun
+	ids := map[string]bool{}
+	for _, r := range runs {
+		if r.id() == ref || strings.HasPrefix(r.id(), ref) {
+			found = append(found, r)
+			ids[r.id()] = true
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return nil, fmt.Errorf("no run %s; list them with `profilesync history`", ref)
+	case len(ids) > 1:
+		return nil, fmt.Errorf("%s matches several runs: %s", ref, strings.Join(sortedKeys(ids), ", "))
+	}
+	return found, nil
+}
+
+// printRun shows one saved report: how the run went and what happened to
+// each item
+func printRun(r savedRun) {
+	infoColor.Println(strings.Repeat("=", 60))
+	title := "📜 RUN " + r.id()
+	if r.User != "" {
+		title += " FOR " + r.User
+	}
+	infoColor.Println(title)
+	infoColor.Println(strings.Repeat("=", 60))
+	noticeColor.Printf("Started:           %s (took %s)\n", r.StartedAt.Local().Format("2006-01-02 15:04:05"), r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond))
+	noticeColor.Printf("Mode:              %s\n", r.mode())
+	if r.Plan == nil {
+		return
+	}
+	noticeColor.Printf("Source:            %s (%s)\n", r.Plan.SourcePlatform, r.SourceBase)
+	noticeColor.Printf("Destination:       %s (%s)\n", r.Plan.DestinationPlatform, r.DestBase)
+	noticeColor.Printf("Result:            %s\n", r.counts())
+	if failures := failureClasses(r.Plan.Items); failures != "" {
+		noticeColor.Printf("Failures:          %s\n", failures)
+	}
+	if r.Error != "" {
+		errorColor.Printf("❌ %s\n", r.Error)
+	}
+	if r.Plan.Interrupted {
+		warnColor.Printf("⛔ Stopped at %s (item %d of %d)\n", r.Plan.StoppedAt, r.Plan.StoppedIndex+1, len(r.Plan.Items))
+	}
+	infoColor.Println(strings.Repeat("=", 60))
+
+	notFound := 0
+	for _, item := range r.Plan.Items {
+		if item.Detail == "not found" {
+			notFound++
+			continue
+		}
+		status := item.Status
+		if status == "" {
+			status = itemNotRun
+		}
+		line := fmt.Sprintf("  %-18s %s", status, item.Description)
+		if item.Detail != "" {
+			line += " (" + item.Detail + ")"
+		}
+		if item.ErrorClass != "" {
+			line += " [" + item.ErrorClass + "]"
+		}
+		switch {
+		case strings.HasSuffix(status, itemFailed) || strings.HasSuffix(status, itemConflicts):
+			errorColor.Println(line)
+		case strings.HasSuffix(status, itemMigrated) || strings.HasSuffix(status, itemMerged):
+			successColor.Println(line)
+		default:
+			fmt.Println(line)
+		}
+	}
+	if notFound > 0 {
+		fmt.Printf("  %d items not found on the source are not listed\n", notFound)
+	}
+}
+
+// runHistory handles `profilesync history [show RUN]`, listing past runs
+// or showing one of them
+func runHistory(args []string) {
+	if len(args) > 0 && args[0] == "show" {
+		runHistoryShow(args[1:])
+		return
+	}
+	flags := newFlagSet("history", "history [flags]")
+	limit := flags.Int("limit", 20, "Show this many of the most recent runs, 0 for all")
+	since := flags.String("since", "", "Only runs since this long ago (such as 720h) or this date")
+	live := flags.Bool("live", false, "Leave out dry runs")
+	flags.Parse(args)
+
+	var from time.Time
+	if *since != "" {
+		var err error
+		if from, err = parseSince(*since); err != nil {
+			errorColor.Println("❌ Error: --since", err)
+			os.Exit(2)
+		}
+	}
+	runs, err := loadHistory()
+	if err != nil {
+		errorColor.Println("❌ Error reading run history:", err)
+		os.Exit(1)
+	}
+	var shown []savedRun
+	for _, r := range runs {
+		if !r.StartedAt.Before(from) && !(*live && r.DryRun) {
+			shown = append(shown, r)
+		}
+	}
+	if *limit > 0 && len(shown) > *limit {
+		shown = shown[len(shown)-*limit:]
+	}
+	if len(shown) == 0 {
+		fmt.Println("No runs recorded yet")
+		return
+	}
+	for _, r := range shown {
+		infoColor.Printf("%-26s", r.id())
+		fmt.Printf("  %s  %-16s", r.StartedAt.Local().Format("2006-01-02 15:04"), r.mode())
+		if r.User != "" {
+			fmt.Printf("  %s:", r.User)
+		}
+		fmt.Printf("  %s\n", r.counts())
+	}
+	noticeColor.Println("Show one with `profilesync history show RUN`")
+}
+
+// runHistoryShow handles `profilesync history show RUN`
+func runHistoryShow(args []string) {
+	flags := newFlagSet("history show", "history show [flags] RUN|last")
+	report := flags.String("report", "", "Also write the run's report to this file, as HTML or Markdown by its extension")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *report != "" {
+		if err := checkReportPath(*report); err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(2)
+		}
+	}
+	runs, err := loadHistory()
+	if err != nil {
+		errorColor.Println("❌ Error reading run history:", err)
+		os.Exit(1)
+	}
+	found, err := findRuns(runs, flags.Arg(0))
+	if err != nil {
+		errorColor.Println("❌ Error:", err)
+		os.Exit(1)
+	}
+
+	for _, r := range found {
+		printRun(r)
+		if *report == "" || r.Plan == nil {
+			continue
+		}
+		ps := &ProfileSync{sourcePlatform: r.Plan.SourcePlatform, destPlatform: r.Plan.DestinationPlatform, dryRun: r.DryRun, user: r.User, migrationPlan: r.Plan}
+		// The files may have changed since, so sizes and hashes are left out
+		data := ps.buildReportData(r.StartedAt.Local(), r.FinishedAt.Local(), r.SourceBase, r.DestBase, func(string) (int64, int, string) { return 0, 0, "" })
+		path := *report
+		if len(found) > 1 {
+			path = userReportPath(path, r.User)
+		}
+		if err := writeReportData(path, data); err != nil {
+			errorColor.Println("❌ Error writing the report:", err)
+			os.Exit(1)
+		}
+		noticeColor.Printf("📄 Report written to %s\n", path)
+	}
+
+	changes, err := readAudit(func(a AuditRecord) bool { return a.Run == found[0].Run && found[0].Run != "" })
+	if err == nil && len(changes) > 0 {
+		noticeColor.Printf("%d files changed; list them with `profilesync audit --run %s`\n", len(changes), found[0].Run)
+	}
+}
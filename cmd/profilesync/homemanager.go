@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// homeManagerFiles is the directory next to profilesync.nix that holds the
+// files it deploys
+const homeManagerFiles = "profilesync"
+
+// homeManagerPrograms are the home-manager programs recognized from the
+// files in the profile store. signals are paths that show the program is
+// used; owns are paths its module writes itself, which cannot also be
+// deployed as home.file entries
+var homeManagerPrograms = []struct {
+	name          string
+	signals, owns []string
+}{
+	{"alacritty", []string{".config/alacritty/"}, []string{".config/alacritty/alacritty.toml", ".config/alacritty/alacritty.yml"}},
+	{"bash", []string{".bashrc", ".bash_profile"}, []string{".bashrc", ".bash_profile", ".profile"}},
+	{"fish", []string{".config/fish/"}, []string{".config/fish/config.fish"}},
+	{"git", []string{".gitconfig", ".config/git/"}, []string{".config/git/config"}},
+	{"htop", []string{".config/htop/"}, []string{".config/htop/htoprc"}},
+	{"kitty", []string{".config/kitty/"}, []string{".config/kitty/kitty.conf"}},
+	{"neovim", []string{".config/nvim/"}, []string{".config/nvim/init.lua"}},
+	{"ssh", []string{".ssh/config"}, []string{".ssh/config"}},
+	{"starship", []string{".config/starship.toml"}, []string{".config/starship.toml"}},
+	{"tmux", []string{".tmux.conf", ".config/tmux/"}, []string{".config/tmux/tmux.conf"}},
+	{"vim", []string{".vimrc", ".vim/"}, []string{".vimrc"}},
+	{"zsh", []string{".zshrc"}, []string{".zshrc", ".zshenv"}},
+}
+
+// homeManagerDir is where home-manager's standalone configuration lives
+func homeManagerDir(home string) string {
+	return filepath.Join(home, ".config", "home-manager")
+}
+
+// nixPathLiteral matches relative paths Nix accepts as path literals
+var nixPathLiteral = regexp.MustCompile(`^[A-Za-z0-9._+/-]+$`)
+
+// nixString quotes s as a Nix string
+func nixString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// nixSource is the expression for a file copied next to profilesync.nix
+func nixSource(rel string) string {
+	if nixPathLiteral.MatchString(rel) && !strings.Contains(rel, "//") {
+		return "./" + homeManagerFiles + "/" + rel
+	}
+	return "./" + homeManagerFiles + " + " + nixString("/"+rel)
+}
+
+// exportHomeManager writes profilesync.nix, a home-manager module with a
+// home.file entry for every file in the profile store, and the files it
+// deploys. Private files are left out, as everything in the Nix store is
+// world-readable
+func exportHomeManager(storeHome, dir string, filter storeFilter) (int, error) {
+	var entries []string
+	var private []string
+	present := map[string]bool{}
+	written := 0
+
+	err := filepath.WalkDir(storeHome, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(storeHome, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if isTempFile(p) || d.Name() == ignoreFileName {
+			return nil
+		}
+		if d.IsDir() {
+			present[rel+"/"] = true
+			return nil
+		}
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		present[rel] = true
+		name := nixString(rel)
+
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			// The link is made outside the Nix store, so it must be
+			// absolute; relative targets are taken from the link's directory
+			expr := nixString(target)
+			if !filepath.IsAbs(target) {
+				expr = "(config.home.homeDirectory + " + nixString(path.Join("/", path.Dir(rel), filepath.ToSlash(target))) + ")"
+			}
+			entries = append(entries, fmt.Sprintf("    %s.source = config.lib.file.mkOutOfStoreSymlink %s;", name, expr))
+		case !info.Mode().IsRegular():
+		case info.Mode().Perm()&0077 == 0:
+			private = append(private, rel)
+		default:
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			data, keep := filter(p, data)
+			if !keep {
+				return nil
+			}
+			if err := writeExported(filepath.Join(dir, homeManagerFiles, filepath.FromSlash(rel)), data, 0644); err != nil {
+				return err
+			}
+			written++
+			if info.Mode().Perm()&0111 != 0 {
+				entries = append(entries, fmt.Sprintf("    %s = {\n      source = %s;\n      executable = true;\n    };", name, nixSource(rel)))
+			} else {
+				entries = append(entries, fmt.Sprintf("    %s.source = %s;", name, nixSource(rel)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return written, err
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by `profilesync export home-manager` from " + storeHome + "\n")
+	b.WriteString("# Add it to the imports of your home.nix:\n#   imports = [ ./profilesync.nix ];\n")
+	b.WriteString("{ config, ... }:\n\n{\n")
+	programs := false
+	for _, prog := range homeManagerPrograms {
+		used := false
+		for _, s := range prog.signals {
+			used = used || present[s]
+		}
+		if !used {
+			continue
+		}
+		var clash []string
+		for _, o := range prog.owns {
+			if present[o] {
+				clash = append(clash, o)
+			}
+		}
+		if len(clash) > 0 {
+			fmt.Fprintf(&b, "  # programs.%s.enable = true; # would write %s itself; it is deployed below as it is\n", prog.name, strings.Join(clash, " and "))
+		} else {
+			fmt.Fprintf(&b, "  programs.%s.enable = true;\n", prog.name)
+		}
+		programs = true
+	}
+	if programs {
+		b.WriteString("\n")
+	}
+	if len(private) > 0 {
+		sort.Strings(private)
+		b.WriteString("  # Private files, left out because the Nix store is world-readable.\n")
+		b.WriteString("  # Deploy them with a secrets tool such as sops-nix or agenix:\n")
+		for _, rel := range private {
+			b.WriteString("  #   " + rel + "\n")
+		}
+		b.WriteString("\n")
+		warnColor.Printf("⚠️  Left out %d private files the Nix store would make world-readable; they are listed in profilesync.nix\n", len(private))
+	}
+	b.WriteString("  home.file = {\n")
+	for _, e := range entries {
+		b.WriteString(e + "\n")
+	}
+	b.WriteString("  };\n}\n")
+
+	if err := writeExported(filepath.Join(dir, "profilesync.nix"), []byte(b.String()), 0644); err != nil {
+		return written, err
+	}
+	noticeColor.Printf("Add `imports = [ ./profilesync.nix ];` to the home.nix in %s\n", dir)
+	return written, nil
+}
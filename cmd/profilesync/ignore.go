@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileName is the name of ignore files, both the global one next to
+// the config file and the one at the root of a mapped directory
+const ignoreFileName = ".profilesyncignore"
+
+// ignorePattern is one line of an ignore file
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules are the patterns of one or more ignore files, in order; the
+// last pattern matching a path decides whether it is ignored, as in git
+type ignoreRules []ignorePattern
+
+// parseIgnore reads gitignore-style patterns: blank lines and # comments
+// are skipped, ! re-includes, a trailing / matches only directories, a
+// pattern with a / elsewhere is relative to the directory's root, and ** matches
+// any number of directories
+func parseIgnore(data []byte) (ignoreRules, error) {
+	var rules ignoreRules
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		// Trailing spaces are dropped unless escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			return nil, fmt.Errorf("line %d: empty pattern", n)
+		}
+		expr := globRegexp(line)
+		if anchored {
+			expr = "^" + expr + "$"
+		} else {
+			expr = "^(?:.*/)?" + expr + "$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q", n, scanner.Text())
+		}
+		p.re = re
+		rules = append(rules, p)
+	}
+	return rules, scanner.Err()
+}
+
+// globRegexp translates a gitignore glob into a regular expression
+func globRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**") && i+2 == len(glob):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// loadIgnoreFile reads an ignore file; a missing file ignores nothing
+func loadIgnoreFile(path string) (ignoreRules, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	rules, err := parseIgnore(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// ignored reports whether rel, a slash-separated path relative to the
+// directory being copied, is ignored
+func (r ignoreRules) ignored(rel string, dir bool) bool {
+	ignored := false
+	for _, p := range r {
+		if p.dirOnly && !dir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// globalIgnorePath is the ignore file that applies to every mapped
+// directory: the one next to the config file
+func globalIgnorePath(configFile string) string {
+	if configFile == "" || isRemoteConfig(configFile) {
+		configFile = configPath()
+	}
+	return filepath.Join(filepath.Dir(configFile), ignoreFileName)
+}
+
+// directoryIgnore returns the rules for copying the directory at root: the
+// global ones followed by those in the directory's own ignore file
+func (ps *ProfileSync) directoryIgnore(root string) (ignoreRules, error) {
+	own, err := loadIgnoreFile(filepath.Join(root, ignoreFileName))
+	if err != nil {
+		return nil, err
+	}
+	return append(append(ignoreRules(nil), ps.ignore...), own...), nil
+}
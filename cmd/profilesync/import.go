@@ -0,0 +1,549 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// backupImporter finds user home directories inside a backup or export
+type backupImporter struct {
+	Name string
+
+	// Detect reports whether root looks like this kind of backup
+	Detect func(root string) bool
+
+	// Homes maps user names to their home directory inside root
+	Homes func(root string) (map[string]string, error)
+
+	// Rename, if set, maps a stored file name to its original name and a
+	// version key; the highest version of each name wins
+	Rename func(name string) (original, version string)
+}
+
+// fileHistoryVersion matches the timestamp Windows File History appends to every file
+var fileHistoryVersion = regexp.MustCompile(`^(.*) \((\d{4}_\d{2}_\d{2} \d{2}_\d{2}_\d{2}) UTC\)(\.[^.]*)?$`)
+
+// backupImporters in detection order; the plain directory layout comes last
+var backupImporters = []backupImporter{
+	{
+		Name:   "profilesync",
+		Detect: hasArchiveManifest,
+		Homes: func(root string) (map[string]string, error) {
+			data, err := os.ReadFile(filepath.Join(root, archiveManifestName))
+			if err != nil {
+				return nil, err
+			}
+			var manifest archiveManifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return nil, fmt.Errorf("%s: %w", archiveManifestName, err)
+			}
+			user := manifest.User
+			if user == "" {
+				user = manifest.Machine
+			}
+			return map[string]string{user: filepath.Join(root, "home")}, nil
+		},
+	},
+	{
+		Name: "timemachine",
+		Detect: func(root string) bool {
+			return isDir(filepath.Join(root, "Backups.backupdb")) || isDir(filepath.Join(root, "Latest"))
+		},
+		Homes: func(root string) (map[string]string, error) {
+			snapshot, err := latestTimeMachineSnapshot(root)
+			if err != nil {
+				return nil, err
+			}
+			homes := map[string]string{}
+			volumes, _ := os.ReadDir(snapshot)
+			for _, volume := range volumes {
+				for user, home := range usersUnder(filepath.Join(snapshot, volume.Name(), "Users")) {
+					homes[user] = home
+				}
+			}
+			return homes, nil
+		},
+	},
+	{
+		Name: "filehistory",
+		Detect: func(root string) bool {
+			return len(fileHistoryDataDirs(root)) > 0
+		},
+		Homes: func(root string) (map[string]string, error) {
+			homes := map[string]string{}
+			for _, data := range fileHistoryDataDirs(root) {
+				drives, _ := os.ReadDir(data)
+				for _, drive := range drives {
+					for user, home := range usersUnder(filepath.Join(data, drive.Name(), "Users")) {
+						homes[user] = home
+					}
+				}
+			}
+			return homes, nil
+		},
+		Rename: func(name string) (string, string) {
+			m := fileHistoryVersion.FindStringSubmatch(name)
+			if m == nil {
+				return name, ""
+			}
+			return m[1] + m[3], m[2]
+		},
+	},
+	{
+		Name: "usmt",
+		Detect: func(root string) bool {
+			return isDir(filepath.Join(root, "USMT", "File")) || isDir(filepath.Join(root, "File", "C$"))
+		},
+		Homes: func(root string) (map[string]string, error) {
+			store := filepath.Join(root, "USMT", "File")
+			if !isDir(store) {
+				store = filepath.Join(root, "File")
+			}
+			homes := map[string]string{}
+			drives, _ := os.ReadDir(store)
+			for _, drive := range drives {
+				for user, home := range usersUnder(filepath.Join(store, drive.Name(), "Users")) {
+					homes[user] = home
+				}
+			}
+			if len(homes) == 0 {
+				return nil, errors.New("no user profiles in USMT store (compressed .MIG stores must be restored with loadstate /nocompress first)")
+			}
+			return homes, nil
+		},
+	},
+	{
+		Name: "dir",
+		Detect: func(root string) bool {
+			return true
+		},
+		Homes: func(root string) (map[string]string, error) {
+			homes := map[string]string{}
+			for _, dir := range []string{"Users", "home", filepath.Join("C", "Users")} {
+				for user, home := range usersUnder(filepath.Join(root, dir)) {
+					homes[user] = home
+				}
+			}
+			// An export of a single home directory
+			if len(homes) == 0 {
+				homes[filepath.Base(filepath.Clean(root))] = root
+			}
+			return homes, nil
+		},
+	},
+}
+
+// excludedUsers are system profiles found next to real users
+var excludedUsers = map[string]bool{"Shared": true, "Public": true, "Default": true, "Default User": true, "All Users": true, "Guest": true}
+
+// usersUnder lists user home directories in a Users-style directory
+func usersUnder(dir string) map[string]string {
+	homes := map[string]string{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return homes
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !excludedUsers[entry.Name()] && !strings.HasPrefix(entry.Name(), ".") {
+			homes[entry.Name()] = filepath.Join(dir, entry.Name())
+		}
+	}
+	return homes
+}
+
+// latestTimeMachineSnapshot finds the newest snapshot of a Time Machine backup
+func latestTimeMachineSnapshot(root string) (string, error) {
+	if isDir(filepath.Join(root, "Latest")) {
+		return filepath.Join(root, "Latest"), nil
+	}
+
+	machines, err := os.ReadDir(filepath.Join(root, "Backups.backupdb"))
+	if err != nil {
+		return "", err
+	}
+	for _, machine := range machines {
+		base := filepath.Join(root, "Backups.backupdb", machine.Name())
+		if isDir(filepath.Join(base, "Latest")) {
+			return filepath.Join(base, "Latest"), nil
+		}
+		// Snapshot directories are named by date, so the last one is newest
+		snapshots, err := os.ReadDir(base)
+		if err != nil || len(snapshots) == 0 {
+			continue
+		}
+		return filepath.Join(base, snapshots[len(snapshots)-1].Name()), nil
+	}
+	return "", errors.New("no Time Machine snapshots found")
+}
+
+// fileHistoryDataDirs returns the Data directories of a File History target
+// (FileHistory/<user>/<machine>/Data or any level below it)
+func fileHistoryDataDirs(root string) []string {
+	var dirs []string
+	for _, pattern := range []string{"Data", "*/Data", "*/*/Data", "FileHistory/*/*/Data"} {
+		matches, _ := filepath.Glob(filepath.Join(root, pattern))
+		for _, m := range matches {
+			if isDir(m) && isDir(filepath.Join(filepath.Dir(m), "Configuration")) {
+				dirs = append(dirs, m)
+			}
+		}
+	}
+	return dirs
+}
+
+// hasArchiveManifest reports whether root is an unpacked `export archive`
+func hasArchiveManifest(root string) bool {
+	_, err := os.Stat(filepath.Join(root, archiveManifestName))
+	return err == nil
+}
+
+// isDir reports whether path is an existing directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// ImportBackup ingests a backup, export or archive at path into dest,
+// returning the importer used and the user imported. An archive is passed
+// to verify once unpacked, with whether it is an exported profilesync
+// archive, and not imported when verify fails
+func ImportBackup(path, dest, format, user string, verify func(exported bool) error) (string, string, error) {
+	root := path
+	if isArchive(path) {
+		tmp, err := os.MkdirTemp("", "profilesync-import-")
+		if err != nil {
+			return "", "", err
+		}
+		defer os.RemoveAll(tmp)
+
+		noticeColor.Printf("📦 Unpacking %s...\n", filepath.Base(path))
+		if err := extractArchive(path, tmp); err != nil {
+			return "", "", fmt.Errorf("unpacking %s: %w", path, err)
+		}
+		root = singleChild(tmp)
+		// Decided on what was unpacked, as detection below does, so moving
+		// the manifest or repacking the archive does not avoid a signature
+		if err := verify(hasArchiveManifest(root)); err != nil {
+			return "", "", err
+		}
+	}
+
+	var imp *backupImporter
+	for i := range backupImporters {
+		candidate := &backupImporters[i]
+		if (format == "auto" && candidate.Detect(root)) || candidate.Name == format {
+			imp = candidate
+			break
+		}
+	}
+	if imp == nil {
+		return "", "", fmt.Errorf("unknown import format %q", format)
+	}
+
+	homes, err := imp.Homes(root)
+	if err != nil {
+		return imp.Name, "", err
+	}
+	home, user, err := pickHome(homes, user)
+	if err != nil {
+		return imp.Name, "", err
+	}
+
+	noticeColor.Printf("📥 Importing %s's home from %s backup\n", user, imp.Name)
+	return imp.Name, user, importTree(home, dest, imp.Rename)
+}
+
+// pickHome chooses which user's home to import
+func pickHome(homes map[string]string, user string) (string, string, error) {
+	if user != "" {
+		home, ok := homes[user]
+		if !ok {
+			return "", "", fmt.Errorf("user %q not found in backup", user)
+		}
+		return home, user, nil
+	}
+	if len(homes) == 1 {
+		for u, home := range homes {
+			return home, u, nil
+		}
+	}
+
+	var users []string
+	for u := range homes {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	if len(users) == 0 {
+		return "", "", errors.New("no user home directories found in backup")
+	}
+	return "", "", fmt.Errorf("backup contains several users (%s); choose one with --user", strings.Join(users, ", "))
+}
+
+// importTree copies a home directory into dest, undoing per-file renames
+// made by the backup tool and keeping the newest version of each file
+func importTree(src, dest string, rename func(string) (string, string)) error {
+	versions := map[string]string{}
+
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		if portable, _ := classifyAppData(rel); !portable {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		version := ""
+		if rename != nil && !d.IsDir() {
+			var name string
+			name, version = rename(d.Name())
+			rel = filepath.Join(filepath.Dir(rel), name)
+			if prev, seen := versions[rel]; seen && prev >= version {
+				return nil
+			}
+			versions[rel] = version
+		}
+
+		target, err := secureJoin(dest, rel)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return copyPlainFile(path, target, info.Mode().Perm())
+	})
+}
+
+// copyPlainFile copies one file, creating its parent directory
+func copyPlainFile(src, dst string, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// isArchive reports whether path is an archive ImportBackup can unpack
+func isArchive(path string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return !isDir(path)
+		}
+	}
+	return false
+}
+
+// extractArchive unpacks a zip or (gzipped) tar archive into dest,
+// refusing entries that would escape it
+func extractArchive(path, dest string) error {
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		return extractZip(path, dest)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if lower := strings.ToLower(path); strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return extractTar(r, dest)
+}
+
+// extractTar unpacks a tar stream into dest, refusing entries that would
+// escape it
+func extractTar(r io.Reader, dest string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := secureJoin(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveEntry(target, tr, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extractZip unpacks a zip archive into dest
+func extractZip(path, dest string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		target, err := secureJoin(dest, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeArchiveEntry(target, rc, f.Mode().Perm())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeArchiveEntry writes one extracted file
+func writeArchiveEntry(target string, r io.Reader, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// singleChild returns the only directory inside dir, if that is all dir
+// holds, so archives wrapping everything in a top-level folder are handled
+func singleChild(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return dir
+	}
+	// Keep Users/home so the directory layout is still recognised
+	if entries[0].Name() == "Users" || entries[0].Name() == "home" {
+		return dir
+	}
+	return filepath.Join(dir, entries[0].Name())
+}
+
+// errUntrustedArchive is returned when import refuses an archive for its
+// signature, after saying why
+var errUntrustedArchive = errors.New("archive not trusted")
+
+// runImport handles `profilesync import PATH`
+func runImport(args []string) {
+	if len(args) > 0 {
+		if tool, ok := dotfileTools[args[0]]; ok && tool.importFrom != nil {
+			runImportTool(args[0], tool, args[1:])
+			return
+		}
+	}
+	flags := newFlagSet("import", "import [flags] PATH")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to import into")
+	format := flags.String("format", "auto", "Backup format (auto, profilesync, timemachine, filehistory, usmt, dir)")
+	user := flags.String("user", "", "User whose home to import when the backup holds several")
+	allowUntrusted := flags.Bool("allow-untrusted", false, "Import an exported archive that is not signed by a trusted key")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	path := flags.Arg(0)
+	verify := func(exported bool) error {
+		signer, untrusted, err := checkArchiveSignature(path, exported)
+		switch {
+		case err != nil:
+			errorColor.Printf("❌ Refusing %s: %v\n", path, err)
+			return errUntrustedArchive
+		case untrusted != "" && !*allowUntrusted:
+			errorColor.Printf("❌ Refusing %s: %s\n", path, untrusted)
+			errorColor.Println("Trust its signer with `profilesync trust add NAME KEY`, or import it anyway with --allow-untrusted")
+			return errUntrustedArchive
+		case untrusted != "":
+			warnColor.Printf("⚠️  Importing %s although %s\n", path, untrusted)
+		case signer != "":
+			successColor.Printf("🔏 Signed by %s\n", signer)
+		}
+		return nil
+	}
+
+	dest := filepath.Join(*dir, "home")
+	kind, who, err := ImportBackup(path, dest, *format, *user, verify)
+	if errors.Is(err, errUntrustedArchive) {
+		os.Exit(1)
+	}
+	if err != nil {
+		errorColor.Println("❌ Error importing backup:", err)
+		os.Exit(1)
+	}
+
+	successColor.Printf("✅ Imported %s's home (%s) into %s\n", who, kind, dest)
+	reportStoreDedup(*dir)
+	noticeColor.Printf("Run `profilesync apply --source-dir %s` to migrate it\n", dest)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archiveEntry is a file or directory of a test archive
+type archiveEntry struct {
+	name, data string
+	dir        bool
+}
+
+// archiveTests are the entries of archives extracting must accept or refuse
+var archiveTests = []struct {
+	name    string
+	entries []archiveEntry
+	escapes bool
+	want    []string // files extracted, relative to dest
+}{
+	{"files and directories", []archiveEntry{{name: "home/", dir: true}, {name: "home/.bashrc", data: "x"}, {name: "home/.config/nvim/init.lua", data: "y"}}, false, []string{"home/.bashrc", "home/.config/nvim/init.lua"}},
+	{"dot segments that stay inside", []archiveEntry{{name: "a/../b/file", data: "x"}}, false, []string{"b/file"}},
+	{"parent", []archiveEntry{{name: "../evil", data: "x"}}, true, nil},
+	{"climbing out after a segment", []archiveEntry{{name: "home/../../evil", data: "x"}}, true, nil},
+	{"absolute", []archiveEntry{{name: "/tmp/evil", data: "x"}}, true, nil},
+	{"directory outside", []archiveEntry{{name: "../evil/", dir: true}}, true, nil},
+	{"after good entries", []archiveEntry{{name: "ok", data: "x"}, {name: "../../evil", data: "x"}}, true, []string{"ok"}},
+}
+
+func buildTar(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}
+		if e.dir {
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		hdr.SetMode(0644)
+		if e.dir {
+			hdr.SetMode(os.ModeDir | 0755)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkExtracted compares what extracting put below parent/dest with want,
+// and makes sure nothing landed next to dest
+func checkExtracted(t *testing.T, parent string, err error, escapes bool, want []string) {
+	t.Helper()
+	if escapes != errors.Is(err, errPathEscapesRoot) {
+		t.Fatalf("err = %v, want escaping %v", err, escapes)
+	}
+	if !escapes && err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	filepath.WalkDir(parent, func(p string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(filepath.Join(parent, "dest"), p)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if len(got) != len(want) {
+		t.Fatalf("extracted %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("extracted %q, want %q", got, want)
+		}
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	for _, tt := range archiveTests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			err := extractTar(bytes.NewReader(buildTar(t, tt.entries)), filepath.Join(parent, "dest"))
+			checkExtracted(t, parent, err, tt.escapes, tt.want)
+		})
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	for _, tt := range archiveTests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			err := extractZip(buildZip(t, tt.entries), filepath.Join(parent, "dest"))
+			checkExtracted(t, parent, err, tt.escapes, tt.want)
+		})
+	}
+}
+
+func TestExtractTarSymlinkedDirectory(t *testing.T) {
+	parent := t.TempDir()
+	dest := filepath.Join(parent, "dest")
+	outside := filepath.Join(parent, "outside")
+	for _, dir := range []string{dest, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(dest, "link")); err != nil {
+		t.Fatal(err)
+	}
+	err := extractTar(bytes.NewReader(buildTar(t, []archiveEntry{{name: "link/evil", data: "x"}})), dest)
+	if !errors.Is(err, errPathEscapesRoot) {
+		t.Fatalf("err = %v, want errPathEscapesRoot", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file written through the symlink: %v", err)
+	}
+}
+
+func TestImportBackupVerifiesUnpacked(t *testing.T) {
+	manifest := archiveEntry{name: archiveManifestName, data: `{"user":"me"}`}
+	bashrc := archiveEntry{name: "home/.bashrc", data: "x"}
+	tarFile := func(t *testing.T, entries []archiveEntry) string {
+		path := filepath.Join(t.TempDir(), "test.tar")
+		if err := os.WriteFile(path, buildTar(t, entries), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name     string
+		archive  func(t *testing.T) string
+		exported bool
+	}{
+		{"manifest first", func(t *testing.T) string { return tarFile(t, []archiveEntry{manifest, bashrc}) }, true},
+		// Moving the manifest or repacking must not avoid the signature
+		{"manifest last", func(t *testing.T) string { return tarFile(t, []archiveEntry{bashrc, manifest}) }, true},
+		{"zip", func(t *testing.T) string { return buildZip(t, []archiveEntry{bashrc, manifest}) }, true},
+		{"wrapped in a folder", func(t *testing.T) string {
+			return buildZip(t, []archiveEntry{{name: "export/home/.bashrc", data: "x"}, {name: "export/" + archiveManifestName, data: manifest.data}})
+		}, true},
+		{"other backup", func(t *testing.T) string { return tarFile(t, []archiveEntry{bashrc}) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "home")
+			var exported *bool
+			_, _, err := ImportBackup(tt.archive(t), dest, "auto", "", func(e bool) error {
+				exported = &e
+				return errUntrustedArchive
+			})
+			if exported == nil || *exported != tt.exported {
+				t.Fatalf("verify called with %v, want %v", exported, tt.exported)
+			}
+			if !errors.Is(err, errUntrustedArchive) {
+				t.Errorf("err = %v, want errUntrustedArchive", err)
+			}
+			if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("refused archive imported: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+)
+
+// iniSection is one [section] of an ini file, keeping key order. Comment
+// lines are kept in Keys as-is so rewritten files keep them
+type iniSection struct {
+	Name string
+	Keys []string
+	Vals map[string]string
+}
+
+// newINISection returns an empty section
+func newINISection(name string) *iniSection {
+	return &iniSection{Name: name, Vals: map[string]string{}}
+}
+
+// Get returns the value of key, or "" when unset
+func (s *iniSection) Get(key string) string {
+	return s.Vals[key]
+}
+
+// Set assigns key, appending it when new
+func (s *iniSection) Set(key, value string) {
+	if _, ok := s.Vals[key]; !ok {
+		s.Keys = append(s.Keys, key)
+	}
+	s.Vals[key] = value
+}
+
+// Delete removes key
+func (s *iniSection) Delete(key string) {
+	if _, ok := s.Vals[key]; !ok {
+		return
+	}
+	delete(s.Vals, key)
+	for i, k := range s.Keys {
+		if k == key {
+			s.Keys = append(s.Keys[:i], s.Keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// isINIComment reports whether a line or stored key is a comment
+func isINIComment(line string) bool {
+	return strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#")
+}
+
+// parseINI reads the simple key=value format used by Firefox and the AWS
+// CLI. Indented lines after a key with no value continue it, as in AWS's
+// nested settings. Comments before the first section go in a section named ""
+func parseINI(data []byte) []*iniSection {
+	var sections []*iniSection
+	var current *iniSection
+	lastKey := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		raw := strings.TrimRight(scanner.Text(), "\r \t")
+		line := strings.TrimSpace(raw)
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			current = newINISection(strings.TrimSpace(line[1 : len(line)-1]))
+			sections = append(sections, current)
+			lastKey = ""
+		case isINIComment(line):
+			if current == nil {
+				current = newINISection("")
+				sections = append(sections, current)
+			}
+			current.Set(line, "")
+		case current == nil:
+		case raw != line && lastKey != "" && (current.Vals[lastKey] == "" || strings.HasPrefix(current.Vals[lastKey], "\n")):
+			current.Vals[lastKey] += "\n" + raw
+		default:
+			if key, value, ok := strings.Cut(line, "="); ok {
+				lastKey = strings.TrimSpace(key)
+				current.Set(lastKey, strings.TrimSpace(value))
+			}
+		}
+	}
+	return sections
+}
+
+// formatINI writes sections back out, separating keys from values with sep
+func formatINI(sections []*iniSection, sep, eol string) []byte {
+	var b strings.Builder
+	for i, s := range sections {
+		if i > 0 {
+			b.WriteString(eol)
+		}
+		if s.Name != "" {
+			b.WriteString("[" + s.Name + "]" + eol)
+		}
+		for _, k := range s.Keys {
+			if isINIComment(k) {
+				b.WriteString(k + eol)
+				continue
+			}
+			value := strings.ReplaceAll(s.Vals[k], "\n", eol)
+			if value == "" || strings.HasPrefix(value, eol) {
+				b.WriteString(strings.TrimRight(k+sep, " ") + value + eol)
+				continue
+			}
+			b.WriteString(k + sep + value + eol)
+		}
+	}
+	return []byte(b.String())
+}
+
+// lineEnding returns the conventional line ending for a platform
+func lineEnding(platform string) string {
+	if platform == "windows" {
+		return "\r\n"
+	}
+	return "\n"
+}
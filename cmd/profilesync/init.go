@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Where the wizard can put the profile store
+const (
+	storeLocal = "local"
+	storeGit   = "git"
+	storeCloud = "cloud"
+)
+
+// detectedConfig is a known config found on this machine
+type detectedConfig struct {
+	entry CatalogEntry
+	path  string
+}
+
+// detectConfigs returns the known configs present in home, by category
+func detectConfigs(home, platform string) map[string][]detectedConfig {
+	found := map[string][]detectedConfig{}
+	for _, e := range catalogEntries() {
+		path, err := resolveMappingPath(home, catalogPath(e.Mapping, platform), platform)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		category := e.Type
+		if category == "" {
+			category = "Other"
+		}
+		found[category] = append(found[category], detectedConfig{entry: e, path: path})
+	}
+	return found
+}
+
+// cloudFolders returns the folders of file sync clients in home, by client
+func cloudFolders(home, platform string) map[string]string {
+	candidates := map[string][]string{
+		"Dropbox":      {filepath.Join(home, "Dropbox")},
+		"Google Drive": {filepath.Join(home, "Google Drive", "My Drive"), filepath.Join(home, "My Drive")},
+		"OneDrive":     {os.Getenv("OneDrive"), filepath.Join(home, "OneDrive")},
+	}
+	if platform == "macos" {
+		candidates["iCloud Drive"] = []string{filepath.Join(home, "Library", "Mobile Documents", "com~apple~CloudDocs")}
+	}
+	found := map[string]string{}
+	for client, dirs := range candidates {
+		for _, dir := range dirs {
+			if info, err := os.Stat(dir); dir != "" && err == nil && info.IsDir() {
+				found[client] = dir
+				break
+			}
+		}
+	}
+	return found
+}
+
+// initConfig is the config file the wizard writes
+type initConfig struct {
+	Defaults *bool              `yaml:"defaults,omitempty"`
+	Mappings map[string]*string `yaml:"mappings,omitempty"`
+	Policies map[string]any     `yaml:"policies,omitempty"`
+}
+
+// chooseStore asks where the profile store should live, returning its
+// kind and directory, and the git remote if any
+func chooseStore(home, platform, def string) (kind, dir, remote string) {
+	clouds := cloudFolders(home, platform)
+	choices := "[l]ocal directory, [g]it repository"
+	if len(clouds) > 0 {
+		choices += " or [c]loud folder (" + strings.Join(sortedKeys(clouds), ", ") + ")"
+	}
+	for kind == "" {
+		switch strings.ToLower(ask("Where should the profile store live? "+choices+":", "l")) {
+		case "l", "local":
+			kind = storeLocal
+		case "g", "git":
+			kind = storeGit
+		case "c", "cloud":
+			if len(clouds) > 0 {
+				kind = storeCloud
+			}
+		}
+	}
+
+	switch kind {
+	case storeGit:
+		remote = ask("Git remote to clone, or empty for a new repository:", "")
+	case storeCloud:
+		def = filepath.Join(clouds[sortedKeys(clouds)[0]], "profilesync")
+	}
+	dir = ask("Profile store directory:", def)
+	if strings.HasPrefix(dir, "~/") {
+		dir = filepath.Join(home, dir[2:])
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return kind, dir, remote
+}
+
+// setupGitStore clones remote into dir, or makes dir a new repository with
+// remote as its origin
+func setupGitStore(dir, remote string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		noticeColor.Printf("ℹ️  %s is already a git repository\n", dir)
+		return nil
+	}
+	entries, _ := os.ReadDir(dir)
+	if remote != "" && len(entries) == 0 {
+		if out, err := exec.Command("git", "clone", remote, dir).CombinedOutput(); err != nil {
+			return fmt.Errorf("git clone: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		successColor.Printf("✅ Cloned %s into %s\n", remote, dir)
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	commands := [][]string{{"init", "--quiet"}}
+	if remote != "" {
+		commands = append(commands, []string{"remote", "add", "origin", remote})
+	}
+	for _, args := range commands {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+	}
+	successColor.Printf("✅ Created a git repository in %s\n", dir)
+	return nil
+}
+
+// commitGitStore commits everything in the store, if anything changed
+func commitGitStore(dir string) error {
+	out, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+	if err != nil || len(out) == 0 {
+		return err
+	}
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "--quiet", "-m", "Add configs from " + machineName()}} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+	}
+	successColor.Printf("✅ Committed the configs in %s\n", dir)
+	return nil
+}
+
+// runInit handles `profilesync init`, a first-run wizard that writes the
+// initial config
+func runInit(args []string) {
+	flags := newFlagSet("init", "init [flags]")
+	configFile := flags.String("config", configPath(), "Config file to write")
+	dir := flags.String("profile-dir", profileDir(), "Default profile store directory")
+	force := flags.Bool("force", false, "Replace an existing config file")
+	yes := flags.Bool("yes", false, "Accept every default without asking")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if _, err := os.Stat(*configFile); err == nil && !*force {
+		errorColor.Printf("❌ %s already exists (use --force to replace it)\n", *configFile)
+		os.Exit(1)
+	}
+	if *yes {
+		stdin.Reset(strings.NewReader(""))
+	}
+
+	platform := DetectPlatform()
+	home := GetHomeDir(platform)
+	infoColor.Printf("🖥️  Platform: %s, home directory %s\n", platform, home)
+
+	found := detectConfigs(home, platform)
+	if len(found) == 0 {
+		warnColor.Println("⚠️  No known configs found in your home directory")
+	} else {
+		infoColor.Println("🔍 Known configs found:")
+	}
+	for _, category := range sortedKeys(found) {
+		var names []string
+		for _, c := range found[category] {
+			names = append(names, c.entry.Description)
+		}
+		fmt.Printf("   %-16s %s\n", category, strings.Join(names, ", "))
+	}
+	fmt.Println()
+
+	// Secrets are only managed when asked for, whatever their category
+	var selected, secrets []detectedConfig
+	for _, category := range sortedKeys(found) {
+		var configs []detectedConfig
+		for _, c := range found[category] {
+			if catalogSensitivity(c.entry.Mapping) == sensitivitySecret {
+				secrets = append(secrets, c)
+			} else {
+				configs = append(configs, c)
+			}
+		}
+		if len(configs) > 0 && confirm(fmt.Sprintf("Manage %s settings (%d found)?", category, len(configs)), true) {
+			selected = append(selected, configs...)
+		}
+	}
+	if len(secrets) > 0 {
+		var names []string
+		for _, c := range secrets {
+			names = append(names, c.entry.Description)
+		}
+		if confirm("Manage secrets too ("+strings.Join(names, ", ")+")?", false) {
+			selected = append(selected, secrets...)
+		}
+	}
+
+	kind, store, remote := chooseStore(home, platform, *dir)
+	if kind == storeGit {
+		if err := setupGitStore(store, remote); err != nil {
+			errorColor.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+	}
+	storeHome := filepath.Join(store, "home")
+
+	defaults := false
+	cfg := initConfig{
+		Defaults: &defaults,
+		Mappings: map[string]*string{},
+		Policies: map[string]any{"source-dir": storeHome},
+	}
+	for _, c := range selected {
+		dst := c.entry.Mapping
+		if c.entry.Destination != "" {
+			dst = c.entry.Destination
+		}
+		cfg.Mappings[c.entry.Mapping] = &dst
+	}
+	out, err := yaml.Marshal(cfg)
+	if err == nil {
+		header := fmt.Sprintf("# Written by `profilesync init` on %s (%s).\n# Profile store: %s (%s)\n\n", machineName(), platform, store, kind)
+		out = append([]byte(header), out...)
+		if err = os.MkdirAll(filepath.Dir(*configFile), 0755); err == nil {
+			err = os.WriteFile(*configFile, out, 0644)
+		}
+	}
+	if err == nil {
+		// What the wizard wrote must load, or every later command fails
+		_, err = loadConfig(*configFile, "")
+	}
+	if err != nil {
+		errorColor.Printf("❌ Writing %s: %v\n", *configFile, err)
+		os.Exit(1)
+	}
+	successColor.Printf("✅ Wrote %s with %d mappings\n", *configFile, len(cfg.Mappings))
+
+	if len(selected) > 0 && confirm("Copy the selected configs into the profile store now?", true) {
+		copied := 0
+		for _, c := range selected {
+			stored, err := resolveMappingPath(storeHome, c.entry.Mapping, platform)
+			if err != nil {
+				errorColor.Printf("❌ %s: %v\n", c.entry.Mapping, err)
+				continue
+			}
+			if _, err := os.Lstat(stored); err == nil {
+				noticeColor.Printf("⏭️  %s is already in the profile store\n", c.entry.Description)
+				continue
+			}
+			if err := copyAdopted(c.path, stored); err != nil {
+				errorColor.Printf("❌ %s: %v\n", c.entry.Description, err)
+				continue
+			}
+			copied++
+		}
+		successColor.Printf("✅ Copied %d configs into %s\n", copied, storeHome)
+		if kind == storeGit {
+			if err := commitGitStore(store); err != nil {
+				warnColor.Printf("⚠️  Could not commit the profile store: %v\n", err)
+			}
+		}
+	}
+
+	fmt.Println()
+	if store != profileDir() {
+		noticeColor.Printf("Set PROFILESYNC_PROFILE_DIR=%s so other commands use this profile store\n", store)
+	}
+	if kind == storeCloud {
+		noticeColor.Println("The store syncs through your cloud folder; on another machine, run `profilesync init` and pick the same folder")
+	}
+	noticeColor.Println("Run `profilesync apply` to preview deploying the profile store, or `profilesync adopt PATH` to add more configs")
+}
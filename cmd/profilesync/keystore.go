@@ -0,0 +1,16 @@
+package main
+
+import "errors"
+
+// keystoreService namespaces profilesync's entries in the OS keystore
+const keystoreService = "profilesync"
+
+// errNoKeystore is returned when this machine has no usable OS keystore
+var errNoKeystore = errors.New("no OS keystore available")
+
+// errSecretNotFound is returned when the keystore has no entry for a name
+var errSecretNotFound = errors.New("secret not found in OS keystore")
+
+// errSecretExists is returned when adding a secret under a name that
+// already has one
+var errSecretExists = errors.New("secret already in OS keystore")
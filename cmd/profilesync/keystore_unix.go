@@ -0,0 +1,145 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// securityNotFound is the exit status of security(1) when no keychain item
+// matches; any other failure, such as a locked keychain or a dismissed
+// prompt, is an error
+const securityNotFound = 44
+
+// keystoreGet reads a secret from the macOS Keychain or the Secret Service (libsecret)
+func keystoreGet(name string) (string, error) {
+	var cmd *exec.Cmd
+	darwin := runtime.GOOS == "darwin"
+	switch {
+	case darwin:
+		cmd = exec.Command("security", "find-generic-password", "-s", keystoreService, "-a", name, "-w")
+	case hasCommand("secret-tool"):
+		cmd = exec.Command("secret-tool", "lookup", "service", keystoreService, "account", name)
+	default:
+		return "", errNoKeystore
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	secret := strings.TrimRight(string(out), "\r\n")
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil && secret != "":
+		return secret, nil
+	case err == nil:
+		return "", errSecretNotFound
+	case errors.As(err, &exitErr) && darwin && exitErr.ExitCode() == securityNotFound:
+		return "", errSecretNotFound
+	case errors.As(err, &exitErr) && !darwin && exitErr.ExitCode() == 1 && secret == "" && strings.TrimSpace(stderr.String()) == "":
+		// secret-tool exits 1 without a word when nothing matches
+		return "", errSecretNotFound
+	}
+	return "", keystoreError(err, stderr.String())
+}
+
+// keystoreSet stores a secret in the macOS Keychain or the Secret Service
+// (libsecret), replacing the one stored under name
+func keystoreSet(name, secret string) error {
+	return keystoreStore(name, secret, true)
+}
+
+// keystoreAdd stores a secret like keystoreSet, but fails with
+// errSecretExists rather than replace one already stored under name
+func keystoreAdd(name, secret string) error {
+	return keystoreStore(name, secret, false)
+}
+
+// keystoreStore stores a secret, replacing an existing one only when
+// replace is set. The secret goes to the keystore's stdin, never on a
+// command line where ps would show it
+func keystoreStore(name, secret string, replace bool) error {
+	if !replace {
+		switch _, err := keystoreGet(name); {
+		case err == nil:
+			return errSecretExists
+		case !errors.Is(err, errSecretNotFound):
+			return err
+		}
+	}
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "darwin":
+		if strings.ContainsAny(secret, "\r\n") {
+			return errors.New("secrets for the macOS Keychain cannot contain line breaks")
+		}
+		// security -i reads commands from stdin
+		update := ""
+		if replace {
+			update = "-U "
+		}
+		quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password %s-s \"%s\" -a \"%s\" -w \"%s\"\n", update, quote.Replace(keystoreService), quote.Replace(name), quote.Replace(secret)))
+	case hasCommand("secret-tool"):
+		cmd = exec.Command("secret-tool", "store", "--label=profilesync "+name, "service", keystoreService, "account", name)
+		cmd.Stdin = bytes.NewBufferString(secret)
+	default:
+		return errNoKeystore
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return keystoreError(err, stderr.String())
+	}
+	// security -i does not fail when a command does, so read it back
+	if stored, err := keystoreGet(name); err != nil {
+		return err
+	} else if stored != secret {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+		return errors.New("the keystore did not keep the secret")
+	}
+	return nil
+}
+
+// keystoreError adds what the keystore tool printed to err
+func keystoreError(err error, stderr string) error {
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+// keystoreDelete removes a secret from the macOS Keychain or the Secret Service (libsecret)
+func keystoreDelete(name string) error {
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "darwin":
+		cmd = exec.Command("security", "delete-generic-password", "-s", keystoreService, "-a", name)
+	case hasCommand("secret-tool"):
+		cmd = exec.Command("secret-tool", "clear", "service", keystoreService, "account", name)
+	default:
+		return errNoKeystore
+	}
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return errSecretNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// hasCommand reports whether a program is on PATH
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
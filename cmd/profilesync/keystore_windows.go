@@ -0,0 +1,85 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// keystorePath is where a DPAPI-protected secret is kept; only the current
+// Windows user can unprotect it. Names such as token:host are escaped to
+// make valid file names
+func keystorePath(name string) string {
+	name = strings.NewReplacer("%", "%25", ":", "%3A", "/", "%2F", `\`, "%5C").Replace(name)
+	return filepath.Join(stateDir(), "keys", name+".dpapi")
+}
+
+// keystoreGet reads a secret protected with the Windows Data Protection API
+func keystoreGet(name string) (string, error) {
+	sealed, err := os.ReadFile(keystorePath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", errSecretNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) == 0 {
+		return "", fmt.Errorf("%s is empty", keystorePath(name))
+	}
+
+	in := windows.DataBlob{Size: uint32(len(sealed)), Data: &sealed[0]}
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return "", err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+
+	return string(unsafe.Slice(out.Data, out.Size)), nil
+}
+
+// keystoreAdd protects a secret like keystoreSet, but fails with
+// errSecretExists rather than replace one already stored under name
+func keystoreAdd(name, secret string) error {
+	if _, err := os.Lstat(keystorePath(name)); err == nil {
+		return errSecretExists
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return keystoreSet(name, secret)
+}
+
+// keystoreSet protects a secret with the Windows Data Protection API
+func keystoreSet(name, secret string) error {
+	if secret == "" {
+		return errors.New("empty secret")
+	}
+	data := []byte(secret)
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+
+	path := keystorePath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, unsafe.Slice(out.Data, out.Size), 0600)
+}
+
+// keystoreDelete removes a DPAPI-protected secret
+func keystoreDelete(name string) error {
+	err := os.Remove(keystorePath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return errSecretNotFound
+	}
+	return err
+}
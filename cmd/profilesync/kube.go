@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kubeConfig is the part of a kubeconfig the merger needs; anything else is
+// carried through untouched
+type kubeConfig struct {
+	APIVersion     string                 `yaml:"apiVersion,omitempty"`
+	Kind           string                 `yaml:"kind,omitempty"`
+	Clusters       []kubeNamed            `yaml:"clusters"`
+	Users          []kubeNamed            `yaml:"users"`
+	Contexts       []kubeNamed            `yaml:"contexts"`
+	CurrentContext string                 `yaml:"current-context"`
+	Rest           map[string]interface{} `yaml:",inline"`
+}
+
+// kubeNamed is one named cluster, user or context entry
+type kubeNamed struct {
+	Name string                 `yaml:"name"`
+	Rest map[string]interface{} `yaml:",inline"`
+}
+
+// kubeContextRef is the cluster and user a context points at
+type kubeContextRef struct {
+	Cluster string `yaml:"cluster"`
+	User    string `yaml:"user"`
+}
+
+// loadKubeconfig parses a kubeconfig file; a missing file is an empty config
+func loadKubeconfig(path string) (*kubeConfig, error) {
+	cfg := &kubeConfig{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// findNamed returns the entry called name, or nil
+func findNamed(entries []kubeNamed, name string) *kubeNamed {
+	for i := range entries {
+		if entries[i].Name == name {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// ref returns the cluster and user a context entry points at
+func (n kubeNamed) ref() kubeContextRef {
+	var ref kubeContextRef
+	if ctx, ok := n.Rest["context"].(map[string]interface{}); ok {
+		ref.Cluster, _ = ctx["cluster"].(string)
+		ref.User, _ = ctx["user"].(string)
+	}
+	return ref
+}
+
+// setRef points a context entry at a cluster and user
+func (n *kubeNamed) setRef(ref kubeContextRef) {
+	ctx, ok := n.Rest["context"].(map[string]interface{})
+	if !ok {
+		ctx = map[string]interface{}{}
+		n.Rest["context"] = ctx
+	}
+	ctx["cluster"] = ref.Cluster
+	ctx["user"] = ref.User
+}
+
+// addNamed adds entry to *entries. An identical entry with the same name is
+// reused; a different one is kept and the new entry is renamed with a
+// "-migrated" suffix. It returns the name the entry ended up with
+func addNamed(entries *[]kubeNamed, entry kubeNamed) (name string, renamed bool) {
+	base := entry.Name
+	for i := 1; ; i++ {
+		existing := findNamed(*entries, entry.Name)
+		if existing == nil {
+			*entries = append(*entries, entry)
+			return entry.Name, entry.Name != base
+		}
+		if reflect.DeepEqual(existing.Rest, entry.Rest) {
+			return entry.Name, entry.Name != base
+		}
+		entry.Name = base + "-migrated"
+		if i > 1 {
+			entry.Name = fmt.Sprintf("%s-migrated-%d", base, i)
+		}
+	}
+}
+
+// mergeKubeconfig brings the chosen contexts of src, with the clusters and
+// users they reference, into dst. Name collisions with different content
+// are resolved by renaming the incoming entry. It returns a note per rename
+func mergeKubeconfig(dst, src *kubeConfig, contexts []string) ([]string, error) {
+	var notes []string
+	clusterNames := map[string]string{}
+	userNames := map[string]string{}
+
+	for _, name := range contexts {
+		ctx := findNamed(src.Contexts, name)
+		if ctx == nil {
+			return notes, fmt.Errorf("context %q not found in source kubeconfig", name)
+		}
+		ref := ctx.ref()
+
+		if _, done := clusterNames[ref.Cluster]; !done && ref.Cluster != "" {
+			cluster := findNamed(src.Clusters, ref.Cluster)
+			if cluster == nil {
+				return notes, fmt.Errorf("context %q refers to missing cluster %q", name, ref.Cluster)
+			}
+			newName, renamed := addNamed(&dst.Clusters, *cluster)
+			clusterNames[ref.Cluster] = newName
+			if renamed {
+				notes = append(notes, fmt.Sprintf("cluster %s → %s", ref.Cluster, newName))
+			}
+		}
+		if _, done := userNames[ref.User]; !done && ref.User != "" {
+			user := findNamed(src.Users, ref.User)
+			if user == nil {
+				return notes, fmt.Errorf("context %q refers to missing user %q", name, ref.User)
+			}
+			newName, renamed := addNamed(&dst.Users, *user)
+			userNames[ref.User] = newName
+			if renamed {
+				notes = append(notes, fmt.Sprintf("user %s → %s", ref.User, newName))
+			}
+		}
+
+		merged := kubeNamed{Name: ctx.Name, Rest: map[string]interface{}{}}
+		for k, v := range ctx.Rest {
+			merged.Rest[k] = v
+		}
+		if inner, ok := ctx.Rest["context"].(map[string]interface{}); ok {
+			copied := map[string]interface{}{}
+			for k, v := range inner {
+				copied[k] = v
+			}
+			merged.Rest["context"] = copied
+		}
+		merged.setRef(kubeContextRef{Cluster: clusterNames[ref.Cluster], User: userNames[ref.User]})
+
+		newName, renamed := addNamed(&dst.Contexts, merged)
+		if renamed {
+			notes = append(notes, fmt.Sprintf("context %s → %s", name, newName))
+		}
+	}
+
+	if dst.APIVersion == "" {
+		dst.APIVersion = "v1"
+		dst.Kind = "Config"
+	}
+	if dst.CurrentContext == "" && src.CurrentContext != "" && findNamed(dst.Contexts, src.CurrentContext) != nil {
+		dst.CurrentContext = src.CurrentContext
+	}
+	return notes, nil
+}
+
+// chooseKubeContexts returns the source contexts to bring over: those named
+// with --kube-contexts, else the user's picks when we can ask, else all
+func (ps *ProfileSync) chooseKubeContexts(src *kubeConfig) []string {
+	var all []string
+	for _, c := range src.Contexts {
+		all = append(all, c.Name)
+	}
+	sort.Strings(all)
+
+	if ps.kubeContexts != nil {
+		return ps.kubeContexts
+	}
+	var chosen []string
+	for _, name := range all {
+		if ps.decide(decisionKubeContext, name, fmt.Sprintf("   Bring over kube context %s?", name), true) {
+			chosen = append(chosen, name)
+		}
+	}
+	return chosen
+}
+
+// copyKubeconfig merges the source kubeconfig into the destination instead
+// of replacing it
+func (ps *ProfileSync) copyKubeconfig(ctx context.Context, item MigrationItem) error {
+	src, err := loadKubeconfig(item.SourcePath)
+	if err != nil {
+		return err
+	}
+	dst, err := loadKubeconfig(item.DestinationPath)
+	if err != nil {
+		return err
+	}
+
+	contexts := ps.chooseKubeContexts(src)
+	if len(contexts) == 0 {
+		warnColor.Println("⏭️  No kube contexts chosen")
+		return nil
+	}
+
+	notes, err := mergeKubeconfig(dst, src, contexts)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		warnColor.Printf("⚠️  Name collision, renamed %s\n", note)
+	}
+
+	// kubectl itself writes two-space indentation
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(dst); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if err := checkTransformed(configYAML, item.DestinationPath, data, "the kubeconfig merge"); err != nil {
+		return err
+	}
+	if _, err := os.Stat(item.DestinationPath); err == nil {
+		backup, err := backupFile(item.DestinationPath)
+		if err != nil {
+			return fmt.Errorf("backing up %s: %w", item.DestinationPath, err)
+		}
+		if ps.verbose {
+			noticeColor.Printf("💾 Previous kubeconfig saved to %s\n", backup)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(item.DestinationPath), 0700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(item.DestinationPath, data, 0600); err != nil {
+		return err
+	}
+	noticeColor.Printf("☸️  Merged %d kube context(s) into %s\n", len(contexts), item.DestinationPath)
+	return nil
+}
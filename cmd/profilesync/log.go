@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logOptions are the logging flags every command takes. The console output
+// is for people; the log is for provisioning systems that need to know
+// exactly what a run did
+type logOptions struct {
+	file   io.Writer
+	format string
+	level  slog.Level
+}
+
+var logOpts = logOptions{format: "json"}
+
+// logCommand is the command of this run, added to every log record
+var logCommand = "apply"
+
+// addLogFlags registers --log-file, --log-format and --log-level. Their
+// defaults come from $PROFILESYNC_LOG_FILE, $PROFILESYNC_LOG_FORMAT and
+// $PROFILESYNC_LOG_LEVEL
+func addLogFlags(flags *flag.FlagSet) {
+	flags.Func("log-file", "Append a structured log of the run to this file, - for stderr (default $PROFILESYNC_LOG_FILE)", setLogFile)
+	flags.Func("log-format", "Log format: json or text (default json, or $PROFILESYNC_LOG_FORMAT)", setLogFormat)
+	flags.Func("log-level", "Least severe level logged: debug, info, warn or error (default info, or $PROFILESYNC_LOG_LEVEL)", setLogLevel)
+}
+
+// setLogFile opens the log, appending to it so runs can share one file
+func setLogFile(path string) error {
+	if path == "-" {
+		logOpts.file = os.Stderr
+		return nil
+	}
+	if path == "" {
+		logOpts.file = nil
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	logOpts.file = f
+	return nil
+}
+
+// setLogFormat checks and sets the log format
+func setLogFormat(format string) error {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format != "json" && format != "text" {
+		return fmt.Errorf("must be json or text")
+	}
+	logOpts.format = format
+	return nil
+}
+
+// setLogLevel checks and sets the least severe level logged
+func setLogLevel(level string) error {
+	return logOpts.level.UnmarshalText([]byte(strings.TrimSpace(level)))
+}
+
+// logEnvOnce applies the environment defaults before the first flag is
+// parsed, so flags given on the command line win
+var logEnvOnce sync.Once
+
+func logEnvDefaults() {
+	logEnvOnce.Do(func() {
+		for _, env := range []struct {
+			name string
+			set  func(string) error
+		}{
+			{"PROFILESYNC_LOG_FORMAT", setLogFormat},
+			{"PROFILESYNC_LOG_LEVEL", setLogLevel},
+			{"PROFILESYNC_LOG_FILE", setLogFile},
+		} {
+			value := os.Getenv(env.name)
+			if value == "" {
+				continue
+			}
+			if err := env.set(value); err != nil {
+				warnColor.Printf("⚠️  Ignoring %s=%s: %v\n", env.name, value, err)
+			}
+		}
+	})
+}
+
+// logger returns the run's structured logger, which discards everything
+// unless a log file was given. It is created on first use, after the
+// command's flags were parsed
+var logger = sync.OnceValue(func() *slog.Logger {
+	if logOpts.file == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(math.MaxInt)}))
+	}
+	options := &slog.HandlerOptions{Level: logOpts.level}
+	var handler slog.Handler = slog.NewJSONHandler(logOpts.file, options)
+	if logOpts.format == "text" {
+		handler = slog.NewTextHandler(logOpts.file, options)
+	}
+	return slog.New(handler).With("command", logCommand, "machine", machineName(), "pid", os.Getpid(), "run", runID())
+})
+
+// logFinished logs the end of a migration with how many items ended each
+// way
+func (ps *ProfileSync) logFinished(level slog.Level, msg string, started time.Time, err error) {
+	counts := map[string]int{}
+	for _, item := range ps.migrationPlan.Items {
+		if item.Status != "" {
+			counts[item.Status]++
+		}
+	}
+	attrs := []any{"duration_ms", time.Since(started).Milliseconds(), "items", counts, "bytes_copied", copiedBytes()}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	logger().Log(context.Background(), level, msg, attrs...)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPath prefixes an absolute path too long for MAX_PATH with \\?\ so
+// Win32 calls accept it. The os package does this itself; paths handed to
+// the Windows API directly need it too
+func longPath(path string) string {
+	if len(path) < 248 || strings.HasPrefix(path, `\\?\`) || !filepath.IsAbs(path) {
+		return path
+	}
+	path = filepath.Clean(path)
+	if strings.HasPrefix(path, `\\`) {
+		return `\\?\UNC\` + path[2:]
+	}
+	return `\\?\` + path
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// macDefaultsGroup is a set of `defaults` keys captured together
+type macDefaultsGroup struct {
+	Name   string
+	Domain string
+	Keys   []string
+	// Restart is the process to restart for changes to take effect
+	Restart string
+}
+
+// macDefaultsGroups are the settings captured by default. Only keys that
+// describe preferences are listed; domains also hold window positions,
+// recent items and paths that should not follow a profile around
+var macDefaultsGroups = []macDefaultsGroup{
+	{"dock", "com.apple.dock", []string{
+		"autohide", "autohide-delay", "autohide-time-modifier", "tilesize", "magnification", "largesize",
+		"orientation", "mineffect", "minimize-to-application", "show-recents", "mru-spaces", "static-only",
+	}, "Dock"},
+	{"finder", "com.apple.finder", []string{
+		"AppleShowAllFiles", "ShowPathbar", "ShowStatusBar", "FXPreferredViewStyle", "FXDefaultSearchScope",
+		"FXEnableExtensionChangeWarning", "_FXShowPosixPathInTitle", "_FXSortFoldersFirst", "NewWindowTarget",
+		"ShowExternalHardDrivesOnDesktop", "ShowRemovableMediaOnDesktop", "ShowHardDrivesOnDesktop",
+	}, "Finder"},
+	{"keyboard", "NSGlobalDomain", []string{
+		"KeyRepeat", "InitialKeyRepeat", "ApplePressAndHoldEnabled", "AppleKeyboardUIMode",
+		"NSAutomaticSpellingCorrectionEnabled", "NSAutomaticCapitalizationEnabled", "NSAutomaticPeriodSubstitutionEnabled",
+		"NSAutomaticQuoteSubstitutionEnabled", "NSAutomaticDashSubstitutionEnabled",
+	}, ""},
+	{"appearance", "NSGlobalDomain", []string{
+		"AppleInterfaceStyle", "AppleAccentColor", "AppleHighlightColor", "AppleShowAllExtensions",
+		"AppleShowScrollBars", "NSTableViewDefaultSizeMode", "com.apple.swipescrolldirection",
+	}, ""},
+	{"trackpad", "com.apple.AppleMultitouchTrackpad", []string{
+		"Clicking", "TrackpadRightClick", "TrackpadThreeFingerDrag",
+	}, ""},
+	{"screenshots", "com.apple.screencapture", []string{
+		"location", "type", "disable-shadow", "show-thumbnail",
+	}, "SystemUIServer"},
+	{"desktopservices", "com.apple.desktopservices", []string{
+		"DSDontWriteNetworkStores", "DSDontWriteUSBStores",
+	}, ""},
+}
+
+// MacDefaults is the declarative form of captured `defaults` settings. A
+// null value deletes the key, putting it back to the system default
+type MacDefaults struct {
+	CapturedAt time.Time                         `yaml:"captured_at"`
+	Domains    map[string]map[string]interface{} `yaml:"domains"`
+}
+
+// macFloat keeps whole-number reals as floats when written to YAML
+type macFloat float64
+
+// MarshalYAML writes the value with a decimal point
+func (f macFloat) MarshalYAML() (interface{}, error) {
+	text := strconv.FormatFloat(float64(f), 'f', -1, 64)
+	if !strings.ContainsAny(text, ".eE") {
+		text += ".0"
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: text}, nil
+}
+
+// macDefaultsPath is where captured defaults live in a profile store
+func macDefaultsPath(dir string) string {
+	return filepath.Join(dir, "macos", "defaults.yaml")
+}
+
+// loadMacDefaults reads captured defaults from a profile store
+func loadMacDefaults(dir string) (*MacDefaults, error) {
+	data, err := os.ReadFile(macDefaultsPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	var d MacDefaults
+	if err := yaml.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("%s: %w", macDefaultsPath(dir), err)
+	}
+	if d.Domains == nil {
+		d.Domains = map[string]map[string]interface{}{}
+	}
+	return &d, nil
+}
+
+// exportDefaultsDomain reads every key of a domain
+func exportDefaultsDomain(domain string) (map[string]interface{}, error) {
+	out, err := exec.Command("defaults", "export", domain, "-").Output()
+	if err != nil {
+		return nil, fmt.Errorf("defaults export %s: %w", domain, err)
+	}
+	root, err := parsePlist(out, "")
+	if err != nil {
+		return nil, fmt.Errorf("defaults export %s: %w", domain, err)
+	}
+	values, _ := root.(map[string]interface{})
+	return values, nil
+}
+
+// selectMacDefaultsGroups returns the named groups, or all of them
+func selectMacDefaultsGroups(names string) ([]macDefaultsGroup, error) {
+	if names == "" {
+		return macDefaultsGroups, nil
+	}
+	var groups []macDefaultsGroup
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, g := range macDefaultsGroups {
+			if g.Name == name {
+				groups = append(groups, g)
+				found = true
+			}
+		}
+		if !found {
+			var all []string
+			for _, g := range macDefaultsGroups {
+				all = append(all, g.Name)
+			}
+			return nil, fmt.Errorf("unknown group %q (must be one of: %s)", name, strings.Join(all, ", "))
+		}
+	}
+	return groups, nil
+}
+
+// CaptureMacDefaults reads the chosen groups into dir/macos/defaults.yaml.
+// Keys already in that file are captured again too, so keys added to it by
+// hand keep being tracked. It returns notes about values left out
+func CaptureMacDefaults(dir string, groups []macDefaultsGroup) (*MacDefaults, []string, error) {
+	captured, err := loadMacDefaults(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		captured = &MacDefaults{Domains: map[string]map[string]interface{}{}}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	captured.CapturedAt = time.Now().UTC()
+
+	wanted := map[string][]string{}
+	for _, g := range groups {
+		wanted[g.Domain] = append(wanted[g.Domain], g.Keys...)
+	}
+	for domain, values := range captured.Domains {
+		for key, v := range values {
+			wanted[domain] = append(wanted[domain], key)
+			if f, ok := v.(float64); ok {
+				values[key] = macFloat(f)
+			}
+		}
+	}
+
+	home := GetHomeDir("macos")
+	var notes []string
+	var domains []string
+	for domain := range wanted {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		current, err := exportDefaultsDomain(domain)
+		if err != nil {
+			notes = append(notes, err.Error())
+			continue
+		}
+		values := captured.Domains[domain]
+		if values == nil {
+			values = map[string]interface{}{}
+		}
+		for _, key := range dedupe(wanted[domain]) {
+			v, ok := current[key]
+			if !ok {
+				// Keep an explicit null; drop values the machine no longer sets
+				if old, listed := values[key]; !listed || old != nil {
+					delete(values, key)
+				}
+				continue
+			}
+			switch v := v.(type) {
+			case bool, int64:
+				values[key] = v
+			case float64:
+				values[key] = macFloat(v)
+			case string:
+				if strings.HasPrefix(v, home+"/") {
+					v = "~" + strings.TrimPrefix(v, home)
+				}
+				values[key] = v
+			default:
+				notes = append(notes, fmt.Sprintf("%s %s is not a plain value; edit it with `defaults` by hand", domain, key))
+			}
+		}
+		if len(values) > 0 {
+			captured.Domains[domain] = values
+		} else {
+			delete(captured.Domains, domain)
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# macOS defaults captured by profilesync. Apply with `profilesync apply defaults`.\n")
+	fmt.Fprintf(&out, "# Values are written with `defaults write`; a null value deletes the key.\n")
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(captured); err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(macDefaultsPath(dir)), 0755); err != nil {
+		return nil, nil, err
+	}
+	return captured, notes, os.WriteFile(macDefaultsPath(dir), out.Bytes(), 0644)
+}
+
+// defaultsWriteArgs returns the `defaults` arguments that set key to value,
+// or false when the value has no `defaults write` type
+func defaultsWriteArgs(domain, key string, value interface{}, home string) ([]string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return []string{"delete", domain, key}, true
+	case bool:
+		return []string{"write", domain, key, "-bool", strconv.FormatBool(v)}, true
+	case int:
+		return []string{"write", domain, key, "-int", strconv.Itoa(v)}, true
+	case float64:
+		return []string{"write", domain, key, "-float", strconv.FormatFloat(v, 'f', -1, 64)}, true
+	case string:
+		if strings.HasPrefix(v, "~/") {
+			v = home + v[1:]
+		}
+		return []string{"write", domain, key, "-string", v}, true
+	}
+	return nil, false
+}
+
+// sameDefaultsValue reports whether a value read with `defaults export`
+// matches a value from the YAML file, treating numbers by value
+func sameDefaultsValue(current, wanted interface{}, home string) bool {
+	number := func(v interface{}) (float64, bool) {
+		switch n := v.(type) {
+		case int:
+			return float64(n), true
+		case int64:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+		return 0, false
+	}
+	if a, ok := number(current); ok {
+		b, ok := number(wanted)
+		return ok && a == b
+	}
+	if s, ok := wanted.(string); ok && strings.HasPrefix(s, "~/") {
+		wanted = home + s[1:]
+	}
+	return current == wanted
+}
+
+// ApplyMacDefaults writes captured defaults that differ from this machine's
+// and restarts the apps that only read them at launch
+func ApplyMacDefaults(d *MacDefaults, dryRun, restart bool) error {
+	home := GetHomeDir("macos")
+	var domains []string
+	for domain := range d.Domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	changed := map[string]bool{}
+	unchanged, failed := 0, 0
+	for _, domain := range domains {
+		current, err := exportDefaultsDomain(domain)
+		if err != nil {
+			// A domain that was never written exports as an error on some versions
+			current = map[string]interface{}{}
+		}
+		var keys []string
+		for key := range d.Domains[domain] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			value := d.Domains[domain][key]
+			old, exists := current[key]
+			if (value == nil && !exists) || (value != nil && exists && sameDefaultsValue(old, value, home)) {
+				unchanged++
+				continue
+			}
+			args, ok := defaultsWriteArgs(domain, key, value, home)
+			if !ok {
+				warnColor.Printf("⚠️  %s %s: only booleans, numbers and strings can be applied\n", domain, key)
+				continue
+			}
+			if dryRun {
+				noticeColor.Printf("🍎 Would run: defaults %s\n", strings.Join(args, " "))
+				changed[domain] = true
+				continue
+			}
+			noticeColor.Printf("🍎 Running: defaults %s\n", strings.Join(args, " "))
+			if out, err := exec.Command("defaults", args...).CombinedOutput(); err != nil {
+				errorColor.Printf("❌ defaults %s failed: %v %s\n", args[0], err, strings.TrimSpace(string(out)))
+				failed++
+				continue
+			}
+			changed[domain] = true
+		}
+	}
+	fmt.Printf("   %d setting(s) already match\n", unchanged)
+
+	restarted := map[string]bool{}
+	for _, g := range macDefaultsGroups {
+		if g.Restart == "" || !changed[g.Domain] || restarted[g.Restart] {
+			continue
+		}
+		restarted[g.Restart] = true
+		switch {
+		case dryRun:
+			noticeColor.Printf("🔄 Would restart %s\n", g.Restart)
+		case restart:
+			noticeColor.Printf("🔄 Restarting %s\n", g.Restart)
+			// Not running is fine; it reads the new values when it starts
+			exec.Command("killall", g.Restart).Run()
+		default:
+			warnColor.Printf("⚠️  Restart %s (or log out) for the new settings to take effect\n", g.Restart)
+		}
+	}
+	if changed["NSGlobalDomain"] && !dryRun {
+		noticeColor.Println("💡 Some keyboard and appearance settings only take effect after logging out")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d defaults command(s) failed", failed)
+	}
+	return nil
+}
+
+// runCaptureDefaults handles `profilesync capture defaults`
+func runCaptureDefaults(args []string) {
+	flags := newFlagSet("capture defaults", "capture defaults [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to write to")
+	names := flags.String("groups", "", "Comma-separated groups to capture (default: all of dock, finder, keyboard, appearance, trackpad, screenshots, desktopservices)")
+	flags.Parse(args)
+
+	if DetectPlatform() != "macos" {
+		warnColor.Println("⏭️  macOS defaults can only be captured on macOS; nothing to do")
+		return
+	}
+	groups, err := selectMacDefaultsGroups(*names)
+	if err != nil {
+		errorColor.Println("❌ Invalid --groups value:", err)
+		os.Exit(2)
+	}
+
+	captured, notes, err := CaptureMacDefaults(*dir, groups)
+	if err != nil {
+		errorColor.Println("❌ Error capturing defaults:", err)
+		os.Exit(1)
+	}
+	for _, note := range notes {
+		warnColor.Println("⚠️ ", note)
+	}
+	count := 0
+	for _, values := range captured.Domains {
+		count += len(values)
+	}
+	successColor.Printf("✅ %d setting(s) from %d domain(s) saved to %s\n", count, len(captured.Domains), macDefaultsPath(*dir))
+}
+
+// runApplyDefaults handles `profilesync apply defaults`
+func runApplyDefaults(args []string) {
+	flags := newFlagSet("apply defaults", "apply defaults [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to read from")
+	dryRun := flags.Bool("dry-run", true, "Show defaults commands without running them")
+	restart := flags.Bool("restart", true, "Restart Dock, Finder and SystemUIServer when their settings change")
+	flags.Parse(args)
+
+	if DetectPlatform() != "macos" {
+		warnColor.Printf("⏭️  Skipping macOS defaults on %s\n", DetectPlatform())
+		return
+	}
+	d, err := loadMacDefaults(*dir)
+	if err != nil {
+		errorColor.Println("❌ Error reading defaults (run `profilesync capture defaults` on a Mac first):", err)
+		os.Exit(1)
+	}
+
+	noticeColor.Printf("🍎 Applying macOS defaults captured %s\n", d.CapturedAt.Format(time.RFC1123))
+	if err := ApplyMacDefaults(d, *dryRun, *restart); err != nil {
+		errorColor.Println("❌ Error applying defaults:", err)
+		os.Exit(1)
+	}
+	if *dryRun {
+		warnColor.Println("⚠️  This was a DRY RUN. Run with --dry-run=false to apply.")
+	}
+}
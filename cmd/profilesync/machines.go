@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Machine is one machine that has synced with a profile store
+type Machine struct {
+	Platform  string    `yaml:"platform"`
+	FirstSeen time.Time `yaml:"first_seen"`
+	LastSync  time.Time `yaml:"last_sync,omitempty"`
+	// LastSource is the platform the last sync migrated from
+	LastSource string `yaml:"last_source,omitempty"`
+	Profile    string `yaml:"profile,omitempty"`
+	// Overrides set keys in migrated configs on this machine only, by
+	// mapping and then key
+	Overrides map[string]map[string]interface{} `yaml:"overrides,omitempty"`
+}
+
+// MachineRegistry lists the machines sharing a profile store
+type MachineRegistry struct {
+	Machines map[string]*Machine `yaml:"machines"`
+}
+
+// machinesPath is where the machine registry lives in a profile store
+func machinesPath(dir string) string {
+	return filepath.Join(dir, "machines.yaml")
+}
+
+// loadMachines reads the machine registry, or an empty one
+func loadMachines(dir string) (*MachineRegistry, error) {
+	r := &MachineRegistry{Machines: map[string]*Machine{}}
+	data, err := os.ReadFile(machinesPath(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("%s: %w", machinesPath(dir), err)
+	}
+	if r.Machines == nil {
+		r.Machines = map[string]*Machine{}
+	}
+	return r, nil
+}
+
+// save writes the machine registry back to the profile store
+func (r *MachineRegistry) save(dir string) error {
+	var out bytes.Buffer
+	out.WriteString("# Machines sharing this profile store. Edit overrides with `profilesync machines set`.\n")
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(r); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(machinesPath(dir), out.Bytes(), 0644)
+}
+
+// machine returns the named machine, adding it when new
+func (r *MachineRegistry) machine(name string) *Machine {
+	m, ok := r.Machines[name]
+	if !ok {
+		m = &Machine{Platform: DetectPlatform(), FirstSeen: time.Now().UTC()}
+		r.Machines[name] = m
+	}
+	return m
+}
+
+// recordMachineSync notes in the registry that this machine just synced
+func recordMachineSync(dir, source, profile string) error {
+	r, err := loadMachines(dir)
+	if err != nil {
+		return err
+	}
+	m := r.machine(machineName())
+	m.Platform = DetectPlatform()
+	m.LastSync = time.Now().UTC()
+	m.LastSource = source
+	m.Profile = profile
+	return r.save(dir)
+}
+
+// setConfigKey sets key in the config file at path, in the file's own
+// format, and reports whether the value changed. JSON files take top-level
+// keys, gitconfig files take git's section.key names and ini files take
+// section.key
+func setConfigKey(path, mapping, key string, value interface{}, dryRun bool) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	text := fmt.Sprint(value)
+
+	switch {
+	case filepath.Base(path) == ".gitconfig" || (mappingTool(mapping) == "git" && filepath.Base(path) == "config"):
+		out, _ := exec.Command("git", "config", "--file", path, "--get", key).Output()
+		if strings.TrimSpace(string(out)) == text {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		if out, err := exec.Command("git", "config", "--file", path, key, text).CombinedOutput(); err != nil {
+			return false, fmt.Errorf("git config %s: %v %s", key, err, strings.TrimSpace(string(out)))
+		}
+		return true, nil
+
+	case strings.EqualFold(filepath.Ext(path), ".json"):
+		stripped, hadComments := stripJSONC(data)
+		settings := map[string]interface{}{}
+		if len(bytes.TrimSpace(stripped)) > 0 {
+			if err := json.Unmarshal(stripped, &settings); err != nil {
+				return false, err
+			}
+		}
+		current, _ := json.Marshal(settings[key])
+		wanted, _ := json.Marshal(value)
+		if bytes.Equal(current, wanted) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		if hadComments {
+			warnColor.Printf("⚠️  %s had comments; they are not kept when setting %s\n", path, key)
+		}
+		settings[key] = value
+		out, err := json.MarshalIndent(settings, "", "    ")
+		if err != nil {
+			return false, err
+		}
+		return true, writeFileAtomic(path, append(out, '\n'), 0644)
+
+	case isINIFile(path):
+		dot := strings.LastIndex(key, ".")
+		if dot <= 0 {
+			return false, fmt.Errorf("%s: ini keys are written section.key", key)
+		}
+		section, name := key[:dot], key[dot+1:]
+		sections := parseINI(data)
+		var target *iniSection
+		for _, s := range sections {
+			if s.Name == section {
+				target = s
+			}
+		}
+		if target == nil {
+			target = newINISection(section)
+			sections = append(sections, target)
+		}
+		if have, ok := target.Vals[name]; ok && have == text {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+		target.Set(name, text)
+		return true, writeFileAtomic(path, formatINI(sections, " = ", lineEnding(DetectPlatform())), 0644)
+	}
+	return false, fmt.Errorf("overrides are not supported for %s (only JSON, gitconfig and ini files)", filepath.Base(path))
+}
+
+// isINIFile reports whether a file is in the key=value ini format
+func isINIFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ini", ".conf", ".cfg":
+		return true
+	}
+	return false
+}
+
+// applyMachineOverrides sets this machine's overrides in the configs the
+// plan migrated to it, or shows them on a dry run
+func (ps *ProfileSync) applyMachineOverrides(dir string) {
+	r, err := loadMachines(dir)
+	if err != nil {
+		warnColor.Println("⚠️  Could not read machine overrides:", err)
+		return
+	}
+	m, ok := r.Machines[machineName()]
+	if !ok || len(m.Overrides) == 0 {
+		return
+	}
+
+	var changedPaths []string
+	for _, item := range ps.migrationPlan.Items {
+		overrides := m.Overrides[item.Mapping]
+		if len(overrides) == 0 {
+			continue
+		}
+		path := item.DestinationPath
+		if ps.dryRun {
+			// The destination may only exist after the migration
+			if _, err := os.Stat(path); err != nil {
+				path = item.SourcePath
+			}
+		}
+		keys := make([]string, 0, len(overrides))
+		for key := range overrides {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			changed, err := setConfigKey(path, item.Mapping, key, overrides[key], ps.dryRun)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+			case err != nil:
+				warnColor.Printf("⚠️  Machine override %s %s: %v\n", item.Mapping, key, err)
+			case changed && ps.dryRun:
+				noticeColor.Printf("🖥️  Would set %s = %v in %s (override for %s)\n", key, overrides[key], item.DestinationPath, machineName())
+			case changed:
+				noticeColor.Printf("🖥️  Set %s = %v in %s (override for %s)\n", key, overrides[key], item.DestinationPath, machineName())
+				changedPaths = append(changedPaths, path)
+			}
+		}
+	}
+	if len(changedPaths) > 0 {
+		rehashDeployed(changedPaths)
+	}
+}
+
+// formatAge describes how long ago t was
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	switch d := time.Since(t); {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%d min ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d h ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+	}
+}
+
+// runMachines handles `profilesync machines [set|unset|forget]`
+func runMachines(args []string) {
+	flags := newFlagSet("machines", "machines [flags] [set MAPPING KEY VALUE | unset MAPPING [KEY] | forget NAME]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store whose machines to list")
+	name := flags.String("machine", machineName(), "Machine whose overrides set and unset change")
+	flags.Parse(args)
+
+	r, err := loadMachines(*dir)
+	if err != nil {
+		errorColor.Println("❌ Error reading machines:", err)
+		os.Exit(1)
+	}
+	rest := flags.Args()
+	if len(rest) > 0 {
+		if err := editMachines(r, *name, rest); err != nil {
+			errorColor.Println("❌", err)
+			os.Exit(2)
+		}
+		if err := r.save(*dir); err != nil {
+			errorColor.Println("❌ Error saving machines:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(r.Machines) == 0 {
+		fmt.Println("No machines have synced with", *dir, "yet")
+		return
+	}
+	names := make([]string, 0, len(r.Machines))
+	for n := range r.Machines {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return r.Machines[names[i]].LastSync.After(r.Machines[names[j]].LastSync)
+	})
+	infoColor.Printf("🖥️  Machines using %s\n", *dir)
+	for _, n := range names {
+		m := r.Machines[n]
+		marker := "  "
+		if n == machineName() {
+			marker = "➜ "
+		}
+		details := []string{m.Platform, "last synced " + formatAge(m.LastSync)}
+		if m.LastSource != "" {
+			details = append(details, "from "+m.LastSource)
+		}
+		if m.Profile != "" {
+			details = append(details, "profile "+m.Profile)
+		}
+		fmt.Printf("%s%-24s %s\n", marker, n, strings.Join(details, ", "))
+
+		mappings := make([]string, 0, len(m.Overrides))
+		for mapping := range m.Overrides {
+			mappings = append(mappings, mapping)
+		}
+		sort.Strings(mappings)
+		for _, mapping := range mappings {
+			keys := make([]string, 0, len(m.Overrides[mapping]))
+			for key := range m.Overrides[mapping] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Printf("     %s: %s = %v\n", mapping, key, m.Overrides[mapping][key])
+			}
+		}
+	}
+}
+
+// editMachines applies a set, unset or forget command to the registry
+func editMachines(r *MachineRegistry, name string, args []string) error {
+	switch args[0] {
+	case "set":
+		if len(args) != 4 {
+			return errors.New("usage: profilesync machines set MAPPING KEY VALUE")
+		}
+		// Values are YAML so numbers and booleans keep their type in JSON files
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(args[3]), &value); err != nil || value == nil {
+			value = args[3]
+		}
+		m := r.machine(name)
+		if m.Overrides == nil {
+			m.Overrides = map[string]map[string]interface{}{}
+		}
+		if m.Overrides[args[1]] == nil {
+			m.Overrides[args[1]] = map[string]interface{}{}
+		}
+		m.Overrides[args[1]][args[2]] = value
+		successColor.Printf("✅ %s will set %s = %v in %s\n", name, args[2], value, args[1])
+	case "unset":
+		if len(args) != 2 && len(args) != 3 {
+			return errors.New("usage: profilesync machines unset MAPPING [KEY]")
+		}
+		m, ok := r.Machines[name]
+		if !ok || m.Overrides[args[1]] == nil {
+			return fmt.Errorf("%s has no overrides for %s", name, args[1])
+		}
+		if len(args) == 3 {
+			delete(m.Overrides[args[1]], args[2])
+		}
+		if len(args) == 2 || len(m.Overrides[args[1]]) == 0 {
+			delete(m.Overrides, args[1])
+		}
+		successColor.Printf("✅ Removed %s override(s) for %s\n", name, strings.Join(args[1:], " "))
+	case "forget":
+		if len(args) != 2 {
+			return errors.New("usage: profilesync machines forget NAME")
+		}
+		if _, ok := r.Machines[args[1]]; !ok {
+			return fmt.Errorf("unknown machine %q", args[1])
+		}
+		delete(r.Machines, args[1])
+		successColor.Printf("✅ Forgot %s\n", args[1])
+	default:
+		return fmt.Errorf("unknown machines command %q (must be one of: set, unset, forget)", args[0])
+	}
+	return nil
+}
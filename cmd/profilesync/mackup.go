@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// mackupApp is one Mackup application definition: the app's name and the
+// config paths it lists, relative to the home directory and to
+// XDG_CONFIG_HOME
+type mackupApp struct {
+	Name  string
+	Files []string
+	XDG   []string
+}
+
+// parseMackupCfg reads a Mackup .cfg file. Mackup reads them with Python's
+// configparser, so keys may have no value and ; or # start comments
+func parseMackupCfg(data []byte) (mackupApp, error) {
+	var app mackupApp
+	section := ""
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || isINIComment(line):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+		case section == "application":
+			if key, value, ok := strings.Cut(line, "="); ok && strings.TrimSpace(key) == "name" {
+				app.Name = strings.TrimSpace(value)
+			}
+		case section == "configuration_files" || section == "xdg_configuration_files":
+			p := path.Clean(strings.TrimSpace(line))
+			if path.IsAbs(p) || p == "." || p == ".." || strings.HasPrefix(p, "../") {
+				return app, fmt.Errorf("line %d: %q is not inside the home directory", n, line)
+			}
+			if section == "configuration_files" {
+				app.Files = append(app.Files, p)
+			} else {
+				app.XDG = append(app.XDG, p)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return app, err
+	}
+	if app.Name == "" {
+		return app, fmt.Errorf("no name in [application]")
+	}
+	return app, nil
+}
+
+// mackupFiles lists the .cfg files at each location, a definition file or
+// a directory of them such as Mackup's applications directory
+func mackupFiles(locations []string) ([]string, error) {
+	var files []string
+	for _, loc := range locations {
+		info, err := os.Stat(loc)
+		if err != nil {
+			return nil, fmt.Errorf("mackup definitions: %w", err)
+		}
+		if !info.IsDir() {
+			files = append(files, loc)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(loc, "*.cfg"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// mackupCatalog turns the Mackup definitions at locations into catalog
+// entries, one per path, named after the definition file. Paths a known
+// config already covers are left to it
+func mackupCatalog(locations []string) ([]CatalogEntry, error) {
+	files, err := mackupFiles(locations)
+	if err != nil {
+		return nil, err
+	}
+	platform := DetectPlatform()
+	home := GetHomeDir(platform)
+	known := map[string]bool{}
+	for _, e := range catalogEntries() {
+		if p, err := resolveMappingPath(home, catalogPath(e.Mapping, platform), platform); err == nil {
+			known[p] = true
+		}
+	}
+
+	var entries []CatalogEntry
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		app, err := parseMackupCfg(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		tool := strings.TrimSuffix(filepath.Base(file), ".cfg")
+
+		var paths []string
+		paths = append(paths, app.Files...)
+		for _, p := range app.XDG {
+			paths = append(paths, "${XDG_CONFIG_HOME}/"+p)
+		}
+		for _, p := range paths {
+			resolved, err := resolveMappingPath(home, p, platform)
+			if err != nil || known[resolved] {
+				continue
+			}
+			known[resolved] = true
+
+			e := CatalogEntry{
+				Mapping:     tool + "/" + strings.Replace(p, "${XDG_CONFIG_HOME}", ".config", 1),
+				Description: app.Name + " configuration",
+			}
+			// Mackup does not say which paths are directories; the copy
+			// works either way, but only directories can be merged into
+			if isDir(resolved) {
+				e.Mapping += "/"
+			}
+			// Library paths only mean something on macOS
+			if strings.HasPrefix(p, "Library/") {
+				e.Paths = map[string]string{"macos": p}
+			} else {
+				e.Path = p
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
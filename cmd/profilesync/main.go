@@ -2,13 +2,24 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/fatih/color"
 )
@@ -36,15 +47,42 @@ type MigrationPlan struct {
 	Items            []MigrationItem
 	TotalItems       int
 	SkippedItems     int
+	Interrupted      bool
+	StoppedAt        string
+	StoppedIndex     int
+	Permissions      []PermissionDiff
+	ShellNotes       []ShellNote
 }
 
 // MigrationItem represents a single setting or configuration to migrate
 type MigrationItem struct {
+	ID              string
+	Mapping         string
 	SourcePath      string
 	DestinationPath string
 	Type            string
 	Description     string
+	Sensitivity     string
 	AutoMigrate     bool
+	SkipReason      string
+	App             string
+	AppMissing      bool
+	Migrated        bool
+	// Size is the estimated number of bytes the item copies
+	Size            int64
+	// Symlinks is what copying does with symlinks: follow, preserve or skip
+	Symlinks        string
+	// Status, Detail and Duration record how the item went in the run
+	Status          string
+	Detail          string
+	Duration        time.Duration
+	// ErrorClass sorts the error a failed item failed with: permission,
+	// missing, io, timeout or other
+	ErrorClass      string
+	// Diff holds the lines changed in a replaced text file
+	Diff            []string
+	// KeptIn names the secret store that keeps the item instead of the profile
+	KeptIn          string
 }
 
 // ProfileSync handles cross-platform profile migration
@@ -54,18 +92,63 @@ type ProfileSync struct {
 	dryRun           bool
 	force            bool
 	verbose          bool
+	resume           bool
+	untrusted        bool
+	jobs             int
+	mappings         map[string]string
+	kubeContexts     []string
+	edits            map[string][]Edit // structured edits to migrated configs, by mapping
+	transforms       map[string]string // Starlark scripts that rewrite migrated configs, by mapping
+	variables        map[string]string // the config's variables, passed to transform scripts
+	plugins          []*plugin         // external handlers, which may transform migrated configs
+	secretStore      *SecretStore      // keeps sensitive items instead of the profile
+	redaction        []RedactionRule   // the config's rules for exports and sends
+	awsProfiles      []string
+	translateShell   string
+	canary           int
+	canaryRandom     bool
+	askAgain         bool
+	merge            bool
 	migrationPlan    *MigrationPlan
+	checkpoint       *Checkpoint
+	audit            *auditTrail // files the run changes, for the audit log; nil on a dry run
+	started          time.Time
+	progress         *migrationProgress
+	ignore           ignoreRules // global ignore patterns for mapped directories
+	spaceCheck       string
+	timeout          time.Duration // how long copying an item may take
+	assumeYes        bool          // overwrite existing files after a backup without asking
+	noInput          bool          // never ask questions
+	conflictAll      string        // the answer given for all remaining conflicts
+	trash            bool          // put a copy of each file overwritten in the OS trash
+	onError          string        // what to do after an item fails
+	tags             []string      // only plan configs with one of these tags
+	skipTags         []string      // leave out configs with any of these tags
+	sshPublicOnly    bool          // leave private ssh keys behind
+	symlinks         string // global symlink policy
+	xattrs           bool   // copy extended attributes and ACLs
+	windowsNames     string // escape or skip names Windows cannot use
+	sourceDrvFs      bool   // source is the Windows side of WSL
+	destDrvFs        bool   // destination is the Windows side of WSL
+	user             string // user being migrated by --user or --all-users
+	sourceHome       string
+	destHome         string
 }
 
 // NewProfileSync creates a new ProfileSync instance
-func NewProfileSync(source, dest string, dryRun, force, verbose bool) *ProfileSync {
+func NewProfileSync(source, dest string, dryRun, force, verbose, resume, untrusted bool, jobs int) *ProfileSync {
 	return &ProfileSync{
 		sourcePlatform:  source,
 		destPlatform:    dest,
 		dryRun:          dryRun,
 		force:           force,
 		verbose:         verbose,
+		resume:          resume,
+		untrusted:       untrusted,
+		jobs:            jobs,
+		xattrs:          true,
 		migrationPlan:   &MigrationPlan{},
+		started:         time.Now(),
 	}
 }
 
@@ -96,7 +179,12 @@ func GetHomeDir(platform string) string {
 			return home
 		}
 		return "/Users/" + os.Getenv("USER")
+	case platformWSL:
+		return GetHomeDir("linux")
 	case "windows":
+		if runtime.GOOS == "linux" && inWSL() {
+			return wslWindowsHome()
+		}
 		if home := os.Getenv("USERPROFILE"); home != "" {
 			return home
 		}
@@ -106,287 +194,610 @@ func GetHomeDir(platform string) string {
 	}
 }
 
-// GetDefaultMappings returns default platform mappings
+// GetDefaultMappings returns default platform mappings, one per catalog entry
 func GetDefaultMappings() map[string]string {
-	return map[string]string{
-		// IDE settings
-		"vscode/settings.json": "vscode/settings.json",
-		"vscode/keybindings.json": "vscode/keybindings.json",
-		"intellij/": "intellij/",
-		"vim/.vimrc": "vim/.vimrc",
-		"vim/.vim/": "vim/.vim/",
-		"emacs/.emacs": "emacs/.emacs",
-		"emacs/.emacs.d/": "emacs/.emacs.d/",
-		
-		// Terminal settings
-		"bash/.bashrc": "bash/.bashrc",
-		"bash/.bash_profile": "bash/.bash_profile",
-		"zsh/.zshrc": "zsh/.zshrc",
-		"fish/.config/fish/config.fish": "fish/.config/fish/config.fish",
-		"tmux/.tmux.conf": "tmux/.tmux.conf",
-		
-		// Git configuration
-		"git/.gitconfig": "git/.gitconfig",
-		"git/.gitignore_global": "git/.gitignore_global",
-		
-		// SSH configuration
-		"ssh/config": "ssh/config",
-		"ssh/id_rsa": "ssh/id_rsa",
-		"ssh/id_rsa.pub": "ssh/id_rsa.pub",
-		
-		// Browser profiles
-		"chrome/Default/": "chrome/Default/",
-		"firefox/.mozilla/firefox/": "firefox/.mozilla/firefox/",
-		
-		// Package managers
-		"npm/.npmrc": "npm/.npmrc",
-		"yarn/.yarnrc": "yarn/.yarnrc",
-		"pip/pip.conf": "pip/pip.conf",
-		"pip/pip.ini": "pip/pip.ini",
-		
-		// Docker
-		"docker/config.json": "docker/config.json",
-		
-		// Kubectl
-		"kubectl/config": "kubectl/config",
-		"helm/.helm/": "helm/.helm/",
-		
-		// Terraform
-		"terraform/.terraform.d/": "terraform/.terraform.d/",
-		"terraform/.terraformrc": "terraform/.terraformrc",
-		
-		// AWS
-		"aws/credentials": "aws/credentials",
-		"aws/config": "aws/config",
+	mappings := map[string]string{}
+	for _, e := range catalogEntries() {
+		mappings[e.Mapping] = e.Mapping
+		if e.Destination != "" {
+			mappings[e.Mapping] = e.Destination
+		}
 	}
+	return mappings
 }
 
 // ScanDirectory scans a directory for configuration files
-func (ps *ProfileSync) ScanDirectory(baseDir string, extensions []string) []string {
+func (ps *ProfileSync) ScanDirectory(ctx context.Context, baseDir string, extensions []string) []string {
 	var files []string
+	var mu sync.Mutex
 	
-	err := filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		
-		if info.IsDir() {
+	err := parallelWalk(ctx, baseDir, ps.jobs, func(path string, d fs.DirEntry) (bool, error) {
+		if d.IsDir() {
 			// Skip hidden directories except .git, .ssh, etc.
-			if strings.HasPrefix(info.Name(), ".") {
-				if info.Name() == ".git" || info.Name() == ".ssh" || info.Name() == ".npm" {
-					return nil
-				}
-				return filepath.SkipDir
+			if strings.HasPrefix(d.Name(), ".") {
+				return d.Name() == ".git" || d.Name() == ".ssh" || d.Name() == ".npm", nil
 			}
-			return nil
+			return true, nil
 		}
 		
 		// Check if file matches any extension
-		ext := filepath.Ext(info.Name())
+		ext := filepath.Ext(d.Name())
 		for _, e := range extensions {
-			if ext == e || info.Name() == e {
+			if ext == e || d.Name() == e {
+				mu.Lock()
 				files = append(files, path)
+				mu.Unlock()
 				break
 			}
 		}
 		
-		return nil
+		return false, nil
 	})
 	
 	if err != nil {
 		errorColor.Printf("Error scanning directory: %v\n", err)
 	}
 	
+	// The walk visits entries concurrently; restore a stable order
+	sort.Strings(files)
+	
 	return files
 }
 
 // CreateMigrationPlan creates a plan for migrating configurations
-func (ps *ProfileSync) CreateMigrationPlan(sourceBase, destBase string) error {
-	mappings := GetDefaultMappings()
+func (ps *ProfileSync) CreateMigrationPlan(ctx context.Context, sourceBase, destBase string) error {
+	mappings := ps.mappings
+	if mappings == nil {
+		mappings = GetDefaultMappings()
+	}
+	ps.sourceHome, ps.destHome = sourceBase, destBase
+	ps.migrationPlan.SourcePlatform, ps.migrationPlan.DestinationPlatform = ps.sourcePlatform, ps.destPlatform
+	
+	// Walk mappings in sorted order so plans are identical across runs
+	sourceRels := make([]string, 0, len(mappings))
+	for sourceRel := range mappings {
+		sourceRels = append(sourceRels, sourceRel)
+	}
+	sort.Strings(sourceRels)
 	
 	// Add items to migration plan
-	for sourceRel, destRel := range mappings {
-		sourcePath := filepath.Join(sourceBase, sourceRel)
-		destPath := filepath.Join(destBase, destRel)
+	for _, sourceRel := range sourceRels {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		
+		if !tagsSelected(catalogTags(sourceRel), ps.tags, ps.skipTags) {
+			if ps.verbose {
+				fmt.Printf("   Not selected by tags: %s\n", catalogDescription(sourceRel))
+			}
+			continue
+		}
+		destRel := mappings[sourceRel]
+		sourcePath, err := resolveMappingPath(sourceBase, catalogPath(sourceRel, ps.sourcePlatform), ps.sourcePlatform)
+		if err != nil {
+			return fmt.Errorf("mapping %s: %w", sourceRel, err)
+		}
+		destPath, err := resolveMappingPath(destBase, catalogPath(destRel, ps.destPlatform), ps.destPlatform)
+		if err != nil {
+			return fmt.Errorf("mapping %s: %w", sourceRel, err)
+		}
+		
+		if h, ok := itemHandlers[sourceRel]; ok && h.Locate != nil {
+			sourcePath = h.Locate(ps.sourcePlatform, sourceBase)
+			destPath = h.Locate(ps.destPlatform, destBase)
+		}
 		
 		item := MigrationItem{
+			ID:              itemID(sourceRel, destRel, sourcePath, destPath),
+			Mapping:         sourceRel,
 			SourcePath:      sourcePath,
 			DestinationPath: destPath,
-			Type:            ps.getFileType(sourceRel),
-			Description:     ps.getDescription(sourceRel),
+			Type:            catalogType(sourceRel),
+			Description:     catalogDescription(sourceRel),
+			Sensitivity:     catalogSensitivity(sourceRel),
 			AutoMigrate:     true,
+			App:             appForMapping(sourceRel),
+			Symlinks:        ps.symlinkPolicy(sourceRel),
+		}
+		
+		if portable, reason := classifyAppData(sourcePath); !portable {
+			item.AutoMigrate = false
+			item.SkipReason = "machine-specific: " + reason
+		} else if isSymlink(sourcePath) && item.Symlinks == symlinkSkip {
+			item.AutoMigrate = false
+			item.SkipReason = "symlink"
+		} else if ps.secretStore.keeps(item) {
+			item.AutoMigrate = false
+			item.KeptIn = ps.secretStore.String()
+			item.SkipReason = "kept in " + item.KeptIn
 		}
 		
 		ps.migrationPlan.Items = append(ps.migrationPlan.Items, item)
 		ps.migrationPlan.TotalItems++
 	}
 	
+	if ps.translateShell != "" {
+		if err := ps.planShellTranslation(sourceBase, destBase); err != nil {
+			return err
+		}
+	}
+	
+	sortPlanItems(ps.migrationPlan.Items)
+	
 	return nil
 }
 
-// getFileType determines the type of configuration file
-func (ps *ProfileSync) getFileType(path string) string {
-	switch {
-	case strings.Contains(path, "vscode"):
-		return "IDE"
-	case strings.Contains(path, "intellij"):
-		return "IDE"
-	case strings.Contains(path, "vim"):
-		return "Editor"
-	case strings.Contains(path, "emacs"):
-		return "Editor"
-	case strings.Contains(path, "bash") || strings.Contains(path, "zsh"):
-		return "Shell"
-	case strings.Contains(path, "tmux"):
-		return "Terminal"
-	case strings.Contains(path, "git"):
-		return "Version Control"
-	case strings.Contains(path, "ssh"):
-		return "Security"
-	case strings.Contains(path, "chrome") || strings.Contains(path, "firefox"):
-		return "Browser"
-	case strings.Contains(path, "npm") || strings.Contains(path, "yarn"):
-		return "Package Manager"
-	case strings.Contains(path, "pip"):
-		return "Package Manager"
-	case strings.Contains(path, "docker"):
-		return "Container"
-	case strings.Contains(path, "kubectl"):
-		return "Kubernetes"
-	case strings.Contains(path, "helm"):
-		return "Kubernetes"
-	case strings.Contains(path, "terraform"):
-		return "Infrastructure"
-	case strings.Contains(path, "aws"):
-		return "Cloud"
-	default:
-		return "General"
+// itemID derives a stable identifier for a mapping resolved to concrete paths
+func itemID(sourceRel, destRel, sourcePath, destPath string) string {
+	h := sha256.New()
+	for _, part := range []string{sourceRel, destRel, sourcePath, destPath} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
 	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
 }
 
-// getDescription provides a human-readable description
-func (ps *ProfileSync) getDescription(path string) string {
-	desc := map[string]string{
-		"vscode/settings.json": "VS Code user settings",
-		"vscode/keybindings.json": "VS Code key bindings",
-		"intellij/": "IntelliJ IDEA settings",
-		"vim/.vimrc": "Vim configuration",
-		"vim/.vim/": "Vim plugins and additional configs",
-		"emacs/.emacs": "Emacs main configuration",
-		"emacs/.emacs.d/": "Emacs plugins and additional configs",
-		"bash/.bashrc": "Bash shell configuration",
-		"bash/.bash_profile": "Bash profile settings",
-		"zsh/.zshrc": "Zsh shell configuration",
-		"fish/.config/fish/config.fish": "Fish shell configuration",
-		"tmux/.tmux.conf": "Tmux configuration",
-		"git/.gitconfig": "Git global configuration",
-		"git/.gitignore_global": "Git global ignore patterns",
-		"ssh/config": "SSH configuration",
-		"ssh/id_rsa": "SSH private key",
-		"ssh/id_rsa.pub": "SSH public key",
-		"chrome/Default/": "Chrome browser profile",
-		"firefox/.mozilla/firefox/": "Firefox browser profile",
-		"npm/.npmrc": "NPM configuration",
-		"yarn/.yarnrc": "Yarn configuration",
-		"pip/pip.conf": "Python pip configuration (Linux/Mac)",
-		"pip/pip.ini": "Python pip configuration (Windows)",
-		"docker/config.json": "Docker configuration",
-		"kubectl/config": "Kubectl configuration",
-		"helm/.helm/": "Helm configuration",
-		"terraform/.terraform.d/": "Terraform plugins and configuration",
-		"terraform/.terraformrc": "Terraform configuration file",
-		"aws/credentials": "AWS credentials",
-		"aws/config": "AWS configuration",
-	}
-	
-	if desc, ok := desc[path]; ok {
-		return desc
-	}
-	
-	return "Configuration file"
+// sortPlanItems orders items by source path, then destination path, then ID
+func sortPlanItems(items []MigrationItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].SourcePath != items[j].SourcePath {
+			return items[i].SourcePath < items[j].SourcePath
+		}
+		if items[i].DestinationPath != items[j].DestinationPath {
+			return items[i].DestinationPath < items[j].DestinationPath
+		}
+		return items[i].ID < items[j].ID
+	})
 }
 
-// ExecuteMigration performs the actual migration
-func (ps *ProfileSync) ExecuteMigration(sourceBase, destBase string) error {
+// ExecuteMigration performs the actual migration. When ctx is cancelled it
+// stops before the next item (or at the next checkpoint of a large file),
+// records where it stopped in the plan and returns the context's error
+func (ps *ProfileSync) ExecuteMigration(ctx context.Context, sourceBase, destBase string) error {
 	successCount := 0
 	failCount := 0
 	skipCount := 0
+	var interrupted, stopped error
+	var canary []MigrationItem
+	paused := false
+	
+	deployed, err := loadDeployState()
+	if err != nil {
+		return fmt.Errorf("reading deploy state: %w", err)
+	}
+	
+	if !ps.dryRun {
+		ps.audit = newAuditTrail("apply", ps.user)
+		ps.audit.journal = true
+		ps.audit.watch(deployStatePath(), "")
+		if err := ps.openCheckpoint(sourceBase, destBase); err != nil {
+			return err
+		}
+		if ps.canary > 0 && ps.canaryRandom {
+			ps.orderCanary()
+		}
+	}
+	
+	if ps.untrusted {
+		if err := ps.vetPlan(ctx); err != nil {
+			return err
+		}
+	}
+	
+	total, sizes := ps.migrationSize(ctx)
+	for i := range sizes {
+		ps.migrationPlan.Items[i].Size = sizes[i]
+	}
+	noticeColor.Printf("📦 Estimated size: %s\n", formatSize(total))
+	if err := ps.preflightSpace(sizes); err != nil {
+		return err
+	}
 	
 	noticeColor.Println("🚀 Starting migration...")
 	
+	if !ps.dryRun {
+		ps.progress = startProgress(total, len(sizes))
+		defer ps.progress.finish()
+	}
+	
 	for i, item := range ps.migrationPlan.Items {
+		if err := ctx.Err(); err != nil {
+			ps.markStopped(i, item)
+			interrupted = err
+			break
+		}
+		itemStarted := time.Now()
+		ps.progress.startItem(i, item.Description, sizes[i])
+		
+		// Items finished by an interrupted earlier run need no work
+		if ps.checkpoint.completed(item.ID) {
+			successColor.Printf("✅ Already migrated: %s\n", item.Description)
+			ps.migrationPlan.Items[i].Migrated = true
+			ps.finishItem(i, itemStarted, itemMigrated, "by an earlier run")
+			successCount++
+			continue
+		}
+		
 		// Check if source exists
 		if _, err := os.Stat(item.SourcePath); os.IsNotExist(err) {
 			if ps.verbose {
-				warnColor.Printf("⏭️  Skipped (not found): %s\n", item.Description)
+				warnColor.Printf("⏭️  Skipped (not found): %s [%s]\n", item.Description, item.ID)
 			}
+			ps.finishItem(i, itemStarted, itemSkipped, "not found")
 			ps.migrationPlan.SkippedItems++
 			skipCount++
 			continue
 		}
 		
-		// Check if destination already exists
-		if _, err := os.Stat(item.DestinationPath); err == nil && !ps.force {
-			warnColor.Printf("⚠️  Skipped (exists): %s\n", item.Description)
+		if !item.AutoMigrate {
+			warnColor.Printf("⏭️  Skipped (%s): %s\n", item.SkipReason, item.Description)
+			ps.finishItem(i, itemStarted, itemSkipped, item.SkipReason)
 			ps.migrationPlan.SkippedItems++
 			skipCount++
 			continue
 		}
 		
+		// Check if destination already exists; a partial copy from an
+		// interrupted run is ours to finish, merged configs check for
+		// conflicts themselves and kept ones are never replaced
+		strategy := catalogMerge(item.Mapping)
+		update := false
+		if f, ok := deployed.Files[item.DestinationPath]; ok && ps.merge && !ps.checkpoint.started(item.ID) {
+			ps.audit.watch(item.DestinationPath, item.Mapping)
+			// Files deployed before are reconciled with what changed on each side since
+			outcome, err := ps.reconcileDeployed(item, f)
+			switch {
+			case err != nil:
+				warnColor.Printf("⚠️  %s: %v\n", item.Description, err)
+			case outcome == mergeUpToDate:
+				if ps.verbose {
+					successColor.Printf("✅ Up to date: %s\n", item.Description)
+				}
+				ps.finishItem(i, itemStarted, itemUpToDate, "")
+				ps.migrationPlan.SkippedItems++
+				skipCount++
+				continue
+			case outcome == mergeKeepLocal:
+				warnColor.Printf("⏭️  Kept local changes: %s\n", item.Description)
+				ps.finishItem(i, itemStarted, itemSkipped, "kept local changes")
+				ps.migrationPlan.SkippedItems++
+				skipCount++
+				continue
+			case outcome == mergeTakeSource:
+				update = true
+			case outcome == mergeMerged || outcome == mergeConflicted:
+				verb := map[bool]string{true: "Would merge", false: "Merged"}[ps.dryRun]
+				if outcome == mergeConflicted {
+					warnColor.Printf("⚠️  %s local and profile changes with conflicts: %s (resolve the markers in %s)\n", verb, item.Description, item.DestinationPath)
+					ps.finishItem(i, itemStarted, itemConflicts, "resolve the markers in the file")
+				} else {
+					successColor.Printf("🔀 %s local and profile changes: %s\n", verb, item.Description)
+					ps.finishItem(i, itemStarted, itemMerged, "")
+				}
+				if !ps.dryRun {
+					ps.migrationPlan.Items[i].Migrated = true
+					if err := deployed.record(item); err != nil {
+						warnColor.Printf("⚠️  Could not record %s for status: %v\n", item.Description, err)
+					}
+				}
+				successCount++
+				continue
+			}
+		}
+		if info, err := os.Stat(item.DestinationPath); err == nil && !update && !ps.checkpoint.started(item.ID) && (strategy == mergeKeep || (strategy == mergeReplace && !ps.force)) {
+			resolution := resolveSkip
+			if strategy == mergeReplace {
+				resolution = ps.resolveConflict(item)
+			}
+			if resolution == resolveSkip {
+				warnColor.Printf("⚠️  Skipped (exists): %s\n", item.Description)
+				ps.finishItem(i, itemStarted, itemSkipped, "exists")
+				ps.migrationPlan.SkippedItems++
+				skipCount++
+				continue
+			}
+			// Only files are backed up; a directory takes in the source's files
+			if resolution == resolveBackup && info.Mode().IsRegular() {
+				if ps.dryRun {
+					noticeColor.Printf("💾 Would back up %s\n", displayPath(item.DestinationPath))
+				} else if backup, err := backupFile(item.DestinationPath); err != nil {
+					errorColor.Printf("❌ Error backing up %s: %v\n", item.DestinationPath, err)
+					ps.failItem(i, itemStarted, err)
+					failCount++
+					if stopped = ps.stopAfterFailure(i); stopped != nil {
+						break
+					}
+					continue
+				} else {
+					noticeColor.Printf("💾 Previous %s saved to %s\n", displayPath(item.DestinationPath), backup)
+				}
+			}
+		}
+		
+		if item.AppMissing {
+			warnColor.Printf("⚠️  %s is not installed on the destination", knownApps[item.App].Name)
+			if hint := ps.installHint(item); hint != "" {
+				warnColor.Printf(" (install with: %s)", hint)
+			}
+			fmt.Println()
+		}
+		
 		// Create parent directory if needed
 		parentDir := filepath.Dir(item.DestinationPath)
 		if ps.dryRun {
 			noticeColor.Printf("📁 Would create directory: %s\n", parentDir)
 		} else {
+			// Before creating them, so undo knows the directories are new
+			ps.audit.watchItem(item)
 			if err := os.MkdirAll(parentDir, 0755); err != nil {
 				errorColor.Printf("❌ Error creating directory %s: %v\n", parentDir, err)
+				ps.failItem(i, itemStarted, err)
 				failCount++
+				if stopped = ps.stopAfterFailure(i); stopped != nil {
+					break
+				}
 				continue
 			}
 		}
 		
 		// Copy file
+		ps.recordDiff(i)
 		if ps.dryRun {
-			successColor.Printf("✅ Would migrate: %s\n", item.Description)
+			if sizes[i] > 0 {
+				successColor.Printf("✅ Would migrate: %s (%s)\n", item.Description, formatSize(sizes[i]))
+			} else {
+				successColor.Printf("✅ Would migrate: %s\n", item.Description)
+			}
+			ps.finishItem(i, itemStarted, itemMigrated, "")
 			successCount++
 		} else {
-			if err := ps.copyFile(item.SourcePath, item.DestinationPath); err != nil {
+			if err := ps.checkpoint.start(item.ID); err != nil {
+				return fmt.Errorf("writing checkpoint: %w", err)
+			}
+			err := ps.trashDisplaced(item)
+			if err != nil {
+				err = fmt.Errorf("putting the files it replaces in the trash: %w", err)
+			} else {
+				err = ps.copyItemTimed(ctx, item)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					ps.markStopped(i, item)
+					interrupted = ctx.Err()
+					break
+				}
 				errorColor.Printf("❌ Error migrating %s: %v\n", item.Description, err)
+				ps.failItem(i, itemStarted, err)
 				failCount++
+				if stopped = ps.stopAfterFailure(i); stopped != nil {
+					break
+				}
 				continue
 			}
+			if err := ps.checkpoint.complete(item.ID, item.DestinationPath); err != nil {
+				return fmt.Errorf("writing checkpoint: %w", err)
+			}
 			successColor.Printf("✅ Migrated: %s\n", item.Description)
+			ps.migrationPlan.Items[i].Migrated = true
+			ps.finishItem(i, itemStarted, itemMigrated, "")
 			successCount++
+			if err := deployed.record(item); err != nil {
+				warnColor.Printf("⚠️  Could not record %s for status: %v\n", item.Description, err)
+			}
+			if err := ps.recordPermissions(ctx, item); err != nil && ps.verbose {
+				warnColor.Printf("⚠️  Could not compare permissions for %s: %v\n", item.Description, err)
+			}
+			
+			// Stop for a look once the canary items are in place
+			if ps.canary > 0 && len(canary) < ps.canary {
+				canary = append(canary, item)
+				if len(canary) == ps.canary && i+1 < len(ps.migrationPlan.Items) && !ps.continueAfterCanary(canary) {
+					ps.markStopped(i+1, ps.migrationPlan.Items[i+1])
+					paused = true
+					break
+				}
+			}
+		}
+	}
+	ps.progress.finish()
+	ps.progress = nil
+	
+	if !ps.dryRun && successCount > 0 {
+		if err := deployed.save(); err != nil {
+			warnColor.Printf("⚠️  Could not save deploy state: %v\n", err)
 		}
-		
-		// Print progress
-		fmt.Printf("\r📊 Progress: %d/%d", i+1, len(ps.migrationPlan.Items))
 	}
 	
-	fmt.Println()
+	// Keep the checkpoint around after failures so --resume can retry them
+	if paused {
+		// The checkpoint stays for --resume to carry on after the canary
+	} else if interrupted != nil {
+		if ps.checkpoint != nil {
+			warnColor.Println("⚠️  Migration interrupted. Run `profilesync apply --resume` to continue.")
+		}
+	} else if failCount == 0 {
+		if err := ps.checkpoint.remove(); err != nil {
+			warnColor.Printf("⚠️  Could not remove checkpoint: %v\n", err)
+		}
+	} else if ps.checkpoint != nil {
+		warnColor.Println("⚠️  Some items failed. Run `profilesync apply --resume` to retry them.")
+	}
 	
 	ps.migrationPlan.TotalItems = successCount + failCount + skipCount
 	
+	if interrupted == nil {
+		return stopped
+	}
+	return interrupted
+}
+
+// markStopped records the item an interrupted migration stopped at
+func (ps *ProfileSync) markStopped(index int, item MigrationItem) {
+	ps.migrationPlan.Interrupted = true
+	ps.migrationPlan.StoppedAt = item.Description
+	ps.migrationPlan.StoppedIndex = index
+}
+
+// copyFile copies a file from source to destination, preferring a
+// copy-on-write clone when both sides share a filesystem that supports it.
+// The copy is written to a temp file next to the destination and renamed
+// over it once complete, so an existing config is never left truncated.
+// Progress is recorded in the checkpoint so an interrupted copy of a large
+// file resumes where it stopped
+func (ps *ProfileSync) copyFile(ctx context.Context, src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	
+	// A symlinked destination is written through; one linking to the
+	// source is already up to date
+	written := writeTarget(dst)
+	if dstInfo, err := os.Stat(written); err == nil && os.SameFile(info, dstInfo) {
+		return nil
+	}
+	ps.audit.watchFile(dst)
+	dst = written
+	
+	// Text from the Windows side of WSL loses its CRLF line endings, which
+	// shells and most Linux tools choke on
+	if ps.sourceDrvFs && !ps.destDrvFs {
+		if data, ok := lfText(src, info); ok {
+			if err := writeFileAtomic(dst, data, ps.fileMode(src, info.Mode()).Perm()); err != nil {
+				return err
+			}
+			metrics.filesCopied.Add(1)
+			metrics.copied(int64(len(data)))
+			return nil
+		}
+	}
+	
+	offset, done := ps.checkpoint.resumeOffset(dst, info.Size())
+	if done {
+		return nil
+	}
+	ps.progress.setFile(src)
+	tmp := tempPath(dst)
+	
+	if offset == 0 {
+		if err := cloneFile(src, tmp); err == nil {
+			if err := os.Chmod(tmp, ps.fileMode(src, info.Mode()).Perm()); err != nil {
+				os.Remove(tmp)
+				return err
+			}
+			ps.copyMetadata(src, tmp, dst)
+			if err := os.Rename(tmp, dst); err != nil {
+				os.Remove(tmp)
+				return err
+			}
+			metrics.filesCopied.Add(1)
+			metrics.bytesCloned.Add(info.Size())
+			return ps.checkpoint.fileProgress(dst, info.Size())
+		}
+	}
+	
+	if err := ps.copyToTemp(ctx, src, tmp, dst, info, offset); err != nil {
+		// Without a checkpoint nothing can resume the partial copy
+		if ps.checkpoint == nil {
+			os.Remove(tmp)
+		}
+		return err
+	}
+	ps.copyMetadata(src, tmp, dst)
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	metrics.filesCopied.Add(1)
 	return nil
 }
 
-// copyFile copies a file from source to destination
-func (ps *ProfileSync) copyFile(src, dst string) error {
+// copyToTemp copies src into tmp, the temp file of dst, from offset on,
+// and flushes it to disk
+func (ps *ProfileSync) copyToTemp(ctx context.Context, src, tmp, dst string, info fs.FileInfo, offset int64) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer sourceFile.Close()
 	
-	destinationFile, err := os.Create(dst)
+	destinationFile, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		return err
 	}
 	defer destinationFile.Close()
-
 	
-	_, err = bufio.NewReader(sourceFile).WriteTo(destinationFile)
-	return err
+	// Keep the source's permissions so private keys stay private
+	if err := destinationFile.Chmod(ps.fileMode(src, info.Mode()).Perm()); err != nil {
+		return err
+	}
+	
+	// Drop anything past the last recorded offset and continue from there
+	if err := destinationFile.Truncate(offset); err != nil {
+		return err
+	}
+	if offset > 0 {
+		if ps.verbose {
+			noticeColor.Printf("↪️  Resuming %s at %d bytes\n", dst, offset)
+		}
+		if _, err := sourceFile.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := destinationFile.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	
+	if ps.checkpoint == nil {
+		n, err := bufio.NewReader(ctxReader{ctx, sourceFile}).WriteTo(destinationFile)
+		metrics.copied(n)
+		if err != nil {
+			return err
+		}
+		return destinationFile.Sync()
+	}
+	
+	written := offset
+	for {
+		n, err := io.CopyN(destinationFile, sourceFile, resumeChunkSize)
+		written += n
+		metrics.copied(n)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// Only record bytes that have reached the disk
+		if syncErr := destinationFile.Sync(); syncErr != nil {
+			return syncErr
+		}
+		if cpErr := ps.checkpoint.fileProgress(dst, written); cpErr != nil {
+			return cpErr
+		}
+		if err == io.EOF {
+			return nil
+		}
+		// Stop between chunks; the progress just recorded lets --resume continue
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+	}
+}
+
+// ctxReader stops a copy between reads once ctx is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
 }
 
 // PrintReport prints a migration report
@@ -398,10 +809,23 @@ func (ps *ProfileSync) PrintReport() {
 	noticeColor.Printf("Source Platform:   %s\n", ps.sourcePlatform)
 	noticeColor.Printf("Destination:       %s\n", ps.destPlatform)
 	noticeColor.Printf("Mode:              %s\n", map[bool]string{true: "DRY RUN", false: "LIVE"}[ps.dryRun])
+	if ps.migrationPlan.Interrupted {
+		warnColor.Printf("Stopped At:        %s (item %d/%d)\n", ps.migrationPlan.StoppedAt, ps.migrationPlan.StoppedIndex+1, len(ps.migrationPlan.Items))
+	}
 	
-	successColor.Printf("✅ Successfully migrated: %d\n", ps.migrationPlan.TotalItems-ps.migrationPlan.SkippedItems)
+	failed := 0
+	for _, item := range ps.migrationPlan.Items {
+		if item.Status == itemFailed {
+			failed++
+		}
+	}
+	successColor.Printf("✅ Successfully migrated: %d\n", ps.migrationPlan.TotalItems-ps.migrationPlan.SkippedItems-failed)
 	warnColor.Printf("⏭️  Skipped:           %d\n", ps.migrationPlan.SkippedItems)
-	errorColor.Printf("❌ Failed:            0")
+	if failed > 0 {
+		errorColor.Printf("❌ Failed:            %d (%s)\n", failed, failureClasses(ps.migrationPlan.Items))
+	} else {
+		errorColor.Println("❌ Failed:            0")
+	}
 	
 	infoColor.Println(strings.Repeat("=", 60))
 	
@@ -428,7 +852,26 @@ func (ps *ProfileSync) PrintReport() {
 	
 	infoColor.Println(strings.Repeat("=", 60))
 	
-	if ps.dryRun {
+	var secrets []string
+	for _, item := range ps.migrationPlan.Items {
+		if item.Sensitivity != sensitivitySecret {
+			continue
+		}
+		if _, err := os.Stat(item.SourcePath); item.Migrated || (ps.dryRun && item.AutoMigrate && err == nil) {
+			secrets = append(secrets, item.Description)
+		}
+	}
+	if len(secrets) > 0 {
+		warnColor.Printf("🔐 Secrets %s: %s\n", map[bool]string{true: "to migrate", false: "migrated"}[ps.dryRun], strings.Join(secrets, ", "))
+	}
+	
+	ps.printPermissionsReport()
+	ps.printShellNotes()
+	printResources(metrics.summary(ps.started), ps.jobs)
+	
+	if ps.migrationPlan.Interrupted {
+		warnColor.Println("⛔ Migration was interrupted before all items were processed.")
+	} else if ps.dryRun {
 		warnColor.Println("⚠️  This was a DRY RUN. No files were actually migrated.")
 		warnColor.Println("Run without --dry-run to perform the actual migration.")
 	} else {
@@ -437,53 +880,492 @@ func (ps *ProfileSync) PrintReport() {
 }
 
 func main() {
+	args := os.Args[1:]
+	command := "apply"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	logCommand = command
+	setupOutput()
+	runCommand(command, args)
+}
+
+// runCommand runs a command with the arguments after its name
+func runCommand(command string, args []string) {
+	switch command {
+	case "adopt":
+		runAdopt(args)
+	case "audit":
+		runAudit(args)
+	case "auth":
+		runAuth(args)
+	case "apply":
+		if len(args) > 0 {
+			switch args[0] {
+			case "packages":
+				runApplyPackages(args[1:])
+				return
+			case "extensions":
+				runApplyExtensions(args[1:])
+				return
+			case "defaults":
+				runApplyDefaults(args[1:])
+				return
+			case "dconf":
+				runApplyDconf(args[1:])
+				return
+			case "registry":
+				runApplyRegistry(args[1:])
+				return
+			case "tasks":
+				runApplyTasks(args[1:])
+				return
+			case "gpg":
+				runApplyGPG(args[1:])
+				return
+			case "secrets":
+				runApplySecrets(args[1:])
+				return
+			case "plugins":
+				runApplyPlugins(args[1:])
+				return
+			}
+		}
+		runApply(args)
+	case "bootstrap":
+		runBootstrap(args)
+	case "capture":
+		runCapture(args)
+	case "catalog":
+		runCatalog(args)
+	case "checklist":
+		runChecklist(args)
+	case "clone":
+		runClone(args)
+	case "completion":
+		runCompletion(args)
+	case "convert":
+		runConvert(args)
+	case "daemon":
+		runDaemon(args)
+	case "decisions":
+		runDecisions(args)
+	case "discover":
+		runDiscover(args)
+	case "export":
+		runExport(args)
+	case "history":
+		runHistory(args)
+	case "import":
+		runImport(args)
+	case "init":
+		runInit(args)
+	case "machines":
+		runMachines(args)
+	case "mappings":
+		runMappings(args)
+	case "man":
+		runMan(args)
+	case "prune":
+		runPrune(args)
+	case "receive":
+		runReceive(args)
+	case "service":
+		runService(args)
+	case "snapshot":
+		runSnapshot(args)
+	case "state":
+		runState(args)
+	case "send":
+		runSend(args)
+	case "status":
+		runStatus(args)
+	case "sync":
+		runSync(args)
+	case "test":
+		runTest(args)
+	case "trust":
+		runTrust(args)
+	case "undo":
+		runUndo(args)
+	case "vet":
+		runVet(args)
+	case completeCommand:
+		runComplete(args)
+	default:
+		errorColor.Println("❌ Unknown command:", command)
+		errorColor.Println("Must be one of: adopt, apply, audit, auth, bootstrap, capture, catalog, checklist, clone, completion, convert, daemon, decisions, discover, export, history, import, init, machines, man, mappings, prune, receive, send, service, snapshot, state, status, sync, test, trust, undo, vet")
+		os.Exit(1)
+	}
+}
+
+// runApply plans and executes a migration; it is also the default command
+func runApply(args []string) {
+	flags := newFlagSet("apply", "[apply] [flags]")
+	
 	// Define flags
-	sourcePlatform := flag.String("source", DetectPlatform(), "Source platform (linux, macos, windows)")
-	destPlatform := flag.String("dest", DetectPlatform(), "Destination platform (linux, macos, windows)")
-	dryRun := flag.Bool("dry-run", true, "Preview migration without making changes")
-	force := flag.Bool("force", false, "Overwrite existing files")
-	verbose := flag.Bool("verbose", false, "Verbose output")
-	showHelp := flag.Bool("help", false, "Show help message")
+	sourcePlatform := flags.String("source", DetectPlatform(), "Source platform (linux, macos, windows, wsl)")
+	destPlatform := flags.String("dest", DetectPlatform(), "Destination platform (linux, macos, windows, wsl)")
+	dryRun := flags.Bool("dry-run", true, "Preview migration without making changes")
+	force := flags.Bool("force", false, "Overwrite existing files")
+	verbose := flags.Bool("verbose", false, "Verbose output")
+	jobs := flags.Int("jobs", runtime.NumCPU(), "Number of parallel workers for scanning and copying")
+	resume := flags.Bool("resume", false, "Continue an interrupted migration from its checkpoint (implies --dry-run=false)")
+	sourceDir := flags.String("source-dir", "", "Migrate from this directory instead of the source platform's home (e.g. an imported backup)")
+	skipMissingApps := flags.Bool("skip-missing-apps", false, "Leave out settings for applications not installed on the destination")
+	untrusted := flags.Bool("untrusted", false, "Vet the source for executables, setuid bits, shell rc additions and hooks, and ask before migrating them")
+	kubeContexts := flags.String("kube-contexts", "", "Comma-separated kube contexts to merge into the destination kubeconfig (default: ask, or all)")
+	awsProfiles := flags.String("aws-profiles", "", "Comma-separated AWS profiles to merge into the destination's ~/.aws files (default: ask, or all)")
+	canary := flags.Int("canary", 0, "Migrate only the first N items, then pause for you to check them before continuing")
+	canaryRandom := flags.Bool("canary-random", false, "Pick the --canary items at random instead of taking the first N")
+	askAgain := flags.Bool("ask-again", false, "Ignore answers remembered from earlier runs on this machine and ask again")
+	translateShell := flags.String("translate-shell", "", "Translate aliases, exports and PATH additions between shells, e.g. bash:fish")
+	configFile := flags.String("config", "", "Config file with mappings and policies (default "+configPath()+" if it exists)")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config to apply, e.g. work or personal")
+	merge := flags.Bool("merge", true, "Three-way merge files changed both locally and in the source since they were deployed (set MERGE_TOOL to resolve conflicts)")
+	report := flags.String("report", "", "Also write a report of the run with each item's status, size, hash and changes to this .html or .md file")
+	spaceCheck := flags.String("space-check", spaceCheckFail, "When the destination lacks space for the estimated size: fail before copying, warn, or off")
+	symlinks := flags.String("symlinks", symlinkFollow, "Symlinks in the source: follow and copy their targets, preserve them as links, or skip them with a warning")
+	itemTimeout := flags.Duration("item-timeout", 0, "Give up on an item whose copy takes longer than this, e.g. 2m, and go on with the rest (0 for no limit)")
+	yes := flags.Bool("yes", false, "Overwrite files that already exist without asking, backing up each one first")
+	trash := flags.Bool("trash", false, "Put a copy of each file that is overwritten in the OS trash or Recycle Bin first")
+	noInput := flags.Bool("no-input", false, "Never ask questions; existing files are skipped unless --yes or --force is given")
+	onError := flags.String("on-error", onErrorContinue, "After an item fails: continue with the rest, abort the run, or prompt whether to continue")
+	tags := flags.String("tags", "", "Comma-separated tags; only migrate configs with one of them, e.g. minimal")
+	skipTags := flags.String("skip-tags", "", "Comma-separated tags; leave out configs with any of them, e.g. gaming")
+	sshPublicOnly := flags.Bool("ssh-public-only", false, "Migrate the ssh config and public keys but leave private keys behind")
+	xattrs := flags.Bool("xattrs", true, "Copy extended attributes, ACLs and macOS metadata such as Finder tags and resource forks (never quarantine flags)")
+	windowsNames := flags.String("windows-names", windowsNamesEscape, "Names a Windows destination cannot hold, like aux or con: escape them as %XX, or skip them")
+	users := flags.String("user", "", "Comma-separated local users whose profiles to migrate, each into their own home (needs root or Administrator)")
+	allUsers := flags.Bool("all-users", false, "Migrate the profile of every local user with a home directory (needs root or Administrator)")
+	showHelp := flags.Bool("help", false, "Show help message")
 	
-	flag.Parse()
+	flags.Parse(args)
 	
 	if *showHelp {
-		flag.Usage()
+		flags.Usage()
 		return
 	}
 	
+	// Policies from the config only fill in flags not given on the command line
+	var cfg *resolvedConfig
+	if *configFile == "" {
+		if _, err := os.Stat(configPath()); err == nil {
+			*configFile = configPath()
+		}
+	}
+	if *profile != "" && *configFile == "" {
+		errorColor.Println("❌ --profile needs a config file that defines profiles (none found at " + configPath() + ")")
+		os.Exit(1)
+	}
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile, *profile); err != nil {
+			errorColor.Println("❌ Error loading config:", err)
+			os.Exit(1)
+		}
+		if err := cfg.applyPolicies(flags); err != nil {
+			errorColor.Println("❌ Error in config:", err)
+			os.Exit(1)
+		}
+	}
+	
 	// Validate platforms
-	validPlatforms := map[string]bool{"linux": true, "macos": true, "windows": true}
+	validPlatforms := map[string]bool{"linux": true, "macos": true, "windows": true, platformWSL: true}
 	if !validPlatforms[*sourcePlatform] {
 		errorColor.Println("❌ Invalid source platform:", *sourcePlatform)
-		errorColor.Println("Must be one of: linux, macos, windows")
+		errorColor.Println("Must be one of: linux, macos, windows, wsl")
 		os.Exit(1)
 	}
 	if !validPlatforms[*destPlatform] {
 		errorColor.Println("❌ Invalid destination platform:", *destPlatform)
-		errorColor.Println("Must be one of: linux, macos, windows")
+		errorColor.Println("Must be one of: linux, macos, windows, wsl")
+		os.Exit(1)
+	}
+	if (*sourcePlatform == platformWSL || *destPlatform == platformWSL) && !inWSL() {
+		errorColor.Println("❌ The wsl platform is only available inside WSL")
+		os.Exit(1)
+	}
+	
+	if *jobs < 1 {
+		errorColor.Println("❌ Invalid --jobs value:", *jobs)
+		errorColor.Println("Must be at least 1")
+		os.Exit(1)
+	}
+	
+	if *translateShell != "" {
+		if _, _, err := parseShellTranslation(*translateShell); err != nil {
+			errorColor.Println("❌ Invalid --translate-shell value:", err)
+			os.Exit(1)
+		}
+	}
+	
+	if err := validSymlinkPolicy(*symlinks); err != nil {
+		errorColor.Println("❌ Invalid --symlinks value:", *symlinks)
+		errorColor.Println("Must be one of: follow, preserve, skip")
 		os.Exit(1)
 	}
 	
+	if *users != "" || *allUsers {
+		if *resume {
+			errorColor.Println("❌ --resume cannot be combined with --user or --all-users")
+			os.Exit(1)
+		}
+		if !*dryRun && !elevated() {
+			errorColor.Println("❌ Migrating other users' profiles needs root or Administrator, to read their files and give them the copies")
+			os.Exit(1)
+		}
+	}
+	
+	if *windowsNames != windowsNamesEscape && *windowsNames != windowsNamesSkip {
+		errorColor.Println("❌ Invalid --windows-names value:", *windowsNames)
+		errorColor.Println("Must be one of: escape, skip")
+		os.Exit(1)
+	}
+	
+	switch *spaceCheck {
+	case spaceCheckFail, spaceCheckWarn, spaceCheckOff:
+	default:
+		errorColor.Println("❌ Invalid --space-check value:", *spaceCheck)
+		errorColor.Println("Must be one of: fail, warn, off")
+		os.Exit(1)
+	}
+	
+	if *itemTimeout < 0 {
+		errorColor.Println("❌ Invalid --item-timeout value:", *itemTimeout)
+		os.Exit(1)
+	}
+	
+	switch *onError {
+	case onErrorContinue, onErrorAbort, onErrorPrompt:
+	default:
+		errorColor.Println("❌ Invalid --on-error value:", *onError)
+		errorColor.Println("Must be one of: continue, abort, prompt")
+		os.Exit(1)
+	}
+	
+	if *report != "" {
+		if err := checkReportPath(*report); err != nil {
+			errorColor.Println("❌ Invalid --report value:", err)
+			os.Exit(1)
+		}
+	}
+	
+	if *resume {
+		if flagWasSet(flags, "dry-run") && *dryRun {
+			errorColor.Println("❌ --resume cannot be combined with --dry-run")
+			os.Exit(1)
+		}
+		*dryRun = false
+	}
+	
+	if *canary < 0 {
+		errorColor.Println("❌ Invalid --canary value:", *canary)
+		errorColor.Println("Must be at least 1")
+		os.Exit(1)
+	}
+	if *canary > 0 {
+		if flagWasSet(flags, "dry-run") && *dryRun {
+			errorColor.Println("❌ --canary cannot be combined with --dry-run")
+			os.Exit(1)
+		}
+		*dryRun = false
+	}
+	
 	// Create profile sync instance
-	ps := NewProfileSync(*sourcePlatform, *destPlatform, *dryRun, *force, *verbose)
+	ps := NewProfileSync(layoutPlatform(*sourcePlatform), layoutPlatform(*destPlatform), *dryRun, *force, *verbose, *resume, *untrusted, *jobs)
+	if *kubeContexts != "" {
+		ps.kubeContexts = strings.Split(*kubeContexts, ",")
+	}
+	if *awsProfiles != "" {
+		ps.awsProfiles = strings.Split(*awsProfiles, ",")
+	}
+	ps.translateShell = *translateShell
+	ps.canary, ps.canaryRandom = *canary, *canaryRandom
+	ps.askAgain = *askAgain
+	ps.merge = *merge
+	ps.spaceCheck = *spaceCheck
+	ps.timeout, ps.onError = *itemTimeout, *onError
+	ps.assumeYes, ps.noInput = *yes, *noInput
+	ps.trash = *trash
+	ps.tags, ps.skipTags = splitList(*tags), splitList(*skipTags)
+	ps.sshPublicOnly = *sshPublicOnly
+	ps.symlinks = *symlinks
+	ps.xattrs = *xattrs
+	ps.windowsNames = *windowsNames
+	ignoreFile := globalIgnorePath(*configFile)
+	ignore, err := loadIgnoreFile(ignoreFile)
+	if err != nil {
+		errorColor.Println("❌ Error reading ignore file:", err)
+		os.Exit(1)
+	}
+	ps.ignore = ignore
+	if *verbose && len(ignore) > 0 {
+		noticeColor.Printf("🙈 Ignore file: %s (%d patterns)\n", ignoreFile, len(ignore))
+	}
+	if cfg != nil {
+		ps.mappings = cfg.Mappings
+		ps.edits = cfg.editsFor(*destPlatform)
+		ps.transforms, ps.variables = cfg.Transforms, cfg.Variables
+		ps.plugins = cfg.Plugins
+		ps.secretStore = cfg.SecretStore
+		if *verbose {
+			noticeColor.Printf("⚙️  Config: %s\n", strings.Join(cfg.Files, " → "))
+		}
+		if len(cfg.Profiles) > 0 {
+			noticeColor.Printf("👤 Profile: %s\n", strings.Join(cfg.Profiles, " → "))
+		}
+	}
 	
 	// Get home directories
 	sourceHome := GetHomeDir(*sourcePlatform)
+	if *sourceDir != "" {
+		// Absolute, so Windows accepts paths below it longer than MAX_PATH
+		if abs, err := filepath.Abs(*sourceDir); err == nil {
+			sourceHome = abs
+		} else {
+			sourceHome = *sourceDir
+		}
+	}
 	destHome := GetHomeDir(*destPlatform)
+	if inWSL() {
+		ps.sourceDrvFs, ps.destDrvFs = isDrvFs(sourceHome), isDrvFs(destHome)
+		if ps.sourceDrvFs || ps.destDrvFs {
+			infoColor.Printf("🪟 WSL: Linux home %s, Windows home %s\n", GetHomeDir(platformWSL), GetHomeDir("windows"))
+		}
+	}
+	
+	ctx, cancel := interruptContext()
+	defer cancel()
+	
+	if *users != "" || *allUsers {
+		dir := ""
+		if *sourceDir != "" {
+			dir = sourceHome
+		}
+		selected, err := resolveUsers(splitList(*users), *allUsers, layoutPlatform(*destPlatform), dir)
+		if err != nil {
+			errorColor.Println("❌ Error finding users:", err)
+			os.Exit(1)
+		}
+		if !ps.migrateUsers(ctx, selected, *skipMissingApps, *report) {
+			os.Exit(1)
+		}
+		return
+	}
 	
 	// Create migration plan
-	if err := ps.CreateMigrationPlan(sourceHome, destHome); err != nil {
+	if err := ps.CreateMigrationPlan(ctx, sourceHome, destHome); err != nil {
+		logger().Error("planning failed", "error", err)
 		errorColor.Println("❌ Error creating migration plan:", err)
 		os.Exit(1)
 	}
+	ps.MarkMissingApps(destHome, *skipMissingApps)
 	
 	// Execute migration
-	if err := ps.ExecuteMigration(sourceHome, destHome); err != nil {
+	logger().Info("migration started", "source_platform", *sourcePlatform, "destination_platform", *destPlatform,
+		"source", sourceHome, "destination", destHome, "items", len(ps.migrationPlan.Items), "dry_run", *dryRun)
+	started := time.Now()
+	err = ps.ExecuteMigration(ctx, sourceHome, destHome)
+	if err == nil {
+		ps.applyTransforms()
+		ps.applyEdits()
+		ps.applyMachineOverrides(profileDir())
+		ps.applySecretRefs()
+		ps.applySecretStore(profileDir())
+	}
+	ps.recordRun(started, sourceHome, destHome, err)
+	if !*dryRun {
+		if err := recordMachineSync(profileDir(), *sourcePlatform, *profile); err != nil {
+			warnColor.Println("⚠️  Could not update the machine registry:", err)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			ps.logFinished(slog.LevelWarn, "migration interrupted", started, err)
+			ps.PrintReport()
+			ps.writeReport(*report, started, sourceHome, destHome)
+			os.Exit(130)
+		}
+		ps.logFinished(slog.LevelError, "migration failed", started, err)
+		if errors.Is(err, errStoppedOnError) {
+			ps.PrintReport()
+		}
 		errorColor.Println("❌ Error during migration:", err)
+		ps.writeReport(*report, started, sourceHome, destHome)
 		os.Exit(1)
 	}
 	
 	// Print report
+	ps.logFinished(slog.LevelInfo, "migration finished", started, nil)
 	ps.PrintReport()
-}
\ No newline at end of file
+	ps.writeReport(*report, started, sourceHome, destHome)
+	if !*dryRun {
+		ps.writeChecklist()
+	}
+}
+
+// interruptContext returns a context cancelled on the first SIGINT or
+// SIGTERM. Later signals get the default behaviour, so a second Ctrl-C
+// terminates immediately
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			fmt.Println()
+			warnColor.Println("⛔ Interrupted, finishing the file in flight (press Ctrl-C again to abort immediately)")
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(signals)
+		}
+	}()
+	
+	return ctx, cancel
+}
+
+// newFlagSet creates a flag set for a subcommand with a usage line
+func newFlagSet(name, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: profilesync %s\n", usage)
+		flags.PrintDefaults()
+	}
+	if describing {
+		// Hand the flags to commandFlags instead of running the command
+		flags.Init(name, flag.PanicOnError)
+		flags.SetOutput(io.Discard)
+		describedFlags, describedUsage = flags, usage
+	}
+	addOutputFlags(flags)
+	logEnvDefaults()
+	addLogFlags(flags)
+	return flags
+}
+
+// flagWasSet reports whether a flag was given explicitly on the command line
+func flagWasSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsService is the DNS-SD service type profilesync advertises
+const mdnsService = "_profilesync._tcp.local."
+
+// mdnsGroup is the IPv4 multicast address and port of mDNS
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsPeer is a profilesync instance found on the local network
+type mdnsPeer struct {
+	Addr string
+	TXT  map[string]string
+}
+
+// mdnsHostname is this machine's name in .local, reduced to what DNS labels allow
+func mdnsHostname() string {
+	var b strings.Builder
+	for _, r := range machineName() {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			b.WriteRune(r)
+		case r == '.':
+			// Keep only the first label of a fully qualified name
+			return b.String() + ".local."
+		default:
+			b.WriteRune('-')
+		}
+	}
+	return b.String() + ".local."
+}
+
+// localIPv4s lists the addresses other machines can reach this one on,
+// falling back to loopback when there is no network
+func localIPv4s() []net.IP {
+	var ips []net.IP
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil && !n.IP.IsLoopback() && !n.IP.IsLinkLocalUnicast() {
+			ips = append(ips, n.IP.To4())
+		}
+	}
+	if len(ips) == 0 {
+		ips = append(ips, net.IPv4(127, 0, 0, 1).To4())
+	}
+	return ips
+}
+
+// mdnsResponse builds the answer to a browse for profilesync: the service
+// instance with where it listens and its TXT record
+func mdnsResponse(id uint16, instance string, port int, txt []string) ([]byte, error) {
+	service := dnsmessage.MustNewName(mdnsService)
+	name, err := dnsmessage.NewName(instance + "." + mdnsService)
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(mdnsHostname())
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	hdr := func(n dnsmessage.Name, t dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: n, Type: t, Class: dnsmessage.ClassINET, TTL: 120}
+	}
+	if err := b.PTRResource(hdr(service, dnsmessage.TypePTR), dnsmessage.PTRResource{PTR: name}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(hdr(name, dnsmessage.TypeSRV), dnsmessage.SRVResource{Target: host, Port: uint16(port)}); err != nil {
+		return nil, err
+	}
+	if err := b.TXTResource(hdr(name, dnsmessage.TypeTXT), dnsmessage.TXTResource{TXT: txt}); err != nil {
+		return nil, err
+	}
+	for _, ip := range localIPv4s() {
+		var a [4]byte
+		copy(a[:], ip)
+		if err := b.AResource(hdr(host, dnsmessage.TypeA), dnsmessage.AResource{A: a}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// mdnsAdvertise answers mDNS browses for profilesync until ctx is done
+func mdnsAdvertise(ctx context.Context, instance string, port int, txt []string) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		var p dnsmessage.Parser
+		hdr, err := p.Start(buf[:n])
+		if err != nil || hdr.Response {
+			continue
+		}
+		questions, err := p.AllQuestions()
+		if err != nil {
+			continue
+		}
+		for _, q := range questions {
+			if !strings.EqualFold(q.Name.String(), mdnsService) || (q.Type != dnsmessage.TypePTR && q.Type != dnsmessage.TypeALL) {
+				continue
+			}
+			resp, err := mdnsResponse(hdr.ID, instance, port, txt)
+			if err != nil {
+				return err
+			}
+			// Browsers asking from another port than 5353 only hear unicast
+			if from.Port == mdnsGroup.Port {
+				conn.WriteToUDP(resp, mdnsGroup)
+			} else {
+				conn.WriteToUDP(resp, from)
+			}
+			break
+		}
+	}
+}
+
+// mdnsBrowse asks the local network for profilesync instances until one
+// matches or ctx is done
+func mdnsBrowse(ctx context.Context, match func(mdnsPeer) bool) (mdnsPeer, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return mdnsPeer{}, err
+	}
+	defer conn.Close()
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(mdnsService), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+	query, err := b.Finish()
+	if err != nil {
+		return mdnsPeer{}, err
+	}
+
+	buf := make([]byte, 9000)
+	for {
+		if err := ctx.Err(); err != nil {
+			return mdnsPeer{}, err
+		}
+		if _, err := conn.WriteToUDP(query, mdnsGroup); err != nil {
+			return mdnsPeer{}, err
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			var timeout net.Error
+			if errors.As(err, &timeout) && timeout.Timeout() {
+				break
+			} else if err != nil {
+				return mdnsPeer{}, err
+			}
+			if peer, ok := parseMDNSPeer(buf[:n], from); ok && match(peer) {
+				return peer, nil
+			}
+		}
+	}
+}
+
+// parseMDNSPeer reads the instance an mDNS response describes
+func parseMDNSPeer(msg []byte, from *net.UDPAddr) (mdnsPeer, bool) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return mdnsPeer{}, false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return mdnsPeer{}, false
+	}
+	var resources []dnsmessage.Resource
+	for _, section := range []func() ([]dnsmessage.Resource, error){p.AllAnswers, p.AllAuthorities, p.AllAdditionals} {
+		rs, err := section()
+		if err != nil {
+			return mdnsPeer{}, false
+		}
+		resources = append(resources, rs...)
+	}
+
+	peer := mdnsPeer{TXT: map[string]string{}}
+	var port uint16
+	var ip net.IP
+	for _, r := range resources {
+		switch body := r.Body.(type) {
+		case *dnsmessage.SRVResource:
+			if strings.HasSuffix(strings.ToLower(r.Header.Name.String()), mdnsService) {
+				port = body.Port
+			}
+		case *dnsmessage.TXTResource:
+			for _, kv := range body.TXT {
+				if k, v, ok := strings.Cut(kv, "="); ok {
+					peer.TXT[k] = v
+				}
+			}
+		case *dnsmessage.AResource:
+			if ip == nil {
+				ip = net.IP(body.A[:])
+			}
+		}
+	}
+	if port == 0 {
+		return mdnsPeer{}, false
+	}
+	if ip == nil || ip.IsLoopback() {
+		// The address the answer came from reaches the sender too
+		ip = from.IP
+	}
+	peer.Addr = net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	return peer, true
+}
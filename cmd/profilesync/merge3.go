@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// mergeMaxSize is the largest file kept as a merge base and merged
+const mergeMaxSize = 1 << 20
+
+// mergeMaxCells bounds the line diff table so huge files are not diffed
+const mergeMaxCells = 25_000_000
+
+// Outcomes of reconciling a deployed file with its source
+const (
+	mergeNotTracked = iota
+	mergeUpToDate
+	mergeKeepLocal
+	mergeTakeSource
+	mergeMerged
+	mergeConflicted
+)
+
+// basesDir holds the content of deployed files, by hash, as merge bases
+func basesDir() string {
+	return filepath.Join(stateDir(), "bases")
+}
+
+// saveBase keeps the content of a deployed file as a future merge base
+func saveBase(hash, path string) error {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > mergeMaxSize {
+		return err
+	}
+	target := filepath.Join(basesDir(), hash)
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(target, data, 0600)
+}
+
+// pruneBases removes merge bases that neither the deploy state nor the
+// state of any sync refers to any more
+func pruneBases() {
+	entries, err := os.ReadDir(basesDir())
+	if err != nil {
+		return
+	}
+	used := map[string]bool{}
+	deployed, err := loadDeployState()
+	if err != nil {
+		return
+	}
+	for _, f := range deployed.Files {
+		used[f.Hash] = true
+	}
+	peers, _ := os.ReadDir(syncStateDir())
+	for _, p := range peers {
+		state, err := loadSyncState(strings.TrimSuffix(p.Name(), ".json"))
+		if err != nil {
+			// Keep everything rather than lose a base still in use
+			return
+		}
+		for _, hash := range state.Files {
+			used[hash] = true
+		}
+	}
+	for _, e := range entries {
+		if !used[e.Name()] {
+			os.Remove(filepath.Join(basesDir(), e.Name()))
+		}
+	}
+}
+
+// splitLines splits text into lines, each keeping its line ending
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// matchLines pairs up the lines of a longest common subsequence of a and b,
+// returning for each line of a the index of its partner in b, or -1
+func matchLines(a, b []string) []int {
+	match := make([]int, len(a))
+	for i := range match {
+		match[i] = -1
+	}
+	// Common leading and trailing lines need no table
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		match[start] = start
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+		match[endA] = endB
+	}
+
+	n, m := endA-start, endB-start
+	if n == 0 || m == 0 {
+		return match
+	}
+	// lcs[i][j] is the LCS length of a[start+i:endA] and b[start+j:endB]
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[start+i] == b[start+j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case a[start+i] == b[start+j]:
+			match[start+i] = start + j
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return match
+}
+
+// sameLines reports whether two line slices are equal
+func sameLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// merge3 merges the changes local and other made to base, line by line, the
+// way diff3 does. Regions both sides changed differently become conflicts
+// marked git-style, with the base between the two sides; conflicts counts them
+func merge3(base, local, other []string, localLabel, otherLabel string) (merged []string, conflicts int) {
+	toLocal := matchLines(base, local)
+	toOther := matchLines(base, other)
+
+	o, a, b := 0, 0, 0
+	for {
+		// Copy lines unchanged on both sides
+		for o < len(base) && toLocal[o] == a && toOther[o] == b {
+			merged = append(merged, base[o])
+			o, a, b = o+1, a+1, b+1
+		}
+		// Find the next base line both sides kept; everything before it
+		// changed on at least one side
+		next, nextA, nextB := len(base), len(local), len(other)
+		for i := o; i < len(base); i++ {
+			if toLocal[i] >= 0 && toOther[i] >= 0 {
+				next, nextA, nextB = i, toLocal[i], toOther[i]
+				break
+			}
+		}
+		baseChunk, localChunk, otherChunk := base[o:next], local[a:nextA], other[b:nextB]
+		switch {
+		case sameLines(localChunk, baseChunk):
+			merged = append(merged, otherChunk...)
+		case sameLines(otherChunk, baseChunk), sameLines(localChunk, otherChunk):
+			merged = append(merged, localChunk...)
+		default:
+			conflicts++
+			merged = append(merged, "<<<<<<< "+localLabel+"\n")
+			merged = append(merged, terminated(localChunk)...)
+			merged = append(merged, "||||||| deployed\n")
+			merged = append(merged, terminated(baseChunk)...)
+			merged = append(merged, "=======\n")
+			merged = append(merged, terminated(otherChunk)...)
+			merged = append(merged, ">>>>>>> "+otherLabel+"\n")
+		}
+		if next == len(base) {
+			return merged, conflicts
+		}
+		o, a, b = next, nextA, nextB
+	}
+}
+
+// terminated makes sure the last line ends with a newline, so a conflict
+// marker after it starts a line of its own
+func terminated(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	out := append([]string(nil), lines...)
+	out[len(out)-1] += "\n"
+	return out
+}
+
+// runMergeTool lets $MERGE_TOOL resolve a conflicted merge. The command may
+// refer to $LOCAL, $BASE, $REMOTE and $MERGED; without any of them the four
+// files are passed in that order. MERGED starts out with the conflict markers
+func runMergeTool(tool string, local, base, remote, merged []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "profilesync-merge-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{}
+	for name, data := range map[string][]byte{"LOCAL": local, "BASE": base, "REMOTE": remote, "MERGED": merged} {
+		files[name] = filepath.Join(dir, name)
+		if err := os.WriteFile(files[name], data, 0600); err != nil {
+			return nil, err
+		}
+	}
+	referenced := false
+	args := strings.Fields(os.Expand(tool, func(name string) string {
+		if path, ok := files[name]; ok {
+			referenced = true
+			return path
+		}
+		return os.Getenv(name)
+	}))
+	if len(args) == 0 {
+		return nil, errors.New("MERGE_TOOL is empty")
+	}
+	if !referenced {
+		args = append(args, files["LOCAL"], files["BASE"], files["REMOTE"], files["MERGED"])
+	}
+
+	defer pauseProgress()()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w", args[0], err)
+	}
+	return os.ReadFile(files["MERGED"])
+}
+
+// reconcileDeployed decides what to do with a destination a previous run
+// deployed, from what changed on each side since. When both sides changed
+// it merges them, writing the result unless this is a dry run
+func (ps *ProfileSync) reconcileDeployed(item MigrationItem, f *DeployedFile) (int, error) {
+	if _, ok := itemHandlers[item.Mapping]; ok || catalogMerge(item.Mapping) != mergeReplace {
+		// Handled configs and merged directories reconcile themselves
+		return mergeNotTracked, nil
+	}
+	info, err := os.Stat(item.SourcePath)
+	if err != nil || info.IsDir() {
+		return mergeNotTracked, nil
+	}
+	if _, err := os.Stat(item.DestinationPath); err != nil {
+		// Deleted locally; it is deployed again like a new file
+		return mergeNotTracked, nil
+	}
+
+	localHash, err := hashFile(item.DestinationPath)
+	if err != nil {
+		return mergeNotTracked, err
+	}
+	sourceHash, err := hashFile(item.SourcePath)
+	if err != nil {
+		return mergeNotTracked, err
+	}
+	localChanged, sourceChanged := localHash != f.Hash, sourceHash != f.SourceHash
+	switch {
+	case localHash == sourceHash, !localChanged && !sourceChanged:
+		return mergeUpToDate, nil
+	case !localChanged:
+		return mergeTakeSource, nil
+	case !sourceChanged:
+		return mergeKeepLocal, nil
+	}
+
+	// Both changed: merge against what was deployed
+	base, err := readStateFile(filepath.Join(basesDir(), f.Hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return mergeNotTracked, nil
+	} else if err != nil {
+		return mergeNotTracked, err
+	}
+	local, err := os.ReadFile(item.DestinationPath)
+	if err != nil {
+		return mergeNotTracked, err
+	}
+	source, err := os.ReadFile(item.SourcePath)
+	if err != nil {
+		return mergeNotTracked, err
+	}
+	if len(local) > mergeMaxSize || len(source) > mergeMaxSize || bytes.IndexByte(local, 0) >= 0 || bytes.IndexByte(source, 0) >= 0 {
+		return mergeNotTracked, errors.New("changed on both sides but too large or not text to merge")
+	}
+	baseLines, localLines, sourceLines := splitLines(base), splitLines(local), splitLines(source)
+	if len(baseLines)*(len(localLines)+len(sourceLines)) > mergeMaxCells {
+		return mergeNotTracked, errors.New("changed on both sides but too large to merge")
+	}
+
+	lines, conflicts := merge3(baseLines, localLines, sourceLines, "local "+item.DestinationPath, "profile "+item.SourcePath)
+	merged := []byte(strings.Join(lines, ""))
+	outcome := mergeMerged
+	if conflicts > 0 {
+		outcome = mergeConflicted
+	}
+	if ps.dryRun {
+		return outcome, nil
+	}
+
+	if tool := os.Getenv("MERGE_TOOL"); conflicts > 0 && tool != "" {
+		resolved, err := runMergeTool(tool, local, base, source, merged)
+		if err != nil {
+			return mergeNotTracked, fmt.Errorf("merge tool: %w", err)
+		}
+		merged = resolved
+		if !bytes.Contains(merged, []byte("<<<<<<< ")) {
+			outcome = mergeMerged
+		}
+	}
+	// Conflict markers are left for the user to resolve; a clean merge
+	// must still parse
+	if !bytes.Contains(merged, []byte("<<<<<<< ")) {
+		if err := checkTransformed("", item.DestinationPath, merged, "the three-way merge"); err != nil {
+			return mergeNotTracked, err
+		}
+	}
+	if _, err := backupFile(item.DestinationPath); err != nil {
+		return mergeNotTracked, err
+	}
+	if err := writeFileAtomic(item.DestinationPath, merged, 0644); err != nil {
+		return mergeNotTracked, err
+	}
+	return outcome, nil
+}
+
+// lineDiff lists the lines that differ between two versions of a text
+// file: each run of changes is headed by "@@ -old +new @@" with the line
+// numbers it starts at, followed by the removed lines prefixed with "-" and
+// the added ones with "+"
+func lineDiff(old, new []byte) ([]string, error) {
+	if len(old) > mergeMaxSize || len(new) > mergeMaxSize || bytes.IndexByte(old, 0) >= 0 || bytes.IndexByte(new, 0) >= 0 {
+		return nil, errors.New("binary or large file")
+	}
+	a, b := splitLines(old), splitLines(new)
+	if len(a)*len(b) > mergeMaxCells {
+		return nil, errors.New("too large to compare line by line")
+	}
+	match := matchLines(a, b)
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && match[i] == j {
+			i, j = i+1, j+1
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("@@ -%d +%d @@", i+1, j+1))
+		for ; i < len(a) && match[i] < 0; i++ {
+			lines = append(lines, "-"+strings.TrimRight(a[i], "\r\n"))
+		}
+		for ; j < len(b) && (i >= len(a) || j < match[i]); j++ {
+			lines = append(lines, "+"+strings.TrimRight(b[j], "\r\n"))
+		}
+	}
+	return lines, nil
+}
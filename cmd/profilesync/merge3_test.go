@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, local, other string
+		want               string
+		conflicts          int
+	}{
+		{
+			name: "unchanged",
+			base: "a\nb\nc\n", local: "a\nb\nc\n", other: "a\nb\nc\n",
+			want: "a\nb\nc\n",
+		},
+		{
+			name: "changed here only",
+			base: "a\nb\nc\n", local: "a\nB\nc\n", other: "a\nb\nc\n",
+			want: "a\nB\nc\n",
+		},
+		{
+			name: "changed there only",
+			base: "a\nb\nc\n", local: "a\nb\nc\n", other: "a\nb\nC\n",
+			want: "a\nb\nC\n",
+		},
+		{
+			name: "separate changes",
+			base: "a\nb\nc\nd\ne\n", local: "A\nb\nc\nd\ne\n", other: "a\nb\nc\nd\nE\n",
+			want: "A\nb\nc\nd\nE\n",
+		},
+		{
+			name: "same change on both sides",
+			base: "a\nb\nc\n", local: "a\nX\nc\n", other: "a\nX\nc\n",
+			want: "a\nX\nc\n",
+		},
+		{
+			name: "lines added at both ends",
+			base: "b\n", local: "a\nb\n", other: "b\nc\n",
+			want: "a\nb\nc\n",
+		},
+		{
+			name: "line deleted there",
+			base: "a\nb\nc\n", local: "a\nb\nc\n", other: "a\nc\n",
+			want: "a\nc\n",
+		},
+		{
+			name: "conflicting change",
+			base: "a\nb\nc\n", local: "a\nhere\nc\n", other: "a\nthere\nc\n",
+			want:      "a\n<<<<<<< local\nhere\n||||||| deployed\nb\n=======\nthere\n>>>>>>> remote\nc\n",
+			conflicts: 1,
+		},
+		{
+			name: "conflict on a last line without a newline",
+			base: "a\nb", local: "a\nhere", other: "a\nthere",
+			want:      "a\n<<<<<<< local\nhere\n||||||| deployed\nb\n=======\nthere\n>>>>>>> remote\n",
+			conflicts: 1,
+		},
+		{
+			name: "two conflicts",
+			base: "a\nb\nc\nd\ne\n", local: "1\nb\nc\nd\n5\n", other: "one\nb\nc\nd\nfive\n",
+			want: "<<<<<<< local\n1\n||||||| deployed\na\n=======\none\n>>>>>>> remote\nb\nc\nd\n" +
+				"<<<<<<< local\n5\n||||||| deployed\ne\n=======\nfive\n>>>>>>> remote\n",
+			conflicts: 2,
+		},
+		{
+			name: "empty base",
+			base: "", local: "a\n", other: "b\n",
+			want:      "<<<<<<< local\na\n||||||| deployed\n=======\nb\n>>>>>>> remote\n",
+			conflicts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, conflicts := merge3(splitLines([]byte(tt.base)), splitLines([]byte(tt.local)), splitLines([]byte(tt.other)), "local", "remote")
+			if got := strings.Join(merged, ""); got != tt.want || conflicts != tt.conflicts {
+				t.Errorf("merge3 = %q with %d conflicts, want %q with %d", got, conflicts, tt.want, tt.conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runMetrics counts the work done during a run. Copies run on several
+// workers, so counters are updated atomically
+type runMetrics struct {
+	filesCopied  atomic.Int64
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	bytesCloned  atomic.Int64
+
+	mu       sync.Mutex
+	requests map[string]int64
+}
+
+// metrics holds the counters for the current run
+var metrics = &runMetrics{requests: map[string]int64{}}
+
+// copied records a file copied byte by byte
+func (m *runMetrics) copied(n int64) {
+	m.bytesRead.Add(n)
+	m.bytesWritten.Add(n)
+}
+
+// request records one request to a backend, e.g. "https", "git" or a
+// package manager
+func (m *runMetrics) request(backend string) {
+	m.mu.Lock()
+	m.requests[backend]++
+	m.mu.Unlock()
+}
+
+// ResourceSummary is what a run cost in time, memory and I/O
+type ResourceSummary struct {
+	Elapsed      time.Duration    `json:"elapsed"`
+	CPU          time.Duration    `json:"cpu"`
+	PeakMemory   int64            `json:"peakMemory"`
+	FilesCopied  int64            `json:"filesCopied"`
+	BytesRead    int64            `json:"bytesRead"`
+	BytesWritten int64            `json:"bytesWritten"`
+	BytesCloned  int64            `json:"bytesCloned"`
+	Requests     map[string]int64 `json:"requests,omitempty"`
+}
+
+// summary snapshots the counters and the process's resource usage
+func (m *runMetrics) summary(started time.Time) ResourceSummary {
+	cpu, peak := processUsage()
+	s := ResourceSummary{
+		Elapsed:      time.Since(started),
+		CPU:          cpu,
+		PeakMemory:   peak,
+		FilesCopied:  m.filesCopied.Load(),
+		BytesRead:    m.bytesRead.Load(),
+		BytesWritten: m.bytesWritten.Load(),
+		BytesCloned:  m.bytesCloned.Load(),
+	}
+	m.mu.Lock()
+	if len(m.requests) > 0 {
+		s.Requests = make(map[string]int64, len(m.requests))
+		for backend, n := range m.requests {
+			s.Requests[backend] = n
+		}
+	}
+	m.mu.Unlock()
+	return s
+}
+
+// utilization is the CPU time used per second of wall-clock time
+func (s ResourceSummary) utilization() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.CPU) / float64(s.Elapsed)
+}
+
+// printResources prints a resource summary, with a hint about --jobs when
+// the run was clearly bound by CPU or by waiting on I/O
+func printResources(s ResourceSummary, jobs int) {
+	infoColor.Println("\n⏱️  Resources:")
+	fmt.Printf("   Elapsed: %s, CPU: %s (%.0f%%)\n", s.Elapsed.Round(time.Millisecond), s.CPU.Round(time.Millisecond), s.utilization()*100)
+	if s.PeakMemory > 0 {
+		fmt.Printf("   Peak memory: %s\n", formatSize(s.PeakMemory))
+	}
+	fmt.Printf("   Files copied: %d, read: %s, written: %s", s.FilesCopied, formatSize(s.BytesRead), formatSize(s.BytesWritten))
+	if s.BytesCloned > 0 {
+		fmt.Printf(", cloned: %s", formatSize(s.BytesCloned))
+	}
+	fmt.Println()
+	if len(s.Requests) > 0 {
+		backends := make([]string, 0, len(s.Requests))
+		for backend := range s.Requests {
+			backends = append(backends, backend)
+		}
+		sort.Strings(backends)
+		parts := make([]string, len(backends))
+		for i, backend := range backends {
+			parts[i] = fmt.Sprintf("%s %d", backend, s.Requests[backend])
+		}
+		fmt.Printf("   Requests: %s\n", strings.Join(parts, ", "))
+	}
+
+	// Only worth a hint once there was enough work to measure
+	if s.Elapsed < 2*time.Second || jobs < 1 {
+		return
+	}
+	switch u := s.utilization(); {
+	case u >= float64(jobs)*0.8:
+		noticeColor.Printf("💡 CPU-bound with --jobs=%d; fewer jobs may be as fast on this machine\n", jobs)
+	case u < 0.2 && s.BytesWritten > 0:
+		noticeColor.Printf("💡 Mostly waiting on I/O; a higher --jobs may help on network or slow disks\n")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migrationUser is a local account whose profile --user or --all-users
+// migrates
+type migrationUser struct {
+	Name       string
+	SourceHome string
+	DestHome   string
+	account    *user.User
+}
+
+// notUserHomes are directories beside the homes that belong to no one
+var notUserHomes = map[string]bool{
+	"Shared": true, "Public": true, "Default": true, "Default User": true,
+	"All Users": true, "Guest": true, "lost+found": true,
+}
+
+// homesRoot is the directory holding every user's home on a platform
+func homesRoot(platform string) string {
+	switch platform {
+	case "macos":
+		return "/Users"
+	case "windows":
+		return filepath.Dir(GetHomeDir("windows"))
+	default:
+		return "/home"
+	}
+}
+
+// resolveUsers looks up the users to migrate: those named, or with all,
+// every local user with a home in homes. Each user's profile comes from
+// their directory in sourceDir if given, or else their home here
+func resolveUsers(names []string, all bool, platform, sourceDir string) ([]migrationUser, error) {
+	if all {
+		root := sourceDir
+		if root == "" {
+			root = homesRoot(platform)
+		}
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.IsDir() || strings.HasPrefix(e.Name(), ".") || notUserHomes[e.Name()] {
+				continue
+			}
+			if _, err := user.Lookup(e.Name()); err != nil {
+				warnColor.Printf("⚠️  Skipped %s: no local user by that name\n", filepath.Join(root, e.Name()))
+				continue
+			}
+			names = append(names, e.Name())
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no user homes found in %s", root)
+		}
+	}
+
+	var users []migrationUser
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		account, err := user.Lookup(name)
+		if err != nil {
+			return nil, fmt.Errorf("no local user %q", name)
+		}
+		source := account.HomeDir
+		if sourceDir != "" {
+			source = filepath.Join(sourceDir, name)
+		}
+		users = append(users, migrationUser{Name: name, SourceHome: source, DestHome: account.HomeDir, account: account})
+	}
+	return users, nil
+}
+
+// userReportPath is where --report writes the report of one user:
+// report.html becomes report-alice.html
+func userReportPath(path, name string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
+
+// userResult is how the migration of one user's profile went
+type userResult struct {
+	name                      string
+	migrated, skipped, failed int
+	err                       error
+}
+
+// migrateUsers migrates each user's profile in turn, gives what was copied
+// into their home to them, and ends with a summary of every user. It
+// reports whether all of them succeeded
+func (ps *ProfileSync) migrateUsers(ctx context.Context, users []migrationUser, skipMissingApps bool, report string) bool {
+	var results []userResult
+	for _, u := range users {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Println()
+		infoColor.Printf("👤 User %s: %s → %s\n", u.Name, u.SourceHome, u.DestHome)
+		ps.user = u.Name
+		ps.migrationPlan = &MigrationPlan{}
+
+		logger().Info("migration started", "user", u.Name, "source", u.SourceHome, "destination", u.DestHome, "dry_run", ps.dryRun)
+		started := time.Now()
+		err := ps.CreateMigrationPlan(ctx, u.SourceHome, u.DestHome)
+		if err == nil {
+			ps.MarkMissingApps(u.DestHome, skipMissingApps)
+			err = ps.ExecuteMigration(ctx, u.SourceHome, u.DestHome)
+		}
+		if !ps.dryRun {
+			if ownErr := ps.giveToUser(u); ownErr != nil && err == nil {
+				err = fmt.Errorf("setting owner: %w", ownErr)
+			}
+		}
+		ps.recordRun(started, u.SourceHome, u.DestHome, err)
+		if err != nil {
+			ps.logFinished(slog.LevelError, "migration failed", started, err)
+			errorColor.Printf("❌ %s: %v\n", u.Name, err)
+		} else {
+			ps.logFinished(slog.LevelInfo, "migration finished", started, nil)
+		}
+		ps.PrintReport()
+		ps.writeReport(userReportPath(report, u.Name), started, u.SourceHome, u.DestHome)
+
+		result := userResult{name: u.Name, err: err}
+		for _, item := range ps.migrationPlan.Items {
+			switch item.Status {
+			case itemMigrated, itemMerged:
+				result.migrated++
+			case itemFailed, itemConflicts:
+				result.failed++
+			default:
+				result.skipped++
+			}
+		}
+		results = append(results, result)
+	}
+	ps.user = ""
+
+	fmt.Println()
+	infoColor.Println(strings.Repeat("=", 60))
+	infoColor.Println("👥 USERS")
+	infoColor.Println(strings.Repeat("=", 60))
+	ok := len(results) == len(users)
+	sort.Slice(results, func(i, j int) bool { return results[i].name < results[j].name })
+	for _, r := range results {
+		line := fmt.Sprintf("%-20s %d migrated, %d skipped, %d failed", r.name, r.migrated, r.skipped, r.failed)
+		switch {
+		case r.err != nil:
+			ok = false
+			errorColor.Printf("❌ %s: %v\n", line, r.err)
+		case r.failed > 0:
+			ok = false
+			warnColor.Printf("⚠️  %s\n", line)
+		default:
+			successColor.Printf("✅ %s\n", line)
+		}
+	}
+	if len(results) < len(users) {
+		warnColor.Printf("⛔ Interrupted before %d of %d users\n", len(users)-len(results), len(users))
+	}
+	return ok
+}
+
+// giveToUser makes u the owner of everything the run wrote into their
+// home, which is otherwise owned by the administrator running it
+func (ps *ProfileSync) giveToUser(u migrationUser) error {
+	var failed []string
+	for _, item := range ps.migrationPlan.Items {
+		if !item.Migrated || !withinRoot(u.DestHome, item.DestinationPath) {
+			continue
+		}
+		if err := chownTree(u.DestHome, item.DestinationPath, u.account); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", item.DestinationPath, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Severities of a job's outcome, from least to most severe
+const (
+	severitySuccess = "success"
+	severityWarning = "warning"
+	severityFailure = "failure"
+)
+
+// severityRank orders severities, and the values of a notification's on
+var severityRank = map[string]int{
+	"all":           0,
+	severitySuccess: 0,
+	severityWarning: 1,
+	severityFailure: 2,
+}
+
+// Notification is where to report the results of scheduled jobs: a
+// webhook, the desktop, or both
+type Notification struct {
+	// Webhook is the URL to post to; $VARIABLES are expanded, so the secret
+	// part can stay out of a shared config
+	Webhook string `yaml:"webhook"`
+	// Format is slack, teams or json; guessed from the URL when unset
+	Format string `yaml:"format"`
+	// Desktop shows a native notification
+	Desktop bool `yaml:"desktop"`
+	// On is the least severe outcome notified: all, warning or failure
+	// (default warning)
+	On string `yaml:"on"`
+}
+
+// validate checks a notification and fills in its defaults
+func (n *Notification) validate() error {
+	if n.Webhook == "" && !n.Desktop {
+		return errors.New("needs a webhook or desktop: true")
+	}
+	if n.On == "" {
+		n.On = severityWarning
+	}
+	if _, ok := severityRank[n.On]; !ok || n.On == severitySuccess {
+		return fmt.Errorf("invalid on %q: must be all, warning or failure", n.On)
+	}
+	if n.Webhook == "" {
+		return nil
+	}
+	if n.Format == "" {
+		n.Format = "json"
+		switch host := webhookHost(os.ExpandEnv(n.Webhook)); {
+		case strings.HasSuffix(host, "slack.com"):
+			n.Format = "slack"
+		case strings.HasSuffix(host, "office.com"), strings.HasSuffix(host, "logic.azure.com"):
+			n.Format = "teams"
+		}
+	}
+	switch n.Format {
+	case "slack", "teams", "json":
+	default:
+		return fmt.Errorf("invalid format %q: must be slack, teams or json", n.Format)
+	}
+	return nil
+}
+
+// webhookHost is the host of a webhook URL, empty if it is not one
+func webhookHost(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// jobEvent is what a notification says about a finished job
+type jobEvent struct {
+	Machine  string         `json:"machine"`
+	Job      string         `json:"job"`
+	Command  string         `json:"command"`
+	Args     []string       `json:"args"`
+	Trigger  string         `json:"trigger"`
+	Result   string         `json:"result"`
+	Severity string         `json:"severity"`
+	ExitCode int            `json:"exit_code"`
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Duration float64        `json:"duration_seconds"`
+	Items    map[string]int `json:"items,omitempty"`
+	Summary  string         `json:"summary"`
+	Output   []string       `json:"output,omitempty"`
+}
+
+// newJobEvent describes a finished job from its state, log and output. A
+// job that succeeded with failed items or conflicts is a warning
+func newJobEvent(job jobInfo, records []jobRecord, lines []string) jobEvent {
+	e := jobEvent{
+		Machine:  machineName(),
+		Job:      job.ID,
+		Command:  jobCommand(job),
+		Args:     job.Args,
+		Trigger:  job.Trigger,
+		Result:   job.State,
+		Severity: severitySuccess,
+		ExitCode: job.ExitCode,
+		Started:  job.Started,
+		Finished: time.Now().UTC(),
+		Items:    map[string]int{},
+	}
+	if job.Finished != nil {
+		e.Finished = *job.Finished
+	}
+	e.Duration = e.Finished.Sub(e.Started).Seconds()
+	for _, r := range records {
+		switch r.Msg {
+		case "item":
+			e.Items[r.Status]++
+			if r.Status == itemFailed || strings.HasSuffix(r.Status, itemConflicts) {
+				e.Severity = severityWarning
+			}
+		case "file":
+			status := r.Action
+			if r.Error != "" {
+				status = itemFailed
+				e.Severity = severityWarning
+			}
+			e.Items[status]++
+		}
+	}
+	if job.State != jobSucceeded {
+		e.Severity = severityFailure
+	}
+	// The end of the output says what went wrong
+	e.Output = lines[max(len(lines)-10, 0):]
+
+	var counts []string
+	for _, status := range sortedKeys(e.Items) {
+		counts = append(counts, fmt.Sprintf("%d %s", e.Items[status], status))
+	}
+	what := "profilesync " + e.Command
+	if name, ok := strings.CutPrefix(e.Trigger, "schedule:"); ok {
+		what += " (" + name + ")"
+	}
+	duration := formatDuration(time.Duration(e.Duration * float64(time.Second)))
+	switch e.Severity {
+	case severityFailure:
+		e.Summary = fmt.Sprintf("❌ %s failed on %s after %s (exit code %d)", what, e.Machine, duration, e.ExitCode)
+	case severityWarning:
+		e.Summary = fmt.Sprintf("⚠️ %s finished with problems on %s in %s", what, e.Machine, duration)
+	default:
+		e.Summary = fmt.Sprintf("✅ %s succeeded on %s in %s", what, e.Machine, duration)
+	}
+	if len(counts) > 0 {
+		e.Summary += ": " + strings.Join(counts, ", ")
+	}
+	return e
+}
+
+// notifier sends the notifications of a config
+type notifier struct {
+	notifications map[string]*Notification
+	client        *http.Client
+}
+
+// newNotifier returns a notifier for the config's notifications, or nil
+// without any
+func newNotifier(notifications map[string]*Notification) *notifier {
+	if len(notifications) == 0 {
+		return nil
+	}
+	return &notifier{notifications: notifications, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// notify sends e to every notification that wants its severity
+func (n *notifier) notify(ctx context.Context, e jobEvent) {
+	names := make([]string, 0, len(n.notifications))
+	for name := range n.notifications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cfg := n.notifications[name]
+		if severityRank[e.Severity] < severityRank[cfg.On] {
+			continue
+		}
+		if cfg.Webhook != "" {
+			if err := n.post(ctx, cfg, e); err != nil {
+				warnColor.Printf("⚠️  Notification %s failed: %v\n", name, err)
+			}
+		}
+		if cfg.Desktop {
+			if err := desktopNotify("profilesync", e.Summary); err != nil {
+				warnColor.Printf("⚠️  Desktop notification %s failed: %v\n", name, err)
+			}
+		}
+	}
+}
+
+// webhookBody formats e for a webhook
+func webhookBody(format string, e jobEvent) any {
+	details := e.Summary
+	if e.Severity == severityFailure && len(e.Output) > 0 {
+		details += "\n" + strings.Join(e.Output, "\n")
+	}
+	switch format {
+	case "slack":
+		text := e.Summary
+		if e.Severity == severityFailure && len(e.Output) > 0 {
+			text += "\n```" + strings.Join(e.Output, "\n") + "```"
+		}
+		return map[string]string{"text": text}
+	case "teams":
+		color := map[string]string{severitySuccess: "2EB67D", severityWarning: "ECB22E", severityFailure: "E01E5A"}[e.Severity]
+		return map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    e.Summary,
+			"themeColor": color,
+			"title":      "profilesync " + e.Command + " on " + e.Machine,
+			"text":       strings.ReplaceAll(details, "\n", "<br>"),
+		}
+	}
+	return e
+}
+
+// post sends e to a notification's webhook
+func (n *notifier) post(ctx context.Context, cfg *Notification, e jobEvent) error {
+	target := os.ExpandEnv(cfg.Webhook)
+	body, err := json.Marshal(webhookBody(cfg.Format, e))
+	if err != nil {
+		return err
+	}
+	// Only the host is shown, as the rest of a webhook URL is its secret
+	shown := webhookHost(target)
+	return defaultRetry.do(ctx, "Posting to "+shown, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return errors.New("invalid webhook URL")
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := n.client.Do(req)
+		if err != nil {
+			// Without the URL the error would show
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				return urlErr.Err
+			}
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode/100 != 2 {
+			return &httpStatusError{url: shown, code: resp.StatusCode, status: resp.Status}
+		}
+		return nil
+	})
+}
+
+// desktopNotify shows a native notification: notify-send on Linux, the
+// notification center on macOS, or a toast on Windows
+func desktopNotify(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		script := `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:PROFILESYNC_TITLE)) | Out-Null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:PROFILESYNC_MESSAGE)) | Out-Null
+$id = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($id).Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+		// The text goes through the environment rather than the script, so
+		// no quoting can break it
+		cmd.Env = append(os.Environ(), "PROFILESYNC_TITLE="+title, "PROFILESYNC_MESSAGE="+message)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=profilesync", title, message)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
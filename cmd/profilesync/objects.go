@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Content-defined chunk sizes. Boundaries follow the content rather than
+// offsets, so an insertion early in a large file, such as a browser
+// database, changes only the chunks around it
+const (
+	chunkMin  = 16 << 10
+	chunkMax  = 256 << 10
+	chunkMask = 1<<16 - 1 // boundaries every 64 KiB on average
+)
+
+// Chunk encodings, the first byte of a stored chunk
+const (
+	chunkRaw     = 0
+	chunkDeflate = 1
+)
+
+// gearTable drives the rolling hash that finds chunk boundaries. It must
+// never change, or files stored before would no longer share chunks with
+// the same files stored after
+var gearTable = func() (table [256]uint64) {
+	// splitmix64 from a fixed seed
+	x := uint64(0x70726f66696c6573)
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = z ^ z>>31
+	}
+	return table
+}()
+
+// chunkBoundaries splits data into content-defined chunks, returning the
+// end offset of each
+func chunkBoundaries(data []byte) []int {
+	var ends []int
+	start := 0
+	for start < len(data) {
+		end := min(start+chunkMax, len(data))
+		if end-start > chunkMin {
+			var h uint64
+			for i := start + chunkMin; i < end; i++ {
+				h = h<<1 + gearTable[data[i]]
+				if h&chunkMask == 0 {
+					end = i + 1
+					break
+				}
+			}
+		}
+		ends = append(ends, end)
+		start = end
+	}
+	return ends
+}
+
+// objectRecipe lists the chunks a stored file is made of
+type objectRecipe struct {
+	Size   int64    `json:"size"`
+	Chunks []string `json:"chunks"`
+}
+
+// objectStore keeps file contents by hash, split into chunks that are
+// stored once however many files, snapshots or machines share them
+type objectStore struct {
+	dir string
+}
+
+// openObjectStore returns the object store in the state directory
+func openObjectStore() *objectStore {
+	return &objectStore{dir: filepath.Join(stateDir(), "objects")}
+}
+
+// filePath is where the recipe of the file with the given hash is stored
+func (s *objectStore) filePath(hash string) string {
+	return filepath.Join(s.dir, "files", hash[:2], hash)
+}
+
+// chunkPath is where the chunk with the given hash is stored
+func (s *objectStore) chunkPath(hash string) string {
+	return filepath.Join(s.dir, "chunks", hash[:2], hash)
+}
+
+// has reports whether the file with the given hash is stored
+func (s *objectStore) has(hash string) bool {
+	_, err := os.Stat(s.filePath(hash))
+	return err == nil
+}
+
+// put stores data, returning its hash and how many bytes were new to the
+// store before compression
+func (s *objectStore) put(data []byte) (string, int64, error) {
+	hash := hashBytes(data)
+	if s.has(hash) {
+		return hash, 0, nil
+	}
+	recipe := objectRecipe{Size: int64(len(data)), Chunks: []string{}}
+	var added int64
+	start := 0
+	for _, end := range chunkBoundaries(data) {
+		chunk := data[start:end]
+		start = end
+		chunkHash := hashBytes(chunk)
+		recipe.Chunks = append(recipe.Chunks, chunkHash)
+		if _, err := os.Stat(s.chunkPath(chunkHash)); err == nil {
+			continue
+		}
+		if err := writeStateFile(s.chunkPath(chunkHash), encodeChunk(chunk), 0600); err != nil {
+			return "", 0, err
+		}
+		added += int64(len(chunk))
+	}
+	// The recipe goes last, so a file is only ever found whole
+	encoded, err := json.Marshal(recipe)
+	if err != nil {
+		return "", 0, err
+	}
+	return hash, added, writeStateFile(s.filePath(hash), encoded, 0600)
+}
+
+// get reads the file with the given hash, checking it was not corrupted
+func (s *objectStore) get(hash string) ([]byte, error) {
+	encoded, err := readStateFile(s.filePath(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("content %s is missing from the object store", hash)
+	} else if err != nil {
+		return nil, err
+	}
+	var recipe objectRecipe
+	if err := json.Unmarshal(encoded, &recipe); err != nil {
+		return nil, fmt.Errorf("corrupt object %s: %w", hash, err)
+	}
+	data := make([]byte, 0, recipe.Size)
+	for _, chunkHash := range recipe.Chunks {
+		stored, err := readStateFile(s.chunkPath(chunkHash))
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := decodeChunk(stored)
+		if err != nil || hashBytes(chunk) != chunkHash {
+			return nil, fmt.Errorf("chunk %s of %s is corrupt", chunkHash, hash)
+		}
+		data = append(data, chunk...)
+	}
+	if hashBytes(data) != hash {
+		return nil, fmt.Errorf("content %s is corrupt", hash)
+	}
+	return data, nil
+}
+
+// encodeChunk compresses a chunk when that makes it smaller
+func encodeChunk(chunk []byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte(chunkDeflate)
+	w, _ := flate.NewWriter(&b, flate.DefaultCompression)
+	w.Write(chunk)
+	w.Close()
+	if b.Len() < len(chunk)+1 {
+		return b.Bytes()
+	}
+	return append([]byte{chunkRaw}, chunk...)
+}
+
+// decodeChunk reverses encodeChunk
+func decodeChunk(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, errors.New("empty chunk")
+	}
+	switch stored[0] {
+	case chunkRaw:
+		return stored[1:], nil
+	case chunkDeflate:
+		return io.ReadAll(flate.NewReader(bytes.NewReader(stored[1:])))
+	}
+	return nil, fmt.Errorf("unknown chunk encoding %d", stored[0])
+}
+
+// objectStats is the size of the object store
+type objectStats struct {
+	Files, Chunks int
+	// Logical is the size of the stored files, Stored what they take on disk
+	Logical, Stored int64
+}
+
+// stats measures the store
+func (s *objectStore) stats() (objectStats, error) {
+	var st objectStats
+	err := s.walk("chunks", func(path string, info fs.FileInfo) error {
+		st.Chunks++
+		st.Stored += info.Size()
+		return nil
+	})
+	if err != nil {
+		return st, err
+	}
+	err = s.walk("files", func(path string, info fs.FileInfo) error {
+		st.Files++
+		st.Stored += info.Size()
+		encoded, err := readStateFile(path)
+		if err != nil {
+			return err
+		}
+		var recipe objectRecipe
+		if json.Unmarshal(encoded, &recipe) == nil {
+			st.Logical += recipe.Size
+		}
+		return nil
+	})
+	return st, err
+}
+
+// walk calls fn for each stored file under one of the store's directories
+func (s *objectStore) walk(kind string, fn func(path string, info fs.FileInfo) error) error {
+	root := filepath.Join(s.dir, kind)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return nil
+		} else if err != nil || d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(path, info)
+	})
+}
+
+// gc removes the files not in used, and the chunks no remaining file
+// needs, returning how many files went and the bytes freed
+func (s *objectStore) gc(used map[string]bool) (int, int64, error) {
+	removed := 0
+	var freed int64
+	chunks := map[string]bool{}
+	err := s.walk("files", func(path string, info fs.FileInfo) error {
+		if !used[filepath.Base(path)] {
+			removed++
+			freed += info.Size()
+			return os.Remove(path)
+		}
+		encoded, err := readStateFile(path)
+		if err != nil {
+			return err
+		}
+		var recipe objectRecipe
+		if err := json.Unmarshal(encoded, &recipe); err != nil {
+			return fmt.Errorf("corrupt object %s: %w", path, err)
+		}
+		for _, c := range recipe.Chunks {
+			chunks[c] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, freed, err
+	}
+	err = s.walk("chunks", func(path string, info fs.FileInfo) error {
+		if chunks[filepath.Base(path)] {
+			return nil
+		}
+		freed += info.Size()
+		return os.Remove(path)
+	})
+	return removed, freed, err
+}
+
+// dedupStore makes identical files in the profile store copy-on-write
+// clones of one another, so the copies received from several machines or
+// imported more than once share their data on disk. The files stay plain
+// files, as `apply --source-dir` reads them, and editing one leaves the
+// others alone. Filesystems without clones are left as they are
+func dedupStore(dir string) (int, int64, error) {
+	type candidate struct {
+		path string
+		info fs.FileInfo
+	}
+	bySize := map[int64][]candidate{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == dir {
+			return nil
+		} else if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Clones save nothing on files smaller than a block
+		if info.Size() >= 4096 {
+			bySize[info.Size()] = append(bySize[info.Size()], candidate{path, info})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cloned := 0
+	var saved int64
+	for size, group := range bySize {
+		if len(group) < 2 {
+			continue
+		}
+		first := map[string]string{}
+		for _, c := range group {
+			hash, err := hashFile(c.path)
+			if err != nil {
+				return cloned, saved, err
+			}
+			original, ok := first[hash]
+			if !ok {
+				first[hash] = c.path
+				continue
+			}
+			if sameFile(original, c.info) {
+				continue
+			}
+			tmp := c.path + ".profilesync-tmp"
+			if err := cloneFile(original, tmp); err != nil {
+				// As when copying, a failed clone means the filesystem
+				// cannot share data
+				os.Remove(tmp)
+				return cloned, saved, nil
+			}
+			os.Chmod(tmp, c.info.Mode().Perm())
+			os.Chtimes(tmp, c.info.ModTime(), c.info.ModTime())
+			if err := os.Rename(tmp, c.path); err != nil {
+				os.Remove(tmp)
+				return cloned, saved, err
+			}
+			cloned++
+			saved += size
+		}
+	}
+	return cloned, saved, nil
+}
+
+// sameFile reports whether path is the file info describes
+func sameFile(path string, info fs.FileInfo) bool {
+	other, err := os.Stat(path)
+	return err == nil && os.SameFile(other, info)
+}
+
+// reportStoreDedup deduplicates the profile store after files were added
+// to it, mentioning any space saved
+func reportStoreDedup(dir string) {
+	cloned, saved, err := dedupStore(dir)
+	if err != nil {
+		warnColor.Println("⚠️  Could not deduplicate the profile store:", err)
+		return
+	}
+	if cloned > 0 {
+		noticeColor.Printf("🗜️  %d identical files in the profile store now share their data (%s saved)\n", cloned, formatSize(saved))
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// testContent returns size bytes of random content, the same for a seed
+func testContent(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestChunkBoundaries(t *testing.T) {
+	for _, size := range []int{0, 1, chunkMin, chunkMax + 1, 3 << 20} {
+		ends := chunkBoundaries(testContent(1, size))
+		start := 0
+		for i, end := range ends {
+			if n := end - start; n > chunkMax || (n < chunkMin && i != len(ends)-1) {
+				t.Errorf("%d bytes: chunk %d has %d bytes", size, i, n)
+			}
+			start = end
+		}
+		if start != size {
+			t.Errorf("%d bytes: chunks end at %d", size, start)
+		}
+	}
+}
+
+func TestObjectStoreRoundTrip(t *testing.T) {
+	large := testContent(1, 2<<20)
+	tests := []struct {
+		name string
+		data []byte
+		// repeats is set when chunks repeat within data, and are stored once
+		repeats bool
+	}{
+		{name: "empty", data: []byte{}},
+		{name: "small", data: []byte("user_pref(\"browser.startup.page\", 3);\n")},
+		{name: "repeated", data: bytes.Repeat([]byte("history "), 100000), repeats: true},
+		{name: "several chunks", data: large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PROFILESYNC_STATE_DIR", t.TempDir())
+			s := openObjectStore()
+			hash, added, err := s.put(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.repeats && added >= int64(len(tt.data)) {
+				t.Errorf("put added %d bytes, want fewer than %d", added, len(tt.data))
+			} else if !tt.repeats && added != int64(len(tt.data)) {
+				t.Errorf("put added %d bytes, want %d", added, len(tt.data))
+			}
+			if !s.has(hash) {
+				t.Errorf("store does not have %s after put", hash)
+			}
+			got, err := s.get(hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("get returned %d bytes, want %d", len(got), len(tt.data))
+			}
+			if _, added, err := s.put(tt.data); err != nil || added != 0 {
+				t.Errorf("putting it again added %d bytes, %v", added, err)
+			}
+		})
+	}
+}
+
+func TestObjectStoreSharesChunks(t *testing.T) {
+	t.Setenv("PROFILESYNC_STATE_DIR", t.TempDir())
+	s := openObjectStore()
+	old := testContent(2, 4<<20)
+	if _, _, err := s.put(old); err != nil {
+		t.Fatal(err)
+	}
+
+	// An insertion near the start moves every offset after it
+	changed := append(append(append([]byte{}, old[:1000]...), "inserted"...), old[1000:]...)
+	hash, added, err := s.put(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added == 0 || added > 2*chunkMax {
+		t.Errorf("storing a file with an insertion added %d of its %d bytes", added, len(changed))
+	}
+	got, err := s.get(hash)
+	if err != nil || !bytes.Equal(got, changed) {
+		t.Errorf("get of the changed file = %d bytes, %v", len(got), err)
+	}
+}
+
+func TestObjectStoreCorruption(t *testing.T) {
+	t.Setenv("PROFILESYNC_STATE_DIR", t.TempDir())
+	s := openObjectStore()
+	data := testContent(3, 100<<10)
+	hash, _, err := s.put(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := hashBytes(data[:chunkBoundaries(data)[0]])
+	stored, err := os.ReadFile(s.chunkPath(chunk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored[len(stored)-1] ^= 1
+	if err := os.WriteFile(s.chunkPath(chunk), stored, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.get(hash); err == nil {
+		t.Error("get returned a file with a corrupt chunk")
+	}
+	if _, err := s.get(hashBytes([]byte("never stored"))); err == nil {
+		t.Error("get returned a file that was never stored")
+	}
+}
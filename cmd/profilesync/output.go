@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+)
+
+// Console output settings. Colors are already off when NO_COLOR is set,
+// TERM is dumb or stdout is not a terminal; emoji are also off on dumb
+// terminals and consoles whose code page cannot show them
+var (
+	noEmoji = os.Getenv("TERM") == "dumb" || !consoleUnicode() || envBool("PROFILESYNC_NO_EMOJI")
+	// showProgress is whether progress counters are redrawn in place, which
+	// only makes sense on a terminal
+	showProgress = isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+)
+
+// setupOutput applies $PROFILESYNC_PLAIN and routes colored output
+// through the emoji filter, before any flags are parsed
+func setupOutput() {
+	if envBool("PROFILESYNC_PLAIN") {
+		setPlainOutput()
+	}
+	color.Output = outputWriter(color.Output)
+}
+
+// envBool reports whether an environment variable is set to a true value
+func envBool(name string) bool {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// addOutputFlags registers --no-color, --no-emoji and --plain
+func addOutputFlags(flags *flag.FlagSet) {
+	flags.BoolFunc("no-color", "Do not color output (also when NO_COLOR is set or stdout is not a terminal)", func(string) error {
+		color.NoColor = true
+		return nil
+	})
+	flags.BoolFunc("no-emoji", "Do not print emoji (also when $PROFILESYNC_NO_EMOJI is set)", func(string) error {
+		noEmoji = true
+		return nil
+	})
+	flags.BoolFunc("plain", "Plain output for logs and dumb terminals: no color, emoji or progress bars (also when $PROFILESYNC_PLAIN is set)", func(string) error {
+		setPlainOutput()
+		return nil
+	})
+}
+
+// setPlainOutput turns off everything but plain text
+func setPlainOutput() {
+	color.NoColor = true
+	noEmoji = true
+	showProgress = false
+}
+
+// outputWriter returns w, leaving out emoji when they are off
+func outputWriter(w io.Writer) io.Writer {
+	if _, ok := w.(*emojiFilter); ok {
+		return w
+	}
+	return &emojiFilter{w: w, lineStart: true}
+}
+
+// emojiFilter drops emoji and the spaces after them, and spells out the
+// few other symbols messages use, while noEmoji is set. Colors pass
+// through untouched
+type emojiFilter struct {
+	w         io.Writer
+	lineStart bool
+	last      rune
+}
+
+// asciiSymbols are the symbols in messages that are not emoji but that
+// legacy code pages cannot show either
+var asciiSymbols = map[rune]string{
+	'→': "->",
+	'←': "<-",
+	'…': "...",
+	'•': "*",
+	'—': "-",
+}
+
+// isEmoji reports whether r is a pictograph or joins one
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF, // pictographs, emoticons, transport
+		r >= 0x2300 && r <= 0x23FF,            // technical symbols such as ⏭️ and ⌛
+		r >= 0x2600 && r <= 0x27BF,            // miscellaneous symbols and dingbats
+		r >= 0x2B00 && r <= 0x2BFF,            // arrows and stars such as ⬆️ and ⭐
+		r == 0xFE0F, r == 0x200D, r == 0x20E3: // variation selector, joiners
+		return true
+	}
+	return false
+}
+
+// Write implements io.Writer
+func (f *emojiFilter) Write(p []byte) (int, error) {
+	if !noEmoji {
+		if len(p) > 0 {
+			f.last, _ = utf8.DecodeLastRune(p)
+			f.lineStart = f.last == '\n' || f.last == '\r'
+		}
+		return f.w.Write(p)
+	}
+	var b strings.Builder
+	dropSpaces := false
+	for i := 0; i < len(p); {
+		r, size := utf8.DecodeRune(p[i:])
+		if r == '\x1b' {
+			// Leave color codes as they are
+			end := i + 1
+			for end < len(p) && !(p[end] >= 'A' && p[end] <= 'Z' || p[end] >= 'a' && p[end] <= 'z') {
+				end++
+			}
+			end = min(end+1, len(p))
+			b.Write(p[i:end])
+			i = end
+			continue
+		}
+		i += size
+		switch {
+		case isEmoji(r):
+			dropSpaces = true
+			continue
+		case r == ' ' && dropSpaces:
+			continue
+		}
+		if dropSpaces && !f.lineStart && f.last != ' ' && r != '\n' && r != '\r' {
+			b.WriteByte(' ')
+		}
+		dropSpaces = false
+		if s, ok := asciiSymbols[r]; ok {
+			b.WriteString(s)
+		} else {
+			b.WriteRune(r)
+		}
+		f.last = r
+		switch r {
+		case '\n', '\r':
+			f.lineStart = true
+		case ' ', '\t':
+		default:
+			f.lineStart = false
+		}
+	}
+	// Report the whole of p as written, as callers check the count
+	if _, err := io.WriteString(f.w, b.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
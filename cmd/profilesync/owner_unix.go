@@ -0,0 +1,61 @@
+//go:build !windows
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner formats a file's uid:gid
+func fileOwner(info fs.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatUint(uint64(stat.Uid), 10) + ":" + strconv.FormatUint(uint64(stat.Gid), 10)
+}
+
+// elevated reports whether this process runs as root
+func elevated() bool {
+	return os.Geteuid() == 0
+}
+
+// chownTree gives path and everything below it to u, along with the
+// directories between home and path that this process created
+func chownTree(home, path string, u *user.User) error {
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return err
+	}
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, uid, gid)
+	})
+	if err != nil {
+		return err
+	}
+	home = filepath.Clean(home)
+	for dir := filepath.Dir(path); dir != home && withinRoot(home, dir); dir = filepath.Dir(dir) {
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) == os.Geteuid() {
+			if err := os.Lchown(dir, uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
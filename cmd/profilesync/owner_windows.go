@@ -0,0 +1,37 @@
+//go:build windows
+
+package main
+
+import (
+	"io/fs"
+	"os/user"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileOwner is not tracked on Windows, where access is governed by ACLs
+func fileOwner(info fs.FileInfo) string {
+	return ""
+}
+
+// elevated reports whether this process runs as Administrator
+func elevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
+
+// chownTree makes u the owner of path and everything below it. Directories
+// created between home and path inherit the home's ACL, which already
+// grants u full control
+func chownTree(home, path string, u *user.User) error {
+	sid, err := windows.StringToSid(u.Uid)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return windows.SetNamedSecurityInfo(p, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION, sid, nil, nil, nil)
+	})
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PackageManifest is the normalized list of installed packages stored in a profile
+type PackageManifest struct {
+	Platform   string              `json:"platform"`
+	CapturedAt time.Time           `json:"captured_at"`
+	Managers   map[string][]string `json:"managers"`
+}
+
+// packageManager knows how to list and install packages with one tool
+type packageManager struct {
+	Name       string
+	Binary     string
+	Platforms  []string
+	NativeFile string
+	List       func() ([]string, []byte, error)
+	Install    func(names []string) [][]string
+	Sudo       bool
+}
+
+// packageManagers in order of preference when choosing an install target
+var packageManagers = []packageManager{
+	{
+		Name: "brew", Binary: "brew", Platforms: []string{"macos", "linux"}, NativeFile: "Brewfile",
+		List: func() ([]string, []byte, error) {
+			formulae, err := commandLines("brew", "leaves", "--installed-on-request")
+			if err != nil {
+				return nil, nil, err
+			}
+			var brewfile bytes.Buffer
+			for _, name := range formulae {
+				fmt.Fprintf(&brewfile, "brew %q\n", name)
+			}
+			return formulae, brewfile.Bytes(), nil
+		},
+		Install: func(names []string) [][]string {
+			return [][]string{append([]string{"brew", "install"}, names...)}
+		},
+	},
+	{
+		Name: "brew-cask", Binary: "brew", Platforms: []string{"macos"}, NativeFile: "Brewfile.cask",
+		List: func() ([]string, []byte, error) {
+			casks, err := commandLines("brew", "list", "--cask", "-1")
+			if err != nil {
+				return nil, nil, err
+			}
+			var brewfile bytes.Buffer
+			for _, name := range casks {
+				fmt.Fprintf(&brewfile, "cask %q\n", name)
+			}
+			return casks, brewfile.Bytes(), nil
+		},
+		Install: func(names []string) [][]string {
+			return [][]string{append([]string{"brew", "install", "--cask"}, names...)}
+		},
+	},
+	{
+		Name: "apt", Binary: "apt-get", Platforms: []string{"linux"}, NativeFile: "apt.txt", Sudo: true,
+		List: linesWithNative("apt-mark", "showmanual"),
+		Install: func(names []string) [][]string {
+			return [][]string{append([]string{"apt-get", "install", "-y"}, names...)}
+		},
+	},
+	{
+		Name: "dnf", Binary: "dnf", Platforms: []string{"linux"}, NativeFile: "dnf.txt", Sudo: true,
+		List: linesWithNative("dnf", "repoquery", "--userinstalled", "--qf", "%{name}\n"),
+		Install: func(names []string) [][]string {
+			return [][]string{append([]string{"dnf", "install", "-y"}, names...)}
+		},
+	},
+	{
+		Name: "pacman", Binary: "pacman", Platforms: []string{"linux"}, NativeFile: "pacman.txt", Sudo: true,
+		List: linesWithNative("pacman", "-Qqe"),
+		Install: func(names []string) [][]string {
+			return [][]string{append([]string{"pacman", "-S", "--needed", "--noconfirm"}, names...)}
+		},
+	},
+	{
+		Name: "winget", Binary: "winget", Platforms: []string{"windows"}, NativeFile: "winget.json",
+		List: func() ([]string, []byte, error) {
+			tmp, err := os.CreateTemp("", "profilesync-winget-*.json")
+			if err != nil {
+				return nil, nil, err
+			}
+			tmp.Close()
+			defer os.Remove(tmp.Name())
+
+			if _, err := exec.Command("winget", "export", "-o", tmp.Name(), "--accept-source-agreements").Output(); err != nil {
+				return nil, nil, err
+			}
+			data, err := os.ReadFile(tmp.Name())
+			if err != nil {
+				return nil, nil, err
+			}
+
+			var export struct {
+				Sources []struct {
+					Packages []struct {
+						PackageIdentifier string
+					}
+				}
+			}
+			if err := json.Unmarshal(data, &export); err != nil {
+				return nil, nil, err
+			}
+			var ids []string
+			for _, source := range export.Sources {
+				for _, pkg := range source.Packages {
+					ids = append(ids, pkg.PackageIdentifier)
+				}
+			}
+			return ids, data, nil
+		},
+		Install: func(names []string) [][]string {
+			var commands [][]string
+			for _, name := range names {
+				commands = append(commands, []string{"winget", "install", "--id", name, "-e", "--accept-package-agreements", "--accept-source-agreements"})
+			}
+			return commands
+		},
+	},
+	{
+		Name: "scoop", Binary: "scoop", Platforms: []string{"windows"}, NativeFile: "scoop.json",
+		List: func() ([]string, []byte, error) {
+			data, err := exec.Command("scoop", "export").Output()
+			if err != nil {
+				return nil, nil, err
+			}
+			var export struct {
+				Apps []struct {
+					Name string
+				}
+			}
+			if err := json.Unmarshal(data, &export); err != nil {
+				return nil, nil, err
+			}
+			var names []string
+			for _, app := range export.Apps {
+				names = append(names, app.Name)
+			}
+			return names, data, nil
+		},
+		Install: func(names []string) [][]string {
+			return [][]string{append([]string{"scoop", "install"}, names...)}
+		},
+	},
+	{
+		Name: "choco", Binary: "choco", Platforms: []string{"windows"}, NativeFile: "choco.txt",
+		List: func() ([]string, []byte, error) {
+			lines, err := commandLines("choco", "list", "--limit-output")
+			if err != nil {
+				return nil, nil, err
+			}
+			var names []string
+			for _, line := range lines {
+				names = append(names, strings.SplitN(line, "|", 2)[0])
+			}
+			return names, []byte(strings.Join(lines, "\n") + "\n"), nil
+		},
+		Install: func(names []string) [][]string {
+			return [][]string{append([]string{"choco", "install", "-y"}, names...)}
+		},
+	},
+}
+
+// packageAliases map the same software across package managers. Each row
+// lists the name a package goes by in every manager that carries it
+var packageAliases = []map[string]string{
+	{"apt": "git", "dnf": "git", "pacman": "git", "brew": "git", "winget": "Git.Git", "scoop": "git", "choco": "git"},
+	{"apt": "vim", "dnf": "vim-enhanced", "pacman": "vim", "brew": "vim", "winget": "vim.vim", "scoop": "vim", "choco": "vim"},
+	{"apt": "neovim", "dnf": "neovim", "pacman": "neovim", "brew": "neovim", "winget": "Neovim.Neovim", "scoop": "neovim", "choco": "neovim"},
+	{"apt": "emacs", "dnf": "emacs", "pacman": "emacs", "brew-cask": "emacs", "winget": "GNU.Emacs", "scoop": "emacs", "choco": "emacs"},
+	{"apt": "tmux", "dnf": "tmux", "pacman": "tmux", "brew": "tmux"},
+	{"apt": "zsh", "dnf": "zsh", "pacman": "zsh", "brew": "zsh"},
+	{"apt": "fish", "dnf": "fish", "pacman": "fish", "brew": "fish", "scoop": "fish"},
+	{"apt": "ripgrep", "dnf": "ripgrep", "pacman": "ripgrep", "brew": "ripgrep", "winget": "BurntSushi.ripgrep.MSVC", "scoop": "ripgrep", "choco": "ripgrep"},
+	{"apt": "fd-find", "dnf": "fd-find", "pacman": "fd", "brew": "fd", "winget": "sharkdp.fd", "scoop": "fd", "choco": "fd"},
+	{"apt": "bat", "dnf": "bat", "pacman": "bat", "brew": "bat", "winget": "sharkdp.bat", "scoop": "bat", "choco": "bat"},
+	{"apt": "fzf", "dnf": "fzf", "pacman": "fzf", "brew": "fzf", "winget": "junegunn.fzf", "scoop": "fzf", "choco": "fzf"},
+	{"apt": "jq", "dnf": "jq", "pacman": "jq", "brew": "jq", "winget": "jqlang.jq", "scoop": "jq", "choco": "jq"},
+	{"apt": "curl", "dnf": "curl", "pacman": "curl", "brew": "curl", "winget": "cURL.cURL", "scoop": "curl", "choco": "curl"},
+	{"apt": "wget", "dnf": "wget", "pacman": "wget", "brew": "wget", "winget": "JernejSimoncic.Wget", "scoop": "wget", "choco": "wget"},
+	{"apt": "golang", "dnf": "golang", "pacman": "go", "brew": "go", "winget": "GoLang.Go", "scoop": "go", "choco": "golang"},
+	{"apt": "nodejs", "dnf": "nodejs", "pacman": "nodejs", "brew": "node", "winget": "OpenJS.NodeJS", "scoop": "nodejs", "choco": "nodejs"},
+	{"apt": "python3", "dnf": "python3", "pacman": "python", "brew": "python", "winget": "Python.Python.3.12", "scoop": "python", "choco": "python"},
+	{"apt": "docker.io", "dnf": "moby-engine", "pacman": "docker", "brew-cask": "docker", "winget": "Docker.DockerDesktop", "choco": "docker-desktop"},
+	{"apt": "kubectl", "dnf": "kubectl", "pacman": "kubectl", "brew": "kubectl", "winget": "Kubernetes.kubectl", "scoop": "kubectl", "choco": "kubernetes-cli"},
+	{"apt": "awscli", "dnf": "awscli", "pacman": "aws-cli", "brew": "awscli", "winget": "Amazon.AWSCLI", "scoop": "aws", "choco": "awscli"},
+	{"brew-cask": "visual-studio-code", "winget": "Microsoft.VisualStudioCode", "scoop": "vscode", "choco": "vscode"},
+	{"brew-cask": "firefox", "winget": "Mozilla.Firefox", "scoop": "firefox", "choco": "firefox", "apt": "firefox", "dnf": "firefox", "pacman": "firefox"},
+	{"brew-cask": "google-chrome", "winget": "Google.Chrome", "scoop": "googlechrome", "choco": "googlechrome"},
+}
+
+// unixManagers share most package names, so names carry over when no alias is known
+var unixManagers = map[string]bool{"apt": true, "dnf": true, "pacman": true, "brew": true}
+
+// mapPackageName translates a package between managers, reporting false when
+// no reasonable equivalent is known
+func mapPackageName(name, from, to string) (string, bool) {
+	if from == to {
+		return name, true
+	}
+	for _, row := range packageAliases {
+		if row[from] == name {
+			if target, ok := row[to]; ok {
+				return target, true
+			}
+			return "", false
+		}
+	}
+	if unixManagers[from] && unixManagers[to] {
+		return name, true
+	}
+	return "", false
+}
+
+// commandLines runs a command and returns its non-empty output lines
+func commandLines(name string, args ...string) ([]string, error) {
+	metrics.request(name)
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// linesWithNative lists packages one per output line, keeping the output as the native file
+func linesWithNative(name string, args ...string) func() ([]string, []byte, error) {
+	return func() ([]string, []byte, error) {
+		lines, err := commandLines(name, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return lines, []byte(strings.Join(lines, "\n") + "\n"), nil
+	}
+}
+
+// availableManagers returns the package managers present on this machine
+func availableManagers(platform string) []packageManager {
+	var found []packageManager
+	for _, pm := range packageManagers {
+		supported := false
+		for _, p := range pm.Platforms {
+			supported = supported || p == platform
+		}
+		if !supported {
+			continue
+		}
+		if _, err := exec.LookPath(pm.Binary); err == nil {
+			found = append(found, pm)
+		}
+	}
+	return found
+}
+
+// CapturePackages records installed packages from every available manager
+// into dir/packages, writing each manager's native export alongside a
+// normalized manifest
+func CapturePackages(dir string) (*PackageManifest, error) {
+	platform := DetectPlatform()
+	manifest := &PackageManifest{
+		Platform:   platform,
+		CapturedAt: time.Now().UTC(),
+		Managers:   map[string][]string{},
+	}
+
+	pkgDir := filepath.Join(dir, "packages")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		return nil, err
+	}
+
+	for _, pm := range availableManagers(platform) {
+		names, native, err := pm.List()
+		if err != nil {
+			warnColor.Printf("⚠️  Could not list %s packages: %v\n", pm.Name, err)
+			continue
+		}
+		sort.Strings(names)
+		manifest.Managers[pm.Name] = names
+		if err := os.WriteFile(filepath.Join(pkgDir, pm.NativeFile), native, 0644); err != nil {
+			return nil, err
+		}
+		successColor.Printf("✅ Captured %d %s packages\n", len(names), pm.Name)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, "packages.json"), data, 0644); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// loadPackageManifest reads the manifest written by CapturePackages
+func loadPackageManifest(dir string) (*PackageManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "packages", "packages.json"))
+	if err != nil {
+		return nil, err
+	}
+	var manifest PackageManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// InstallPackages reinstalls a captured manifest with this machine's package
+// managers, translating names where needed. Packages with no known
+// equivalent are reported and left out
+func InstallPackages(manifest *PackageManifest, dryRun bool) error {
+	platform := DetectPlatform()
+	targets := availableManagers(platform)
+	if len(targets) == 0 {
+		return fmt.Errorf("no supported package manager found on %s", platform)
+	}
+
+	// Casks only make sense to brew on macOS; everything else goes to the first manager
+	targetFor := func(from string) packageManager {
+		for _, pm := range targets {
+			if pm.Name == from {
+				return pm
+			}
+		}
+		if from == "brew-cask" {
+			for _, pm := range targets {
+				if pm.Name == "brew-cask" {
+					return pm
+				}
+			}
+		}
+		return targets[0]
+	}
+
+	planned := map[string][]string{}
+	var unmapped []string
+	var sources []string
+	for from := range manifest.Managers {
+		sources = append(sources, from)
+	}
+	sort.Strings(sources)
+
+	for _, from := range sources {
+		target := targetFor(from)
+		for _, name := range manifest.Managers[from] {
+			mapped, ok := mapPackageName(name, from, target.Name)
+			if !ok {
+				unmapped = append(unmapped, fmt.Sprintf("%s (%s)", name, from))
+				continue
+			}
+			planned[target.Name] = append(planned[target.Name], mapped)
+		}
+	}
+
+	var failed []string
+	for _, pm := range targets {
+		names := dedupe(planned[pm.Name])
+		if len(names) == 0 {
+			continue
+		}
+		for _, command := range pm.Install(names) {
+			if pm.Sudo && runtime.GOOS != "windows" && os.Geteuid() != 0 {
+				command = append([]string{"sudo"}, command...)
+			}
+			if dryRun {
+				noticeColor.Printf("📦 Would run: %s\n", strings.Join(command, " "))
+				continue
+			}
+			noticeColor.Printf("📦 Running: %s\n", strings.Join(command, " "))
+			cmd := exec.Command(command[0], command[1:]...)
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+			if err := cmd.Run(); err != nil {
+				errorColor.Printf("❌ %s failed: %v\n", command[0], err)
+				failed = append(failed, strings.Join(command, " "))
+			}
+		}
+	}
+
+	if len(unmapped) > 0 {
+		warnColor.Printf("⚠️  No %s equivalent known for %d package(s):\n", targets[0].Name, len(unmapped))
+		for _, name := range unmapped {
+			warnColor.Printf("   • %s\n", name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d install command(s) failed", len(failed))
+	}
+	return nil
+}
+
+// dedupe returns the sorted unique values of names
+func dedupe(names []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// runCapture dispatches `profilesync capture <what>`, or captures the whole
+// setup of this machine when no target is given
+func runCapture(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runCaptureProfile(args)
+		return
+	}
+
+	switch args[0] {
+	case "packages":
+		runCapturePackages(args[1:])
+	case "extensions":
+		runCaptureExtensions(args[1:])
+	case "defaults":
+		runCaptureDefaults(args[1:])
+	case "dconf":
+		runCaptureDconf(args[1:])
+	case "registry":
+		runCaptureRegistry(args[1:])
+	case "tasks":
+		runCaptureTasks(args[1:])
+	case "gpg":
+		runCaptureGPG(args[1:])
+	case "plugins":
+		runCapturePlugins(args[1:])
+	case "secrets":
+		runCaptureSecrets(args[1:])
+	default:
+		errorColor.Println("❌ Unknown capture target:", args[0])
+		errorColor.Println("Must be one of: packages, extensions, defaults, dconf, registry, tasks, gpg, plugins, secrets")
+		os.Exit(2)
+	}
+}
+
+// runCapturePackages handles `profilesync capture packages`
+func runCapturePackages(args []string) {
+	flags := newFlagSet("capture packages", "capture packages [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to write to")
+	flags.Parse(args)
+
+	manifest, err := CapturePackages(*dir)
+	if err != nil {
+		errorColor.Println("❌ Error capturing packages:", err)
+		os.Exit(1)
+	}
+	if len(manifest.Managers) == 0 {
+		warnColor.Println("⚠️  No supported package manager found")
+		return
+	}
+	successColor.Printf("✅ Package lists saved to %s\n", filepath.Join(*dir, "packages"))
+}
+
+// runApplyPackages handles `profilesync apply packages`
+func runApplyPackages(args []string) {
+	flags := newFlagSet("apply packages", "apply packages [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to read from")
+	dryRun := flags.Bool("dry-run", true, "Show install commands without running them")
+	flags.Parse(args)
+
+	manifest, err := loadPackageManifest(*dir)
+	if err != nil {
+		errorColor.Println("❌ Error reading package manifest (run `profilesync capture packages` first):", err)
+		os.Exit(1)
+	}
+
+	noticeColor.Printf("📦 Installing packages captured on %s (%s)\n", manifest.Platform, manifest.CapturedAt.Format(time.RFC1123))
+	if err := InstallPackages(manifest, *dryRun); err != nil {
+		errorColor.Println("❌ Error installing packages:", err)
+		os.Exit(1)
+	}
+	if *dryRun {
+		warnColor.Println("⚠️  This was a DRY RUN. Run with --dry-run=false to install.")
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultRegistry is the community registry of mapping packs
+const defaultRegistry = "git+https://github.com/hallucinaut/profilesync-registry.git//index.yaml"
+
+// packComment marks the extends entries `mappings add` manages, e.g.
+// "# pack neovim@1.2.0"
+var packComment = regexp.MustCompile(`^#\s*pack\s+(\S+)@(\S+)\s*$`)
+
+// packIndex is a registry's index: the mapping packs it hosts, by name.
+// A pack is a config file, usually just catalog entries and mappings for
+// one application, that the config extends
+type packIndex struct {
+	Packs map[string]packInfo `yaml:"packs"`
+}
+
+// packInfo is one pack and its published versions
+type packInfo struct {
+	Description string                 `yaml:"description"`
+	Versions    map[string]packVersion `yaml:"versions"`
+}
+
+// packVersion is where one version of a pack lives, relative to the
+// index, and its checksum. Published versions must never change
+type packVersion struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// installedPack is a pack the config extends
+type installedPack struct {
+	Name, Version, Location string
+}
+
+// registryLocation is the registry to use: the flag if given, then
+// PROFILESYNC_REGISTRY, then the community registry
+func registryLocation(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if env := os.Getenv("PROFILESYNC_REGISTRY"); env != "" {
+		return env
+	}
+	return defaultRegistry
+}
+
+// loadPackIndex fetches a registry's index, falling back to the last copy
+// fetched when offline
+func loadPackIndex(registry string) (*packIndex, error) {
+	if !isRemoteConfig(registry) {
+		return nil, fmt.Errorf("registry %s must be an https or git+ location", registry)
+	}
+	data, err := readRemoteConfig(registry)
+	if err != nil {
+		return nil, fmt.Errorf("registry: %w", err)
+	}
+	var index packIndex
+	if err := yaml.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("registry %s: %w", registry, err)
+	}
+	return &index, nil
+}
+
+// compareVersions orders versions like 1.2.0 and v1.10: numeric parts
+// compare as numbers, anything else as text
+func compareVersions(a, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(strings.TrimPrefix(v, "v"), func(r rune) bool { return r == '.' || r == '-' || r == '+' })
+	}
+	pa, pb := split(a), split(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil && na != nb:
+			if na < nb {
+				return -1
+			}
+			return 1
+		case (errA != nil || errB != nil) && pa[i] != pb[i]:
+			return strings.Compare(pa[i], pb[i])
+		}
+	}
+	switch {
+	case len(pa) < len(pb):
+		return -1
+	case len(pa) > len(pb):
+		return 1
+	}
+	return 0
+}
+
+// latest is the highest version of a pack
+func (p packInfo) latest() string {
+	best := ""
+	for v := range p.Versions {
+		if best == "" || compareVersions(v, best) > 0 {
+			best = v
+		}
+	}
+	return best
+}
+
+// sortedVersions lists a pack's versions, newest first
+func (p packInfo) sortedVersions() []string {
+	versions := sortedKeys(p.Versions)
+	sort.SliceStable(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) > 0 })
+	return versions
+}
+
+// packLocation is the pinned config location of one version of a pack
+func packLocation(registry, name, version string, v packVersion) (string, error) {
+	if v.URL == "" {
+		return "", fmt.Errorf("%s@%s has no url in the registry", name, version)
+	}
+	if len(v.SHA256) != 64 {
+		return "", fmt.Errorf("%s@%s has no sha256 in the registry, so it cannot be verified", name, version)
+	}
+	r, err := parseRemoteConfig(registry)
+	if err != nil {
+		return "", err
+	}
+	location, err := r.resolve(v.URL)
+	if err != nil {
+		return "", err
+	}
+	location, _, _ = strings.Cut(location, "#")
+	return location + "#sha256=" + strings.ToLower(v.SHA256), nil
+}
+
+// configExtends returns the extends list of a config tree, turning a
+// single entry into a list
+func configExtends(doc *yaml.Node) *yaml.Node {
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "extends" {
+			continue
+		}
+		value := root.Content[i+1]
+		switch {
+		case value.Kind == yaml.SequenceNode:
+		case value.Kind == yaml.ScalarNode && value.Tag == "!!null":
+			root.Content[i+1] = &yaml.Node{Kind: yaml.SequenceNode}
+		default:
+			root.Content[i+1] = &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{value}}
+		}
+		return root.Content[i+1]
+	}
+	// Extended files come first, so the config's own settings override them
+	list := &yaml.Node{Kind: yaml.SequenceNode}
+	root.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: "extends"}, list}, root.Content...)
+	return list
+}
+
+// packEntry returns the pack name and version an extends entry was added
+// for, if `mappings add` added it
+func packEntry(n *yaml.Node) (name, version string, ok bool) {
+	m := packComment.FindStringSubmatch(n.LineComment)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// installedPacks lists the packs a config file extends
+func installedPacks(path string) ([]installedPack, error) {
+	doc, err := readConfigTree(path)
+	if err != nil {
+		return nil, err
+	}
+	var packs []installedPack
+	for _, n := range configExtends(doc).Content {
+		if name, version, ok := packEntry(n); ok {
+			packs = append(packs, installedPack{Name: name, Version: version, Location: n.Value})
+		}
+	}
+	return packs, nil
+}
+
+// setPack records a pack version in the config's extends, replacing the
+// version already there. It returns the version replaced, if any
+func setPack(path, name, version, location string) (string, error) {
+	doc, err := readConfigTree(path)
+	if err != nil {
+		return "", err
+	}
+	list := configExtends(doc)
+	entry := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: location, LineComment: "# pack " + name + "@" + version}
+	previous := ""
+	replaced := false
+	for i, n := range list.Content {
+		if existing, v, ok := packEntry(n); ok && existing == name {
+			list.Content[i] = entry
+			previous, replaced = v, true
+			break
+		}
+	}
+	if !replaced {
+		list.Content = append(list.Content, entry)
+	}
+	return previous, writeConfigTree(path, doc)
+}
+
+// removePack drops a pack from the config's extends
+func removePack(path, name string) (bool, error) {
+	doc, err := readConfigTree(path)
+	if err != nil {
+		return false, err
+	}
+	list := configExtends(doc)
+	kept := list.Content[:0]
+	removed := false
+	for _, n := range list.Content {
+		if existing, _, ok := packEntry(n); ok && existing == name {
+			removed = true
+			continue
+		}
+		kept = append(kept, n)
+	}
+	if !removed {
+		return false, nil
+	}
+	list.Content = kept
+	return true, writeConfigTree(path, doc)
+}
+
+// runMappings handles `profilesync mappings search|add|list|remove`
+func runMappings(args []string) {
+	if len(args) == 0 {
+		errorColor.Println("❌ Usage: profilesync mappings search|add|list|remove [flags]")
+		os.Exit(2)
+	}
+	sub, args := args[0], args[1:]
+
+	switch sub {
+	case "search":
+		flags := newFlagSet("mappings search", "mappings search [flags] [TERM]")
+		registry := flags.String("registry", "", "Registry index to search (default $PROFILESYNC_REGISTRY or "+defaultRegistry+")")
+		configFile := flags.String("config", configPath(), "Config file whose packs to mark")
+		flags.Parse(args)
+
+		index, err := loadPackIndex(registryLocation(*registry))
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(1)
+		}
+		installed := map[string]string{}
+		if packs, err := installedPacks(*configFile); err == nil {
+			for _, p := range packs {
+				installed[p.Name] = p.Version
+			}
+		}
+		term := strings.ToLower(strings.Join(flags.Args(), " "))
+		found := 0
+		for _, name := range sortedKeys(index.Packs) {
+			pack := index.Packs[name]
+			if term != "" && !strings.Contains(strings.ToLower(name+" "+pack.Description), term) {
+				continue
+			}
+			found++
+			mark := ""
+			if v, ok := installed[name]; ok {
+				mark = " [added " + v + "]"
+			}
+			fmt.Printf("   %-20s %-10s %s%s\n", name, pack.latest(), pack.Description, mark)
+		}
+		if found == 0 {
+			warnColor.Println("⚠️  No packs found")
+		}
+
+	case "add":
+		flags := newFlagSet("mappings add", "mappings add [flags] NAME[@VERSION]...")
+		registry := flags.String("registry", "", "Registry index to add from (default $PROFILESYNC_REGISTRY or "+defaultRegistry+")")
+		configFile := flags.String("config", configPath(), "Config file to add the packs to")
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		reg := registryLocation(*registry)
+		index, err := loadPackIndex(reg)
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(1)
+		}
+		failed := 0
+		for _, arg := range flags.Args() {
+			if err := addPack(index, reg, *configFile, arg); err != nil {
+				errorColor.Printf("❌ %s: %v\n", arg, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+
+	case "list":
+		flags := newFlagSet("mappings list", "mappings list [flags]")
+		configFile := flags.String("config", configPath(), "Config file to list the packs of")
+		flags.Parse(args)
+
+		packs, err := installedPacks(*configFile)
+		if err != nil {
+			errorColor.Println("❌ Error reading config:", err)
+			os.Exit(1)
+		}
+		if len(packs) == 0 {
+			fmt.Println("No mapping packs added; find some with `profilesync mappings search`")
+			return
+		}
+		for _, p := range packs {
+			fmt.Printf("   %-20s %-10s %s\n", p.Name, p.Version, p.Location)
+		}
+
+	case "remove":
+		flags := newFlagSet("mappings remove", "mappings remove [flags] NAME...")
+		configFile := flags.String("config", configPath(), "Config file to remove the packs from")
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		failed := 0
+		for _, name := range flags.Args() {
+			removed, err := removePack(*configFile, name)
+			switch {
+			case err != nil:
+				errorColor.Printf("❌ %s: %v\n", name, err)
+				failed++
+			case !removed:
+				warnColor.Printf("⚠️  %s is not in %s\n", name, *configFile)
+			default:
+				successColor.Printf("🗑️  Removed %s from %s\n", name, *configFile)
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+
+	default:
+		errorColor.Printf("❌ Unknown mappings command: %s\n", sub)
+		errorColor.Println("Must be one of: search, add, list, remove")
+		os.Exit(2)
+	}
+}
+
+// addPack adds NAME[@VERSION] from the registry to the config, after
+// checking the pack matches its checksum and loads as a config
+func addPack(index *packIndex, registry, configFile, arg string) error {
+	name, version, _ := strings.Cut(arg, "@")
+	pack, ok := index.Packs[name]
+	if !ok {
+		return fmt.Errorf("no pack named %s in the registry", name)
+	}
+	if version == "" {
+		version = pack.latest()
+	}
+	v, ok := pack.Versions[version]
+	if !ok {
+		return fmt.Errorf("no version %s (available: %s)", version, strings.Join(pack.sortedVersions(), ", "))
+	}
+	location, err := packLocation(registry, name, version, v)
+	if err != nil {
+		return err
+	}
+	// Fetching verifies the checksum and caches the pack for later runs
+	if _, err := readRemoteConfig(location); err != nil {
+		return err
+	}
+	if _, err := loadConfig(location, ""); err != nil {
+		return fmt.Errorf("pack does not load: %w", err)
+	}
+
+	previous, err := setPack(configFile, name, version, location)
+	switch {
+	case err != nil:
+		return err
+	case previous == version:
+		noticeColor.Printf("📦 %s@%s is already in %s\n", name, version, configFile)
+	case previous != "":
+		successColor.Printf("📦 Changed %s from %s to %s in %s\n", name, previous, version, configFile)
+	default:
+		successColor.Printf("📦 Added %s@%s to %s\n", name, version, configFile)
+	}
+	return nil
+}
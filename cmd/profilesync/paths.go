@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errPathEscapesRoot is returned when a computed path would land outside its root
+var errPathEscapesRoot = errors.New("path escapes destination root")
+
+// secureJoin joins rel onto root and verifies the result stays under root.
+// Absolute paths, volume names and ".." segments that climb out of root are
+// rejected, as are symlinked ancestors that point outside it. Validation is
+// purely string based apart from resolving existing ancestors, so it does not
+// depend on platform path length limits
+func secureJoin(root, rel string) (string, error) {
+	if rel == "" {
+		return filepath.Clean(root), nil
+	}
+
+	native := filepath.FromSlash(rel)
+	if filepath.IsAbs(native) || filepath.VolumeName(native) != "" || strings.HasPrefix(native, string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q is not relative", errPathEscapesRoot, rel)
+	}
+
+	cleanRoot := filepath.Clean(root)
+	joined := filepath.Join(cleanRoot, native)
+	if !withinRoot(cleanRoot, joined) {
+		return "", fmt.Errorf("%w: %q", errPathEscapesRoot, rel)
+	}
+
+	if err := checkResolvedWithinRoot(cleanRoot, joined); err != nil {
+		return "", err
+	}
+
+	return joined, nil
+}
+
+// withinRoot reports whether path is root or lexically below it
+func withinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// checkResolvedWithinRoot resolves the deepest existing ancestor of path and
+// makes sure symlinks do not redirect it outside root. The leaf itself is left
+// to the symlink handling of the copy
+func checkResolvedWithinRoot(root, path string) error {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		// A root that does not exist yet cannot contain symlinks
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	existing := filepath.Dir(path)
+	if !withinRoot(root, existing) {
+		return nil
+	}
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing || !withinRoot(root, parent) {
+			return nil
+		}
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		// Dangling links are reported by the copy itself
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !withinRoot(resolvedRoot, resolved) {
+		return fmt.Errorf("%w: %s resolves to %s", errPathEscapesRoot, existing, resolved)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecureJoin(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real", filepath.Join(root, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, rel string
+		want      string // relative to root; "" when rejected
+	}{
+		{"empty", "", "."},
+		{"file", ".bashrc", ".bashrc"},
+		{"nested", ".config/nvim/init.lua", ".config/nvim/init.lua"},
+		{"dot segments that stay inside", "a/../b/./c", "b/c"},
+		{"missing ancestors", "new/dir/file", "new/dir/file"},
+		{"symlink inside the root", "inside/file", "inside/file"},
+		{"parent", "..", ""},
+		{"climbing out", "../etc/passwd", ""},
+		{"climbing out after a segment", "a/../../etc/passwd", ""},
+		{"absolute", "/etc/passwd", ""},
+		{"symlinked ancestor outside", "escape/file", ""},
+		// The leaf is left to the symlink handling of the copy
+		{"symlink outside as the leaf", "escape", "escape"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := secureJoin(root, tt.rel)
+			if tt.want == "" {
+				if !errors.Is(err, errPathEscapesRoot) {
+					t.Errorf("secureJoin(%q) = %q, %v; want errPathEscapesRoot", tt.rel, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("secureJoin(%q): %v", tt.rel, err)
+			}
+			if want := filepath.Join(root, filepath.FromSlash(tt.want)); got != want {
+				t.Errorf("secureJoin(%q) = %q, want %q", tt.rel, got, want)
+			}
+		})
+	}
+}
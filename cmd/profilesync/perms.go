@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PermissionDiff compares a source file's permissions and ownership with its migrated copy
+type PermissionDiff struct {
+	SourcePath      string
+	DestinationPath string
+	SourceMode      fs.FileMode
+	DestMode        fs.FileMode
+	SourceOwner     string
+	DestOwner       string
+	Sensitive       bool
+}
+
+// MorePermissive reports whether the copy grants any permission bit the source did not
+func (d PermissionDiff) MorePermissive() bool {
+	return d.DestMode.Perm()&^d.SourceMode.Perm() != 0
+}
+
+// Changed reports whether mode or ownership differ at all
+func (d PermissionDiff) Changed() bool {
+	return d.SourceMode.Perm() != d.DestMode.Perm() || d.SourceOwner != d.DestOwner
+}
+
+// sensitiveDirs hold keys and credentials whose permissions matter most
+var sensitiveDirs = []string{".ssh", ".gnupg", "ssh", "aws", ".aws", "kubectl", ".kube"}
+
+// isSensitivePath reports whether a path lies in a credential directory
+func isSensitivePath(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		for _, dir := range sensitiveDirs {
+			if part == dir {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordPermissions compares every file of a migrated item with its source
+func (ps *ProfileSync) recordPermissions(ctx context.Context, item MigrationItem) error {
+	// Modes on DrvFs are made up, so there is nothing to compare
+	if ps.sourceDrvFs || ps.destDrvFs {
+		return nil
+	}
+	info, err := os.Stat(item.SourcePath)
+	if err != nil {
+		return err
+	}
+
+	var diffs []PermissionDiff
+	if !info.IsDir() {
+		diff, err := comparePermissions(item.SourcePath, item.DestinationPath, info)
+		if err != nil {
+			return err
+		}
+		diffs = append(diffs, diff)
+	} else {
+		var mu sync.Mutex
+		err = parallelWalk(ctx, item.SourcePath, ps.jobs, func(path string, d fs.DirEntry) (bool, error) {
+			if !d.Type().IsRegular() && !d.IsDir() {
+				return false, nil
+			}
+			rel, err := filepath.Rel(item.SourcePath, path)
+			if err != nil {
+				return false, err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return false, err
+			}
+			diff, err := comparePermissions(path, filepath.Join(item.DestinationPath, rel), info)
+			if err != nil {
+				return false, err
+			}
+			mu.Lock()
+			diffs = append(diffs, diff)
+			mu.Unlock()
+			return d.IsDir(), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if item.Sensitivity == sensitivitySecret {
+		for i := range diffs {
+			diffs[i].Sensitive = true
+		}
+	}
+	ps.migrationPlan.Permissions = append(ps.migrationPlan.Permissions, diffs...)
+	return nil
+}
+
+// comparePermissions stats the copy of a source file
+func comparePermissions(src, dst string, srcInfo fs.FileInfo) (PermissionDiff, error) {
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return PermissionDiff{}, err
+	}
+	return PermissionDiff{
+		SourcePath:      src,
+		DestinationPath: dst,
+		SourceMode:      srcInfo.Mode(),
+		DestMode:        dstInfo.Mode(),
+		SourceOwner:     fileOwner(srcInfo),
+		DestOwner:       fileOwner(dstInfo),
+		Sensitive:       isSensitivePath(src),
+	}, nil
+}
+
+// printPermissionsReport lists files whose permissions or ownership changed,
+// highlighting anything that became more permissive
+func (ps *ProfileSync) printPermissionsReport() {
+	diffs := ps.migrationPlan.Permissions
+	if len(diffs) == 0 {
+		return
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].SourcePath < diffs[j].SourcePath })
+
+	infoColor.Println("🔐 Permissions:")
+
+	changed, loosened := 0, 0
+	for _, d := range diffs {
+		if !d.Changed() && !(ps.verbose && d.Sensitive) {
+			continue
+		}
+		line := fmt.Sprintf("  • %s: %s → %s", d.DestinationPath, d.SourceMode.Perm(), d.DestMode.Perm())
+		if d.SourceOwner != d.DestOwner {
+			line += fmt.Sprintf(" (owner %s → %s)", d.SourceOwner, d.DestOwner)
+		}
+		switch {
+		case d.MorePermissive():
+			loosened++
+			changed++
+			errorColor.Println(line + " ⚠️  more permissive")
+		case d.Changed():
+			changed++
+			warnColor.Println(line)
+		default:
+			successColor.Println(line)
+		}
+	}
+
+	switch {
+	case loosened > 0:
+		errorColor.Printf("❌ %d of %d files ended up more permissive than their source\n", loosened, len(diffs))
+	case changed > 0:
+		warnColor.Printf("⚠️  %d of %d files changed permissions or owner, none more permissive\n", changed, len(diffs))
+	default:
+		successColor.Printf("✅ All %d files kept their permissions and owner\n", len(diffs))
+	}
+
+	infoColor.Println(strings.Repeat("=", 60))
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pluginProtocol is the version of the plugin protocol this build speaks
+const pluginProtocol = 1
+
+// pluginTimeout bounds one call to a plugin
+const pluginTimeout = 2 * time.Minute
+
+// pluginPrefix names plugins found on PATH: plugin "obsidian" is the
+// executable profilesync-plugin-obsidian
+const pluginPrefix = "profilesync-plugin-"
+
+var pluginName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// plugin is an external handler for applications profilesync does not know
+// itself. Each call runs the executable with one JSON request on stdin and
+// reads one JSON response from stdout
+type plugin struct {
+	Path       string
+	Name       string
+	Catalog    []CatalogEntry
+	Transforms map[string]bool
+	Capture    bool
+}
+
+// pluginRequest is what profilesync sends a plugin. Method is describe,
+// transform, capture or apply; the other fields depend on it
+type pluginRequest struct {
+	Protocol  int               `json:"protocol"`
+	Method    string            `json:"method"`
+	Platform  string            `json:"platform,omitempty"`
+	Home      string            `json:"home,omitempty"`
+	Mapping   string            `json:"mapping,omitempty"`
+	Content   *string           `json:"content,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Files     map[string]string `json:"files,omitempty"`
+	DryRun    bool              `json:"dry_run,omitempty"`
+}
+
+// pluginResponse is what a plugin answers. A non-empty Error fails the call
+type pluginResponse struct {
+	Error    string   `json:"error"`
+	Messages []string `json:"messages"`
+
+	// describe
+	Name       string         `json:"name"`
+	Protocol   int            `json:"protocol"`
+	Catalog    []CatalogEntry `json:"catalog"`
+	Transforms []string       `json:"transforms"`
+	Capture    bool           `json:"capture"`
+
+	// transform
+	Content *string `json:"content"`
+
+	// capture
+	Files map[string]string `json:"files"`
+}
+
+var (
+	pluginsMu     sync.Mutex
+	pluginsByPath = map[string]*plugin{}
+)
+
+// findPlugin resolves a plugins entry: a bare name is looked up on PATH
+// with pluginPrefix, anything else is a path
+func findPlugin(entry string) (string, error) {
+	if strings.ContainsAny(entry, `/\`) {
+		return entry, nil
+	}
+	p, err := exec.LookPath(pluginPrefix + entry)
+	if err != nil {
+		return "", fmt.Errorf("%s%s not found on PATH", pluginPrefix, entry)
+	}
+	return p, nil
+}
+
+// loadPlugin starts the plugin at path and asks what it handles. Plugins
+// are described once per run
+func loadPlugin(path string) (*plugin, error) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if p, ok := pluginsByPath[path]; ok {
+		return p, nil
+	}
+
+	p := &plugin{Path: path, Name: filepath.Base(path)}
+	resp, err := p.call(pluginRequest{Method: "describe"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Protocol != pluginProtocol {
+		return nil, fmt.Errorf("%s speaks plugin protocol %d, not %d", path, resp.Protocol, pluginProtocol)
+	}
+	if !pluginName.MatchString(resp.Name) {
+		return nil, fmt.Errorf("%s: plugin name %q must be lowercase letters, digits, dots, dashes or underscores", path, resp.Name)
+	}
+	p.Name = resp.Name
+	p.Catalog = resp.Catalog
+	p.Capture = resp.Capture
+	p.Transforms = map[string]bool{}
+	for _, mapping := range resp.Transforms {
+		p.Transforms[mapping] = true
+	}
+	for _, e := range p.Catalog {
+		if err := e.validate(); err != nil {
+			return nil, fmt.Errorf("plugin %s: catalog entry %s: %w", p.Name, e.Mapping, err)
+		}
+	}
+	pluginsByPath[path] = p
+	return p, nil
+}
+
+// loadPlugins loads the plugins a config names, refusing two with the same name
+func loadPlugins(paths []string) ([]*plugin, error) {
+	var plugins []*plugin
+	seen := map[string]string{}
+	for _, path := range paths {
+		p, err := loadPlugin(path)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[p.Name]; ok {
+			if other == path {
+				continue
+			}
+			return nil, fmt.Errorf("plugins %s and %s are both named %s", other, path, p.Name)
+		}
+		seen[p.Name] = path
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+// call sends req to the plugin and decodes its response. Whatever the
+// plugin writes to stderr is shown when the call fails
+func (p *plugin) call(req pluginRequest) (*pluginResponse, error) {
+	req.Protocol = pluginProtocol
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.Stdin = bytes.NewReader(append(in, '\n'))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PROFILESYNC_PLUGIN_PROTOCOL=%d", pluginProtocol))
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("plugin %s: %s timed out after %s", p.Name, req.Method, pluginTimeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, lastLine(msg))
+		}
+		return nil, fmt.Errorf("plugin %s: %s: %w", p.Name, req.Method, err)
+	}
+
+	var resp pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("plugin %s: %s: invalid response: %w", p.Name, req.Method, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin %s: %s", p.Name, resp.Error)
+	}
+	return &resp, nil
+}
+
+// lastLine is the last line of s
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return strings.TrimSpace(s[i+1:])
+	}
+	return s
+}
+
+// transform asks the plugin to rewrite a migrated config
+func (p *plugin) transform(mapping, content, platform string, variables map[string]string) (string, error) {
+	resp, err := p.call(pluginRequest{
+		Method:    "transform",
+		Platform:  platform,
+		Mapping:   mapping,
+		Content:   &content,
+		Variables: variables,
+	})
+	if err != nil {
+		return "", err
+	}
+	p.printMessages(resp.Messages)
+	if resp.Content == nil {
+		return "", fmt.Errorf("plugin %s: transform returned no content", p.Name)
+	}
+	return *resp.Content, nil
+}
+
+// printMessages shows what a plugin reported
+func (p *plugin) printMessages(messages []string) {
+	for _, msg := range messages {
+		infoColor.Printf("🔌 %s: %s\n", p.Name, msg)
+	}
+}
+
+// pluginFor returns the plugin that transforms mapping, if any
+func pluginFor(plugins []*plugin, mapping string) *plugin {
+	for _, p := range plugins {
+		if p.Transforms[mapping] {
+			return p
+		}
+	}
+	return nil
+}
+
+// pluginsDir is where a plugin's captured files live in a profile store
+func pluginsDir(dir, name string) string {
+	return filepath.Join(dir, "plugins", name)
+}
+
+// CapturePlugin asks a plugin for the files to keep and replaces its
+// directory in the profile store with them
+func CapturePlugin(dir string, p *plugin) (int, error) {
+	platform := DetectPlatform()
+	resp, err := p.call(pluginRequest{Method: "capture", Platform: platform, Home: GetHomeDir(platform)})
+	if err != nil {
+		return 0, err
+	}
+	p.printMessages(resp.Messages)
+	for name := range resp.Files {
+		if clean := path.Clean(name); path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+			return 0, fmt.Errorf("plugin %s: captured file %q is outside its directory", p.Name, name)
+		}
+	}
+
+	target := pluginsDir(dir, p.Name)
+	if err := os.RemoveAll(target); err != nil {
+		return 0, err
+	}
+	for _, name := range sortedKeys(resp.Files) {
+		file := filepath.Join(target, filepath.FromSlash(path.Clean(name)))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return 0, err
+		}
+		if err := writeFileAtomic(file, []byte(resp.Files[name]), 0o644); err != nil {
+			return 0, err
+		}
+	}
+	return len(resp.Files), nil
+}
+
+// ApplyPlugin sends a plugin the files it captured so it can install them
+func ApplyPlugin(dir string, p *plugin, dryRun bool) error {
+	target := pluginsDir(dir, p.Name)
+	files := map[string]string{}
+	err := filepath.WalkDir(target, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(target, file)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	platform := DetectPlatform()
+	resp, err := p.call(pluginRequest{
+		Method:   "apply",
+		Platform: platform,
+		Home:     GetHomeDir(platform),
+		Files:    files,
+		DryRun:   dryRun,
+	})
+	if err != nil {
+		return err
+	}
+	p.printMessages(resp.Messages)
+	return nil
+}
+
+// configPlugins loads the plugins named by the config file given with
+// --config, or the default config if it exists
+func configPlugins(configFile, only string) ([]*plugin, error) {
+	path := configFile
+	if path == "" {
+		if _, err := os.Stat(configPath()); err != nil {
+			return nil, errors.New("no config file; plugins are listed under plugins in the config")
+		}
+		path = configPath()
+	}
+	cfg, err := loadConfig(path, "")
+	if err != nil {
+		return nil, err
+	}
+	var plugins []*plugin
+	for _, p := range cfg.Plugins {
+		if p.Capture && (only == "" || p.Name == only) {
+			plugins = append(plugins, p)
+		}
+	}
+	if only != "" && len(plugins) == 0 {
+		return nil, fmt.Errorf("no capturing plugin named %s in %s", only, path)
+	}
+	return plugins, nil
+}
+
+// runCapturePlugins handles `profilesync capture plugins`
+func runCapturePlugins(args []string) {
+	flags := newFlagSet("capture plugins", "capture plugins [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to write to")
+	configFile := flags.String("config", "", "Config file naming the plugins (default "+configPath()+")")
+	only := flags.String("plugin", "", "Only capture with this plugin")
+	flags.Parse(args)
+
+	plugins, err := configPlugins(*configFile, *only)
+	if err != nil {
+		errorColor.Println("❌ Error loading plugins:", err)
+		os.Exit(1)
+	}
+	if len(plugins) == 0 {
+		warnColor.Println("⏭️  No plugin captures anything; nothing to do")
+		return
+	}
+	failed := false
+	for _, p := range plugins {
+		n, err := CapturePlugin(*dir, p)
+		if err != nil {
+			errorColor.Println("❌ Error capturing:", err)
+			failed = true
+			continue
+		}
+		successColor.Printf("✅ %s: %d file(s) saved to %s\n", p.Name, n, pluginsDir(*dir, p.Name))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runApplyPlugins handles `profilesync apply plugins`
+func runApplyPlugins(args []string) {
+	flags := newFlagSet("apply plugins", "apply plugins [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to read from")
+	configFile := flags.String("config", "", "Config file naming the plugins (default "+configPath()+")")
+	only := flags.String("plugin", "", "Only apply with this plugin")
+	dryRun := flags.Bool("dry-run", true, "Ask the plugins what they would change without changing it")
+	flags.Parse(args)
+
+	plugins, err := configPlugins(*configFile, *only)
+	if err != nil {
+		errorColor.Println("❌ Error loading plugins:", err)
+		os.Exit(1)
+	}
+	failed := false
+	applied := 0
+	for _, p := range plugins {
+		if !isDir(pluginsDir(*dir, p.Name)) {
+			warnColor.Printf("⏭️  %s: nothing captured (run `profilesync capture plugins` first)\n", p.Name)
+			continue
+		}
+		noticeColor.Printf("🔌 Applying %s\n", p.Name)
+		if err := ApplyPlugin(*dir, p, *dryRun); err != nil {
+			errorColor.Println("❌ Error applying:", err)
+			failed = true
+			continue
+		}
+		applied++
+	}
+	if failed {
+		os.Exit(1)
+	}
+	if *dryRun && applied > 0 {
+		warnColor.Println("⚠️  This was a DRY RUN. Run with --dry-run=false to apply.")
+	}
+}
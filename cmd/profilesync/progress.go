@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// How often the progress bars are redrawn on a terminal, and progress is
+// logged elsewhere
+const (
+	progressRedraw      = 200 * time.Millisecond
+	progressLogInterval = 10 * time.Second
+)
+
+// migrationProgress shows how far a migration is, in bytes copied and
+// items done: bars redrawn in place on a terminal, or a line every few
+// seconds in logs. While it runs, colored output goes through it, so
+// messages are printed above the bars rather than over them
+type migrationProgress struct {
+	mu  sync.Mutex
+	out io.Writer
+	tty bool
+
+	started time.Time
+	base    int64 // bytes copied before the migration began
+	total   int64
+	count   int
+
+	item     int
+	itemName string
+	itemBase int64
+	itemSize int64
+	files    string
+	file     string
+	drawn    int
+	shown    string
+	paused   bool
+	lastLog  time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// liveProgress is the progress shown for the running migration, if any
+var liveProgress *migrationProgress
+
+// copiedBytes is how much the run has copied or cloned so far
+func copiedBytes() int64 {
+	return metrics.bytesWritten.Load() + metrics.bytesCloned.Load()
+}
+
+// startProgress starts showing the progress of copying total bytes in
+// count items
+func startProgress(total int64, count int) *migrationProgress {
+	p := &migrationProgress{
+		out:     color.Output,
+		tty:     showProgress,
+		started: time.Now(),
+		base:    copiedBytes(),
+		total:   total,
+		count:   count,
+		lastLog: time.Now(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	color.Output = p
+	liveProgress = p
+	go p.run()
+	return p
+}
+
+// run redraws the bars, or logs progress, until the progress is finished
+func (p *migrationProgress) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(progressRedraw)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.update()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// finish stops showing progress and clears the bars. Finishing again does
+// nothing
+func (p *migrationProgress) finish() {
+	if p == nil {
+		return
+	}
+	select {
+	case <-p.done:
+		// Already finished
+		return
+	default:
+	}
+	close(p.stop)
+	<-p.done
+	p.mu.Lock()
+	p.clear()
+	color.Output = p.out
+	liveProgress = nil
+	p.mu.Unlock()
+}
+
+// startItem moves on to item i, of size bytes
+func (p *migrationProgress) startItem(i int, name string, size int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.item, p.itemName, p.itemSize = i, name, size
+	p.itemBase = copiedBytes()
+	p.files, p.file = "", ""
+	p.mu.Unlock()
+}
+
+// endItem corrects the total with what the item really copied, which is
+// nothing when it was skipped or up to date
+func (p *migrationProgress) endItem() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.total = max(p.total+copiedBytes()-p.itemBase-p.itemSize, 0)
+	p.itemSize = 0
+	p.mu.Unlock()
+}
+
+// setFile records the file being copied
+func (p *migrationProgress) setFile(path string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.file = path
+	p.mu.Unlock()
+}
+
+// setFiles records how many files of a directory item are copied
+func (p *migrationProgress) setFiles(done, total int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.files = fmt.Sprintf("%d/%d files", done, total)
+	p.mu.Unlock()
+}
+
+// pauseProgress clears the bars and stops redrawing them until the
+// returned function is called, so the user can answer a question or use a
+// merge tool
+func pauseProgress() func() {
+	p := liveProgress
+	if p == nil {
+		return func() {}
+	}
+	p.mu.Lock()
+	p.clear()
+	p.paused = true
+	p.mu.Unlock()
+	return func() {
+		p.mu.Lock()
+		p.paused = false
+		p.mu.Unlock()
+	}
+}
+
+// Write implements io.Writer, printing output above the bars
+func (p *migrationProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	return p.out.Write(b)
+}
+
+// clear erases the bars from the terminal
+func (p *migrationProgress) clear() {
+	if p.drawn == 0 {
+		return
+	}
+	io.WriteString(p.out, "\r\x1b[K"+strings.Repeat("\x1b[1A\x1b[K", p.drawn-1))
+	p.drawn = 0
+}
+
+// update redraws the bars or logs progress, when it is time to
+func (p *migrationProgress) update() {
+	if p.paused {
+		return
+	}
+	done := min(copiedBytes()-p.base, p.total)
+	elapsed := time.Since(p.started)
+	var rate float64
+	eta := "--"
+	if elapsed > 2*time.Second && done > 0 {
+		rate = float64(done) / elapsed.Seconds()
+		eta = formatDuration(time.Duration(float64(p.total-done) / rate * float64(time.Second)))
+	}
+
+	if !p.tty {
+		if time.Since(p.lastLog) < progressLogInterval {
+			return
+		}
+		p.lastLog = time.Now()
+		fmt.Fprintf(p.out, "Progress: %s (%s of %s), item %d of %d (%s), ETA %s\n",
+			percent(done, p.total), formatSize(done), formatSize(p.total), p.item+1, p.count, p.itemName, eta)
+		logger().Info("progress", "bytes_done", done, "bytes_total", p.total, "item", p.item+1, "items", p.count)
+		return
+	}
+
+	width := consoleWidth() - 1
+	overall := fmt.Sprintf("%s %s %s/%s", progressBar(done, p.total, 24), percent(done, p.total), formatSize(done), formatSize(p.total))
+	if rate > 0 {
+		overall += fmt.Sprintf("  %s/s  ETA %s", formatSize(int64(rate)), eta)
+	}
+	itemDone := min(copiedBytes()-p.itemBase, p.itemSize)
+	current := fmt.Sprintf("%d/%d %s %s %s", p.item+1, p.count, progressBar(itemDone, p.itemSize, 12), percent(itemDone, p.itemSize), p.itemName)
+	if p.files != "" {
+		current += " (" + p.files + ")"
+	}
+	if p.file != "" {
+		current += ": " + filepath.Base(p.file)
+	}
+	lines := truncateLine(overall, width) + "\n" + truncateLine(current, width)
+	if p.drawn > 0 && lines == p.shown {
+		return
+	}
+	p.clear()
+	io.WriteString(p.out, lines)
+	p.drawn, p.shown = 2, lines
+}
+
+// progressBar draws done out of total as a bar width cells wide
+func progressBar(done, total int64, width int) string {
+	filled := width
+	if total > 0 {
+		filled = int(done * int64(width) / total)
+	}
+	full, empty := "█", "░"
+	if noEmoji {
+		full, empty = "#", "-"
+	}
+	return "[" + strings.Repeat(full, filled) + strings.Repeat(empty, width-filled) + "]"
+}
+
+// percent formats done out of total as a percentage
+func percent(done, total int64) string {
+	if total <= 0 {
+		return "100%"
+	}
+	return fmt.Sprintf("%3d%%", done*100/total)
+}
+
+// truncateLine shortens s to width characters, so a bar never wraps
+func truncateLine(s string, width int) string {
+	if width < 4 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-3]) + "..."
+}
+
+// migrationSize estimates how many bytes a migration will copy, from the
+// size of each item's source less what copying it leaves out, returning
+// the size of each too
+func (ps *ProfileSync) migrationSize(ctx context.Context) (int64, []int64) {
+	sizes := make([]int64, len(ps.migrationPlan.Items))
+	var total int64
+	for i, item := range ps.migrationPlan.Items {
+		if ctx.Err() != nil || item.SkipReason != "" {
+			continue
+		}
+		sizes[i] = ps.itemSize(ctx, item)
+		total += sizes[i]
+	}
+	return total, sizes
+}
+
+// pathSize is the size of the regular files at path, and how many there are
+func pathSize(path string) (size int64, files int) {
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+				files++
+			}
+		}
+		return nil
+	})
+	return size, files
+}
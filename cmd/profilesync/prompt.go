@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
+// stdin is shared by every prompt so buffered input is never lost between them
+var stdin = bufio.NewReader(os.Stdin)
+
+// canPrompt reports whether the user can be asked questions during this run
+func (ps *ProfileSync) canPrompt() bool {
+	return !ps.dryRun && !ps.noInput && isatty.IsTerminal(os.Stdin.Fd())
+}
+
+// confirm asks a yes/no question, returning def on an empty answer
+func confirm(question string, def bool) bool {
+	hint := "[y/N]"
+	if def {
+		hint = "[Y/n]"
+	}
+	defer pauseProgress()()
+	fmt.Printf("%s %s ", question, hint)
+
+	answer, _ := stdin.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	default:
+		return def
+	}
+}
+
+// ask asks for a line of text, returning def on an empty answer
+func ask(question, def string) string {
+	defer pauseProgress()()
+	if def != "" {
+		fmt.Printf("%s [%s] ", question, def)
+	} else {
+		fmt.Printf("%s ", question)
+	}
+
+	answer, _ := stdin.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// unmanagedFiles returns the deployed files that no item of the plan
+// covers any more, because their mapping was removed or now points elsewhere.
+// Translated shell configs are left out, as they are only planned when
+// --translate-shell is given
+func unmanagedFiles(state *DeployState, items []MigrationItem) []string {
+	var unmanaged []string
+	for dest, f := range state.Files {
+		if f.Mapping == shellTranslateMapping {
+			continue
+		}
+		managed := false
+		for _, item := range items {
+			if dest == item.DestinationPath || withinRoot(item.DestinationPath, dest) {
+				managed = true
+				break
+			}
+		}
+		if !managed {
+			unmanaged = append(unmanaged, dest)
+		}
+	}
+	sort.Strings(unmanaged)
+	return unmanaged
+}
+
+// removeEmptyParents removes the directories above path that are left
+// empty, stopping at root
+func removeEmptyParents(path, root string) {
+	for dir := filepath.Dir(path); dir != root && withinRoot(root, dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// runPrune handles `profilesync prune`, removing deployed files whose
+// mapping is gone from the config
+func runPrune(args []string) {
+	flags := newFlagSet("prune", "prune [flags]")
+	dryRun := flags.Bool("dry-run", true, "List the files that would be removed without removing them")
+	force := flags.Bool("force", false, "Also remove files changed locally since they were deployed")
+	trash := flags.Bool("trash", false, "Move the files to the OS trash or Recycle Bin instead of deleting them")
+	configFile := flags.String("config", "", "Config file with the current mappings (default "+configPath()+" if it exists)")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config whose mappings are current")
+	flags.Parse(args)
+
+	if *configFile == "" {
+		if _, err := os.Stat(configPath()); err == nil {
+			*configFile = configPath()
+		}
+	}
+	platform := DetectPlatform()
+	ps := NewProfileSync(platform, platform, true, false, false, false, false, 1)
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile, *profile)
+		if err != nil {
+			errorColor.Println("❌ Error loading config:", err)
+			os.Exit(1)
+		}
+		ps.mappings = cfg.Mappings
+	}
+	home := GetHomeDir(platform)
+	if err := ps.CreateMigrationPlan(context.Background(), home, home); err != nil {
+		errorColor.Println("❌ Error creating migration plan:", err)
+		os.Exit(1)
+	}
+
+	state, err := loadDeployState()
+	if err != nil {
+		errorColor.Println("❌ Error reading deploy state:", err)
+		os.Exit(1)
+	}
+	unmanaged := unmanagedFiles(state, ps.migrationPlan.Items)
+	if len(unmanaged) == 0 {
+		successColor.Println("✅ Nothing to prune: every deployed file is still managed")
+		return
+	}
+
+	trail := newAuditTrail("prune", "")
+	removed, kept := 0, 0
+	for _, dest := range unmanaged {
+		f := state.Files[dest]
+		drift := f.drift(dest)
+		switch {
+		case drift == driftDeleted:
+			// Already gone; only the record is left
+			if !*dryRun {
+				delete(state.Files, dest)
+			}
+			continue
+		case drift == driftModified || drift == driftDiverged:
+			if !*force {
+				warnColor.Printf("⚠️  Keeping %s (%s): changed since it was deployed (use --force to remove it)\n", displayPath(dest), f.Mapping)
+				kept++
+				continue
+			}
+		}
+
+		if *dryRun {
+			fmt.Printf("Would remove %s (%s)\n", displayPath(dest), f.Mapping)
+			removed++
+			continue
+		}
+		trail.watch(dest, f.Mapping)
+		note := "moved to the trash"
+		if *trash {
+			if err := trashFile(dest, false); err != nil {
+				errorColor.Printf("❌ Error moving %s to the trash: %v\n", displayPath(dest), err)
+				continue
+			}
+		} else {
+			backup, err := backupFile(dest)
+			if err != nil {
+				errorColor.Printf("❌ Error backing up %s: %v\n", displayPath(dest), err)
+				continue
+			}
+			if err := os.Remove(dest); err != nil {
+				errorColor.Printf("❌ Error removing %s: %v\n", displayPath(dest), err)
+				continue
+			}
+			note = "backed up to " + backup
+		}
+		removeEmptyParents(dest, home)
+		delete(state.Files, dest)
+		successColor.Printf("🗑️  Removed %s (%s, %s)\n", displayPath(dest), f.Mapping, note)
+		removed++
+	}
+
+	if *dryRun {
+		noticeColor.Printf("%d files would be removed. Run with --dry-run=false to remove them.\n", removed)
+		return
+	}
+	trail.flush()
+	if err := state.save(); err != nil {
+		errorColor.Println("❌ Error saving deploy state:", err)
+		os.Exit(1)
+	}
+	noticeColor.Printf("%d files removed, %d kept\n", removed, kept)
+}
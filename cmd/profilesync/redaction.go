@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Kinds of destination redaction rules apply to
+const (
+	// destExport is everything `export` writes, which is meant to be
+	// committed, pushed or baked into an image
+	destExport = "export"
+	// destSend is a direct transfer to another machine with `send`
+	destSend = "send"
+)
+
+// What a redaction rule does with the files it matches
+const (
+	redactionAllow  = "allow"
+	redactionDeny   = "deny"
+	redactionRedact = "redact"
+)
+
+// RedactionRule decides what happens to matching files on their way to
+// some kinds of destination. Exactly one of Deny, Redact and Allow is set,
+// to a pattern matched against each file's mapping path: the mapping for a
+// single file, or the mapping and the file's path within it for a
+// directory, such as ssh/id_rsa
+type RedactionRule struct {
+	Deny   string `yaml:"deny"`
+	Redact string `yaml:"redact"`
+	Allow  string `yaml:"allow"`
+	// Type limits the rule to items of a catalog type, such as Security
+	Type string `yaml:"type"`
+	// To limits the rule to kinds of destination: export or send (default
+	// both)
+	To stringList `yaml:"to"`
+}
+
+// defaultRedaction keeps private keys and cloud credentials out of
+// exports. The config's rules come after them, so they can override these
+var defaultRedaction = []RedactionRule{
+	{Deny: "ssh/id_*", To: stringList{destExport}},
+	{Allow: "ssh/id_*.pub", To: stringList{destExport}},
+	{Redact: "aws/credentials", To: stringList{destExport}},
+}
+
+// action returns what the rule does and its pattern
+func (r RedactionRule) action() (string, string) {
+	switch {
+	case r.Deny != "":
+		return redactionDeny, r.Deny
+	case r.Redact != "":
+		return redactionRedact, r.Redact
+	}
+	return redactionAllow, r.Allow
+}
+
+// validate checks a rule's action, pattern and destinations
+func (r RedactionRule) validate() error {
+	set := 0
+	for _, p := range []string{r.Deny, r.Redact, r.Allow} {
+		if p != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("needs exactly one of deny, redact and allow")
+	}
+	_, pattern := r.action()
+	if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	for _, to := range r.To {
+		if to != destExport && to != destSend {
+			return fmt.Errorf("to must be export or send, not %q", to)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule covers a file with the mapping path
+// name, of an item of type typ, sent to kind. A pattern ending in / covers
+// everything under it
+func (r RedactionRule) matches(name, typ, kind string) bool {
+	if r.Type != "" && !strings.EqualFold(r.Type, typ) {
+		return false
+	}
+	if len(r.To) > 0 {
+		found := false
+		for _, to := range r.To {
+			found = found || to == kind
+		}
+		if !found {
+			return false
+		}
+	}
+	_, pattern := r.action()
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(name+"/", pattern)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// redactionPolicy applies the rules for one kind of destination, keeping
+// track of what it left out and redacted for the summary
+type redactionPolicy struct {
+	kind     string
+	rules    []RedactionRule
+	denied   []string
+	redacted []string
+}
+
+// newRedactionPolicy returns the built-in rules followed by the config's,
+// for one kind of destination
+func newRedactionPolicy(kind string, rules []RedactionRule) *redactionPolicy {
+	return &redactionPolicy{kind: kind, rules: append(append([]RedactionRule{}, defaultRedaction...), rules...)}
+}
+
+// mappingPath is the name rules match for a file at sub within a mapping;
+// sub is "." or empty for a single-file mapping
+func mappingPath(mapping, sub string) string {
+	if sub == "" || sub == "." {
+		return strings.TrimSuffix(mapping, "/")
+	}
+	return strings.TrimSuffix(mapping, "/") + "/" + sub
+}
+
+// decide returns what happens to a file: the action of the last rule that
+// matches it, so later rules override earlier ones, or allow
+func (p *redactionPolicy) decide(name, typ string) string {
+	if p == nil {
+		return redactionAllow
+	}
+	decision := redactionAllow
+	for _, r := range p.rules {
+		if r.matches(name, typ, p.kind) {
+			decision, _ = r.action()
+		}
+	}
+	return decision
+}
+
+// filter applies the policy to a file's content. It returns the content
+// to write, redacted if a rule says so, and false when the file must be
+// left out. Binary files cannot be redacted, so they are left out instead
+func (p *redactionPolicy) filter(name, typ string, data []byte) ([]byte, bool) {
+	switch p.decide(name, typ) {
+	case redactionDeny:
+		p.denied = append(p.denied, name)
+		return nil, false
+	case redactionRedact:
+		if bytes.IndexByte(data, 0) >= 0 {
+			p.denied = append(p.denied, name)
+			return nil, false
+		}
+		p.redacted = append(p.redacted, name)
+		return redactSecrets(data), true
+	}
+	return data, true
+}
+
+// storeFilter applies a redaction policy to a file of the profile store's
+// home with its content, returning the content to export, or false to
+// leave the file out
+type storeFilter func(file string, data []byte) ([]byte, bool)
+
+// forStore returns a filter for the files of the profile store's home,
+// which finds each file's mapping among mappings. Files no mapping covers
+// are matched by their path in the home directory
+func (p *redactionPolicy) forStore(mappings map[string]string, storeHome string) storeFilter {
+	platform := DetectPlatform()
+	return func(file string, data []byte) ([]byte, bool) {
+		if mapping := managedBy(mappings, file, storeHome, platform); mapping != "" {
+			if resolved, err := resolveMappingPath(storeHome, catalogPath(mapping, platform), platform); err == nil {
+				sub, _ := filepath.Rel(resolved, file)
+				return p.filter(mappingPath(mapping, filepath.ToSlash(sub)), catalogType(mapping), data)
+			}
+		}
+		rel, _ := filepath.Rel(storeHome, file)
+		return p.filter(filepath.ToSlash(rel), "", data)
+	}
+}
+
+// summary says what the policy left out and redacted
+func (p *redactionPolicy) summary() {
+	if p == nil {
+		return
+	}
+	if len(p.denied) > 0 {
+		noticeColor.Printf("🙈 Left out by the redaction policy: %s\n", strings.Join(p.denied, ", "))
+	}
+	if len(p.redacted) > 0 {
+		noticeColor.Printf("🙈 Secrets redacted by the redaction policy: %s\n", strings.Join(p.redacted, ", "))
+	}
+}
+
+// redactedValue replaces the secrets redactSecrets finds
+const redactedValue = "REDACTED"
+
+var (
+	// secretKey matches the names of settings that hold secrets
+	secretKey = regexp.MustCompile(`(?i)secret|token|passw(or)?d|credential|private[_-]?key|api[_-]?key|access[_-]?key|key[_-]?data|(^|[^a-z])_?auth($|[^a-z])`)
+	// quotedSetting is a JSON member, or a YAML setting with a quoted name
+	// and value, anywhere in a line
+	quotedSetting = regexp.MustCompile(`("([^"]+)"\s*:\s*)"((?:[^"\\]|\\.)*)"`)
+	// yamlSetting is a YAML setting or list item with a plain value
+	yamlSetting = regexp.MustCompile(`^(\s*(?:-\s+)?([\w.-]+)\s*:\s+)(\S.*?)\s*$`)
+	// iniSetting is an INI, .npmrc or shell setting, name=value
+	iniSetting = regexp.MustCompile(`^(\s*(?:export\s+)?([^\s=#;][^=]*?)\s*=\s*)(\S.*?)\s*$`)
+	// pemPrivateKey matches a PEM private key block
+	pemPrivateKey = regexp.MustCompile(`(?s)(-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----).*?(-----END [A-Z0-9 ]*PRIVATE KEY-----)`)
+)
+
+// redactSecrets replaces the values of settings whose names suggest a
+// secret, and the bodies of private keys, keeping everything else. Secret
+// references are left as they are, since they hold no secret
+func redactSecrets(data []byte) []byte {
+	data = pemPrivateKey.ReplaceAll(data, []byte("$1\n"+redactedValue+"\n$2"))
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		body := strings.TrimRight(line, "\r\n")
+		eol := line[len(body):]
+		if quotedSetting.MatchString(body) {
+			lines[i] = quotedSetting.ReplaceAllStringFunc(body, func(setting string) string {
+				m := quotedSetting.FindStringSubmatch(setting)
+				if !secretKey.MatchString(m[2]) || m[3] == "" || secretRefPattern.MatchString(m[3]) {
+					return setting
+				}
+				return m[1] + `"` + redactedValue + `"`
+			}) + eol
+			continue
+		}
+		for _, setting := range []*regexp.Regexp{yamlSetting, iniSetting} {
+			if m := setting.FindStringSubmatch(body); m != nil {
+				if secretKey.MatchString(m[2]) && !secretRefPattern.MatchString(m[3]) {
+					lines[i] = m[1] + redactedValue + eol
+				}
+				break
+			}
+		}
+	}
+	return []byte(strings.Join(lines, ""))
+}
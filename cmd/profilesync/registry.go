@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Registry value types, as written in registry.json
+const (
+	regString       = "sz"
+	regExpandString = "expand_sz"
+	regMultiString  = "multi_sz"
+	regDWord        = "dword"
+	regQWord        = "qword"
+	regBinary       = "binary"
+)
+
+// errRegistryUnsupported is returned by registry access off Windows
+var errRegistryUnsupported = errors.New("the registry is only available on Windows")
+
+// registryGroup is a registry key whose values are captured together
+type registryGroup struct {
+	Name string
+	Key  string
+	// Values lists the values to capture; empty means all but Exclude
+	Values  []string
+	Exclude []string
+	// Note tells the user how the changes take effect
+	Note string
+}
+
+// registryGroups are the settings captured by default. Everything is under
+// HKCU so applying never needs elevation
+var registryGroups = []registryGroup{
+	{"console", `HKCU\Console`, nil, nil,
+		"New console windows use the new colors and fonts"},
+	{"explorer", `HKCU\Software\Microsoft\Windows\CurrentVersion\Explorer\Advanced`, []string{
+		"Hidden", "HideFileExt", "ShowSuperHidden", "LaunchTo", "NavPaneShowAllFolders", "NavPaneExpandToCurrentFolder",
+		"SeparateProcess", "TaskbarAl", "TaskbarSmallIcons", "TaskbarGlomLevel", "ShowTaskViewButton", "MMTaskbarEnabled",
+	}, nil, "Restart Explorer (or sign out) for the new settings to take effect"},
+	{"appearance", `HKCU\Software\Microsoft\Windows\CurrentVersion\Themes\Personalize`, []string{
+		"AppsUseLightTheme", "SystemUsesLightTheme", "EnableTransparency", "ColorPrevalence",
+	}, nil, "Some appearance settings only take effect after signing out"},
+	{"environment", `HKCU\Environment`, nil, []string{
+		"TEMP", "TMP", "OneDrive", "OneDriveConsumer", "OneDriveCommercial",
+	}, "Programs started from now on see the new environment variables"},
+}
+
+// RegistryValue is one typed registry value. String values hold homeToken
+// in place of the home directory
+type RegistryValue struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// RegistrySettings is the declarative form of captured registry values. A
+// null value deletes it; a key with no values captures all of them next time
+type RegistrySettings struct {
+	CapturedAt time.Time                            `json:"captured_at"`
+	Keys       map[string]map[string]*RegistryValue `json:"keys"`
+}
+
+// registryPath and registryExportPath are where registry values live in a profile store
+func registryPath(dir string) string {
+	return filepath.Join(dir, "windows", "registry.json")
+}
+
+func registryExportPath(dir string) string {
+	return filepath.Join(dir, "windows", "registry.reg")
+}
+
+// normalizeRegistryKey spells a key as HKCU\Sub\Key. Only keys under
+// HKEY_CURRENT_USER are accepted
+func normalizeRegistryKey(key string) (string, error) {
+	key = strings.Trim(strings.ReplaceAll(key, "/", `\`), `\`)
+	root, sub, _ := strings.Cut(key, `\`)
+	switch strings.ToUpper(root) {
+	case "HKCU", "HKEY_CURRENT_USER":
+	default:
+		return "", fmt.Errorf("%s: only keys under HKCU can be migrated", key)
+	}
+	if sub == "" {
+		return "", fmt.Errorf("%s: name a key under HKCU, not HKCU itself", key)
+	}
+	return `HKCU\` + sub, nil
+}
+
+// registrySubKey is a normalized key's path below HKEY_CURRENT_USER
+func registrySubKey(key string) string {
+	return strings.TrimPrefix(key, `HKCU\`)
+}
+
+// loadRegistrySettings reads captured registry values from a profile store
+func loadRegistrySettings(dir string) (*RegistrySettings, error) {
+	data, err := os.ReadFile(registryPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	var s RegistrySettings
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %w", registryPath(dir), err)
+	}
+	if s.Keys == nil {
+		s.Keys = map[string]map[string]*RegistryValue{}
+	}
+	for key, values := range s.Keys {
+		normalized, err := normalizeRegistryKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", registryPath(dir), err)
+		}
+		for name, v := range values {
+			if v == nil {
+				continue
+			}
+			if _, err := v.text(); err != nil {
+				return nil, fmt.Errorf("%s: %s\\%s: %w", registryPath(dir), key, name, err)
+			}
+		}
+		if normalized != key {
+			delete(s.Keys, key)
+			s.Keys[normalized] = values
+		}
+	}
+	return &s, nil
+}
+
+// registryInteger reads a number from JSON or from the registry
+func registryInteger(value interface{}) (uint64, error) {
+	switch n := value.(type) {
+	case uint64:
+		return n, nil
+	case json.Number:
+		return strconv.ParseUint(n.String(), 10, 64)
+	case float64:
+		return uint64(n), nil
+	}
+	return 0, fmt.Errorf("expected a number, not %v", value)
+}
+
+// registryStrings reads a list of strings from JSON or from the registry
+func registryStrings(value interface{}) ([]string, error) {
+	switch list := value.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		strs := make([]string, len(list))
+		for i, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a list of strings, not %v", value)
+			}
+			strs[i] = s
+		}
+		return strs, nil
+	}
+	return nil, fmt.Errorf("expected a list of strings, not %v", value)
+}
+
+// text is the value in a canonical form, for comparing values read from
+// the registry with values read from registry.json
+func (v *RegistryValue) text() (string, error) {
+	switch v.Type {
+	case regString, regExpandString, regBinary:
+		s, ok := v.Value.(string)
+		if !ok {
+			return "", fmt.Errorf("expected a string, not %v", v.Value)
+		}
+		if v.Type == regBinary {
+			if _, err := hex.DecodeString(s); err != nil {
+				return "", fmt.Errorf("binary values are hex strings: %w", err)
+			}
+			return strings.ToLower(s), nil
+		}
+		return s, nil
+	case regDWord, regQWord:
+		n, err := registryInteger(v.Value)
+		if err != nil {
+			return "", err
+		}
+		if v.Type == regDWord && n > 0xffffffff {
+			return "", fmt.Errorf("%d does not fit in a dword", n)
+		}
+		return strconv.FormatUint(n, 10), nil
+	case regMultiString:
+		strs, err := registryStrings(v.Value)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(strs, "\x00"), nil
+	}
+	return "", fmt.Errorf("unknown type %q (must be one of: sz, expand_sz, multi_sz, dword, qword, binary)", v.Type)
+}
+
+// replaceHome swaps the home directory in string values for
+// homeToken, or back
+func (v *RegistryValue) replaceHome(home string, capture bool) *RegistryValue {
+	swap := func(s string) string {
+		if capture {
+			return strings.ReplaceAll(s, home+`\`, homeToken+`\`)
+		}
+		return strings.ReplaceAll(s, homeToken+`\`, home+`\`)
+	}
+	switch v.Type {
+	case regString, regExpandString:
+		if s, ok := v.Value.(string); ok {
+			return &RegistryValue{v.Type, swap(s)}
+		}
+	case regMultiString:
+		if strs, err := registryStrings(v.Value); err == nil {
+			swapped := make([]string, len(strs))
+			for i, s := range strs {
+				swapped[i] = swap(s)
+			}
+			return &RegistryValue{v.Type, swapped}
+		}
+	}
+	return v
+}
+
+// selectRegistryGroups returns the named groups, or all of them
+func selectRegistryGroups(names string) ([]registryGroup, error) {
+	if names == "" {
+		return registryGroups, nil
+	}
+	var groups []registryGroup
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, g := range registryGroups {
+			if g.Name == name {
+				groups = append(groups, g)
+				found = true
+			}
+		}
+		if !found {
+			var all []string
+			for _, g := range registryGroups {
+				all = append(all, g.Name)
+			}
+			return nil, fmt.Errorf("unknown group %q (must be one of: %s)", name, strings.Join(all, ", "))
+		}
+	}
+	return groups, nil
+}
+
+// CaptureRegistry reads the chosen groups and extra keys into
+// dir/windows/registry.json, and writes registry.reg for importing by hand.
+// Values already in registry.json are captured again, and keys listed there
+// with no values are captured whole, so keys added by hand keep being tracked
+func CaptureRegistry(dir string, groups []registryGroup, extraKeys []string) (*RegistrySettings, []string, error) {
+	captured, err := loadRegistrySettings(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		captured = &RegistrySettings{Keys: map[string]map[string]*RegistryValue{}}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	captured.CapturedAt = time.Now().UTC()
+
+	// wanted lists the values to capture per key; a nil list means all
+	wanted := map[string][]string{}
+	whole := map[string]bool{}
+	excluded := map[string]map[string]bool{}
+	for _, g := range groups {
+		if len(g.Values) == 0 {
+			whole[g.Key] = true
+		}
+		wanted[g.Key] = append(wanted[g.Key], g.Values...)
+		for _, name := range g.Exclude {
+			if excluded[g.Key] == nil {
+				excluded[g.Key] = map[string]bool{}
+			}
+			excluded[g.Key][name] = true
+		}
+	}
+	for _, key := range extraKeys {
+		whole[key] = true
+		if _, ok := wanted[key]; !ok {
+			wanted[key] = nil
+		}
+	}
+	for key, values := range captured.Keys {
+		if len(values) == 0 {
+			whole[key] = true
+		}
+		for name := range values {
+			wanted[key] = append(wanted[key], name)
+		}
+	}
+
+	home := GetHomeDir("windows")
+	var notes []string
+	var keys []string
+	for key := range wanted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		current, err := readRegistryKey(key)
+		if errors.Is(err, os.ErrNotExist) {
+			current = map[string]*RegistryValue{}
+		} else if err != nil {
+			notes = append(notes, err.Error())
+			continue
+		}
+		values := captured.Keys[key]
+		if values == nil {
+			values = map[string]*RegistryValue{}
+		}
+		names := wanted[key]
+		if whole[key] {
+			for name := range current {
+				if !excluded[key][name] {
+					names = append(names, name)
+				}
+			}
+		}
+		for _, name := range dedupe(names) {
+			v, ok := current[name]
+			if !ok {
+				// Keep an explicit null; drop values the machine no longer has
+				if old, listed := values[name]; !listed || old != nil {
+					delete(values, name)
+				}
+				continue
+			}
+			values[name] = v.replaceHome(home, true)
+		}
+		if len(values) > 0 || whole[key] {
+			captured.Keys[key] = values
+		} else {
+			delete(captured.Keys, key)
+		}
+	}
+
+	data, err := json.MarshalIndent(captured, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(registryPath(dir)), 0755); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(registryPath(dir), append(data, '\n'), 0644); err != nil {
+		return nil, nil, err
+	}
+	return captured, notes, os.WriteFile(registryExportPath(dir), formatRegFile(captured), 0644)
+}
+
+// formatRegFile renders settings in regedit's .reg format, UTF-16 with a BOM
+func formatRegFile(s *RegistrySettings) []byte {
+	var b strings.Builder
+	b.WriteString("Windows Registry Editor Version 5.00\r\n\r\n")
+	b.WriteString("; Registry values captured by profilesync. Apply with `profilesync apply registry`,\r\n")
+	b.WriteString("; or import by hand after replacing " + homeToken + " with your home directory.\r\n")
+
+	var keys []string
+	for key := range s.Keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\r\n[HKEY_CURRENT_USER\\%s]\r\n", registrySubKey(key))
+		var names []string
+		for name := range s.Keys[key] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			label := `"` + regEscape(name) + `"`
+			if name == "" {
+				label = "@"
+			}
+			fmt.Fprintf(&b, "%s=%s\r\n", label, regData(s.Keys[key][name]))
+		}
+	}
+
+	units := utf16.Encode([]rune(b.String()))
+	out := make([]byte, 2, 2+2*len(units))
+	binary.LittleEndian.PutUint16(out, 0xfeff)
+	for _, u := range units {
+		out = binary.LittleEndian.AppendUint16(out, u)
+	}
+	return out
+}
+
+// regEscape escapes a string for a quoted .reg field
+func regEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// regData renders a value's data in .reg syntax
+func regData(v *RegistryValue) string {
+	if v == nil {
+		return "-"
+	}
+	hexBytes := func(kind string, data []byte) string {
+		parts := make([]string, len(data))
+		for i, c := range data {
+			parts[i] = fmt.Sprintf("%02x", c)
+		}
+		return kind + ":" + strings.Join(parts, ",")
+	}
+	utf16Bytes := func(strs ...string) []byte {
+		var data []byte
+		for _, s := range strs {
+			for _, u := range utf16.Encode([]rune(s)) {
+				data = binary.LittleEndian.AppendUint16(data, u)
+			}
+			data = append(data, 0, 0)
+		}
+		return data
+	}
+
+	switch v.Type {
+	case regString:
+		s, _ := v.Value.(string)
+		return `"` + regEscape(s) + `"`
+	case regExpandString:
+		s, _ := v.Value.(string)
+		return hexBytes("hex(2)", utf16Bytes(s))
+	case regMultiString:
+		strs, _ := registryStrings(v.Value)
+		return hexBytes("hex(7)", append(utf16Bytes(strs...), 0, 0))
+	case regDWord:
+		n, _ := registryInteger(v.Value)
+		return fmt.Sprintf("dword:%08x", n)
+	case regQWord:
+		n, _ := registryInteger(v.Value)
+		return hexBytes("hex(b)", binary.LittleEndian.AppendUint64(nil, n))
+	case regBinary:
+		s, _ := v.Value.(string)
+		data, _ := hex.DecodeString(s)
+		return hexBytes("hex", data)
+	}
+	return "-"
+}
+
+// describeRegistryValue is a value as shown in plans
+func describeRegistryValue(v *RegistryValue) string {
+	if v == nil {
+		return "(deleted)"
+	}
+	text, _ := v.text()
+	if v.Type == regMultiString {
+		text = strings.ReplaceAll(text, "\x00", "; ")
+	}
+	return fmt.Sprintf("%s (%s)", text, v.Type)
+}
+
+// ApplyRegistry writes captured values that differ from this machine's and
+// prints how the groups that changed take effect
+func ApplyRegistry(s *RegistrySettings, dryRun bool) error {
+	home := GetHomeDir("windows")
+	var keys []string
+	for key := range s.Keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changed := map[string]bool{}
+	unchanged, failed := 0, 0
+	for _, key := range keys {
+		current, err := readRegistryKey(key)
+		if errors.Is(err, os.ErrNotExist) {
+			current = map[string]*RegistryValue{}
+		} else if err != nil {
+			return err
+		}
+		var names []string
+		for name := range s.Keys[key] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			wanted := s.Keys[key][name]
+			if wanted != nil {
+				wanted = wanted.replaceHome(home, false)
+			}
+			old, exists := current[name]
+			if wanted == nil && !exists {
+				unchanged++
+				continue
+			}
+			if wanted != nil && exists && old.Type == wanted.Type {
+				a, _ := old.text()
+				if b, _ := wanted.text(); a == b {
+					unchanged++
+					continue
+				}
+			}
+			if dryRun {
+				noticeColor.Printf("🪟 Would set %s\\%s = %s\n", key, name, describeRegistryValue(wanted))
+				changed[key] = true
+				continue
+			}
+			noticeColor.Printf("🪟 Setting %s\\%s = %s\n", key, name, describeRegistryValue(wanted))
+			if err := writeRegistryValue(key, name, wanted); err != nil {
+				errorColor.Printf("❌ %s\\%s: %v\n", key, name, err)
+				failed++
+				continue
+			}
+			changed[key] = true
+		}
+	}
+	fmt.Printf("   %d value(s) already match\n", unchanged)
+
+	if changed[`HKCU\Environment`] && !dryRun {
+		broadcastSettingChange("Environment")
+	}
+	for _, g := range registryGroups {
+		if changed[g.Key] && !dryRun {
+			noticeColor.Println("💡", g.Note)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d registry value(s) could not be written", failed)
+	}
+	return nil
+}
+
+// runCaptureRegistry handles `profilesync capture registry`
+func runCaptureRegistry(args []string) {
+	flags := newFlagSet("capture registry", "capture registry [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to write to")
+	names := flags.String("groups", "", "Comma-separated groups to capture (default: all of console, explorer, appearance, environment)")
+	extra := flags.String("keys", "", `Comma-separated extra keys to capture whole, e.g. HKCU\Software\SimonTatham\PuTTY\Sessions\Default%20Settings`)
+	flags.Parse(args)
+
+	if DetectPlatform() != "windows" {
+		warnColor.Println("⏭️  Registry settings can only be captured on Windows; nothing to do")
+		return
+	}
+	groups, err := selectRegistryGroups(*names)
+	if err != nil {
+		errorColor.Println("❌ Invalid --groups value:", err)
+		os.Exit(2)
+	}
+	if flagWasSet(flags, "keys") && !flagWasSet(flags, "groups") {
+		groups = nil
+	}
+	var keys []string
+	for _, key := range splitList(*extra) {
+		normalized, err := normalizeRegistryKey(key)
+		if err != nil {
+			errorColor.Println("❌ Invalid --keys value:", err)
+			os.Exit(2)
+		}
+		keys = append(keys, normalized)
+	}
+
+	captured, notes, err := CaptureRegistry(*dir, groups, keys)
+	if err != nil {
+		errorColor.Println("❌ Error capturing registry settings:", err)
+		os.Exit(1)
+	}
+	for _, note := range notes {
+		warnColor.Println("⚠️ ", note)
+	}
+	count := 0
+	for _, values := range captured.Keys {
+		count += len(values)
+	}
+	successColor.Printf("✅ %d value(s) from %d key(s) saved to %s\n", count, len(captured.Keys), registryPath(*dir))
+}
+
+// runApplyRegistry handles `profilesync apply registry`
+func runApplyRegistry(args []string) {
+	flags := newFlagSet("apply registry", "apply registry [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to read from")
+	dryRun := flags.Bool("dry-run", true, "Show the values that would change without changing them")
+	flags.Parse(args)
+
+	if DetectPlatform() != "windows" {
+		warnColor.Printf("⏭️  Skipping registry settings on %s\n", DetectPlatform())
+		return
+	}
+	s, err := loadRegistrySettings(*dir)
+	if err != nil {
+		errorColor.Println("❌ Error reading registry settings (run `profilesync capture registry` on Windows first):", err)
+		os.Exit(1)
+	}
+
+	noticeColor.Printf("🪟 Applying registry settings captured %s\n", s.CapturedAt.Format(time.RFC1123))
+	if err := ApplyRegistry(s, *dryRun); err != nil {
+		errorColor.Println("❌ Error applying registry settings:", err)
+		os.Exit(1)
+	}
+	if *dryRun {
+		warnColor.Println("⚠️  This was a DRY RUN. Run with --dry-run=false to apply.")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+// readRegistryKey only has a registry to read on Windows
+func readRegistryKey(key string) (map[string]*RegistryValue, error) {
+	return nil, errRegistryUnsupported
+}
+
+// writeRegistryValue only has a registry to write on Windows
+func writeRegistryValue(key, name string, v *RegistryValue) error {
+	return errRegistryUnsupported
+}
+
+// broadcastSettingChange only has windows to notify on Windows
+func broadcastSettingChange(area string) {}
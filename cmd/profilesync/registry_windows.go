@@ -0,0 +1,147 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+var procSendMessageTimeout = windows.NewLazySystemDLL("user32.dll").NewProc("SendMessageTimeoutW")
+
+// readRegistryKey reads every value of a key under HKCU. A missing key is
+// reported as os.ErrNotExist
+func readRegistryKey(key string) (map[string]*RegistryValue, error) {
+	k, err := registry.OpenKey(registry.CURRENT_USER, registrySubKey(key), registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+
+	names, err := k.ReadValueNames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	values := map[string]*RegistryValue{}
+	for _, name := range names {
+		_, valtype, err := k.GetValue(name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s\\%s: %w", key, name, err)
+		}
+		var v *RegistryValue
+		switch valtype {
+		case registry.SZ, registry.EXPAND_SZ:
+			s, _, err := k.GetStringValue(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s\\%s: %w", key, name, err)
+			}
+			v = &RegistryValue{regString, s}
+			if valtype == registry.EXPAND_SZ {
+				v.Type = regExpandString
+			}
+		case registry.MULTI_SZ:
+			strs, _, err := k.GetStringsValue(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s\\%s: %w", key, name, err)
+			}
+			v = &RegistryValue{regMultiString, strs}
+		case registry.DWORD, registry.QWORD:
+			n, _, err := k.GetIntegerValue(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s\\%s: %w", key, name, err)
+			}
+			v = &RegistryValue{regDWord, n}
+			if valtype == registry.QWORD {
+				v.Type = regQWord
+			}
+		case registry.BINARY:
+			data, _, err := k.GetBinaryValue(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s\\%s: %w", key, name, err)
+			}
+			v = &RegistryValue{regBinary, hex.EncodeToString(data)}
+		default:
+			// Other types are rare under HKCU and have no portable form
+			continue
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+// writeRegistryValue sets a value under HKCU, creating the key if needed. A
+// nil value deletes it
+func writeRegistryValue(key, name string, v *RegistryValue) error {
+	if v == nil {
+		k, err := registry.OpenKey(registry.CURRENT_USER, registrySubKey(key), registry.SET_VALUE)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+		return k.DeleteValue(name)
+	}
+
+	k, _, err := registry.CreateKey(registry.CURRENT_USER, registrySubKey(key), registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	switch v.Type {
+	case regString, regExpandString:
+		s, ok := v.Value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, not %v", v.Value)
+		}
+		if v.Type == regExpandString {
+			return k.SetExpandStringValue(name, s)
+		}
+		return k.SetStringValue(name, s)
+	case regMultiString:
+		strs, err := registryStrings(v.Value)
+		if err != nil {
+			return err
+		}
+		return k.SetStringsValue(name, strs)
+	case regDWord:
+		n, err := registryInteger(v.Value)
+		if err != nil {
+			return err
+		}
+		return k.SetDWordValue(name, uint32(n))
+	case regQWord:
+		n, err := registryInteger(v.Value)
+		if err != nil {
+			return err
+		}
+		return k.SetQWordValue(name, n)
+	case regBinary:
+		s, _ := v.Value.(string)
+		data, err := hex.DecodeString(s)
+		if err != nil {
+			return err
+		}
+		return k.SetBinaryValue(name, data)
+	}
+	return fmt.Errorf("unknown type %q", v.Type)
+}
+
+// broadcastSettingChange tells running programs, Explorer among them, that
+// a settings area changed, so new processes they start see it
+func broadcastSettingChange(area string) {
+	const (
+		hwndBroadcast   = 0xffff
+		wmSettingChange = 0x001a
+		smtoAbortIfHung = 0x0002
+		timeoutMillis   = 5000
+	)
+	p, err := windows.UTF16PtrFromString(area)
+	if err != nil {
+		return
+	}
+	procSendMessageTimeout.Call(hwndBroadcast, wmSettingChange, 0, uintptr(unsafe.Pointer(p)), smtoAbortIfHung, timeoutMillis, 0)
+}
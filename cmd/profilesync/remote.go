@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteFetchTimeout bounds each download or git fetch of a remote config
+const remoteFetchTimeout = 30 * time.Second
+
+// remoteConfig is a config location outside the local filesystem:
+//
+//	https://example.com/baseline.yaml#sha256=<hex>
+//	git+https://github.com/org/dotfiles.git//profilesync/base.yaml?ref=v1.4.0
+//
+// A sha256 fragment pins the content; a git ref pins the revision
+type remoteConfig struct {
+	Raw    string
+	URL    string // https URL, or the repository for git
+	Path   string // file inside the repository, git only
+	Ref    string // tag, branch or commit, git only
+	SHA256 string
+	Git    bool
+}
+
+// isRemoteConfig reports whether a config location is a URL
+func isRemoteConfig(location string) bool {
+	return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "git+")
+}
+
+// parseRemoteConfig splits a remote config location into its parts
+func parseRemoteConfig(location string) (*remoteConfig, error) {
+	r := &remoteConfig{Raw: location}
+
+	rest := location
+	if i := strings.Index(rest, "#"); i >= 0 {
+		fragment := rest[i+1:]
+		rest = rest[:i]
+		digest, ok := strings.CutPrefix(fragment, "sha256=")
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported pin %q (use #sha256=<hex>)", location, fragment)
+		}
+		r.SHA256 = strings.ToLower(digest)
+	}
+
+	if gitURL, ok := strings.CutPrefix(rest, "git+"); ok {
+		r.Git = true
+		if i := strings.Index(gitURL, "?"); i >= 0 {
+			query, err := url.ParseQuery(gitURL[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", location, err)
+			}
+			r.Ref = query.Get("ref")
+			gitURL = gitURL[:i]
+		}
+		scheme := strings.Index(gitURL, "://")
+		sep := -1
+		if scheme >= 0 {
+			if i := strings.Index(gitURL[scheme+3:], "//"); i >= 0 {
+				sep = scheme + 3 + i
+			}
+		}
+		if sep < 0 {
+			return nil, fmt.Errorf("%s: git locations need //path/to/config.yaml after the repository", location)
+		}
+		r.URL, r.Path = gitURL[:sep], strings.TrimPrefix(gitURL[sep+2:], "/")
+		// git would take these as options
+		if strings.HasPrefix(r.URL, "-") || strings.HasPrefix(r.Ref, "-") {
+			return nil, fmt.Errorf("%s: repository and ref must not start with -", location)
+		}
+		return r, nil
+	}
+
+	if !strings.HasPrefix(rest, "https://") {
+		return nil, fmt.Errorf("%s: remote configs must use https", location)
+	}
+	r.URL = rest
+	return r, nil
+}
+
+// pinned reports whether the location always names the same content
+func (r *remoteConfig) pinned() bool {
+	return r.SHA256 != "" || (r.Git && r.Ref != "")
+}
+
+// resolve returns the location of ref relative to this config, keeping the
+// same repository and revision for git
+func (r *remoteConfig) resolve(ref string) (string, error) {
+	if isRemoteConfig(ref) {
+		return ref, nil
+	}
+	if filepath.IsAbs(ref) {
+		return "", fmt.Errorf("%s: a remote config cannot extend the local file %s", r.Raw, ref)
+	}
+
+	if r.Git {
+		location := "git+" + r.URL + "//" + path.Join(path.Dir(r.Path), filepath.ToSlash(ref))
+		if r.Ref != "" {
+			location += "?ref=" + url.QueryEscape(r.Ref)
+		}
+		return location, nil
+	}
+
+	base, err := url.Parse(r.URL)
+	if err != nil {
+		return "", err
+	}
+	next, err := url.Parse(filepath.ToSlash(ref))
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(next).String(), nil
+}
+
+// cachePath is where the last good copy of this location is kept
+func (r *remoteConfig) cachePath() string {
+	sum := sha256.Sum256([]byte(strings.SplitN(r.Raw, "#", 2)[0]))
+	return filepath.Join(stateDir(), "config-cache", hex.EncodeToString(sum[:])[:16]+".yaml")
+}
+
+// verify checks data against the sha256 pin, if there is one
+func (r *remoteConfig) verify(data []byte) error {
+	if r.SHA256 == "" {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != r.SHA256 {
+		return fmt.Errorf("%s: sha256 is %s, pinned %s", r.Raw, got, r.SHA256)
+	}
+	return nil
+}
+
+// readRemoteConfig returns the content of a remote config. Pinned locations
+// are served from the cache once fetched, so runs are reproducible and work
+// offline; unpinned ones are fetched every time and fall back to the cache
+// when the fetch fails
+func readRemoteConfig(location string) ([]byte, error) {
+	r, err := parseRemoteConfig(location)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, cacheErr := readStateFile(r.cachePath())
+	if cacheErr == nil && r.pinned() && r.verify(cached) == nil {
+		return cached, nil
+	}
+
+	var data []byte
+	err = defaultRetry.do(context.Background(), "Fetching "+location, func() error {
+		var err error
+		data, err = r.fetch()
+		return err
+	})
+	if err == nil {
+		err = r.verify(data)
+	}
+	if err != nil {
+		// A pin mismatch is never papered over with an older copy
+		if cacheErr == nil && !r.pinned() {
+			warnColor.Printf("⚠️  Using cached %s: %v\n", location, err)
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	if err := writeStateFile(r.cachePath(), data, 0600); err != nil {
+		warnColor.Printf("⚠️  Could not cache %s: %v\n", location, err)
+	}
+	return data, nil
+}
+
+// fetch downloads the config over https or from git
+func (r *remoteConfig) fetch() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteFetchTimeout)
+	defer cancel()
+
+	if r.Git {
+		metrics.request("git")
+		return r.fetchGit(ctx)
+	}
+	metrics.request("https")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Private servers take a bearer token stored with `auth set token:HOST`
+	token, hasToken, err := lookupSecret(authTokenPrefix + req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	if hasToken && req.URL.Scheme == "https" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := &httpStatusError{url: r.URL, code: resp.StatusCode, status: resp.Status}
+		if (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && !hasToken {
+			return nil, fmt.Errorf("%w (store a token with `profilesync auth set %s%s`)", err, authTokenPrefix, req.URL.Hostname())
+		}
+		return nil, err
+	}
+	// Configs are small; refuse anything that clearly is not one
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// fetchGit reads one file at a revision with a shallow fetch, without a checkout
+func (r *remoteConfig) fetchGit(ctx context.Context) ([]byte, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.New("git is needed for git+ config locations")
+	}
+
+	tmp, err := os.MkdirTemp("", "profilesync-config-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	ref := r.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"fetch", "-q", "--depth", "1", "--", r.URL, ref},
+	} {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", tmp}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	out, err := exec.CommandContext(ctx, "git", "-C", tmp, "show", "FETCH_HEAD:"+r.Path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s not found at %s in %s", r.Path, ref, r.URL)
+	}
+	return out, nil
+}
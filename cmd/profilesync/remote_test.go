@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestParseRemoteConfig(t *testing.T) {
+	tests := []struct {
+		location       string
+		url, path, ref string
+		ok             bool
+	}{
+		{"https://example.com/base.yaml", "https://example.com/base.yaml", "", "", true},
+		{"http://example.com/base.yaml", "", "", "", false},
+		{"git+https://github.com/org/dotfiles.git//profilesync/base.yaml?ref=v1.4.0", "https://github.com/org/dotfiles.git", "profilesync/base.yaml", "v1.4.0", true},
+		{"git+https://github.com/org/dotfiles.git", "", "", "", false},
+		// git would read these as options
+		{"git+https://github.com/org/dotfiles.git//base.yaml?ref=--upload-pack=touch%20x", "", "", "", false},
+		{"git+--upload-pack=touch x://h//base.yaml", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			r, err := parseRemoteConfig(tt.location)
+			if (err == nil) != tt.ok {
+				t.Fatalf("parseRemoteConfig error = %v, want ok %v", err, tt.ok)
+			}
+			if err == nil && (r.URL != tt.url || r.Path != tt.path || r.Ref != tt.ref) {
+				t.Errorf("parseRemoteConfig = %q, %q, %q, want %q, %q, %q", r.URL, r.Path, r.Ref, tt.url, tt.path, tt.ref)
+			}
+		})
+	}
+}
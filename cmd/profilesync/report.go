@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Outcomes of an item in a run, as reports show them
+const (
+	itemMigrated  = "migrated"
+	itemMerged    = "merged"
+	itemConflicts = "conflicts"
+	itemSkipped   = "skipped"
+	itemUpToDate  = "up to date"
+	itemFailed    = "failed"
+	itemNotRun    = "not run"
+)
+
+// reportMaxDiffLines caps the lines of each diff a report shows
+const reportMaxDiffLines = 200
+
+// finishItem records how an item went. A dry run records what would have
+// happened
+func (ps *ProfileSync) finishItem(i int, started time.Time, status, detail string) {
+	item := &ps.migrationPlan.Items[i]
+	if ps.dryRun && status != itemSkipped && status != itemUpToDate && status != itemFailed {
+		status = "would be " + status
+		if status == "would be conflicts" {
+			status = "would conflict"
+		}
+	}
+	item.Status, item.Detail, item.Duration = status, detail, time.Since(started)
+	ps.progress.endItem()
+
+	level := slog.LevelInfo
+	switch {
+	case status == itemFailed:
+		level = slog.LevelError
+	case strings.HasSuffix(status, itemConflicts) || status == "would conflict":
+		level = slog.LevelWarn
+	case detail == "not found":
+		level = slog.LevelDebug
+	}
+	attrs := []any{"id", item.ID, "description", item.Description,
+		"status", status, "detail", detail, "source", item.SourcePath, "destination", item.DestinationPath,
+		"sensitivity", item.Sensitivity, "duration_ms", item.Duration.Milliseconds()}
+	if item.ErrorClass != "" {
+		attrs = append(attrs, "error_class", item.ErrorClass)
+	}
+	logger().Log(context.Background(), level, "item", attrs...)
+}
+
+// recordDiff keeps the lines an item is about to change in an existing
+// text file, for the report. Secrets are never shown
+func (ps *ProfileSync) recordDiff(i int) {
+	item := &ps.migrationPlan.Items[i]
+	if item.Sensitivity == sensitivitySecret {
+		return
+	}
+	info, err := os.Stat(item.DestinationPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	old, err := os.ReadFile(item.DestinationPath)
+	if err != nil {
+		return
+	}
+	new, err := os.ReadFile(item.SourcePath)
+	if err != nil {
+		return
+	}
+	if item.Diff, err = lineDiff(old, new); err == nil && len(item.Diff) > reportMaxDiffLines {
+		more := len(item.Diff) - reportMaxDiffLines
+		item.Diff = append(item.Diff[:reportMaxDiffLines], fmt.Sprintf("... %d more lines", more))
+	}
+}
+
+// reportItem is an item as a report shows it, with the size and hash of
+// what it put in place, or would have
+type reportItem struct {
+	MigrationItem
+	Path  string
+	Size  int64
+	Files int
+	Hash  string
+}
+
+// reportData is everything a report shows
+type reportData struct {
+	Title      string
+	Machine    string
+	Source     string
+	Dest       string
+	Mode       string
+	Started    time.Time
+	Finished   time.Time
+	Elapsed    time.Duration
+	Counts     []reportCount
+	Items      []reportItem
+	Secrets    []string
+	Stopped    string
+	Generated  string
+	TotalBytes int64
+	// NotFound counts the items the source does not have, left out of Items
+	NotFound int
+	// Failures counts the failed items by the class of their error
+	Failures string
+}
+
+// reportCount is how many items ended with one status
+type reportCount struct {
+	Status string
+	Count  int
+}
+
+// measureItem finds the size and hash of the file or directory at path
+func measureItem(path string) (size int64, files int, hash string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, ""
+	}
+	if !info.IsDir() {
+		hash, _ = hashFile(path)
+		return info.Size(), 1, hash
+	}
+	size, files = pathSize(path)
+	return size, files, ""
+}
+
+// reportData collects what the report of the run shows
+func (ps *ProfileSync) reportData(started time.Time, sourceBase, destBase string) reportData {
+	return ps.buildReportData(started, time.Now(), sourceBase, destBase, measureItem)
+}
+
+// buildReportData collects what a report of the plan shows. measure finds
+// the size and hash of what an item put in place, or would have
+func (ps *ProfileSync) buildReportData(started, finished time.Time, sourceBase, destBase string, measure func(path string) (int64, int, string)) reportData {
+	data := reportData{
+		Title:     "profilesync migration report",
+		Machine:   machineName(),
+		Source:    fmt.Sprintf("%s (%s)", ps.sourcePlatform, sourceBase),
+		Dest:      fmt.Sprintf("%s (%s)", ps.destPlatform, destBase),
+		Mode:      map[bool]string{true: "Dry run", false: "Live"}[ps.dryRun],
+		Started:   started,
+		Finished:  finished,
+		Generated: time.Now().Format(time.RFC1123),
+	}
+	if ps.user != "" {
+		data.Title += " for " + ps.user
+	}
+	data.Elapsed = data.Finished.Sub(started).Round(time.Millisecond)
+	if ps.migrationPlan.Interrupted {
+		data.Stopped = fmt.Sprintf("Stopped at %s (item %d of %d)", ps.migrationPlan.StoppedAt, ps.migrationPlan.StoppedIndex+1, len(ps.migrationPlan.Items))
+	}
+
+	counts := map[string]int{}
+	for _, item := range ps.migrationPlan.Items {
+		if item.Status == "" {
+			item.Status = itemNotRun
+		}
+		counts[item.Status]++
+		if item.Detail == "not found" {
+			data.NotFound++
+			continue
+		}
+		r := reportItem{MigrationItem: item, Path: item.SourcePath}
+		if item.Migrated {
+			r.Path = item.DestinationPath
+		}
+		// A source that timed out would only hang the report too
+		if item.Status != itemNotRun && item.ErrorClass != errorTimeout {
+			r.Size, r.Files, r.Hash = measure(r.Path)
+		}
+		data.TotalBytes += r.Size
+		data.Items = append(data.Items, r)
+		if item.Sensitivity == sensitivitySecret && (item.Migrated || (ps.dryRun && item.Status == "would be "+itemMigrated)) {
+			data.Secrets = append(data.Secrets, item.Description)
+		}
+	}
+	for status, n := range counts {
+		data.Counts = append(data.Counts, reportCount{status, n})
+	}
+	sort.Slice(data.Counts, func(i, j int) bool { return data.Counts[i].Status < data.Counts[j].Status })
+	data.Failures = failureClasses(ps.migrationPlan.Items)
+	return data
+}
+
+// checkReportPath rejects a --report file of a format there is no report for
+func checkReportPath(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm", ".md", ".markdown":
+		return nil
+	}
+	return fmt.Errorf("%s: the report format is chosen by the extension, one of .html or .md", path)
+}
+
+// writeReport writes the report of the run when --report asked for one
+func (ps *ProfileSync) writeReport(path string, started time.Time, sourceBase, destBase string) {
+	if path == "" {
+		return
+	}
+	if err := ps.writeReportFile(path, started, sourceBase, destBase); err != nil {
+		warnColor.Println("⚠️  Could not write the report:", err)
+		return
+	}
+	noticeColor.Printf("📄 Report written to %s\n", path)
+}
+
+// writeReportFile writes the report of the run as HTML or Markdown,
+// depending on the extension of path
+func (ps *ProfileSync) writeReportFile(path string, started time.Time, sourceBase, destBase string) error {
+	return writeReportData(path, ps.reportData(started, sourceBase, destBase))
+}
+
+// writeReportData writes a report as HTML or Markdown, depending on the
+// extension of path
+func writeReportData(path string, data reportData) error {
+	var b strings.Builder
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		if err := reportHTML.Execute(&b, data); err != nil {
+			return err
+		}
+	default:
+		writeReportMarkdown(&b, data)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// formatItemSize renders the size column of an item
+func formatItemSize(r reportItem) string {
+	switch {
+	case r.Files == 0:
+		return ""
+	case r.Hash == "":
+		return fmt.Sprintf("%s in %d files", formatSize(r.Size), r.Files)
+	}
+	return formatSize(r.Size)
+}
+
+// formatDuration renders how long an item took
+func formatDuration(d time.Duration) string {
+	if d < time.Millisecond {
+		return ""
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// markdownCell escapes text for a Markdown table cell
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+// writeReportMarkdown renders the report as Markdown
+func writeReportMarkdown(b *strings.Builder, data reportData) {
+	fmt.Fprintf(b, "# %s\n\n", data.Title)
+	fmt.Fprintf(b, "| | |\n|---|---|\n")
+	fmt.Fprintf(b, "| Machine | %s |\n", markdownCell(data.Machine))
+	fmt.Fprintf(b, "| Source | %s |\n", markdownCell(data.Source))
+	fmt.Fprintf(b, "| Destination | %s |\n", markdownCell(data.Dest))
+	fmt.Fprintf(b, "| Mode | %s |\n", data.Mode)
+	fmt.Fprintf(b, "| Started | %s |\n", data.Started.Format(time.RFC1123))
+	fmt.Fprintf(b, "| Duration | %s |\n", data.Elapsed)
+	var counts []string
+	for _, c := range data.Counts {
+		counts = append(counts, fmt.Sprintf("%d %s", c.Count, c.Status))
+	}
+	fmt.Fprintf(b, "| Result | %s |\n", strings.Join(counts, ", "))
+	if data.Failures != "" {
+		fmt.Fprintf(b, "| Failures | %s |\n", data.Failures)
+	}
+	fmt.Fprintf(b, "| Size | %s |\n", formatSize(data.TotalBytes))
+	if data.Stopped != "" {
+		fmt.Fprintf(b, "\n> ⛔ %s\n", markdownCell(data.Stopped))
+	}
+	if len(data.Secrets) > 0 {
+		fmt.Fprintf(b, "\n> 🔐 Secrets: %s\n", markdownCell(strings.Join(data.Secrets, ", ")))
+	}
+
+	fmt.Fprintf(b, "\n## Items\n\n")
+	if data.NotFound > 0 {
+		fmt.Fprintf(b, "%d items not found on the source are not listed.\n\n", data.NotFound)
+	}
+	fmt.Fprintf(b, "| Status | Item | Type | Path | Size | SHA-256 | Time |\n|---|---|---|---|---|---|---|\n")
+	for _, r := range data.Items {
+		status := r.Status
+		if r.ErrorClass != "" {
+			status += " [" + r.ErrorClass + "]"
+		}
+		if r.Detail != "" {
+			status += " (" + r.Detail + ")"
+		}
+		hash := ""
+		if r.Hash != "" {
+			hash = "`" + r.Hash[:16] + "…`"
+		}
+		fmt.Fprintf(b, "| %s | %s | %s | `%s` | %s | %s | %s |\n", markdownCell(status), markdownCell(r.Description), markdownCell(r.Type),
+			markdownCell(r.Path), formatItemSize(r), hash, formatDuration(r.Duration))
+	}
+
+	var changed []reportItem
+	for _, r := range data.Items {
+		if len(r.Diff) > 0 {
+			changed = append(changed, r)
+		}
+	}
+	if len(changed) > 0 {
+		fmt.Fprintf(b, "\n## Changes\n")
+		for _, r := range changed {
+			fmt.Fprintf(b, "\n### %s\n\n`%s`\n\n```diff\n%s\n```\n", r.Description, r.DestinationPath, strings.Join(r.Diff, "\n"))
+		}
+	}
+	fmt.Fprintf(b, "\n_Generated by profilesync on %s._\n", data.Generated)
+}
+
+// reportHTML renders the report as a self-contained HTML page
+var reportHTML = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size":     formatItemSize,
+	"bytes":    formatSize,
+	"duration": formatDuration,
+	"short": func(hash string) string {
+		if len(hash) > 16 {
+			return hash[:16] + "…"
+		}
+		return hash
+	},
+	"lineClass": func(line string) string {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			return "hunk"
+		case strings.HasPrefix(line, "-"):
+			return "del"
+		case strings.HasPrefix(line, "+"):
+			return "add"
+		}
+		return ""
+	},
+	"statusClass": func(status string) string {
+		return strings.ReplaceAll(strings.TrimPrefix(status, "would be "), " ", "-")
+	},
+	"rfc1123": func(t time.Time) string { return t.Format(time.RFC1123) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+code, pre { font-family: ui-monospace, Menlo, Consolas, monospace; font-size: 90%; }
+pre { background: #f8f8f8; padding: 8px; overflow-x: auto; }
+.migrated, .merged { color: #1a7f37; }
+.skipped, .up-to-date, .not-run { color: #9a6700; }
+.failed, .conflicts, .would-conflict { color: #cf222e; font-weight: bold; }
+.add { color: #1a7f37; } .del { color: #cf222e; } .hunk { color: #0969da; }
+.note { padding: 8px; border-left: 4px solid #9a6700; background: #fff8c5; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th>Machine</th><td>{{.Machine}}</td></tr>
+<tr><th>Source</th><td>{{.Source}}</td></tr>
+<tr><th>Destination</th><td>{{.Dest}}</td></tr>
+<tr><th>Mode</th><td>{{.Mode}}</td></tr>
+<tr><th>Started</th><td>{{rfc1123 .Started}}</td></tr>
+<tr><th>Duration</th><td>{{.Elapsed}}</td></tr>
+<tr><th>Result</th><td>{{range $i, $c := .Counts}}{{if $i}}, {{end}}<span class="{{statusClass $c.Status}}">{{$c.Count}} {{$c.Status}}</span>{{end}}</td></tr>
+{{if .Failures}}<tr><th>Failures</th><td class="failed">{{.Failures}}</td></tr>{{end}}
+<tr><th>Size</th><td>{{bytes .TotalBytes}}</td></tr>
+</table>
+{{if .Stopped}}<p class="note">⛔ {{.Stopped}}</p>{{end}}
+{{if .Secrets}}<p class="note">🔐 Secrets: {{range $i, $s := .Secrets}}{{if $i}}, {{end}}{{$s}}{{end}}</p>{{end}}
+<h2>Items</h2>
+{{if .NotFound}}<p>{{.NotFound}} items not found on the source are not listed.</p>{{end}}
+<table>
+<tr><th>Status</th><th>Item</th><th>Type</th><th>Path</th><th>Size</th><th>SHA-256</th><th>Time</th></tr>
+{{range .Items}}<tr>
+<td class="{{statusClass .Status}}">{{.Status}}{{if .ErrorClass}} [{{.ErrorClass}}]{{end}}{{if .Detail}} ({{.Detail}}){{end}}</td>
+<td>{{.Description}}</td>
+<td>{{.Type}}</td>
+<td><code>{{.Path}}</code></td>
+<td>{{size .}}</td>
+<td>{{if .Hash}}<code title="{{.Hash}}">{{short .Hash}}</code>{{end}}</td>
+<td>{{duration .Duration}}</td>
+</tr>
+{{end}}</table>
+{{$changed := false}}{{range .Items}}{{if .Diff}}{{$changed = true}}{{end}}{{end}}
+{{if $changed}}<h2>Changes</h2>
+{{range .Items}}{{if .Diff}}<h3>{{.Description}}</h3>
+<p><code>{{.DestinationPath}}</code></p>
+<pre>{{range .Diff}}<span class="{{lineClass .}}">{{.}}</span>
+{{end}}</pre>
+{{end}}{{end}}{{end}}
+<p><em>Generated by profilesync on {{.Generated}}.</em></p>
+</body>
+</html>
+`))
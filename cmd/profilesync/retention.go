@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retentionPolicy caps what one state subdirectory may hold. Zero disables a cap
+type retentionPolicy struct {
+	MaxAge   time.Duration
+	MaxCount int
+	MaxBytes int64
+}
+
+// defaultRetention is applied after every live run so unattended use does
+// not fill the disk. The newest entry in each directory is always kept
+var defaultRetention = map[string]retentionPolicy{
+	"logs":       {MaxAge: 30 * 24 * time.Hour, MaxCount: 100, MaxBytes: 50 << 20},
+	"reports":    {MaxAge: 90 * 24 * time.Hour, MaxCount: 200, MaxBytes: 50 << 20},
+	"backups":    {MaxAge: 30 * 24 * time.Hour, MaxCount: 20, MaxBytes: 2 << 30},
+	"quarantine": {MaxAge: 30 * 24 * time.Hour, MaxCount: 20, MaxBytes: 1 << 30},
+}
+
+// logsDir holds one log file per run
+func logsDir() string {
+	return filepath.Join(stateDir(), "logs")
+}
+
+// reportsDir holds one report per live run
+func reportsDir() string {
+	return filepath.Join(stateDir(), "reports")
+}
+
+// retentionEntry is one file or directory considered for removal
+type retentionEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// retentionOverrides reads caps that replace the defaults for every
+// directory from PROFILESYNC_RETAIN_DAYS, PROFILESYNC_RETAIN_COUNT and
+// PROFILESYNC_RETAIN_SIZE
+func retentionOverrides() (retentionPolicy, error) {
+	var p retentionPolicy
+	if v := os.Getenv("PROFILESYNC_RETAIN_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("PROFILESYNC_RETAIN_DAYS: %w", err)
+		}
+		p.MaxAge = time.Duration(days) * 24 * time.Hour
+	}
+	if v := os.Getenv("PROFILESYNC_RETAIN_COUNT"); v != "" {
+		count, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("PROFILESYNC_RETAIN_COUNT: %w", err)
+		}
+		p.MaxCount = count
+	}
+	if v := os.Getenv("PROFILESYNC_RETAIN_SIZE"); v != "" {
+		size, err := parseSize(v)
+		if err != nil {
+			return p, fmt.Errorf("PROFILESYNC_RETAIN_SIZE: %w", err)
+		}
+		p.MaxBytes = size
+	}
+	return p, nil
+}
+
+// merge returns p with every cap set in override replaced
+func (p retentionPolicy) merge(override retentionPolicy) retentionPolicy {
+	if override.MaxAge != 0 {
+		p.MaxAge = override.MaxAge
+	}
+	if override.MaxCount != 0 {
+		p.MaxCount = override.MaxCount
+	}
+	if override.MaxBytes != 0 {
+		p.MaxBytes = override.MaxBytes
+	}
+	return p
+}
+
+// parseSize parses sizes such as "500MB", "2G" or "1048576"
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+
+	value := strings.ToUpper(strings.TrimSpace(s))
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(factor)), nil
+}
+
+// formatSize renders a byte count for humans
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// retentionEntries lists the entries of dir with their total sizes, newest first
+func retentionEntries(dir string) ([]retentionEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []retentionEntry
+	for _, d := range dirEntries {
+		if strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil, err
+		}
+		entry := retentionEntry{path: filepath.Join(dir, d.Name()), size: info.Size(), modTime: info.ModTime()}
+		if d.IsDir() {
+			entry.size = 0
+			filepath.WalkDir(entry.path, func(_ string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					if info, err := d.Info(); err == nil {
+						entry.size += info.Size()
+					}
+				}
+				return nil
+			})
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.After(entries[j].modTime) })
+	return entries, nil
+}
+
+// enforceRetention removes the oldest entries of dir until it is within
+// policy, always keeping the newest one
+func enforceRetention(dir string, policy retentionPolicy, now time.Time) (removed int, freed int64, err error) {
+	entries, err := retentionEntries(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total int64
+	for i, e := range entries {
+		total += e.size
+		if i == 0 {
+			continue
+		}
+		expired := policy.MaxAge > 0 && now.Sub(e.modTime) > policy.MaxAge
+		tooMany := policy.MaxCount > 0 && i >= policy.MaxCount
+		tooBig := policy.MaxBytes > 0 && total > policy.MaxBytes
+		if !expired && !tooMany && !tooBig {
+			continue
+		}
+		if err := os.RemoveAll(e.path); err != nil {
+			return removed, freed, err
+		}
+		total -= e.size
+		removed++
+		freed += e.size
+	}
+	return removed, freed, nil
+}
+
+// rotateStateDirs applies retention to logs, reports, backups and quarantine
+func rotateStateDirs(override retentionPolicy, verbose bool) error {
+	names := make([]string, 0, len(defaultRetention))
+	for name := range defaultRetention {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	for _, name := range names {
+		policy := defaultRetention[name].merge(override)
+		removed, freed, err := enforceRetention(filepath.Join(stateDir(), name), policy, now)
+		if err != nil {
+			return fmt.Errorf("rotating %s: %w", name, err)
+		}
+		if removed > 0 && verbose {
+			noticeColor.Printf("🧹 Removed %d old %s (%s)\n", removed, name, formatSize(freed))
+		}
+	}
+	return nil
+}
+
+// runReport is what saveReport writes for each run
+type runReport struct {
+	Run        string          `json:"run,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	DryRun     bool            `json:"dry_run,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	User       string          `json:"user,omitempty"`
+	SourceBase string          `json:"source_base"`
+	DestBase   string          `json:"dest_base"`
+	Plan       *MigrationPlan  `json:"plan"`
+	Resources  ResourceSummary `json:"resources"`
+}
+
+// saveReport records the outcome of a run in the reports directory
+func (ps *ProfileSync) saveReport(started time.Time, sourceBase, destBase string, runErr error) error {
+	report := runReport{
+		Run:        runID(),
+		StartedAt:  started.UTC(),
+		User:       ps.user,
+		FinishedAt: time.Now().UTC(),
+		DryRun:     ps.dryRun,
+		SourceBase: sourceBase,
+		DestBase:   destBase,
+		Plan:       ps.migrationPlan,
+		Resources:  metrics.summary(started),
+	}
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := started.UTC().Format("20060102T150405Z")
+	if ps.user != "" {
+		name += "-" + ps.user
+	}
+	if ps.dryRun {
+		name += "-dry-run"
+	}
+	name += ".json"
+	return writeStateFile(filepath.Join(reportsDir(), name), data, 0600)
+}
+
+// recordRun saves the report of a run, ending with runErr if it failed,
+// for `history`. After a live run it also logs the files the run changed
+// and rotates old state. Failures are reported but never fail the run
+// itself
+func (ps *ProfileSync) recordRun(started time.Time, sourceBase, destBase string, runErr error) {
+	ps.audit.flush()
+	ps.audit = nil
+	if err := ps.saveReport(started, sourceBase, destBase, runErr); err != nil {
+		warnColor.Println("⚠️  Could not save report:", err)
+	}
+	if ps.dryRun {
+		return
+	}
+	override, err := retentionOverrides()
+	if err != nil {
+		warnColor.Println("⚠️  Ignoring retention settings:", err)
+	}
+	if err := rotateStateDirs(override, ps.verbose); err != nil {
+		warnColor.Println("⚠️  Could not rotate old state:", err)
+	}
+}
+
+// printStateUsage lists how much each retained directory holds
+func printStateUsage() {
+	names := make([]string, 0, len(defaultRetention))
+	for name := range defaultRetention {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entries, err := retentionEntries(filepath.Join(stateDir(), name))
+		if err != nil {
+			warnColor.Printf("%-19s%v\n", name+":", err)
+			continue
+		}
+		var total int64
+		for _, e := range entries {
+			total += e.size
+		}
+		noticeColor.Printf("%-19s%d entries, %s\n", strings.ToUpper(name[:1])+name[1:]+":", len(entries), formatSize(total))
+	}
+
+	st, err := openObjectStore().stats()
+	if err != nil {
+		warnColor.Printf("%-19s%v\n", "Objects:", err)
+		return
+	}
+	noticeColor.Printf("%-19s%d files in %d chunks, %s stored for %s\n", "Objects:", st.Files, st.Chunks, formatSize(st.Stored), formatSize(st.Logical))
+}
+
+// runStateRotate handles `profilesync state rotate`, applying retention now
+// with optional caps that replace the defaults
+func runStateRotate(args []string) {
+	flags := newFlagSet("state rotate", "state rotate [flags]")
+	days := flags.Int("days", 0, "Remove entries older than this many days")
+	count := flags.Int("count", 0, "Keep at most this many entries per directory")
+	size := flags.String("size", "", "Keep at most this much data per directory (e.g. 500MB)")
+	flags.Parse(args)
+
+	override, err := retentionOverrides()
+	if err != nil {
+		errorColor.Println("❌ Error reading retention settings:", err)
+		os.Exit(2)
+	}
+	if *days > 0 {
+		override.MaxAge = time.Duration(*days) * 24 * time.Hour
+	}
+	if *count > 0 {
+		override.MaxCount = *count
+	}
+	if *size != "" {
+		if override.MaxBytes, err = parseSize(*size); err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(2)
+		}
+	}
+
+	if err := rotateStateDirs(override, true); err != nil {
+		errorColor.Println("❌ Error rotating state:", err)
+		os.Exit(1)
+	}
+	printStateUsage()
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sandboxImage is the image `test` applies the profile in unless --image
+// says otherwise
+const sandboxImage = "debian:stable-slim"
+
+// sandboxHome is the home directory of the user the container runs as
+const sandboxHome = "/root"
+
+// sandboxMarker starts the lines the check script reports results on
+const sandboxMarker = "profilesync-check:"
+
+// sandboxCheck is a smoke check of a deployed config. script is an sh
+// command that passes when it exits 0 without writing to stderr
+type sandboxCheck struct {
+	name    string
+	command string
+	pkg     string
+	script  func(rel string) string
+}
+
+// sandboxChecks are the smoke checks, by the name of the config they check
+var sandboxChecks = map[string]sandboxCheck{
+	".bashrc": {"bash starts", "bash", "bash", func(rel string) string {
+		// Without a terminal, interactive bash always complains about job control
+		return `bash --rcfile "$HOME"/` + shellQuote(rel) + ` -i -c true 2>&1 >/dev/null | grep -v -e 'no job control' -e 'terminal process group' >&2 || true`
+	}},
+	".zshrc": {"zsh starts", "zsh", "zsh", func(rel string) string {
+		return `ZDOTDIR="$HOME"/` + shellQuote(path.Dir(rel)) + ` zsh -i -c true`
+	}},
+	"config.fish": {"fish starts", "fish", "fish", func(rel string) string {
+		return `XDG_CONFIG_HOME="$HOME"/` + shellQuote(path.Dir(path.Dir(rel))) + ` fish -c true`
+	}},
+	".gitconfig": {"git config is valid", "git", "git", func(rel string) string {
+		return `git config --file "$HOME"/` + shellQuote(rel) + ` --list`
+	}},
+	".vimrc": {"vim loads", "vim", "vim", func(rel string) string {
+		// Silent Ex mode keeps its errors in :messages
+		return `vim -N -u "$HOME"/` + shellQuote(rel) + ` -i NONE -es -c 'redir! >> /dev/stderr | silent messages | redir END' -c 'qa!' </dev/null`
+	}},
+	"nvim": {"neovim loads", "nvim", "neovim", func(rel string) string {
+		return `XDG_CONFIG_HOME="$HOME"/` + shellQuote(path.Dir(rel)) + ` nvim --headless -i NONE +qa`
+	}},
+}
+
+// shellQuote quotes s for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runTest handles `profilesync test`, applying the plan in a throwaway
+// container and running smoke checks on what it deployed
+func runTest(args []string) {
+	flags := newFlagSet("test", "test [flags]")
+	runtimeName := flags.String("runtime", "", "Container runtime to use, docker or podman (default whichever is on PATH)")
+	image := flags.String("image", sandboxImage, "Image to apply the profile in; missing tools are installed with apt-get, apk or dnf")
+	sourcePlatform := flags.String("source", DetectPlatform(), "Source platform (linux, macos, windows)")
+	destPlatform := flags.String("dest", "linux", "Destination platform; containers can only run linux")
+	sourceDir := flags.String("source-dir", "", "Apply from this directory instead of the source platform's home, e.g. the profile store's home")
+	configFile := flags.String("config", "", "Config file with mappings and policies (default "+configPath()+" if it exists)")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config to apply")
+	symlinks := flags.String("symlinks", symlinkFollow, "Symlinks in the source: follow and copy their targets, preserve them as links, or skip them")
+	verbose := flags.Bool("verbose", false, "Show the container's output, such as package installs")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	switch *sourcePlatform {
+	case "linux", "macos", "windows":
+	default:
+		errorColor.Println("❌ Invalid source platform:", *sourcePlatform)
+		errorColor.Println("Must be one of: linux, macos, windows")
+		os.Exit(1)
+	}
+	if *destPlatform != "linux" {
+		errorColor.Printf("❌ Containers can only test a linux destination, not %s\n", *destPlatform)
+		os.Exit(1)
+	}
+	if err := validSymlinkPolicy(*symlinks); err != nil {
+		errorColor.Println("❌ Invalid --symlinks value:", *symlinks)
+		errorColor.Println("Must be one of: follow, preserve, skip")
+		os.Exit(1)
+	}
+	runtime, err := containerRuntime(*runtimeName)
+	if err != nil {
+		errorColor.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+	ps, sourceHome := planExport(ctx, *sourcePlatform, *destPlatform, *sourceDir, *configFile, *profile, *symlinks)
+
+	dir, err := os.MkdirTemp("", "profilesync-test-")
+	if err != nil {
+		errorColor.Println("❌ Error creating a temporary directory:", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	var checks []string
+	var needed []sandboxCheck
+	files := 0
+	out := exportTarget{dir: dir, home: sandboxHome, keepModes: true}
+	err = ps.exportedItems(ctx, sourceHome, out, nil, func(item MigrationItem, rel string, collected exportedItem) error {
+		files += len(collected.files) + len(collected.rewritten)
+		if check, ok := sandboxChecks[path.Base(rel)]; ok {
+			checks = append(checks, sandboxCheckScript(check.name+" with ~/"+rel, check.script(rel)))
+			needed = append(needed, check)
+		}
+		return placeExported(dir, collected)
+	})
+	if err != nil {
+		errorColor.Println("❌ Error collecting the profile:", err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0755); err != nil {
+		errorColor.Println("❌ Error collecting the profile:", err)
+		os.Exit(1)
+	}
+
+	infoColor.Printf("🐳 Applying %d files in %s with %s\n", files, *image, runtime)
+	passed, failed, err := runSandbox(ctx, runtime, *image, filepath.Join(dir, "files"), sandboxScript(needed, checks), *verbose)
+	if err != nil {
+		errorColor.Println("❌ Error running the container:", err)
+		os.Exit(1)
+	}
+	if len(checks) == 0 {
+		warnColor.Println("⚠️  The profile was applied, but has no shell, git or vim config to check")
+		return
+	}
+	if failed > 0 {
+		errorColor.Printf("❌ %d of %d checks failed\n", failed, passed+failed)
+		os.Exit(1)
+	}
+	successColor.Printf("✅ All %d checks passed\n", passed)
+}
+
+// containerRuntime finds the container runtime to use: name, or docker or
+// podman, whichever is on PATH
+func containerRuntime(name string) (string, error) {
+	if name != "" {
+		if _, err := exec.LookPath(name); err != nil {
+			return "", fmt.Errorf("%s is not on PATH", name)
+		}
+		return name, nil
+	}
+	for _, candidate := range []string{"docker", "podman"} {
+		if _, err := exec.LookPath(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("neither docker nor podman is on PATH")
+}
+
+// sandboxCheckScript runs command as one check, reporting its result and,
+// when it fails, its errors
+func sandboxCheckScript(name, command string) string {
+	return fmt.Sprintf("if out=$( { %s; } 2>&1 >/dev/null ) && [ -z \"$out\" ]; then echo %s; else echo %s; printf '%%s\\n' \"$out\"; fi\n",
+		command, shellQuote(sandboxMarker+"pass:"+name), shellQuote(sandboxMarker+"fail:"+name))
+}
+
+// sandboxScript is the script run in the container: it unpacks the profile
+// from stdin into the home directory, installs the tools the checks need
+// and runs them
+func sandboxScript(needed []sandboxCheck, checks []string) string {
+	var b strings.Builder
+	b.WriteString("mkdir -p \"$HOME\" && tar -xf - -C \"$HOME\" || exit 1\n")
+	b.WriteString("missing=\n")
+	seen := map[string]bool{}
+	for _, check := range needed {
+		if seen[check.command] {
+			continue
+		}
+		seen[check.command] = true
+		fmt.Fprintf(&b, "command -v %s >/dev/null 2>&1 || missing=\"$missing %s\"\n", check.command, check.pkg)
+	}
+	b.WriteString(`if [ -n "$missing" ]; then
+  if command -v apt-get >/dev/null 2>&1; then
+    apt-get update -qq && DEBIAN_FRONTEND=noninteractive apt-get install -y -qq --no-install-recommends $missing
+  elif command -v apk >/dev/null 2>&1; then
+    apk add --no-cache $missing
+  elif command -v dnf >/dev/null 2>&1; then
+    dnf install -y -q $missing
+  fi >&2
+fi
+`)
+	for _, check := range checks {
+		b.WriteString(check)
+	}
+	return b.String()
+}
+
+// runSandbox runs script in a throwaway container of image, with the files
+// in dir unpacked into its home directory, and reports the results of the
+// checks it runs
+func runSandbox(ctx context.Context, runtime, image, dir, script string, verbose bool) (passed, failed int, err error) {
+	cmd := exec.CommandContext(ctx, runtime, "run", "--rm", "-i", "-e", "HOME="+sandboxHome, "-w", sandboxHome, image, "sh", "-c", script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if verbose {
+		cmd.Stderr = os.Stderr
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 0, 0, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, 0, err
+	}
+	written := make(chan error, 1)
+	go func() {
+		written <- writeSandboxTar(stdin, dir)
+		stdin.Close()
+	}()
+
+	scanner := bufio.NewScanner(stdout)
+	failing := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, sandboxMarker+"pass:"):
+			successColor.Printf("✅ %s\n", strings.TrimPrefix(line, sandboxMarker+"pass:"))
+			passed++
+			failing = false
+		case strings.HasPrefix(line, sandboxMarker+"fail:"):
+			errorColor.Printf("❌ %s\n", strings.TrimPrefix(line, sandboxMarker+"fail:"))
+			failed++
+			failing = true
+		case failing && line != "":
+			warnColor.Println("   " + line)
+		case verbose:
+			fmt.Println(line)
+		}
+	}
+	err = cmd.Wait()
+	if werr := <-written; werr != nil && err == nil {
+		err = werr
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return passed, failed, fmt.Errorf("%w\n%s", err, msg)
+		}
+		return passed, failed, err
+	}
+	return passed, failed, nil
+}
+
+// writeSandboxTar writes the files, directories and links in dir as a tar
+// archive owned by root
+func writeSandboxTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		var target string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if target, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, target)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "root", "root"
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schedule is a command the daemon runs on a cron schedule
+type Schedule struct {
+	// Cron is when to run, e.g. "0 */6 * * *" or "@daily"
+	Cron string `yaml:"schedule"`
+	// Run is the profilesync command to run, e.g. capture --dry-run=false
+	Run commandLine `yaml:"run"`
+	// Jitter delays each run by a random time up to this long, so machines
+	// sharing a config do not all run at once
+	Jitter string `yaml:"jitter"`
+	// CatchUp runs a schedule at start when a run was missed while the
+	// daemon was not running (default true)
+	CatchUp *bool `yaml:"catch-up"`
+
+	cron   *cronSchedule
+	jitter time.Duration
+}
+
+// commandLine accepts a command either as one string, split on spaces, or
+// as a list of arguments
+type commandLine []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (c *commandLine) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*c = strings.Fields(value.Value)
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// validate parses the schedule's cron expression and jitter
+func (s *Schedule) validate() error {
+	if len(s.Run) == 0 {
+		return errors.New("run is empty")
+	}
+	if s.Run[0] == "daemon" || s.Run[0] == "service" {
+		return fmt.Errorf("cannot run %q on a schedule", s.Run[0])
+	}
+	cron, err := parseCron(s.Cron)
+	if err != nil {
+		return err
+	}
+	if cron.next(time.Now()).IsZero() {
+		return fmt.Errorf("%q never fires", s.Cron)
+	}
+	s.cron = cron
+	if s.Jitter != "" {
+		if s.jitter, err = time.ParseDuration(s.Jitter); err != nil || s.jitter < 0 {
+			return fmt.Errorf("invalid jitter %q", s.Jitter)
+		}
+	}
+	return nil
+}
+
+// scheduleRecord is what the state file keeps about one schedule
+type scheduleRecord struct {
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	State    string     `json:"state,omitempty"`
+	ExitCode int        `json:"exit_code"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+}
+
+// schedulesPath is where the last run of each schedule is recorded
+func schedulesPath() string {
+	return filepath.Join(stateDir(), "schedules.json")
+}
+
+// scheduler runs the daemon's schedules and records their runs
+type scheduler struct {
+	d         *daemon
+	schedules map[string]*Schedule
+
+	mu      sync.Mutex
+	records map[string]*scheduleRecord
+}
+
+// newScheduler reads the records of earlier runs
+func newScheduler(d *daemon, schedules map[string]*Schedule) (*scheduler, error) {
+	s := &scheduler{d: d, schedules: schedules, records: map[string]*scheduleRecord{}}
+	data, err := readStateFile(schedulesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.records); err != nil {
+		return nil, fmt.Errorf("corrupt schedule state %s: %w", schedulesPath(), err)
+	}
+	return s, nil
+}
+
+// update changes the record of a schedule and saves all of them
+func (s *scheduler) update(name string, change func(*scheduleRecord)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[name]
+	if !ok {
+		r = &scheduleRecord{}
+		s.records[name] = r
+	}
+	change(r)
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err == nil {
+		err = writeStateFile(schedulesPath(), data, 0600)
+	}
+	if err != nil {
+		warnColor.Println("⚠️  Could not save schedule state:", err)
+	}
+}
+
+// lastRun returns when a schedule last ran
+func (s *scheduler) lastRun(name string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.records[name]; ok && r.LastRun != nil {
+		return *r.LastRun
+	}
+	return time.Time{}
+}
+
+// start runs every schedule until ctx is done
+func (s *scheduler) start(ctx context.Context) {
+	for name, sched := range s.schedules {
+		go s.loop(ctx, name, sched)
+	}
+}
+
+// loop runs one schedule each time it comes due
+func (s *scheduler) loop(ctx context.Context, name string, sched *Schedule) {
+	first := true
+	for {
+		now := time.Now()
+		at := sched.cron.next(now)
+		last := s.lastRun(name)
+		if first && !last.IsZero() && (sched.CatchUp == nil || *sched.CatchUp) {
+			// A run came due while the daemon was down
+			if missed := sched.cron.next(last); !missed.IsZero() && missed.Before(now) {
+				noticeColor.Printf("⏰ Schedule %s missed its run at %s, catching up\n", name, missed.Format(time.RFC822))
+				at = now
+			}
+		}
+		first = false
+		if at.IsZero() {
+			return
+		}
+		if sched.jitter > 0 {
+			at = at.Add(time.Duration(rand.Int63n(int64(sched.jitter))))
+		}
+		s.update(name, func(r *scheduleRecord) { r.NextRun = &at })
+
+		// Wake up every minute rather than sleep until the run, as the
+		// wall clock jumps ahead while the machine is suspended
+		for time.Now().Before(at) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(min(time.Until(at), time.Minute)):
+			}
+		}
+		s.run(ctx, name, sched)
+	}
+}
+
+// run starts a schedule's command as a job, once no other job is running,
+// and records how it went
+func (s *scheduler) run(ctx context.Context, name string, sched *Schedule) {
+	var job *daemonJob
+	for {
+		var err error
+		job, err = s.d.start(sched.Run, "schedule:"+name)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+	infoColor.Printf("⏰ Schedule %s started job %s: profilesync %s\n", name, job.ID, strings.Join(sched.Run, " "))
+	started := time.Now().UTC()
+	s.update(name, func(r *scheduleRecord) { r.LastRun, r.State = &started, jobRunning })
+
+	info := job.wait(ctx)
+	s.update(name, func(r *scheduleRecord) { r.State, r.ExitCode = info.State, info.ExitCode })
+	if info.State == jobFailed {
+		warnColor.Printf("⚠️  Schedule %s failed (exit code %d); see job %s\n", name, info.ExitCode, job.ID)
+	}
+}
+
+// apiSchedule is a schedule as the API shows it
+type apiSchedule struct {
+	Name     string   `json:"name"`
+	Schedule string   `json:"schedule"`
+	Run      []string `json:"run"`
+	scheduleRecord
+}
+
+// handleSchedules answers GET /v1/schedules with the configured schedules
+// and their last and next runs
+func (d *daemon) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	schedules := []apiSchedule{}
+	if d.scheduler != nil {
+		d.scheduler.mu.Lock()
+		for name, sched := range d.scheduler.schedules {
+			a := apiSchedule{Name: name, Schedule: sched.Cron, Run: sched.Run}
+			if rec, ok := d.scheduler.records[name]; ok {
+				a.scheduleRecord = *rec
+			}
+			schedules = append(schedules, a)
+		}
+		d.scheduler.mu.Unlock()
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	writeJSON(w, http.StatusOK, map[string]any{"schedules": schedules})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// starMaxSteps stops a transform script that loops for too long
+const starMaxSteps = 10_000_000
+
+// starOptions are the Starlark dialect of transform scripts. Beyond the
+// language spec, they may use if and for at the top level and assign a
+// global more than once, as Python does
+var starOptions = &syntax.FileOptions{TopLevelControl: true, GlobalReassign: true}
+
+// starPredeclared are the modules scripts get besides the built-in
+// functions. There are none for files, the network, the clock or the
+// environment, so scripts can only compute
+var starPredeclared = starlark.StringDict{
+	"json": &starlarkstruct.Module{
+		Name: "json",
+		Members: starlark.StringDict{
+			"decode":        starlarkjson.Module.Members["decode"],
+			"encode":        starlarkjson.Module.Members["encode"],
+			"encode_indent": starlark.NewBuiltin("json.encode_indent", starJSONEncodeIndent),
+			"indent":        starlarkjson.Module.Members["indent"],
+		},
+	},
+}
+
+// errStarLoad is returned for scripts that load others, as each must be
+// complete in itself
+var errStarLoad = errors.New("load is not supported in transform scripts")
+
+// starJSONEncodeIndent is json.encode followed by json.indent
+func starJSONEncodeIndent(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	prefix, indent := starlark.String(""), starlark.String("\t")
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &x, "prefix?", &prefix, "indent?", &indent); err != nil {
+		return nil, err
+	}
+	encoded, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{x}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Call(thread, starlarkjson.Module.Members["indent"], starlark.Tuple{encoded},
+		[]starlark.Tuple{{starlark.String("prefix"), prefix}, {starlark.String("indent"), indent}})
+}
+
+// checkTransformScript compiles the transform script at path, so syntax
+// errors and undefined names are reported when the config is loaded rather
+// than mid-apply
+func checkTransformScript(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, prog, err := starlark.SourceProgramOptions(starOptions, filepath.Base(path), src, starPredeclared.Has)
+	if err == nil && prog.NumLoads() > 0 {
+		err = fmt.Errorf("%s: %w", filepath.Base(path), errStarLoad)
+	}
+	return err
+}
+
+// runTransformScript runs the transform function of the Starlark script at
+// path on content. It gets the content, the destination platform and the
+// config's variables, and returns the new content
+func runTransformScript(path, content, platform string, variables map[string]string) (string, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(path)
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			infoColor.Printf("📜 %s: %s\n", name, msg)
+		},
+		Load: func(*starlark.Thread, string) (starlark.StringDict, error) {
+			return nil, errStarLoad
+		},
+	}
+	thread.SetMaxExecutionSteps(starMaxSteps)
+	globals, err := starlark.ExecFileOptions(starOptions, thread, name, src, starPredeclared)
+	if err != nil {
+		return "", starScriptError(name, err)
+	}
+	fn, ok := globals["transform"]
+	if !ok {
+		return "", fmt.Errorf("%s does not define transform(content, platform, variables)", name)
+	}
+
+	vars := starlark.NewDict(len(variables))
+	names := make([]string, 0, len(variables))
+	for k := range variables {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		vars.SetKey(starlark.String(k), starlark.String(variables[k]))
+	}
+	args := starlark.Tuple{starlark.String(content), starlark.String(platform), vars}
+	if f, ok := fn.(*starlark.Function); ok && !f.HasVarargs() {
+		// transform may leave out the arguments it does not use
+		positional := f.NumParams() - f.NumKwonlyParams()
+		if f.HasKwargs() {
+			positional--
+		}
+		args = args[:min(positional, len(args))]
+	}
+	result, err := starlark.Call(thread, fn, args, nil)
+	if err != nil {
+		return "", starScriptError(name, err)
+	}
+	out, ok := result.(starlark.String)
+	if !ok {
+		return "", fmt.Errorf("%s: transform returned %s, not a string", name, result.Type())
+	}
+	return string(out), nil
+}
+
+// starScriptError places an error of the script name at the line of it
+// that was running
+func starScriptError(name string, err error) error {
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) {
+		return err
+	}
+	for i := range evalErr.CallStack {
+		if frame := evalErr.CallStack.At(i); frame.Pos.Filename() == name {
+			return fmt.Errorf("%s: %s", frame.Pos, evalErr.Msg)
+		}
+	}
+	return fmt.Errorf("%s: %s", name, evalErr.Msg)
+}
+
+// applyTransforms runs the config's transform scripts, and the transforms
+// of plugins for mappings without a script, on the configs the migration wrote
+func (ps *ProfileSync) applyTransforms() {
+	if len(ps.transforms) == 0 && len(ps.plugins) == 0 {
+		return
+	}
+	variables := platformDirs(ps.destPlatform, ps.destHome)
+	for k, v := range ps.variables {
+		variables[k] = v
+	}
+
+	var changedPaths []string
+	for _, item := range ps.migrationPlan.Items {
+		var by, what string
+		var run func(content string) (string, error)
+		if script, ok := ps.transforms[item.Mapping]; ok {
+			by = filepath.Base(script)
+			what = "the transform script " + by
+			run = func(content string) (string, error) {
+				return runTransformScript(script, content, ps.destPlatform, variables)
+			}
+		} else if p := pluginFor(ps.plugins, item.Mapping); p != nil {
+			by = "the " + p.Name + " plugin"
+			what = by
+			run = func(content string) (string, error) {
+				return p.transform(item.Mapping, content, ps.destPlatform, variables)
+			}
+		} else {
+			continue
+		}
+		path := item.DestinationPath
+		if ps.dryRun {
+			// The destination may only exist after the migration
+			if _, err := os.Stat(path); err != nil {
+				path = item.SourcePath
+			}
+		}
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil && info.IsDir() {
+			err = errors.New("transform scripts only work on single-file mappings")
+		}
+		var data []byte
+		var out string
+		if err == nil {
+			data, err = os.ReadFile(path)
+		}
+		if err == nil {
+			out, err = run(string(data))
+		}
+		if err == nil {
+			err = checkTransformed("", item.DestinationPath, []byte(out), what)
+		}
+		if err != nil {
+			warnColor.Printf("⚠️  Transform of %s: %v\n", item.Mapping, err)
+			continue
+		}
+		if bytes.Equal([]byte(out), data) {
+			continue
+		}
+		if ps.dryRun {
+			noticeColor.Printf("📜 Would transform %s with %s\n", item.DestinationPath, by)
+			continue
+		}
+		if err := writeFileAtomic(path, []byte(out), info.Mode().Perm()); err != nil {
+			warnColor.Printf("⚠️  Transform of %s: %v\n", item.Mapping, err)
+			continue
+		}
+		noticeColor.Printf("📜 Transformed %s with %s\n", item.DestinationPath, by)
+		changedPaths = append(changedPaths, path)
+	}
+	if len(changedPaths) > 0 {
+		rehashDeployed(changedPaths)
+	}
+}
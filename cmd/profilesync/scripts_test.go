@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeScript writes a transform script to a temporary directory
+func writeScript(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tool.star")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunTransformScript(t *testing.T) {
+	tests := []struct {
+		name, src, content, want string
+	}{
+		{
+			name: "all arguments",
+			src: `def transform(content, platform, variables):
+    return content.replace("/Users/me", variables["HOME"]) + platform
+`,
+			content: "path = /Users/me\n",
+			want:    "path = /home/me\nlinux",
+		},
+		{
+			name:    "arguments left out",
+			src:     "def transform(content):\n    return content.upper()\n",
+			content: "abc",
+			want:    "ABC",
+		},
+		{
+			name:    "varargs",
+			src:     "def transform(*args):\n    return str(len(args))\n",
+			content: "",
+			want:    "3",
+		},
+		{
+			name:    "json",
+			src:     "def transform(content):\n    d = json.decode(content)\n    d[\"b\"] = [1, 2]\n    return json.encode_indent(d, indent=\"  \")\n",
+			content: `{"a": true}`,
+			want:    "{\n  \"a\": true,\n  \"b\": [\n    1,\n    2\n  ]\n}",
+		},
+		{
+			name: "top-level control and reassigned globals",
+			src: `suffix = ""
+for word in ["a", "b"]:
+    suffix += word
+suffix = suffix.upper()
+def transform(content):
+    return content + suffix
+`,
+			content: "x",
+			want:    "xAB",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runTransformScript(writeScript(t, tt.src), tt.content, "linux", map[string]string{"HOME": "/home/me"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("transform = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunTransformScriptErrors(t *testing.T) {
+	tests := []struct {
+		name, src, err string
+	}{
+		{"no transform", "x = 1\n", "does not define transform"},
+		{"not a string", "def transform(content):\n    return 1\n", "transform returned int, not a string"},
+		{"fail", "def transform(content):\n    fail(\"no way\")\n", "tool.star:2:9: fail: no way"},
+		{"error at load", "x = 1 // 0\ndef transform(content):\n    return content\n", "tool.star:1:7: floored division by zero"},
+		{"runaway loop", "def transform(content):\n    for i in range(100000000):\n        content += \"\"\n    return content\n", "too many steps"},
+		{"no files", "def transform(content):\n    return open(\"/etc/passwd\")\n", "undefined: open"},
+		{"load", "load(\"x.star\", \"y\")\ndef transform(content):\n    return y\n", "load is not supported in transform scripts"},
+		{"no recursion", "def f(n):\n    return f(n - 1)\ndef transform(content):\n    return f(1)\n", "called recursively"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runTransformScript(writeScript(t, tt.src), "content", "linux", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckTransformScript(t *testing.T) {
+	tests := []struct {
+		name, src, err string
+	}{
+		{"valid", "def transform(content):\n    return json.encode(content)\n", ""},
+		{"syntax error", "def transform(content)\n    return content\n", "tool.star:2:1: got newline, want ':'"},
+		{"undefined name", "def transform(content):\n    return contnet\n", "undefined: contnet"},
+		{"load", "load(\"x.star\", \"y\")\n", "tool.star: load is not supported in transform scripts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTransformScript(writeScript(t, tt.src))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sealedMagic prefixes every encrypted state file
+	sealedMagic = "PSENC1\n"
+
+	// stateKeyName is the keystore entry holding the state encryption key
+	stateKeyName = "state-key"
+
+	// encryptedMarker in the state directory turns encryption on for all later writes
+	encryptedMarker = ".encrypted"
+)
+
+// plainStateFiles are kept in plain text even with state encryption, as
+// paths below the state directory. The audit log and the service log are
+// only ever appended to, and daemon clients read the token and address
+var plainStateFiles = []string{auditLogName, "daemon.token", "daemon.json", "job-*.log", "logs/service.log"}
+
+var (
+	stateKeyMu    sync.Mutex
+	stateKeyCache []byte
+)
+
+// backupsDir holds copies of files displaced by a migration
+func backupsDir() string {
+	return filepath.Join(stateDir(), "backups")
+}
+
+// backupFile keeps a copy of a destination file that is about to be
+// rewritten in place, returning where the copy went
+func backupFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	name := time.Now().UTC().Format("20060102T150405.000Z") + "-" + filepath.Base(path)
+	target := filepath.Join(backupsDir(), name)
+	return target, writeStateFile(target, data, 0600)
+}
+
+// quarantineDir holds untrusted content withheld from a migration
+func quarantineDir() string {
+	return filepath.Join(stateDir(), "quarantine")
+}
+
+// stateEncryptionEnabled reports whether state, backups and quarantine files are encrypted at rest
+func stateEncryptionEnabled() bool {
+	switch strings.ToLower(os.Getenv("PROFILESYNC_ENCRYPT_STATE")) {
+	case "1", "true", "yes":
+		return true
+	}
+	_, err := os.Stat(filepath.Join(stateDir(), encryptedMarker))
+	return err == nil
+}
+
+// stateKey returns the state encryption key from the OS keystore, creating
+// one when create is set. PROFILESYNC_STATE_KEY (base64) overrides the
+// keystore for headless machines
+func stateKey(create bool) ([]byte, error) {
+	stateKeyMu.Lock()
+	defer stateKeyMu.Unlock()
+
+	if stateKeyCache != nil {
+		return stateKeyCache, nil
+	}
+
+	encoded := os.Getenv("PROFILESYNC_STATE_KEY")
+	if encoded == "" {
+		var err error
+		metrics.request("keystore")
+		encoded, err = keystoreGet(stateKeyName)
+		// Only a key that is really not there is created: a locked or
+		// unreachable keystore must not lose the key everything is sealed with
+		if errors.Is(err, errSecretNotFound) && create {
+			key := make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+			encoded = base64.StdEncoding.EncodeToString(key)
+			metrics.request("keystore")
+			if err := keystoreAdd(stateKeyName, encoded); errors.Is(err, errSecretExists) {
+				// Another run stored one first
+				if encoded, err = keystoreGet(stateKeyName); err != nil {
+					return nil, fmt.Errorf("reading state key: %w", err)
+				}
+			} else if err != nil {
+				return nil, fmt.Errorf("storing state key: %w", err)
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("reading state key: %w", err)
+		}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("state key must be 32 bytes, base64 encoded")
+	}
+	stateKeyCache = key
+	return key, nil
+}
+
+// sealBytes encrypts data with AES-256-GCM
+func sealBytes(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(sealedMagic), nonce...)
+	return gcm.Seal(out, nonce, data, []byte(sealedMagic)), nil
+}
+
+// openBytes decrypts data produced by sealBytes
+func openBytes(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	body := data[len(sealedMagic):]
+	if len(body) < gcm.NonceSize() {
+		return nil, errors.New("sealed file is truncated")
+	}
+	return gcm.Open(nil, body[:gcm.NonceSize()], body[gcm.NonceSize():], []byte(sealedMagic))
+}
+
+// newGCM builds the AEAD used for state files
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isSealed reports whether data is an encrypted state file
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedMagic))
+}
+
+// writeStateFile atomically writes a file under the state directory,
+// encrypting it when state encryption is enabled. Everything profilesync
+// keeps in its state, backups and quarantine directories goes through here
+func writeStateFile(path string, data []byte, perm fs.FileMode) error {
+	if stateEncryptionEnabled() {
+		key, err := stateKey(true)
+		if err != nil {
+			return err
+		}
+		if data, err = sealBytes(key, data); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readStateFile reads a state file, decrypting it if it was sealed
+func readStateFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !isSealed(data) {
+		return data, err
+	}
+	key, err := stateKey(false)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := openBytes(key, data)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %w", path, err)
+	}
+	return plain, nil
+}
+
+// isPlainStateFile reports whether file, below the state directory root,
+// is one of plainStateFiles
+func isPlainStateFile(root, file string) bool {
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range plainStateFiles {
+		if ok, _ := path.Match(pattern, filepath.ToSlash(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resealStateDir rewrites every file under the state directory either
+// encrypted or in plain text, returning how many files changed
+func resealStateDir(encrypt bool) (int, error) {
+	root := stateDir()
+	changed := 0
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			// DPAPI-protected keys are already sealed by the OS
+			if path != root && d.Name() == "keys" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == encryptedMarker || !d.Type().IsRegular() || isPlainStateFile(root, path) {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if isSealed(data) == encrypt {
+			return nil
+		}
+
+		key, err := stateKey(encrypt)
+		if err != nil {
+			return err
+		}
+		var out []byte
+		if encrypt {
+			out, err = sealBytes(key, data)
+		} else {
+			out, err = openBytes(key, data)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, out, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		changed++
+		return nil
+	})
+
+	return changed, err
+}
+
+// runState handles `profilesync state encrypt|decrypt|status|rotate`
+func runState(args []string) {
+	if len(args) == 0 || (len(args) > 1 && args[0] != "rotate") {
+		errorColor.Println("❌ Usage: profilesync state encrypt|decrypt|status|rotate")
+		os.Exit(2)
+	}
+	marker := filepath.Join(stateDir(), encryptedMarker)
+
+	switch args[0] {
+	case "encrypt":
+		if _, err := stateKey(true); err != nil {
+			errorColor.Println("❌ Error getting encryption key:", err)
+			os.Exit(1)
+		}
+		if err := os.MkdirAll(stateDir(), 0700); err != nil {
+			errorColor.Println("❌ Error creating state directory:", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(marker, nil, 0600); err != nil {
+			errorColor.Println("❌ Error enabling encryption:", err)
+			os.Exit(1)
+		}
+		changed, err := resealStateDir(true)
+		if err != nil {
+			errorColor.Println("❌ Error encrypting state:", err)
+			os.Exit(1)
+		}
+		successColor.Printf("🔒 State encryption enabled (%d existing files encrypted)\n", changed)
+	case "decrypt":
+		changed, err := resealStateDir(false)
+		if err != nil {
+			errorColor.Println("❌ Error decrypting state:", err)
+			os.Exit(1)
+		}
+		if err := os.Remove(marker); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errorColor.Println("❌ Error disabling encryption:", err)
+			os.Exit(1)
+		}
+		successColor.Printf("🔓 State encryption disabled (%d files decrypted)\n", changed)
+	case "status":
+		noticeColor.Printf("State directory:   %s\n", stateDir())
+		noticeColor.Printf("Encryption:        %s\n", map[bool]string{true: "enabled", false: "disabled"}[stateEncryptionEnabled()])
+		printStateUsage()
+	case "rotate":
+		runStateRotate(args[1:])
+	default:
+		errorColor.Println("❌ Unknown state command:", args[0])
+		errorColor.Println("Must be one of: encrypt, decrypt, status, rotate")
+		os.Exit(2)
+	}
+}
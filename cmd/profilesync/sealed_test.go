@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{nil, []byte("{}"), bytes.Repeat([]byte("profile "), 10000)} {
+		sealed, err := sealBytes(key, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isSealed(sealed) {
+			t.Errorf("sealed data of %d bytes is not recognized as sealed", len(data))
+		}
+		if len(data) > 0 && bytes.Contains(sealed, data) {
+			t.Errorf("sealed data of %d bytes contains the plain text", len(data))
+		}
+		plain, err := openBytes(key, sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plain, data) {
+			t.Errorf("round trip of %d bytes returned %d bytes", len(data), len(plain))
+		}
+	}
+}
+
+func TestSealFresh(t *testing.T) {
+	key := make([]byte, 32)
+	a, err := sealBytes(key, []byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sealBytes(key, []byte("same"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Error("sealing the same data twice gave the same output")
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	key := make([]byte, 32)
+	sealed, err := sealBytes(key, []byte("secret state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey := bytes.Repeat([]byte{1}, 32)
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name string
+		key  []byte
+		data []byte
+	}{
+		{"wrong key", otherKey, sealed},
+		{"tampered", key, tampered},
+		{"truncated nonce", key, sealed[:len(sealedMagic)+4]},
+		{"truncated tag", key, sealed[:len(sealed)-1]},
+		{"shorter key", key[:16], sealed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if plain, err := openBytes(tt.key, tt.data); err == nil {
+				t.Errorf("openBytes = %q, want an error", plain)
+			}
+		})
+	}
+}
+
+func TestResealKeepsPlainFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROFILESYNC_STATE_DIR", dir)
+	t.Setenv("PROFILESYNC_STATE_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	stateKeyCache = nil
+	t.Cleanup(func() { stateKeyCache = nil })
+
+	token, err := daemonToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"deployed.json":    "{}",
+		"daemon.json":      `{"address":"127.0.0.1:7777"}`,
+		auditLogName:       "{}\n",
+		"logs/service.log": "started\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := resealStateDir(true); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, sealed := range map[string]bool{
+		"deployed.json":    true,
+		"daemon.token":     false,
+		"daemon.json":      false,
+		auditLogName:       false,
+		"logs/service.log": false,
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isSealed(data) != sealed {
+			t.Errorf("%s sealed = %v, want %v", name, !sealed, sealed)
+		}
+	}
+
+	// The daemon still authenticates clients with the token they read
+	again, err := daemonToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &daemon{token: again}
+	r := httptest.NewRequest("GET", "/v1/jobs", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if !d.authorized(r) {
+		t.Error("the token written before encryption is refused after it")
+	}
+}
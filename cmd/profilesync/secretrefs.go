@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// secretRefPattern matches a secret reference in a deployed file, such as
+// {{ op://Personal/npm/token }} or {{ bw://npm/password }}
+var secretRefPattern = regexp.MustCompile(`\{\{\s*((?:op|bw)://[^\s{}]+)\s*\}\}`)
+
+// maxSecretRefFile is the largest file searched for secret references;
+// dotfiles are small, and larger files are rarely text
+const maxSecretRefFile = 1 << 20
+
+// secretManagers are the CLIs that resolve each kind of reference
+var secretManagers = map[string]struct{ command, name string }{
+	"op": {"op", "1Password"},
+	"bw": {"bw", "Bitwarden"},
+}
+
+// secretResolver resolves references through the password managers' CLIs,
+// asking for each secret once per run
+type secretResolver struct {
+	cache map[string]string
+}
+
+// resolve returns the value of one reference
+func (r *secretResolver) resolve(ref string) (string, error) {
+	if value, ok := r.cache[ref]; ok {
+		return value, nil
+	}
+	scheme, rest, _ := strings.Cut(ref, "://")
+	manager := secretManagers[scheme]
+	if _, err := exec.LookPath(manager.command); err != nil {
+		return "", fmt.Errorf("%s needs the %s CLI (%s), which is not installed", ref, manager.name, manager.command)
+	}
+	metrics.request(manager.command)
+
+	var value string
+	var err error
+	switch scheme {
+	case "op":
+		value, err = runSecretCLI("op", "read", "--no-newline", ref)
+	case "bw":
+		value, err = resolveBitwarden(rest)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ref, err)
+	}
+	r.cache[ref] = value
+	return value, nil
+}
+
+// runSecretCLI runs a password manager's CLI, returning its output
+func runSecretCLI(name string, args ...string) (string, error) {
+	return runSecretCommand(exec.Command(name, args...))
+}
+
+// runSecretCommand runs a secret manager's CLI prepared with its stdin or
+// environment, returning its output
+func runSecretCommand(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(lastLine(msg))
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// resolveBitwarden reads ITEM/FIELD through the Bitwarden CLI. FIELD is
+// username, password, totp, notes or uri, or the name of a custom field.
+// The vault must be unlocked, with BW_SESSION set
+func resolveBitwarden(ref string) (string, error) {
+	i := strings.LastIndex(ref, "/")
+	if i <= 0 || i == len(ref)-1 {
+		return "", errors.New("must be bw://ITEM/FIELD")
+	}
+	item, field := ref[:i], ref[i+1:]
+	if os.Getenv("BW_SESSION") == "" {
+		return "", errors.New("the Bitwarden vault is locked; run `bw unlock` and export BW_SESSION")
+	}
+	switch field {
+	case "username", "password", "totp", "notes", "uri":
+		return runSecretCLI("bw", "get", field, item)
+	}
+	out, err := runSecretCLI("bw", "get", "item", item)
+	if err != nil {
+		return "", err
+	}
+	var found struct {
+		Fields []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(out), &found); err != nil {
+		return "", fmt.Errorf("reading bw output: %w", err)
+	}
+	for _, f := range found.Fields {
+		if f.Name == field {
+			return f.Value, nil
+		}
+	}
+	return "", fmt.Errorf("item %s has no field %s", item, field)
+}
+
+// render replaces every reference in data with its value
+func (r *secretResolver) render(data []byte) ([]byte, error) {
+	var firstErr error
+	out := secretRefPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		ref := string(secretRefPattern.FindSubmatch(match)[1])
+		value, err := r.resolve(ref)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return match
+		}
+		return []byte(value)
+	})
+	return out, firstErr
+}
+
+// secretRefFiles lists the files of an item that may hold references, as
+// pairs of where to look and where the file is deployed. On a dry run the
+// source stands in for destinations that do not exist yet
+func (ps *ProfileSync) secretRefFiles(item MigrationItem) [][2]string {
+	info, err := os.Stat(item.SourcePath)
+	if err != nil {
+		return nil
+	}
+	var files [][2]string
+	add := func(source, dest string) {
+		path := dest
+		if _, err := os.Stat(dest); err != nil {
+			if !ps.dryRun {
+				return
+			}
+			path = source
+		}
+		files = append(files, [2]string{path, dest})
+	}
+	if !info.IsDir() {
+		add(item.SourcePath, item.DestinationPath)
+		return files
+	}
+	filepath.WalkDir(item.SourcePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxSecretRefFile {
+			return nil
+		}
+		rel, err := filepath.Rel(item.SourcePath, path)
+		if err == nil {
+			add(path, filepath.Join(item.DestinationPath, rel))
+		}
+		return nil
+	})
+	return files
+}
+
+// applySecretRefs resolves the 1Password and Bitwarden references in the
+// files the migration wrote, so the profile only ever holds references
+func (ps *ProfileSync) applySecretRefs() {
+	r := &secretResolver{cache: map[string]string{}}
+	var changedPaths []string
+	for _, item := range ps.migrationPlan.Items {
+		for _, f := range ps.secretRefFiles(item) {
+			path, dest := f[0], f[1]
+			info, err := os.Stat(path)
+			if err != nil || info.Size() > maxSecretRefFile {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil || !bytes.Contains(data, []byte("{{")) {
+				continue
+			}
+			refs := secretRefPattern.FindAllSubmatch(data, -1)
+			if len(refs) == 0 {
+				continue
+			}
+
+			if ps.dryRun {
+				managers := map[string]bool{}
+				for _, m := range refs {
+					scheme, _, _ := strings.Cut(string(m[1]), "://")
+					managers[secretManagers[scheme].name] = true
+				}
+				noticeColor.Printf("🔐 Would resolve %d secret reference(s) in %s (%s)\n", len(refs), dest, strings.Join(sortedKeys(managers), ", "))
+				for _, scheme := range sortedKeys(secretManagers) {
+					m := secretManagers[scheme]
+					if _, err := exec.LookPath(m.command); managers[m.name] && err != nil {
+						warnColor.Printf("⚠️  Resolving them needs the %s CLI (%s), which is not installed\n", m.name, m.command)
+					}
+				}
+				continue
+			}
+
+			out, err := r.render(data)
+			if err == nil {
+				err = checkTransformed("", dest, out, "resolving secret references")
+			}
+			if err != nil {
+				warnColor.Printf("⚠️  Secrets in %s were not resolved: %v\n", dest, err)
+				continue
+			}
+			// A file holding secrets is for its owner only
+			err = writeFileAtomic(path, out, info.Mode().Perm())
+			if err == nil {
+				err = os.Chmod(path, info.Mode().Perm()&^0o077)
+			}
+			if err != nil {
+				warnColor.Printf("⚠️  Secrets in %s were not resolved: %v\n", dest, err)
+				continue
+			}
+			noticeColor.Printf("🔐 Resolved %d secret reference(s) in %s\n", len(refs), dest)
+			changedPaths = append(changedPaths, path)
+		}
+	}
+	if len(changedPaths) > 0 {
+		rehashDeployed(changedPaths)
+	}
+}
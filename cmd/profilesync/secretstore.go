@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Backends a secret store can use
+const (
+	secretBackendVault = "vault"
+	secretBackendAWS   = "aws"
+)
+
+// SecretStore keeps the files of sensitive mappings in HashiCorp Vault or
+// AWS Secrets Manager instead of the profile store. Later config files
+// override it field by field
+type SecretStore struct {
+	// Backend is vault or aws
+	Backend string `yaml:"backend"`
+	// Address is the Vault server (default $VAULT_ADDR)
+	Address string `yaml:"address"`
+	// Mount is the Vault KV version 2 secrets engine (default secret)
+	Mount string `yaml:"mount"`
+	// Region is the AWS region (default that of the AWS CLI's config)
+	Region string `yaml:"region"`
+	// Prefix starts every secret's name; $VARIABLES are expanded (default
+	// profilesync/ and the user name)
+	Prefix string `yaml:"prefix"`
+	// Mappings are the mappings kept in the store; by default, those whose
+	// sensitivity is secret
+	Mappings stringList `yaml:"mappings"`
+
+	env []string // the vault CLI's environment, once looked up
+}
+
+// merge returns s with the fields set in override replaced
+func (s SecretStore) merge(override SecretStore) SecretStore {
+	for _, f := range []struct{ field, value *string }{
+		{&s.Backend, &override.Backend},
+		{&s.Address, &override.Address},
+		{&s.Mount, &override.Mount},
+		{&s.Region, &override.Region},
+		{&s.Prefix, &override.Prefix},
+	} {
+		if *f.value != "" {
+			*f.field = *f.value
+		}
+	}
+	if override.Mappings != nil {
+		s.Mappings = override.Mappings
+	}
+	return s
+}
+
+// validate checks the store and fills in its defaults
+func (s *SecretStore) validate() error {
+	switch s.Backend {
+	case secretBackendVault:
+		if s.Mount == "" {
+			s.Mount = "secret"
+		}
+	case secretBackendAWS:
+	default:
+		return fmt.Errorf("backend must be vault or aws, not %q", s.Backend)
+	}
+	if s.Prefix == "" {
+		name := os.Getenv("USER")
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+		s.Prefix = "profilesync/" + name
+	}
+	return nil
+}
+
+// String names the backend for messages
+func (s *SecretStore) String() string {
+	if s.Backend == secretBackendAWS {
+		return "AWS Secrets Manager"
+	}
+	return "HashiCorp Vault"
+}
+
+// keeps reports whether the store, if there is one, keeps an item's files
+func (s *SecretStore) keeps(item MigrationItem) bool {
+	if s == nil {
+		return false
+	}
+	if s.Mappings == nil {
+		return item.Sensitivity == sensitivitySecret
+	}
+	for _, m := range s.Mappings {
+		if m == item.Mapping {
+			return true
+		}
+	}
+	return false
+}
+
+// command returns the backend's CLI, erroring when it is not installed
+func (s *SecretStore) command() (string, error) {
+	name := map[string]string{secretBackendVault: "vault", secretBackendAWS: "aws"}[s.Backend]
+	if _, err := exec.LookPath(name); err != nil {
+		return "", fmt.Errorf("%s needs its CLI (%s), which is not installed", s, name)
+	}
+	return name, nil
+}
+
+// secretNameInvalid matches characters that not every backend allows in a
+// secret's name
+var secretNameInvalid = regexp.MustCompile(`[^A-Za-z0-9/_+=.@-]`)
+
+// secretName is where a file of a mapping is kept. rel is the file's path
+// within a directory mapping, or empty
+func (s *SecretStore) secretName(mapping, rel string) string {
+	name := strings.Trim(os.ExpandEnv(s.Prefix), "/") + "/" + strings.Trim(mapping, "/")
+	if rel != "" {
+		name += "/" + rel
+	}
+	return secretNameInvalid.ReplaceAllString(name, "_")
+}
+
+// storedSecret is the value kept for one file: its content, base64 encoded
+// so binary files survive, and its permissions in octal
+type storedSecret struct {
+	Content string `json:"content"`
+	Mode    string `json:"mode"`
+}
+
+// vaultEnv returns the environment for the vault CLI: the configured
+// address, and the token stored with `auth set token:HOST` unless
+// VAULT_TOKEN is set
+func (s *SecretStore) vaultEnv() ([]string, error) {
+	if s.env != nil {
+		return s.env, nil
+	}
+	env := os.Environ()
+	addr := os.Getenv("VAULT_ADDR")
+	if s.Address != "" {
+		addr = s.Address
+		env = append(env, "VAULT_ADDR="+addr)
+	}
+	if _, ok := os.LookupEnv("VAULT_TOKEN"); !ok && addr != "" {
+		if u, err := url.Parse(addr); err == nil && u.Hostname() != "" {
+			token, ok, err := lookupSecret(authTokenPrefix + u.Hostname())
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				env = append(env, "VAULT_TOKEN="+token)
+			}
+		}
+	}
+	s.env = env
+	return env, nil
+}
+
+// awsArgs adds the configured region to an aws command line
+func (s *SecretStore) awsArgs(args ...string) []string {
+	if s.Region != "" {
+		args = append(args, "--region", s.Region)
+	}
+	return args
+}
+
+// put stores a file's content and permissions under name
+func (s *SecretStore) put(name string, content []byte, mode fs.FileMode) error {
+	command, err := s.command()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(storedSecret{
+		Content: base64.StdEncoding.EncodeToString(content),
+		Mode:    fmt.Sprintf("%04o", mode.Perm()),
+	})
+	if err != nil {
+		return err
+	}
+	metrics.request(command)
+
+	if s.Backend == secretBackendVault {
+		env, err := s.vaultEnv()
+		if err != nil {
+			return err
+		}
+		// "-" reads the fields as JSON from stdin, keeping them off the
+		// command line
+		cmd := exec.Command("vault", "kv", "put", "-mount="+s.Mount, name, "-")
+		cmd.Stdin = bytes.NewReader(value)
+		cmd.Env = env
+		_, err = runSecretCommand(cmd)
+		return err
+	}
+
+	// The aws CLI reads a secret from a file without showing it in ps
+	f, err := os.CreateTemp("", "profilesync-secret-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	secretFile := "file://" + f.Name()
+	_, err = runSecretCommand(exec.Command("aws", s.awsArgs("secretsmanager", "put-secret-value", "--secret-id", name, "--secret-string", secretFile)...))
+	if err != nil && strings.Contains(err.Error(), "ResourceNotFoundException") {
+		_, err = runSecretCommand(exec.Command("aws", s.awsArgs("secretsmanager", "create-secret", "--name", name, "--secret-string", secretFile)...))
+	}
+	return err
+}
+
+// get fetches the content and permissions stored under name
+func (s *SecretStore) get(name string) ([]byte, fs.FileMode, error) {
+	command, err := s.command()
+	if err != nil {
+		return nil, 0, err
+	}
+	metrics.request(command)
+
+	var value string
+	if s.Backend == secretBackendVault {
+		env, err := s.vaultEnv()
+		if err != nil {
+			return nil, 0, err
+		}
+		cmd := exec.Command("vault", "kv", "get", "-mount="+s.Mount, "-format=json", name)
+		cmd.Env = env
+		out, err := runSecretCommand(cmd)
+		if err != nil {
+			return nil, 0, err
+		}
+		var resp struct {
+			Data struct {
+				Data json.RawMessage `json:"data"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			return nil, 0, fmt.Errorf("reading vault output: %w", err)
+		}
+		value = string(resp.Data.Data)
+	} else {
+		value, err = runSecretCommand(exec.Command("aws", s.awsArgs("secretsmanager", "get-secret-value", "--secret-id", name, "--query", "SecretString", "--output", "text")...))
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var stored storedSecret
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return nil, 0, fmt.Errorf("%s was not stored by profilesync: %w", name, err)
+	}
+	content, err := base64.StdEncoding.DecodeString(stored.Content)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s was not stored by profilesync: %w", name, err)
+	}
+	mode, err := strconv.ParseUint(stored.Mode, 8, 32)
+	if err != nil {
+		mode = 0600
+	}
+	return content, fs.FileMode(mode).Perm(), nil
+}
+
+// SecretManifest lists the files `capture secrets` stored, so they can be
+// fetched without listing the backend. It never holds the secrets
+type SecretManifest struct {
+	CapturedAt time.Time    `yaml:"captured_at"`
+	Platform   string       `yaml:"platform"`
+	Backend    string       `yaml:"backend"`
+	Files      []SecretFile `yaml:"files"`
+}
+
+// SecretFile is one file kept in the secret store
+type SecretFile struct {
+	Mapping string `yaml:"mapping"`
+	// Path is the file's path within a directory mapping
+	Path   string `yaml:"path,omitempty"`
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"`
+}
+
+// secretsManifestPath is where the manifest lives in a profile store
+func secretsManifestPath(dir string) string {
+	return filepath.Join(dir, "secrets", "secrets.yaml")
+}
+
+func loadSecretManifest(dir string) (*SecretManifest, error) {
+	data, err := os.ReadFile(secretsManifestPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	var m SecretManifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", secretsManifestPath(dir), err)
+	}
+	return &m, nil
+}
+
+// secretItemFiles lists the regular files of an item's source, with their
+// paths within it; a single-file item has one, with an empty path
+func secretItemFiles(item MigrationItem) (map[string]string, error) {
+	info, err := os.Stat(item.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return map[string]string{"": item.SourcePath}, nil
+	}
+	files := map[string]string{}
+	err = filepath.WalkDir(item.SourcePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(item.SourcePath, path)
+		if err == nil {
+			files[filepath.ToSlash(rel)] = path
+		}
+		return err
+	})
+	return files, err
+}
+
+// CaptureSecrets stores the files of the items the secret store keeps,
+// replacing the profile store's manifest. Files unchanged since the last
+// capture are not stored again
+func CaptureSecrets(dir string, store *SecretStore, items []MigrationItem) (*SecretManifest, int, error) {
+	previous := map[string]string{}
+	if old, err := loadSecretManifest(dir); err == nil && old.Backend == store.Backend {
+		for _, f := range old.Files {
+			previous[f.Name] = f.SHA256
+		}
+	}
+	m := &SecretManifest{CapturedAt: time.Now().UTC(), Platform: DetectPlatform(), Backend: store.Backend}
+	stored := 0
+	for _, item := range items {
+		if !store.keeps(item) {
+			continue
+		}
+		files, err := secretItemFiles(item)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, stored, fmt.Errorf("%s: %w", item.Mapping, err)
+		}
+		for _, rel := range sortedKeys(files) {
+			data, err := os.ReadFile(files[rel])
+			if err != nil {
+				return nil, stored, err
+			}
+			info, err := os.Stat(files[rel])
+			if err != nil {
+				return nil, stored, err
+			}
+			f := SecretFile{Mapping: item.Mapping, Path: rel, Name: store.secretName(item.Mapping, rel), SHA256: hashBytes(data)}
+			if previous[f.Name] != f.SHA256 {
+				if err := store.put(f.Name, data, info.Mode()); err != nil {
+					return nil, stored, fmt.Errorf("storing %s: %w", files[rel], err)
+				}
+				stored++
+			}
+			m.Files = append(m.Files, f)
+		}
+	}
+
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, stored, err
+	}
+	if err := os.MkdirAll(filepath.Dir(secretsManifestPath(dir)), 0700); err != nil {
+		return nil, stored, err
+	}
+	return m, stored, writeFileAtomic(secretsManifestPath(dir), data, 0600)
+}
+
+// applySecretStore fetches the files of the items the secret store keeps,
+// which the migration skipped, using the manifest in the profile store
+// dir. A file that differs from the stored one is only replaced with
+// --force
+func (ps *ProfileSync) applySecretStore(dir string) {
+	store := ps.secretStore
+	var kept []int
+	for i, item := range ps.migrationPlan.Items {
+		if item.KeptIn != "" {
+			kept = append(kept, i)
+		}
+	}
+	if store == nil || len(kept) == 0 {
+		return
+	}
+	m, err := loadSecretManifest(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		warnColor.Printf("⏭️  Nothing is stored in %s yet; run `profilesync capture secrets` where the secrets are set up\n", store)
+		return
+	} else if err != nil {
+		warnColor.Println("⚠️  Could not read the secret store's manifest:", err)
+		return
+	}
+	if m.Backend != store.Backend {
+		warnColor.Printf("⚠️  The secrets were stored in %s, not %s; run `profilesync capture secrets` again\n", &SecretStore{Backend: m.Backend}, store)
+		return
+	}
+	byMapping := map[string][]SecretFile{}
+	for _, f := range m.Files {
+		byMapping[f.Mapping] = append(byMapping[f.Mapping], f)
+	}
+
+	for _, i := range kept {
+		item := &ps.migrationPlan.Items[i]
+		files := byMapping[item.Mapping]
+		if len(files) == 0 {
+			continue
+		}
+		started := time.Now()
+		fetched, upToDate, failed := 0, 0, 0
+		for _, f := range files {
+			dest := item.DestinationPath
+			if f.Path != "" {
+				dest = filepath.Join(dest, filepath.FromSlash(f.Path))
+			}
+			if hash, err := hashFile(dest); err == nil {
+				if hash == f.SHA256 {
+					upToDate++
+					continue
+				}
+				if !ps.force {
+					warnColor.Printf("⚠️  %s differs from the copy in %s; use --force to replace it\n", dest, store)
+					failed++
+					continue
+				}
+			}
+			if ps.dryRun {
+				fetched++
+				continue
+			}
+			ps.audit.watch(dest, item.Mapping)
+			content, mode, err := store.get(f.Name)
+			if err == nil {
+				err = os.MkdirAll(filepath.Dir(dest), 0700)
+			}
+			if err == nil {
+				err = writeFileAtomic(dest, content, mode)
+			}
+			if err == nil {
+				err = os.Chmod(dest, mode)
+			}
+			if err != nil {
+				warnColor.Printf("⚠️  Could not fetch %s from %s: %v\n", dest, store, err)
+				failed++
+				continue
+			}
+			fetched++
+		}
+
+		status := itemUpToDate
+		switch {
+		case fetched > 0:
+			status = itemMigrated
+			if item.Status == itemSkipped {
+				ps.migrationPlan.SkippedItems--
+			}
+			item.Migrated = !ps.dryRun
+			noticeColor.Printf("🔐 %s %d file(s) of %s from %s\n", map[bool]string{true: "Would fetch", false: "Fetched"}[ps.dryRun], fetched, item.Description, store)
+		case failed > 0:
+			status = itemConflicts
+		}
+		ps.finishItem(i, started, status, "kept in "+store.String())
+	}
+}
+
+// secretStorePlan plans this machine's home onto itself with the config's
+// mappings, to find the files the secret store keeps, exiting on errors
+func secretStorePlan(configFile, profile string) (*ProfileSync, *SecretStore) {
+	if configFile == "" {
+		if _, err := os.Stat(configPath()); err != nil {
+			errorColor.Println("❌ Error: no config file; the secret store is set with secret-store in the config")
+			os.Exit(1)
+		}
+		configFile = configPath()
+	}
+	cfg, err := loadConfig(configFile, profile)
+	if err != nil {
+		errorColor.Println("❌ Error loading config:", err)
+		os.Exit(1)
+	}
+	if cfg.SecretStore == nil {
+		errorColor.Printf("❌ Error: %s sets no secret-store\n", configFile)
+		os.Exit(1)
+	}
+	platform := DetectPlatform()
+	ps := NewProfileSync(platform, platform, true, false, false, false, false, 1)
+	ps.mappings = cfg.Mappings
+	ps.secretStore = cfg.SecretStore
+	home := GetHomeDir(platform)
+	if err := ps.CreateMigrationPlan(context.Background(), home, home); err != nil {
+		errorColor.Println("❌ Error creating migration plan:", err)
+		os.Exit(1)
+	}
+	return ps, cfg.SecretStore
+}
+
+// runCaptureSecrets handles `profilesync capture secrets`
+func runCaptureSecrets(args []string) {
+	flags := newFlagSet("capture secrets", "capture secrets [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to record the stored files in")
+	configFile := flags.String("config", "", "Config file with the secret store (default "+configPath()+")")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config")
+	flags.Parse(args)
+
+	ps, store := secretStorePlan(*configFile, *profile)
+	m, stored, err := CaptureSecrets(*dir, store, ps.migrationPlan.Items)
+	if err != nil {
+		errorColor.Println("❌ Error capturing secrets:", err)
+		os.Exit(1)
+	}
+	for _, f := range m.Files {
+		fmt.Printf("   🔑 %s\n", f.Name)
+	}
+	successColor.Printf("✅ %d file(s) kept in %s, %d of them stored now; recorded in %s\n", len(m.Files), store, stored, secretsManifestPath(*dir))
+}
+
+// runApplySecrets handles `profilesync apply secrets`
+func runApplySecrets(args []string) {
+	flags := newFlagSet("apply secrets", "apply secrets [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to read from")
+	configFile := flags.String("config", "", "Config file with the secret store (default "+configPath()+")")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config")
+	dryRun := flags.Bool("dry-run", true, "Show the files that would be fetched without fetching them")
+	force := flags.Bool("force", false, "Replace files that differ from the stored ones")
+	flags.Parse(args)
+
+	ps, _ := secretStorePlan(*configFile, *profile)
+	ps.dryRun, ps.force = *dryRun, *force
+	if !*dryRun {
+		ps.audit = newAuditTrail("apply", "")
+		ps.audit.journal = true
+	}
+	ps.applySecretStore(*dir)
+	ps.audit.flush()
+	changed, conflicts := false, false
+	for _, item := range ps.migrationPlan.Items {
+		changed = changed || strings.HasSuffix(item.Status, itemMigrated)
+		conflicts = conflicts || strings.HasSuffix(item.Status, itemConflicts) || item.Status == "would conflict"
+	}
+	if !changed && !conflicts {
+		successColor.Println("✅ Files kept in the secret store are up to date")
+		return
+	}
+	if *dryRun {
+		warnColor.Println("⚠️  This was a DRY RUN. Run with --dry-run=false to apply.")
+	}
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// sendMagic starts every transfer, naming the protocol and its version
+const sendMagic = "PSSEND1\n"
+
+// sendChunkSize is how much of the archive each encrypted frame carries
+const sendChunkSize = 1 << 20
+
+// sendKDFIterations makes guessing the pairing code offline expensive
+const sendKDFIterations = 600_000
+
+// pairingAlphabet is Crockford's base32, which avoids letters that read
+// like digits
+const pairingAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// pairingSecretLen is how many characters of the alphabet a pairing code's
+// secret has, 5 bits each
+const pairingSecretLen = 16
+
+// sendHello is what a receiver proves it knows the code with
+const sendHello = "profilesync receive"
+
+// sendManifest describes a transferred profile
+type sendManifest struct {
+	Machine  string    `json:"machine"`
+	Platform string    `json:"platform"`
+	Created  time.Time `json:"created"`
+	Files    int       `json:"files"`
+}
+
+// newPairingCode returns a code like 417-K7Q2-M9XD-3HTP-W8RA: a channel the
+// receiver finds the sender by, and a secret of 80 bits that never leaves
+// either machine
+func newPairingCode() (string, error) {
+	channel, err := rand.Int(rand.Reader, big.NewInt(900))
+	if err != nil {
+		return "", err
+	}
+	secret := make([]byte, pairingSecretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	for i, b := range secret {
+		secret[i] = pairingAlphabet[int(b)%len(pairingAlphabet)]
+	}
+	groups := []string{fmt.Sprint(100 + channel.Int64())}
+	for i := 0; i < len(secret); i += 4 {
+		groups = append(groups, string(secret[i:i+4]))
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// parsePairingCode splits a code as typed into its channel and secret,
+// forgiving case, spaces and letters mistaken for digits
+func parsePairingCode(code string) (channel, secret string, err error) {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), ""))
+	channel, rest, ok := strings.Cut(code, "-")
+	rest = strings.NewReplacer("-", "", "O", "0", "I", "1", "L", "1").Replace(rest)
+	if !ok || len(channel) != 3 || strings.Trim(channel, "0123456789") != "" || len(rest) != pairingSecretLen || strings.Trim(rest, pairingAlphabet) != "" {
+		return "", "", fmt.Errorf("invalid pairing code %q (expected something like 417-K7Q2-M9XD-3HTP-W8RA)", code)
+	}
+	return channel, rest, nil
+}
+
+// sendKeys derives one key for each direction of a transfer from the secret
+// of the pairing code
+func sendKeys(secret string, salt []byte) (toReceiver, toSender cipher.AEAD, err error) {
+	key := pbkdf2.Key([]byte(secret), salt, sendKDFIterations, 64, sha256.New)
+	if toReceiver, err = newGCM(key[:32]); err != nil {
+		return nil, nil, err
+	}
+	toSender, err = newGCM(key[32:])
+	return toReceiver, toSender, err
+}
+
+// writeFrame encrypts one frame. The counter is the nonce, and the final
+// flag is authenticated so a transfer cut short is noticed
+func writeFrame(w io.Writer, gcm cipher.AEAD, counter uint64, final bool, data []byte) error {
+	nonce := make([]byte, gcm.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	header := make([]byte, 5)
+	if final {
+		header[0] = 1
+	}
+	sealed := gcm.Seal(nil, nonce, data, header[:1])
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(sealed)
+	return err
+}
+
+// readFrame decrypts the next frame
+func readFrame(r io.Reader, gcm cipher.AEAD, counter uint64) (data []byte, final bool, err error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, false, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > sendChunkSize+uint32(gcm.Overhead()) || header[0] > 1 {
+		return nil, false, errors.New("malformed frame")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, false, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	data, err = gcm.Open(nil, nonce, sealed, header[:1])
+	if err != nil {
+		return nil, false, errors.New("wrong pairing code or corrupted transfer")
+	}
+	return data, header[0] == 1, nil
+}
+
+// frameWriter encrypts a stream into frames of sendChunkSize
+type frameWriter struct {
+	w       io.Writer
+	gcm     cipher.AEAD
+	counter uint64
+	buf     []byte
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(sendChunkSize-len(f.buf), len(p))
+		f.buf = append(f.buf, p[:take]...)
+		p = p[take:]
+		if len(f.buf) == sendChunkSize {
+			if err := writeFrame(f.w, f.gcm, f.counter, false, f.buf); err != nil {
+				return 0, err
+			}
+			f.counter++
+			f.buf = f.buf[:0]
+		}
+	}
+	return n, nil
+}
+
+// Close writes the final frame
+func (f *frameWriter) Close() error {
+	return writeFrame(f.w, f.gcm, f.counter, true, f.buf)
+}
+
+// frameReader decrypts a stream written by frameWriter
+type frameReader struct {
+	r       io.Reader
+	gcm     cipher.AEAD
+	counter uint64
+	buf     []byte
+	done    bool
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.done {
+			return 0, io.EOF
+		}
+		data, final, err := readFrame(f.r, f.gcm, f.counter)
+		if errors.Is(err, io.EOF) && f.counter == 0 {
+			// The sender hangs up on receivers that fail to prove the code
+			return 0, errors.New("the sender refused the transfer; check the pairing code")
+		} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, errors.New("transfer was cut short")
+		} else if err != nil {
+			return 0, err
+		}
+		f.counter++
+		f.buf, f.done = data, final
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// sendArchive writes the profile as a gzipped tar: a manifest, the managed
+// files of the plan under home/, the config, and the profile store. The
+// redaction policy applies to the files of the plan
+func sendArchive(w io.Writer, items []MigrationItem, home, config string, policy *redactionPolicy) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	// data, when set, replaces the file's content
+	type entry struct {
+		name, path string
+		data       []byte
+	}
+	var entries []entry
+	add := func(name, path string, item *MigrationItem) error {
+		return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if portable, _ := classifyAppData(p); !portable {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// Follow links, such as those adopt leaves in place of files
+			info, err := os.Stat(p)
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(path, p)
+			if err != nil {
+				return err
+			}
+			e := entry{name: filepath.ToSlash(filepath.Join(name, rel)), path: p}
+			if item != nil {
+				mapped := mappingPath(item.Mapping, filepath.ToSlash(rel))
+				if action := policy.decide(mapped, item.Type); action != redactionAllow {
+					var data []byte
+					if action == redactionRedact {
+						if data, err = os.ReadFile(p); err != nil {
+							return err
+						}
+					}
+					var keep bool
+					if e.data, keep = policy.filter(mapped, item.Type, data); !keep {
+						return nil
+					}
+				}
+			}
+			entries = append(entries, e)
+			return nil
+		})
+	}
+	for _, item := range items {
+		if !withinRoot(home, item.SourcePath) || item.SourcePath == home || item.KeptIn != "" {
+			continue
+		}
+		if _, err := os.Stat(item.SourcePath); err != nil {
+			continue
+		}
+		rel, _ := filepath.Rel(home, item.SourcePath)
+		if err := add(filepath.Join("home", rel), item.SourcePath, &item); err != nil {
+			return err
+		}
+	}
+	if config != "" {
+		entries = append(entries, entry{name: "config.yaml", path: config})
+	}
+	if store, err := os.ReadDir(profileDir()); err == nil {
+		for _, e := range store {
+			// Home trees are sent from the plan; received profiles stay here
+			if e.Name() == "home" || e.Name() == "received" {
+				continue
+			}
+			if err := add(e.Name(), filepath.Join(profileDir(), e.Name()), nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	manifest, err := json.MarshalIndent(sendManifest{
+		Machine:  machineName(),
+		Platform: DetectPlatform(),
+		Created:  time.Now().UTC(),
+		Files:    len(entries),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0600, Size: int64(len(manifest)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if seen[e.name] {
+			continue
+		}
+		seen[e.name] = true
+		if err := addTarFile(tw, e.name, e.path, e.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addTarFile adds one regular file to an archive, with data as its
+// content when it is set
+func addTarFile(tw *tar.Writer, name, path string, data []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if data != nil {
+		size = int64(len(data))
+	}
+	hdr := &tar.Header{Name: name, Mode: int64(info.Mode().Perm()), Size: size, ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if data != nil {
+		_, err = tw.Write(data)
+		return err
+	}
+	_, err = io.CopyN(tw, f, size)
+	return err
+}
+
+// serveSend hands the profile to one receiver that knows the code. It
+// reports whether the transfer completed
+func serveSend(conn net.Conn, secret string, items []MigrationItem, home, config string, policy *redactionPolicy) (bool, error) {
+	defer conn.Close()
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return false, err
+	}
+	if _, err := conn.Write(append([]byte(sendMagic), salt...)); err != nil {
+		return false, err
+	}
+	toReceiver, toSender, err := sendKeys(secret, salt)
+	if err != nil {
+		return false, err
+	}
+
+	// The receiver proves it has the code before anything is sent
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	r := bufio.NewReader(conn)
+	hello, _, err := readFrame(r, toSender, 0)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.HasPrefix(hello, []byte(sendHello)) {
+		return false, errors.New("unexpected greeting")
+	}
+	infoColor.Printf("🔗 %s connected, sending...\n", strings.TrimSpace(strings.TrimPrefix(string(hello), sendHello)))
+
+	conn.SetDeadline(time.Time{})
+	bw := bufio.NewWriter(conn)
+	fw := &frameWriter{w: bw, gcm: toReceiver}
+	if err := sendArchive(fw, items, home, config, policy); err != nil {
+		return false, err
+	}
+	if err := fw.Close(); err != nil {
+		return false, err
+	}
+	if err := bw.Flush(); err != nil {
+		return false, err
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	ack, _, err := readFrame(r, toSender, 1)
+	if err != nil {
+		return false, fmt.Errorf("no confirmation from the receiver: %w", err)
+	}
+	if string(ack) != "ok" {
+		return false, fmt.Errorf("receiver failed: %s", ack)
+	}
+	return true, nil
+}
+
+// runSend handles `profilesync send`, offering this machine's profile to one
+// `profilesync receive` on the local network
+func runSend(args []string) {
+	flags := newFlagSet("send", "send [flags]")
+	configFile := flags.String("config", "", "Config file with the mappings to send (default "+configPath()+" if it exists)")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config whose mappings to send")
+	listen := flags.String("listen", ":0", "Address to accept the receiver on")
+	timeout := flags.Duration("timeout", 10*time.Minute, "Give up when no receiver has connected by then")
+	noDiscovery := flags.Bool("no-discovery", false, "Do not advertise over mDNS; the receiver needs --address")
+	transfer := addBandwidthFlag(flags)
+	flags.Parse(args)
+	rate := transfer.rate()
+
+	if *configFile == "" {
+		if _, err := os.Stat(configPath()); err == nil {
+			*configFile = configPath()
+		}
+	}
+	platform := DetectPlatform()
+	ps := NewProfileSync(platform, platform, true, false, false, false, false, 1)
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile, *profile)
+		if err != nil {
+			errorColor.Println("❌ Error loading config:", err)
+			os.Exit(1)
+		}
+		ps.mappings = cfg.Mappings
+		ps.secretStore = cfg.SecretStore
+		ps.redaction = cfg.Redaction
+	}
+	home := GetHomeDir(platform)
+	if err := ps.CreateMigrationPlan(context.Background(), home, home); err != nil {
+		errorColor.Println("❌ Error creating migration plan:", err)
+		os.Exit(1)
+	}
+
+	code, err := newPairingCode()
+	if err != nil {
+		errorColor.Println("❌", err)
+		os.Exit(1)
+	}
+	channel, secret, _ := parsePairingCode(code)
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		errorColor.Println("❌ Error listening:", err)
+		os.Exit(1)
+	}
+	defer ln.Close()
+	listening := ln.Addr().(*net.TCPAddr)
+	port, host := listening.Port, listening.IP
+	if host.IsUnspecified() {
+		host = localIPv4s()[0]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if !*noDiscovery {
+		txt := []string{"channel=" + channel, "machine=" + machineName(), "platform=" + platform, "v=1"}
+		go func() {
+			if err := mdnsAdvertise(ctx, "profilesync-"+channel, port, txt); err != nil {
+				warnColor.Println("⚠️  Could not advertise on the local network:", err)
+				warnColor.Println("   Use the --address shown below on the receiving machine.")
+			}
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	successColor.Printf("📡 Ready to send %d items. On the other machine run:\n\n", len(ps.migrationPlan.Items))
+	fmt.Printf("   profilesync receive %s\n\n", code)
+	noticeColor.Printf("Without mDNS: profilesync receive --address %s %s\n", net.JoinHostPort(host.String(), fmt.Sprint(port)), code)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				errorColor.Println("❌ No receiver connected within", *timeout)
+			} else {
+				errorColor.Println("❌", err)
+			}
+			os.Exit(1)
+		}
+		policy := newRedactionPolicy(destSend, ps.redaction)
+		done, err := serveSend(newLimitedConn(conn, rate), secret, ps.migrationPlan.Items, home, *configFile, policy)
+		if err != nil {
+			logger().Warn("transfer failed", "peer", conn.RemoteAddr().String(), "error", err)
+			warnColor.Printf("⚠️  Transfer to %s failed: %v\n", conn.RemoteAddr(), err)
+			continue
+		}
+		if done {
+			logger().Info("profile sent", "peer", conn.RemoteAddr().String(), "items", len(ps.migrationPlan.Items))
+			policy.summary()
+			successColor.Println("✅ Profile sent")
+			return
+		}
+	}
+}
+
+// receiveTransfer fetches the profile from a sender and unpacks it into dir
+func receiveTransfer(conn net.Conn, secret, dir string) (*sendManifest, error) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	header := make([]byte, len(sendMagic)+16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(sendMagic)]) != sendMagic {
+		return nil, errors.New("not a profilesync sender, or an incompatible version")
+	}
+	toReceiver, toSender, err := sendKeys(secret, header[len(sendMagic):])
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, toSender, 0, true, []byte(sendHello+" "+machineName())); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	fail := func(err error) (*sendManifest, error) {
+		writeFrame(conn, toSender, 1, true, []byte(err.Error()))
+		return nil, err
+	}
+	gz, err := gzip.NewReader(&frameReader{r: r, gcm: toReceiver})
+	if err != nil {
+		return fail(err)
+	}
+	if err := extractTar(gz, dir); err != nil {
+		return fail(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return fail(errors.New("transfer has no manifest"))
+	}
+	var m sendManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fail(fmt.Errorf("corrupt manifest: %w", err))
+	}
+	if err := writeFrame(conn, toSender, 1, true, []byte("ok")); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// checkReceiveDir refuses a --dir that is not a new or empty directory.
+// Only what receive put under received/ itself is ever replaced
+func checkReceiveDir(dir string) error {
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory; pass a new or empty --dir", dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty; pass a new or empty --dir", dir)
+	}
+	return nil
+}
+
+// runReceive handles `profilesync receive CODE`, finding the sender with the
+// code on the local network and unpacking its profile
+func runReceive(args []string) {
+	flags := newFlagSet("receive", "receive [flags] CODE")
+	address := flags.String("address", "", "Connect to the sender at host:port instead of finding it over mDNS")
+	dest := flags.String("dir", "", "New or empty directory to unpack the profile into (default "+filepath.Join(profileDir(), "received", "<machine>")+", replacing what was received from that machine before)")
+	timeout := flags.Duration("timeout", time.Minute, "How long to look for the sender")
+	transfer := addTransferFlags(flags)
+	flags.Parse(args)
+	rate := transfer.rate()
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	channel, secret, err := parsePairingCode(flags.Arg(0))
+	if err != nil {
+		errorColor.Println("❌", err)
+		os.Exit(2)
+	}
+
+	addr, sender := *address, ""
+	if addr == "" {
+		infoColor.Println("🔍 Looking for the sender on the local network...")
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		peer, err := mdnsBrowse(ctx, func(p mdnsPeer) bool { return p.TXT["channel"] == channel })
+		cancel()
+		if err != nil {
+			errorColor.Println("❌ Could not find the sender:", err)
+			errorColor.Println("   Check both machines are on the same network, or pass --address from the sender's output.")
+			os.Exit(1)
+		}
+		addr, sender = peer.Addr, peer.TXT["machine"]
+		infoColor.Printf("🔗 Found %s at %s\n", sender, addr)
+	}
+
+	if *dest != "" {
+		if err := checkReceiveDir(*dest); err != nil {
+			errorColor.Println("❌", err)
+			os.Exit(1)
+		}
+	}
+	received := filepath.Join(profileDir(), "received")
+	if err := os.MkdirAll(received, 0700); err != nil {
+		errorColor.Println("❌", err)
+		os.Exit(1)
+	}
+	tmp, err := os.MkdirTemp(received, ".incoming-")
+	if err != nil {
+		errorColor.Println("❌", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(tmp)
+
+	// The sender keeps accepting until a transfer completes, so one cut
+	// off is started again from the beginning
+	var m *sendManifest
+	err = transfer.retry().do(context.Background(), "Receiving", func() error {
+		if err := os.RemoveAll(tmp); err != nil {
+			return err
+		}
+		if err := os.Mkdir(tmp, 0700); err != nil {
+			return err
+		}
+		conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+		if err != nil {
+			return fmt.Errorf("connecting to the sender: %w", err)
+		}
+		m, err = receiveTransfer(newLimitedConn(conn, rate), secret, tmp)
+		return err
+	})
+	if err != nil {
+		logger().Error("receive failed", "error", err)
+		errorColor.Println("❌ Error receiving the profile:", err)
+		os.Exit(1)
+	}
+
+	dir := *dest
+	if dir == "" {
+		if dir, err = secureJoin(received, m.Machine); err != nil || dir == received {
+			dir = filepath.Join(received, "unknown")
+		}
+	}
+	if _, err := os.Stat(dir); err == nil && *dest == "" {
+		// An earlier copy from the same machine is replaced, not merged
+		noticeColor.Println("Replacing the profile received earlier in", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			errorColor.Println("❌", err)
+			os.Exit(1)
+		}
+	} else if err == nil {
+		// Checked again, as it may have changed while receiving
+		if err := checkReceiveDir(dir); err != nil {
+			errorColor.Println("❌", err)
+			os.Exit(1)
+		}
+		if err := os.Remove(dir); err != nil {
+			errorColor.Println("❌", err)
+			os.Exit(1)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		errorColor.Println("❌", err)
+		os.Exit(1)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		errorColor.Println("❌ Error saving the profile:", err)
+		os.Exit(1)
+	}
+	if withinRoot(profileDir(), dir) {
+		reportStoreDedup(profileDir())
+	}
+
+	logger().Info("profile received", "files", m.Files, "peer", m.Machine, "platform", m.Platform, "destination", dir)
+	successColor.Printf("✅ Received %d files from %s (%s) into %s\n", m.Files, m.Machine, m.Platform, dir)
+	fmt.Println("Preview applying them with:")
+	fmt.Printf("   profilesync apply --source %s --source-dir %s", m.Platform, filepath.Join(dir, "home"))
+	if _, err := os.Stat(filepath.Join(dir, "config.yaml")); err == nil {
+		fmt.Printf(" --config %s", filepath.Join(dir, "config.yaml"))
+	}
+	fmt.Println()
+}
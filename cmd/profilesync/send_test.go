@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePairingCode(t *testing.T) {
+	tests := []struct {
+		name            string
+		code            string
+		channel, secret string
+		wantErr         bool
+	}{
+		{name: "as printed", code: "417-K7Q2-M9XD-3HTP-W8RA", channel: "417", secret: "K7Q2M9XD3HTPW8RA"},
+		{name: "lower case and spaces", code: " 417-k7q2 m9xd-3htp w8ra\n", channel: "417", secret: "K7Q2M9XD3HTPW8RA"},
+		{name: "letters read as digits", code: "417-O7Q2-M9XD-3HTP-W8IL", channel: "417", secret: "07Q2M9XD3HTPW811"},
+		{name: "without dashes in the secret", code: "417-K7Q2M9XD3HTPW8RA", channel: "417", secret: "K7Q2M9XD3HTPW8RA"},
+		{name: "secret too short", code: "417-K7Q2-M9XD-3HTP", wantErr: true},
+		{name: "secret too long", code: "417-K7Q2-M9XD-3HTP-W8RA-1", wantErr: true},
+		{name: "no channel", code: "K7Q2-M9XD-3HTP-W8RA", wantErr: true},
+		{name: "short channel", code: "41-K7Q2-M9XD-3HTP-W8RA", wantErr: true},
+		{name: "letters in the channel", code: "4I7-K7Q2-M9XD-3HTP-W8RA", wantErr: true},
+		{name: "letter outside the alphabet", code: "417-K7Q2-M9XD-3HTP-W8RU", wantErr: true},
+		{name: "empty", code: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel, secret, err := parsePairingCode(tt.code)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsePairingCode(%q) = %q, %q; want an error", tt.code, channel, secret)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if channel != tt.channel || secret != tt.secret {
+				t.Errorf("parsePairingCode(%q) = %q, %q; want %q, %q", tt.code, channel, secret, tt.channel, tt.secret)
+			}
+		})
+	}
+}
+
+func TestNewPairingCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := newPairingCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups := strings.Split(code, "-")
+		channel, secret, err := parsePairingCode(code)
+		if err != nil {
+			t.Fatalf("generated code %q does not parse: %v", code, err)
+		}
+		if channel != groups[0] || secret != strings.Join(groups[1:], "") {
+			t.Errorf("parsePairingCode(%q) = %q, %q", code, channel, secret)
+		}
+		if seen[secret] {
+			t.Errorf("secret %s generated twice", secret)
+		}
+		seen[secret] = true
+	}
+}
+
+func TestSendKeys(t *testing.T) {
+	salt := bytes.Repeat([]byte{7}, 16)
+	toReceiver, toSender, err := sendKeys("K7Q2M9XD3HTPW8RA", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, toReceiver.NonceSize())
+	sealed := toReceiver.Seal(nil, nonce, []byte("profile"), nil)
+	if _, err := toSender.Open(nil, nonce, sealed, nil); err == nil {
+		t.Error("the key to the sender opens what was sealed for the receiver")
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		salt   []byte
+		opens  bool
+	}{
+		{name: "same code and salt", secret: "K7Q2M9XD3HTPW8RA", salt: salt, opens: true},
+		{name: "other code", secret: "K7Q2M9XD3HTPW8RB", salt: salt},
+		{name: "other salt", secret: "K7Q2M9XD3HTPW8RA", salt: bytes.Repeat([]byte{8}, 16)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other, _, err := sendKeys(tt.secret, tt.salt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := other.Open(nil, nonce, sealed, nil)
+			if tt.opens && (err != nil || string(plain) != "profile") {
+				t.Errorf("derived key does not open the frame: %v", err)
+			} else if !tt.opens && err == nil {
+				t.Error("derived key opens the frame")
+			}
+		})
+	}
+}
+
+// testFrameKey returns a transfer key without the cost of deriving one
+func testFrameKey(t *testing.T) cipher.AEAD {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gcm
+}
+
+// splitFrames splits a framed stream into its frames, headers included
+func splitFrames(t *testing.T, stream []byte) [][]byte {
+	t.Helper()
+	var frames [][]byte
+	for len(stream) > 0 {
+		size := 5 + int(binary.BigEndian.Uint32(stream[1:5]))
+		frames = append(frames, stream[:size])
+		stream = stream[size:]
+	}
+	return frames
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 4096, sendChunkSize, 2*sendChunkSize + 1000} {
+		gcm := testFrameKey(t)
+		data := make([]byte, size)
+		rand.Read(data)
+
+		var stream bytes.Buffer
+		fw := &frameWriter{w: &stream, gcm: gcm}
+		if _, err := fw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := fw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if want := size/sendChunkSize + 1; len(splitFrames(t, stream.Bytes())) != want {
+			t.Errorf("%d bytes were sent in %d frames, want %d", size, len(splitFrames(t, stream.Bytes())), want)
+		}
+		if size > 0 && bytes.Contains(stream.Bytes(), data[:min(size, 64)]) {
+			t.Errorf("the stream of %d bytes contains the plain text", size)
+		}
+
+		got, err := io.ReadAll(&frameReader{r: &stream, gcm: gcm})
+		if err != nil {
+			t.Fatalf("reading %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("round trip of %d bytes returned %d bytes", size, len(got))
+		}
+	}
+}
+
+func TestFrameTampering(t *testing.T) {
+	gcm := testFrameKey(t)
+	data := make([]byte, 2*sendChunkSize+1000)
+	rand.Read(data)
+	var stream bytes.Buffer
+	fw := &frameWriter{w: &stream, gcm: gcm}
+	fw.Write(data)
+	fw.Close()
+
+	join := func(frames ...[]byte) []byte { return bytes.Join(frames, nil) }
+	tests := []struct {
+		name    string
+		tamper  func(f [][]byte) []byte
+		gcm     cipher.AEAD
+		wantErr string
+	}{
+		{
+			name:    "reordered frames",
+			tamper:  func(f [][]byte) []byte { return join(f[1], f[0], f[2]) },
+			wantErr: "wrong pairing code or corrupted transfer",
+		},
+		{
+			name:    "replayed frame",
+			tamper:  func(f [][]byte) []byte { return join(f[0], f[0], f[1], f[2]) },
+			wantErr: "wrong pairing code or corrupted transfer",
+		},
+		{
+			name:    "final frame dropped",
+			tamper:  func(f [][]byte) []byte { return join(f[0], f[1]) },
+			wantErr: "transfer was cut short",
+		},
+		{
+			name: "truncated within a frame",
+			tamper: func(f [][]byte) []byte {
+				s := join(f...)
+				return s[:len(s)-10]
+			},
+			wantErr: "transfer was cut short",
+		},
+		{
+			name: "flag of a frame set to final",
+			tamper: func(f [][]byte) []byte {
+				f1 := append([]byte{}, f[1]...)
+				f1[0] = 1
+				return join(f[0], f1)
+			},
+			wantErr: "wrong pairing code or corrupted transfer",
+		},
+		{
+			name: "flipped byte",
+			tamper: func(f [][]byte) []byte {
+				f1 := append([]byte{}, f[1]...)
+				f1[100] ^= 1
+				return join(f[0], f1, f[2])
+			},
+			wantErr: "wrong pairing code or corrupted transfer",
+		},
+		{
+			name: "oversized frame",
+			tamper: func(f [][]byte) []byte {
+				f0 := append([]byte{}, f[0]...)
+				binary.BigEndian.PutUint32(f0[1:5], 1<<31)
+				return f0
+			},
+			wantErr: "malformed frame",
+		},
+		{
+			name: "unknown flag",
+			tamper: func(f [][]byte) []byte {
+				f0 := append([]byte{}, f[0]...)
+				f0[0] = 2
+				return f0
+			},
+			wantErr: "malformed frame",
+		},
+		{
+			name:    "wrong key",
+			tamper:  func(f [][]byte) []byte { return join(f...) },
+			gcm:     testFrameKey(t),
+			wantErr: "wrong pairing code or corrupted transfer",
+		},
+		{
+			name:    "nothing sent",
+			tamper:  func(f [][]byte) []byte { return nil },
+			wantErr: "the sender refused the transfer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := gcm
+			if tt.gcm != nil {
+				key = tt.gcm
+			}
+			tampered := tt.tamper(splitFrames(t, stream.Bytes()))
+			_, err := io.ReadAll(&frameReader{r: bytes.NewReader(tampered), gcm: key})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("reading got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckReceiveDir(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "empty"), 0700)
+	os.MkdirAll(filepath.Join(root, "full", "sub"), 0700)
+	os.WriteFile(filepath.Join(root, "file"), []byte("keep"), 0600)
+
+	tests := []struct {
+		dir     string
+		wantErr string
+	}{
+		{dir: "new"},
+		{dir: "empty"},
+		{dir: "full", wantErr: "is not empty"},
+		{dir: "file", wantErr: "is not a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			err := checkReceiveDir(filepath.Join(root, tt.dir))
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("checkReceiveDir(%s) = %v", tt.dir, err)
+			} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkReceiveDir(%s) = %v, want %q", tt.dir, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("PROFILESYNC_PROFILE_DIR", t.TempDir())
+	os.WriteFile(filepath.Join(home, ".bashrc"), []byte("export EDITOR=vi\n"), 0600)
+	items := []MigrationItem{{Mapping: "bashrc", SourcePath: filepath.Join(home, ".bashrc"), Type: "file"}}
+
+	tests := []struct {
+		name    string
+		code    string
+		wantErr string
+	}{
+		{name: "same code", code: "K7Q2M9XD3HTPW8RA"},
+		{name: "wrong code", code: "K7Q2M9XD3HTPW8RB", wantErr: "the sender refused the transfer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender, receiver := net.Pipe()
+			sent := make(chan error, 1)
+			go func() {
+				_, err := serveSend(sender, "K7Q2M9XD3HTPW8RA", items, home, "", newRedactionPolicy(destSend, nil))
+				sent <- err
+			}()
+			dir := t.TempDir()
+			m, err := receiveTransfer(receiver, tt.code, dir)
+			sendErr := <-sent
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("receiving got error %v, want %q", err, tt.wantErr)
+				}
+				if sendErr == nil {
+					t.Error("the sender sent to a receiver with the wrong code")
+				}
+				if _, err := os.Stat(filepath.Join(dir, "home")); err == nil {
+					t.Error("files were received with the wrong code")
+				}
+				return
+			}
+			if err != nil || sendErr != nil {
+				t.Fatalf("receiving: %v; sending: %v", err, sendErr)
+			}
+			if m.Files != 1 {
+				t.Errorf("manifest lists %d files, want 1", m.Files)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "home", ".bashrc"))
+			if err != nil || string(data) != "export EDITOR=vi\n" {
+				t.Errorf("received .bashrc = %q, %v", data, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// serviceName names the systemd unit and the Windows scheduled task
+const serviceName = "profilesync"
+
+// serviceLabel is the launchd label of the agent
+const serviceLabel = "com.github.hallucinaut.profilesync"
+
+// serviceSpec is what an installed service runs, and when
+type serviceSpec struct {
+	exe  string
+	args []string
+	// interval runs the command periodically; zero runs it at login and
+	// restarts it when it exits
+	interval time.Duration
+	env      []string
+	log      string
+}
+
+// serviceEnv carries PROFILESYNC_ settings of the installing shell into the
+// service, which would not otherwise see them
+func serviceEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "PROFILESYNC_") {
+			env = append(env, kv)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// systemdDir is where the user units are installed
+func systemdDir() string {
+	return systemdUserDir(GetHomeDir("linux"))
+}
+
+// launchAgentPath is where the launchd agent is installed
+func launchAgentPath() string {
+	return filepath.Join(launchAgentsDir(GetHomeDir("macos")), serviceLabel+".plist")
+}
+
+// systemdQuote quotes an ExecStart or Environment word
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;$") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$").Replace(s) + `"`
+}
+
+// systemdUnits renders the service unit, and a timer unit for a periodic
+// service
+func systemdUnits(spec serviceSpec) (service, timer string) {
+	words := []string{systemdQuote(spec.exe)}
+	for _, a := range spec.args {
+		words = append(words, systemdQuote(a))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\nDescription=profilesync %s\n\n[Service]\n", spec.args[0])
+	if spec.interval > 0 {
+		b.WriteString("Type=oneshot\n")
+	} else {
+		b.WriteString("Restart=on-failure\nRestartSec=30\n")
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(words, " "))
+	for _, kv := range spec.env {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(kv))
+	}
+	if spec.interval > 0 {
+		return b.String(), fmt.Sprintf("[Unit]\nDescription=Run profilesync %s every %s\n\n[Timer]\nOnStartupSec=2min\nOnUnitActiveSec=%ds\n\n[Install]\nWantedBy=timers.target\n",
+			spec.args[0], spec.interval, int(spec.interval.Seconds()))
+	}
+	b.WriteString("\n[Install]\nWantedBy=default.target\n")
+	return b.String(), ""
+}
+
+// launchdPlist renders the launchd agent
+func launchdPlist(spec serviceSpec) string {
+	agent := map[string]interface{}{
+		"Label":             serviceLabel,
+		"ProgramArguments":  append([]string{spec.exe}, spec.args...),
+		"RunAtLoad":         true,
+		"StandardOutPath":   spec.log,
+		"StandardErrorPath": spec.log,
+	}
+	if spec.interval > 0 {
+		agent["StartInterval"] = int(spec.interval.Seconds())
+	} else {
+		agent["KeepAlive"] = map[string]interface{}{"SuccessfulExit": false}
+	}
+	if len(spec.env) > 0 {
+		env := map[string]interface{}{}
+		for _, kv := range spec.env {
+			k, v, _ := strings.Cut(kv, "=")
+			env[k] = v
+		}
+		agent["EnvironmentVariables"] = env
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0">` + "\n")
+	formatPlistValue(&b, agent, "")
+	b.WriteString("</plist>\n")
+	return b.String()
+}
+
+// windowsQuote quotes a command line word the way Windows programs parse it
+func windowsQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// schtasksCreate returns the schtasks arguments registering the task
+func schtasksCreate(spec serviceSpec) []string {
+	words := []string{windowsQuote(spec.exe)}
+	for _, a := range spec.args {
+		words = append(words, windowsQuote(a))
+	}
+	args := []string{"/Create", "/F", "/TN", serviceName, "/TR", strings.Join(words, " ")}
+	minutes := int(spec.interval.Minutes())
+	switch {
+	case spec.interval == 0:
+		args = append(args, "/SC", "ONLOGON")
+	case minutes < 24*60:
+		args = append(args, "/SC", "MINUTE", "/MO", fmt.Sprint(minutes))
+	default:
+		args = append(args, "/SC", "DAILY", "/MO", fmt.Sprint(minutes/(24*60)))
+	}
+	return args
+}
+
+// launchdDomain is the launchd domain of the user's agents
+func launchdDomain() string {
+	return fmt.Sprintf("gui/%d", os.Getuid())
+}
+
+// runCommands runs commands in order, showing each, and stops at the first
+// failure unless it is allowed to fail
+func runCommands(commands [][]string, mayFail bool) error {
+	for _, c := range commands {
+		noticeColor.Printf("$ %s\n", strings.Join(c, " "))
+		cmd := exec.Command(c[0], c[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil && !mayFail {
+			return fmt.Errorf("%s: %w", c[0], err)
+		}
+	}
+	return nil
+}
+
+// installService writes and registers the service for this platform
+func installService(spec serviceSpec, dryRun bool) error {
+	type file struct{ path, content string }
+	var files []file
+	var commands [][]string
+	var stale []string
+
+	switch DetectPlatform() {
+	case "linux":
+		service, timer := systemdUnits(spec)
+		files = append(files, file{filepath.Join(systemdDir(), serviceName+".service"), service})
+		unit := serviceName + ".service"
+		if timer != "" {
+			files = append(files, file{filepath.Join(systemdDir(), serviceName+".timer"), timer})
+			unit = serviceName + ".timer"
+		} else {
+			// A timer left from an earlier install would start the daemon again
+			stale = append(stale, filepath.Join(systemdDir(), serviceName+".timer"))
+		}
+		commands = [][]string{{"systemctl", "--user", "daemon-reload"}, {"systemctl", "--user", "enable", "--now", unit}}
+	case "macos":
+		files = append(files, file{launchAgentPath(), launchdPlist(spec)})
+		commands = [][]string{{"launchctl", "bootstrap", launchdDomain(), launchAgentPath()}}
+	case "windows":
+		commands = [][]string{append([]string{"schtasks"}, schtasksCreate(spec)...)}
+	default:
+		return errors.New("services are not supported on this platform")
+	}
+
+	for _, f := range files {
+		if dryRun {
+			infoColor.Printf("Would write %s:\n", f.path)
+			fmt.Println(f.content)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			return err
+		}
+		successColor.Printf("📝 Wrote %s\n", f.path)
+	}
+	if dryRun {
+		for _, c := range commands {
+			infoColor.Printf("Would run: %s\n", strings.Join(c, " "))
+		}
+		return nil
+	}
+	for _, path := range stale {
+		os.Remove(path)
+	}
+	if DetectPlatform() == "macos" {
+		// Reinstalling replaces an agent already loaded
+		runCommands([][]string{{"launchctl", "bootout", launchdDomain() + "/" + serviceLabel}}, true)
+	}
+	if err := os.MkdirAll(logsDir(), 0700); err != nil {
+		return err
+	}
+	return runCommands(commands, false)
+}
+
+// uninstallService stops the service and removes what install wrote
+func uninstallService() error {
+	switch DetectPlatform() {
+	case "linux":
+		runCommands([][]string{
+			{"systemctl", "--user", "disable", "--now", serviceName + ".timer"},
+			{"systemctl", "--user", "disable", "--now", serviceName + ".service"},
+		}, true)
+		for _, ext := range []string{".service", ".timer"} {
+			if err := os.Remove(filepath.Join(systemdDir(), serviceName+ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		return runCommands([][]string{{"systemctl", "--user", "daemon-reload"}}, false)
+	case "macos":
+		runCommands([][]string{{"launchctl", "bootout", launchdDomain() + "/" + serviceLabel}}, true)
+		if err := os.Remove(launchAgentPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	case "windows":
+		return runCommands([][]string{{"schtasks", "/Delete", "/F", "/TN", serviceName}}, false)
+	}
+	return errors.New("services are not supported on this platform")
+}
+
+// serviceStatus shows what the service manager knows about the service
+func serviceStatus() error {
+	switch DetectPlatform() {
+	case "linux":
+		return runCommands([][]string{
+			{"systemctl", "--user", "--no-pager", "status", serviceName + ".service"},
+			{"systemctl", "--user", "--no-pager", "list-timers", serviceName + ".timer"},
+		}, true)
+	case "macos":
+		return runCommands([][]string{{"launchctl", "print", launchdDomain() + "/" + serviceLabel}}, false)
+	case "windows":
+		return runCommands([][]string{{"schtasks", "/Query", "/V", "/FO", "LIST", "/TN", serviceName}}, false)
+	}
+	return errors.New("services are not supported on this platform")
+}
+
+// runService handles `profilesync service install|uninstall|status`,
+// managing a systemd user unit, launchd agent or scheduled task that runs
+// profilesync at login or on a schedule
+func runService(args []string) {
+	if len(args) == 0 {
+		errorColor.Println("❌ Usage: profilesync service install|uninstall|status")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "install":
+		flags := newFlagSet("service install", "service install [flags] [COMMAND [ARGS...]]")
+		interval := flags.Duration("interval", 0, "Run the command this often (e.g. 1h) instead of keeping it running from login")
+		dryRun := flags.Bool("dry-run", false, "Show what would be written and registered without doing it")
+		flags.Parse(args[1:])
+
+		command := flags.Args()
+		if len(command) == 0 {
+			if *interval > 0 {
+				errorColor.Println("❌ --interval needs the command to run, e.g. sync --dry-run=false ssh://me@laptop")
+				os.Exit(2)
+			}
+			command = []string{"daemon"}
+		}
+		if *interval != 0 && *interval < time.Minute {
+			errorColor.Println("❌ Invalid --interval value:", *interval)
+			errorColor.Println("Must be at least 1m")
+			os.Exit(2)
+		}
+		exe, err := os.Executable()
+		if err == nil {
+			exe, err = filepath.EvalSymlinks(exe)
+		}
+		if err != nil {
+			errorColor.Println("❌", err)
+			os.Exit(1)
+		}
+		spec := serviceSpec{exe: exe, args: command, interval: *interval, env: serviceEnv(), log: filepath.Join(logsDir(), "service.log")}
+		if err := installService(spec, *dryRun); err != nil {
+			errorColor.Println("❌ Error installing the service:", err)
+			os.Exit(1)
+		}
+		if !*dryRun {
+			when := "at login"
+			if *interval > 0 {
+				when = "every " + interval.String()
+			}
+			successColor.Printf("✅ profilesync %s runs %s\n", strings.Join(command, " "), when)
+		}
+	case "uninstall":
+		if err := uninstallService(); err != nil {
+			errorColor.Println("❌ Error uninstalling the service:", err)
+			os.Exit(1)
+		}
+		successColor.Println("✅ Service removed")
+	case "status":
+		if err := serviceStatus(); err != nil {
+			errorColor.Println("❌", err)
+			os.Exit(1)
+		}
+	default:
+		errorColor.Printf("❌ Unknown service command: %s\n", args[0])
+		errorColor.Println("Must be one of: install, uninstall, status")
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,837 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// shellRCMappings are the rc files translated from, by shell
+var shellRCMappings = map[string]string{
+	"bash": "bash/.bashrc",
+	"zsh":  "zsh/.zshrc",
+	"fish": "fish/.config/fish/config.fish",
+}
+
+// shellTitles name the shells in descriptions
+var shellTitles = map[string]string{"bash": "Bash", "zsh": "Zsh", "fish": "Fish"}
+
+// shellSpecific are commands that only mean something to one shell
+var shellSpecific = map[string][]string{
+	"bash": {"shopt", "bind", "complete", "compgen", "declare", "mapfile", "readarray"},
+	"zsh":  {"setopt", "unsetopt", "autoload", "zstyle", "bindkey", "compdef", "compinit", "zmodload", "zle", "typeset", "path", "fpath"},
+}
+
+// shellPromptVars hold prompts, whose escapes differ between every shell
+var shellPromptVars = map[string]bool{"PS1": true, "PS2": true, "PROMPT": true, "RPROMPT": true, "PROMPT_COMMAND": true}
+
+// shellTranslateMapping is the mapping of the translated rc file item
+const shellTranslateMapping = "shell/translate"
+
+// shellManualMarker starts every line left for translating by hand
+const shellManualMarker = "# profilesync: translate by hand: "
+
+// ShellNote is a construct the translation could not convert
+type ShellNote struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Text   string `json:"text"`
+	Reason string `json:"reason"`
+}
+
+// parseShellTranslation splits a --translate-shell value such as "bash:fish"
+func parseShellTranslation(value string) (string, string, error) {
+	from, to, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", "", fmt.Errorf("expected FROM:TO such as bash:fish, got %q", value)
+	}
+	for _, shell := range []string{from, to} {
+		if _, known := shellRCMappings[shell]; !known {
+			return "", "", fmt.Errorf("unknown shell %q (bash, zsh or fish)", shell)
+		}
+	}
+	if from == to {
+		return "", "", fmt.Errorf("nothing to translate from %s to itself", from)
+	}
+	return from, to, nil
+}
+
+// translatedRCMapping is where the translation goes. fish loads conf.d by
+// itself; bash and zsh need a line in their rc file to source it
+func translatedRCMapping(from, to string) string {
+	switch to {
+	case "fish":
+		return "fish/.config/fish/conf.d/profilesync-from-" + from + ".fish"
+	case "zsh":
+		return "zsh/.profilesync-from-" + from + ".zsh"
+	default:
+		return "bash/.profilesync-from-" + from + ".sh"
+	}
+}
+
+// planShellTranslation adds the translated rc file to the plan and records
+// what needs translating by hand, so dry runs show it too
+func (ps *ProfileSync) planShellTranslation(sourceBase, destBase string) error {
+	from, to, err := parseShellTranslation(ps.translateShell)
+	if err != nil {
+		return err
+	}
+	destRel := translatedRCMapping(from, to)
+	sourcePath, err := resolveMappingPath(sourceBase, shellRCMappings[from], ps.sourcePlatform)
+	if err != nil {
+		return err
+	}
+	destPath, err := resolveMappingPath(destBase, destRel, ps.destPlatform)
+	if err != nil {
+		return err
+	}
+
+	ps.migrationPlan.Items = append(ps.migrationPlan.Items, MigrationItem{
+		ID:              itemID(shellTranslateMapping, destRel, sourcePath, destPath),
+		Mapping:         shellTranslateMapping,
+		SourcePath:      sourcePath,
+		DestinationPath: destPath,
+		Type:            "Shell",
+		Description:     fmt.Sprintf("%s configuration translated to %s", shellTitles[from], to),
+		AutoMigrate:     true,
+		App:             appForMapping(destRel),
+	})
+	ps.migrationPlan.TotalItems++
+
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		// A missing rc file is skipped like any other missing source
+		return nil
+	}
+	_, notes := translateShellRC(data, from, to)
+	for i := range notes {
+		notes[i].File = sourcePath
+	}
+	ps.migrationPlan.ShellNotes = notes
+	return nil
+}
+
+// copyShellTranslation writes the source rc file translated for the
+// destination shell
+func (ps *ProfileSync) copyShellTranslation(ctx context.Context, item MigrationItem) error {
+	from, to, err := parseShellTranslation(ps.translateShell)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(item.SourcePath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(item.SourcePath)
+	if err != nil {
+		return err
+	}
+
+	body, _ := translateShellRC(data, from, to)
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# Translated from %s by profilesync\n", filepath.Base(item.SourcePath))
+	fmt.Fprintf(&out, "# Lines marked %q were left as comments\n\n", strings.TrimSuffix(strings.TrimPrefix(shellManualMarker, "# "), ": "))
+	out.Write(body)
+
+	if err := os.MkdirAll(filepath.Dir(item.DestinationPath), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(item.DestinationPath, out.Bytes(), info.Mode().Perm())
+}
+
+// printShellNotes lists what the shell translation left for the user
+func (ps *ProfileSync) printShellNotes() {
+	notes := ps.migrationPlan.ShellNotes
+	if len(notes) == 0 {
+		return
+	}
+	infoColor.Println("🐚 Shell translation:")
+	for _, n := range notes {
+		warnColor.Printf("  • line %d: %s (%s)\n", n.Line, n.Text, n.Reason)
+	}
+	warnColor.Printf("⚠️  %d constructs were commented out and need translating by hand\n", len(notes))
+	infoColor.Println(strings.Repeat("=", 60))
+}
+
+// shellTranslator converts an rc file line by line
+type shellTranslator struct {
+	from, to string
+	out      bytes.Buffer
+	notes    []ShellNote
+
+	// depth counts the blocks open while a block is being commented out
+	depth      int
+	awaitBrace bool
+}
+
+// translateShellRC converts aliases, exports, PATH additions and other
+// variables between shells. Everything else is kept when it works in both
+// shells and commented out, with a note, when it does not
+func translateShellRC(data []byte, from, to string) ([]byte, []ShellNote) {
+	t := &shellTranslator{from: from, to: to}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	for i := 0; i < len(lines); i++ {
+		// Join continuation lines into one statement, keeping the original
+		// lines for anything passed through or commented out
+		number, raw, stmt := i+1, lines[i], lines[i]
+		for strings.HasSuffix(stmt, `\`) && i+1 < len(lines) {
+			i++
+			raw += "\n" + lines[i]
+			stmt = strings.TrimSuffix(stmt, `\`) + lines[i]
+		}
+		t.line(number, raw, stmt)
+	}
+	return t.out.Bytes(), t.notes
+}
+
+// line translates one statement, given as written and with continuation
+// lines joined
+func (t *shellTranslator) line(number int, raw, stmt string) {
+	trimmed := strings.TrimSpace(stmt)
+	if t.depth > 0 || t.awaitBrace {
+		t.updateDepth(trimmed)
+		t.manual(raw)
+		return
+	}
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		t.out.WriteString(raw + "\n")
+		return
+	}
+
+	if t.updateDepth(trimmed); t.depth > 0 || t.awaitBrace {
+		t.note(number, trimmed, blockReason(trimmed))
+		t.manual(raw)
+		return
+	}
+
+	var translated string
+	var reason string
+	if t.from == "fish" {
+		translated, reason = t.fromFish(trimmed)
+	} else {
+		translated, reason = t.fromPOSIX(trimmed)
+	}
+	switch {
+	case reason != "":
+		t.note(number, trimmed, reason)
+		t.manual(raw)
+	case translated == trimmed:
+		t.out.WriteString(raw + "\n")
+	default:
+		t.out.WriteString(translated + "\n")
+	}
+}
+
+// note records a construct left for translating by hand
+func (t *shellTranslator) note(number int, text, reason string) {
+	t.notes = append(t.notes, ShellNote{Line: number, Text: text, Reason: reason})
+}
+
+// manual comments out a statement
+func (t *shellTranslator) manual(line string) {
+	for _, l := range strings.Split(line, "\n") {
+		t.out.WriteString(shellManualMarker + l + "\n")
+	}
+}
+
+// blockReason names the kind of block a statement opens
+func blockReason(trimmed string) string {
+	if posixFunctionHeader.MatchString(trimmed) {
+		return "function"
+	}
+	return strings.Fields(trimmed)[0] + " block"
+}
+
+var posixFunctionHeader = regexp.MustCompile(`^(function\s+[\w-]+|[\w-]+\s*\(\))`)
+
+// updateDepth tracks if/for/while/case blocks and functions, which are only
+// translated between bash and zsh
+func (t *shellTranslator) updateDepth(trimmed string) {
+	if t.from != "fish" && t.to != "fish" {
+		return
+	}
+	if t.from == "fish" {
+		for _, command := range strings.Split(trimmed, ";") {
+			fields := strings.Fields(command)
+			for len(fields) > 0 && (fields[0] == "and" || fields[0] == "or" || fields[0] == "not") {
+				fields = fields[1:]
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			switch fields[0] {
+			case "if", "for", "while", "function", "switch", "begin":
+				t.depth++
+			case "end":
+				t.depth--
+			}
+		}
+		return
+	}
+
+	if t.depth == 0 && !t.awaitBrace && posixFunctionHeader.MatchString(trimmed) && !strings.Contains(trimmed, "{") {
+		t.awaitBrace = true
+		return
+	}
+	for _, word := range strings.Fields(strings.NewReplacer(";", " ", "&&", " ", "||", " ").Replace(trimmed)) {
+		switch word {
+		case "if", "for", "while", "until", "case", "select":
+			t.depth++
+		case "{":
+			if t.awaitBrace {
+				t.awaitBrace = false
+			}
+			t.depth++
+		case "fi", "done", "esac", "}":
+			t.depth--
+		}
+	}
+	if t.depth < 0 {
+		t.depth = 0
+	}
+}
+
+var shellEvalInit = regexp.MustCompile(`^eval\s+"\$\((.+)\)"$`)
+var shellFishSource = regexp.MustCompile(`^(.+?)\s*\|\s*source$`)
+var shellAssignment = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// fromPOSIX translates a bash or zsh statement
+func (t *shellTranslator) fromPOSIX(stmt string) (string, string) {
+	if m := shellEvalInit.FindStringSubmatch(stmt); m != nil {
+		return t.initHook(m[1]), ""
+	}
+	words, ok := splitShellWords(stmt)
+	if !ok {
+		return "", "unbalanced quotes"
+	}
+	for i, word := range words {
+		if i == 0 || words[i-1] == "&&" || words[i-1] == "||" || words[i-1] == "then" || strings.HasSuffix(words[i-1], ";") {
+			if reason := t.unsupported(word); reason != "" {
+				return "", reason
+			}
+		}
+	}
+
+	// bash and zsh share the rest of their syntax; only check for what
+	// belongs to one of them
+	if t.to != "fish" {
+		if words[0] == "export" || shellAssignment.MatchString(words[0]) {
+			for _, word := range words {
+				if m := shellAssignment.FindStringSubmatch(word); m != nil && shellPromptVars[m[1]] {
+					return "", "prompt escapes differ between shells"
+				}
+			}
+		}
+		return stmt, ""
+	}
+
+	switch {
+	case words[0] == "alias":
+		if len(words) != 2 || strings.HasPrefix(words[1], "-") {
+			return "", "alias with options or several aliases"
+		}
+		name, value, ok := strings.Cut(words[1], "=")
+		if !ok {
+			return "", "alias without a value"
+		}
+		v, reason := parsePOSIXWord(value)
+		if reason != "" {
+			return "", reason
+		}
+		rendered, reason := renderFishWord(v)
+		if reason != "" {
+			return "", reason
+		}
+		return "alias " + name + " " + rendered, ""
+
+	case words[0] == "export" || shellAssignment.MatchString(words[0]):
+		export := words[0] == "export"
+		if export {
+			words = words[1:]
+		}
+		if len(words) == 0 {
+			return "", "export without variables"
+		}
+		var lines []string
+		for _, word := range words {
+			m := shellAssignment.FindStringSubmatch(word)
+			if m == nil {
+				if export && shellVariableName.MatchString(word) {
+					lines = append(lines, fmt.Sprintf("set -gx %s $%s", word, word))
+					continue
+				}
+				return "", "command or export option"
+			}
+			if shellPromptVars[m[1]] {
+				return "", "prompt escapes differ between shells"
+			}
+			value, reason := parsePOSIXWord(m[2])
+			if reason != "" {
+				return "", reason
+			}
+			line, reason := t.fishSet(m[1], value, export || strings.HasSuffix(m[1], "PATH"))
+			if reason != "" {
+				return "", reason
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n"), ""
+
+	case words[0] == "unset" && len(words) > 1:
+		return "set -e " + strings.Join(words[1:], " "), ""
+	}
+	return "", "not a simple alias, export or PATH addition"
+}
+
+// fishSet renders a variable assignment in fish, turning PATH-like values
+// into lists and PATH additions into fish_add_path
+func (t *shellTranslator) fishSet(name, value string, export bool) (string, string) {
+	scope := "-g"
+	if export {
+		scope = "-gx"
+	}
+	if !strings.HasSuffix(name, "PATH") {
+		rendered, reason := renderFishWord(value)
+		if reason != "" {
+			return "", reason
+		}
+		return fmt.Sprintf("set %s %s %s", scope, name, rendered), ""
+	}
+
+	self := map[string]bool{"$" + name: true, "${" + name + "}": true}
+	var before, after, entries []string
+	seenSelf := false
+	for _, entry := range strings.Split(value, ":") {
+		if entry == "" {
+			continue
+		}
+		if self[entry] {
+			seenSelf = true
+			continue
+		}
+		rendered, reason := renderFishWord(entry)
+		if reason != "" {
+			return "", reason
+		}
+		entries = append(entries, rendered)
+		if seenSelf {
+			after = append(after, rendered)
+		} else {
+			before = append(before, rendered)
+		}
+	}
+
+	switch {
+	case name == "PATH" && seenSelf:
+		var lines []string
+		if len(before) > 0 {
+			lines = append(lines, "fish_add_path --global --move "+strings.Join(before, " "))
+		}
+		if len(after) > 0 {
+			lines = append(lines, "fish_add_path --global --append "+strings.Join(after, " "))
+		}
+		return strings.Join(lines, "\n"), ""
+	case seenSelf:
+		return fmt.Sprintf("set %s %s %s $%s %s", scope, name, strings.Join(before, " "), name, strings.Join(after, " ")), ""
+	default:
+		return fmt.Sprintf("set %s %s %s", scope, name, strings.Join(entries, " ")), ""
+	}
+}
+
+var fishSetFlags = map[string]bool{
+	"-g": true, "-x": true, "-gx": true, "-xg": true, "-U": true, "-Ux": true, "-xU": true,
+	"--global": true, "--export": true, "--universal": true,
+}
+
+// fromFish translates a fish statement into bash or zsh
+func (t *shellTranslator) fromFish(stmt string) (string, string) {
+	if m := shellFishSource.FindStringSubmatch(stmt); m != nil {
+		return t.initHook(m[1]), ""
+	}
+	words, ok := splitShellWords(stmt)
+	if !ok {
+		return "", "unbalanced quotes"
+	}
+
+	switch words[0] {
+	case "alias", "abbr":
+		args := words[1:]
+		if words[0] == "abbr" {
+			if len(args) == 0 || (args[0] != "-a" && args[0] != "--add") {
+				return "", "abbreviation option"
+			}
+			args = args[1:]
+		}
+		var name string
+		var raws []string
+		switch {
+		case len(args) == 1 && strings.Contains(args[0], "="):
+			var raw string
+			name, raw, _ = strings.Cut(args[0], "=")
+			raws = []string{raw}
+		case len(args) == 2 || (words[0] == "abbr" && len(args) > 2):
+			// An abbreviation expands to all the words after its name
+			name, raws = args[0], args[1:]
+		default:
+			return "", "alias with options"
+		}
+		values := make([]string, len(raws))
+		for i, raw := range raws {
+			value, reason := parseFishWord(raw)
+			if reason != "" {
+				return "", reason
+			}
+			values[i] = value
+		}
+		return "alias " + name + "=" + renderPOSIXWord(strings.Join(values, " "), true), ""
+
+	case "set":
+		args := words[1:]
+		export := false
+		for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+			switch {
+			case args[0] == "-e" || args[0] == "--erase":
+				if len(args) < 2 {
+					return "", "set option"
+				}
+				return "unset " + strings.Join(args[1:], " "), ""
+			case !fishSetFlags[args[0]]:
+				return "", "set option"
+			}
+			export = export || strings.Contains(args[0], "x") || args[0] == "--export"
+			args = args[1:]
+		}
+		if len(args) < 2 {
+			return "", "set without a value"
+		}
+		name := args[0]
+		if shellPromptVars[name] {
+			return "", "prompt escapes differ between shells"
+		}
+		values := make([]string, 0, len(args)-1)
+		for _, raw := range args[1:] {
+			value, reason := parseFishWord(raw)
+			if reason != "" {
+				return "", reason
+			}
+			values = append(values, value)
+		}
+		sep := " "
+		if strings.HasSuffix(name, "PATH") {
+			sep = ":"
+			export = export || name == "PATH"
+		}
+		line := name + "=" + renderPOSIXWord(strings.Join(values, sep), false)
+		if export {
+			line = "export " + line
+		}
+		return line, ""
+
+	case "fish_add_path":
+		appendPath := false
+		var paths []string
+		for _, raw := range words[1:] {
+			switch raw {
+			case "-a", "--append":
+				appendPath = true
+			case "-p", "--prepend", "-g", "--global", "-U", "--universal", "-m", "--move", "-P", "--path":
+			default:
+				if strings.HasPrefix(raw, "-") {
+					return "", "fish_add_path option"
+				}
+				value, reason := parseFishWord(raw)
+				if reason != "" {
+					return "", reason
+				}
+				paths = append(paths, value)
+			}
+		}
+		if len(paths) == 0 {
+			return "", "fish_add_path without paths"
+		}
+		if appendPath {
+			paths = append([]string{"$PATH"}, paths...)
+		} else {
+			paths = append(paths, "$PATH")
+		}
+		return "export PATH=" + renderPOSIXWord(strings.Join(paths, ":"), false), ""
+	}
+	return "", "not a simple alias, set or fish_add_path"
+}
+
+// initHook translates the common `eval "$(tool init bash)"` and
+// `tool init fish | source` lines, asking the tool for the destination shell
+func (t *shellTranslator) initHook(command string) string {
+	command = regexp.MustCompile(`\b`+t.from+`\b`).ReplaceAllString(command, t.to)
+	if t.to == "fish" {
+		return command + " | source"
+	}
+	return `eval "$(` + command + `)"`
+}
+
+// unsupported explains why a bash or zsh command cannot be carried over
+func (t *shellTranslator) unsupported(command string) string {
+	if command == "source" || command == "." {
+		return "sources a " + t.from + " script"
+	}
+	for _, specific := range shellSpecific[t.from] {
+		if command == specific {
+			return command + " is " + t.from + "-specific"
+		}
+	}
+	return ""
+}
+
+// splitShellWords splits a statement into words, keeping their quotes and
+// command substitutions
+func splitShellWords(s string) ([]string, bool) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+	parens := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			word.WriteByte(c)
+			if c == '\\' && quote == '"' && i+1 < len(s) {
+				i++
+				word.WriteByte(s[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\\' && i+1 < len(s):
+			word.WriteByte(c)
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		case c == '\'' || c == '"':
+			quote = c
+			word.WriteByte(c)
+			inWord = true
+		case c == '(' || c == ')':
+			if c == '(' {
+				parens++
+			} else {
+				parens--
+			}
+			word.WriteByte(c)
+			inWord = true
+		case parens > 0:
+			word.WriteByte(c)
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, quote == 0 && parens == 0 && len(words) > 0
+}
+
+// Words are translated through a neutral form: the body of a POSIX
+// double-quoted string, where `$NAME`, `${NAME}` and `$(…)` expand and `\$`,
+// `\"`, `\\` and "\`" are literal, with a leading ~ meaning home
+
+// neutralEscaper escapes literal text for the neutral form
+var neutralEscaper = strings.NewReplacer(`\`, `\\`, `$`, `\$`, `"`, `\"`, "`", "\\`")
+
+// parsePOSIXWord converts a bash or zsh word to the neutral form
+func parsePOSIXWord(raw string) (string, string) {
+	var out strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch c {
+		case '\'':
+			end := strings.IndexByte(raw[i+1:], '\'')
+			if end < 0 {
+				return "", "unbalanced quotes"
+			}
+			out.WriteString(neutralEscaper.Replace(raw[i+1 : i+1+end]))
+			i += end + 1
+		case '"':
+			j := i + 1
+			for ; j < len(raw) && raw[j] != '"'; j++ {
+				if raw[j] == '\\' {
+					j++
+				}
+			}
+			out.WriteString(raw[i+1 : j])
+			i = j
+		case '\\':
+			if i+1 < len(raw) {
+				i++
+				out.WriteString(neutralEscaper.Replace(raw[i : i+1]))
+			}
+		case '`':
+			return "", "backtick command substitution"
+		case '*', '?', '[':
+			return "", "glob pattern"
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), ""
+}
+
+// parseFishWord converts a fish word to the neutral form
+func parseFishWord(raw string) (string, string) {
+	var out strings.Builder
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '\'':
+			j := i + 1
+			var literal strings.Builder
+			for ; j < len(raw) && raw[j] != '\''; j++ {
+				if raw[j] == '\\' && j+1 < len(raw) && (raw[j+1] == '\'' || raw[j+1] == '\\') {
+					j++
+				}
+				literal.WriteByte(raw[j])
+			}
+			out.WriteString(neutralEscaper.Replace(literal.String()))
+			i = j
+		case c == '"':
+			j := i + 1
+			for ; j < len(raw) && raw[j] != '"'; j++ {
+				if raw[j] == '\\' {
+					out.WriteByte(raw[j])
+					j++
+				}
+				if raw[j] == '`' {
+					out.WriteByte('\\')
+				}
+				out.WriteByte(raw[j])
+			}
+			i = j
+		case c == '\\' && i+1 < len(raw):
+			i++
+			out.WriteString(neutralEscaper.Replace(raw[i : i+1]))
+		case strings.HasPrefix(raw[i:], "{$"):
+			end := strings.IndexByte(raw[i:], '}')
+			if end < 0 {
+				return "", "brace expansion"
+			}
+			out.WriteString("${" + raw[i+2:i+end] + "}")
+			i += end
+		case c == '$' && i+1 < len(raw) && raw[i+1] != '(':
+			j := i + 1
+			for j < len(raw) && (raw[j] == '_' || isAlnum(raw[j])) {
+				j++
+			}
+			if j < len(raw) && raw[j] == '[' {
+				return "", "list index"
+			}
+			out.WriteString(raw[i:j])
+			i = j - 1
+		case c == '(' || (c == '$' && i+1 < len(raw)):
+			if c == '$' {
+				i++
+			}
+			depth, j := 0, i
+			for ; j < len(raw); j++ {
+				if raw[j] == '(' {
+					depth++
+				} else if raw[j] == ')' {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+			if j == len(raw) {
+				return "", "unbalanced command substitution"
+			}
+			out.WriteString("$" + raw[i:j+1])
+			i = j
+		case c == '{' || c == '*' || c == '?':
+			return "", "brace expansion or glob"
+		case c == '`':
+			out.WriteString("\\`")
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), ""
+}
+
+// isAlnum reports whether c is an ASCII letter or digit
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+var shellBareWord = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]+$`)
+var neutralBraced = regexp.MustCompile(`\$\{([^}]*)\}`)
+var shellVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// renderPOSIXWord renders a neutral word for bash or zsh, single-quoted when
+// preferred and nothing in it expands
+func renderPOSIXWord(value string, preferSingle bool) string {
+	tilde := ""
+	if value == "~" || strings.HasPrefix(value, "~/") {
+		tilde, value = "~", value[1:]
+	}
+	switch {
+	case value == "" && tilde != "":
+		return tilde
+	case shellBareWord.MatchString(value):
+		return tilde + value
+	case tilde != "":
+		return `"$HOME` + value + `"`
+	case preferSingle && tilde == "" && !strings.ContainsAny(value, "$\\`"):
+		return "'" + strings.ReplaceAll(strings.ReplaceAll(value, `\"`, `"`), "'", `'\''`) + "'"
+	}
+	return tilde + `"` + value + `"`
+}
+
+// renderFishWord renders a neutral word for fish, reporting what the POSIX
+// shells expand but fish does not
+func renderFishWord(value string) (string, string) {
+	tilde := ""
+	if value == "~" || strings.HasPrefix(value, "~/") {
+		tilde, value = "~", value[1:]
+	}
+
+	// fish has no ${NAME}; "$NAME" works unless a name character follows,
+	// where closing and reopening the quotes keeps them apart
+	var out strings.Builder
+	last := 0
+	for _, m := range neutralBraced.FindAllStringSubmatchIndex(value, -1) {
+		name := value[m[2]:m[3]]
+		if !shellVariableName.MatchString(name) {
+			return "", "parameter expansion ${" + name + "}"
+		}
+		out.WriteString(value[last:m[0]] + "$" + name)
+		if m[1] < len(value) && (value[m[1]] == '_' || isAlnum(value[m[1]])) {
+			out.WriteString(`""`)
+		}
+		last = m[1]
+	}
+	out.WriteString(value[last:])
+	value = strings.ReplaceAll(out.String(), "\\`", "`")
+
+	switch {
+	case value == "" && tilde != "":
+		return tilde, ""
+	case shellBareWord.MatchString(value):
+		return tilde + value, ""
+	case !strings.ContainsAny(value, "$\\"):
+		return tilde + "'" + strings.ReplaceAll(value, "'", `\'`) + "'", ""
+	}
+	return tilde + `"` + value + `"`, ""
+}
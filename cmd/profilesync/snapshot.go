@@ -0,0 +1,640 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshots kept unless the config says otherwise
+const (
+	defaultKeepLast  = 10
+	defaultKeepDaily = 30
+)
+
+// snapshotsDir holds one manifest per snapshot. The content of the files
+// they refer to is kept in the object store
+func snapshotsDir() string {
+	return filepath.Join(stateDir(), "snapshots")
+}
+
+// SnapshotRetention is how many snapshots are kept when old ones are
+// pruned. The newest snapshot is always kept
+type SnapshotRetention struct {
+	// KeepLast keeps the most recent snapshots (default 10)
+	KeepLast *int `yaml:"keep-last"`
+	// KeepDaily keeps the newest snapshot of each of the last days (default 30)
+	KeepDaily *int `yaml:"keep-daily"`
+	// KeepWeekly keeps the newest snapshot of each of the last weeks
+	KeepWeekly *int `yaml:"keep-weekly"`
+}
+
+// merge returns r with the limits set in override replaced
+func (r SnapshotRetention) merge(override SnapshotRetention) SnapshotRetention {
+	if override.KeepLast != nil {
+		r.KeepLast = override.KeepLast
+	}
+	if override.KeepDaily != nil {
+		r.KeepDaily = override.KeepDaily
+	}
+	if override.KeepWeekly != nil {
+		r.KeepWeekly = override.KeepWeekly
+	}
+	return r
+}
+
+// limits returns the retention limits with defaults filled in
+func (r SnapshotRetention) limits() (last, daily, weekly int) {
+	last, daily = defaultKeepLast, defaultKeepDaily
+	if r.KeepLast != nil {
+		last = *r.KeepLast
+	}
+	if r.KeepDaily != nil {
+		daily = *r.KeepDaily
+	}
+	if r.KeepWeekly != nil {
+		weekly = *r.KeepWeekly
+	}
+	return last, daily, weekly
+}
+
+// Snapshot records the managed files of this machine at one moment
+type Snapshot struct {
+	ID       string     `json:"id"`
+	Created  time.Time  `json:"created"`
+	Machine  string     `json:"machine"`
+	Platform string     `json:"platform"`
+	Message  string     `json:"message,omitempty"`
+	Files    []syncFile `json:"files"`
+}
+
+// loadSnapshots reads every snapshot, newest first
+func loadSnapshots() ([]*Snapshot, error) {
+	entries, err := os.ReadDir(snapshotsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var snapshots []*Snapshot
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(snapshotsDir(), e.Name())
+		data, err := readStateFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var s Snapshot
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("corrupt snapshot %s: %w", path, err)
+		}
+		snapshots = append(snapshots, &s)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Created.After(snapshots[j].Created) })
+	return snapshots, nil
+}
+
+// save writes the snapshot's manifest
+func (s *Snapshot) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeStateFile(filepath.Join(snapshotsDir(), s.ID+".json"), data, 0600)
+}
+
+// findSnapshot looks a snapshot up by ID, unique ID prefix, or "latest"
+func findSnapshot(snapshots []*Snapshot, ref string) (*Snapshot, error) {
+	if ref == "latest" && len(snapshots) > 0 {
+		return snapshots[0], nil
+	}
+	var found []*Snapshot
+	for _, s := range snapshots {
+		if s.ID == ref {
+			return s, nil
+		}
+		if strings.HasPrefix(s.ID, ref) {
+			found = append(found, s)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no snapshot %q (see `profilesync snapshot list`)", ref)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("%q matches %d snapshots; give more of the ID", ref, len(found))
+}
+
+// snapshotEndpoint is the managed files of this machine
+func snapshotEndpoint() *syncEndpoint {
+	platform := DetectPlatform()
+	return &syncEndpoint{home: GetHomeDir(platform), platform: platform}
+}
+
+// sortedMappings returns the names of the mappings in order
+func sortedMappings(mappings map[string]string) []string {
+	names := make([]string, 0, len(mappings))
+	for name := range mappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// listManaged lists the files of mappings, once each where mappings overlap
+func listManaged(e *syncEndpoint, mappings map[string]string) ([]syncFile, error) {
+	files, err := e.list(sortedMappings(mappings))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	unique := files[:0]
+	for _, f := range files {
+		path, err := e.path(f.Mapping, f.Rel)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[path] {
+			seen[path] = true
+			unique = append(unique, f)
+		}
+	}
+	return unique, nil
+}
+
+// takeSnapshot stores the current content of the files of mappings,
+// returning the snapshot and how many bytes were new to the object store
+func takeSnapshot(mappings map[string]string, message string) (*Snapshot, int64, error) {
+	e := snapshotEndpoint()
+	files, err := listManaged(e, mappings)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	store := openObjectStore()
+	var stored int64
+	for i, f := range files {
+		path, err := e.path(f.Mapping, f.Rel)
+		if err != nil {
+			return nil, 0, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		// The file may have changed since it was listed
+		hash, added, err := store.put(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		files[i].Hash = hash
+		stored += added
+	}
+
+	created := time.Now().UTC()
+	s := &Snapshot{
+		ID:       created.Format("20060102T150405Z"),
+		Created:  created,
+		Machine:  machineName(),
+		Platform: e.platform,
+		Message:  message,
+		Files:    files,
+	}
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(snapshotsDir(), s.ID+".json")); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		s.ID = fmt.Sprintf("%s-%d", created.Format("20060102T150405Z"), n)
+	}
+	return s, stored, s.save()
+}
+
+// keptSnapshots applies the retention policy to snapshots, newest first,
+// returning the IDs to keep
+func keptSnapshots(snapshots []*Snapshot, policy SnapshotRetention, now time.Time) map[string]bool {
+	last, daily, weekly := policy.limits()
+	keep := map[string]bool{}
+	days, weeks := map[string]bool{}, map[string]bool{}
+	for i, s := range snapshots {
+		local := s.Created.Local()
+		age := now.Sub(s.Created)
+		day := local.Format("2006-01-02")
+		year, week := local.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%d", year, week)
+		switch {
+		case i == 0, i < last:
+			keep[s.ID] = true
+		case age < time.Duration(daily)*24*time.Hour && !days[day]:
+			keep[s.ID] = true
+		case age < time.Duration(weekly)*7*24*time.Hour && !weeks[weekKey]:
+			keep[s.ID] = true
+		}
+		// The newest snapshot of a day or week stands for it, kept or not
+		days[day], weeks[weekKey] = true, true
+	}
+	return keep
+}
+
+// pruneSnapshots removes the snapshots the policy does not keep and the
+// stored content only they referred to, returning the bytes freed
+func pruneSnapshots(snapshots []*Snapshot, policy SnapshotRetention, dryRun bool) (removed []*Snapshot, freed int64, err error) {
+	keep := keptSnapshots(snapshots, policy, time.Now())
+	used := map[string]bool{}
+	for _, s := range snapshots {
+		if !keep[s.ID] {
+			removed = append(removed, s)
+			continue
+		}
+		for _, f := range s.Files {
+			used[f.Hash] = true
+		}
+	}
+	// Undo needs what the last apply replaced
+	for _, hash := range undoHashes() {
+		used[hash] = true
+	}
+	if dryRun || len(removed) == 0 {
+		return removed, 0, nil
+	}
+
+	for _, s := range removed {
+		if err := os.Remove(filepath.Join(snapshotsDir(), s.ID+".json")); err != nil {
+			return nil, 0, err
+		}
+	}
+	_, freed, err = openObjectStore().gc(used)
+	return removed, freed, err
+}
+
+// snapshotDiff is a file that differs between two sets of files
+type snapshotDiff struct {
+	key      string
+	old, new *syncFile
+}
+
+// diffFiles compares two sets of files by mapping and path
+func diffFiles(old, new []syncFile) []snapshotDiff {
+	byKey := map[string]*snapshotDiff{}
+	for i := range old {
+		byKey[old[i].key()] = &snapshotDiff{key: old[i].key(), old: &old[i]}
+	}
+	for i := range new {
+		if d, ok := byKey[new[i].key()]; ok {
+			d.new = &new[i]
+			continue
+		}
+		byKey[new[i].key()] = &snapshotDiff{key: new[i].key(), new: &new[i]}
+	}
+	var diffs []snapshotDiff
+	for _, d := range byKey {
+		if d.old == nil || d.new == nil || d.old.Hash != d.new.Hash {
+			diffs = append(diffs, *d)
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].key < diffs[j].key })
+	return diffs
+}
+
+// printLineDiff shows the lines that differ between two versions of a text
+// file, each run of changes headed by where it starts
+func printLineDiff(old, new []byte) {
+	lines, err := lineDiff(old, new)
+	if err != nil {
+		fmt.Printf("   (%v)\n", err)
+		return
+	}
+	for _, line := range lines {
+		switch line[0] {
+		case '@':
+			infoColor.Printf("   %s\n", line)
+		case '-':
+			errorColor.Printf("   %s\n", line)
+		default:
+			successColor.Printf("   %s\n", line)
+		}
+	}
+}
+
+// snapshotMappings reads the mappings and retention policy from the config
+func snapshotMappings(configFile, profile string) (map[string]string, SnapshotRetention, error) {
+	if configFile == "" {
+		if _, err := os.Stat(configPath()); err == nil {
+			configFile = configPath()
+		}
+	}
+	if configFile == "" {
+		return GetDefaultMappings(), SnapshotRetention{}, nil
+	}
+	cfg, err := loadConfig(configFile, profile)
+	if err != nil {
+		return nil, SnapshotRetention{}, err
+	}
+	return cfg.Mappings, cfg.Snapshots, nil
+}
+
+// runSnapshot handles `profilesync snapshot [create|list|diff|restore|prune]`
+func runSnapshot(args []string) {
+	sub := "create"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+
+	switch sub {
+	case "create":
+		flags := newFlagSet("snapshot", "snapshot [create] [flags]")
+		message := flags.String("message", "", "Note to keep with the snapshot")
+		configFile := flags.String("config", "", "Config file with the mappings to snapshot (default "+configPath()+" if it exists)")
+		profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config whose mappings to snapshot")
+		flags.Parse(args)
+
+		mappings, policy, err := snapshotMappings(*configFile, *profile)
+		if err != nil {
+			errorColor.Println("❌ Error loading config:", err)
+			os.Exit(1)
+		}
+		s, stored, err := takeSnapshot(mappings, *message)
+		if err != nil {
+			errorColor.Println("❌ Error taking snapshot:", err)
+			os.Exit(1)
+		}
+		logger().Info("snapshot created", "snapshot", s.ID, "files", len(s.Files), "bytes_stored", stored)
+		successColor.Printf("📸 Snapshot %s: %d files (%s new to the store)\n", s.ID, len(s.Files), formatSize(stored))
+
+		snapshots, err := loadSnapshots()
+		if err == nil {
+			var removed []*Snapshot
+			removed, _, err = pruneSnapshots(snapshots, policy, false)
+			if len(removed) > 0 {
+				noticeColor.Printf("🧹 Pruned %d old snapshots\n", len(removed))
+			}
+		}
+		if err != nil {
+			warnColor.Println("⚠️  Could not prune old snapshots:", err)
+		}
+
+	case "list":
+		flags := newFlagSet("snapshot list", "snapshot list")
+		flags.Parse(args)
+		snapshots, err := loadSnapshots()
+		if err != nil {
+			errorColor.Println("❌ Error reading snapshots:", err)
+			os.Exit(1)
+		}
+		if len(snapshots) == 0 {
+			fmt.Println("No snapshots yet. Take one with `profilesync snapshot`.")
+			return
+		}
+		for _, s := range snapshots {
+			infoColor.Printf("%-20s", s.ID)
+			fmt.Printf("  %s  %5d files", s.Created.Local().Format("2006-01-02 15:04"), len(s.Files))
+			if s.Message != "" {
+				fmt.Printf("  %s", s.Message)
+			}
+			fmt.Println()
+		}
+
+	case "diff":
+		flags := newFlagSet("snapshot diff", "snapshot diff [flags] SNAPSHOT [SNAPSHOT]")
+		patch := flags.Bool("patch", false, "Show the changed lines of text files")
+		configFile := flags.String("config", "", "Config file with the current mappings (default "+configPath()+" if it exists)")
+		profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config whose mappings are current")
+		flags.Parse(args)
+		if flags.NArg() < 1 || flags.NArg() > 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		snapshots, err := loadSnapshots()
+		if err != nil {
+			errorColor.Println("❌ Error reading snapshots:", err)
+			os.Exit(1)
+		}
+		old, err := findSnapshot(snapshots, flags.Arg(0))
+		if err != nil {
+			errorColor.Printf("❌ %v\n", err)
+			os.Exit(2)
+		}
+
+		e := snapshotEndpoint()
+		var newFiles []syncFile
+		current := flags.NArg() == 1
+		if current {
+			// Compare with the files now, of the mappings of both the
+			// snapshot and the config
+			mappings, _, err := snapshotMappings(*configFile, *profile)
+			if err != nil {
+				errorColor.Println("❌ Error loading config:", err)
+				os.Exit(1)
+			}
+			for _, f := range old.Files {
+				mappings[f.Mapping] = ""
+			}
+			if newFiles, err = listManaged(e, mappings); err != nil {
+				errorColor.Println("❌ Error listing files:", err)
+				os.Exit(1)
+			}
+		} else {
+			s, err := findSnapshot(snapshots, flags.Arg(1))
+			if err != nil {
+				errorColor.Printf("❌ %v\n", err)
+				os.Exit(2)
+			}
+			newFiles = s.Files
+		}
+
+		diffs := diffFiles(old.Files, newFiles)
+		if len(diffs) == 0 {
+			successColor.Println("✅ No differences")
+			return
+		}
+		for _, d := range diffs {
+			f := d.new
+			if f == nil {
+				f = d.old
+			}
+			path, _ := e.path(f.Mapping, f.Rel)
+			switch {
+			case d.old == nil:
+				successColor.Printf("A %s\n", displayPath(path))
+			case d.new == nil:
+				errorColor.Printf("D %s\n", displayPath(path))
+			default:
+				warnColor.Printf("M %s\n", displayPath(path))
+			}
+			if !*patch {
+				continue
+			}
+			var before, after []byte
+			if d.old != nil {
+				if before, err = openObjectStore().get(d.old.Hash); err != nil {
+					errorColor.Printf("❌ %v\n", err)
+					continue
+				}
+			}
+			if d.new != nil {
+				if current {
+					after, err = os.ReadFile(path)
+				} else {
+					after, err = openObjectStore().get(d.new.Hash)
+				}
+				if err != nil {
+					errorColor.Printf("❌ %v\n", err)
+					continue
+				}
+			}
+			printLineDiff(before, after)
+		}
+
+	case "restore":
+		flags := newFlagSet("snapshot restore", "snapshot restore [flags] SNAPSHOT [PATH...]")
+		dryRun := flags.Bool("dry-run", true, "Show what would be restored without restoring it")
+		flags.Parse(args)
+		if flags.NArg() < 1 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		snapshots, err := loadSnapshots()
+		if err != nil {
+			errorColor.Println("❌ Error reading snapshots:", err)
+			os.Exit(1)
+		}
+		s, err := findSnapshot(snapshots, flags.Arg(0))
+		if err != nil {
+			errorColor.Printf("❌ %v\n", err)
+			os.Exit(2)
+		}
+		var filters []string
+		for _, arg := range flags.Args()[1:] {
+			abs, err := filepath.Abs(arg)
+			if err != nil {
+				errorColor.Printf("❌ %v\n", err)
+				os.Exit(2)
+			}
+			filters = append(filters, abs)
+		}
+
+		e := snapshotEndpoint()
+		type restore struct {
+			path string
+			file syncFile
+		}
+		var restores []restore
+		mappings := map[string]string{}
+		for _, f := range s.Files {
+			path, err := e.path(f.Mapping, f.Rel)
+			if err != nil {
+				errorColor.Printf("❌ Error resolving %s: %v\n", f.key(), err)
+				continue
+			}
+			matched := len(filters) == 0
+			for _, filter := range filters {
+				matched = matched || withinRoot(filter, path)
+			}
+			if hash, err := hashFile(path); !matched || (err == nil && hash == f.Hash) {
+				continue
+			}
+			restores = append(restores, restore{path, f})
+			mappings[f.Mapping] = ""
+		}
+		if len(restores) == 0 {
+			successColor.Printf("✅ Everything already matches snapshot %s\n", s.ID)
+			return
+		}
+		if *dryRun {
+			for _, r := range restores {
+				fmt.Printf("Would restore %s\n", displayPath(r.path))
+			}
+			noticeColor.Printf("%d files would be restored. Run with --dry-run=false to restore them.\n", len(restores))
+			return
+		}
+
+		// Restoring can be undone from the snapshot taken first
+		before, _, err := takeSnapshot(mappings, "before restoring "+s.ID)
+		if err != nil {
+			errorColor.Println("❌ Error taking a snapshot before restoring:", err)
+			os.Exit(1)
+		}
+		trail := newAuditTrail("restore", "")
+		restored := 0
+		for _, r := range restores {
+			trail.watch(r.path, r.file.Mapping)
+			data, err := openObjectStore().get(r.file.Hash)
+			if err == nil {
+				err = os.MkdirAll(filepath.Dir(r.path), 0755)
+			}
+			if err == nil {
+				tmp := tempPath(r.path)
+				if err = os.WriteFile(tmp, data, r.file.Mode|0600); err == nil {
+					err = os.Rename(tmp, r.path)
+				}
+			}
+			if err != nil {
+				logger().Error("file restore failed", "snapshot", s.ID, "path", r.path, "error", err)
+				errorColor.Printf("❌ Error restoring %s: %v\n", displayPath(r.path), err)
+				continue
+			}
+			logger().Info("file restored", "snapshot", s.ID, "path", r.path, "hash", r.file.Hash)
+			successColor.Printf("♻️  Restored %s\n", displayPath(r.path))
+			restored++
+		}
+		trail.flush()
+		noticeColor.Printf("%d files restored from %s. Undo with: profilesync snapshot restore --dry-run=false %s\n", restored, s.ID, before.ID)
+
+	case "prune":
+		flags := newFlagSet("snapshot prune", "snapshot prune [flags]")
+		dryRun := flags.Bool("dry-run", false, "List the snapshots that would be removed without removing them")
+		keepLast := flags.Int("keep-last", -1, "Keep the most recent snapshots (default from the config, or 10)")
+		keepDaily := flags.Int("keep-daily", -1, "Keep the newest snapshot of each of the last days (default from the config, or 30)")
+		keepWeekly := flags.Int("keep-weekly", -1, "Keep the newest snapshot of each of the last weeks (default from the config, or 0)")
+		configFile := flags.String("config", "", "Config file with the retention policy (default "+configPath()+" if it exists)")
+		profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config to load")
+		flags.Parse(args)
+
+		_, policy, err := snapshotMappings(*configFile, *profile)
+		if err != nil {
+			errorColor.Println("❌ Error loading config:", err)
+			os.Exit(1)
+		}
+		for _, limit := range []struct {
+			value *int
+			field **int
+		}{{keepLast, &policy.KeepLast}, {keepDaily, &policy.KeepDaily}, {keepWeekly, &policy.KeepWeekly}} {
+			if *limit.value >= 0 {
+				*limit.field = limit.value
+			}
+		}
+		snapshots, err := loadSnapshots()
+		if err != nil {
+			errorColor.Println("❌ Error reading snapshots:", err)
+			os.Exit(1)
+		}
+		removed, freed, err := pruneSnapshots(snapshots, policy, *dryRun)
+		if err != nil {
+			errorColor.Println("❌ Error pruning snapshots:", err)
+			os.Exit(1)
+		}
+		for _, s := range removed {
+			verb := "Removed"
+			if *dryRun {
+				verb = "Would remove"
+			}
+			logger().Info("snapshot pruned", "snapshot", s.ID, "dry_run", *dryRun)
+			fmt.Printf("%s snapshot %s (%s)\n", verb, s.ID, s.Created.Local().Format("2006-01-02 15:04"))
+		}
+		if !*dryRun {
+			noticeColor.Printf("%d snapshots removed, %d kept, %s freed\n", len(removed), len(snapshots)-len(removed), formatSize(freed))
+		}
+
+	default:
+		errorColor.Printf("❌ Unknown snapshot command: %s\n", sub)
+		errorColor.Println("Must be one of: create, list, diff, restore, prune")
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestKeptSnapshots(t *testing.T) {
+	// A Wednesday, so the week before starts within the last eight days
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.Local)
+	at := func(id string, ago time.Duration) *Snapshot { return &Snapshot{ID: id, Created: now.Add(-ago)} }
+	n := func(v int) *int { return &v }
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name      string
+		snapshots []*Snapshot
+		policy    SnapshotRetention
+		want      []string
+	}{
+		{
+			name:      "most recent",
+			snapshots: []*Snapshot{at("a", 0), at("b", time.Hour), at("c", 2*time.Hour), at("d", 3*time.Hour)},
+			policy:    SnapshotRetention{KeepLast: n(2), KeepDaily: n(0)},
+			want:      []string{"a", "b"},
+		},
+		{
+			name:      "newest always kept",
+			snapshots: []*Snapshot{at("a", 0), at("b", time.Hour)},
+			policy:    SnapshotRetention{KeepLast: n(0), KeepDaily: n(0)},
+			want:      []string{"a"},
+		},
+		{
+			name:      "newest of each day",
+			snapshots: []*Snapshot{at("a", 2*time.Hour), at("b", 3*time.Hour), at("c", day), at("d", day+4*time.Hour), at("e", 40*day)},
+			policy:    SnapshotRetention{KeepLast: n(1), KeepDaily: n(30)},
+			want:      []string{"a", "c"},
+		},
+		{
+			name:      "newest of each week",
+			snapshots: []*Snapshot{at("a", 0), at("b", 7*day), at("c", 8*day), at("d", 14*day), at("e", 100*day)},
+			policy:    SnapshotRetention{KeepLast: n(1), KeepDaily: n(0), KeepWeekly: n(8)},
+			want:      []string{"a", "b", "d"},
+		},
+		{
+			name:      "defaults",
+			snapshots: []*Snapshot{at("a", 0), at("b", 10*day), at("c", 40*day)},
+			want:      []string{"a", "b", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for id := range keptSnapshots(tt.snapshots, tt.policy, now) {
+				got = append(got, id)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSnapshot(t *testing.T) {
+	snapshots := []*Snapshot{{ID: "20261014T120000Z"}, {ID: "20261014T110000Z"}, {ID: "20261001T090000Z"}}
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "latest", want: "20261014T120000Z"},
+		{ref: "20261014T110000Z", want: "20261014T110000Z"},
+		{ref: "20261001", want: "20261001T090000Z"},
+		{ref: "20261014", wantErr: true},
+		{ref: "2025", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			s, err := findSnapshot(snapshots, tt.ref)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("findSnapshot(%q) = %s, want an error", tt.ref, s.ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.ID != tt.want {
+				t.Errorf("findSnapshot(%q) = %s, want %s", tt.ref, s.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	old := []syncFile{{Mapping: "a", Hash: "1"}, {Mapping: "b", Hash: "2"}, {Mapping: "c", Hash: "3"}}
+	new := []syncFile{{Mapping: "a", Hash: "1"}, {Mapping: "b", Hash: "4"}, {Mapping: "d", Hash: "5"}}
+	var got []string
+	for _, d := range diffFiles(old, new) {
+		switch {
+		case d.old == nil:
+			got = append(got, "added "+d.key)
+		case d.new == nil:
+			got = append(got, "removed "+d.key)
+		default:
+			got = append(got, "changed "+d.key)
+		}
+	}
+	if want := []string{"changed b", "removed c", "added d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("diffFiles = %v, want %v", got, want)
+	}
+}
+
+func TestSnapshotPrune(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("PROFILESYNC_STATE_DIR", t.TempDir())
+	mappings := map[string]string{".tmux.conf": ".tmux.conf", "${XDG_CONFIG_HOME}/nvim/": "${XDG_CONFIG_HOME}/nvim/"}
+	write := func(name, data string) {
+		path := filepath.Join(home, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".tmux.conf", "set -g mouse on\n")
+	write(".config/nvim/init.lua", "vim.o.number = true\n")
+
+	first, stored, err := takeSnapshot(mappings, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Files) != 2 || stored != int64(len("set -g mouse on\nvim.o.number = true\n")) {
+		t.Fatalf("first snapshot has %d files and stored %d bytes", len(first.Files), stored)
+	}
+
+	// Only the changed file is stored again
+	write(".tmux.conf", "set -g mouse off\n")
+	second, stored, err := takeSnapshot(mappings, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != int64(len("set -g mouse off\n")) {
+		t.Errorf("second snapshot stored %d bytes", stored)
+	}
+	if second.ID == first.ID {
+		t.Errorf("both snapshots have ID %s", first.ID)
+	}
+
+	snapshots, err := loadSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].ID != second.ID {
+		t.Fatalf("loadSnapshots returned %d snapshots", len(snapshots))
+	}
+	removed, freed, err := pruneSnapshots(snapshots, SnapshotRetention{KeepLast: new(int), KeepDaily: new(int)}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].ID != first.ID || freed == 0 {
+		t.Errorf("prune removed %d snapshots and freed %d bytes", len(removed), freed)
+	}
+
+	store := openObjectStore()
+	for _, f := range first.Files {
+		if f.Mapping == ".tmux.conf" && store.has(f.Hash) {
+			t.Error("content only the pruned snapshot had is still stored")
+		}
+	}
+	for _, f := range second.Files {
+		data, err := store.get(f.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, _ := snapshotEndpoint().path(f.Mapping, f.Rel)
+		if current, _ := os.ReadFile(path); string(current) != string(data) {
+			t.Errorf("kept snapshot has %q for %s, want %q", data, path, current)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// What to do when the destination lacks space for a migration
+const (
+	spaceCheckFail = "fail"
+	spaceCheckWarn = "warn"
+	spaceCheckOff  = "off"
+)
+
+// spaceShortfall is a destination filesystem too small for what a
+// migration copies onto it
+type spaceShortfall struct {
+	path  string // an existing directory on the filesystem
+	need  int64
+	free  int64
+	items int
+}
+
+// itemSize estimates how many bytes copying item copies: the size of a
+// file, or of the files in a directory that copying it keeps. It leaves out
+// what the item's handler, the ignore files and the symlink policy do
+func (ps *ProfileSync) itemSize(ctx context.Context, item MigrationItem) int64 {
+	if isSymlink(item.SourcePath) && item.Symlinks == symlinkPreserve {
+		return 0
+	}
+	root, exclude, err := ps.itemSource(item)
+	if err != nil {
+		return 0
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return 0
+	}
+	if !info.IsDir() {
+		return info.Size()
+	}
+	ignore, _ := ps.directoryIgnore(root)
+	var size atomic.Int64
+	parallelWalk(ctx, root, ps.jobs, func(path string, d fs.DirEntry) (bool, error) {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return false, nil
+		}
+		rel = filepath.ToSlash(rel)
+		if exclude(rel) || ignore.ignored(rel, d.IsDir()) {
+			return false, nil
+		}
+		mode := d.Type()
+		if mode&fs.ModeSymlink != 0 {
+			if item.Symlinks == symlinkPreserve || item.Symlinks == symlinkSkip {
+				return false, nil
+			}
+			info, _ := followLink(path)
+			if info == nil {
+				return false, nil
+			}
+			mode = info.Mode().Type()
+		}
+		if mode.IsDir() {
+			return true, nil
+		}
+		if isTempFile(path) || !mode.IsRegular() {
+			return false, nil
+		}
+		if info, err := os.Stat(path); err == nil {
+			size.Add(info.Size())
+		}
+		return false, nil
+	})
+	return size.Load()
+}
+
+// existingAncestor returns path or the nearest of its parents that exists
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// checkSpace adds up what the plan copies onto each destination filesystem
+// and returns those without room for it
+func (ps *ProfileSync) checkSpace(sizes []int64) ([]spaceShortfall, error) {
+	needs := map[string]*spaceShortfall{}
+	var order []string
+	for i, item := range ps.migrationPlan.Items {
+		if sizes[i] == 0 || !item.AutoMigrate || ps.checkpoint.completed(item.ID) {
+			continue
+		}
+		dir := existingAncestor(filepath.Dir(item.DestinationPath))
+		free, volume, err := diskSpace(dir)
+		if err != nil {
+			return nil, fmt.Errorf("checking free space on %s: %w", dir, err)
+		}
+		n, ok := needs[volume]
+		if !ok {
+			n = &spaceShortfall{path: dir, free: free}
+			needs[volume] = n
+			order = append(order, volume)
+		}
+		n.need += sizes[i]
+		n.items++
+	}
+
+	var short []spaceShortfall
+	for _, volume := range order {
+		if n := needs[volume]; n.need > n.free {
+			short = append(short, *n)
+		}
+	}
+	return short, nil
+}
+
+// preflightSpace reports destinations without room for the migration,
+// returning an error when the migration should not start
+func (ps *ProfileSync) preflightSpace(sizes []int64) error {
+	if ps.spaceCheck == spaceCheckOff {
+		return nil
+	}
+	short, err := ps.checkSpace(sizes)
+	if err != nil {
+		warnColor.Printf("⚠️  %v\n", err)
+		return nil
+	}
+	for _, s := range short {
+		warnColor.Printf("💾 Not enough space on the destination: %s needed for %d items, %s free on the filesystem of %s\n",
+			formatSize(s.need), s.items, formatSize(s.free), s.path)
+	}
+	if len(short) == 0 || ps.dryRun || ps.spaceCheck == spaceCheckWarn {
+		return nil
+	}
+	return errors.New("not enough space on the destination (free some space, leave items out, or use --space-check=warn)")
+}
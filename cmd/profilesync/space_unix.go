@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// diskSpace returns the bytes available to this user on the filesystem
+// holding path, and an identifier of that filesystem
+func diskSpace(path string) (int64, string, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return 0, "", err
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, "", err
+	}
+	return int64(fs.Bavail) * int64(fs.Bsize), fmt.Sprint(st.Dev), nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// diskSpace returns the bytes available to this user on the volume holding
+// path, and the volume's root
+func diskSpace(path string) (int64, string, error) {
+	p, err := windows.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return 0, "", err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, &totalFree); err != nil {
+		return 0, "", err
+	}
+	volume := path
+	buf := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumePathName(p, &buf[0], uint32(len(buf))); err == nil {
+		volume = windows.UTF16ToString(buf)
+	}
+	return int64(free), strings.ToLower(volume), nil
+}
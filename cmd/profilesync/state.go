@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// stateDir returns the directory where profilesync keeps its own local state
+func stateDir() string {
+	if dir := os.Getenv("PROFILESYNC_STATE_DIR"); dir != "" {
+		return dir
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, "profilesync")
+		}
+	default:
+		if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+			return filepath.Join(dir, "profilesync")
+		}
+	}
+
+	return filepath.Join(GetHomeDir(DetectPlatform()), ".local", "state", "profilesync")
+}
+
+// profileDir returns the default profile store, where captured settings live
+func profileDir() string {
+	if dir := os.Getenv("PROFILESYNC_PROFILE_DIR"); dir != "" {
+		return dir
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return filepath.Join(dir, "profilesync", "profile")
+		}
+	default:
+		if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+			return filepath.Join(dir, "profilesync", "profile")
+		}
+	}
+
+	return filepath.Join(GetHomeDir(DetectPlatform()), ".local", "share", "profilesync", "profile")
+}
+
+// configPath returns the config file used when --config is not given
+func configPath() string {
+	if path := os.Getenv("PROFILESYNC_CONFIG"); path != "" {
+		return path
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return filepath.Join(dir, "profilesync", "config.yaml")
+		}
+	default:
+		if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+			return filepath.Join(dir, "profilesync", "config.yaml")
+		}
+	}
+
+	return filepath.Join(GetHomeDir(DetectPlatform()), ".config", "profilesync", "config.yaml")
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// stowDefaultIgnore is what Stow ignores in a package without a
+// .stow-local-ignore or ~/.stow-global-ignore
+var stowDefaultIgnore = []string{
+	`RCS`, `.+,v`, `CVS`, `\.\#.+`, `\.cvsignore`, `\.svn`, `_darcs`, `\.hg`,
+	`\.git`, `\.gitignore`, `\.gitmodules`, `.+~`, `\#.*\#`,
+	`^/README.*`, `^/LICENSE.*`, `^/COPYING`,
+}
+
+// stowDir is where dotfiles managed with Stow are usually kept
+func stowDir(home string) string {
+	return filepath.Join(home, "dotfiles")
+}
+
+// stowOptions are the .stowrc settings that change what a package puts
+// in the home directory
+type stowOptions struct {
+	dotfiles bool
+	ignore   []string
+}
+
+// readStowrc reads the options Stow takes from .stowrc files, the one in
+// the Stow directory first and then the one in home
+func readStowrc(dir, home string) stowOptions {
+	var opts stowOptions
+	for _, path := range []string{filepath.Join(dir, ".stowrc"), filepath.Join(home, ".stowrc")} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, field := range strings.Fields(string(data)) {
+			switch {
+			case field == "--dotfiles":
+				opts.dotfiles = true
+			case strings.HasPrefix(field, "--ignore="):
+				opts.ignore = append(opts.ignore, strings.TrimPrefix(field, "--ignore="))
+			}
+		}
+	}
+	return opts
+}
+
+// readStowIgnore reads an ignore list: one regular expression per line,
+// with # starting a comment unless escaped
+func readStowIgnore(path string) ([]string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	comment := regexp.MustCompile(`(^|\s+)#.*$`)
+	var patterns []string
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(comment.ReplaceAllString(scanner.Text(), "")); line != "" {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns, true
+}
+
+// stowIgnore matches paths in a package the way Stow does: a pattern with
+// a / matches the path from the package's top, starting with /, and any
+// other pattern matches the whole of a name
+type stowIgnore struct {
+	paths, names []*regexp.Regexp
+}
+
+// newStowIgnore compiles a package's ignore patterns
+func newStowIgnore(patterns []string) (*stowIgnore, error) {
+	ig := &stowIgnore{}
+	for _, p := range patterns {
+		if strings.Contains(p, "/") {
+			re, err := regexp.Compile("(" + p + ")$")
+			if err != nil {
+				return nil, err
+			}
+			ig.paths = append(ig.paths, re)
+			continue
+		}
+		re, err := regexp.Compile("^(" + p + ")$")
+		if err != nil {
+			return nil, err
+		}
+		ig.names = append(ig.names, re)
+	}
+	return ig, nil
+}
+
+// match reports whether rel, a slash-separated path in the package, is
+// ignored
+func (ig *stowIgnore) match(rel string) bool {
+	for _, re := range ig.paths {
+		if re.MatchString("/" + rel) {
+			return true
+		}
+	}
+	for _, re := range ig.names {
+		if re.MatchString(path.Base(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// importStow imports every package in a Stow directory. Each package is a
+// directory laid out like the home directory it is stowed into
+func importStow(dir, home string, put importPut) error {
+	opts := readStowrc(dir, home)
+	global, hasGlobal := readStowIgnore(filepath.Join(home, ".stow-global-ignore"))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	packages := 0
+	for _, pkg := range entries {
+		// Stow packages cannot start with a dot, which leaves out .git
+		if !pkg.IsDir() || strings.HasPrefix(pkg.Name(), ".") {
+			continue
+		}
+		patterns, ok := readStowIgnore(filepath.Join(dir, pkg.Name(), ".stow-local-ignore"))
+		switch {
+		case ok:
+		case hasGlobal:
+			patterns = global
+		default:
+			patterns = stowDefaultIgnore
+		}
+		ig, err := newStowIgnore(append(patterns, opts.ignore...))
+		if err != nil {
+			return fmt.Errorf("package %s: ignore list: %w", pkg.Name(), err)
+		}
+		if err := importStowPackage(filepath.Join(dir, pkg.Name()), ig, opts.dotfiles, put); err != nil {
+			return fmt.Errorf("package %s: %w", pkg.Name(), err)
+		}
+		packages++
+	}
+	noticeColor.Printf("📦 Read %d Stow packages\n", packages)
+	return nil
+}
+
+// importStowPackage hands everything in one package to put
+func importStowPackage(root string, ig *stowIgnore, dotfiles bool, put importPut) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.Name() == ".stow-local-ignore" || ig.match(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := rel
+		if dotfiles {
+			// With --dotfiles, dot-bashrc is stowed as .bashrc
+			parts := strings.Split(rel, "/")
+			for i, part := range parts {
+				if rest, ok := strings.CutPrefix(part, "dot-"); ok {
+					parts[i] = "." + rest
+				}
+			}
+			target = strings.Join(parts, "/")
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return put(target, nil, info.Mode(), "")
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return put(target, nil, info.Mode(), link)
+		case info.Mode().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return put(target, data, info.Mode(), "")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// What to do with a symlink in the source
+const (
+	symlinkFollow   = "follow"
+	symlinkPreserve = "preserve"
+	symlinkSkip     = "skip"
+)
+
+// validSymlinkPolicy checks a symlink policy from a flag or the catalog
+func validSymlinkPolicy(policy string) error {
+	switch policy {
+	case symlinkFollow, symlinkPreserve, symlinkSkip:
+		return nil
+	}
+	return fmt.Errorf("symlinks must be follow, preserve or skip, not %q", policy)
+}
+
+// symlinkPolicy is how an item treats symlinks: its catalog entry's
+// policy, or the global one
+func (ps *ProfileSync) symlinkPolicy(mapping string) string {
+	if e, ok := lookupCatalog(mapping); ok && e.Symlinks != "" {
+		return e.Symlinks
+	}
+	if ps.symlinks == "" {
+		return symlinkFollow
+	}
+	return ps.symlinks
+}
+
+// isSymlink reports whether path is a symlink itself
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
+
+// linkTarget returns what the link at src should point to on the
+// destination: the same relative target, or an absolute one moved from the
+// source home directory to the destination's
+func (ps *ProfileSync) linkTarget(src string) (string, error) {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) || ps.sourceHome == "" || !withinRoot(ps.sourceHome, target) {
+		return target, nil
+	}
+	rel, err := filepath.Rel(ps.sourceHome, target)
+	if err != nil {
+		return target, nil
+	}
+	return filepath.Join(ps.destHome, rel), nil
+}
+
+// copySymlink recreates the link at src as dst, replacing a file or link
+// there. A directory at dst is refused: backups and undo only cover files,
+// so replacing it would lose everything in it
+func (ps *ProfileSync) copySymlink(src, dst string) error {
+	target, err := ps.linkTarget(src)
+	if err != nil {
+		return err
+	}
+	if existing, err := os.Readlink(dst); err == nil && existing == target {
+		return nil
+	}
+	if info, err := os.Lstat(dst); err == nil && info.IsDir() {
+		return fmt.Errorf("%s is a directory; move it away to replace it with the link to %s", dst, target)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	// The new link is made beside dst and renamed over it, like files
+	tmp := tempPath(dst)
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// symlinkLoops reports whether following the directory link at path would
+// walk into a directory the walk is already inside: the link points at one
+// of the directories containing it, whether below the walk's root or above
+func symlinkLoops(path string) bool {
+	target, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(dir); err == nil && os.SameFile(info, target) {
+			return true
+		}
+		if filepath.Dir(dir) == dir {
+			return false
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopySymlink(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(dst string) error
+		wantErr bool
+	}{
+		{"nothing there", func(string) error { return nil }, false},
+		{"file", func(dst string) error { return os.WriteFile(dst, []byte("old"), 0644) }, false},
+		{"other link", func(dst string) error { return os.Symlink("elsewhere", dst) }, false},
+		{"directory", func(dst string) error {
+			if err := os.Mkdir(dst, 0755); err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(dst, "keep"), []byte("data"), 0644)
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+			if err := os.Symlink("target", src); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.setup(dst); err != nil {
+				t.Fatal(err)
+			}
+			err := (&ProfileSync{}).copySymlink(src, dst)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("copySymlink error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				// The directory and what is in it are left as they were
+				if _, err := os.Stat(filepath.Join(dst, "keep")); err != nil {
+					t.Errorf("directory content gone: %v", err)
+				}
+				return
+			}
+			if target, err := os.Readlink(dst); err != nil || target != "target" {
+				t.Errorf("dst links to %q (%v), want %q", target, err, "target")
+			}
+		})
+	}
+}
@@ -0,0 +1,835 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// syncProtocolVersion is bumped whenever the messages between the two ends
+// of a sync change incompatibly. Requests added since are announced as
+// features in the answer to hello
+const syncProtocolVersion = 1
+
+// Conflict policies for files changed on both machines
+const (
+	conflictNewest      = "newest-wins"
+	conflictSource      = "source-wins"
+	conflictInteractive = "interactive"
+)
+
+// syncFile is one file of a managed item on either end of a sync. Rel is
+// its slash-separated path inside a directory mapping
+type syncFile struct {
+	Mapping string      `json:"mapping"`
+	Rel     string      `json:"rel,omitempty"`
+	Hash    string      `json:"hash"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    fs.FileMode `json:"mode"`
+}
+
+// key identifies a file across both ends and in the sync state
+func (f syncFile) key() string {
+	return f.Mapping + f.Rel
+}
+
+// syncEndpoint is the managed files of one machine
+type syncEndpoint struct {
+	home     string
+	platform string
+}
+
+// path resolves a file of a mapping on this machine
+func (e *syncEndpoint) path(mapping, rel string) (string, error) {
+	root, err := resolveMappingPath(e.home, catalogPath(mapping, e.platform), e.platform)
+	if err != nil || rel == "" {
+		return root, err
+	}
+	return secureJoin(root, rel)
+}
+
+// list hashes every file of the given mappings that exists here
+func (e *syncEndpoint) list(mappings []string) ([]syncFile, error) {
+	var files []syncFile
+	for _, mapping := range mappings {
+		root, err := e.path(mapping, "")
+		if err != nil {
+			return nil, fmt.Errorf("mapping %s: %w", mapping, err)
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) && path == root {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if portable, _ := classifyAppData(path); !portable {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			hash, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			rel := ""
+			if path != root {
+				if rel, err = filepath.Rel(root, path); err != nil {
+					return err
+				}
+			}
+			files = append(files, syncFile{
+				Mapping: mapping,
+				Rel:     filepath.ToSlash(rel),
+				Hash:    hash,
+				ModTime: info.ModTime().UTC(),
+				Mode:    info.Mode().Perm(),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// read returns the content of a file
+func (e *syncEndpoint) read(f syncFile) ([]byte, error) {
+	path, err := e.path(f.Mapping, f.Rel)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// write replaces a file, backing up what it held first
+func (e *syncEndpoint) write(f syncFile, data []byte) error {
+	path, err := e.path(f.Mapping, f.Rel)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		if _, err := backupFile(path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	mode := f.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	tmp := tempPath(path)
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// remove deletes a file, backing it up first
+func (e *syncEndpoint) remove(f syncFile) error {
+	path, err := e.path(f.Mapping, f.Rel)
+	if err != nil {
+		return err
+	}
+	if _, err := backupFile(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	removeEmptyParents(path, e.home)
+	return nil
+}
+
+// syncRequest is a message to the remote end of a sync
+type syncRequest struct {
+	Op        string          `json:"op"`
+	Version   int             `json:"version,omitempty"`
+	Mappings  []string        `json:"mappings,omitempty"`
+	File      syncFile        `json:"file"`
+	Data      []byte          `json:"data,omitempty"`
+	Signature *deltaSignature `json:"signature,omitempty"`
+	Delta     []deltaOp       `json:"delta,omitempty"`
+}
+
+// syncResponse answers a syncRequest
+type syncResponse struct {
+	Error     string          `json:"error,omitempty"`
+	Platform  string          `json:"platform,omitempty"`
+	Features  []string        `json:"features,omitempty"`
+	Files     []syncFile      `json:"files,omitempty"`
+	Data      []byte          `json:"data,omitempty"`
+	Signature *deltaSignature `json:"signature,omitempty"`
+	Delta     []deltaOp       `json:"delta,omitempty"`
+}
+
+// serveSync answers sync requests on in and out until in is closed. It runs
+// on the remote machine as `profilesync sync --serve`
+func serveSync(in io.Reader, out io.Writer) error {
+	platform := DetectPlatform()
+	e := &syncEndpoint{home: GetHomeDir(platform), platform: platform}
+	dec := json.NewDecoder(in)
+	enc := json.NewEncoder(out)
+	for {
+		var req syncRequest
+		if err := dec.Decode(&req); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var resp syncResponse
+		var err error
+		switch req.Op {
+		case "hello":
+			if req.Version != syncProtocolVersion {
+				err = fmt.Errorf("sync protocol version %d is not supported (this end speaks %d); update profilesync on both machines", req.Version, syncProtocolVersion)
+			}
+			resp.Platform = platform
+			resp.Features = []string{syncFeatureDelta}
+		case "list":
+			resp.Files, err = e.list(req.Mappings)
+		case "read":
+			resp.Data, err = e.read(req.File)
+		case "write":
+			err = e.write(req.File, req.Data)
+		case "remove":
+			err = e.remove(req.File)
+		case "signature":
+			resp.Signature, err = e.serveSignature(req.File)
+		case "delta":
+			resp.Delta, err = e.serveDelta(req.File, req.Signature)
+		case "patch":
+			err = e.servePatch(req.File, req.Delta)
+		default:
+			err = fmt.Errorf("unknown sync request %q", req.Op)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := enc.Encode(&resp); err != nil {
+			return err
+		}
+	}
+}
+
+// syncRemote is the other machine, reached through ssh. A connection that
+// drops is made again, and the request that was under way sent again
+type syncRemote struct {
+	argv     []string
+	up, down *rateLimiter
+	retry    retryPolicy
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	enc      *json.Encoder
+	dec      *json.Decoder
+	broken   bool
+	platform string
+	// delta sends only the changed blocks of files both ends have, when
+	// allowed and the other end supports it
+	delta, allowDelta bool
+	transfer          syncTransfer
+}
+
+// sshTarget splits ssh://[user@]host[:port] into the ssh arguments that
+// reach it and a name for the peer in the sync state
+func sshTarget(target string) (args []string, peer string, err error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, "", fmt.Errorf("%q is not an ssh://[user@]host[:port] URL", target)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, "", fmt.Errorf("%s: paths are not supported; files are found through the mappings", target)
+	}
+	// ssh reads an argument starting with - as an option, such as
+	// -oProxyCommand, even in the user name
+	host := u.Hostname()
+	if strings.HasPrefix(host, "-") || u.User != nil && strings.HasPrefix(u.User.Username(), "-") {
+		return nil, "", fmt.Errorf("%s: user and host must not start with -", target)
+	}
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	peer = strings.ToLower(u.Hostname())
+	if u.Port() != "" {
+		peer += "-" + u.Port()
+	}
+	return append(args, "--", host), peer, nil
+}
+
+// dialSync starts profilesync on the other machine and says hello
+func dialSync(sshCommand, remoteCommand string, sshArgs []string, opts *transferOptions, delta bool) (*syncRemote, error) {
+	argv := strings.Fields(sshCommand)
+	if len(argv) == 0 {
+		return nil, errors.New("empty ssh command")
+	}
+	rate := opts.rate()
+	r := &syncRemote{
+		argv:       append(append(argv, sshArgs...), remoteCommand),
+		up:         newRateLimiter(rate),
+		down:       newRateLimiter(rate),
+		retry:      opts.retry(),
+		allowDelta: delta,
+	}
+	if err := r.retry.do(context.Background(), "Connecting", r.connect); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// connect starts the remote end and says hello
+func (r *syncRemote) connect() error {
+	cmd := exec.Command(r.argv[0], r.argv[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	r.cmd, r.stdin, r.broken = cmd, stdin, false
+	r.enc = json.NewEncoder(newLimitedWriter(stdin, r.up))
+	r.dec = json.NewDecoder(newLimitedReader(stdout, r.down))
+	resp, err := r.roundTrip(syncRequest{Op: "hello", Version: syncProtocolVersion})
+	if err != nil {
+		r.close()
+		return err
+	}
+	r.platform = resp.Platform
+	r.delta = false
+	for _, feature := range resp.Features {
+		r.delta = r.delta || (r.allowDelta && feature == syncFeatureDelta)
+	}
+	return nil
+}
+
+// call sends one request and waits for its answer, connecting again when
+// the connection dropped
+func (r *syncRemote) call(req syncRequest) (*syncResponse, error) {
+	var resp *syncResponse
+	err := r.retry.do(context.Background(), "Sync request", func() error {
+		if r.broken {
+			r.close()
+			if err := r.connect(); err != nil {
+				r.broken = true
+				return err
+			}
+		}
+		var err error
+		resp, err = r.roundTrip(req)
+		return err
+	})
+	return resp, err
+}
+
+// roundTrip sends one request on the current connection
+func (r *syncRemote) roundTrip(req syncRequest) (*syncResponse, error) {
+	if err := r.enc.Encode(&req); err != nil {
+		r.broken = true
+		return nil, fmt.Errorf("sending to the other machine: %w", err)
+	}
+	var resp syncResponse
+	if err := r.dec.Decode(&resp); err != nil {
+		r.broken = true
+		return nil, fmt.Errorf("reading from the other machine (is profilesync installed there?): %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// close ends the remote session
+func (r *syncRemote) close() error {
+	if r.cmd == nil {
+		return nil
+	}
+	r.stdin.Close()
+	err := r.cmd.Wait()
+	r.cmd = nil
+	return err
+}
+
+// SyncState records the hash each file had on both machines at the end of
+// the last sync with a peer, the base that tells which side changed since
+type SyncState struct {
+	Peer     string            `json:"peer"`
+	LastSync time.Time         `json:"last_sync"`
+	Files    map[string]string `json:"files"`
+}
+
+// syncStateDir holds one state file per peer
+func syncStateDir() string {
+	return filepath.Join(stateDir(), "sync")
+}
+
+// loadSyncState reads the state of syncs with a peer
+func loadSyncState(peer string) (*SyncState, error) {
+	s := &SyncState{Peer: peer, Files: map[string]string{}}
+	data, err := readStateFile(filepath.Join(syncStateDir(), peer+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("corrupt sync state for %s: %w", peer, err)
+	}
+	if s.Files == nil {
+		s.Files = map[string]string{}
+	}
+	return s, nil
+}
+
+// save writes the sync state
+func (s *SyncState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeStateFile(filepath.Join(syncStateDir(), s.Peer+".json"), data, 0600)
+}
+
+// Actions a sync takes for a file
+const (
+	syncPull         = "pull"
+	syncPush         = "push"
+	syncDeleteLocal  = "delete here"
+	syncDeleteRemote = "delete there"
+	syncConflict     = "conflict"
+	syncMerge        = "merge"
+)
+
+// syncDone describes each action once carried out
+var syncDone = map[string]string{
+	syncPull:         "Pulled",
+	syncPush:         "Pushed",
+	syncDeleteLocal:  "Deleted here",
+	syncDeleteRemote: "Deleted there",
+	syncMerge:        "Merged",
+}
+
+// syncAction is what a sync does with one file. local and remote are nil
+// where the file does not exist
+type syncAction struct {
+	kind          string
+	local, remote *syncFile
+}
+
+// file is whichever end's description of the file exists
+func (a syncAction) file() syncFile {
+	if a.local != nil {
+		return *a.local
+	}
+	return *a.remote
+}
+
+// planSync compares both ends with the base from the last sync
+func planSync(local, remote []syncFile, base map[string]string) (actions []syncAction, inSync []string) {
+	byKey := map[string]*syncAction{}
+	for i := range local {
+		byKey[local[i].key()] = &syncAction{local: &local[i]}
+	}
+	for i := range remote {
+		a, ok := byKey[remote[i].key()]
+		if !ok {
+			a = &syncAction{}
+			byKey[remote[i].key()] = a
+		}
+		a.remote = &remote[i]
+	}
+	for key := range base {
+		if _, ok := byKey[key]; !ok {
+			// Deleted on both ends
+			delete(base, key)
+		}
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		a := byKey[key]
+		hashOf := func(f *syncFile) string {
+			if f == nil {
+				return ""
+			}
+			return f.Hash
+		}
+		l, r := hashOf(a.local), hashOf(a.remote)
+		b, known := base[key]
+		switch {
+		case l == r:
+			inSync = append(inSync, key)
+			continue
+		case known && l == b:
+			a.kind = syncPull
+			if a.remote == nil {
+				a.kind = syncDeleteLocal
+			}
+		case known && r == b:
+			a.kind = syncPush
+			if a.local == nil {
+				a.kind = syncDeleteRemote
+			}
+		case !known && a.remote == nil:
+			a.kind = syncPush
+		case !known && a.local == nil:
+			a.kind = syncPull
+		default:
+			a.kind = syncConflict
+		}
+		actions = append(actions, *a)
+	}
+	return actions, inSync
+}
+
+// resolveConflict picks the action for a file changed on both ends. It
+// returns "" to leave the file alone and syncMerge to merge the two
+func resolveConflict(a syncAction, policy string, canMerge bool) string {
+	newer := func() string {
+		switch {
+		case a.local == nil:
+			return syncPull
+		case a.remote == nil:
+			return syncPush
+		case a.remote.ModTime.After(a.local.ModTime):
+			return syncPull
+		}
+		return syncPush
+	}
+	switch policy {
+	case conflictNewest:
+		return newer()
+	case conflictSource:
+		if a.local == nil {
+			return syncDeleteRemote
+		}
+		return syncPush
+	}
+
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		warnColor.Printf("⚠️  Skipped conflict in %s: no terminal to ask on (use --conflict)\n", a.file().key())
+		return ""
+	}
+	describe := func(f *syncFile) string {
+		if f == nil {
+			return "deleted"
+		}
+		return "changed " + f.ModTime.Local().Format("2006-01-02 15:04")
+	}
+	warnColor.Printf("⚡ %s changed on both machines (here: %s, there: %s)\n", a.file().key(), describe(a.local), describe(a.remote))
+	choices := "[h]ere, [t]here"
+	if canMerge {
+		choices += ", [m]erge"
+	}
+	for {
+		fmt.Printf("   Keep which version? %s or [s]kip: ", choices)
+		answer, _ := stdin.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "h", "here":
+			if a.local == nil {
+				return syncDeleteRemote
+			}
+			return syncPush
+		case "t", "there":
+			if a.remote == nil {
+				return syncDeleteLocal
+			}
+			return syncPull
+		case "m", "merge":
+			if canMerge {
+				return syncMerge
+			}
+		case "s", "skip", "":
+			return ""
+		}
+	}
+}
+
+// runSync handles `profilesync sync ssh://host`, propagating changes to
+// managed files in both directions
+func runSync(args []string) {
+	flags := newFlagSet("sync", "sync [flags] ssh://[user@]host[:port]")
+	defaultSSH := "ssh"
+	if command := os.Getenv("PROFILESYNC_SSH"); command != "" {
+		defaultSSH = command
+	}
+	dryRun := flags.Bool("dry-run", true, "Show what would be copied in each direction without copying")
+	policy := flags.String("conflict", conflictInteractive, "What to do with files changed on both machines: newest-wins, source-wins (this machine) or interactive")
+	configFile := flags.String("config", "", "Config file with the mappings to sync (default "+configPath()+" if it exists)")
+	profile := flags.String("profile", os.Getenv("PROFILESYNC_PROFILE"), "Named profile from the config whose mappings to sync")
+	sshCommand := flags.String("ssh", defaultSSH, "Command used to reach the other machine")
+	remoteCommand := flags.String("remote-command", "profilesync sync --serve", "Command that runs profilesync on the other machine")
+	serve := flags.Bool("serve", false, "Answer a sync from another machine on stdin and stdout (run through ssh by sync)")
+	verbose := flags.Bool("verbose", false, "Also list files already in sync")
+	delta := flags.Bool("delta", true, "Send only the changed blocks of large files both machines have")
+	transfer := addTransferFlags(flags)
+	flags.Parse(args)
+
+	if *serve {
+		if err := serveSync(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "profilesync sync --serve:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	switch *policy {
+	case conflictNewest, conflictSource, conflictInteractive:
+	default:
+		errorColor.Println("❌ Invalid --conflict value:", *policy)
+		errorColor.Println("Must be one of: newest-wins, source-wins, interactive")
+		os.Exit(2)
+	}
+	sshArgs, peer, err := sshTarget(flags.Arg(0))
+	if err != nil {
+		errorColor.Println("❌", err)
+		os.Exit(2)
+	}
+
+	if *configFile == "" {
+		if _, err := os.Stat(configPath()); err == nil {
+			*configFile = configPath()
+		}
+	}
+	mappings := GetDefaultMappings()
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile, *profile)
+		if err != nil {
+			errorColor.Println("❌ Error loading config:", err)
+			os.Exit(1)
+		}
+		mappings = cfg.Mappings
+	}
+	var synced []string
+	for mapping := range mappings {
+		// Configs with their own handling are merged or located per
+		// platform and are not copied as they are
+		if _, ok := itemHandlers[mapping]; ok {
+			if *verbose {
+				noticeColor.Printf("⏭️  Not synced (migrated with special handling): %s\n", mapping)
+			}
+			continue
+		}
+		synced = append(synced, mapping)
+	}
+	sort.Strings(synced)
+
+	platform := DetectPlatform()
+	here := &syncEndpoint{home: GetHomeDir(platform), platform: platform}
+	remote, err := dialSync(*sshCommand, *remoteCommand, sshArgs, transfer, *delta)
+	if err != nil {
+		logger().Error("connection failed", "peer", flags.Arg(0), "error", err)
+		errorColor.Println("❌ Error connecting to", flags.Arg(0)+":", err)
+		os.Exit(1)
+	}
+	defer remote.close()
+	infoColor.Printf("🔄 Syncing with %s (%s)\n", peer, remote.platform)
+	logger().Info("sync started", "peer", peer, "platform", remote.platform, "dry_run", *dryRun, "delta", remote.delta)
+	started := time.Now()
+
+	localFiles, err := here.list(synced)
+	if err != nil {
+		errorColor.Println("❌ Error listing files here:", err)
+		os.Exit(1)
+	}
+	resp, err := remote.call(syncRequest{Op: "list", Mappings: synced})
+	if err != nil {
+		errorColor.Println("❌ Error listing files on", peer+":", err)
+		os.Exit(1)
+	}
+	state, err := loadSyncState(peer)
+	if err != nil {
+		errorColor.Println("❌ Error reading sync state:", err)
+		os.Exit(1)
+	}
+
+	actions, inSync := planSync(localFiles, resp.Files, state.Files)
+	if *verbose {
+		for _, key := range inSync {
+			fmt.Printf("   = %s\n", key)
+		}
+	}
+	if len(actions) == 0 {
+		successColor.Printf("✅ In sync: %d files are the same on both machines\n", len(inSync))
+	}
+
+	failed := 0
+	for _, a := range actions {
+		f := a.file()
+		kind := a.kind
+		if kind == syncConflict {
+			if *dryRun {
+				logger().Warn("file", "file", f.key(), "action", kind, "dry_run", true)
+				warnColor.Printf("⚡ Conflict: %s changed on both machines (--conflict %s)\n", f.key(), *policy)
+				continue
+			}
+			_, hasBase := state.Files[f.key()]
+			kind = resolveConflict(a, *policy, hasBase && a.local != nil && a.remote != nil)
+		}
+		if *dryRun {
+			logger().Info("file", "file", f.key(), "action", kind, "dry_run", true)
+			fmt.Printf("Would %s: %s\n", kind, f.key())
+			continue
+		}
+
+		hash, err := applySyncAction(kind, a, here, remote, state.Files[f.key()])
+		switch {
+		case err != nil:
+			logger().Error("file", "file", f.key(), "action", kind, "error", err)
+			errorColor.Printf("❌ %s %s: %v\n", kind, f.key(), err)
+			failed++
+			continue
+		case kind == "":
+			continue
+		case hash == "":
+			delete(state.Files, f.key())
+		default:
+			state.Files[f.key()] = hash
+		}
+		logger().Info("file", "file", f.key(), "action", kind, "hash", hash)
+		successColor.Printf("✅ %s: %s\n", syncDone[kind], f.key())
+	}
+	if *dryRun {
+		if len(actions) > 0 {
+			noticeColor.Println("This was a dry run. Run with --dry-run=false to sync.")
+		}
+		return
+	}
+
+	// Files now the same on both ends are the base for the next sync
+	for _, key := range inSync {
+		for _, f := range localFiles {
+			if f.key() == key {
+				state.Files[key] = f.Hash
+			}
+		}
+	}
+	for _, f := range localFiles {
+		if hash, ok := state.Files[f.key()]; ok {
+			if path, err := here.path(f.Mapping, f.Rel); err == nil {
+				saveBase(hash, path)
+			}
+		}
+	}
+	state.LastSync = time.Now().UTC()
+	if err := state.save(); err != nil {
+		errorColor.Println("❌ Error saving sync state:", err)
+		os.Exit(1)
+	}
+	pruneBases()
+	t := remote.transfer
+	logger().Info("sync finished", "peer", peer, "in_sync", len(inSync), "actions", len(actions), "failed", failed,
+		"files_transferred", t.files, "deltas", t.deltas, "bytes_sent", t.sent, "bytes_received", t.received,
+		"duration_ms", time.Since(started).Milliseconds())
+	if t.deltas > 0 {
+		noticeColor.Printf("📦 %d of %d files transferred as deltas: %s sent for %s, %s received for %s\n",
+			t.deltas, t.files, formatSize(t.sent), formatSize(t.whole), formatSize(t.received), formatSize(t.fresh))
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// applySyncAction carries out one action, returning the hash both ends now
+// share, or "" when the file is gone from both
+func applySyncAction(kind string, a syncAction, here *syncEndpoint, remote *syncRemote, baseHash string) (string, error) {
+	switch kind {
+	case syncPull:
+		var local []byte
+		if a.local != nil {
+			// The version here is the basis of a delta
+			local, _ = here.read(*a.local)
+		}
+		data, err := remote.pull(*a.remote, local)
+		if err != nil {
+			return "", err
+		}
+		return a.remote.Hash, here.write(*a.remote, data)
+	case syncPush:
+		data, err := here.read(*a.local)
+		if err != nil {
+			return "", err
+		}
+		return a.local.Hash, remote.push(*a.local, a.remote, data)
+	case syncDeleteLocal:
+		return "", here.remove(*a.local)
+	case syncDeleteRemote:
+		_, err := remote.call(syncRequest{Op: "remove", File: *a.remote})
+		return "", err
+	case syncMerge:
+		base, err := readStateFile(filepath.Join(basesDir(), baseHash))
+		if err != nil {
+			return "", fmt.Errorf("no base to merge from: %w", err)
+		}
+		local, err := here.read(*a.local)
+		if err != nil {
+			return "", err
+		}
+		resp, err := remote.call(syncRequest{Op: "read", File: *a.remote})
+		if err != nil {
+			return "", err
+		}
+		lines, conflicts := merge3(splitLines(base), splitLines(local), splitLines(resp.Data), "here", "there")
+		merged := []byte(strings.Join(lines, ""))
+		if conflicts > 0 {
+			warnColor.Printf("⚠️  %d conflicts in %s; resolve the markers on either machine and sync again\n", conflicts, a.local.key())
+		}
+		if err := here.write(*a.local, merged); err != nil {
+			return "", err
+		}
+		if _, err := remote.call(syncRequest{Op: "write", File: *a.remote, Data: merged}); err != nil {
+			return "", err
+		}
+		return hashBytes(merged), nil
+	}
+	return "", nil
+}
+
+// hashBytes returns the hex SHA-256 of data, as hashFile does for files
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlanSync(t *testing.T) {
+	file := func(rel, hash string) syncFile {
+		return syncFile{Mapping: "vim/", Rel: rel, Hash: hash}
+	}
+	tests := []struct {
+		name          string
+		local, remote []syncFile
+		base          map[string]string
+		want          map[string]string // key to action kind
+		inSync        []string
+		forgotten     []string // keys dropped from base
+	}{
+		{
+			name:  "same on both ends",
+			local: []syncFile{file("a", "1")}, remote: []syncFile{file("a", "1")},
+			base:   map[string]string{"vim/a": "0"},
+			want:   map[string]string{},
+			inSync: []string{"vim/a"},
+		},
+		{
+			name:  "changed there",
+			local: []syncFile{file("a", "1")}, remote: []syncFile{file("a", "2")},
+			base: map[string]string{"vim/a": "1"},
+			want: map[string]string{"vim/a": syncPull},
+		},
+		{
+			name:  "changed here",
+			local: []syncFile{file("a", "2")}, remote: []syncFile{file("a", "1")},
+			base: map[string]string{"vim/a": "1"},
+			want: map[string]string{"vim/a": syncPush},
+		},
+		{
+			name:  "deleted there",
+			local: []syncFile{file("a", "1")},
+			base:  map[string]string{"vim/a": "1"},
+			want:  map[string]string{"vim/a": syncDeleteLocal},
+		},
+		{
+			name:   "deleted here",
+			remote: []syncFile{file("a", "1")},
+			base:   map[string]string{"vim/a": "1"},
+			want:   map[string]string{"vim/a": syncDeleteRemote},
+		},
+		{
+			name:  "new here",
+			local: []syncFile{file("a", "1")},
+			base:  map[string]string{},
+			want:  map[string]string{"vim/a": syncPush},
+		},
+		{
+			name:   "new there",
+			remote: []syncFile{file("a", "1")},
+			base:   map[string]string{},
+			want:   map[string]string{"vim/a": syncPull},
+		},
+		{
+			name:  "changed on both ends",
+			local: []syncFile{file("a", "2")}, remote: []syncFile{file("a", "3")},
+			base: map[string]string{"vim/a": "1"},
+			want: map[string]string{"vim/a": syncConflict},
+		},
+		{
+			name:  "new on both ends with different content",
+			local: []syncFile{file("a", "2")}, remote: []syncFile{file("a", "3")},
+			base: map[string]string{},
+			want: map[string]string{"vim/a": syncConflict},
+		},
+		{
+			name:  "changed here, deleted there",
+			local: []syncFile{file("a", "2")},
+			base:  map[string]string{"vim/a": "1"},
+			want:  map[string]string{"vim/a": syncConflict},
+		},
+		{
+			name:      "deleted on both ends",
+			base:      map[string]string{"vim/a": "1"},
+			want:      map[string]string{},
+			forgotten: []string{"vim/a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, inSync := planSync(tt.local, tt.remote, tt.base)
+			got := map[string]string{}
+			for _, a := range actions {
+				got[a.file().key()] = a.kind
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("actions = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(inSync, tt.inSync) {
+				t.Errorf("in sync = %q, want %q", inSync, tt.inSync)
+			}
+			for _, key := range tt.forgotten {
+				if _, ok := tt.base[key]; ok {
+					t.Errorf("%s is still in the base", key)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanSyncOrder(t *testing.T) {
+	local := []syncFile{{Mapping: "vim/", Rel: "c", Hash: "1"}, {Mapping: "git/.gitconfig", Hash: "1"}, {Mapping: "vim/", Rel: "a", Hash: "1"}}
+	actions, _ := planSync(local, nil, map[string]string{})
+	var keys []string
+	for _, a := range actions {
+		keys = append(keys, a.file().key())
+	}
+	if want := []string{"git/.gitconfig", "vim/a", "vim/c"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("actions for %q, want %q", keys, want)
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	file := func(mtime time.Time) *syncFile {
+		return &syncFile{Mapping: "git/.gitconfig", Hash: mtime.String(), ModTime: mtime}
+	}
+	tests := []struct {
+		name          string
+		local, remote *syncFile
+		policy        string
+		want          string
+	}{
+		{"newest wins, newer there", file(older), file(newer), conflictNewest, syncPull},
+		{"newest wins, newer here", file(newer), file(older), conflictNewest, syncPush},
+		{"newest wins, same time", file(older), file(older), conflictNewest, syncPush},
+		{"newest wins, deleted here", nil, file(older), conflictNewest, syncPull},
+		{"newest wins, deleted there", file(older), nil, conflictNewest, syncPush},
+		{"source wins", file(older), file(newer), conflictSource, syncPush},
+		{"source wins, deleted here", nil, file(newer), conflictSource, syncDeleteRemote},
+		// go test runs without a terminal to ask on
+		{"interactive without a terminal", file(older), file(newer), conflictInteractive, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := syncAction{kind: syncConflict, local: tt.local, remote: tt.remote}
+			if got := resolveConflict(a, tt.policy, true); got != tt.want {
+				t.Errorf("resolveConflict = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSHTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		args   []string
+		peer   string
+		ok     bool
+	}{
+		{"ssh://laptop", []string{"--", "laptop"}, "laptop", true},
+		{"ssh://me@Laptop:2222", []string{"-p", "2222", "--", "me@Laptop"}, "laptop-2222", true},
+		{"ssh://laptop/", []string{"--", "laptop"}, "laptop", true},
+		{"ssh://laptop/home/me", nil, "", false},
+		{"https://laptop", nil, "", false},
+		{"ssh://-oProxyCommand=id", nil, "", false},
+		{"ssh://-oProxyCommand=id@laptop", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			args, peer, err := sshTarget(tt.target)
+			if (err == nil) != tt.ok {
+				t.Fatalf("sshTarget error = %v, want ok %v", err, tt.ok)
+			}
+			if !reflect.DeepEqual(args, tt.args) || peer != tt.peer {
+				t.Errorf("sshTarget = %q, %q, want %q, %q", args, peer, tt.args, tt.peer)
+			}
+		})
+	}
+}
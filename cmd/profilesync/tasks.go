@@ -0,0 +1,1399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schedulers scheduled tasks are captured from and installed into
+const (
+	schedulerCron    = "cron"
+	schedulerLaunchd = "launchd"
+	schedulerSystemd = "systemd"
+)
+
+// ScheduledTask is one job in the form every scheduler can be translated
+// from. Commands hold homeToken in place of the home directory
+type ScheduledTask struct {
+	Name    string `yaml:"name"`
+	Origin  string `yaml:"origin"`
+	Command string `yaml:"command"`
+	// Schedule is a five-field cron expression or a cron @keyword
+	Schedule string `yaml:"schedule,omitempty"`
+	// Interval is the number of seconds between runs
+	Interval int `yaml:"interval,omitempty"`
+	// KeepAlive jobs are restarted whenever they exit
+	KeepAlive bool `yaml:"keep_alive,omitempty"`
+	// Files are the captured unit or agent files, relative to tasks/
+	Files []string `yaml:"files,omitempty"`
+	// Notes say what about the job no other scheduler can express
+	Notes []string `yaml:"notes,omitempty"`
+}
+
+// TaskManifest lists the scheduled tasks captured into a profile store
+type TaskManifest struct {
+	CapturedAt time.Time `yaml:"captured_at"`
+	Platform   string    `yaml:"platform"`
+	// Environment holds the variables set at the top of the crontab
+	Environment map[string]string `yaml:"environment,omitempty"`
+	Tasks       []ScheduledTask   `yaml:"tasks"`
+}
+
+// tasksDir and tasksManifestPath are where scheduled tasks live in a profile store
+func tasksDir(dir string) string {
+	return filepath.Join(dir, "tasks")
+}
+
+func tasksManifestPath(dir string) string {
+	return filepath.Join(tasksDir(dir), "tasks.yaml")
+}
+
+// launchAgentsDir and systemdUserDir are where per-user jobs are installed
+func launchAgentsDir(home string) string {
+	return filepath.Join(home, "Library", "LaunchAgents")
+}
+
+func systemdUserDir(home string) string {
+	return filepath.Join(home, ".config", "systemd", "user")
+}
+
+// loadTaskManifest reads the scheduled tasks captured into a profile store
+func loadTaskManifest(dir string) (*TaskManifest, error) {
+	data, err := os.ReadFile(tasksManifestPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	var m TaskManifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", tasksManifestPath(dir), err)
+	}
+	return &m, nil
+}
+
+// localSchedulers are the schedulers available on this machine
+func localSchedulers() map[string]bool {
+	available := map[string]bool{}
+	if _, err := exec.LookPath("crontab"); err == nil && DetectPlatform() != "windows" {
+		available[schedulerCron] = true
+	}
+	switch DetectPlatform() {
+	case "macos":
+		available[schedulerLaunchd] = true
+	case "linux":
+		if _, err := exec.LookPath("systemctl"); err == nil {
+			available[schedulerSystemd] = true
+		}
+	}
+	return available
+}
+
+// uniqueTaskName returns name, or name with a number when it is taken
+func uniqueTaskName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// --- cron ---
+
+// cronMarker tags crontab lines profilesync added so they are found again
+const cronMarker = "# profilesync: "
+
+// cronEnvLine matches a crontab variable assignment
+var cronEnvLine = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+
+// parseCrontab reads crontab lines into tasks and the variables they run with
+func parseCrontab(data []byte, home string, taken map[string]bool) (map[string]string, []ScheduledTask) {
+	env := map[string]string{}
+	var tasks []ScheduledTask
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m := cronEnvLine.FindStringSubmatch(line); m != nil {
+			env[m[1]] = replaceHome(strings.Trim(m[2], `"'`), home, true)
+			continue
+		}
+
+		name := ""
+		if i := strings.Index(line, cronMarker); i >= 0 {
+			name = strings.TrimSpace(line[i+len(cronMarker):])
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(line)
+		count := 5
+		if strings.HasPrefix(line, "@") {
+			count = 1
+		}
+		if len(fields) <= count {
+			continue
+		}
+		schedule := strings.Join(fields[:count], " ")
+		command := line
+		for i := 0; i < count; i++ {
+			command = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(command), fields[i]))
+		}
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(strings.Fields(command)[0]), filepath.Ext(strings.Fields(command)[0]))
+		}
+		tasks = append(tasks, ScheduledTask{
+			Name:     uniqueTaskName(name, taken),
+			Origin:   schedulerCron,
+			Command:  replaceHome(command, home, true),
+			Schedule: schedule,
+		})
+	}
+	return env, tasks
+}
+
+// readCrontab returns the user's crontab, or nothing when there is none
+func readCrontab() ([]byte, error) {
+	out, err := exec.Command("crontab", "-l").Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// crontab -l fails when the user has no crontab
+		return nil, nil
+	}
+	return out, err
+}
+
+// cronSpecials are the @keywords with a fixed five-field meaning
+var cronSpecials = map[string]string{
+	"@yearly": "0 0 1 1 *", "@annually": "0 0 1 1 *", "@monthly": "0 0 1 * *",
+	"@weekly": "0 0 * * 0", "@daily": "0 0 * * *", "@midnight": "0 0 * * *", "@hourly": "0 * * * *",
+}
+
+// cronFieldRanges are the bounds of the five cron fields
+var cronFieldRanges = [5]struct{ min, max int }{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// cronNames are the names cron accepts for months and weekdays
+var cronNames = [5]map[string]int{
+	3: {"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12},
+	4: {"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6},
+}
+
+// expandCronField returns the values a cron field matches, or nil for *
+func expandCronField(field string, i int) ([]int, error) {
+	if field == "*" {
+		return nil, nil
+	}
+	bounds := cronFieldRanges[i]
+	number := func(s string) (int, error) {
+		if n, ok := cronNames[i][strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < bounds.min || n > bounds.max {
+			return 0, fmt.Errorf("invalid cron field %q", field)
+		}
+		return n, nil
+	}
+
+	seen := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if base, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid cron field %q", field)
+			}
+			part, step = base, n
+		}
+		lo, hi := bounds.min, bounds.max
+		switch a, b, isRange := strings.Cut(part, "-"); {
+		case part == "*":
+		case isRange:
+			var err error
+			if lo, err = number(a); err != nil {
+				return nil, err
+			}
+			if hi, err = number(b); err != nil {
+				return nil, err
+			}
+		default:
+			n, err := number(part)
+			if err != nil {
+				return nil, err
+			}
+			lo = n
+			if step == 1 {
+				hi = n
+			}
+		}
+		for n := lo; n <= hi; n += step {
+			if i == 4 {
+				// Both 0 and 7 are Sunday
+				seen[n%7] = true
+				continue
+			}
+			seen[n] = true
+		}
+	}
+	values := make([]int, 0, len(seen))
+	for n := range seen {
+		values = append(values, n)
+	}
+	sort.Ints(values)
+	return values, nil
+}
+
+// splitCronSchedule returns the five fields of a schedule, resolving @keywords
+func splitCronSchedule(schedule string) ([]string, error) {
+	if fixed, ok := cronSpecials[schedule]; ok {
+		schedule = fixed
+	}
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q is not a five-field cron schedule", schedule)
+	}
+	if fields[2] != "*" && fields[4] != "*" {
+		return nil, errors.New("cron runs it when either the day of month or the weekday matches, which other schedulers cannot express")
+	}
+	return fields, nil
+}
+
+// formatCronField writes values as a cron field, nil meaning *
+func formatCronField(values []int) string {
+	if values == nil {
+		return "*"
+	}
+	parts := make([]string, len(values))
+	for i, n := range values {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
+
+// intervalToCron writes a whole number of minutes or hours as a schedule
+func intervalToCron(seconds int) (string, bool) {
+	switch minutes := seconds / 60; {
+	case seconds%60 != 0 || minutes == 0:
+	case minutes < 60 && 60%minutes == 0:
+		return fmt.Sprintf("*/%d * * * *", minutes), true
+	case minutes%60 == 0 && 24%(minutes/60) == 0:
+		return fmt.Sprintf("0 */%d * * *", minutes/60), true
+	}
+	return "", false
+}
+
+// --- launchd ---
+
+// launchdCalendarKeys are StartCalendarInterval's keys in cron field order
+var launchdCalendarKeys = [5]string{"Minute", "Hour", "Day", "Month", "Weekday"}
+
+// calendarToCron turns a StartCalendarInterval into a cron schedule. Several
+// dicts combine when they differ in one field only
+func calendarToCron(v interface{}) (string, bool) {
+	var dicts []map[string]interface{}
+	switch c := v.(type) {
+	case map[string]interface{}:
+		dicts = append(dicts, c)
+	case []interface{}:
+		for _, item := range c {
+			d, ok := item.(map[string]interface{})
+			if !ok {
+				return "", false
+			}
+			dicts = append(dicts, d)
+		}
+	}
+	if len(dicts) == 0 {
+		return "", false
+	}
+
+	fields := make([]string, 5)
+	varying := 0
+	for i, key := range launchdCalendarKeys {
+		seen := map[int]bool{}
+		var values []int
+		for _, d := range dicts {
+			n, ok := d[key].(int64)
+			if !ok {
+				// A key missing from some dicts but not others cannot combine
+				if _, present := d[key]; present || len(values) > 0 {
+					return "", false
+				}
+				continue
+			}
+			if !seen[int(n)] {
+				seen[int(n)] = true
+				values = append(values, int(n))
+			}
+		}
+		if len(values) > 0 && len(seen) != len(dicts) && len(seen) != 1 {
+			return "", false
+		}
+		if len(values) > 1 {
+			varying++
+		}
+		sort.Ints(values)
+		fields[i] = formatCronField(values)
+	}
+	if varying > 1 {
+		return "", false
+	}
+	return strings.Join(fields, " "), true
+}
+
+// cronToCalendar turns a cron schedule into StartCalendarInterval dicts
+func cronToCalendar(schedule string) ([]map[string]interface{}, error) {
+	fields, err := splitCronSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+	dicts := []map[string]interface{}{{}}
+	for i, field := range fields {
+		values, err := expandCronField(field, i)
+		if err != nil {
+			return nil, err
+		}
+		if values == nil {
+			continue
+		}
+		var next []map[string]interface{}
+		for _, d := range dicts {
+			for _, n := range values {
+				combined := map[string]interface{}{launchdCalendarKeys[i]: n}
+				for k, v := range d {
+					combined[k] = v
+				}
+				next = append(next, combined)
+			}
+		}
+		dicts = next
+	}
+	if len(dicts) > 100 {
+		return nil, fmt.Errorf("%q runs at %d different times, too many to list for launchd", schedule, len(dicts))
+	}
+	return dicts, nil
+}
+
+// shellJoin quotes arguments for sh where they need it
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`&|;<>()*?[]#~!{}") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// parseLaunchAgent reads a LaunchAgent plist into a task. ok is false for
+// agents that are not worth migrating, with the reason in notes
+func parseLaunchAgent(data []byte, path, home string) (task ScheduledTask, ok bool, err error) {
+	root, err := parsePlist(data, path)
+	if err != nil {
+		return task, false, err
+	}
+	agent, _ := root.(map[string]interface{})
+	task.Origin = schedulerLaunchd
+	task.Name = lookupString(agent, "Label")
+	if task.Name == "" {
+		task.Name = strings.TrimSuffix(filepath.Base(path), ".plist")
+	}
+	if disabled, _ := agent["Disabled"].(bool); disabled {
+		return task, false, nil
+	}
+
+	var args []string
+	if list, ok := agent["ProgramArguments"].([]interface{}); ok {
+		for _, arg := range list {
+			args = append(args, fmt.Sprint(arg))
+		}
+	}
+	if program := lookupString(agent, "Program"); program != "" {
+		if len(args) == 0 {
+			args = []string{program}
+		} else {
+			args[0] = program
+		}
+	}
+	if len(args) == 0 {
+		return task, false, nil
+	}
+	if strings.Contains(args[0], ".app/Contents/") {
+		// Reinstalling the app sets up its own agent
+		task.Notes = append(task.Notes, "installed by an app")
+		return task, false, nil
+	}
+	task.Command = replaceHome(shellJoin(args), home, true)
+
+	if v, ok := agent["StartCalendarInterval"]; ok {
+		if schedule, ok := calendarToCron(v); ok {
+			task.Schedule = schedule
+		} else {
+			task.Notes = append(task.Notes, "its StartCalendarInterval has no cron equivalent")
+		}
+	}
+	if n, ok := agent["StartInterval"].(int64); ok {
+		task.Interval = int(n)
+	}
+	switch keepAlive := agent["KeepAlive"].(type) {
+	case bool:
+		task.KeepAlive = keepAlive
+	case map[string]interface{}:
+		task.KeepAlive = true
+		task.Notes = append(task.Notes, "it is kept alive only under some conditions")
+	}
+	if runAtLoad, _ := agent["RunAtLoad"].(bool); runAtLoad && task.Schedule == "" && task.Interval == 0 && !task.KeepAlive {
+		task.Schedule = "@reboot"
+	}
+	for _, key := range []string{"WatchPaths", "QueueDirectories", "StartOnMount", "Sockets", "MachServices"} {
+		if _, ok := agent[key]; ok {
+			task.Notes = append(task.Notes, "it is started by "+key+", which only launchd supports")
+		}
+	}
+	return task, true, nil
+}
+
+// formatPlistValue writes one value of a property list
+func formatPlistValue(b *bytes.Buffer, v interface{}, indent string) {
+	escape := func(s string) string {
+		var e bytes.Buffer
+		xml.EscapeText(&e, []byte(s))
+		return e.String()
+	}
+	switch v := v.(type) {
+	case bool:
+		fmt.Fprintf(b, "%s<%t/>\n", indent, v)
+	case int:
+		fmt.Fprintf(b, "%s<integer>%d</integer>\n", indent, v)
+	case string:
+		fmt.Fprintf(b, "%s<string>%s</string>\n", indent, escape(v))
+	case []string:
+		fmt.Fprintf(b, "%s<array>\n", indent)
+		for _, item := range v {
+			formatPlistValue(b, item, indent+"\t")
+		}
+		fmt.Fprintf(b, "%s</array>\n", indent)
+	case []map[string]interface{}:
+		fmt.Fprintf(b, "%s<array>\n", indent)
+		for _, item := range v {
+			formatPlistValue(b, item, indent+"\t")
+		}
+		fmt.Fprintf(b, "%s</array>\n", indent)
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(b, "%s<dict>\n", indent)
+		for _, k := range keys {
+			fmt.Fprintf(b, "%s\t<key>%s</key>\n", indent, escape(k))
+			formatPlistValue(b, v[k], indent+"\t")
+		}
+		fmt.Fprintf(b, "%s</dict>\n", indent)
+	}
+}
+
+// launchdLabel is the label a translated task is installed under
+func launchdLabel(name string) string {
+	if strings.Contains(name, ".") {
+		return name
+	}
+	return "local." + name
+}
+
+// taskToLaunchAgent writes a task as a LaunchAgent plist
+func taskToLaunchAgent(task ScheduledTask, home string) (string, []byte, error) {
+	label := launchdLabel(task.Name)
+	agent := map[string]interface{}{
+		"Label":            label,
+		"ProgramArguments": []string{"/bin/sh", "-c", replaceHome(task.Command, home, false)},
+	}
+	switch {
+	case task.KeepAlive:
+		agent["KeepAlive"] = true
+		agent["RunAtLoad"] = true
+	case task.Interval > 0:
+		agent["StartInterval"] = task.Interval
+	case task.Schedule == "@reboot":
+		agent["RunAtLoad"] = true
+	case task.Schedule != "":
+		dicts, err := cronToCalendar(task.Schedule)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(dicts) == 1 {
+			agent["StartCalendarInterval"] = dicts[0]
+		} else {
+			agent["StartCalendarInterval"] = dicts
+		}
+	default:
+		return "", nil, errors.New("it has no schedule")
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0">` + "\n")
+	formatPlistValue(&b, agent, "")
+	b.WriteString("</plist>\n")
+	return label + ".plist", b.Bytes(), nil
+}
+
+// --- systemd ---
+
+// systemdWeekdays are systemd's weekday names, Sunday first as in cron
+var systemdWeekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// systemdShorthands are the OnCalendar keywords with a fixed cron meaning
+var systemdShorthands = map[string]string{
+	"minutely": "* * * * *", "hourly": "0 * * * *", "daily": "0 0 * * *", "weekly": "0 0 * * 1",
+	"monthly": "0 0 1 * *", "yearly": "0 0 1 1 *", "annually": "0 0 1 1 *",
+}
+
+// onCalendarField converts one systemd calendar field to cron syntax
+func onCalendarField(field string, i int) (string, error) {
+	if field == "*" {
+		return "*", nil
+	}
+	var parts []string
+	for _, part := range strings.Split(field, ",") {
+		if i == 4 {
+			a, b, isRange := strings.Cut(part, "..")
+			from, to := -1, -1
+			for n, day := range systemdWeekdays {
+				if strings.EqualFold(a[:min(3, len(a))], day) {
+					from = n
+				}
+				if isRange && strings.EqualFold(b[:min(3, len(b))], day) {
+					to = n
+				}
+			}
+			switch {
+			case from < 0 || (isRange && to < 0):
+				return "", fmt.Errorf("unknown weekday %q", part)
+			case isRange:
+				if to == 0 {
+					to = 7
+				}
+				parts = append(parts, fmt.Sprintf("%d-%d", from, to))
+			default:
+				parts = append(parts, strconv.Itoa(from))
+			}
+			continue
+		}
+		if base, step, ok := strings.Cut(part, "/"); ok {
+			if n, err := strconv.Atoi(base); err != nil || n != cronFieldRanges[i].min {
+				return "", fmt.Errorf("%q repeats from an offset cron cannot express", part)
+			}
+			parts = append(parts, "*/"+step)
+			continue
+		}
+		var bounds []string
+		for _, text := range strings.Split(part, "..") {
+			n, err := strconv.Atoi(text)
+			if err != nil {
+				return "", fmt.Errorf("%q has no cron equivalent", part)
+			}
+			bounds = append(bounds, strconv.Itoa(n))
+		}
+		parts = append(parts, strings.Join(bounds, "-"))
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// onCalendarToCron converts the common forms of a systemd OnCalendar
+// expression, [weekdays] [date] time, to a cron schedule
+func onCalendarToCron(spec string) (string, error) {
+	if schedule, ok := systemdShorthands[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		return schedule, nil
+	}
+	fields := strings.Fields(spec)
+	weekday, date, clock := "*", "*-*-*", "00:00:00"
+	if len(fields) > 0 && fields[0] != "" && !strings.ContainsAny(fields[0][:1], "0123456789*") {
+		weekday, fields = fields[0], fields[1:]
+	}
+	for _, f := range fields {
+		switch {
+		case strings.Contains(f, ":"):
+			clock = f
+		case strings.Contains(f, "-"):
+			date = f
+		default:
+			return "", fmt.Errorf("OnCalendar=%s has no cron equivalent", spec)
+		}
+	}
+
+	dateParts := strings.Split(date, "-")
+	if len(dateParts) == 2 {
+		dateParts = append([]string{"*"}, dateParts...)
+	}
+	clockParts := strings.Split(clock, ":")
+	if len(dateParts) != 3 || len(clockParts) < 2 || dateParts[0] != "*" {
+		return "", fmt.Errorf("OnCalendar=%s has no cron equivalent", spec)
+	}
+	if len(clockParts) == 3 && strings.Trim(clockParts[2], "0") != "" {
+		return "", fmt.Errorf("OnCalendar=%s runs at seconds cron cannot express", spec)
+	}
+
+	var cron [5]string
+	for i, field := range []string{clockParts[1], clockParts[0], dateParts[2], dateParts[1], weekday} {
+		converted, err := onCalendarField(field, i)
+		if err != nil {
+			return "", fmt.Errorf("OnCalendar=%s: %w", spec, err)
+		}
+		cron[i] = converted
+	}
+	return strings.Join(cron[:], " "), nil
+}
+
+// cronToOnCalendar converts a cron schedule to a systemd OnCalendar expression
+func cronToOnCalendar(schedule string) (string, error) {
+	fields, err := splitCronSchedule(schedule)
+	if err != nil {
+		return "", err
+	}
+	converted := make([]string, 5)
+	for i, field := range fields[:4] {
+		if field == "*" {
+			converted[i] = "*"
+			continue
+		}
+		var parts []string
+		for _, part := range strings.Split(field, ",") {
+			base, step, hasStep := strings.Cut(part, "/")
+			if hasStep && base == "*" {
+				parts = append(parts, fmt.Sprintf("%d/%s", cronFieldRanges[i].min, step))
+				continue
+			}
+			if hasStep || strings.IndexFunc(part, unicode.IsLetter) >= 0 {
+				values, err := expandCronField(part, i)
+				if err != nil {
+					return "", err
+				}
+				parts = append(parts, formatCronField(values))
+				continue
+			}
+			parts = append(parts, strings.ReplaceAll(part, "-", ".."))
+		}
+		converted[i] = strings.Join(parts, ",")
+	}
+
+	for i := 0; i < 2; i++ {
+		converted[i] = regexp.MustCompile(`\b\d\b`).ReplaceAllStringFunc(converted[i], func(d string) string { return "0" + d })
+	}
+
+	// Weekdays are named, with Monday to Saturday runs as ranges
+	days, err := expandCronField(fields[4], 4)
+	if err != nil {
+		return "", err
+	}
+	var weekdays []string
+	for j := 0; j < len(days); j++ {
+		k := j
+		for days[j] > 0 && k+1 < len(days) && days[k+1] == days[k]+1 {
+			k++
+		}
+		if k-j >= 2 {
+			weekdays = append(weekdays, systemdWeekdays[days[j]]+".."+systemdWeekdays[days[k]])
+			j = k
+			continue
+		}
+		weekdays = append(weekdays, systemdWeekdays[days[j]])
+	}
+	if len(weekdays) > 0 {
+		converted[4] = strings.Join(weekdays, ",") + " "
+	}
+	return fmt.Sprintf("%s*-%s-%s %s:%s:00", converted[4], converted[3], converted[2], converted[1], converted[0]), nil
+}
+
+// parseSystemdDuration reads durations such as 90, 15min or 1h 30m in seconds
+func parseSystemdDuration(value string) (int, error) {
+	units := map[string]int{
+		"": 1, "s": 1, "sec": 1, "second": 1, "seconds": 1,
+		"m": 60, "min": 60, "minute": 60, "minutes": 60,
+		"h": 3600, "hr": 3600, "hour": 3600, "hours": 3600,
+		"d": 86400, "day": 86400, "days": 86400, "w": 604800, "week": 604800, "weeks": 604800,
+	}
+	total := 0
+	matches := regexp.MustCompile(`(\d+)\s*([a-z]*)`).FindAllStringSubmatch(strings.ToLower(value), -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	for _, m := range matches {
+		n, _ := strconv.Atoi(m[1])
+		unit, ok := units[m[2]]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		total += n * unit
+	}
+	return total, nil
+}
+
+// unitSection returns a section of a unit file, or an empty one
+func unitSection(sections []*iniSection, name string) *iniSection {
+	for _, s := range sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return newINISection(name)
+}
+
+// execStartCommand turns an ExecStart line into a shell command
+func execStartCommand(execStart string) (string, []string) {
+	command := strings.TrimLeft(execStart, "-@+!:")
+	var notes []string
+	command = strings.ReplaceAll(command, "%h", "$HOME")
+	command = strings.ReplaceAll(command, "$$", "$")
+	if strings.Contains(strings.ReplaceAll(command, "%%", ""), "%") {
+		notes = append(notes, "its command uses systemd specifiers")
+	}
+	return strings.ReplaceAll(command, "%%", "%"), notes
+}
+
+// parseSystemdUnits reads the user units in dir into tasks: one for every
+// enabled timer, and one for every enabled service no timer starts
+func parseSystemdUnits(dir, home string, taken map[string]bool) ([]ScheduledTask, []string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	units := map[string][]*iniSection{}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".service" && ext != ".timer") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		units[e.Name()] = parseINI(data)
+	}
+	enabled := func(unit, target string) bool {
+		_, err := os.Lstat(filepath.Join(dir, target+".wants", unit))
+		return err == nil
+	}
+
+	var names []string
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var tasks []ScheduledTask
+	var files []string
+	timed := map[string]bool{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".timer") {
+			continue
+		}
+		timer := unitSection(units[name], "Timer")
+		service := timer.Get("Unit")
+		if service == "" {
+			service = strings.TrimSuffix(name, ".timer") + ".service"
+		}
+		timed[service] = true
+		if _, ok := units[service]; !ok || !enabled(name, "timers.target") {
+			continue
+		}
+		task := ScheduledTask{
+			Name:   uniqueTaskName(strings.TrimSuffix(name, ".timer"), taken),
+			Origin: schedulerSystemd,
+			Files:  []string{"systemd/" + name, "systemd/" + service},
+		}
+		task.Command, task.Notes = execStartCommand(unitSection(units[service], "Service").Get("ExecStart"))
+		task.Command = replaceHome(task.Command, home, true)
+		if spec := timer.Get("OnCalendar"); spec != "" {
+			if schedule, err := onCalendarToCron(spec); err == nil {
+				task.Schedule = schedule
+			} else {
+				task.Notes = append(task.Notes, err.Error())
+			}
+		}
+		for _, key := range []string{"OnUnitActiveSec", "OnUnitInactiveSec"} {
+			if value := timer.Get(key); value != "" && task.Schedule == "" {
+				if seconds, err := parseSystemdDuration(value); err == nil {
+					task.Interval = seconds
+				}
+			}
+		}
+		if task.Schedule == "" && task.Interval == 0 && timer.Get("OnBootSec") != "" {
+			task.Schedule = "@reboot"
+		}
+		tasks = append(tasks, task)
+		files = append(files, name, service)
+	}
+
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".service") || timed[name] || !enabled(name, "default.target") {
+			continue
+		}
+		section := unitSection(units[name], "Service")
+		task := ScheduledTask{
+			Name:   uniqueTaskName(strings.TrimSuffix(name, ".service"), taken),
+			Origin: schedulerSystemd,
+			Files:  []string{"systemd/" + name},
+		}
+		task.Command, task.Notes = execStartCommand(section.Get("ExecStart"))
+		task.Command = replaceHome(task.Command, home, true)
+		switch section.Get("Restart") {
+		case "always", "on-failure", "on-abnormal", "on-abort":
+			task.KeepAlive = true
+		default:
+			task.Schedule = "@reboot"
+		}
+		tasks = append(tasks, task)
+		files = append(files, name)
+	}
+	return tasks, files, nil
+}
+
+// systemdEscape quotes a shell command for ExecStart=/bin/sh -c "..."
+func systemdEscape(command string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(command)
+}
+
+// systemdUnitName is the unit name a translated task is installed under
+func systemdUnitName(name string) string {
+	return regexp.MustCompile(`[^A-Za-z0-9:_.\-]+`).ReplaceAllString(name, "-")
+}
+
+// taskToSystemdUnits writes a task as a service and, for scheduled tasks,
+// a timer. It returns the units by file name and the unit to enable
+func taskToSystemdUnits(task ScheduledTask, home string) (map[string][]byte, string, error) {
+	name := systemdUnitName(task.Name)
+	command := systemdEscape(replaceHome(task.Command, home, false))
+
+	service := fmt.Sprintf("[Unit]\nDescription=%s (migrated from %s by profilesync)\n\n[Service]\n", task.Name, task.Origin)
+	timer := ""
+	switch {
+	case task.KeepAlive:
+		service += fmt.Sprintf("ExecStart=/bin/sh -c \"%s\"\nRestart=always\n\n[Install]\nWantedBy=default.target\n", command)
+	case task.Schedule == "@reboot":
+		service += fmt.Sprintf("Type=oneshot\nExecStart=/bin/sh -c \"%s\"\n\n[Install]\nWantedBy=default.target\n", command)
+	case task.Interval > 0:
+		service += fmt.Sprintf("Type=oneshot\nExecStart=/bin/sh -c \"%s\"\n", command)
+		timer = fmt.Sprintf("OnBootSec=%ds\nOnUnitActiveSec=%ds\n", task.Interval, task.Interval)
+	case task.Schedule != "":
+		spec, err := cronToOnCalendar(task.Schedule)
+		if err != nil {
+			return nil, "", err
+		}
+		service += fmt.Sprintf("Type=oneshot\nExecStart=/bin/sh -c \"%s\"\n", command)
+		timer = fmt.Sprintf("OnCalendar=%s\nPersistent=true\n", spec)
+	default:
+		return nil, "", errors.New("it has no schedule")
+	}
+
+	units := map[string][]byte{name + ".service": []byte(service)}
+	if timer == "" {
+		return units, name + ".service", nil
+	}
+	units[name+".timer"] = []byte(fmt.Sprintf("[Unit]\nDescription=Run %s on schedule\n\n[Timer]\n%s\n[Install]\nWantedBy=timers.target\n", task.Name, timer))
+	return units, name + ".timer", nil
+}
+
+// --- capture ---
+
+// CaptureTasks saves this machine's crontab, LaunchAgents and systemd user
+// units into dir/tasks. Tasks captured from schedulers this machine does
+// not have are kept, so one store can hold a Mac's and a Linux box's jobs
+func CaptureTasks(dir string) (*TaskManifest, []string, error) {
+	platform := DetectPlatform()
+	home := GetHomeDir(platform)
+	manifest, err := loadTaskManifest(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		manifest = &TaskManifest{}
+	} else if err != nil {
+		return nil, nil, err
+	}
+	available := localSchedulers()
+	manifest.CapturedAt = time.Now().UTC()
+	manifest.Platform = platform
+
+	taken := map[string]bool{}
+	var tasks []ScheduledTask
+	for _, task := range manifest.Tasks {
+		if !available[task.Origin] {
+			taken[task.Name] = true
+			tasks = append(tasks, task)
+		}
+	}
+	var notes []string
+
+	if available[schedulerCron] {
+		data, err := readCrontab()
+		if err != nil {
+			return nil, nil, fmt.Errorf("crontab -l: %w", err)
+		}
+		env, cronTasks := parseCrontab(data, home, taken)
+		manifest.Environment = nil
+		if len(env) > 0 {
+			manifest.Environment = env
+		}
+		tasks = append(tasks, cronTasks...)
+	}
+
+	if available[schedulerLaunchd] {
+		os.RemoveAll(filepath.Join(tasksDir(dir), "launchd"))
+		entries, err := os.ReadDir(launchAgentsDir(home))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() || filepath.Ext(e.Name()) != ".plist" {
+				continue
+			}
+			path := filepath.Join(launchAgentsDir(home), e.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			task, ok, err := parseLaunchAgent(data, path, home)
+			switch {
+			case err != nil:
+				notes = append(notes, fmt.Sprintf("%s: %v", e.Name(), err))
+				continue
+			case !ok:
+				if len(task.Notes) > 0 {
+					notes = append(notes, fmt.Sprintf("%s skipped: %s", task.Name, task.Notes[0]))
+				}
+				continue
+			}
+			task.Name = uniqueTaskName(task.Name, taken)
+			task.Files = []string{"launchd/" + e.Name()}
+			if err := writeTaskFile(dir, task.Files[0], []byte(replaceHome(string(data), home, true))); err != nil {
+				return nil, nil, err
+			}
+			tasks = append(tasks, task)
+		}
+	}
+
+	if available[schedulerSystemd] {
+		os.RemoveAll(filepath.Join(tasksDir(dir), "systemd"))
+		unitDir := systemdUserDir(home)
+		systemdTasks, files, err := parseSystemdUnits(unitDir, home, taken)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		for _, name := range files {
+			data, err := os.ReadFile(filepath.Join(unitDir, name))
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := writeTaskFile(dir, "systemd/"+name, []byte(replaceHome(string(data), home, true))); err != nil {
+				return nil, nil, err
+			}
+		}
+		tasks = append(tasks, systemdTasks...)
+	}
+
+	manifest.Tasks = tasks
+	var out bytes.Buffer
+	out.WriteString("# Scheduled tasks captured by profilesync. Apply with `profilesync apply tasks`.\n")
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(manifest); err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(tasksDir(dir), 0755); err != nil {
+		return nil, nil, err
+	}
+	return manifest, notes, os.WriteFile(tasksManifestPath(dir), out.Bytes(), 0644)
+}
+
+// writeTaskFile writes a captured unit or agent under dir/tasks
+func writeTaskFile(dir, name string, data []byte) error {
+	path := filepath.Join(tasksDir(dir), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// --- apply ---
+
+// taskResult is one line of the translation report
+type taskResult struct {
+	Task   ScheduledTask
+	Target string
+	Status string
+}
+
+// taskTarget picks the scheduler a task is installed into here: its own
+// when available, then this platform's native one, then cron
+func taskTarget(task ScheduledTask, available map[string]bool) string {
+	if available[task.Origin] {
+		return task.Origin
+	}
+	for _, scheduler := range []string{schedulerLaunchd, schedulerSystemd} {
+		if available[scheduler] {
+			return scheduler
+		}
+	}
+	if available[schedulerCron] {
+		return schedulerCron
+	}
+	return ""
+}
+
+// cronLine is the crontab line a task is installed as
+func cronLine(task ScheduledTask, home string, translated bool) (string, error) {
+	schedule := task.Schedule
+	switch {
+	case task.KeepAlive:
+		return "", errors.New("it is kept running, and cron only starts jobs on a schedule")
+	case task.Interval > 0:
+		var ok bool
+		if schedule, ok = intervalToCron(task.Interval); !ok {
+			return "", fmt.Errorf("it runs every %ds, which is not a whole fraction of an hour or a day", task.Interval)
+		}
+	case schedule == "":
+		return "", errors.New("it has no schedule")
+	}
+	line := schedule + " " + replaceHome(task.Command, home, false)
+	if translated {
+		line += " " + cronMarker + task.Name
+	}
+	return line, nil
+}
+
+// normalizeCronLine compares crontab lines ignoring spacing and markers
+func normalizeCronLine(line string) string {
+	if i := strings.Index(line, cronMarker); i >= 0 {
+		line = line[:i]
+	}
+	return strings.Join(strings.Fields(line), " ")
+}
+
+// installFile writes data to path unless it already has it, reporting
+// whether it changed
+func installFile(path string, data []byte, dryRun bool) (bool, error) {
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(path, data, 0644)
+}
+
+// ApplyTasks installs captured tasks into this machine's schedulers,
+// translating them between cron, launchd and systemd where needed
+func ApplyTasks(dir string, m *TaskManifest, dryRun bool) ([]taskResult, error) {
+	platform := DetectPlatform()
+	home := GetHomeDir(platform)
+	available := localSchedulers()
+
+	var results []taskResult
+	var cronLines []string
+	var enable []string
+	reload := false
+	failed := 0
+
+	for _, task := range m.Tasks {
+		target := taskTarget(task, available)
+		result := taskResult{Task: task, Target: target}
+		translated := target != task.Origin
+		fail := func(err error) {
+			result.Status = "failed: " + err.Error()
+			failed++
+		}
+
+		if target == "" {
+			result.Status = "not translated: no supported scheduler on " + platform
+			results = append(results, result)
+			continue
+		}
+		if translated && task.Schedule == "" && task.Interval == 0 && !task.KeepAlive {
+			reason := "it has no schedule"
+			if len(task.Notes) > 0 {
+				reason = task.Notes[0]
+			}
+			result.Status = "not translated: " + reason
+			results = append(results, result)
+			continue
+		}
+
+		var files map[string][]byte
+		var unit string
+		var err error
+		switch {
+		case target == schedulerCron:
+			var line string
+			if line, err = cronLine(task, home, translated); err == nil {
+				cronLines = append(cronLines, line)
+				result.Status = "cron"
+			}
+		case !translated:
+			files = map[string][]byte{}
+			for _, name := range task.Files {
+				data, readErr := os.ReadFile(filepath.Join(tasksDir(dir), filepath.FromSlash(name)))
+				if readErr != nil {
+					err = readErr
+					break
+				}
+				files[filepath.Base(name)] = []byte(replaceHome(string(data), home, false))
+				if unit == "" || strings.HasSuffix(name, ".timer") {
+					unit = filepath.Base(name)
+				}
+			}
+		case target == schedulerLaunchd:
+			var name string
+			var data []byte
+			if name, data, err = taskToLaunchAgent(task, home); err == nil {
+				files = map[string][]byte{name: data}
+				unit = name
+			}
+		case target == schedulerSystemd:
+			files, unit, err = taskToSystemdUnits(task, home)
+		}
+		switch {
+		case err != nil && translated:
+			result.Status = "not translated: " + err.Error()
+			results = append(results, result)
+			continue
+		case err != nil:
+			fail(err)
+			results = append(results, result)
+			continue
+		}
+
+		if files != nil {
+			installDir := systemdUserDir(home)
+			if target == schedulerLaunchd {
+				installDir = launchAgentsDir(home)
+			}
+			var names []string
+			for name := range files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			changed := false
+			for _, name := range names {
+				c, err := installFile(filepath.Join(installDir, name), files[name], dryRun)
+				if err != nil {
+					fail(err)
+					break
+				}
+				changed = changed || c
+			}
+			switch {
+			case strings.HasPrefix(result.Status, "failed"):
+			case !changed:
+				result.Status = "already installed"
+			default:
+				result.Status = "installed"
+				if target == schedulerSystemd {
+					reload = true
+					enable = append(enable, unit)
+				} else if !dryRun {
+					path := filepath.Join(installDir, unit)
+					exec.Command("launchctl", "unload", path).Run()
+					if out, err := exec.Command("launchctl", "load", "-w", path).CombinedOutput(); err != nil {
+						fail(fmt.Errorf("launchctl load: %v %s", err, strings.TrimSpace(string(out))))
+					}
+				}
+			}
+		}
+		results = append(results, result)
+	}
+
+	if len(cronLines) > 0 {
+		added, err := installCronLines(cronLines, m.Environment, home, dryRun)
+		for i := range results {
+			if results[i].Status != "cron" {
+				continue
+			}
+			line, _ := cronLine(results[i].Task, home, results[i].Target != results[i].Task.Origin)
+			switch {
+			case err != nil:
+				results[i].Status = "failed: " + err.Error()
+				failed++
+			case added[line]:
+				results[i].Status = "installed"
+			default:
+				results[i].Status = "already installed"
+			}
+		}
+	}
+
+	if reload && !dryRun {
+		if out, err := exec.Command("systemctl", "--user", "daemon-reload").CombinedOutput(); err != nil {
+			return results, fmt.Errorf("systemctl --user daemon-reload: %v %s", err, strings.TrimSpace(string(out)))
+		}
+		for _, unit := range enable {
+			if out, err := exec.Command("systemctl", "--user", "enable", "--now", unit).CombinedOutput(); err != nil {
+				errorColor.Printf("❌ systemctl --user enable %s failed: %v %s\n", unit, err, strings.TrimSpace(string(out)))
+				failed++
+			}
+		}
+	}
+
+	if dryRun {
+		for i := range results {
+			if results[i].Status == "installed" {
+				results[i].Status = "would install"
+			}
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d task(s) could not be installed", failed)
+	}
+	return results, nil
+}
+
+// installCronLines adds the lines the crontab does not have yet, after
+// any variables they need, and returns the lines added
+func installCronLines(lines []string, env map[string]string, home string, dryRun bool) (map[string]bool, error) {
+	current, err := readCrontab()
+	if err != nil {
+		return nil, err
+	}
+	have := map[string]bool{}
+	vars := map[string]string{}
+	for _, line := range strings.Split(string(current), "\n") {
+		have[normalizeCronLine(line)] = true
+		if m := cronEnvLine.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			vars[m[1]] = strings.Trim(m[2], `"'`)
+		}
+	}
+
+	added := map[string]bool{}
+	var add []string
+	for _, line := range lines {
+		if !have[normalizeCronLine(line)] {
+			have[normalizeCronLine(line)] = true
+			added[line] = true
+			add = append(add, line)
+		}
+	}
+	if len(add) == 0 || dryRun {
+		return added, nil
+	}
+
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	b.Write(current)
+	if len(current) > 0 && !bytes.HasSuffix(current, []byte("\n")) {
+		b.WriteByte('\n')
+	}
+	for _, name := range names {
+		value := replaceHome(env[name], home, false)
+		if have, ok := vars[name]; !ok || have != value {
+			fmt.Fprintf(&b, "%s=\"%s\"\n", name, value)
+		}
+	}
+	for _, line := range add {
+		b.WriteString(line + "\n")
+	}
+	cmd := exec.Command("crontab", "-")
+	cmd.Stdin = &b
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("crontab: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return added, nil
+}
+
+// printTaskReport shows where every task went and why some did not
+func printTaskReport(results []taskResult) {
+	infoColor.Println("📋 Scheduled tasks")
+	untranslated := 0
+	for _, r := range results {
+		target := r.Target
+		if target == "" || strings.HasPrefix(r.Status, "not translated") {
+			target = "—"
+			untranslated++
+		}
+		line := fmt.Sprintf("   %-28s %-8s → %-8s %s", r.Task.Name, r.Task.Origin, target, r.Status)
+		switch {
+		case strings.HasPrefix(r.Status, "failed"):
+			errorColor.Println(line)
+		case strings.HasPrefix(r.Status, "not translated"):
+			warnColor.Println(line)
+		default:
+			fmt.Println(line)
+		}
+		if r.Target != r.Task.Origin && !strings.HasPrefix(r.Status, "not translated") {
+			for _, note := range r.Task.Notes {
+				warnColor.Printf("      ⚠️  Partly translated: %s\n", note)
+			}
+		}
+	}
+	if untranslated > 0 {
+		warnColor.Printf("⚠️  %d task(s) could not be translated; recreate them by hand (commands are in tasks/tasks.yaml)\n", untranslated)
+	}
+}
+
+// runCaptureTasks handles `profilesync capture tasks`
+func runCaptureTasks(args []string) {
+	flags := newFlagSet("capture tasks", "capture tasks [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to write to")
+	flags.Parse(args)
+
+	if len(localSchedulers()) == 0 {
+		warnColor.Printf("⏭️  No crontab, launchd or systemd on %s; nothing to do\n", DetectPlatform())
+		return
+	}
+	manifest, notes, err := CaptureTasks(*dir)
+	if err != nil {
+		errorColor.Println("❌ Error capturing scheduled tasks:", err)
+		os.Exit(1)
+	}
+	for _, note := range notes {
+		warnColor.Println("⚠️ ", note)
+	}
+	counts := map[string]int{}
+	for _, task := range manifest.Tasks {
+		counts[task.Origin]++
+	}
+	successColor.Printf("✅ %d task(s) saved to %s (cron: %d, launchd: %d, systemd: %d)\n", len(manifest.Tasks), tasksManifestPath(*dir),
+		counts[schedulerCron], counts[schedulerLaunchd], counts[schedulerSystemd])
+}
+
+// runApplyTasks handles `profilesync apply tasks`
+func runApplyTasks(args []string) {
+	flags := newFlagSet("apply tasks", "apply tasks [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to read from")
+	dryRun := flags.Bool("dry-run", true, "Show where each task would be installed without installing it")
+	flags.Parse(args)
+
+	m, err := loadTaskManifest(*dir)
+	if err != nil {
+		errorColor.Println("❌ Error reading scheduled tasks (run `profilesync capture tasks` first):", err)
+		os.Exit(1)
+	}
+	noticeColor.Printf("⏰ Applying %d scheduled task(s) captured on %s (%s)\n", len(m.Tasks), m.Platform, m.CapturedAt.Format(time.RFC1123))
+	results, err := ApplyTasks(*dir, m, *dryRun)
+	printTaskReport(results)
+	if err != nil {
+		errorColor.Println("❌ Error applying scheduled tasks:", err)
+		os.Exit(1)
+	}
+	if *dryRun {
+		warnColor.Println("⚠️  This was a DRY RUN. Run with --dry-run=false to apply.")
+	}
+}
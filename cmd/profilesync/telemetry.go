@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobRecord is a record of the structured log of a daemon job, with the
+// fields the daemon's metrics and traces use
+type jobRecord struct {
+	Time          time.Time `json:"time"`
+	Msg           string    `json:"msg"`
+	ID            string    `json:"id"`
+	Description   string    `json:"description"`
+	Status        string    `json:"status"`
+	Detail        string    `json:"detail"`
+	File          string    `json:"file"`
+	Action        string    `json:"action"`
+	Error         string    `json:"error"`
+	DurationMS    int64     `json:"duration_ms"`
+	BytesCopied   int64     `json:"bytes_copied"`
+	BytesSent     int64     `json:"bytes_sent"`
+	BytesReceived int64     `json:"bytes_received"`
+}
+
+// readJobLog reads the records a job logged, skipping lines that are not
+// JSON records
+func readJobLog(path string) []jobRecord {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var records []jobRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var r jobRecord
+		if json.Unmarshal(scanner.Bytes(), &r) == nil && r.Msg != "" {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// jobCommand is the profilesync command a job ran
+func jobCommand(job jobInfo) string {
+	if len(job.Args) == 0 {
+		return "apply"
+	}
+	return job.Args[0]
+}
+
+// daemonMetrics counts what the daemon's jobs did, for GET /metrics
+type daemonMetrics struct {
+	started time.Time
+
+	mu           sync.Mutex
+	jobs         map[[3]string]int64 // command, trigger, result
+	items        map[[2]string]int64 // command, status
+	bytes        map[string]int64    // direction
+	failures     map[string]int64    // command
+	lastSuccess  map[string]time.Time
+	lastDuration map[string]time.Duration
+}
+
+// newDaemonMetrics returns empty metrics
+func newDaemonMetrics() *daemonMetrics {
+	return &daemonMetrics{
+		started:      time.Now(),
+		jobs:         map[[3]string]int64{},
+		items:        map[[2]string]int64{},
+		bytes:        map[string]int64{},
+		failures:     map[string]int64{},
+		lastSuccess:  map[string]time.Time{},
+		lastDuration: map[string]time.Duration{},
+	}
+}
+
+// record adds a finished job and what it logged
+func (m *daemonMetrics) record(job jobInfo, records []jobRecord) {
+	command := jobCommand(job)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[[3]string{command, job.Trigger, job.State}]++
+	if job.Finished != nil {
+		m.lastDuration[command] = job.Finished.Sub(job.Started)
+		if job.State == jobSucceeded {
+			m.lastSuccess[command] = *job.Finished
+		}
+	}
+	if job.State != jobSucceeded {
+		m.failures[command]++
+	}
+	for _, r := range records {
+		switch r.Msg {
+		case "item":
+			m.items[[2]string{command, r.Status}]++
+		case "file":
+			status := r.Action
+			if r.Error != "" {
+				status = itemFailed
+			}
+			m.items[[2]string{command, status}]++
+		case "migration finished", "migration failed", "migration interrupted":
+			m.bytes["copied"] += r.BytesCopied
+		case "sync finished":
+			m.bytes["sent"] += r.BytesSent
+			m.bytes["received"] += r.BytesReceived
+		}
+	}
+}
+
+// metricsWriter writes metrics in the Prometheus text format
+type metricsWriter struct {
+	w io.Writer
+}
+
+// family starts a metric with its help and type
+func (mw metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one value, with labels as name and value pairs
+func (mw metricsWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(mw.w, "%s %s\n", b.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// escapeLabel escapes a label value as the text format requires
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[K interface {
+	~string | ~[2]string | ~[3]string
+}, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+	return keys
+}
+
+// write writes every metric, with running, the number of jobs running now
+func (m *daemonMetrics) write(w io.Writer, running int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mw := metricsWriter{w}
+
+	mw.family("profilesync_daemon_start_time_seconds", "gauge", "When the daemon started, in seconds since the epoch.")
+	mw.sample("profilesync_daemon_start_time_seconds", float64(m.started.Unix()), "machine", machineName(), "platform", DetectPlatform())
+	mw.family("profilesync_jobs_running", "gauge", "Jobs running now.")
+	mw.sample("profilesync_jobs_running", float64(running))
+
+	mw.family("profilesync_jobs_total", "counter", "Commands the daemon ran, by how they ended.")
+	for _, k := range sortedKeys(m.jobs) {
+		mw.sample("profilesync_jobs_total", float64(m.jobs[k]), "command", k[0], "trigger", k[1], "result", k[2])
+	}
+	mw.family("profilesync_failures_total", "counter", "Commands that failed.")
+	for _, k := range sortedKeys(m.failures) {
+		mw.sample("profilesync_failures_total", float64(m.failures[k]), "command", k)
+	}
+	mw.family("profilesync_items_total", "counter", "Items applied and files synced, by outcome.")
+	for _, k := range sortedKeys(m.items) {
+		mw.sample("profilesync_items_total", float64(m.items[k]), "command", k[0], "status", k[1])
+	}
+	mw.family("profilesync_bytes_transferred_total", "counter", "Bytes copied by applies, and sent or received by syncs.")
+	for _, k := range sortedKeys(m.bytes) {
+		mw.sample("profilesync_bytes_transferred_total", float64(m.bytes[k]), "direction", k)
+	}
+	mw.family("profilesync_last_success_timestamp_seconds", "gauge", "When each command last succeeded, in seconds since the epoch.")
+	for _, k := range sortedKeys(m.lastSuccess) {
+		mw.sample("profilesync_last_success_timestamp_seconds", float64(m.lastSuccess[k].Unix()), "command", k)
+	}
+	mw.family("profilesync_last_duration_seconds", "gauge", "How long the last run of each command took.")
+	for _, k := range sortedKeys(m.lastDuration) {
+		mw.sample("profilesync_last_duration_seconds", m.lastDuration[k].Seconds(), "command", k)
+	}
+}
+
+// handleMetrics answers GET /metrics in the Prometheus text format
+func (d *daemon) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	running := 0
+	d.mu.Lock()
+	for _, j := range d.jobs {
+		if info, _, _ := j.snapshot(0); info.State == jobRunning {
+			running++
+		}
+	}
+	d.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	d.metrics.write(w, running)
+}
+
+// traceExporter sends a trace of each job to an OpenTelemetry collector,
+// over OTLP/HTTP with JSON encoding
+type traceExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// newTraceExporter returns an exporter to the collector at endpoint, the
+// base URL as in $OTEL_EXPORTER_OTLP_ENDPOINT, or nil without one.
+// $OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, the full URL, wins when set, and
+// $OTEL_EXPORTER_OTLP_HEADERS adds headers such as an API key
+func newTraceExporter(endpoint string) (*traceExporter, error) {
+	target := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if target == "" && endpoint != "" {
+		target = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	if target == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", target)
+	}
+	e := &traceExporter{url: target, headers: map[string]string{}, client: &http.Client{Timeout: 10 * time.Second}}
+	for _, pair := range splitList(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q", pair)
+		}
+		if decoded, err := url.QueryUnescape(value); err == nil {
+			value = decoded
+		}
+		e.headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return e, nil
+}
+
+// OTLP span kinds and status codes
+const (
+	otlpSpanInternal = 1
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+// otlpAttr is an OTLP key-value attribute
+type otlpAttr struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+// attr makes an attribute of a string or an integer
+func attr(key string, value any) otlpAttr {
+	switch v := value.(type) {
+	case int:
+		return otlpAttr{key, map[string]any{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpAttr{key, map[string]any{"intValue": strconv.FormatInt(v, 10)}}
+	}
+	return otlpAttr{key, map[string]any{"stringValue": fmt.Sprint(value)}}
+}
+
+// otlpEvent is an event during a span
+type otlpEvent struct {
+	Time       string     `json:"timeUnixNano"`
+	Name       string     `json:"name"`
+	Attributes []otlpAttr `json:"attributes,omitempty"`
+}
+
+// otlpStatus is how a span ended
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlpSpan is one span of a trace
+type otlpSpan struct {
+	TraceID    string      `json:"traceId"`
+	SpanID     string      `json:"spanId"`
+	ParentID   string      `json:"parentSpanId,omitempty"`
+	Name       string      `json:"name"`
+	Kind       int         `json:"kind"`
+	Start      string      `json:"startTimeUnixNano"`
+	End        string      `json:"endTimeUnixNano"`
+	Attributes []otlpAttr  `json:"attributes,omitempty"`
+	Events     []otlpEvent `json:"events,omitempty"`
+	Status     otlpStatus  `json:"status"`
+}
+
+// unixNano formats a time as OTLP JSON does
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// randomID returns n random bytes in hex, for trace and span IDs
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobSpans makes the spans of a finished job: one for the job, and one for
+// each item an apply migrated, with the files a sync transferred as events
+func jobSpans(job jobInfo, records []jobRecord) []otlpSpan {
+	finished := time.Now()
+	if job.Finished != nil {
+		finished = *job.Finished
+	}
+	root := otlpSpan{
+		TraceID: randomID(16),
+		SpanID:  randomID(8),
+		Name:    "profilesync " + jobCommand(job),
+		Kind:    otlpSpanInternal,
+		Start:   unixNano(job.Started),
+		End:     unixNano(finished),
+		Attributes: []otlpAttr{
+			attr("profilesync.job.id", job.ID),
+			attr("profilesync.job.trigger", job.Trigger),
+			attr("profilesync.job.args", strings.Join(job.Args, " ")),
+			attr("process.exit.code", job.ExitCode),
+		},
+		Status: otlpStatus{Code: otlpStatusOK},
+	}
+	if job.State != jobSucceeded {
+		root.Status = otlpStatus{Code: otlpStatusError, Message: fmt.Sprintf("exit code %d", job.ExitCode)}
+	}
+
+	spans := []otlpSpan{root}
+	for _, r := range records {
+		switch r.Msg {
+		case "item":
+			span := otlpSpan{
+				TraceID:  root.TraceID,
+				SpanID:   randomID(8),
+				ParentID: root.SpanID,
+				Name:     "item " + r.Description,
+				Kind:     otlpSpanInternal,
+				Start:    unixNano(r.Time.Add(-time.Duration(r.DurationMS) * time.Millisecond)),
+				End:      unixNano(r.Time),
+				Attributes: []otlpAttr{
+					attr("profilesync.item.id", r.ID),
+					attr("profilesync.item.status", r.Status),
+				},
+				Status: otlpStatus{Code: otlpStatusOK},
+			}
+			if r.Status == itemFailed {
+				span.Status = otlpStatus{Code: otlpStatusError, Message: r.Detail}
+			}
+			spans = append(spans, span)
+		case "file":
+			attrs := []otlpAttr{attr("profilesync.file", r.File), attr("profilesync.action", r.Action)}
+			if r.Error != "" {
+				attrs = append(attrs, attr("exception.message", r.Error))
+			}
+			spans[0].Events = append(spans[0].Events, otlpEvent{Time: unixNano(r.Time), Name: "file", Attributes: attrs})
+		}
+	}
+	return spans
+}
+
+// export sends the trace of a finished job
+func (e *traceExporter) export(ctx context.Context, job jobInfo, records []jobRecord) error {
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []otlpAttr{
+				attr("service.name", "profilesync"),
+				attr("host.name", machineName()),
+				attr("os.type", DetectPlatform()),
+			}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "profilesync"},
+				"spans": jobSpans(job, records),
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	return defaultRetry.do(ctx, "Exporting the trace of job "+job.ID, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for key, value := range e.headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := e.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode/100 != 2 {
+			return &httpStatusError{url: e.url, code: resp.StatusCode, status: resp.Status}
+		}
+		return nil
+	})
+}
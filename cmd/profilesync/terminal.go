@@ -0,0 +1,394 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// terminalTheme is a terminal profile in a form every format converts to
+// and from. Colors are "#rrggbb"
+type terminalTheme struct {
+	Name       string
+	FontFamily string
+	FontSize   float64
+	Foreground string
+	Background string
+	Cursor     string
+	Selection  string
+	Palette    [16]string
+	Keys       []terminalKey
+}
+
+// terminalKey is a key binding. Key uses kitty's key names, Mods is a
+// sorted subset of ctrl, shift, alt and super, and Action is one of
+// terminalActions or "send_text"
+type terminalKey struct {
+	Key    string
+	Mods   []string
+	Action string
+	Text   string
+}
+
+// String formats the binding's keys for messages
+func (k terminalKey) String() string {
+	return strings.Join(append(append([]string{}, k.Mods...), k.Key), "+")
+}
+
+// terminalFormat reads and writes one terminal's configuration
+type terminalFormat struct {
+	// Input and Output are the default paths on a platform; Input is empty
+	// when the format can only be written
+	Input  func(platform, home string) string
+	Output func(platform, home string) string
+	// Read returns the theme of the named profile, or the default one
+	Read func(data []byte, path, profile string) (*terminalTheme, []string, error)
+	// Write renders the theme, merging it into existing when the output is
+	// the terminal's own settings file
+	Write func(theme *terminalTheme, existing []byte) ([]byte, []string, error)
+	// Hint explains how to load the written file, given its path
+	Hint string
+}
+
+// terminalFormats are the terminals themes convert between
+var terminalFormats = map[string]terminalFormat{
+	"iterm2": {
+		Input:  terminalPath("${HOME}/Library/Preferences/com.googlecode.iterm2.plist"),
+		Output: terminalPath("${HOME}/Library/Application Support/iTerm2/DynamicProfiles/profilesync.json"),
+		Read:   readITermTheme,
+		Write:  writeITermTheme,
+		Hint:   "iTerm2 loads dynamic profiles by itself; pick the profile in Settings → Profiles",
+	},
+	"windows-terminal": {
+		Input:  terminalPath(windowsTerminalSettings),
+		Output: terminalPath(windowsTerminalSettings),
+		Read:   readWindowsTerminalTheme,
+		Write:  writeWindowsTerminalTheme,
+	},
+	"alacritty": {
+		Input:  alacrittyInput,
+		Output: terminalPath("${XDG_CONFIG_HOME}/alacritty/profilesync.toml"),
+		Read:   readAlacrittyTheme,
+		Write:  writeAlacrittyTheme,
+		Hint:   `Add "%s" to the import list under [general] in alacritty.toml`,
+	},
+	"kitty": {
+		Input:  terminalPath("${XDG_CONFIG_HOME}/kitty/kitty.conf"),
+		Output: terminalPath("${XDG_CONFIG_HOME}/kitty/profilesync.conf"),
+		Read:   readKittyTheme,
+		Write:  writeKittyTheme,
+		Hint:   "Add `include %s` to kitty.conf",
+	},
+	"wezterm": {
+		Output: terminalPath("${XDG_CONFIG_HOME}/wezterm/profilesync.lua"),
+		Write:  writeWezTermTheme,
+		Hint:   "Call `require('profilesync')(config)` in wezterm.lua before returning config (loaded from %s)",
+	},
+}
+
+// windowsTerminalSettings is where the Store build of Windows Terminal keeps its settings
+const windowsTerminalSettings = "${LOCALAPPDATA}/Packages/Microsoft.WindowsTerminal_8wekyb3d8bbwe/LocalState/settings.json"
+
+// terminalPath resolves a mapping-style path for a platform
+func terminalPath(mapping string) func(platform, home string) string {
+	return func(platform, home string) string {
+		path, _ := resolveMappingPath(home, mapping, platform)
+		return path
+	}
+}
+
+// alacrittyInput prefers alacritty.toml, falling back to the YAML config
+// used before Alacritty 0.13
+func alacrittyInput(platform, home string) string {
+	toml := terminalPath("${XDG_CONFIG_HOME}/alacritty/alacritty.toml")(platform, home)
+	yml := strings.TrimSuffix(toml, ".toml") + ".yml"
+	if _, err := os.Stat(toml); err != nil {
+		if _, err := os.Stat(yml); err == nil {
+			return yml
+		}
+	}
+	return toml
+}
+
+// terminalFormatNames lists the formats for messages
+func terminalFormatNames() string {
+	names := make([]string, 0, len(terminalFormats))
+	for name := range terminalFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// terminalActions maps the actions key bindings can run onto each format's
+// own name for them. A missing entry means the terminal has no such action
+var terminalActions = map[string]map[string]string{
+	"copy":               {"kitty": "copy_to_clipboard", "alacritty": "Copy", "windows-terminal": "copy", "wezterm": "act.CopyTo 'Clipboard'"},
+	"paste":              {"kitty": "paste_from_clipboard", "alacritty": "Paste", "windows-terminal": "paste", "wezterm": "act.PasteFrom 'Clipboard'"},
+	"new_tab":            {"kitty": "new_tab", "alacritty": "CreateNewTab", "windows-terminal": "newTab", "wezterm": "act.SpawnTab 'CurrentPaneDomain'"},
+	"close_tab":          {"kitty": "close_tab", "windows-terminal": "closeTab", "wezterm": "act.CloseCurrentTab { confirm = true }"},
+	"next_tab":           {"kitty": "next_tab", "alacritty": "SelectNextTab", "windows-terminal": "nextTab", "wezterm": "act.ActivateTabRelative(1)"},
+	"previous_tab":       {"kitty": "previous_tab", "alacritty": "SelectPreviousTab", "windows-terminal": "prevTab", "wezterm": "act.ActivateTabRelative(-1)"},
+	"new_window":         {"kitty": "new_os_window", "alacritty": "CreateNewWindow", "windows-terminal": "newWindow", "wezterm": "act.SpawnWindow"},
+	"increase_font_size": {"kitty": "change_font_size all +1.0", "alacritty": "IncreaseFontSize", "windows-terminal": "adjustFontSize +1", "wezterm": "act.IncreaseFontSize"},
+	"decrease_font_size": {"kitty": "change_font_size all -1.0", "alacritty": "DecreaseFontSize", "windows-terminal": "adjustFontSize -1", "wezterm": "act.DecreaseFontSize"},
+	"reset_font_size":    {"kitty": "change_font_size all 0", "alacritty": "ResetFontSize", "windows-terminal": "resetFontSize", "wezterm": "act.ResetFontSize"},
+	"toggle_fullscreen":  {"kitty": "toggle_fullscreen", "alacritty": "ToggleFullscreen", "windows-terminal": "toggleFullscreen", "wezterm": "act.ToggleFullScreen"},
+}
+
+// terminalAction finds the neutral action for a format's action name
+func terminalAction(format, name string) string {
+	for action, names := range terminalActions {
+		if n, ok := names[format]; ok && strings.EqualFold(n, name) {
+			return action
+		}
+	}
+	return ""
+}
+
+// terminalKeyNames are the key names that differ from kitty's, by format
+var terminalKeyNames = map[string]map[string]string{
+	"alacritty": {
+		"tab": "Tab", "enter": "Return", "escape": "Escape", "space": "Space", "backspace": "Back",
+		"delete": "Delete", "insert": "Insert", "left": "Left", "right": "Right", "up": "Up", "down": "Down",
+		"home": "Home", "end": "End", "page_up": "PageUp", "page_down": "PageDown",
+		"plus": "Plus", "minus": "Minus", "equal": "Equals", "comma": "Comma", "period": "Period", "slash": "Slash",
+	},
+	"windows-terminal": {
+		"escape": "esc", "page_up": "pgup", "page_down": "pgdn", "equal": "=", "comma": ",", "period": ".", "slash": "/",
+	},
+	"wezterm": {
+		"tab": "Tab", "enter": "Enter", "escape": "Escape", "space": "Space", "backspace": "Backspace",
+		"delete": "Delete", "insert": "Insert", "left": "LeftArrow", "right": "RightArrow", "up": "UpArrow", "down": "DownArrow",
+		"home": "Home", "end": "End", "page_up": "PageUp", "page_down": "PageDown",
+		"plus": "+", "minus": "-", "equal": "=", "comma": ",", "period": ".", "slash": "/",
+	},
+}
+
+// formatTerminalKey renders a key name for a format
+func formatTerminalKey(format, key string) string {
+	if name, ok := terminalKeyNames[format][key]; ok {
+		return name
+	}
+	upper := format == "alacritty" || format == "wezterm"
+	if upper && len(key) > 1 && key[0] == 'f' {
+		return strings.ToUpper(key)
+	}
+	if format == "alacritty" {
+		return strings.ToUpper(key)
+	}
+	return key
+}
+
+// parseTerminalKey turns a format's key name into kitty's
+func parseTerminalKey(format, name string) string {
+	for key, n := range terminalKeyNames[format] {
+		if strings.EqualFold(n, name) {
+			return key
+		}
+	}
+	name = strings.ToLower(name)
+	if format == "alacritty" {
+		name = strings.TrimPrefix(name, "key")
+	}
+	return name
+}
+
+// terminalModNames are each format's names for ctrl, shift, alt and super,
+// and the separator between modifiers
+var terminalModNames = map[string][5]string{
+	"kitty":            {"ctrl", "shift", "alt", "super", "+"},
+	"windows-terminal": {"ctrl", "shift", "alt", "win", "+"},
+	"alacritty":        {"Control", "Shift", "Alt", "Super", "|"},
+	"wezterm":          {"CTRL", "SHIFT", "ALT", "SUPER", "|"},
+}
+
+// terminalModAliases are other names formats accept for the modifiers
+var terminalModAliases = map[string]string{
+	"control": "ctrl", "ctl": "ctrl", "opt": "alt", "option": "alt", "meta": "alt",
+	"cmd": "super", "command": "super", "win": "super",
+}
+
+// parseTerminalMods splits a format's modifiers into sorted neutral names
+func parseTerminalMods(format string, mods []string) []string {
+	names := terminalModNames[format]
+	var out []string
+	for _, mod := range mods {
+		mod = strings.ToLower(strings.TrimSpace(mod))
+		if alias, ok := terminalModAliases[mod]; ok {
+			mod = alias
+		}
+		for i, neutral := range []string{"ctrl", "shift", "alt", "super"} {
+			if mod == neutral || mod == strings.ToLower(names[i]) {
+				out = append(out, neutral)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// formatTerminalMods renders neutral modifiers for a format
+func formatTerminalMods(format string, mods []string) string {
+	names := terminalModNames[format]
+	var out []string
+	for _, mod := range mods {
+		for i, neutral := range []string{"ctrl", "shift", "alt", "super"} {
+			if mod == neutral {
+				out = append(out, names[i])
+			}
+		}
+	}
+	return strings.Join(out, names[4])
+}
+
+// parseTerminalColor accepts "#rgb", "#rrggbb" and "0xrrggbb"
+func parseTerminalColor(value string) (string, bool) {
+	value = strings.ToLower(strings.Trim(strings.TrimSpace(value), `"'`))
+	if strings.HasPrefix(value, "0x") {
+		value = "#" + value[2:]
+	}
+	if !strings.HasPrefix(value, "#") {
+		return "", false
+	}
+	hex := value[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(hex, 16, 32); err != nil {
+		return "", false
+	}
+	return "#" + hex, true
+}
+
+// runConvert handles `profilesync convert`
+func runConvert(args []string) {
+	if len(args) == 0 {
+		errorColor.Println("❌ Usage: profilesync convert terminal [flags]")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "terminal":
+		runConvertTerminal(args[1:])
+	default:
+		errorColor.Println("❌ Unknown convert target:", args[0])
+		errorColor.Println("Must be one of: terminal")
+		os.Exit(2)
+	}
+}
+
+// runConvertTerminal handles `profilesync convert terminal`
+func runConvertTerminal(args []string) {
+	flags := newFlagSet("convert terminal", "convert terminal --from FORMAT --to FORMAT [flags]")
+	from := flags.String("from", "", "Terminal to convert from ("+terminalFormatNames()+")")
+	to := flags.String("to", "", "Terminal to convert to ("+terminalFormatNames()+")")
+	input := flags.String("input", "", "Config to read (default: the terminal's own config)")
+	output := flags.String("output", "", "File to write, or - for stdout (default: a profilesync file in the terminal's config directory)")
+	profile := flags.String("profile", "", "Profile to convert when the config has several (default: the default profile)")
+	dryRun := flags.Bool("dry-run", true, "Show what would be written without writing it")
+	flags.Parse(args)
+
+	source, ok := terminalFormats[*from]
+	if !ok || source.Read == nil {
+		errorColor.Println("❌ Invalid --from value:", *from)
+		errorColor.Println("Must be one of: iterm2, windows-terminal, alacritty, kitty (WezTerm configs are Lua programs and can only be written)")
+		os.Exit(2)
+	}
+	target, ok := terminalFormats[*to]
+	if !ok {
+		errorColor.Println("❌ Invalid --to value:", *to)
+		errorColor.Println("Must be one of:", terminalFormatNames())
+		os.Exit(2)
+	}
+
+	platform := DetectPlatform()
+	home := GetHomeDir(platform)
+	if *input == "" {
+		*input = source.Input(platform, home)
+	}
+	if *output == "" {
+		*output = target.Output(platform, home)
+	}
+
+	data, err := os.ReadFile(*input)
+	if err != nil {
+		errorColor.Println("❌ Error reading terminal config:", err)
+		os.Exit(1)
+	}
+	theme, notes, err := source.Read(data, *input, *profile)
+	if err != nil {
+		errorColor.Printf("❌ Error reading %s: %v\n", *input, err)
+		os.Exit(1)
+	}
+
+	var existing []byte
+	if *output != "-" {
+		existing, _ = os.ReadFile(*output)
+	}
+	rendered, writeNotes, err := target.Write(theme, existing)
+	if err != nil {
+		errorColor.Println("❌ Error converting terminal config:", err)
+		os.Exit(1)
+	}
+	notes = append(notes, writeNotes...)
+	if err := checkTransformed(configFormat(target.Output(platform, home)), *output, rendered, "the conversion to "+*to); err != nil {
+		errorColor.Println("❌ Error converting terminal config:", err)
+		os.Exit(1)
+	}
+
+	// Keep stdout for the config itself when writing there
+	summary := outputWriter(os.Stdout)
+	if *output == "-" {
+		summary = outputWriter(os.Stderr)
+	}
+	infoColor.Fprintf(summary, "🖥️  %s → %s: profile %q\n", *from, *to, theme.Name)
+	colors := 0
+	for _, c := range theme.Palette {
+		if c != "" {
+			colors++
+		}
+	}
+	font := "none"
+	if theme.FontFamily != "" {
+		font = fmt.Sprintf("%s %g", theme.FontFamily, theme.FontSize)
+	}
+	fmt.Fprintf(summary, "   Font: %s, palette: %d/16 colors, key bindings: %d\n", font, colors, len(theme.Keys))
+	for _, note := range notes {
+		warnColor.Fprintln(summary, "   ⚠️ ", note)
+	}
+
+	switch {
+	case *output == "-":
+		os.Stdout.Write(rendered)
+	case *dryRun:
+		warnColor.Printf("⚠️  DRY RUN: would write %s. Run with --dry-run=false to write it.\n", *output)
+	default:
+		if existing != nil {
+			backup, err := backupFile(*output)
+			if err != nil {
+				errorColor.Println("❌ Error backing up terminal config:", err)
+				os.Exit(1)
+			}
+			noticeColor.Printf("💾 Backed up %s to %s\n", *output, backup)
+		}
+		if err := os.MkdirAll(filepath.Dir(*output), 0755); err != nil {
+			errorColor.Println("❌ Error writing terminal config:", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(*output, rendered, 0644); err != nil {
+			errorColor.Println("❌ Error writing terminal config:", err)
+			os.Exit(1)
+		}
+		successColor.Printf("✅ Wrote %s\n", *output)
+		if target.Hint != "" {
+			noticeColor.Println("💡 " + strings.ReplaceAll(target.Hint, "%s", *output))
+		}
+	}
+}
@@ -0,0 +1,1045 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// terminalPaletteNames are the 16 ANSI colors as most configs name them
+var terminalPaletteNames = [8]string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// lookup walks nested maps decoded from JSON, YAML, TOML or a plist
+func lookup(v interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// lookupString is lookup for string values
+func lookupString(v interface{}, path ...string) string {
+	s, _ := lookup(v, path...).(string)
+	return s
+}
+
+// lookupFloat is lookup for numbers, however they were decoded
+func lookupFloat(v interface{}, path ...string) float64 {
+	switch n := lookup(v, path...).(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
+
+// --- iTerm2 ---
+
+// itermKeyCodes are iTerm2's codes for keys without a printable character
+var itermKeyCodes = map[int]string{
+	0x09: "tab", 0x0d: "enter", 0x1b: "escape", 0x20: "space", 0x7f: "backspace",
+	0xf700: "up", 0xf701: "down", 0xf702: "left", 0xf703: "right",
+	0xf727: "insert", 0xf728: "delete", 0xf729: "home", 0xf72b: "end", 0xf72c: "page_up", 0xf72d: "page_down",
+	'+': "plus", '-': "minus", '=': "equal", ',': "comma", '.': "period", '/': "slash",
+}
+
+// itermModMasks are iTerm2's modifier flags
+var itermModMasks = map[string]int{"shift": 0x20000, "ctrl": 0x40000, "alt": 0x80000, "super": 0x100000}
+
+// itermFontStyles are PostScript name suffixes dropped to get a family name
+var itermFontStyles = regexp.MustCompile(`-(Regular|Retina|Book|Medium|Light|Normal)$`)
+
+// parsePlist decodes an XML property list. Binary plists are converted with
+// plutil where it is available
+func parsePlist(data []byte, path string) (interface{}, error) {
+	if bytes.HasPrefix(data, []byte("bplist")) {
+		out, err := exec.Command("plutil", "-convert", "xml1", "-o", "-", path).Output()
+		if err != nil {
+			return nil, errors.New("binary plist; convert it with `plutil -convert xml1` on a Mac first")
+		}
+		data = out
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("not a property list: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local != "plist" {
+			return parsePlistValue(dec, start)
+		}
+	}
+}
+
+// parsePlistValue decodes the plist element that start opens
+func parsePlistValue(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict", "array":
+		dict := map[string]interface{}{}
+		var array []interface{}
+		var key string
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := dec.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				v, err := parsePlistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				if start.Name.Local == "dict" {
+					dict[key] = v
+				} else {
+					array = append(array, v)
+				}
+			case xml.EndElement:
+				if start.Name.Local == "dict" {
+					return dict, nil
+				}
+				return array, nil
+			}
+		}
+	case "true", "false":
+		return start.Name.Local == "true", dec.Skip()
+	}
+
+	var text string
+	if err := dec.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "integer":
+		return strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	case "real":
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	case "date":
+		return time.Parse(time.RFC3339, strings.TrimSpace(text))
+	case "data":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	}
+	return text, nil
+}
+
+// readITermTheme reads a profile from iTerm2's preferences, a dynamic
+// profile or an .itermcolors file
+func readITermTheme(data []byte, path, profile string) (*terminalTheme, []string, error) {
+	var root interface{}
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(data, &root)
+	} else {
+		root, err = parsePlist(data, path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var profiles []interface{}
+	switch {
+	case lookup(root, "New Bookmarks") != nil:
+		profiles, _ = lookup(root, "New Bookmarks").([]interface{})
+	case lookup(root, "Profiles") != nil:
+		profiles, _ = lookup(root, "Profiles").([]interface{})
+	case lookup(root, "Ansi 0 Color") != nil:
+		profiles = []interface{}{root}
+	}
+	if len(profiles) == 0 {
+		return nil, nil, errors.New("no iTerm2 profiles found")
+	}
+
+	p := profiles[0]
+	defaultGUID := lookupString(root, "Default Bookmark Guid")
+	for _, candidate := range profiles {
+		name := lookupString(candidate, "Name")
+		if (profile != "" && name == profile) || (profile == "" && defaultGUID != "" && lookupString(candidate, "Guid") == defaultGUID) {
+			p = candidate
+			break
+		}
+	}
+	if profile != "" && lookupString(p, "Name") != profile {
+		return nil, nil, fmt.Errorf("no iTerm2 profile named %q", profile)
+	}
+
+	theme := &terminalTheme{Name: lookupString(p, "Name")}
+	if theme.Name == "" {
+		theme.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if font := lookupString(p, "Normal Font"); font != "" {
+		if i := strings.LastIndex(font, " "); i > 0 {
+			theme.FontSize, _ = strconv.ParseFloat(font[i+1:], 64)
+			font = font[:i]
+		}
+		theme.FontFamily = itermFontStyles.ReplaceAllString(font, "")
+	}
+	theme.Foreground = itermColor(lookup(p, "Foreground Color"))
+	theme.Background = itermColor(lookup(p, "Background Color"))
+	theme.Cursor = itermColor(lookup(p, "Cursor Color"))
+	theme.Selection = itermColor(lookup(p, "Selection Color"))
+	for i := range theme.Palette {
+		theme.Palette[i] = itermColor(lookup(p, fmt.Sprintf("Ansi %d Color", i)))
+	}
+
+	var notes []string
+	keymap, _ := lookup(p, "Keyboard Map").(map[string]interface{})
+	combos := make([]string, 0, len(keymap))
+	for combo := range keymap {
+		combos = append(combos, combo)
+	}
+	sort.Strings(combos)
+	for _, combo := range combos {
+		binding := keymap[combo]
+		key, ok := parseITermKey(combo)
+		if !ok {
+			notes = append(notes, fmt.Sprintf("iTerm2 key %s not converted", combo))
+			continue
+		}
+		text := lookupString(binding, "Text")
+		switch int(lookupFloat(binding, "Action")) {
+		case 10:
+			key.Action, key.Text = "send_text", "\x1b"+text
+		case 11:
+			key.Action, key.Text = "send_text", parseITermHex(text)
+		case 12:
+			key.Action, key.Text = "send_text", text
+		default:
+			notes = append(notes, fmt.Sprintf("iTerm2 action %v on %s not converted", lookup(binding, "Action"), key))
+			continue
+		}
+		theme.Keys = append(theme.Keys, key)
+	}
+	return theme, notes, nil
+}
+
+// itermColor converts an iTerm2 color dictionary
+func itermColor(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	component := func(name string) int {
+		return int(math.Round(math.Max(0, math.Min(1, lookupFloat(v, name+" Component"))) * 255))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", component("Red"), component("Green"), component("Blue"))
+}
+
+// itermColorDict converts a "#rrggbb" color into an iTerm2 color dictionary
+func itermColorDict(color string) map[string]interface{} {
+	n, _ := strconv.ParseUint(strings.TrimPrefix(color, "#"), 16, 32)
+	return map[string]interface{}{
+		"Red Component":   float64(n>>16&0xff) / 255,
+		"Green Component": float64(n>>8&0xff) / 255,
+		"Blue Component":  float64(n&0xff) / 255,
+		"Alpha Component": 1,
+		"Color Space":     "sRGB",
+	}
+}
+
+// parseITermKey decodes an iTerm2 key combination such as "0x63-0x40000"
+func parseITermKey(combo string) (terminalKey, bool) {
+	parts := strings.Split(combo, "-")
+	if len(parts) < 2 {
+		return terminalKey{}, false
+	}
+	code, err1 := strconv.ParseUint(strings.TrimPrefix(parts[0], "0x"), 16, 32)
+	flags, err2 := strconv.ParseUint(strings.TrimPrefix(parts[1], "0x"), 16, 32)
+	if err1 != nil || err2 != nil {
+		return terminalKey{}, false
+	}
+
+	var key terminalKey
+	switch name, ok := itermKeyCodes[int(code)]; {
+	case ok:
+		key.Key = name
+	case code >= 0xf704 && code <= 0xf70f:
+		key.Key = fmt.Sprintf("f%d", code-0xf704+1)
+	case code > 0x20 && code < 0x7f:
+		key.Key = strings.ToLower(string(rune(code)))
+	default:
+		return terminalKey{}, false
+	}
+	for mod, mask := range itermModMasks {
+		if int(flags)&mask != 0 {
+			key.Mods = append(key.Mods, mod)
+		}
+	}
+	sort.Strings(key.Mods)
+	return key, true
+}
+
+// formatITermKey encodes a key combination for iTerm2
+func formatITermKey(key terminalKey) (string, bool) {
+	code := -1
+	for c, name := range itermKeyCodes {
+		if name == key.Key {
+			code = c
+		}
+	}
+	switch {
+	case code >= 0:
+	case len(key.Key) == 1:
+		code = int(key.Key[0])
+	case len(key.Key) > 1 && key.Key[0] == 'f':
+		n, err := strconv.Atoi(key.Key[1:])
+		if err != nil || n < 1 || n > 12 {
+			return "", false
+		}
+		code = 0xf704 + n - 1
+	default:
+		return "", false
+	}
+	flags := 0
+	for _, mod := range key.Mods {
+		flags |= itermModMasks[mod]
+	}
+	return fmt.Sprintf("0x%x-0x%x", code, flags), true
+}
+
+// parseITermHex decodes the "0x1b 0x5b" byte lists iTerm2 sends as hex codes
+func parseITermHex(text string) string {
+	var out []byte
+	for _, field := range strings.Fields(text) {
+		if n, err := strconv.ParseUint(strings.TrimPrefix(field, "0x"), 16, 8); err == nil {
+			out = append(out, byte(n))
+		}
+	}
+	return string(out)
+}
+
+// writeITermTheme renders the theme as an iTerm2 dynamic profile
+func writeITermTheme(theme *terminalTheme, _ []byte) ([]byte, []string, error) {
+	profile := map[string]interface{}{
+		"Name": theme.Name,
+		"Guid": "profilesync-" + strings.ToLower(regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(theme.Name, "-")),
+	}
+	var notes []string
+	if theme.FontFamily != "" {
+		size := theme.FontSize
+		if size == 0 {
+			size = 12
+		}
+		profile["Normal Font"] = fmt.Sprintf("%s-Regular %g", strings.ReplaceAll(theme.FontFamily, " ", ""), size)
+		notes = append(notes, "iTerm2 names fonts by PostScript name; pick the font again if it does not show up")
+	}
+	colors := map[string]string{
+		"Foreground Color": theme.Foreground, "Background Color": theme.Background,
+		"Cursor Color": theme.Cursor, "Selection Color": theme.Selection,
+	}
+	for i, c := range theme.Palette {
+		colors[fmt.Sprintf("Ansi %d Color", i)] = c
+	}
+	for name, c := range colors {
+		if c != "" {
+			profile[name] = itermColorDict(c)
+		}
+	}
+
+	keymap := map[string]interface{}{}
+	for _, key := range theme.Keys {
+		combo, ok := formatITermKey(key)
+		if !ok || key.Action != "send_text" {
+			notes = append(notes, fmt.Sprintf("%s (%s) has no iTerm2 profile equivalent", key, key.Action))
+			continue
+		}
+		var hex []string
+		for _, b := range []byte(key.Text) {
+			hex = append(hex, fmt.Sprintf("0x%02x", b))
+		}
+		keymap[combo] = map[string]interface{}{"Action": 11, "Text": strings.Join(hex, " ")}
+	}
+	if len(keymap) > 0 {
+		profile["Keyboard Map"] = keymap
+	}
+
+	out, err := json.MarshalIndent(map[string]interface{}{"Profiles": []interface{}{profile}}, "", "  ")
+	return append(out, '\n'), notes, err
+}
+
+// --- Windows Terminal ---
+
+// windowsTerminalPalette are Windows Terminal's names for the 16 colors
+var windowsTerminalPalette = [16]string{
+	"black", "red", "green", "yellow", "blue", "purple", "cyan", "white",
+	"brightBlack", "brightRed", "brightGreen", "brightYellow", "brightBlue", "brightPurple", "brightCyan", "brightWhite",
+}
+
+// stripJSONC removes the comments and trailing commas Windows Terminal
+// allows in settings.json, reporting whether there were comments
+func stripJSONC(data []byte) ([]byte, bool) {
+	var out []byte
+	comments := false
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			comments = true
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			comments = true
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				i = len(data)
+			} else {
+				i += end + 3
+			}
+		case c == ',':
+			// Drop the comma when only whitespace is left before the closing bracket
+			rest := bytes.TrimLeft(data[i+1:], " \t\r\n")
+			if len(rest) == 0 || (rest[0] != '}' && rest[0] != ']') {
+				out = append(out, c)
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, comments
+}
+
+// readWindowsTerminalTheme reads the default profile, or the named one,
+// with its color scheme, font and the key bindings
+func readWindowsTerminalTheme(data []byte, _ string, profile string) (*terminalTheme, []string, error) {
+	clean, _ := stripJSONC(data)
+	var settings map[string]interface{}
+	if err := json.Unmarshal(clean, &settings); err != nil {
+		return nil, nil, err
+	}
+
+	defaults := lookup(settings, "profiles", "defaults")
+	list, _ := lookup(settings, "profiles", "list").([]interface{})
+	if l, ok := settings["profiles"].([]interface{}); ok {
+		list = l
+	}
+	var chosen interface{}
+	for _, p := range list {
+		if (profile != "" && lookupString(p, "name") == profile) || (profile == "" && lookupString(p, "guid") == lookupString(settings, "defaultProfile")) {
+			chosen = p
+		}
+	}
+	if profile != "" && chosen == nil {
+		return nil, nil, fmt.Errorf("no Windows Terminal profile named %q", profile)
+	}
+
+	// A profile's own settings win over the defaults
+	setting := func(path ...string) interface{} {
+		if v := lookup(chosen, path...); v != nil {
+			return v
+		}
+		return lookup(defaults, path...)
+	}
+
+	theme := &terminalTheme{Name: lookupString(chosen, "name")}
+	if theme.Name == "" {
+		theme.Name = "Windows Terminal"
+	}
+	theme.FontFamily, _ = setting("font", "face").(string)
+	theme.FontSize = lookupFloat(map[string]interface{}{"size": setting("font", "size")}, "size")
+	if theme.FontFamily == "" {
+		theme.FontFamily, _ = setting("fontFace").(string)
+		theme.FontSize = lookupFloat(map[string]interface{}{"size": setting("fontSize")}, "size")
+	}
+
+	var notes []string
+	schemeName, _ := setting("colorScheme").(string)
+	if schemeName == "" {
+		schemeName = lookupString(setting("colorScheme"), "dark")
+	}
+	var scheme interface{}
+	schemes, _ := settings["schemes"].([]interface{})
+	for _, s := range schemes {
+		if lookupString(s, "name") == schemeName {
+			scheme = s
+		}
+	}
+	if scheme == nil && schemeName != "" {
+		notes = append(notes, fmt.Sprintf("color scheme %q is built into Windows Terminal; its colors were not converted", schemeName))
+	}
+	color := func(name string) string {
+		c, _ := parseTerminalColor(lookupString(scheme, name))
+		return c
+	}
+	theme.Foreground = color("foreground")
+	theme.Background = color("background")
+	theme.Cursor = color("cursorColor")
+	theme.Selection = color("selectionBackground")
+	for i, name := range windowsTerminalPalette {
+		theme.Palette[i] = color(name)
+	}
+
+	// Newer settings keep actions and their keys apart, joined by id
+	commands := map[string]interface{}{}
+	var bindings []interface{}
+	for _, section := range []string{"actions", "keybindings"} {
+		entries, _ := settings[section].([]interface{})
+		for _, entry := range entries {
+			if id := lookupString(entry, "id"); id != "" && lookup(entry, "command") != nil {
+				commands[id] = lookup(entry, "command")
+			}
+			if lookup(entry, "keys") != nil {
+				bindings = append(bindings, entry)
+			}
+		}
+	}
+	for _, entry := range bindings {
+		command := lookup(entry, "command")
+		if command == nil {
+			command = commands[lookupString(entry, "id")]
+		}
+		if command == nil || command == "unbound" {
+			continue
+		}
+		keys := lookup(entry, "keys")
+		if list, ok := keys.([]interface{}); ok && len(list) > 0 {
+			keys = list[0]
+		}
+		combo, _ := keys.(string)
+		key := parseKeyCombo("windows-terminal", combo, "+")
+
+		name, _ := command.(string)
+		if name == "" {
+			name = lookupString(command, "action")
+		}
+		switch name {
+		case "sendInput":
+			key.Action, key.Text = "send_text", lookupString(command, "input")
+		case "adjustFontSize":
+			key.Action = "increase_font_size"
+			if lookupFloat(command, "delta") < 0 {
+				key.Action = "decrease_font_size"
+			}
+		default:
+			key.Action = terminalAction("windows-terminal", name)
+		}
+		if key.Action == "" {
+			notes = append(notes, fmt.Sprintf("Windows Terminal action %s on %s not converted", name, combo))
+			continue
+		}
+		theme.Keys = append(theme.Keys, key)
+	}
+	return theme, notes, nil
+}
+
+// parseKeyCombo splits a combination such as "ctrl+shift+c"
+func parseKeyCombo(format, combo, sep string) terminalKey {
+	parts := strings.Split(combo, sep)
+	return terminalKey{
+		Key:  parseTerminalKey(format, parts[len(parts)-1]),
+		Mods: parseTerminalMods(format, parts[:len(parts)-1]),
+	}
+}
+
+// writeWindowsTerminalTheme adds the theme to settings.json as a color
+// scheme, makes it and the font the profile defaults, and adds the key
+// bindings, replacing any bound to the same keys
+func writeWindowsTerminalTheme(theme *terminalTheme, existing []byte) ([]byte, []string, error) {
+	settings := map[string]interface{}{}
+	var notes []string
+	if len(existing) > 0 {
+		clean, comments := stripJSONC(existing)
+		if err := json.Unmarshal(clean, &settings); err != nil {
+			return nil, nil, err
+		}
+		if comments {
+			notes = append(notes, "comments in settings.json are not kept (the original is backed up)")
+		}
+	}
+
+	scheme := map[string]interface{}{"name": theme.Name}
+	for name, c := range map[string]string{
+		"foreground": theme.Foreground, "background": theme.Background,
+		"cursorColor": theme.Cursor, "selectionBackground": theme.Selection,
+	} {
+		if c != "" {
+			scheme[name] = c
+		}
+	}
+	for i, name := range windowsTerminalPalette {
+		if theme.Palette[i] != "" {
+			scheme[name] = theme.Palette[i]
+		}
+	}
+	schemes := []interface{}{}
+	existingSchemes, _ := settings["schemes"].([]interface{})
+	for _, s := range existingSchemes {
+		if lookupString(s, "name") != theme.Name {
+			schemes = append(schemes, s)
+		}
+	}
+	settings["schemes"] = append(schemes, scheme)
+
+	profiles, ok := settings["profiles"].(map[string]interface{})
+	if !ok {
+		profiles = map[string]interface{}{}
+		if list, isList := settings["profiles"].([]interface{}); isList {
+			profiles["list"] = list
+		}
+		settings["profiles"] = profiles
+	}
+	defaults, ok := profiles["defaults"].(map[string]interface{})
+	if !ok {
+		defaults = map[string]interface{}{}
+		profiles["defaults"] = defaults
+	}
+	defaults["colorScheme"] = theme.Name
+	if theme.FontFamily != "" {
+		font, _ := defaults["font"].(map[string]interface{})
+		if font == nil {
+			font = map[string]interface{}{}
+		}
+		font["face"] = theme.FontFamily
+		if theme.FontSize > 0 {
+			font["size"] = theme.FontSize
+		}
+		defaults["font"] = font
+	}
+
+	actions, _ := settings["actions"].([]interface{})
+	for _, key := range theme.Keys {
+		var command interface{}
+		switch name := terminalActions[key.Action]["windows-terminal"]; {
+		case key.Action == "send_text":
+			command = map[string]interface{}{"action": "sendInput", "input": key.Text}
+		case strings.HasPrefix(name, "adjustFontSize "):
+			delta, _ := strconv.Atoi(strings.TrimPrefix(name, "adjustFontSize "))
+			command = map[string]interface{}{"action": "adjustFontSize", "delta": delta}
+		case name != "":
+			command = name
+		default:
+			notes = append(notes, fmt.Sprintf("%s (%s) has no Windows Terminal equivalent", key, key.Action))
+			continue
+		}
+		combo := strings.Trim(formatTerminalMods("windows-terminal", key.Mods)+"+"+formatTerminalKey("windows-terminal", key.Key), "+")
+		kept := actions[:0:0]
+		for _, a := range actions {
+			if !strings.EqualFold(lookupString(a, "keys"), combo) {
+				kept = append(kept, a)
+			}
+		}
+		actions = append(kept, map[string]interface{}{"command": command, "keys": combo})
+	}
+	if len(actions) > 0 {
+		settings["actions"] = actions
+	}
+
+	out, err := json.MarshalIndent(settings, "", "    ")
+	return append(out, '\n'), notes, err
+}
+
+// --- Alacritty ---
+
+// readAlacrittyTheme reads alacritty.toml, or alacritty.yml from before 0.13
+func readAlacrittyTheme(data []byte, path, _ string) (*terminalTheme, []string, error) {
+	var cfg map[string]interface{}
+	var err error
+	if ext := filepath.Ext(path); ext == ".yml" || ext == ".yaml" {
+		err = yaml.Unmarshal(data, &cfg)
+	} else {
+		cfg, err = parseTOML(data)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var notes []string
+	if lookup(cfg, "import") != nil || lookup(cfg, "general", "import") != nil {
+		notes = append(notes, "imported Alacritty files are not followed")
+	}
+	theme := &terminalTheme{
+		Name:       "Alacritty",
+		FontFamily: lookupString(cfg, "font", "normal", "family"),
+		FontSize:   lookupFloat(cfg, "font", "size"),
+	}
+	color := func(path ...string) string {
+		c, _ := parseTerminalColor(lookupString(cfg, append([]string{"colors"}, path...)...))
+		return c
+	}
+	theme.Foreground = color("primary", "foreground")
+	theme.Background = color("primary", "background")
+	theme.Cursor = color("cursor", "cursor")
+	theme.Selection = color("selection", "background")
+	for i, name := range terminalPaletteNames {
+		theme.Palette[i] = color("normal", name)
+		theme.Palette[i+8] = color("bright", name)
+	}
+
+	bindings, _ := lookup(cfg, "keyboard", "bindings").([]interface{})
+	if old, ok := lookup(cfg, "key_bindings").([]interface{}); ok {
+		bindings = append(bindings, old...)
+	}
+	for _, b := range bindings {
+		key := terminalKey{
+			Key:  parseTerminalKey("alacritty", fmt.Sprint(lookup(b, "key"))),
+			Mods: parseTerminalMods("alacritty", strings.Split(lookupString(b, "mods"), "|")),
+		}
+		if chars := lookupString(b, "chars"); chars != "" {
+			key.Action, key.Text = "send_text", chars
+		} else {
+			key.Action = terminalAction("alacritty", lookupString(b, "action"))
+		}
+		if key.Action == "" || lookup(b, "mode") != nil {
+			notes = append(notes, fmt.Sprintf("Alacritty binding %s (%s) not converted", key, lookupString(b, "action")))
+			continue
+		}
+		theme.Keys = append(theme.Keys, key)
+	}
+	return theme, notes, nil
+}
+
+// tomlQuote quotes a TOML basic string
+func tomlQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteString(`\` + string(r))
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// writeAlacrittyTheme renders the theme as an Alacritty TOML file to import
+func writeAlacrittyTheme(theme *terminalTheme, _ []byte) ([]byte, []string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Converted by profilesync from %s\n", theme.Name)
+	if theme.FontFamily != "" || theme.FontSize > 0 {
+		b.WriteString("\n[font]\n")
+		if theme.FontSize > 0 {
+			fmt.Fprintf(&b, "size = %s\n", strconv.FormatFloat(theme.FontSize, 'f', 1, 64))
+		}
+		if theme.FontFamily != "" {
+			fmt.Fprintf(&b, "\n[font.normal]\nfamily = %s\n", tomlQuote(theme.FontFamily))
+		}
+	}
+
+	table := func(name string, values [][2]string) {
+		var lines []string
+		for _, v := range values {
+			if v[1] != "" {
+				lines = append(lines, fmt.Sprintf("%s = %s", v[0], tomlQuote(v[1])))
+			}
+		}
+		if len(lines) > 0 {
+			fmt.Fprintf(&b, "\n[%s]\n%s\n", name, strings.Join(lines, "\n"))
+		}
+	}
+	table("colors.primary", [][2]string{{"foreground", theme.Foreground}, {"background", theme.Background}})
+	table("colors.cursor", [][2]string{{"cursor", theme.Cursor}})
+	table("colors.selection", [][2]string{{"background", theme.Selection}})
+	var normal, bright [][2]string
+	for i, name := range terminalPaletteNames {
+		normal = append(normal, [2]string{name, theme.Palette[i]})
+		bright = append(bright, [2]string{name, theme.Palette[i+8]})
+	}
+	table("colors.normal", normal)
+	table("colors.bright", bright)
+
+	var notes []string
+	for _, key := range theme.Keys {
+		action := terminalActions[key.Action]["alacritty"]
+		if key.Action != "send_text" && action == "" {
+			notes = append(notes, fmt.Sprintf("%s (%s) has no Alacritty equivalent", key, key.Action))
+			continue
+		}
+		fmt.Fprintf(&b, "\n[[keyboard.bindings]]\nkey = %s\n", tomlQuote(formatTerminalKey("alacritty", key.Key)))
+		if len(key.Mods) > 0 {
+			fmt.Fprintf(&b, "mods = %s\n", tomlQuote(formatTerminalMods("alacritty", key.Mods)))
+		}
+		if key.Action == "send_text" {
+			fmt.Fprintf(&b, "chars = %s\n", tomlQuote(key.Text))
+		} else {
+			fmt.Fprintf(&b, "action = %s\n", tomlQuote(action))
+		}
+	}
+	return []byte(b.String()), notes, nil
+}
+
+// --- kitty ---
+
+// readKittyTheme reads kitty.conf
+func readKittyTheme(data []byte, _, _ string) (*terminalTheme, []string, error) {
+	theme := &terminalTheme{Name: "kitty"}
+	var notes []string
+	kittyMod := []string{"ctrl", "shift"}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		color, _ := parseTerminalColor(value)
+
+		switch {
+		case name == "font_family":
+			if value != "auto" && value != "monospace" {
+				theme.FontFamily = value
+			}
+		case name == "font_size":
+			theme.FontSize, _ = strconv.ParseFloat(value, 64)
+		case name == "foreground":
+			theme.Foreground = color
+		case name == "background":
+			theme.Background = color
+		case name == "cursor":
+			theme.Cursor = color
+		case name == "selection_background":
+			theme.Selection = color
+		case strings.HasPrefix(name, "color"):
+			if n, err := strconv.Atoi(name[5:]); err == nil && n >= 0 && n < 16 {
+				theme.Palette[n] = color
+			}
+		case name == "kitty_mod":
+			kittyMod = parseTerminalMods("kitty", strings.Split(value, "+"))
+		case name == "include" || name == "globinclude":
+			notes = append(notes, "included kitty files are not followed: "+value)
+		case name == "map":
+			combo, action, _ := strings.Cut(value, " ")
+			action = strings.TrimSpace(action)
+			if strings.Contains(combo, ">") {
+				notes = append(notes, "multi-key kitty shortcut "+combo+" not converted")
+				continue
+			}
+			parts := strings.Split(combo, "+")
+			var mods []string
+			for _, part := range parts[:len(parts)-1] {
+				if part == "kitty_mod" {
+					mods = append(mods, kittyMod...)
+				} else {
+					mods = append(mods, part)
+				}
+			}
+			key := terminalKey{Key: parseTerminalKey("kitty", parts[len(parts)-1]), Mods: parseTerminalMods("kitty", mods)}
+
+			fields := strings.Fields(action)
+			switch {
+			case len(fields) > 2 && fields[0] == "send_text":
+				key.Action = "send_text"
+				key.Text = kittyUnescape(strings.TrimSpace(strings.SplitN(action, " ", 3)[2]))
+			case len(fields) > 0 && fields[0] == "change_font_size":
+				switch arg := fields[len(fields)-1]; {
+				case strings.HasPrefix(arg, "+"):
+					key.Action = "increase_font_size"
+				case strings.HasPrefix(arg, "-"):
+					key.Action = "decrease_font_size"
+				default:
+					key.Action = "reset_font_size"
+				}
+			default:
+				key.Action = terminalAction("kitty", action)
+			}
+			if key.Action == "" {
+				notes = append(notes, fmt.Sprintf("kitty action %q on %s not converted", action, combo))
+				continue
+			}
+			theme.Keys = append(theme.Keys, key)
+		}
+	}
+	return theme, notes, nil
+}
+
+// kittyUnescape decodes the Python-style escapes kitty's send_text takes
+func kittyUnescape(s string) string {
+	unquoted, err := strconv.Unquote(`"` + strings.ReplaceAll(strings.ReplaceAll(s, `"`, `\"`), `\e`, `\x1b`) + `"`)
+	if err != nil {
+		return s
+	}
+	return unquoted
+}
+
+// kittyEscape encodes text for send_text
+func kittyEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// writeKittyTheme renders the theme as a kitty.conf fragment to include
+func writeKittyTheme(theme *terminalTheme, _ []byte) ([]byte, []string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Converted by profilesync from %s\n\n", theme.Name)
+	if theme.FontFamily != "" {
+		fmt.Fprintf(&b, "font_family %s\n", theme.FontFamily)
+	}
+	if theme.FontSize > 0 {
+		fmt.Fprintf(&b, "font_size %g\n", theme.FontSize)
+	}
+	for _, v := range [][2]string{
+		{"foreground", theme.Foreground}, {"background", theme.Background},
+		{"cursor", theme.Cursor}, {"selection_background", theme.Selection},
+	} {
+		if v[1] != "" {
+			fmt.Fprintf(&b, "%s %s\n", v[0], v[1])
+		}
+	}
+	for i, c := range theme.Palette {
+		if c != "" {
+			fmt.Fprintf(&b, "color%d %s\n", i, c)
+		}
+	}
+
+	var notes []string
+	if len(theme.Keys) > 0 {
+		b.WriteString("\n")
+	}
+	for _, key := range theme.Keys {
+		action := terminalActions[key.Action]["kitty"]
+		if key.Action == "send_text" {
+			action = "send_text all " + kittyEscape(key.Text)
+		}
+		if action == "" {
+			notes = append(notes, fmt.Sprintf("%s (%s) has no kitty equivalent", key, key.Action))
+			continue
+		}
+		combo := strings.Trim(formatTerminalMods("kitty", key.Mods)+"+"+formatTerminalKey("kitty", key.Key), "+")
+		fmt.Fprintf(&b, "map %s %s\n", combo, action)
+	}
+	return []byte(b.String()), notes, nil
+}
+
+// --- WezTerm ---
+
+// luaQuote quotes a Lua string
+func luaQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\'' || r == '\\':
+			b.WriteString(`\` + string(r))
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// writeWezTermTheme renders the theme as a Lua module that applies it to
+// a WezTerm config
+func writeWezTermTheme(theme *terminalTheme, _ []byte) ([]byte, []string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Converted by profilesync from %s\n", theme.Name)
+	b.WriteString("local wezterm = require 'wezterm'\nlocal act = wezterm.action\n\nreturn function(config)\n")
+	if theme.FontFamily != "" {
+		fmt.Fprintf(&b, "  config.font = wezterm.font(%s)\n", luaQuote(theme.FontFamily))
+	}
+	if theme.FontSize > 0 {
+		fmt.Fprintf(&b, "  config.font_size = %s\n", strconv.FormatFloat(theme.FontSize, 'f', 1, 64))
+	}
+
+	b.WriteString("  config.colors = {\n")
+	for _, v := range [][2]string{
+		{"foreground", theme.Foreground}, {"background", theme.Background},
+		{"cursor_bg", theme.Cursor}, {"selection_bg", theme.Selection},
+	} {
+		if v[1] != "" {
+			fmt.Fprintf(&b, "    %s = %s,\n", v[0], luaQuote(v[1]))
+		}
+	}
+	palette := func(name string, colors []string) {
+		for _, c := range colors {
+			if c == "" {
+				return
+			}
+		}
+		quoted := make([]string, len(colors))
+		for i, c := range colors {
+			quoted[i] = luaQuote(c)
+		}
+		fmt.Fprintf(&b, "    %s = { %s },\n", name, strings.Join(quoted, ", "))
+	}
+	palette("ansi", theme.Palette[:8])
+	palette("brights", theme.Palette[8:])
+	b.WriteString("  }\n")
+
+	var notes []string
+	if len(theme.Keys) > 0 {
+		b.WriteString("  config.keys = config.keys or {}\n")
+	}
+	for _, key := range theme.Keys {
+		action := terminalActions[key.Action]["wezterm"]
+		if key.Action == "send_text" {
+			action = "act.SendString " + luaQuote(key.Text)
+		}
+		if action == "" {
+			notes = append(notes, fmt.Sprintf("%s (%s) has no WezTerm equivalent", key, key.Action))
+			continue
+		}
+		fmt.Fprintf(&b, "  table.insert(config.keys, { key = %s, mods = %s, action = %s })\n",
+			luaQuote(formatTerminalKey("wezterm", key.Key)), luaQuote(formatTerminalMods("wezterm", key.Mods)), action)
+	}
+	b.WriteString("end\n")
+	if theme.Palette[0] != "" && theme.Palette[15] == "" {
+		notes = append(notes, "the palette is incomplete; WezTerm needs all 8 colors of a row, so partial rows were left out")
+	}
+	return []byte(b.String()), notes, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseTOML decodes a TOML document into nested maps. Integers are int64,
+// floats float64 and dates and times time.Time. Arrays of tables are
+// []interface{} like other arrays, so callers look them up the same way
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if _, err := toml.Decode(string(data), &m); err != nil {
+		return nil, errors.New(strings.TrimPrefix(err.Error(), "toml: "))
+	}
+	return tomlArrays(m).(map[string]interface{}), nil
+}
+
+// tomlArrays turns the []map[string]interface{} of arrays of tables in v
+// into []interface{}
+func tomlArrays(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = tomlArrays(e)
+		}
+		return v
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, e := range v {
+			list[i] = tomlArrays(e)
+		}
+		return list
+	case []interface{}:
+		for i, e := range v {
+			v[i] = tomlArrays(e)
+		}
+		return v
+	}
+	return v
+}
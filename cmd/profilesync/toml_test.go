@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTOML(t *testing.T) {
+	data := `# alacritty
+title = "a \"b\"\tc"
+path = 'C:\Users'
+size = 1_024
+ratio = 1.5e2
+hex = 0xff
+on = true
+when = 1979-05-27T07:32:00Z
+list = [
+  1, 2, # comment
+  3,
+]
+"quoted key".x = 'y'
+inline = { a = 1, b.c = "d" }
+multi = """
+one \
+    two"""
+quotes = """a \""" b"""
+
+[colors.primary]
+background = "#1d1f21"
+
+[[hints.enabled]]
+regex = 'a'
+
+[[hints.enabled]]
+regex = 'b'
+[hints.enabled.binding]
+key = "U"
+`
+	got, err := parseTOML([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"title":      "a \"b\"\tc",
+		"path":       `C:\Users`,
+		"size":       int64(1024),
+		"ratio":      150.0,
+		"hex":        int64(255),
+		"on":         true,
+		"when":       time.Date(1979, 5, 27, 7, 32, 0, 0, time.UTC),
+		"list":       []interface{}{int64(1), int64(2), int64(3)},
+		"quoted key": map[string]interface{}{"x": "y"},
+		"inline":     map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": "d"}},
+		"multi":      "one two",
+		"quotes":     `a """ b`,
+		"colors":     map[string]interface{}{"primary": map[string]interface{}{"background": "#1d1f21"}},
+		"hints": map[string]interface{}{"enabled": []interface{}{
+			map[string]interface{}{"regex": "a"},
+			map[string]interface{}{"regex": "b", "binding": map[string]interface{}{"key": "U"}},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTOML =\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name, data, err string
+	}{
+		{"duplicate key", "a = 1\na = 2\n", "line 2"},
+		{"duplicate table", "[t]\n[t]\n", "line 2"},
+		{"duplicate in array entry", "[[t]]\na = 1\na = 2\n", "line 3"},
+		{"table over a value", "a = 1\n[a]\n", "line 2"},
+		{"missing value", "a =\n", "line 1"},
+		{"bad value", "a = yes\n", "line 1"},
+		{"trailing garbage", "a = 1 2\n", "line 1"},
+		{"unterminated string", "a = \"x\nb = 1\n", "line 1"},
+		{"unterminated multi-line string", "a = \"\"\"x \\\"\"\"\n", "line 1"},
+		{"unknown escape", `a = "\q"`, "line 1"},
+		{"array separator", "a = [1 2]\n", "line 1"},
+		{"no equals", "a 1\n", "line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTOML([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseTOML(%q) = %v, want %q", tt.data, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateConfigTOML(t *testing.T) {
+	if err := validateConfig(configTOML, []byte("[a]\nb = 1\n")); err != nil {
+		t.Errorf("valid TOML: %v", err)
+	}
+	if err := validateConfig(configTOML, []byte("[a]\nb = \n")); err == nil {
+		t.Error("invalid TOML passed")
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultRetries is how many times a transfer is tried before giving up
+const defaultRetries = 5
+
+// transferOptions are the flags of commands that move files between
+// machines
+type transferOptions struct {
+	bwlimit string
+	retries int
+}
+
+// addTransferFlags registers --bwlimit and --retries
+func addTransferFlags(flags *flag.FlagSet) *transferOptions {
+	o := addBandwidthFlag(flags)
+	flags.IntVar(&o.retries, "retries", defaultRetries, "Tries before giving up on a transfer interrupted by a network error")
+	return o
+}
+
+// addBandwidthFlag registers only --bwlimit, for commands that wait for the
+// other end to come back rather than retry
+func addBandwidthFlag(flags *flag.FlagSet) *transferOptions {
+	o := &transferOptions{retries: 1}
+	flags.StringVar(&o.bwlimit, "bwlimit", os.Getenv("PROFILESYNC_BWLIMIT"), "Limit the bandwidth used in each direction, e.g. 500KB/s (default unlimited, or $PROFILESYNC_BWLIMIT)")
+	return o
+}
+
+// rate returns the --bwlimit in bytes per second, 0 for none. It exits on
+// an invalid value, as the flag checks of commands do
+func (o *transferOptions) rate() int64 {
+	rate, err := parseRate(o.bwlimit)
+	if err != nil {
+		errorColor.Println("❌ Invalid --bwlimit value:", o.bwlimit)
+		errorColor.Println("Must be a size per second, e.g. 500KB/s or 2MB/s")
+		os.Exit(2)
+	}
+	return rate
+}
+
+// retry returns the retry policy for --retries
+func (o *transferOptions) retry() retryPolicy {
+	return retryPolicy{attempts: max(o.retries, 1), base: time.Second, max: 30 * time.Second}
+}
+
+// parseRate parses a bandwidth such as 500KB/s, 2M or 64k, in bytes per
+// second. An empty rate or 0 is unlimited
+func parseRate(s string) (int64, error) {
+	value := strings.TrimSpace(s)
+	if value == "" {
+		return 0, nil
+	}
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "/s"), "ps")
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+		// A bare number is KiB per second, as rsync takes it
+		return n << 10, nil
+	}
+	return parseSize(value)
+}
+
+// rateLimiter is a token bucket shared by everything that one transfer
+// reads, or writes. Each direction has its own
+type rateLimiter struct {
+	rate int64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter limits to rate bytes per second, or returns nil for no limit
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, last: time.Now()}
+}
+
+// burst is the most that is read or written at once, a tenth of a second
+// of bandwidth
+func (l *rateLimiter) burst() int {
+	return int(max(l.rate/10, 1024))
+}
+
+// wait blocks until n more bytes fit in the limit
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(l.rate), float64(l.burst()))
+	l.last = now
+	l.tokens -= float64(n)
+	debt := l.tokens
+	l.mu.Unlock()
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / float64(l.rate) * float64(time.Second)))
+	}
+}
+
+// limitedWriter writes no faster than its limiter allows
+type limitedWriter struct {
+	w io.Writer
+	l *rateLimiter
+}
+
+// newLimitedWriter limits w, or returns it as it is without a limiter
+func newLimitedWriter(w io.Writer, l *rateLimiter) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &limitedWriter{w: w, l: l}
+}
+
+// Write implements io.Writer
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), w.l.burst())]
+		w.l.wait(len(chunk))
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// limitedReader reads no faster than its limiter allows
+type limitedReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+// newLimitedReader limits r, or returns it as it is without a limiter
+func newLimitedReader(r io.Reader, l *rateLimiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, l: l}
+}
+
+// Read implements io.Reader
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:min(len(p), r.l.burst())])
+	r.l.wait(n)
+	return n, err
+}
+
+// limitedConn is a connection limited to a bandwidth in each direction
+type limitedConn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+// newLimitedConn limits conn to rate bytes per second in each direction,
+// or returns it as it is for no limit
+func newLimitedConn(conn net.Conn, rate int64) net.Conn {
+	if rate <= 0 {
+		return conn
+	}
+	return &limitedConn{
+		Conn: conn,
+		r:    newLimitedReader(conn, newRateLimiter(rate)),
+		w:    newLimitedWriter(conn, newRateLimiter(rate)),
+	}
+}
+
+// Read implements net.Conn
+func (c *limitedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// Write implements net.Conn
+func (c *limitedConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+// retryPolicy retries operations failing with transient network errors,
+// waiting exponentially longer between tries
+type retryPolicy struct {
+	attempts  int
+	base, max time.Duration
+}
+
+// defaultRetry is the policy of transfers without a --retries flag
+var defaultRetry = retryPolicy{attempts: 3, base: time.Second, max: 30 * time.Second}
+
+// delay is how long to wait before the next try: exponential, with jitter
+// so many clients cut off at once do not all come back at once
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.max
+	if shift := attempt - 1; shift < 16 {
+		d = min(p.base<<shift, p.max)
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// do runs fn until it succeeds, fails with an error that is not transient,
+// or has been tried as often as the policy allows
+func (p retryPolicy) do(ctx context.Context, what string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(err) || attempt >= p.attempts {
+			return err
+		}
+		delay := p.delay(attempt)
+		warnColor.Printf("⚠️  %s failed: %v; retrying in %s (%d/%d)\n", what, err, delay.Round(100*time.Millisecond), attempt, p.attempts-1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// httpStatusError is an HTTP response other than success
+type httpStatusError struct {
+	url    string
+	code   int
+	status string
+}
+
+// Error implements error
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.url, e.status)
+}
+
+// isTransient reports whether err is a network failure that may not
+// happen again: a dropped or refused connection, a timeout, or a server
+// asking to come back later
+func isTransient(err error) bool {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var statusErr *httpStatusError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.As(err, &statusErr):
+		return statusErr.code == 429 || statusErr.code >= 500
+	case errors.As(err, &dnsErr):
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, syscall.ETIMEDOUT), errors.Is(err, syscall.ENETUNREACH),
+		errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETDOWN):
+		return true
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// displacedFiles lists the files an item is about to overwrite with
+// different content: its destination, or for a directory, the files its
+// source would land on
+func displacedFiles(item MigrationItem) []string {
+	var displaced []string
+	check := func(src, dst string) {
+		info, err := os.Stat(dst)
+		if err != nil || !info.Mode().IsRegular() {
+			return
+		}
+		srcHash, err := hashFile(src)
+		if dstHash, _ := hashFile(dst); err == nil && srcHash != dstHash {
+			displaced = append(displaced, dst)
+		}
+	}
+	info, err := os.Stat(item.SourcePath)
+	if err != nil || !info.IsDir() {
+		check(item.SourcePath, item.DestinationPath)
+		return displaced
+	}
+	filepath.WalkDir(item.SourcePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if rel, err := filepath.Rel(item.SourcePath, path); err == nil {
+			check(path, filepath.Join(item.DestinationPath, rel))
+		}
+		return nil
+	})
+	return displaced
+}
+
+// trashDisplaced puts a copy of each file the item is about to overwrite in
+// the OS trash, with --trash. The item fails rather than lose a file that
+// could not be put there
+func (ps *ProfileSync) trashDisplaced(item MigrationItem) error {
+	if !ps.trash || ps.dryRun {
+		return nil
+	}
+	displaced := displacedFiles(item)
+	for _, path := range displaced {
+		if err := trashFile(path, true); err != nil {
+			return err
+		}
+		if ps.verbose {
+			noticeColor.Printf("🗑️  Previous %s put in the trash\n", displayPath(path))
+		}
+	}
+	if len(displaced) > 0 && !ps.verbose {
+		noticeColor.Printf("🗑️  Put %d replaced file(s) of %s in the trash\n", len(displaced), item.Description)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// trashFile puts the file at path in the trash: ~/.Trash on macOS, or the
+// Freedesktop home trash elsewhere, with the .trashinfo file managers need
+// to restore it. With keep set a copy goes there and the file stays, to be
+// replaced in place
+func trashFile(path string, keep bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	filesDir, infoDir := filepath.Join(home, ".Trash"), ""
+	if runtime.GOOS != "darwin" {
+		data := os.Getenv("XDG_DATA_HOME")
+		if data == "" {
+			data = filepath.Join(home, ".local", "share")
+		}
+		filesDir, infoDir = filepath.Join(data, "Trash", "files"), filepath.Join(data, "Trash", "info")
+		if err := os.MkdirAll(infoDir, 0700); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filesDir, 0700); err != nil {
+		return err
+	}
+
+	base, ext := filepath.Base(abs), filepath.Ext(abs)
+	if ext == base {
+		ext = ""
+	}
+	for n := 1; ; n++ {
+		name := base
+		if n > 1 {
+			name = fmt.Sprintf("%s %d%s", strings.TrimSuffix(base, ext), n, ext)
+		}
+		target := filepath.Join(filesDir, name)
+		if _, err := os.Lstat(target); err == nil {
+			continue
+		}
+		// Creating the info file first claims the name, as the spec asks
+		info := ""
+		if infoDir != "" {
+			info = filepath.Join(infoDir, name+".trashinfo")
+			f, err := os.OpenFile(info, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if os.IsExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(f, "[Trash Info]\nPath=%s\nDeletionDate=%s\n", (&url.URL{Path: abs}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(info)
+				return err
+			}
+		}
+		if err := placeInTrash(abs, target, keep); err != nil {
+			if info != "" {
+				os.Remove(info)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// placeInTrash moves or copies a file into the trash, copying when it is
+// on another filesystem
+func placeInTrash(path, target string, keep bool) error {
+	if !keep && os.Rename(path, target) == nil {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(target, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if keep {
+		return nil
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,84 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procSHFileOperationW = windows.NewLazySystemDLL("shell32.dll").NewProc("SHFileOperationW")
+
+// shFileOpStruct is SHFILEOPSTRUCTW, laid out as on 64-bit Windows
+type shFileOpStruct struct {
+	hwnd                  uintptr
+	wFunc                 uint32
+	pFrom                 *uint16
+	pTo                   *uint16
+	fFlags                uint16
+	fAnyOperationsAborted int32
+	hNameMappings         uintptr
+	lpszProgressTitle     *uint16
+}
+
+// SHFileOperation's operation and flags
+const (
+	foDelete          = 0x3
+	fofSilent         = 0x4
+	fofNoConfirmation = 0x10
+	fofAllowUndo      = 0x40
+	fofNoErrorUI      = 0x400
+)
+
+// trashFile puts the file at path in the Recycle Bin. With keep set a copy
+// goes there and the file stays, to be replaced in place; the copy is made
+// in a temporary folder, which is where restoring it puts it
+func trashFile(path string, keep bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if keep {
+		dir, err := os.MkdirTemp("", "profilesync-trash-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		info, err := os.Stat(abs)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(longPath(abs))
+		if err != nil {
+			return err
+		}
+		copied := filepath.Join(dir, filepath.Base(abs))
+		if err := os.WriteFile(copied, data, info.Mode().Perm()); err != nil {
+			return err
+		}
+		abs = copied
+	}
+
+	// pFrom is a list of paths, ended by an empty one
+	from, err := windows.UTF16FromString(abs)
+	if err != nil {
+		return err
+	}
+	op := shFileOpStruct{
+		wFunc:  foDelete,
+		pFrom:  &append(from, 0)[0],
+		fFlags: fofAllowUndo | fofNoConfirmation | fofNoErrorUI | fofSilent,
+	}
+	r, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op)))
+	switch {
+	case r != 0:
+		return fmt.Errorf("moving %s to the Recycle Bin failed with code %#x", path, r)
+	case op.fAnyOperationsAborted != 0:
+		return fmt.Errorf("moving %s to the Recycle Bin was cancelled", path)
+	}
+	return nil
+}
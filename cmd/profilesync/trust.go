@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// signingKeyName is the keystore entry holding the key that signs exported
+// archives
+const signingKeyName = "signing-key"
+
+// signingKeyPrefix starts a public key as `trust key` prints it
+const signingKeyPrefix = "ed25519:"
+
+// signingKey returns this machine's archive signing key from the OS
+// keystore, creating one when create is set. PROFILESYNC_SIGNING_KEY
+// (base64 seed) overrides the keystore for headless machines
+func signingKey(create bool) (ed25519.PrivateKey, error) {
+	encoded, ok, err := lookupSecret(signingKeyName)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if !create {
+			return nil, errors.New("this machine has no signing key yet")
+		}
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		encoded = base64.StdEncoding.EncodeToString(seed)
+		metrics.request("keystore")
+		if err := keystoreAdd(signingKeyName, encoded); errors.Is(err, errSecretExists) {
+			// Another run stored one first
+			if encoded, err = keystoreGet(signingKeyName); err != nil {
+				return nil, fmt.Errorf("reading signing key: %w", err)
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("storing signing key: %w (set PROFILESYNC_SIGNING_KEY instead)", err)
+		}
+	}
+	seed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("signing key must be a 32 byte seed, base64 encoded")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// formatPublicKey returns a public key as it is shared and trusted
+func formatPublicKey(pub ed25519.PublicKey) string {
+	return signingKeyPrefix + base64.StdEncoding.EncodeToString(pub)
+}
+
+// parsePublicKey reads a key formatted by formatPublicKey
+func parsePublicKey(s string) (ed25519.PublicKey, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(s), signingKeyPrefix)
+	if !ok {
+		return nil, fmt.Errorf("public key must start with %s", signingKeyPrefix)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("public key must be 32 bytes, base64 encoded")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// keyFingerprint is a short form of a public key for messages, as SSH
+// shows them
+func keyFingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// trustedKeysPath holds the public keys whose archives import accepts, by
+// name
+func trustedKeysPath() string {
+	return filepath.Join(stateDir(), "trusted-keys.yaml")
+}
+
+// loadTrustedKeys reads the trusted keys, or none when there are none yet
+func loadTrustedKeys() (map[string]string, error) {
+	keys := map[string]string{}
+	data, err := readStateFile(trustedKeysPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", trustedKeysPath(), err)
+	}
+	return keys, nil
+}
+
+// saveTrustedKeys writes the trusted keys
+func saveTrustedKeys(keys map[string]string) error {
+	data, err := yaml.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(trustedKeysPath(), data, 0600)
+}
+
+// trustedName returns the name a public key is trusted under. This
+// machine's own key is always trusted
+func trustedName(pub ed25519.PublicKey) (string, bool, error) {
+	if own, err := signingKey(false); err == nil && pub.Equal(own.Public()) {
+		return "this machine", true, nil
+	}
+	keys, err := loadTrustedKeys()
+	if err != nil {
+		return "", false, err
+	}
+	for _, name := range sortedKeys(keys) {
+		if key, err := parsePublicKey(keys[name]); err == nil && pub.Equal(key) {
+			return name, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// runTrust handles `profilesync trust key|add|list|remove`, managing the
+// keys archives are signed with and accepted from
+func runTrust(args []string) {
+	if len(args) == 0 {
+		errorColor.Println("❌ Usage: profilesync trust key|add|list|remove [flags]")
+		os.Exit(2)
+	}
+	sub, args := args[0], args[1:]
+
+	switch sub {
+	case "key":
+		flags := newFlagSet("trust key", "trust key [flags]")
+		flags.Parse(args)
+		key, err := signingKey(true)
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(1)
+		}
+		pub := key.Public().(ed25519.PublicKey)
+		fmt.Println(formatPublicKey(pub))
+		noticeColor.Printf("Fingerprint %s; teammates trust it with `profilesync trust add NAME %s`\n", keyFingerprint(pub), formatPublicKey(pub))
+
+	case "add":
+		flags := newFlagSet("trust add", "trust add [flags] NAME KEY")
+		flags.Parse(args)
+		if flags.NArg() != 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		name := flags.Arg(0)
+		pub, err := parsePublicKey(flags.Arg(1))
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(2)
+		}
+		keys, err := loadTrustedKeys()
+		if err == nil {
+			keys[name] = formatPublicKey(pub)
+			err = saveTrustedKeys(keys)
+		}
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(1)
+		}
+		successColor.Printf("🔏 Trusting archives signed by %s (%s)\n", name, keyFingerprint(pub))
+
+	case "list":
+		flags := newFlagSet("trust list", "trust list [flags]")
+		flags.Parse(args)
+		keys, err := loadTrustedKeys()
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(1)
+		}
+		if own, err := signingKey(false); err == nil {
+			fmt.Printf("   %-24s %s\n", "this machine", keyFingerprint(own.Public().(ed25519.PublicKey)))
+		}
+		if len(keys) == 0 {
+			fmt.Println("No keys trusted; add a teammate's with `profilesync trust add NAME KEY`")
+			return
+		}
+		for _, name := range sortedKeys(keys) {
+			fingerprint := "invalid key"
+			if pub, err := parsePublicKey(keys[name]); err == nil {
+				fingerprint = keyFingerprint(pub)
+			}
+			fmt.Printf("   %-24s %s\n", name, fingerprint)
+		}
+
+	case "remove":
+		flags := newFlagSet("trust remove", "trust remove [flags] NAME...")
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		keys, err := loadTrustedKeys()
+		if err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(1)
+		}
+		for _, name := range flags.Args() {
+			if _, ok := keys[name]; !ok {
+				warnColor.Printf("⚠️  %s is not trusted\n", name)
+				continue
+			}
+			delete(keys, name)
+			successColor.Printf("🗑️  No longer trusting %s\n", name)
+		}
+		if err := saveTrustedKeys(keys); err != nil {
+			errorColor.Println("❌ Error:", err)
+			os.Exit(1)
+		}
+
+	default:
+		errorColor.Printf("❌ Unknown trust command: %s\n", sub)
+		errorColor.Println("Must be one of: key, add, list, remove")
+		os.Exit(2)
+	}
+}
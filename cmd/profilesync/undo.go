@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lastApply returns the audit records of the most recent apply that
+// changed files, and the undo that already reverted it, if any
+func lastApply() (run string, records []AuditRecord, undoneBy string, err error) {
+	all, err := readAudit(func(r AuditRecord) bool { return r.Command == "apply" || r.Command == "undo" })
+	if err != nil {
+		return "", nil, "", err
+	}
+	for i := len(all) - 1; i >= 0 && run == ""; i-- {
+		if all[i].Command == "apply" {
+			run = all[i].Run
+		}
+	}
+	for _, r := range all {
+		switch {
+		case r.Command == "apply" && r.Run == run:
+			records = append(records, r)
+		case r.Command == "undo" && r.Undoes == run && run != "":
+			undoneBy = r.Run
+		}
+	}
+	return run, records, undoneBy, nil
+}
+
+// undoHashes lists what the files the last apply replaced held, which the
+// object store keeps until another apply comes along
+func undoHashes() []string {
+	_, records, _, err := lastApply()
+	if err != nil {
+		return nil
+	}
+	var hashes []string
+	for _, r := range records {
+		if r.Before != "" {
+			hashes = append(hashes, r.Before)
+		}
+	}
+	return hashes
+}
+
+// undoRecord puts one file back as it was before the apply: removing a
+// file it created, with the directories it created for it, or writing
+// back what the file held
+func undoRecord(r AuditRecord) error {
+	if r.Before == "" {
+		if err := os.Remove(r.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if r.Dir != "" {
+			removeEmptyParents(r.Path, filepath.Dir(r.Dir))
+		}
+		return nil
+	}
+	data, err := openObjectStore().get(r.Before)
+	if err != nil {
+		return fmt.Errorf("the previous content is gone: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return err
+	}
+	mode := r.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := writeFileAtomic(r.Path, data, mode); err != nil {
+		return err
+	}
+	return os.Chmod(r.Path, mode)
+}
+
+// runUndo handles `profilesync undo`, reverting the files the most recent
+// apply changed
+func runUndo(args []string) {
+	flags := newFlagSet("undo", "undo [flags]")
+	dryRun := flags.Bool("dry-run", true, "Show what would be undone without undoing it")
+	force := flags.Bool("force", false, "Also undo files changed since the apply, and undo it again after an undo")
+	flags.Parse(args)
+
+	run, records, undoneBy, err := lastApply()
+	if err != nil {
+		errorColor.Println("❌ Error reading the audit log:", err)
+		os.Exit(1)
+	}
+	if run == "" {
+		successColor.Println("✅ Nothing to undo: no apply has changed files yet")
+		return
+	}
+	if undoneBy != "" && !*force {
+		warnColor.Printf("⚠️  The last apply, %s, was already undone by %s (use --force to undo it again)\n", run, undoneBy)
+		return
+	}
+	noticeColor.Printf("↩️  Undoing apply %s from %s\n", run, records[0].Time.Local().Format("2006-01-02 15:04"))
+
+	trail := newAuditTrail("undo", "")
+	trail.undoes = run
+	undone, kept, failed := 0, 0, 0
+	// Later changes are undone first, so a file changed twice ends up as
+	// it was before both
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		current, _ := hashFile(r.Path)
+		if current == r.Before {
+			continue
+		}
+		if current != r.After && !*force {
+			warnColor.Printf("⚠️  Keeping %s: changed since the apply (use --force to undo it anyway)\n", displayPath(r.Path))
+			kept++
+			continue
+		}
+		verb := map[bool]string{true: "remove", false: "restore"}[r.Before == ""]
+		if *dryRun {
+			fmt.Printf("Would %s %s\n", verb, displayPath(r.Path))
+			undone++
+			continue
+		}
+		trail.watch(r.Path, r.Mapping)
+		if err := undoRecord(r); err != nil {
+			errorColor.Printf("❌ Error undoing %s: %v\n", displayPath(r.Path), err)
+			failed++
+			continue
+		}
+		logger().Info("file undone", "undoes", run, "path", r.Path, "hash", r.Before)
+		if r.Before == "" {
+			successColor.Printf("🗑️  Removed %s\n", displayPath(r.Path))
+		} else {
+			successColor.Printf("♻️  Restored %s\n", displayPath(r.Path))
+		}
+		undone++
+	}
+	trail.flush()
+
+	switch {
+	case *dryRun:
+		noticeColor.Printf("%d files would be undone, %d kept. Run with --dry-run=false to undo them.\n", undone, kept)
+	case undone == 0 && failed == 0:
+		successColor.Printf("✅ Nothing to undo: the files are already as they were before %s\n", run)
+	default:
+		noticeColor.Printf("%d files undone, %d kept\n", undone, kept)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
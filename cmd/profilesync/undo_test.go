@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUndo(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROFILESYNC_STATE_DIR", filepath.Join(dir, "state"))
+	home := filepath.Join(dir, "home")
+
+	// A file exists before the apply when before is set, and after it when
+	// after is set. edited is written after the apply, as a user would
+	tests := []struct {
+		name          string
+		file          string
+		before, after string
+		mode          os.FileMode
+		edited        string
+		// want is the content after the undo, empty when the file is gone
+		want string
+	}{
+		{name: "modified", file: ".tmux.conf", before: "set -g mouse on\n", after: "set -g mouse off\n", mode: 0600, want: "set -g mouse on\n"},
+		{name: "deleted", file: ".config/old.toml", before: "theme = \"dark\"\n", want: "theme = \"dark\"\n"},
+		{name: "created", file: ".config/nvim/lua/plugins.lua", after: "return {}\n"},
+		{name: "unchanged", file: ".bashrc", before: "alias ll='ls -l'\n", after: "alias ll='ls -l'\n", want: "alias ll='ls -l'\n"},
+		{name: "changed since the apply", file: ".gitconfig", before: "[user]\n", after: "[user]\n\tname = a\n", edited: "[user]\n\tname = b\n", want: "[user]\n\tname = b\n"},
+	}
+	path := func(file string) string { return filepath.Join(home, filepath.FromSlash(file)) }
+	write := func(file, data string, mode os.FileMode) {
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := os.MkdirAll(filepath.Dir(path(file)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path(file), []byte(data), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path(file), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range tests {
+		if tt.before != "" {
+			write(tt.file, tt.before, tt.mode)
+		}
+	}
+	trail := newAuditTrail("apply", "")
+	trail.journal = true
+	for _, tt := range tests {
+		trail.watch(path(tt.file), tt.file)
+		if tt.after == "" {
+			os.Remove(path(tt.file))
+		} else {
+			write(tt.file, tt.after, 0644)
+		}
+	}
+	trail.flush()
+	for _, tt := range tests {
+		if tt.edited != "" {
+			write(tt.file, tt.edited, 0644)
+		}
+	}
+
+	run, records, _, err := lastApply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(tests)-1 {
+		t.Fatalf("apply %s recorded %d changes, want %d", run, len(records), len(tests)-1)
+	}
+	runUndo([]string{"--dry-run=false"})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(path(tt.file))
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("%s is still there after the undo", tt.file)
+				}
+				return
+			}
+			if err != nil || string(data) != tt.want {
+				t.Errorf("%s = %q, %v; want %q", tt.file, data, err, tt.want)
+			}
+			if info, err := os.Stat(path(tt.file)); err == nil && tt.mode != 0 && info.Mode().Perm() != tt.mode {
+				t.Errorf("%s has mode %v, want %v", tt.file, info.Mode().Perm(), tt.mode)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(home, ".config", "nvim")); err == nil {
+		t.Error("the directories the apply created are still there after the undo")
+	}
+	if _, _, undoneBy, err := lastApply(); err != nil || undoneBy == "" {
+		t.Errorf("the undo of %s was not recorded: %v", run, err)
+	}
+}
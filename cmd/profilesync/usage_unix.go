@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"runtime"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// processUsage reports the CPU time (user and system) used so far and the
+// peak resident memory in bytes
+func processUsage() (time.Duration, int64) {
+	var ru unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0
+	}
+	cpu := time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	// macOS reports ru_maxrss in bytes, everything else in kilobytes
+	peak := int64(ru.Maxrss)
+	if runtime.GOOS != "darwin" {
+		peak *= 1024
+	}
+	return cpu, peak
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats transformed configs are checked against before they are written
+const (
+	configJSON      = "JSON"
+	configYAML      = "YAML"
+	configINI       = "INI"
+	configGitconfig = "gitconfig"
+	configTOML      = "TOML"
+)
+
+// configFormat is the format of the config at path, or "" when it is not
+// one profilesync can check
+func configFormat(path string) string {
+	base := filepath.Base(path)
+	parent := filepath.Base(filepath.Dir(path))
+	switch {
+	case strings.EqualFold(filepath.Ext(base), ".json"):
+		return configJSON
+	case strings.EqualFold(filepath.Ext(base), ".yaml"), strings.EqualFold(filepath.Ext(base), ".yml"):
+		return configYAML
+	case strings.EqualFold(filepath.Ext(base), ".toml"):
+		return configTOML
+	case strings.EqualFold(filepath.Ext(base), ".ini"):
+		return configINI
+	case base == ".gitconfig", base == "config" && parent == "git":
+		return configGitconfig
+	case (base == "config" || base == "credentials") && parent == ".aws":
+		return configINI
+	case base == "config" && parent == ".kube":
+		return configYAML
+	}
+	return ""
+}
+
+// checkTransformed refuses the output of a transform that does not parse
+// as format, or as the format of path when format is "", so a broken
+// transform never replaces a working config. transform names it in the error
+func checkTransformed(format, path string, data []byte, transform string) error {
+	if format == "" {
+		format = configFormat(path)
+	}
+	if err := validateConfig(format, data); err != nil {
+		return fmt.Errorf("%s produced invalid %s for %s, so it was not written: %w", transform, format, path, err)
+	}
+	return nil
+}
+
+// validateConfig checks that data parses as format
+func validateConfig(format string, data []byte) error {
+	switch format {
+	case configJSON:
+		// VS Code and Windows Terminal allow comments and trailing commas
+		clean, _ := stripJSONC(data)
+		var v interface{}
+		if err := json.Unmarshal(clean, &v); err != nil {
+			var syntax *json.SyntaxError
+			if errors.As(err, &syntax) {
+				return fmt.Errorf("near line %d: %v", 1+bytes.Count(clean[:syntax.Offset], []byte("\n")), err)
+			}
+			return err
+		}
+	case configYAML:
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+	case configINI:
+		return validateINI(data)
+	case configGitconfig:
+		return validateGitconfig(data)
+	case configTOML:
+		_, err := parseTOML(data)
+		return err
+	}
+	return nil
+}
+
+// validateINI checks the key=value format of AWS and Firefox: every line is
+// a [section], a comment, a key with a value, or an indented line
+// continuing the key before it
+func validateINI(data []byte) error {
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, "\r \t")
+		line := strings.TrimSpace(raw)
+		switch {
+		case line == "", isINIComment(line):
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") || strings.TrimSpace(line[1:len(line)-1]) == "" {
+				return fmt.Errorf("line %d: bad section header %q", i+1, line)
+			}
+		case raw != line:
+			// Continues the key above, as in AWS's nested settings
+		default:
+			if key, _, ok := strings.Cut(line, "="); !ok || strings.TrimSpace(key) == "" {
+				return fmt.Errorf("line %d: expected key = value, found %q", i+1, line)
+			}
+		}
+	}
+	return nil
+}
+
+// gitSectionName and gitKeyName match the names git accepts
+var (
+	gitSectionName = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+	gitKeyName     = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+)
+
+// validateGitconfig checks a gitconfig the way git reads it, catching the
+// errors that make git refuse the whole file: bad section headers and key
+// names, unterminated quotes and unknown escapes
+func validateGitconfig(data []byte) error {
+	lines := strings.Split(string(data), "\n")
+	inSection := false
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(strings.TrimRight(lines[i], "\r"))
+		n := i + 1
+		if strings.HasPrefix(line, "[") {
+			end, err := gitSectionEnd(line)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", n, err)
+			}
+			inSection = true
+			// A key may follow the header on the same line
+			line = strings.TrimSpace(line[end+1:])
+		}
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if !inSection {
+			return fmt.Errorf("line %d: %q is outside a section", n, line)
+		}
+		key, value, hasValue := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !gitKeyName.MatchString(key) {
+			return fmt.Errorf("line %d: bad key name %q", n, key)
+		}
+		if !hasValue {
+			continue
+		}
+		// A value ending in a backslash continues on the next line
+		for {
+			cont, err := gitValue(value)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", n, err)
+			}
+			if !cont {
+				break
+			}
+			if i+1 >= len(lines) {
+				return fmt.Errorf("line %d: value continues past the end of the file", n)
+			}
+			i++
+			value = strings.TrimRight(lines[i], "\r")
+		}
+	}
+	return nil
+}
+
+// gitSectionEnd finds the ] closing the section header at the start of
+// line, checking the name and quoted subsection in between
+func gitSectionEnd(line string) (int, error) {
+	end := strings.IndexAny(line, ` "]`)
+	if end < 0 {
+		return 0, fmt.Errorf("unterminated section header %q", line)
+	}
+	name := line[1:end]
+	if line[end] == ' ' {
+		rest := strings.TrimLeft(line[end:], " ")
+		if !strings.HasPrefix(rest, `"`) {
+			return 0, fmt.Errorf("bad section header %q", line)
+		}
+		i := len(line) - len(rest) + 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' {
+				i++
+			}
+		}
+		if i+1 >= len(line) || line[i+1] != ']' {
+			return 0, fmt.Errorf("bad subsection in section header %q", line)
+		}
+		end = i + 1
+	} else if line[end] != ']' {
+		return 0, fmt.Errorf("bad section header %q", line)
+	}
+	if !gitSectionName.MatchString(name) {
+		return 0, fmt.Errorf("bad section name %q", name)
+	}
+	return end, nil
+}
+
+// gitValue checks one line of a value, reporting whether it continues on
+// the next line
+func gitValue(value string) (bool, error) {
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\':
+			if i+1 == len(value) {
+				return true, nil
+			}
+			i++
+			if !strings.ContainsRune(`"\ntb`, rune(value[i])) {
+				return false, fmt.Errorf(`unknown escape \%c`, value[i])
+			}
+		case (c == '#' || c == ';') && !quoted:
+			return false, nil
+		}
+	}
+	if quoted {
+		return false, errors.New("unterminated quote")
+	}
+	return false, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Kinds of content that need explicit approval before an untrusted source is applied
+const (
+	vetExecutable = "executable"
+	vetSetuid     = "setuid"
+	vetSetgid     = "setgid"
+	vetShellRC    = "shell-rc"
+	vetHook       = "hook-script"
+)
+
+// VetFinding describes a piece of untrusted content that could run code
+type VetFinding struct {
+	Path   string
+	Kind   string
+	Detail string
+}
+
+// shellRCFiles are sourced by interactive shells, so any line added to them runs on login
+var shellRCFiles = map[string]bool{
+	".bashrc":       true,
+	".bash_profile": true,
+	".bash_login":   true,
+	".profile":      true,
+	".zshrc":        true,
+	".zprofile":     true,
+	".zshenv":       true,
+	".zlogin":       true,
+	"config.fish":   true,
+}
+
+// gitHookNames are the client-side hooks git runs automatically
+var gitHookNames = map[string]bool{
+	"applypatch-msg":     true,
+	"pre-applypatch":     true,
+	"post-applypatch":    true,
+	"pre-commit":         true,
+	"prepare-commit-msg": true,
+	"commit-msg":         true,
+	"post-commit":        true,
+	"pre-rebase":         true,
+	"post-checkout":      true,
+	"post-merge":         true,
+	"pre-push":           true,
+	"post-rewrite":       true,
+}
+
+// vetPath inspects a source file or tree and reports content that could
+// execute code on the destination. destPath is used to work out which shell
+// rc lines are new
+func (ps *ProfileSync) vetPath(ctx context.Context, srcPath, destPath string) ([]VetFinding, error) {
+	info, err := os.Lstat(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return vetFile(srcPath, destPath, info), nil
+	}
+
+	var findings []VetFinding
+	var mu sync.Mutex
+	err = parallelWalk(ctx, srcPath, ps.jobs, func(path string, d fs.DirEntry) (bool, error) {
+		if d.IsDir() {
+			return true, nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return false, err
+		}
+		rel, err := filepath.Rel(srcPath, path)
+		if err != nil {
+			return false, err
+		}
+		dest := ""
+		if destPath != "" {
+			dest = filepath.Join(destPath, rel)
+		}
+		found := vetFile(path, dest, info)
+		mu.Lock()
+		findings = append(findings, found...)
+		mu.Unlock()
+		return false, nil
+	})
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Path != findings[j].Path {
+			return findings[i].Path < findings[j].Path
+		}
+		return findings[i].Kind < findings[j].Kind
+	})
+	return findings, err
+}
+
+// vetFile checks a single file
+func vetFile(path, destPath string, info fs.FileInfo) []VetFinding {
+	var findings []VetFinding
+	mode := info.Mode()
+	name := filepath.Base(path)
+
+	if mode&fs.ModeSetuid != 0 {
+		findings = append(findings, VetFinding{Path: path, Kind: vetSetuid, Detail: "runs with the owner's privileges"})
+	}
+	if mode&fs.ModeSetgid != 0 {
+		findings = append(findings, VetFinding{Path: path, Kind: vetSetgid, Detail: "runs with the group's privileges"})
+	}
+
+	if gitHookNames[name] && filepath.Base(filepath.Dir(path)) == "hooks" {
+		findings = append(findings, VetFinding{Path: path, Kind: vetHook, Detail: "git runs this automatically"})
+	} else if mode.IsRegular() && mode.Perm()&0111 != 0 {
+		findings = append(findings, VetFinding{Path: path, Kind: vetExecutable, Detail: fmt.Sprintf("mode %s", mode.Perm())})
+	}
+
+	if shellRCFiles[name] && mode.IsRegular() {
+		if added := addedLines(path, destPath); len(added) > 0 {
+			detail := fmt.Sprintf("%d new line(s), first: %q", len(added), added[0])
+			findings = append(findings, VetFinding{Path: path, Kind: vetShellRC, Detail: detail})
+		}
+	}
+
+	return findings
+}
+
+// addedLines lists meaningful lines in src that are not present in dst.
+// An empty dst means every line is new
+func addedLines(src, dst string) []string {
+	existing := map[string]bool{}
+	if data, err := os.ReadFile(dst); dst != "" && err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			existing[strings.TrimSpace(line)] = true
+		}
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return nil
+	}
+
+	var added []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || existing[line] {
+			continue
+		}
+		added = append(added, line)
+	}
+	return added
+}
+
+// vetPlan vets every planned item whose source exists and withholds items
+// with findings unless the user approves them. Items that are not approved
+// are marked as not auto-migrated. Answers are remembered until the
+// findings change
+func (ps *ProfileSync) vetPlan(ctx context.Context) error {
+	noticeColor.Println("🔍 Vetting untrusted source...")
+
+	interactive := ps.canPrompt()
+
+	for i := range ps.migrationPlan.Items {
+		item := &ps.migrationPlan.Items[i]
+		if _, err := os.Lstat(item.SourcePath); err != nil {
+			continue
+		}
+
+		findings, err := ps.vetPath(ctx, item.SourcePath, item.DestinationPath)
+		if err != nil {
+			return fmt.Errorf("vetting %s: %w", item.Description, err)
+		}
+		if len(findings) == 0 {
+			continue
+		}
+
+		warnColor.Printf("⚠️  %s needs approval:\n", item.Description)
+		printFindings(findings)
+
+		fingerprint := findingsFingerprint(findings)
+		if approved, ok := ps.recall(decisionUntrusted, item.Mapping, fingerprint); ok {
+			if !approved {
+				item.AutoMigrate = false
+				item.SkipReason = "not approved"
+			}
+			continue
+		}
+
+		switch {
+		case ps.dryRun:
+			warnColor.Println("   Would ask for approval before migrating")
+		case !interactive:
+			warnColor.Println("   Not approved (no terminal to ask on)")
+			item.AutoMigrate = false
+			item.SkipReason = "not approved"
+		default:
+			approved := confirm("   Migrate anyway?", false)
+			ps.remember(decisionUntrusted, item.Mapping, fingerprint, approved)
+			if !approved {
+				item.AutoMigrate = false
+				item.SkipReason = "not approved"
+			}
+		}
+	}
+
+	return nil
+}
+
+// printFindings lists vetting findings
+func printFindings(findings []VetFinding) {
+	for _, f := range findings {
+		color := warnColor
+		if f.Kind == vetSetuid || f.Kind == vetSetgid {
+			color = errorColor
+		}
+		color.Printf("   • [%s] %s: %s\n", f.Kind, f.Path, f.Detail)
+	}
+}
+
+// runVet reports content in a directory that would need approval when applied
+func runVet(args []string) {
+	flags := newFlagSet("vet", "vet [flags] DIR")
+	jobs := flags.Int("jobs", runtime.NumCPU(), "Number of parallel workers for scanning")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ps := NewProfileSync(DetectPlatform(), DetectPlatform(), true, false, false, false, false, *jobs)
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	root := flags.Arg(0)
+	findings, err := ps.vetPath(ctx, root, "")
+	if err != nil {
+		errorColor.Println("❌ Error vetting:", err)
+		os.Exit(1)
+	}
+
+	if len(findings) == 0 {
+		successColor.Printf("✅ Nothing in %s needs approval\n", root)
+		return
+	}
+
+	warnColor.Printf("⚠️  %d finding(s) in %s:\n", len(findings), root)
+	printFindings(findings)
+	os.Exit(1)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// vscodeVariants are the editor builds sharing the VS Code extension CLI
+var vscodeVariants = []struct {
+	Name   string
+	Binary string
+}{
+	{"VS Code", "code"},
+	{"VS Code Insiders", "code-insiders"},
+	{"VSCodium", "codium"},
+}
+
+// ExtensionManifest records installed extensions per editor variant
+type ExtensionManifest struct {
+	CapturedAt time.Time           `json:"captured_at"`
+	Variants   map[string][]string `json:"variants"`
+}
+
+// listExtensions returns the extension IDs installed for a variant
+func listExtensions(binary string) ([]string, error) {
+	lines, err := commandLines(binary, "--list-extensions")
+	if err != nil {
+		return nil, err
+	}
+	for i := range lines {
+		lines[i] = strings.ToLower(lines[i])
+	}
+	sort.Strings(lines)
+	return lines, nil
+}
+
+// CaptureExtensions saves the extension lists of every installed VS Code
+// variant into dir/vscode
+func CaptureExtensions(dir string) (*ExtensionManifest, error) {
+	manifest := &ExtensionManifest{
+		CapturedAt: time.Now().UTC(),
+		Variants:   map[string][]string{},
+	}
+
+	outDir := filepath.Join(dir, "vscode")
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+
+	for _, v := range vscodeVariants {
+		if _, err := exec.LookPath(v.Binary); err != nil {
+			continue
+		}
+		exts, err := listExtensions(v.Binary)
+		if err != nil {
+			warnColor.Printf("⚠️  Could not list %s extensions: %v\n", v.Name, err)
+			continue
+		}
+		manifest.Variants[v.Binary] = exts
+
+		// Plain list, usable with `xargs -n1 code --install-extension`
+		list := strings.Join(exts, "\n") + "\n"
+		if err := os.WriteFile(filepath.Join(outDir, "extensions-"+v.Binary+".txt"), []byte(list), 0644); err != nil {
+			return nil, err
+		}
+		successColor.Printf("✅ Captured %d %s extensions\n", len(exts), v.Name)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return manifest, os.WriteFile(filepath.Join(outDir, "extensions.json"), data, 0644)
+}
+
+// loadExtensionManifest reads the manifest written by CaptureExtensions
+func loadExtensionManifest(dir string) (*ExtensionManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "vscode", "extensions.json"))
+	if err != nil {
+		return nil, err
+	}
+	var manifest ExtensionManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// InstallExtensions installs captured extensions that are missing on this
+// machine. Extensions captured from a variant that is not installed here go
+// to the first variant that is, so a VS Code profile can seed VSCodium
+func InstallExtensions(manifest *ExtensionManifest, dryRun bool) error {
+	var installed []string
+	for _, v := range vscodeVariants {
+		if _, err := exec.LookPath(v.Binary); err == nil {
+			installed = append(installed, v.Binary)
+		}
+	}
+	if len(installed) == 0 {
+		return fmt.Errorf("no VS Code variant found on PATH (looked for code, code-insiders, codium)")
+	}
+
+	wanted := map[string][]string{}
+	for variant, exts := range manifest.Variants {
+		target := installed[0]
+		for _, b := range installed {
+			if b == variant {
+				target = b
+			}
+		}
+		wanted[target] = append(wanted[target], exts...)
+	}
+
+	failed := 0
+	for _, binary := range installed {
+		exts := dedupe(wanted[binary])
+		if len(exts) == 0 {
+			continue
+		}
+
+		present := map[string]bool{}
+		current, err := listExtensions(binary)
+		if err != nil {
+			return fmt.Errorf("listing %s extensions: %w", binary, err)
+		}
+		for _, ext := range current {
+			present[ext] = true
+		}
+
+		for _, ext := range exts {
+			if present[strings.ToLower(ext)] {
+				continue
+			}
+			if dryRun {
+				noticeColor.Printf("🧩 Would run: %s --install-extension %s\n", binary, ext)
+				continue
+			}
+			noticeColor.Printf("🧩 Installing %s into %s\n", ext, binary)
+			if out, err := exec.Command(binary, "--install-extension", ext).CombinedOutput(); err != nil {
+				// Marketplace-only extensions are often unavailable on Open VSX (VSCodium)
+				errorColor.Printf("❌ %s: %v\n%s", ext, err, out)
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d extension(s) failed to install", failed)
+	}
+	return nil
+}
+
+// runCaptureExtensions handles `profilesync capture extensions`
+func runCaptureExtensions(args []string) {
+	flags := newFlagSet("capture extensions", "capture extensions [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to write to")
+	flags.Parse(args)
+
+	manifest, err := CaptureExtensions(*dir)
+	if err != nil {
+		errorColor.Println("❌ Error capturing extensions:", err)
+		os.Exit(1)
+	}
+	if len(manifest.Variants) == 0 {
+		warnColor.Println("⚠️  No VS Code variant found on PATH")
+		return
+	}
+	successColor.Printf("✅ Extension lists saved to %s\n", filepath.Join(*dir, "vscode"))
+}
+
+// runApplyExtensions handles `profilesync apply extensions`
+func runApplyExtensions(args []string) {
+	flags := newFlagSet("apply extensions", "apply extensions [flags]")
+	dir := flags.String("profile-dir", profileDir(), "Profile store to read from")
+	dryRun := flags.Bool("dry-run", true, "Show install commands without running them")
+	flags.Parse(args)
+
+	manifest, err := loadExtensionManifest(*dir)
+	if err != nil {
+		errorColor.Println("❌ Error reading extension list (run `profilesync capture extensions` first):", err)
+		os.Exit(1)
+	}
+
+	if err := InstallExtensions(manifest, *dryRun); err != nil {
+		errorColor.Println("❌ Error installing extensions:", err)
+		os.Exit(1)
+	}
+	if *dryRun {
+		warnColor.Println("⚠️  This was a DRY RUN. Run with --dry-run=false to install.")
+	} else {
+		successColor.Println("✅ Extensions up to date")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// walkFunc is called for every entry below the walk root. It runs
+// concurrently from several goroutines and returns whether a directory
+// entry should be descended into
+type walkFunc func(path string, d fs.DirEntry) (descend bool, err error)
+
+// parallelWalker reads directories concurrently, bounding the number of
+// directories open at once
+type parallelWalker struct {
+	ctx  context.Context
+	fn   walkFunc
+	sem  chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs []error
+}
+
+// parallelWalk walks the tree below root with at most workers concurrent
+// directory reads. Unlike filepath.Walk, entries are visited in no particular
+// order, the root itself is not passed to fn, and errors are collected
+// rather than aborting the walk. Cancelling ctx stops further directory reads
+func parallelWalk(ctx context.Context, root string, workers int, fn walkFunc) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	w := &parallelWalker{
+		ctx: ctx,
+		fn:  fn,
+		sem: make(chan struct{}, workers),
+	}
+
+	w.wg.Add(1)
+	go w.walkDir(root)
+	w.wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(w.errs...)
+}
+
+// walkDir visits the entries of dir and schedules its subdirectories
+func (w *parallelWalker) walkDir(dir string) {
+	defer w.wg.Done()
+
+	if w.ctx.Err() != nil {
+		return
+	}
+
+	w.sem <- struct{}{}
+	entries, err := os.ReadDir(dir)
+	<-w.sem
+	if err != nil {
+		w.fail(fmt.Errorf("reading %s: %w", dir, err))
+		return
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		descend, err := w.fn(path, entry)
+		if err != nil {
+			w.fail(err)
+			continue
+		}
+		// fn may descend into a symlinked directory it follows
+		if descend {
+			w.wg.Add(1)
+			go w.walkDir(path)
+		}
+	}
+}
+
+// fail records a walk error
+func (w *parallelWalker) fail(err error) {
+	w.mu.Lock()
+	w.errs = append(w.errs, err)
+	w.mu.Unlock()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// What to do with a name a Windows destination cannot hold
+const (
+	windowsNamesEscape = "escape"
+	windowsNamesSkip   = "skip"
+)
+
+// reservedWindowsNames are device names Windows reserves in every
+// directory, with or without an extension
+var reservedWindowsNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// windowsNameProblem returns why a file name cannot be created on Windows,
+// or "" if it can
+func windowsNameProblem(name string) string {
+	stem, _, _ := strings.Cut(name, ".")
+	if reservedWindowsNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		return "reserved device name"
+	}
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(`<>:"|?*\`, r) {
+			return fmt.Sprintf("contains %q", r)
+		}
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return "ends with a dot or space"
+	}
+	return ""
+}
+
+// escapeWindowsName makes a name Windows can create by writing the
+// characters it cannot hold as %XX, and the last character of a reserved
+// device name's stem, so aux.el becomes au%78.el
+func escapeWindowsName(name string) string {
+	var b strings.Builder
+	stem, rest, dotted := strings.Cut(name, ".")
+	if reservedWindowsNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		b.WriteString(stem[:len(stem)-1])
+		fmt.Fprintf(&b, "%%%02X", stem[len(stem)-1])
+		if dotted {
+			b.WriteString("." + rest)
+		}
+		name, b = b.String(), strings.Builder{}
+	}
+	trailing := len(name) - len(strings.TrimRight(name, ". "))
+	for i, r := range name {
+		if r < 0x20 || strings.ContainsRune(`<>:"|?*\`, r) || i >= len(name)-trailing {
+			fmt.Fprintf(&b, "%%%02X", r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// windowsRel escapes each element of rel, a path below a mapped directory,
+// that Windows cannot create, returning the new path and the first
+// problem found
+func windowsRel(rel string) (string, string) {
+	parts := strings.Split(rel, string(filepath.Separator))
+	problem := ""
+	for i, part := range parts {
+		if p := windowsNameProblem(part); p != "" {
+			if problem == "" {
+				problem = p
+			}
+			parts[i] = escapeWindowsName(part)
+		}
+	}
+	return strings.Join(parts, string(filepath.Separator)), problem
+}
+
+// caseInsensitive reports whether the filesystem holding dir, which must
+// exist, treats names differing only in case as the same, by looking up
+// the nearest element of dir that has letters with their case swapped
+func caseInsensitive(dir, platform string) bool {
+	dir = filepath.Clean(dir)
+	for d := dir; filepath.Dir(d) != d; d = filepath.Dir(d) {
+		base := filepath.Base(d)
+		swapped := strings.Map(func(r rune) rune {
+			if unicode.IsUpper(r) {
+				return unicode.ToLower(r)
+			}
+			return unicode.ToUpper(r)
+		}, base)
+		if swapped == base {
+			continue
+		}
+		info, err := os.Stat(d)
+		if err != nil {
+			break
+		}
+		other, err := os.Stat(filepath.Join(filepath.Dir(d), swapped))
+		return err == nil && os.SameFile(info, other)
+	}
+	// Nothing to probe with; go by the platform's default
+	return platform == "windows" || platform == "macos"
+}
+
+// dropCaseCollisions leaves out jobs whose destination differs only in case
+// from that of an earlier job, which a case-insensitive destination would
+// write to the same file
+func dropCaseCollisions(jobs []copyJob) []copyJob {
+	seen := map[string]string{}
+	kept := jobs[:0]
+	for _, job := range jobs {
+		key := strings.ToLower(job.dst)
+		if first, ok := seen[key]; ok {
+			warnColor.Printf("⚠️  Skipped %s: the destination does not tell its name apart from %s\n", job.src, first)
+			continue
+		}
+		seen[key] = job.src
+		kept = append(kept, job)
+	}
+	return kept
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// platformWSL is the Linux side of Windows Subsystem for Linux. Its files
+// are laid out as on Linux; what sets it apart is the Windows home beside it
+const platformWSL = "wsl"
+
+// crlfLimit is the largest text file whose line endings are converted
+const crlfLimit = 1 << 20
+
+// inWSL reports whether this process runs under WSL
+func inWSL() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	return err == nil && strings.Contains(strings.ToLower(string(release)), "microsoft")
+}
+
+// layoutPlatform is the platform whose paths and tools a platform uses
+func layoutPlatform(platform string) string {
+	if platform == platformWSL {
+		return "linux"
+	}
+	return platform
+}
+
+// wslWindowsHome returns the Windows user's home directory as WSL sees it,
+// e.g. /mnt/c/Users/me
+func wslWindowsHome() string {
+	// USERPROFILE is only set when shared through WSLENV
+	profile := os.Getenv("USERPROFILE")
+	if profile == "" {
+		cmd := exec.Command("cmd.exe", "/c", "echo %USERPROFILE%")
+		// cmd.exe complains about starting in a Linux directory
+		cmd.Dir = "/mnt/c"
+		if out, err := cmd.Output(); err == nil {
+			profile = strings.TrimSpace(string(out))
+		}
+	}
+	if strings.HasPrefix(profile, "/") {
+		return profile
+	}
+	if profile != "" && !strings.Contains(profile, "%") {
+		if out, err := exec.Command("wslpath", "-u", profile).Output(); err == nil {
+			return strings.TrimSpace(string(out))
+		}
+	}
+	return filepath.Join("/mnt/c/Users", os.Getenv("USER"))
+}
+
+// isDrvFs reports whether path is on a Windows drive mounted into WSL.
+// DrvFs shows as drvfs on WSL 1 and as 9p with aname=drvfs on WSL 2
+func isDrvFs(path string) bool {
+	data, err := os.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return false
+	}
+	path = filepath.Clean(path)
+	best, drvfs := "", false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		// Spaces in mount points are escaped as \040
+		mount := strings.ReplaceAll(fields[1], `\040`, " ")
+		if !withinRoot(mount, path) || len(mount) < len(best) {
+			continue
+		}
+		best = mount
+		drvfs = fields[2] == "drvfs" || (fields[2] == "9p" && strings.Contains(fields[3], "aname=drvfs"))
+	}
+	return drvfs
+}
+
+// fileMode is the mode a copy of path gets. Files on DrvFs all look
+// world-writable and executable, so copies from there get the usual modes
+// instead, kept private in credential directories
+func (ps *ProfileSync) fileMode(path string, mode fs.FileMode) fs.FileMode {
+	if !ps.sourceDrvFs {
+		return mode
+	}
+	private := isSensitivePath(path)
+	switch {
+	case mode.IsDir() && private:
+		return fs.ModeDir | 0700
+	case mode.IsDir():
+		return fs.ModeDir | 0755
+	case private:
+		return 0600
+	default:
+		return 0644
+	}
+}
+
+// lfText returns the contents of a text file with CRLF line endings
+// converted to LF, and false for binary files, large files and files
+// without CRLF
+func lfText(path string, info fs.FileInfo) ([]byte, bool) {
+	if info.Size() > crlfLimit {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(data, 0) >= 0 || !bytes.Contains(data, []byte("\r\n")) {
+		return nil, false
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), true
+}
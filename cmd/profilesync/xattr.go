@@ -0,0 +1,21 @@
+package main
+
+import "strings"
+
+// skipXattr reports whether an extended attribute is left behind: the
+// quarantine flag macOS puts on downloads, and labels the destination's own
+// security policy assigns
+func skipXattr(name string) bool {
+	return name == "com.apple.quarantine" || strings.HasPrefix(name, "security.")
+}
+
+// copyMetadata copies the extended attributes of src to dst, warning about
+// any it could not copy rather than failing the copy
+func (ps *ProfileSync) copyMetadata(src, dst, shown string) {
+	if !ps.xattrs {
+		return
+	}
+	if err := copyXattrs(src, dst); err != nil {
+		warnColor.Printf("⚠️  Could not copy extended attributes of %s: %v\n", shown, err)
+	}
+}
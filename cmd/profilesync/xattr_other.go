@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// copyXattrs does nothing where extended attributes are not supported
+func copyXattrs(src, dst string) error {
+	return nil
+}
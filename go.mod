@@ -2,10 +2,15 @@ module profilesync
 
 go 1.21
 
-require github.com/fatih/color v1.16.0
-
 require (
-	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
-	golang.org/x/sys v0.15.0 // indirect
+	github.com/BurntSushi/toml v1.5.0
+	github.com/fatih/color v1.16.0
+	github.com/mattn/go-isatty v0.0.20
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
+	golang.org/x/crypto v0.17.0
+	golang.org/x/net v0.19.0
+	golang.org/x/sys v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/mattn/go-colorable v0.1.13 // indirect
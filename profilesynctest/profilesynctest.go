@@ -0,0 +1,187 @@
+// Package profilesynctest provides helpers for tests that build and check
+// directory trees such as home directories: an in-memory filesystem builder
+// that can be materialized into a temporary directory, a deterministic tree
+// snapshot, and golden-file comparison. profilesync's plan and apply tests
+// build their source homes with it and compare the homes apply writes with
+// golden files.
+package profilesynctest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// UpdateEnv names the environment variable that, set to 1, makes Golden
+// rewrite golden files instead of comparing against them. An environment
+// variable rather than a flag works for go test ./... across packages
+// that do not use this one
+const UpdateEnv = "PROFILESYNC_UPDATE_GOLDEN"
+
+// FS builds an in-memory file tree
+type FS struct {
+	files fstest.MapFS
+}
+
+// NewFS creates an empty in-memory file tree
+func NewFS() *FS {
+	return &FS{files: fstest.MapFS{}}
+}
+
+// File adds a regular file with mode 0644
+func (f *FS) File(name, data string) *FS {
+	return f.FileMode(name, data, 0644)
+}
+
+// FileMode adds a regular file with the given permissions
+func (f *FS) FileMode(name, data string, mode fs.FileMode) *FS {
+	f.files[clean(name)] = &fstest.MapFile{Data: []byte(data), Mode: mode.Perm()}
+	return f
+}
+
+// Dir adds an empty directory
+func (f *FS) Dir(name string) *FS {
+	f.files[clean(name)] = &fstest.MapFile{Mode: fs.ModeDir | 0755}
+	return f
+}
+
+// MapFS returns the underlying fs.FS view of the tree
+func (f *FS) MapFS() fstest.MapFS {
+	return f.files
+}
+
+// Materialize writes the tree into a fresh temporary directory and returns its path
+func (f *FS) Materialize(t testing.TB) string {
+	t.Helper()
+	root := t.TempDir()
+	f.WriteTo(t, root)
+	return root
+}
+
+// WriteTo writes the tree below root, creating parent directories as needed
+func (f *FS) WriteTo(t testing.TB, root string) {
+	t.Helper()
+	names := make([]string, 0, len(f.files))
+	for name := range f.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		file := f.files[name]
+		target := filepath.Join(root, filepath.FromSlash(name))
+		if file.Mode.IsDir() {
+			if err := os.MkdirAll(target, file.Mode.Perm()); err != nil {
+				t.Fatalf("profilesynctest: create dir %s: %v", name, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			t.Fatalf("profilesynctest: create parent of %s: %v", name, err)
+		}
+		if err := os.WriteFile(target, file.Data, file.Mode.Perm()); err != nil {
+			t.Fatalf("profilesynctest: write %s: %v", name, err)
+		}
+		// WriteFile is subject to umask; apply the requested mode explicitly
+		if err := os.Chmod(target, file.Mode.Perm()); err != nil {
+			t.Fatalf("profilesynctest: chmod %s: %v", name, err)
+		}
+	}
+}
+
+// Snapshot renders the tree below root as a sorted, line-oriented listing of
+// paths, permissions and content hashes, suitable for golden comparison
+func Snapshot(t testing.TB, root string) string {
+	t.Helper()
+	var lines []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			lines = append(lines, fmt.Sprintf("%s/ %s", rel, info.Mode().Perm()))
+		case info.Mode()&fs.ModeSymlink != 0:
+			dest, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			lines = append(lines, fmt.Sprintf("%s -> %s", rel, filepath.ToSlash(dest)))
+		default:
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data)
+			lines = append(lines, fmt.Sprintf("%s %s %s", rel, info.Mode().Perm(), hex.EncodeToString(sum[:8])))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("profilesynctest: snapshot %s: %v", root, err)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Golden compares got against testdata/<name>.golden, rewriting the file
+// instead when UpdateEnv is set to 1
+func Golden(t testing.TB, name string, got []byte) {
+	t.Helper()
+	golden := filepath.Join("testdata", name+".golden")
+
+	if os.Getenv(UpdateEnv) == "1" {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatalf("profilesynctest: create testdata: %v", err)
+		}
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatalf("profilesynctest: update %s: %v", golden, err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("profilesynctest: read %s: %v (run with %s=1 to create it)", golden, err, UpdateEnv)
+	}
+	if !bytes.Equal(normalizeNewlines(want), normalizeNewlines(got)) {
+		t.Errorf("profilesynctest: %s mismatch (run with %s=1 to accept)\n--- want\n%s\n--- got\n%s", golden, UpdateEnv, want, got)
+	}
+}
+
+// GoldenString is Golden for string output
+func GoldenString(t testing.TB, name, got string) {
+	t.Helper()
+	Golden(t, name, []byte(got))
+}
+
+// clean normalizes a builder path to the slash-separated relative form fs.FS expects
+func clean(name string) string {
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+}
+
+// normalizeNewlines lets golden files checked out with CRLF endings compare equal
+func normalizeNewlines(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+}
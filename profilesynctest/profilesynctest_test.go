@@ -0,0 +1,97 @@
+package profilesynctest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMaterializeSnapshot(t *testing.T) {
+	home := NewFS().
+		File(".bashrc", "export EDITOR=vim\n").
+		FileMode("/.ssh/id_ed25519", "secret", 0600).
+		Dir(".config/empty").
+		Materialize(t)
+
+	data, err := os.ReadFile(filepath.Join(home, ".bashrc"))
+	if err != nil || string(data) != "export EDITOR=vim\n" {
+		t.Fatalf(".bashrc = %q, %v", data, err)
+	}
+	info, err := os.Stat(filepath.Join(home, ".ssh", "id_ed25519"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("id_ed25519 mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+	if err := os.Symlink(".bashrc", filepath.Join(home, ".profile")); err != nil {
+		t.Fatal(err)
+	}
+
+	GoldenString(t, "snapshot", Snapshot(t, home))
+}
+
+func TestMapFS(t *testing.T) {
+	files := NewFS().File("a/b.txt", "b").Dir("c").MapFS()
+	if err := fstest.TestFS(files, "a/b.txt", "c"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{".bashrc", ".bashrc"},
+		{"/.ssh/config", ".ssh/config"},
+		{"a//b/../c", "a/c"},
+		{`a\b`, `a\b`},
+	}
+	for _, tt := range tests {
+		if got := clean(tt.in); got != tt.want {
+			t.Errorf("clean(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGoldenUpdate(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	t.Setenv(UpdateEnv, "1")
+	GoldenString(t, "out", "one\n")
+	data, err := os.ReadFile(filepath.Join(dir, "testdata", "out.golden"))
+	if err != nil || string(data) != "one\n" {
+		t.Fatalf("golden file = %q, %v", data, err)
+	}
+
+	// Compared, a golden file checked out with CRLF endings still matches
+	t.Setenv(UpdateEnv, "")
+	if err := os.WriteFile(filepath.Join(dir, "testdata", "out.golden"), []byte("one\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	GoldenString(t, "out", "one\n")
+}
+
+func TestGoldenMismatch(t *testing.T) {
+	rec := &recorder{TB: t}
+	Golden(rec, "snapshot", []byte("something else\n"))
+	if !strings.Contains(rec.failure, "mismatch") || !strings.Contains(rec.failure, UpdateEnv) {
+		t.Errorf("failure = %q, want a mismatch naming %s", rec.failure, UpdateEnv)
+	}
+}
+
+// recorder notes a failure instead of failing the test
+type recorder struct {
+	testing.TB
+	failure string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failure = fmt.Sprintf(format, args...)
+}